@@ -0,0 +1,113 @@
+// Package accesslog writes a structured entry for every HTTP request, and the record of every other flow, proxied to
+// an origin, to sinks such as files, syslog or an in-memory ring.
+package accesslog
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	cfdflow "github.com/cloudflare/cloudflared/flow"
+)
+
+var writeErrors = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "cloudflared",
+		Subsystem: "access_log",
+		Name:      "write_errors_total",
+		Help:      "Total count of access log entries that couldn't be written, by sink",
+	},
+	[]string{"sink"},
+)
+
+func init() {
+	prometheus.MustRegister(writeErrors)
+}
+
+// Entry describes a request proxied to an origin.
+type Entry struct {
+	Time     time.Time     `json:"time"`
+	Method   string        `json:"method"`
+	Host     string        `json:"host"`
+	Path     string        `json:"path"`
+	Status   int           `json:"status"`
+	Duration time.Duration `json:"duration"`
+	// Bytes is the size of the response body sent to the eyeball.
+	Bytes     int64  `json:"bytes"`
+	EyeballIP string `json:"eyeballIP,omitempty"`
+	CfRay     string `json:"cfRay,omitempty"`
+	// Rule is the index of the ingress rule that matched the request. Internal rules have negative indexes.
+	Rule      int   `json:"rule"`
+	ConnIndex uint8 `json:"connIndex"`
+	// Flow is set for the entries of flows other than HTTP requests, such as UDP sessions.
+	Flow *cfdflow.Record `json:"flow,omitempty"`
+}
+
+// Sink receives the access log entries. Writes happen on the path of the requests, so they must not block.
+type Sink interface {
+	Name() string
+	Write(entry Entry) error
+	Close() error
+}
+
+// Logger writes the entries to all its sinks. A nil Logger discards them.
+type Logger struct {
+	sinks []Sink
+	ring  *Ring
+}
+
+// New returns a Logger writing to sinks, or nil if there is none.
+func New(sinks ...Sink) *Logger {
+	if len(sinks) == 0 {
+		return nil
+	}
+	logger := &Logger{sinks: sinks}
+	for _, sink := range sinks {
+		if ring, ok := sink.(*Ring); ok {
+			logger.ring = ring
+		}
+	}
+	return logger
+}
+
+func (l *Logger) Log(entry Entry) {
+	if l == nil {
+		return
+	}
+	for _, sink := range l.sinks {
+		if err := sink.Write(entry); err != nil {
+			writeErrors.WithLabelValues(sink.Name()).Inc()
+		}
+	}
+}
+
+// LogFlow writes the entry of a closed flow. It can be used as a flow recorder.
+func (l *Logger) LogFlow(record cfdflow.Record) {
+	l.Log(Entry{
+		Time:      record.Start,
+		Duration:  record.Duration,
+		ConnIndex: record.ConnIndex,
+		Flow:      &record,
+	})
+}
+
+// Ring returns the in-memory sink of the logger, if any.
+func (l *Logger) Ring() *Ring {
+	if l == nil {
+		return nil
+	}
+	return l.ring
+}
+
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	var firstErr error
+	for _, sink := range l.sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
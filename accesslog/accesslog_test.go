@@ -0,0 +1,104 @@
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfdflow "github.com/cloudflare/cloudflared/flow"
+)
+
+func TestRing(t *testing.T) {
+	ring := NewRing(3)
+	assert.Empty(t, ring.Entries())
+	for i := 0; i < 5; i++ {
+		require.NoError(t, ring.Write(Entry{Status: 200 + i}))
+	}
+	entries := ring.Entries()
+	require.Len(t, entries, 3)
+	for i, entry := range entries {
+		assert.Equal(t, 202+i, entry.Status)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "access.log")
+	sink, err := NewFileSink(FileConfig{Path: path})
+	require.NoError(t, err)
+	logger := New(sink)
+	logger.Log(Entry{Method: "GET", Host: "example.com", Path: "/", Status: 200})
+	logger.Log(Entry{Method: "POST", Host: "example.com", Path: "/upload", Status: 413})
+	require.NoError(t, logger.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	require.Len(t, entries, 2)
+	assert.Equal(t, "POST", entries[1].Method)
+	assert.Equal(t, 413, entries[1].Status)
+}
+
+type failingSink struct{}
+
+func (failingSink) Name() string      { return "failing" }
+func (failingSink) Write(Entry) error { return errors.New("sink is full") }
+func (failingSink) Close() error      { return nil }
+
+func TestLoggerWritesToAllSinks(t *testing.T) {
+	ring := NewRing(1)
+	logger := New(failingSink{}, ring)
+	assert.Same(t, ring, logger.Ring())
+
+	// Errors of a sink don't prevent the other sinks from getting the entry
+	logger.Log(Entry{Status: 200})
+	assert.Len(t, ring.Entries(), 1)
+}
+
+func TestNilLogger(t *testing.T) {
+	logger := New()
+	assert.Nil(t, logger)
+	logger.Log(Entry{Status: 200})
+	assert.Nil(t, logger.Ring())
+	assert.NoError(t, logger.Close())
+}
+
+func TestLogFlow(t *testing.T) {
+	ring := NewRing(1)
+	logger := New(ring)
+	start := time.Now()
+	logger.LogFlow(cfdflow.Record{
+		Kind:          cfdflow.KindUDP,
+		Dst:           "10.0.0.1:53",
+		Start:         start,
+		Duration:      time.Second,
+		BytesToOrigin: 42,
+		CloseReason:   cfdflow.CloseIdle,
+		ConnIndex:     2,
+	})
+	entries := ring.Entries()
+	require.Len(t, entries, 1)
+	assert.Equal(t, start, entries[0].Time)
+	assert.Equal(t, uint8(2), entries[0].ConnIndex)
+	require.NotNil(t, entries[0].Flow)
+	assert.Equal(t, "10.0.0.1:53", entries[0].Flow.Dst)
+	assert.Equal(t, uint64(42), entries[0].Flow.BytesToOrigin)
+
+	// HTTP entries don't have a flow record
+	message, err := json.Marshal(Entry{Status: 200})
+	require.NoError(t, err)
+	assert.NotContains(t, string(message), "flow")
+}
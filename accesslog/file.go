@@ -0,0 +1,59 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// FileConfig configures the file access logs are written to, as JSON lines.
+type FileConfig struct {
+	Path string
+	// MaxSize is the size in megabytes of the file before it's rotated. 0 uses the default of 100MB.
+	MaxSize int
+	// MaxBackups is the number of rotated files kept. 0 keeps all of them.
+	MaxBackups int
+	// MaxAge is the number of days rotated files are kept. 0 keeps them regardless of their age.
+	MaxAge int
+}
+
+type fileSink struct {
+	lock    sync.Mutex
+	writer  *lumberjack.Logger
+	encoder *json.Encoder
+}
+
+func NewFileSink(config FileConfig) (Sink, error) {
+	if dir := filepath.Dir(config.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0744); err != nil {
+			return nil, fmt.Errorf("unable to create the directory of the access log: %w", err)
+		}
+	}
+	writer := &lumberjack.Logger{
+		Filename:   config.Path,
+		MaxSize:    config.MaxSize,
+		MaxBackups: config.MaxBackups,
+		MaxAge:     config.MaxAge,
+	}
+	return &fileSink{writer: writer, encoder: json.NewEncoder(writer)}, nil
+}
+
+func (s *fileSink) Name() string {
+	return "file"
+}
+
+func (s *fileSink) Write(entry Entry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.encoder.Encode(entry)
+}
+
+func (s *fileSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.writer.Close()
+}
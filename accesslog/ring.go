@@ -0,0 +1,49 @@
+package accesslog
+
+import "sync"
+
+// Ring keeps the last entries in memory, to be read from the management service.
+type Ring struct {
+	lock    sync.Mutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+func NewRing(size int) *Ring {
+	return &Ring{entries: make([]Entry, size)}
+}
+
+func (r *Ring) Name() string {
+	return "ring"
+}
+
+func (r *Ring) Write(entry Entry) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.entries) == 0 {
+		return nil
+	}
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+	return nil
+}
+
+func (r *Ring) Close() error {
+	return nil
+}
+
+// Entries returns the entries held by the ring, from the oldest to the newest.
+func (r *Ring) Entries() []Entry {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.full {
+		return append([]Entry(nil), r.entries[:r.next]...)
+	}
+	entries := make([]Entry, 0, len(r.entries))
+	entries = append(entries, r.entries[r.next:]...)
+	return append(entries, r.entries[:r.next]...)
+}
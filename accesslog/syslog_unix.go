@@ -0,0 +1,37 @@
+//go:build !windows && !plan9
+
+package accesslog
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogSink writes the entries, as JSON, to the local syslog daemon with the daemon facility.
+func NewSyslogSink(tag string) (Sink, error) {
+	writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+func (s *syslogSink) Name() string {
+	return "syslog"
+}
+
+func (s *syslogSink) Write(entry Entry) error {
+	message, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.writer.Info(string(message))
+}
+
+func (s *syslogSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package accesslog
+
+import "errors"
+
+// NewSyslogSink isn't supported on Windows, which doesn't have a syslog daemon.
+func NewSyslogSink(_ string) (Sink, error) {
+	return nil, errors.New("syslog access logs are not supported on Windows")
+}
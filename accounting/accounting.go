@@ -0,0 +1,237 @@
+// Package accounting keeps cumulative usage counters of a tunnel, persisted periodically to a store, with optional
+// soft quotas that warn or throttle once exceeded in a period.
+package accounting
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/accesslog"
+)
+
+const (
+	defaultPersistInterval = time.Minute
+)
+
+// Period is how often the usage counters are reset.
+type Period string
+
+const (
+	// PeriodNone never resets the usage
+	PeriodNone  Period = ""
+	PeriodDay   Period = "day"
+	PeriodMonth Period = "month"
+)
+
+// ParsePeriod parses the name of a period.
+func ParsePeriod(s string) (Period, error) {
+	switch period := Period(s); period {
+	case PeriodNone, PeriodDay, PeriodMonth:
+		return period, nil
+	default:
+		return PeriodNone, fmt.Errorf("unknown accounting period %q, expected day or month", s)
+	}
+}
+
+// start returns the start of the period that now is in.
+func (p Period) start(now time.Time) time.Time {
+	now = now.UTC()
+	switch p {
+	case PeriodDay:
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	case PeriodMonth:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Time{}
+	}
+}
+
+// QuotaAction is what happens once a quota is exceeded.
+type QuotaAction string
+
+const (
+	QuotaWarn     QuotaAction = "warn"
+	QuotaThrottle QuotaAction = "throttle"
+)
+
+// ParseQuotaAction parses the name of a quota action.
+func ParseQuotaAction(s string) (QuotaAction, error) {
+	switch action := QuotaAction(s); action {
+	case QuotaWarn, QuotaThrottle:
+		return action, nil
+	default:
+		return "", fmt.Errorf("unknown quota action %q, expected warn or throttle", s)
+	}
+}
+
+// Quota is a soft limit of the usage in a period. Zero values are unlimited.
+type Quota struct {
+	// Bytes limits the bytes proxied in both directions
+	Bytes uint64
+	// Requests limits the HTTP requests and the TCP and UDP flows
+	Requests uint64
+	Action   QuotaAction
+}
+
+// Usage is the usage of a tunnel in a period.
+type Usage struct {
+	PeriodStart     time.Time `json:"periodStart"`
+	Requests        uint64    `json:"requests"`
+	Flows           uint64    `json:"flows"`
+	BytesToOrigin   uint64    `json:"bytesToOrigin"`
+	BytesFromOrigin uint64    `json:"bytesFromOrigin"`
+}
+
+func (u Usage) exceeds(quota Quota) bool {
+	if quota.Bytes > 0 && u.BytesToOrigin+u.BytesFromOrigin >= quota.Bytes {
+		return true
+	}
+	return quota.Requests > 0 && u.Requests+u.Flows >= quota.Requests
+}
+
+type Config struct {
+	Store  Store
+	Period Period
+	Quota  Quota
+	// PersistInterval is how often the usage is saved to the store. 0 uses 1 minute.
+	PersistInterval time.Duration
+}
+
+// Meter counts the usage of the tunnel from the access log entries and the flow records, as an access log sink.
+type Meter struct {
+	config Config
+	log    *zerolog.Logger
+	now    func() time.Time
+
+	lock  sync.Mutex
+	usage Usage
+	// warned is set once the quota exceeded warning was logged in the period
+	warned    bool
+	throttled atomic.Bool
+}
+
+// New returns a Meter resuming the usage saved in the store, if it's in the current period.
+func New(config Config, log *zerolog.Logger) (*Meter, error) {
+	m := &Meter{config: config, log: log, now: time.Now}
+	usage, err := config.Store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the saved usage: %w", err)
+	}
+	if periodStart := config.Period.start(m.now()); !usage.PeriodStart.Equal(periodStart) {
+		usage = Usage{PeriodStart: periodStart}
+	}
+	m.usage = usage
+	m.checkQuota()
+	m.updateMetrics()
+	return m, nil
+}
+
+func (m *Meter) Name() string {
+	return "accounting"
+}
+
+// Write counts an access log entry.
+func (m *Meter) Write(entry accesslog.Entry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.rollPeriod()
+	if flow := entry.Flow; flow != nil {
+		m.usage.Flows++
+		m.usage.BytesToOrigin += flow.BytesToOrigin
+		m.usage.BytesFromOrigin += flow.BytesFromOrigin
+	} else {
+		m.usage.Requests++
+		m.usage.BytesFromOrigin += uint64(entry.Bytes)
+	}
+	m.checkQuota()
+	m.updateMetrics()
+	return nil
+}
+
+// Close saves the usage.
+func (m *Meter) Close() error {
+	return m.persist()
+}
+
+// Usage returns the usage of the current period.
+func (m *Meter) Usage() Usage {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.rollPeriod()
+	return m.usage
+}
+
+// Throttled returns whether new requests and flows should be rejected because the quota is exceeded. A nil Meter
+// never throttles.
+func (m *Meter) Throttled() bool {
+	if m == nil {
+		return false
+	}
+	return m.throttled.Load()
+}
+
+// Run saves the usage periodically until ctx is done.
+func (m *Meter) Run(ctx context.Context) {
+	interval := m.config.PersistInterval
+	if interval <= 0 {
+		interval = defaultPersistInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := m.persist(); err != nil {
+				m.log.Err(err).Msg("Failed to save the usage of the tunnel")
+			}
+			return
+		case <-ticker.C:
+			m.lock.Lock()
+			m.rollPeriod()
+			m.lock.Unlock()
+			if err := m.persist(); err != nil {
+				m.log.Err(err).Msg("Failed to save the usage of the tunnel")
+			}
+		}
+	}
+}
+
+func (m *Meter) persist() error {
+	return m.config.Store.Save(m.Usage())
+}
+
+// rollPeriod resets the usage when a new period starts. It must be called with the lock held.
+func (m *Meter) rollPeriod() {
+	periodStart := m.config.Period.start(m.now())
+	if m.usage.PeriodStart.Equal(periodStart) {
+		return
+	}
+	m.usage = Usage{PeriodStart: periodStart}
+	m.warned = false
+	m.throttled.Store(false)
+	m.updateMetrics()
+}
+
+// checkQuota must be called with the lock held.
+func (m *Meter) checkQuota() {
+	quota := m.config.Quota
+	if !m.usage.exceeds(quota) {
+		return
+	}
+	if quota.Action == QuotaThrottle {
+		m.throttled.Store(true)
+	}
+	if !m.warned {
+		m.warned = true
+		m.log.Warn().
+			Uint64("requests", m.usage.Requests+m.usage.Flows).
+			Uint64("bytes", m.usage.BytesToOrigin+m.usage.BytesFromOrigin).
+			Str("action", string(quota.Action)).
+			Msg("The usage quota of the tunnel is exceeded for the current period")
+	}
+}
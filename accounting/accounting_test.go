@@ -0,0 +1,147 @@
+package accounting
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/accesslog"
+	cfdflow "github.com/cloudflare/cloudflared/flow"
+)
+
+type memoryStore struct {
+	usage Usage
+	saves int
+}
+
+func (s *memoryStore) Load() (Usage, error) { return s.usage, nil }
+
+func (s *memoryStore) Save(usage Usage) error {
+	s.usage = usage
+	s.saves++
+	return nil
+}
+
+func newTestMeter(t *testing.T, store Store, quota Quota, now *time.Time) *Meter {
+	log := zerolog.Nop()
+	meter, err := New(Config{Store: store, Period: PeriodDay, Quota: quota}, &log)
+	require.NoError(t, err)
+	meter.now = func() time.Time { return *now }
+	return meter
+}
+
+func TestMeterCountsEntries(t *testing.T) {
+	now := time.Now()
+	store := &memoryStore{}
+	meter := newTestMeter(t, store, Quota{}, &now)
+
+	require.NoError(t, meter.Write(accesslog.Entry{Status: 200, Bytes: 100}))
+	require.NoError(t, meter.Write(accesslog.Entry{Flow: &cfdflow.Record{BytesToOrigin: 10, BytesFromOrigin: 20}}))
+	usage := meter.Usage()
+	assert.Equal(t, uint64(1), usage.Requests)
+	assert.Equal(t, uint64(1), usage.Flows)
+	assert.Equal(t, uint64(10), usage.BytesToOrigin)
+	assert.Equal(t, uint64(120), usage.BytesFromOrigin)
+
+	require.NoError(t, meter.Close())
+	assert.Equal(t, usage, store.usage)
+
+	// The saved usage is resumed within the same period
+	resumed := newTestMeter(t, store, Quota{}, &now)
+	assert.Equal(t, usage, resumed.Usage())
+}
+
+func TestMeterResetsUsageWithPeriod(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 23, 0, 0, 0, time.UTC)
+	meter := newTestMeter(t, &memoryStore{}, Quota{Requests: 1, Action: QuotaThrottle}, &now)
+	meter.usage.PeriodStart = PeriodDay.start(now)
+
+	require.NoError(t, meter.Write(accesslog.Entry{Status: 200}))
+	assert.True(t, meter.Throttled())
+
+	now = now.Add(2 * time.Hour)
+	usage := meter.Usage()
+	assert.Equal(t, time.Date(2024, time.March, 11, 0, 0, 0, 0, time.UTC), usage.PeriodStart)
+	assert.Zero(t, usage.Requests)
+	assert.False(t, meter.Throttled())
+}
+
+func TestMeterIgnoresUsageOfPreviousPeriod(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.UTC)
+	store := &memoryStore{usage: Usage{
+		PeriodStart: time.Date(2024, time.March, 9, 0, 0, 0, 0, time.UTC),
+		Requests:    10,
+	}}
+	meter := newTestMeter(t, store, Quota{}, &now)
+	assert.Zero(t, meter.Usage().Requests)
+}
+
+func TestQuotaActions(t *testing.T) {
+	tests := []struct {
+		action    QuotaAction
+		throttled bool
+	}{
+		{action: QuotaWarn, throttled: false},
+		{action: QuotaThrottle, throttled: true},
+	}
+	for _, test := range tests {
+		t.Run(string(test.action), func(t *testing.T) {
+			now := time.Now()
+			meter := newTestMeter(t, &memoryStore{}, Quota{Bytes: 100, Action: test.action}, &now)
+			limiter := NewLimiter(cfdflow.NewLimiter(0), meter)
+
+			require.NoError(t, meter.Write(accesslog.Entry{Status: 200, Bytes: 99}))
+			assert.False(t, meter.Throttled())
+			require.NoError(t, limiter.Acquire("tcp"))
+
+			require.NoError(t, meter.Write(accesslog.Entry{Status: 200, Bytes: 1}))
+			assert.Equal(t, test.throttled, meter.Throttled())
+			err := limiter.Acquire("tcp")
+			if test.throttled {
+				assert.ErrorIs(t, err, ErrQuotaExceeded)
+				assert.True(t, errors.Is(err, cfdflow.ErrTooManyActiveFlows))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNilMeterNeverThrottles(t *testing.T) {
+	var meter *Meter
+	assert.False(t, meter.Throttled())
+}
+
+func TestFileStore(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "usage.json"))
+	usage, err := store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, Usage{}, usage)
+
+	saved := Usage{
+		PeriodStart:     time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+		Requests:        3,
+		Flows:           2,
+		BytesToOrigin:   1024,
+		BytesFromOrigin: 4096,
+	}
+	require.NoError(t, store.Save(saved))
+	usage, err = store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, saved, usage)
+}
+
+func TestParsePeriod(t *testing.T) {
+	period, err := ParsePeriod("month")
+	require.NoError(t, err)
+	assert.Equal(t, PeriodMonth, period)
+	_, err = ParsePeriod("week")
+	assert.Error(t, err)
+	_, err = ParseQuotaAction("block")
+	assert.Error(t, err)
+}
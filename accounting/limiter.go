@@ -0,0 +1,27 @@
+package accounting
+
+import (
+	"fmt"
+
+	cfdflow "github.com/cloudflare/cloudflared/flow"
+)
+
+// ErrQuotaExceeded wraps cfdflow.ErrTooManyActiveFlows so that the edge is told the flow was rate limited.
+var ErrQuotaExceeded = fmt.Errorf("usage quota of the tunnel exceeded: %w", cfdflow.ErrTooManyActiveFlows)
+
+type quotaLimiter struct {
+	cfdflow.Limiter
+	meter *Meter
+}
+
+// NewLimiter returns a flow limiter also rejecting new flows while meter throttles them.
+func NewLimiter(limiter cfdflow.Limiter, meter *Meter) cfdflow.Limiter {
+	return &quotaLimiter{Limiter: limiter, meter: meter}
+}
+
+func (l *quotaLimiter) Acquire(flowType string) error {
+	if l.meter.Throttled() {
+		return ErrQuotaExceeded
+	}
+	return l.Limiter.Acquire(flowType)
+}
@@ -0,0 +1,42 @@
+package accounting
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	usageGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "cloudflared",
+			Subsystem: "accounting",
+			Name:      "usage",
+			Help:      "Usage of the tunnel in the current accounting period, by kind",
+		},
+		[]string{"kind"},
+	)
+	quotaExceeded = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "cloudflared",
+			Subsystem: "accounting",
+			Name:      "quota_exceeded",
+			Help:      "Whether the usage quota of the tunnel is exceeded in the current accounting period",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(usageGauge, quotaExceeded)
+}
+
+// updateMetrics must be called with the lock held.
+func (m *Meter) updateMetrics() {
+	usageGauge.WithLabelValues("requests").Set(float64(m.usage.Requests))
+	usageGauge.WithLabelValues("flows").Set(float64(m.usage.Flows))
+	usageGauge.WithLabelValues("bytes_to_origin").Set(float64(m.usage.BytesToOrigin))
+	usageGauge.WithLabelValues("bytes_from_origin").Set(float64(m.usage.BytesFromOrigin))
+	if m.usage.exceeds(m.config.Quota) {
+		quotaExceeded.Set(1)
+	} else {
+		quotaExceeded.Set(0)
+	}
+}
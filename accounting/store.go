@@ -0,0 +1,62 @@
+package accounting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Store persists the usage of a tunnel, so that it survives restarts.
+type Store interface {
+	// Load returns the saved usage, or a zero Usage if none was saved.
+	Load() (Usage, error)
+	Save(usage Usage) error
+}
+
+type fileStore struct {
+	path string
+}
+
+// NewFileStore returns a Store saving the usage as JSON to the file at path.
+func NewFileStore(path string) Store {
+	return &fileStore{path: path}
+}
+
+func (s *fileStore) Load() (Usage, error) {
+	var usage Usage
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return usage, nil
+	}
+	if err != nil {
+		return usage, err
+	}
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return usage, fmt.Errorf("invalid usage file %s: %w", s.path, err)
+	}
+	return usage, nil
+}
+
+// Save writes the usage to a temporary file renamed over the previous one, so that a crash doesn't leave a
+// truncated file behind.
+func (s *fileStore) Save(usage Usage) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -38,6 +38,7 @@ type RESTClient struct {
 type baseEndpoints struct {
 	accountLevel  url.URL
 	zoneLevel     url.URL
+	zone          url.URL
 	accountRoutes url.URL
 	accountVnets  url.URL
 }
@@ -64,6 +65,10 @@ func NewRESTClient(baseURL, accountTag, zoneTag, authToken, userAgent string, lo
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create account level endpoint")
 	}
+	zoneEndpoint, err := url.Parse(fmt.Sprintf("%s/zones/%s", baseURL, zoneTag))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create zone endpoint")
+	}
 	httpTransport := http.Transport{
 		TLSHandshakeTimeout:   defaultTimeout,
 		ResponseHeaderTimeout: defaultTimeout,
@@ -73,6 +78,7 @@ func NewRESTClient(baseURL, accountTag, zoneTag, authToken, userAgent string, lo
 		baseEndpoints: &baseEndpoints{
 			accountLevel:  *accountLevelEndpoint,
 			zoneLevel:     *zoneLevelEndpoint,
+			zone:          *zoneEndpoint,
 			accountRoutes: *accountRoutesEndpoint,
 			accountVnets:  *accountVnetsEndpoint,
 		},
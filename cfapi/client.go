@@ -17,6 +17,7 @@ type TunnelClient interface {
 
 type HostnameClient interface {
 	RouteTunnel(tunnelID uuid.UUID, route HostnameRoute) (HostnameRouteResult, error)
+	ListDNSRoutes(tunnelID uuid.UUID) (*DNSRoutes, error)
 }
 
 type IPRouteClient interface {
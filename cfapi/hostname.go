@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"path"
+	"strconv"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
@@ -190,3 +192,63 @@ func (r *RESTClient) RouteTunnel(tunnelID uuid.UUID, route HostnameRoute) (Hostn
 
 	return nil, r.statusCodeToError("add route", resp)
 }
+
+// DNSRoutes are the hostnames of a zone routed to a tunnel with a DNS record.
+type DNSRoutes struct {
+	// Zone is the name of the zone the hostnames were listed from, e.g. example.com
+	Zone      string
+	Hostnames []string
+}
+
+type dnsRecord struct {
+	Name string `json:"name"`
+}
+
+type zone struct {
+	Name string `json:"name"`
+}
+
+// ListDNSRoutes lists the hostnames routed to the tunnel by CNAME records in the zone of the client.
+func (r *RESTClient) ListDNSRoutes(tunnelID uuid.UUID) (*DNSRoutes, error) {
+	resp, err := r.sendRequest("GET", r.baseEndpoints.zone, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "REST request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, r.statusCodeToError("get zone", resp)
+	}
+	var z zone
+	if err := parseResponse(resp.Body, &z); err != nil {
+		return nil, err
+	}
+
+	fetchFn := func(page int) (*http.Response, error) {
+		endpoint := r.baseEndpoints.zone
+		endpoint.Path = path.Join(endpoint.Path, "dns_records")
+		endpoint.RawQuery = url.Values{
+			"type":    []string{"CNAME"},
+			"content": []string{fmt.Sprintf("%v.cfargotunnel.com", tunnelID)},
+			"page":    []string{strconv.Itoa(page)},
+		}.Encode()
+		rsp, err := r.sendRequest("GET", endpoint, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "REST request failed")
+		}
+		if rsp.StatusCode != http.StatusOK {
+			rsp.Body.Close()
+			return nil, r.statusCodeToError("list DNS records", rsp)
+		}
+		return rsp, nil
+	}
+	records, err := fetchExhaustively[dnsRecord](fetchFn)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := DNSRoutes{Zone: z.Name}
+	for _, record := range records {
+		routes.Hostnames = append(routes.Hostnames, record.Name)
+	}
+	return &routes, nil
+}
@@ -0,0 +1,180 @@
+package cfio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Watermarks are the high and low watermarks of Backpressure budgets. The copies aren't limited when High is 0.
+type Watermarks struct {
+	High int64
+	Low  int64
+}
+
+// Enabled returns whether the copies are limited.
+func (w Watermarks) Enabled() bool {
+	return w.High > 0
+}
+
+// Validate returns an error if the watermarks can't make a budget.
+func (w Watermarks) Validate() error {
+	if w.High <= 0 {
+		return fmt.Errorf("the high watermark must be positive, got %d", w.High)
+	}
+	if w.Low < 0 || w.Low >= w.High {
+		return fmt.Errorf("the low watermark must be between 0 and the high watermark %d, got %d", w.High, w.Low)
+	}
+	return nil
+}
+
+// Gauge and Counter are the metrics a Backpressure reports to, e.g. a prometheus.Gauge and a prometheus.Counter.
+type Gauge interface {
+	Add(float64)
+}
+
+type Counter interface {
+	Inc()
+}
+
+// Backpressure is a bucket of tokens, one per byte, that copies take when they read and give back once they wrote
+// what they read. A budget is shared by the copies of a group, e.g. those of an ingress rule, so that a stalled
+// destination only pauses the copies of its group. It bounds the bytes held in memory by copies waiting on a slow destination: once the bytes
+// buffered reach the high watermark, copies stop reading until they drop below the low watermark, so that the flow
+// control of their sources, the QUIC streams of the edge or the TCP connections to the origins, slows the senders
+// down instead of cloudflared buffering for them.
+type Backpressure struct {
+	high, low int64
+
+	lock     sync.Mutex
+	resumed  *sync.Cond
+	buffered int64
+	paused   bool
+	pauses   uint64
+
+	bufferedGauge Gauge
+	pausesCounter Counter
+}
+
+// NewBackpressure returns a budget pausing the copies at the high watermark of buffered bytes, until they drop below
+// the low watermark. It reports to bufferedGauge and pausesCounter if they're set.
+func NewBackpressure(watermarks Watermarks, bufferedGauge Gauge, pausesCounter Counter) (*Backpressure, error) {
+	if err := watermarks.Validate(); err != nil {
+		return nil, err
+	}
+	b := &Backpressure{
+		high:          watermarks.High,
+		low:           watermarks.Low,
+		bufferedGauge: bufferedGauge,
+		pausesCounter: pausesCounter,
+	}
+	b.resumed = sync.NewCond(&b.lock)
+	return b, nil
+}
+
+type backpressureKey struct{}
+
+// ContextWithBackpressure returns ctx carrying the budget of the copies of a stream, for the origins copying the
+// stream themselves.
+func ContextWithBackpressure(ctx context.Context, b *Backpressure) context.Context {
+	if b == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, backpressureKey{}, b)
+}
+
+// BackpressureFromContext returns the budget carried by ctx, nil if there's none.
+func BackpressureFromContext(ctx context.Context) *Backpressure {
+	b, _ := ctx.Value(backpressureKey{}).(*Backpressure)
+	return b
+}
+
+// wait blocks while the copies are paused.
+func (b *Backpressure) wait() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for b.paused {
+		b.resumed.Wait()
+	}
+}
+
+// take counts n bytes as buffered, pausing the copies once the high watermark is reached.
+func (b *Backpressure) take(n int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.buffered += n
+	if b.bufferedGauge != nil {
+		b.bufferedGauge.Add(float64(n))
+	}
+	if !b.paused && b.buffered >= b.high {
+		b.paused = true
+		b.pauses++
+		if b.pausesCounter != nil {
+			b.pausesCounter.Inc()
+		}
+	}
+}
+
+// give counts n bytes as written, resuming the copies once below the low watermark.
+func (b *Backpressure) give(n int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.buffered -= n
+	if b.bufferedGauge != nil {
+		b.bufferedGauge.Add(-float64(n))
+	}
+	if b.paused && b.buffered < b.low {
+		b.paused = false
+		b.resumed.Broadcast()
+	}
+}
+
+// Buffered returns the bytes read by the copies that aren't written yet.
+func (b *Backpressure) Buffered() int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffered
+}
+
+// Pauses returns how many times the copies were paused because the high watermark was reached.
+func (b *Backpressure) Pauses() uint64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.pauses
+}
+
+// copyWithBackpressure is io.CopyBuffer, waiting for the budget to allow it before each read.
+func copyWithBackpressure(dst io.Writer, src io.Reader, b *Backpressure) (written int64, err error) {
+	pooled := GetBuffer(defaultBufferSize)
+	defer PutBuffer(pooled)
+	buffer := *pooled
+	for {
+		b.wait()
+		nr, er := src.Read(buffer)
+		if nr > 0 {
+			b.take(int64(nr))
+			nw, ew := dst.Write(buffer[:nr])
+			b.give(int64(nr))
+			if nw < 0 || nr < nw {
+				nw = 0
+				if ew == nil {
+					ew = fmt.Errorf("invalid write result")
+				}
+			}
+			written += int64(nw)
+			if ew != nil {
+				return written, ew
+			}
+			if nr != nw {
+				return written, io.ErrShortWrite
+			}
+		}
+		if er != nil {
+			if er != io.EOF {
+				return written, er
+			}
+			return written, nil
+		}
+	}
+}
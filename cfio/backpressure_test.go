@@ -0,0 +1,71 @@
+package cfio
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBackpressure(t *testing.T) {
+	_, err := NewBackpressure(Watermarks{}, nil, nil)
+	assert.Error(t, err)
+	_, err = NewBackpressure(Watermarks{High: 1024, Low: 1024}, nil, nil)
+	assert.Error(t, err)
+	_, err = NewBackpressure(Watermarks{High: 1024, Low: -1}, nil, nil)
+	assert.Error(t, err)
+	b, err := NewBackpressure(Watermarks{High: 1024}, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), b.Buffered())
+}
+
+func TestCopyBackpressure(t *testing.T) {
+	b, err := NewBackpressure(Watermarks{High: 1024, Low: 512}, nil, nil)
+	require.NoError(t, err)
+
+	// A copy to a stalled destination holds what it read, over the high watermark
+	stalledR, stalledW := io.Pipe()
+	payload := bytes.Repeat([]byte("x"), 4*defaultBufferSize)
+	stalledDone := make(chan struct{})
+	go func() {
+		defer close(stalledDone)
+		_, _ = CopyWithBackpressure(stalledW, bytes.NewReader(payload), b)
+		_ = stalledW.Close()
+	}()
+	require.Eventually(t, func() bool { return b.Buffered() > 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, uint64(1), b.Pauses())
+
+	// The copies of other groups aren't paused
+	var other bytes.Buffer
+	_, err = CopyWithBackpressure(&other, bytes.NewReader([]byte("other")), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "other", other.String())
+
+	// But the other copies of the group are until it writes
+	var out bytes.Buffer
+	copyDone := make(chan struct{})
+	go func() {
+		defer close(copyDone)
+		_, _ = CopyWithBackpressure(&out, bytes.NewReader([]byte("hello")), b)
+	}()
+	select {
+	case <-copyDone:
+		t.Fatal("the copy wasn't paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	received, err := io.ReadAll(stalledR)
+	require.NoError(t, err)
+	assert.Equal(t, payload, received)
+	<-stalledDone
+	select {
+	case <-copyDone:
+	case <-time.After(time.Second):
+		t.Fatal("the copy wasn't resumed")
+	}
+	assert.Equal(t, "hello", out.String())
+	assert.Equal(t, int64(0), b.Buffered())
+}
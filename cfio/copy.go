@@ -2,26 +2,87 @@ package cfio
 
 import (
 	"io"
-	"sync"
+	"syscall"
 )
 
 const defaultBufferSize = 16 * 1024
 
-var bufferPool = sync.Pool{
-	New: func() interface{} {
-		return make([]byte, defaultBufferSize)
-	},
+// Unwrapper is implemented by the wrappers of connections that Copy can bypass, to find the OS sockets they wrap.
+// Unwrap returns nil when the wrapper can't be bypassed, e.g. because it sets deadlines on writes.
+type Unwrapper interface {
+	Unwrap() io.ReadWriter
 }
 
+// Copy copies from src to dst until EOF. When both ends are OS sockets, or files, data is copied by the kernel
+// without going through user space, with splice or sendfile on Linux. Otherwise, data is copied through a pooled
+// buffer, unless src or dst copy it themselves.
 func Copy(dst io.Writer, src io.Reader) (written int64, err error) {
+	return CopyWithBackpressure(dst, src, nil)
+}
+
+// CopyWithBackpressure is Copy, sharing the budget b with the other copies of its group when copying through a
+// buffer. The copy isn't limited if b is nil.
+func CopyWithBackpressure(dst io.Writer, src io.Reader, b *Backpressure) (written int64, err error) {
+	if socketDst, socketSrc, ok := osSockets(dst, src); ok {
+		// net.TCPConn.ReadFrom and WriteTo splice between sockets
+		return io.Copy(socketDst, socketSrc)
+	}
+	if b != nil {
+		return copyWithBackpressure(dst, src, b)
+	}
+	// Past this point, the ReadFrom and WriteTo of OS sockets would copy through a buffer allocated for each copy
+	if _, ok := src.(syscall.Conn); ok {
+		src = readerOnly{src}
+	}
+	if _, ok := dst.(syscall.Conn); ok {
+		dst = writerOnly{dst}
+	}
+
 	_, okWriteTo := src.(io.WriterTo)
 	_, okReadFrom := dst.(io.ReaderFrom)
 	var buffer []byte = nil
 
 	if !(okWriteTo || okReadFrom) {
-		buffer = bufferPool.Get().([]byte)
-		defer bufferPool.Put(buffer)
+		pooled := GetBuffer(defaultBufferSize)
+		defer PutBuffer(pooled)
+		buffer = *pooled
 	}
 
 	return io.CopyBuffer(dst, src, buffer)
 }
+
+// osSockets returns the OS sockets wrapped by dst and src, if both wrap one.
+func osSockets(dst io.Writer, src io.Reader) (io.Writer, io.Reader, bool) {
+	socketDst, ok := unwrap(dst).(io.Writer)
+	if _, isSocket := socketDst.(syscall.Conn); !ok || !isSocket {
+		return nil, nil, false
+	}
+	socketSrc, ok := unwrap(src).(io.Reader)
+	if _, isSocket := socketSrc.(syscall.Conn); !ok || !isSocket {
+		return nil, nil, false
+	}
+	return socketDst, socketSrc, true
+}
+
+func unwrap(conn any) any {
+	for {
+		unwrapper, ok := conn.(Unwrapper)
+		if !ok {
+			return conn
+		}
+		inner := unwrapper.Unwrap()
+		if inner == nil {
+			return conn
+		}
+		conn = inner
+	}
+}
+
+// readerOnly and writerOnly hide the WriteTo and ReadFrom methods of their reader and writer.
+type readerOnly struct {
+	io.Reader
+}
+
+type writerOnly struct {
+	io.Writer
+}
@@ -0,0 +1,173 @@
+package cfio
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tcpPair returns the two ends of a loopback TCP connection.
+func tcpPair(t testing.TB) (*net.TCPConn, *net.TCPConn) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer listener.Close()
+	accepted := make(chan *net.TCPConn)
+	go func() {
+		conn, _ := listener.AcceptTCP()
+		accepted <- conn
+	}()
+	client, err := net.DialTCP("tcp", nil, listener.Addr().(*net.TCPAddr))
+	require.NoError(t, err)
+	server := <-accepted
+	require.NotNil(t, server)
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client, server
+}
+
+type wrapper struct {
+	io.ReadWriter
+	unwrap bool
+}
+
+func (w wrapper) Unwrap() io.ReadWriter {
+	if !w.unwrap {
+		return nil
+	}
+	return w.ReadWriter
+}
+
+func TestCopy(t *testing.T) {
+	payload := make([]byte, 1<<20)
+	_, _ = rand.Read(payload)
+
+	tests := []struct {
+		name    string
+		sockets bool
+		wrap    func(conn *net.TCPConn) io.ReadWriter
+	}{
+		{"sockets", true, func(conn *net.TCPConn) io.ReadWriter { return conn }},
+		{"unwrapped sockets", true, func(conn *net.TCPConn) io.ReadWriter { return wrapper{conn, true} }},
+		{"wrapped sockets", false, func(conn *net.TCPConn) io.ReadWriter { return wrapper{conn, false} }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srcWriter, src := tcpPair(t)
+			dst, dstReader := tcpPair(t)
+			_, _, ok := osSockets(test.wrap(dst), test.wrap(src))
+			assert.Equal(t, test.sockets, ok)
+
+			go func() {
+				_, _ = srcWriter.Write(payload)
+				_ = srcWriter.CloseWrite()
+			}()
+			received := make(chan []byte)
+			go func() {
+				data, _ := io.ReadAll(dstReader)
+				received <- data
+			}()
+			n, err := Copy(test.wrap(dst), test.wrap(src))
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(payload)), n)
+			require.NoError(t, dst.CloseWrite())
+			assert.Equal(t, payload, <-received)
+		})
+	}
+
+	t.Run("socket to buffer", func(t *testing.T) {
+		srcWriter, src := tcpPair(t)
+		go func() {
+			_, _ = srcWriter.Write(payload)
+			_ = srcWriter.CloseWrite()
+		}()
+		var dst bytes.Buffer
+		n, err := Copy(&dst, src)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(payload)), n)
+		assert.Equal(t, payload, dst.Bytes())
+	})
+}
+
+const benchmarkChunkSize = 64 * 1024
+
+// benchmarkTCPCopy copies b.N chunks between two loopback TCP connections with copyFn.
+func benchmarkTCPCopy(b *testing.B, copyFn func(dst io.Writer, src io.Reader) (int64, error)) {
+	srcWriter, src := tcpPair(b)
+	dst, dstReader := tcpPair(b)
+	chunk := make([]byte, benchmarkChunkSize)
+	go func() {
+		for range b.N {
+			if _, err := srcWriter.Write(chunk); err != nil {
+				return
+			}
+		}
+		_ = srcWriter.CloseWrite()
+	}()
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, dstReader)
+		close(done)
+	}()
+
+	b.SetBytes(benchmarkChunkSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	if _, err := copyFn(dst, src); err != nil {
+		b.Fatal(err)
+	}
+	_ = dst.CloseWrite()
+	<-done
+}
+
+// BenchmarkCopyTCP compares copying between sockets through a buffer, as when they were hidden behind adapters, to
+// letting the kernel splice them.
+func BenchmarkCopyTCP(b *testing.B) {
+	b.Run("io.CopyBuffer", func(b *testing.B) {
+		benchmarkTCPCopy(b, func(dst io.Writer, src io.Reader) (int64, error) {
+			return io.CopyBuffer(writerOnly{dst}, readerOnly{src}, make([]byte, defaultBufferSize))
+		})
+	})
+	b.Run("io.Copy", func(b *testing.B) {
+		benchmarkTCPCopy(b, func(dst io.Writer, src io.Reader) (int64, error) {
+			return io.Copy(writerOnly{dst}, readerOnly{src})
+		})
+	})
+	b.Run("cfio.Copy", func(b *testing.B) {
+		benchmarkTCPCopy(b, Copy)
+	})
+}
+
+// BenchmarkCopyTCPToBuffer copies from a socket to a writer that isn't one, e.g. a stream of the tunnel.
+func BenchmarkCopyTCPToBuffer(b *testing.B) {
+	b.Run("io.Copy", func(b *testing.B) {
+		benchmarkTCPToWriter(b, func(dst io.Writer, src io.Reader) (int64, error) {
+			return io.Copy(dst, src)
+		})
+	})
+	b.Run("cfio.Copy", func(b *testing.B) {
+		benchmarkTCPToWriter(b, Copy)
+	})
+}
+
+func benchmarkTCPToWriter(b *testing.B, copyFn func(dst io.Writer, src io.Reader) (int64, error)) {
+	chunk := make([]byte, benchmarkChunkSize)
+	b.SetBytes(benchmarkChunkSize)
+	b.ReportAllocs()
+	for range b.N {
+		srcWriter, src := tcpPair(b)
+		go func() {
+			_, _ = srcWriter.Write(chunk)
+			_ = srcWriter.CloseWrite()
+		}()
+		if _, err := copyFn(writerOnly{io.Discard}, src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package cfio
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// bucketSizes are the capacities of the pooled buffers: datagrams fit in the smallest, stream copies in the others.
+var bucketSizes = [...]int{2 * 1024, defaultBufferSize, 64 * 1024}
+
+var bucketPools [len(bucketSizes)]sync.Pool
+
+// poolingDisabled makes PutBuffer drop the buffers, so that the pools drain as garbage is collected.
+var poolingDisabled atomic.Bool
+
+// SetPooling stops pooling the buffers given back with PutBuffer when disabled, e.g. to give memory back while it's
+// scarce, and pools them again when enabled.
+func SetPooling(enabled bool) {
+	poolingDisabled.Store(!enabled)
+}
+
+func init() {
+	for i, size := range bucketSizes {
+		bucketPools[i].New = func() any {
+			buf := make([]byte, size)
+			return &buf
+		}
+	}
+}
+
+// GetBuffer returns a buffer of length size, from the pool of the smallest bucket it fits in. Buffers bigger than
+// the largest bucket are allocated. Pointers to slices are pooled, since putting a slice in a sync.Pool allocates.
+func GetBuffer(size int) *[]byte {
+	for i, bucketSize := range bucketSizes {
+		if size <= bucketSize {
+			buf := bucketPools[i].Get().(*[]byte)
+			*buf = (*buf)[:size]
+			return buf
+		}
+	}
+	buf := make([]byte, size)
+	return &buf
+}
+
+// PutBuffer gives back a buffer returned by GetBuffer, which must not be used afterwards.
+func PutBuffer(buf *[]byte) {
+	if poolingDisabled.Load() {
+		return
+	}
+	for i, bucketSize := range bucketSizes {
+		if cap(*buf) == bucketSize {
+			*buf = (*buf)[:bucketSize]
+			bucketPools[i].Put(buf)
+			return
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package cfio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferPool(t *testing.T) {
+	tests := []struct {
+		size        int
+		expectedCap int
+	}{
+		{size: 0, expectedCap: 2 * 1024},
+		{size: 1500, expectedCap: 2 * 1024},
+		{size: 2*1024 + 1, expectedCap: 16 * 1024},
+		{size: 16 * 1024, expectedCap: 16 * 1024},
+		{size: 32 * 1024, expectedCap: 64 * 1024},
+		{size: 64*1024 + 1, expectedCap: 64*1024 + 1},
+	}
+	for _, test := range tests {
+		buf := GetBuffer(test.size)
+		assert.Len(t, *buf, test.size)
+		assert.Equal(t, test.expectedCap, cap(*buf), "size %d", test.size)
+		PutBuffer(buf)
+	}
+}
+
+func BenchmarkGetBuffer(b *testing.B) {
+	b.ReportAllocs()
+	for range b.N {
+		buf := GetBuffer(1500)
+		(*buf)[0] = 1
+		PutBuffer(buf)
+	}
+}
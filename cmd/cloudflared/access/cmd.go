@@ -11,7 +11,6 @@ import (
 	"text/template"
 	"time"
 
-	"github.com/getsentry/sentry-go"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
@@ -83,6 +82,7 @@ func Commands() []*cli.Command {
 			per-user and by application. With Cloudflare Access, only authenticated users with the required permissions are
 			able to reach sensitive resources. The commands provided here allow you to interact with Access protected
 			applications from the command line.`,
+			Flags: cliutil.ConfigureSentryFlags(false),
 			Subcommands: []*cli.Command{
 				{
 					Name:      "login",
@@ -232,11 +232,7 @@ func Commands() []*cli.Command {
 
 // login pops up the browser window to do the actual login and JWT generation
 func login(c *cli.Context) error {
-	err := sentry.Init(sentry.ClientOptions{
-		Dsn:     sentryDSN,
-		Release: c.App.Version,
-	})
-	if err != nil {
+	if err := cliutil.InitSentry(c, sentryDSN); err != nil {
 		return err
 	}
 
@@ -284,11 +280,7 @@ func login(c *cli.Context) error {
 
 // curl provides a wrapper around curl, passing Access JWT along in request
 func curl(c *cli.Context) error {
-	err := sentry.Init(sentry.ClientOptions{
-		Dsn:     sentryDSN,
-		Release: c.App.Version,
-	})
-	if err != nil {
+	if err := cliutil.InitSentry(c, sentryDSN); err != nil {
 		return err
 	}
 	log := logger.CreateLoggerFromContext(c, logger.EnableTerminalLog)
@@ -369,11 +361,7 @@ func getAppURLFromArgs(c *cli.Context) (*url.URL, error) {
 
 // token dumps provided token to stdout
 func generateToken(c *cli.Context) error {
-	err := sentry.Init(sentry.ClientOptions{
-		Dsn:     sentryDSN,
-		Release: c.App.Version,
-	})
-	if err != nil {
+	if err := cliutil.InitSentry(c, sentryDSN); err != nil {
 		return err
 	}
 	appURL, err := getAppURLFromArgs(c)
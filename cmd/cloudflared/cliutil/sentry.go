@@ -0,0 +1,161 @@
+package cliutil
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+
+	"github.com/cloudflare/cloudflared/cmd/cloudflared/flags"
+)
+
+const (
+	scrubbedHostname = "[hostname]"
+	scrubbedIP       = "[ip]"
+)
+
+var (
+	hostnamePattern = regexp.MustCompile(`\b(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]{2,63}\b`)
+	ipv4Pattern     = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	// ipv6Pattern matches candidates, which are only scrubbed if they parse as IPv6 addresses
+	ipv6Pattern = regexp.MustCompile(`[0-9a-fA-F]{0,4}(?::[0-9a-fA-F]{0,4}){2,7}(?:%[0-9a-zA-Z]+)?`)
+
+	// unscrubbedHostnames are the names that aren't the hosts of users but show up in errors, namely the module paths
+	// and source files of the stacks.
+	unscrubbedHostnames = []string{"github.com", "golang.org", "google.golang.org", "gopkg.in", "go.opentelemetry.io"}
+)
+
+func ConfigureSentryFlags(shouldHide bool) []cli.Flag {
+	return []cli.Flag{
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    flags.NoSentry,
+			Usage:   "Don't report errors and panics to Sentry.",
+			EnvVars: []string{"TUNNEL_NO_SENTRY"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    flags.SentryDSN,
+			Usage:   "DSN of the Sentry project errors and panics are reported to, instead of Cloudflare's. The hostnames and IP addresses are scrubbed from the reports.",
+			EnvVars: []string{"TUNNEL_SENTRY_DSN"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewFloat64Flag(&cli.Float64Flag{
+			Name:    flags.SentrySampleRate,
+			Usage:   "Share, between 0 and 1, of the errors and panics reported to Sentry. 0 disables the reports.",
+			EnvVars: []string{"TUNNEL_SENTRY_SAMPLE_RATE"},
+			Value:   1,
+			Hidden:  shouldHide,
+		}),
+	}
+}
+
+// InitSentry sets up the reports to Sentry according to the Sentry flags, to defaultDSN unless another DSN is set.
+// The reports are disabled when the flags aren't defined for the command.
+func InitSentry(c *cli.Context, defaultDSN string) error {
+	dsn := defaultDSN
+	if custom := c.String(flags.SentryDSN); custom != "" {
+		dsn = custom
+	}
+	sampleRate := 1.0
+	if c.IsSet(flags.SentrySampleRate) {
+		sampleRate = c.Float64(flags.SentrySampleRate)
+		if sampleRate < 0 || sampleRate > 1 {
+			return fmt.Errorf("%s must be between 0 and 1, got %v", flags.SentrySampleRate, sampleRate)
+		}
+	}
+	// Sentry doesn't report anything without a DSN, while a sample rate of 0 would report everything
+	if c.Bool(flags.NoSentry) || sampleRate == 0 {
+		dsn = ""
+	}
+	return sentry.Init(sentry.ClientOptions{
+		Dsn:        dsn,
+		Release:    c.App.Version,
+		SampleRate: sampleRate,
+		BeforeSend: ScrubSentryEvent,
+	})
+}
+
+// ScrubSentryEvent removes the hostnames and IP addresses from an event, along with the request and the name of the
+// host it was sent from.
+func ScrubSentryEvent(event *sentry.Event, _ *sentry.EventHint) *sentry.Event {
+	event.ServerName = ""
+	event.Request = nil
+	event.User.IPAddress = ""
+	event.Message = scrub(event.Message)
+	event.Transaction = scrub(event.Transaction)
+	for i := range event.Exception {
+		event.Exception[i].Value = scrub(event.Exception[i].Value)
+	}
+	for _, breadcrumb := range event.Breadcrumbs {
+		breadcrumb.Message = scrub(breadcrumb.Message)
+		scrubValues(breadcrumb.Data)
+	}
+	for key, value := range event.Tags {
+		event.Tags[key] = scrub(value)
+	}
+	scrubValues(event.Extra)
+	return event
+}
+
+func scrubValues(values map[string]interface{}) {
+	for key, value := range values {
+		switch value := value.(type) {
+		case string:
+			values[key] = scrub(value)
+		case fmt.Stringer:
+			values[key] = scrub(value.String())
+		case error:
+			values[key] = scrub(value.Error())
+		}
+	}
+}
+
+// scrub replaces the hostnames and IP addresses in s.
+func scrub(s string) string {
+	s = ipv6Pattern.ReplaceAllStringFunc(s, func(candidate string) string {
+		address, _, _ := strings.Cut(candidate, "%")
+		if ip := net.ParseIP(address); ip != nil && strings.Contains(address, ":") {
+			return scrubbedIP
+		}
+		return candidate
+	})
+	s = ipv4Pattern.ReplaceAllStringFunc(s, func(candidate string) string {
+		if net.ParseIP(candidate) != nil {
+			return scrubbedIP
+		}
+		return candidate
+	})
+	var scrubbed strings.Builder
+	last := 0
+	for _, match := range hostnamePattern.FindAllStringIndex(s, -1) {
+		if isHostname(s, match[0], match[1]) {
+			scrubbed.WriteString(s[last:match[0]])
+			scrubbed.WriteString(scrubbedHostname)
+			last = match[1]
+		}
+	}
+	scrubbed.WriteString(s[last:])
+	return scrubbed.String()
+}
+
+// isHostname returns whether s[start:end], which looks like a hostname, isn't a function or source file of a stack.
+func isHostname(s string, start, end int) bool {
+	// Functions, as in main.main() or proxy.(*Proxy).ProxyHTTP(...)
+	if end < len(s) && s[end] == '(' {
+		return false
+	}
+	lower := strings.ToLower(s[start:end])
+	if strings.HasSuffix(lower, ".go") {
+		return false
+	}
+	for _, unscrubbed := range unscrubbedHostnames {
+		if lower == unscrubbed {
+			return false
+		}
+	}
+	return true
+}
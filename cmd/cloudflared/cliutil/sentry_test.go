@@ -0,0 +1,95 @@
+package cliutil
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+)
+
+func TestScrub(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"dial tcp 198.51.100.7:443: connection refused", "dial tcp [ip]:443: connection refused"},
+		{"dial udp [2001:db8::1]:7844: i/o timeout", "dial udp [[ip]]:7844: i/o timeout"},
+		{"lookup origin.internal.example.com on 10.0.0.2:53: no such host", "lookup [hostname] on [ip]:53: no such host"},
+		{"Unable to reach the origin service at https://app.corp.example:8443/health.", "Unable to reach the origin service at https://[hostname]:8443/health."},
+		{"fe80::1%eth0 is unreachable", "[ip] is unreachable"},
+		// Versions, times and stacks are kept
+		{"cloudflared 2024.10.1 at 12:30:45", "cloudflared 2024.10.1 at 12:30:45"},
+		{
+			"github.com/cloudflare/cloudflared/supervisor.(*EdgeTunnelServer).serveTunnel(...)\n\t/src/supervisor/tunnel.go:516 +0x5e\nmain.main()",
+			"github.com/cloudflare/cloudflared/supervisor.(*EdgeTunnelServer).serveTunnel(...)\n\t/src/supervisor/tunnel.go:516 +0x5e\nmain.main()",
+		},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.out, scrub(test.in), test.in)
+	}
+}
+
+func TestScrubSentryEvent(t *testing.T) {
+	event := &sentry.Event{
+		ServerName: "connector-1.example.com",
+		Message:    "failed to connect to 203.0.113.9",
+		Exception:  []sentry.Exception{{Type: "*net.OpError", Value: "dial tcp 203.0.113.9:7844: timeout"}},
+		Breadcrumbs: []*sentry.Breadcrumb{{
+			Message: "origin db.example.com unhealthy",
+			Data:    map[string]interface{}{"addr": "192.0.2.1", "attempts": 3},
+		}},
+		Tags:    map[string]string{"edge": "region1.v2.argotunnel.com"},
+		Extra:   map[string]interface{}{"origin": "http://localhost.example:8080"},
+		Request: &sentry.Request{URL: "https://secret.example.com"},
+		User:    sentry.User{IPAddress: "192.0.2.2"},
+	}
+	event = ScrubSentryEvent(event, nil)
+	assert.Empty(t, event.ServerName)
+	assert.Nil(t, event.Request)
+	assert.Empty(t, event.User.IPAddress)
+	assert.Equal(t, "failed to connect to [ip]", event.Message)
+	assert.Equal(t, "*net.OpError", event.Exception[0].Type)
+	assert.Equal(t, "dial tcp [ip]:7844: timeout", event.Exception[0].Value)
+	assert.Equal(t, "origin [hostname] unhealthy", event.Breadcrumbs[0].Message)
+	assert.Equal(t, map[string]interface{}{"addr": "[ip]", "attempts": 3}, event.Breadcrumbs[0].Data)
+	assert.Equal(t, map[string]string{"edge": "[hostname]"}, event.Tags)
+	assert.Equal(t, map[string]interface{}{"origin": "http://[hostname]:8080"}, event.Extra)
+}
+
+func TestInitSentry(t *testing.T) {
+	t.Cleanup(func() {
+		_ = sentry.Init(sentry.ClientOptions{})
+	})
+	newContext := func(args ...string) *cli.Context {
+		app := &cli.App{Version: "test"}
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		for _, f := range ConfigureSentryFlags(false) {
+			require.NoError(t, f.Apply(set))
+		}
+		require.NoError(t, set.Parse(args))
+		return cli.NewContext(app, set, nil)
+	}
+	dsn := func() string {
+		if client := sentry.CurrentHub().Client(); client != nil {
+			return client.Options().Dsn
+		}
+		return ""
+	}
+
+	require.NoError(t, InitSentry(newContext(), "https://key@sentry.example.com/1"))
+	assert.Equal(t, "https://key@sentry.example.com/1", dsn())
+	assert.Equal(t, 1.0, sentry.CurrentHub().Client().Options().SampleRate)
+
+	require.NoError(t, InitSentry(newContext("--sentry-dsn", "https://other@sentry.corp.example/2", "--sentry-sample-rate", "0.25"), "https://key@sentry.example.com/1"))
+	assert.Equal(t, "https://other@sentry.corp.example/2", dsn())
+	assert.Equal(t, 0.25, sentry.CurrentHub().Client().Options().SampleRate)
+
+	require.NoError(t, InitSentry(newContext("--no-sentry"), "https://key@sentry.example.com/1"))
+	assert.Empty(t, dsn())
+	require.NoError(t, InitSentry(newContext("--sentry-sample-rate", "0"), "https://key@sentry.example.com/1"))
+	assert.Empty(t, dsn())
+
+	require.Error(t, InitSentry(newContext("--sentry-sample-rate", "2"), "https://key@sentry.example.com/1"))
+}
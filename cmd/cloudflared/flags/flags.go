@@ -4,6 +4,23 @@ const (
 	// HaConnections specifies how many connections to make to the edge
 	HaConnections = "ha-connections"
 
+	// HaParallelism, HaReadyConnections and HaReadyDeadline control how the HA connections are brought up on startup
+	HaParallelism      = "ha-parallelism"
+	HaReadyConnections = "ha-ready-connections"
+	HaReadyDeadline    = "ha-ready-deadline"
+
+	// WatchdogFlappingPeriod, WatchdogConfigApplyTimeout, WatchdogMaxGoroutines and WatchdogMaxRestarts configure the
+	// watchdog restarting all the connections, or exiting, when cloudflared is wedged
+	WatchdogFlappingPeriod     = "watchdog-flapping-period"
+	WatchdogConfigApplyTimeout = "watchdog-config-apply-timeout"
+	WatchdogMaxGoroutines      = "watchdog-max-goroutines"
+	WatchdogMaxRestarts        = "watchdog-max-restarts"
+
+	// ProtocolRecoveryInterval and ProtocolRecoverySuccesses configure moving the connections that fell back to another
+	// protocol back to the preferred one once it's reachable again
+	ProtocolRecoveryInterval  = "protocol-recovery-interval"
+	ProtocolRecoverySuccesses = "protocol-recovery-successes"
+
 	// SshPort is the port on localhost the cloudflared ssh server will run on
 	SshPort = "local-ssh-port"
 
@@ -45,6 +62,13 @@ const (
 	// Note that this may result in packet drops for UDP proxying, since we expect being able to send at least 1280 bytes of inner packets.
 	QuicDisablePathMTUDiscovery = "quic-disable-pmtu-discovery"
 
+	// QuicDisableUDPOffload stops QUIC from sending with GSO and reading packets in batches, for the network stacks that
+	// misbehave with them.
+	QuicDisableUDPOffload = "quic-disable-udp-offload"
+
+	// NetworkChangeReconnect reconnects the connections broken by a change of the network of the host right away.
+	NetworkChangeReconnect = "network-change-reconnect"
+
 	// QuicConnLevelFlowControlLimit controls the max flow control limit allocated for a QUIC connection. This controls how much data is the
 	// receiver willing to buffer. Once the limit is reached, the sender will send a DATA_BLOCKED frame to indicate it has more data to write,
 	// but it's blocked by flow control
@@ -63,15 +87,48 @@ const (
 	// MaxActiveFlows is the command line flag to set the maximum number of flows that cloudflared can be processing at the same time
 	MaxActiveFlows = "max-active-flows"
 
+	// UDPFlowPacketsPerSecond is the command line flag to limit the packets per second of each UDP flow
+	UDPFlowPacketsPerSecond = "udp-flow-packets-per-second"
+
+	// UDPFlowBytesPerSecond is the command line flag to limit the bytes per second of each UDP flow
+	UDPFlowBytesPerSecond = "udp-flow-bytes-per-second"
+
+	// UDPGlobalPacketsPerSecond is the command line flag to limit the packets per second of all the UDP flows
+	UDPGlobalPacketsPerSecond = "udp-global-packets-per-second"
+
+	// UDPGlobalBytesPerSecond is the command line flag to limit the bytes per second of all the UDP flows
+	UDPGlobalBytesPerSecond = "udp-global-bytes-per-second"
+
+	// UDPMaxFlowsPerConnection is the command line flag to limit the UDP flows registered through each edge connection
+	UDPMaxFlowsPerConnection = "udp-max-flows-per-connection"
+
+	// UDPFlowMigrationGracePeriod is the command line flag to set how long UDP flows wait to be migrated to another edge connection when theirs goes away
+	UDPFlowMigrationGracePeriod = "udp-flow-migration-grace-period"
+
+	// UDPDemuxWorkers is the command line flag to set the number of workers processing the datagrams of each edge connection
+	UDPDemuxWorkers = "udp-demux-workers"
+
+	// UDPDemuxQueueDepth is the command line flag to set how many datagrams of each edge connection wait for a worker before being dropped
+	UDPDemuxQueueDepth = "udp-demux-queue-depth"
+
+	// UDPSessionManager is the command line flag to select the implementation holding the UDP flows of datagram v3
+	UDPSessionManager = "udp-session-manager"
+
 	// Tag is the command line flag to set custom tags used to identify this tunnel via added HTTP request headers to the origin
 	Tag = "tag"
 
 	// Protocol is the command line flag to set the protocol to use to connect to the Cloudflare Edge
 	Protocol = "protocol"
 
+	// ProtocolOverride is the command line flag to force the protocol of a connection, for debugging
+	ProtocolOverride = "protocol-override"
+
 	// PostQuantum is the command line flag to force the connection to Cloudflare Edge to use Post Quantum cryptography
 	PostQuantum = "post-quantum"
 
+	// PostQuantumMode sets the post-quantum mode of the connections to the edge, overall or by transport protocol
+	PostQuantumMode = "post-quantum-mode"
+
 	// Features is the command line flag to opt into various features that are still being developed or tested
 	Features = "features"
 
@@ -90,6 +147,15 @@ const (
 	// Region is the command line flag to set the Cloudflare Edge region to connect to
 	Region = "region"
 
+	// RegionFailoverWindow is the command line flag to set how long the connections can't connect to the region before falling back to the global region
+	RegionFailoverWindow = "region-failover-window"
+
+	// EdgeBootstrapURL is the command line flag to set the HTTPS endpoint the edge discovery falls back to when the SRV lookups fail
+	EdgeBootstrapURL = "edge-bootstrap-url"
+
+	// EdgeBootstrapPublicKey is the command line flag to set the base64 encoded ed25519 public key verifying the edge bootstrap documents
+	EdgeBootstrapPublicKey = "edge-bootstrap-public-key"
+
 	// IsAutoUpdated is the command line flag to signal the new process that cloudflared has been autoupdated
 	IsAutoUpdated = "is-autoupdated"
 
@@ -99,6 +165,12 @@ const (
 	// Retries is the command line flag to set the maximum number of retries for connection/protocol errors
 	Retries = "retries"
 
+	// RetryBaseTime, RetryMaxTime, RetryMultiplier and RetryJitter configure the backoff between the retries
+	RetryBaseTime   = "retry-base-time"
+	RetryMaxTime    = "retry-max-time"
+	RetryMultiplier = "retry-multiplier"
+	RetryJitter     = "retry-jitter"
+
 	// MaxEdgeAddrRetries is the command line flag to set the maximum number of times to retry on edge addrs before falling back to a lower protocol
 	MaxEdgeAddrRetries = "max-edge-addr-retries"
 
@@ -146,18 +218,162 @@ const (
 	// TraceOutput is the command line flag to set the name of trace output file
 	TraceOutput = "trace-output"
 
+	// HealthMinConnections is the number of connections to the edge needed by /readyz and /healthz of the metrics server
+	HealthMinConnections = "health-min-connections"
+
+	// HealthMaxTimeSinceConnected is how long /healthz tolerates less than HealthMinConnections
+	HealthMaxTimeSinceConnected = "health-max-time-since-connected"
+
+	// HealthRequireRemoteConfig makes /readyz wait for the configuration of remotely managed tunnels
+	HealthRequireRemoteConfig = "health-require-remote-config"
+
+	// OTLPEndpoint is the URL of the OTLP collector the spans of the proxied requests are exported to over HTTP
+	OTLPEndpoint = "otlp-endpoint"
+
+	// OTLPHeaders are the headers, as name=value, added to the requests to the OTLP collector
+	OTLPHeaders = "otlp-headers"
+
+	// OTLPSampleRatio is the ratio of the requests without a sampled parent span whose spans are exported
+	OTLPSampleRatio = "otlp-sample-ratio"
+
 	// OriginCert is the command line flag to define the path for the origin certificate used by cloudflared
 	OriginCert = "origincert"
 
 	// Metrics is the command line flag to define the address of the metrics server
 	Metrics = "metrics"
 
+	// MetricsDropLabel is the command line flag to drop labels from the metric families matching a glob
+	MetricsDropLabel = "metrics-drop-label"
+
+	// MetricsLabelAllowlist is the command line flag to collapse the values of a label outside of an allowlist
+	MetricsLabelAllowlist = "metrics-label-allowlist"
+
+	// MetricsDebug is the command line flag to serve the pprof, expvar and request trace endpoints of the metrics server
+	MetricsDebug = "metrics-debug"
+
+	// MetricsDebugToken is the command line flag to require a bearer token to reach the debug endpoints
+	MetricsDebugToken = "metrics-debug-token"
+
+	// MetricsTLSCert is the command line flag to define the certificate the metrics server is served over TLS with
+	MetricsTLSCert = "metrics-tls-cert"
+
+	// MetricsTLSKey is the command line flag to define the private key of MetricsTLSCert
+	MetricsTLSKey = "metrics-tls-key"
+
+	// MetricsClientCA is the command line flag to let the client certificates issued by a CA reach the debug endpoints
+	MetricsClientCA = "metrics-client-ca"
+
 	// MetricsUpdateFreq is the command line flag to define how frequently tunnel metrics are updated
 	MetricsUpdateFreq = "metrics-update-freq"
 
 	// ApiURL is the command line flag used to define the base URL of the API
 	ApiURL = "api-url"
 
+	// DNSRouteVerification is the command line flag to cross-check the ingress rule hostnames against the DNS routes of the tunnel
+	DNSRouteVerification = "dns-route-verification"
+
 	// Virtual DNS resolver service resolver addresses to use instead of dynamically fetching them from the OS.
 	VirtualDNSServiceResolverAddresses = "dns-resolver-addrs"
+
+	// VirtualDNSServiceResolverTimeout is how long a query waits for a resolver before being sent to another one.
+	VirtualDNSServiceResolverTimeout = "dns-resolver-timeout"
+
+	// VirtualDNSServiceCacheSize is the number of answers cached by the virtual DNS resolver service.
+	VirtualDNSServiceCacheSize = "dns-resolver-cache-size"
+
+	// VirtualDNSServiceCacheMinTTL and VirtualDNSServiceCacheMaxTTL clamp the TTL of the cached answers.
+	VirtualDNSServiceCacheMinTTL = "dns-resolver-cache-min-ttl"
+	VirtualDNSServiceCacheMaxTTL = "dns-resolver-cache-max-ttl"
+
+	// AccessLogFile is the file HTTP access logs and flow records are written to, rotated after AccessLogMaxSize megabytes.
+	AccessLogFile       = "access-log-file"
+	AccessLogMaxSize    = "access-log-max-size"
+	AccessLogMaxBackups = "access-log-max-backups"
+	AccessLogMaxAge     = "access-log-max-age"
+
+	// AccessLogSyslog writes the HTTP access logs to the local syslog daemon.
+	AccessLogSyslog = "access-log-syslog"
+
+	// AccessLogRingSize is the number of HTTP access logs kept in memory for the management service.
+	AccessLogRingSize = "access-log-ring-size"
+
+	// AccountingFile is the file the cumulative usage of the tunnel is persisted to. Accounting is disabled without it.
+	AccountingFile = "accounting-file"
+
+	// AccountingPeriod is how often the usage is reset: day or month.
+	AccountingPeriod = "accounting-period"
+
+	// AccountingQuotaBytes and AccountingQuotaRequests are the soft quotas of the usage in a period.
+	AccountingQuotaBytes    = "accounting-quota-bytes"
+	AccountingQuotaRequests = "accounting-quota-requests"
+
+	// AccountingQuotaAction is what happens once a quota is exceeded: warn or throttle.
+	AccountingQuotaAction = "accounting-quota-action"
+
+	// MemorySoftLimit is the memory, in bytes, above which the connector sheds load
+	MemorySoftLimit = "memory-soft-limit"
+
+	// LeakCheckInterval is the time between the audits of the goroutines and resources for leaks
+	LeakCheckInterval = "leak-check-interval"
+
+	// NoSentry disables the reports of the errors and panics of cloudflared to Sentry
+	NoSentry = "no-sentry"
+
+	// SentryDSN is the DSN of the Sentry project the errors and panics of cloudflared are reported to
+	SentryDSN = "sentry-dsn"
+
+	// SentrySampleRate is the share of the errors and panics that are reported to Sentry
+	SentrySampleRate = "sentry-sample-rate"
+
+	// PanicReportSampleRate is the share of the panics recovered from that are reported to Sentry
+	PanicReportSampleRate = "panic-report-sample-rate"
+
+	// ControlHeartbeatInterval is the time between the heartbeats sent to the edge on the control stream of each connection
+	ControlHeartbeatInterval = "control-heartbeat-interval"
+
+	// ControlHeartbeatLossWindow is how long the edge can leave the heartbeats unanswered before the connection is dropped
+	ControlHeartbeatLossWindow = "control-heartbeat-loss-window"
+
+	// ControlSocket is the path of the Unix socket serving the local control API
+	ControlSocket = "control-socket"
+
+	// EdgePrecheck tests the reachability of the edge over QUIC and TLS before connecting to it
+	EdgePrecheck = "edge-precheck"
+
+	// EdgeDSCP marks the packets of the connections to the edge with DSCP code points
+	EdgeDSCP = "edge-dscp"
+
+	// EdgeSocketMark is the firewall mark (SO_MARK) of the sockets of the connections to the edge
+	EdgeSocketMark = "edge-socket-mark"
+
+	// EdgeBindInterface is the interface the sockets of the connections to the edge are bound to (SO_BINDTODEVICE)
+	EdgeBindInterface = "edge-bind-interface"
+
+	// ServiceUnhealthyTimeout is how long the tunnel can go without registered connections before the service
+	// manager is told it's unhealthy
+	ServiceUnhealthyTimeout = "service-unhealthy-timeout"
+
+	// KubernetesMode makes cloudflared exit with distinct codes and print Kubernetes Events on its transitions
+	KubernetesMode = "kubernetes-mode"
+
+	// PreStopDelay is how long the tunnel keeps serving after SIGTERM before it starts draining
+	PreStopDelay = "pre-stop-delay"
+
+	// BackpressureHighWatermark is the count of bytes buffered by the proxy copies of an ingress rule at which they stop reading
+	BackpressureHighWatermark = "backpressure-high-watermark"
+
+	// BackpressureLowWatermark is the count of bytes buffered by the proxy copies of an ingress rule below which they read again
+	BackpressureLowWatermark = "backpressure-low-watermark"
+
+	// EventSink is the URL of a webhook, Kafka REST proxy or NATS server the connection events are published to
+	EventSink = "event-sink"
+
+	// EventSinkTemplate is the Go template rendering the payloads of the connection events
+	EventSinkTemplate = "event-sink-template"
+
+	// StateFile is the path of the file persisting the registration state of the connections across restarts
+	StateFile = "state-file"
+
+	// ConfigHistorySize is how many of the configurations applied are kept to roll back to
+	ConfigHistorySize = "config-history-size"
 )
@@ -92,6 +92,21 @@ func buildTailCommand(subcommands []*cli.Command) *cli.Command {
 				EnvVars: []string{"TUNNEL_MANAGEMENT_FILTER_SAMPLE"},
 				Value:   1.0,
 			},
+			&cli.StringSliceFlag{
+				Name:    "hostname",
+				Usage:   "Filter by the hostname of the requests, can be repeated",
+				EnvVars: []string{"TUNNEL_MANAGEMENT_FILTER_HOSTNAME"},
+			},
+			&cli.IntSliceFlag{
+				Name:    "rule",
+				Usage:   "Filter by the index of the ingress rule the requests matched, can be repeated",
+				EnvVars: []string{"TUNNEL_MANAGEMENT_FILTER_RULE"},
+			},
+			&cli.IntFlag{
+				Name:    "min-status",
+				Usage:   "Filter out the responses with a lower status code, e.g. 500 for the server errors",
+				EnvVars: []string{"TUNNEL_MANAGEMENT_FILTER_MIN_STATUS"},
+			},
 			&cli.StringFlag{
 				Name:    "token",
 				Usage:   "Access token for a specific tunnel",
@@ -217,15 +232,25 @@ func parseFilters(c *cli.Context) (*management.StreamingFilters, error) {
 	}
 	sample = argSample
 
-	if level == nil && len(events) == 0 && argSample != 1.0 {
+	hostnames := c.StringSlice("hostname")
+	rules := c.IntSlice("rule")
+	minStatus := c.Int("min-status")
+	if minStatus < 0 || minStatus > 599 {
+		return nil, fmt.Errorf("invalid --min-status value provided, please use a status code up to 599")
+	}
+
+	if level == nil && len(events) == 0 && argSample != 1.0 && len(hostnames) == 0 && len(rules) == 0 && minStatus == 0 {
 		// When no filters are provided, do not return a StreamingFilters struct
 		return nil, nil
 	}
 
 	return &management.StreamingFilters{
-		Level:    level,
-		Events:   events,
-		Sampling: sample,
+		Level:     level,
+		Events:    events,
+		Sampling:  sample,
+		Hostnames: hostnames,
+		Rules:     rules,
+		MinStatus: minStatus,
 	}, nil
 }
 
@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -12,15 +14,14 @@ import (
 	"sync"
 	"time"
 
-	"github.com/coreos/go-systemd/v22/daemon"
 	"github.com/facebookgo/grace/gracenet"
-	"github.com/getsentry/sentry-go"
 	"github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
 	"github.com/urfave/cli/v2/altsrc"
 
+	"github.com/cloudflare/cloudflared/accounting"
 	"github.com/cloudflare/cloudflared/cfapi"
 	"github.com/cloudflare/cloudflared/cmd/cloudflared/cliutil"
 	cfdflags "github.com/cloudflare/cloudflared/cmd/cloudflared/flags"
@@ -28,14 +29,19 @@ import (
 	"github.com/cloudflare/cloudflared/cmd/cloudflared/updater"
 	"github.com/cloudflare/cloudflared/config"
 	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/control"
 	"github.com/cloudflare/cloudflared/credentials"
 	"github.com/cloudflare/cloudflared/diagnostic"
 	"github.com/cloudflare/cloudflared/edgediscovery"
+	cfdflow "github.com/cloudflare/cloudflared/flow"
 	"github.com/cloudflare/cloudflared/ingress"
 	"github.com/cloudflare/cloudflared/logger"
 	"github.com/cloudflare/cloudflared/management"
 	"github.com/cloudflare/cloudflared/metrics"
 	"github.com/cloudflare/cloudflared/orchestration"
+	"github.com/cloudflare/cloudflared/proxy"
+	"github.com/cloudflare/cloudflared/recovery"
+	"github.com/cloudflare/cloudflared/retry"
 	"github.com/cloudflare/cloudflared/signal"
 	"github.com/cloudflare/cloudflared/supervisor"
 	"github.com/cloudflare/cloudflared/tlsconfig"
@@ -53,6 +59,9 @@ const (
 	LogFieldTmpTraceFilename    = "tmpTraceFilename"
 	LogFieldTraceOutputFilepath = "traceOutputFilepath"
 
+	// credentialsReregisterInterval spaces the connections re-registering with rotated credentials
+	credentialsReregisterInterval = 5 * time.Second
+
 	tunnelCmdErrorMessage = `You did not specify any valid additional argument to the cloudflared tunnel command.
 
 If you are trying to run a Quick Tunnel then you need to explicitly pass the --url flag.
@@ -78,6 +87,11 @@ var (
 		cfdflags.AutoUpdateFreq,
 		cfdflags.NoAutoUpdate,
 		cfdflags.Metrics,
+		cfdflags.MetricsDropLabel,
+		cfdflags.MetricsLabelAllowlist,
+		cfdflags.MetricsDebug,
+		cfdflags.MetricsTLSCert,
+		cfdflags.MetricsClientCA,
 		"pidfile",
 		"url",
 		"hello-world",
@@ -115,6 +129,43 @@ var (
 		cfdflags.LogFile,
 		cfdflags.LogDirectory,
 		cfdflags.TraceOutput,
+		cfdflags.OTLPEndpoint,
+		cfdflags.OTLPSampleRatio,
+		cfdflags.HealthMinConnections,
+		cfdflags.HealthMaxTimeSinceConnected,
+		cfdflags.HealthRequireRemoteConfig,
+		cfdflags.AccessLogFile,
+		cfdflags.AccessLogMaxSize,
+		cfdflags.AccessLogMaxBackups,
+		cfdflags.AccessLogMaxAge,
+		cfdflags.AccessLogSyslog,
+		cfdflags.AccessLogRingSize,
+		cfdflags.ControlSocket,
+		cfdflags.QuicDisableUDPOffload,
+		cfdflags.NetworkChangeReconnect,
+		cfdflags.EdgeDSCP,
+		cfdflags.EdgeSocketMark,
+		cfdflags.EdgeBindInterface,
+		cfdflags.ServiceUnhealthyTimeout,
+		cfdflags.KubernetesMode,
+		cfdflags.PreStopDelay,
+		cfdflags.BackpressureHighWatermark,
+		cfdflags.BackpressureLowWatermark,
+		cfdflags.MemorySoftLimit,
+		cfdflags.LeakCheckInterval,
+		cfdflags.PanicReportSampleRate,
+		cfdflags.NoSentry,
+		cfdflags.SentrySampleRate,
+		cfdflags.ControlHeartbeatInterval,
+		cfdflags.ControlHeartbeatLossWindow,
+		cfdflags.ProtocolRecoveryInterval,
+		cfdflags.ProtocolRecoverySuccesses,
+		cfdflags.UDPSessionManager,
+		tlsconfig.EdgeCABundleFlag,
+		tlsconfig.EdgeSPKIPinsFlag,
+		CredProviderFlag,
+		CredRotationFlag,
+		cfdflags.StateFile,
 		cfdflags.ProxyDns,
 		"proxy-dns-port",
 		"proxy-dns-address",
@@ -124,6 +175,10 @@ var (
 		cfdflags.IsAutoUpdated,
 		cfdflags.Edge,
 		cfdflags.Region,
+		cfdflags.RegionFailoverWindow,
+		cfdflags.ConfigHistorySize,
+		cfdflags.EdgeBootstrapURL,
+		cfdflags.EdgeBootstrapPublicKey,
 		cfdflags.EdgeIpVersion,
 		cfdflags.EdgeBindAddress,
 		"cacert",
@@ -137,6 +192,10 @@ var (
 		"heartbeat-count",
 		cfdflags.MaxEdgeAddrRetries,
 		cfdflags.Retries,
+		cfdflags.RetryBaseTime,
+		cfdflags.RetryMaxTime,
+		cfdflags.RetryMultiplier,
+		cfdflags.RetryJitter,
 		"ha-connections",
 		"rpc-timeout",
 		"write-stream-timeout",
@@ -154,6 +213,7 @@ var (
 		"quick-service",
 		"max-fetch-size",
 		cfdflags.PostQuantum,
+		cfdflags.PostQuantumMode,
 		"management-diagnostics",
 		cfdflags.Protocol,
 		"overwrite-dns",
@@ -335,17 +395,57 @@ func StartServer(
 	namedTunnel *connection.TunnelProperties,
 	log *zerolog.Logger,
 ) error {
-	err := sentry.Init(sentry.ClientOptions{
-		Dsn:     sentryDSN,
-		Release: c.App.Version,
-	})
-	if err != nil {
+	return startServer(c, info, namedTunnel, nil, log)
+}
+
+func startServer(
+	c *cli.Context,
+	info *cliutil.BuildInfo,
+	namedTunnel *connection.TunnelProperties,
+	rotatingCredentials *credentials.RotatingTunnelCredentials,
+	log *zerolog.Logger,
+) error {
+	kubernetes := newKubernetesMode(c)
+	return kubernetes.exit(runServer(c, info, namedTunnel, rotatingCredentials, kubernetes, log))
+}
+
+func runServer(
+	c *cli.Context,
+	info *cliutil.BuildInfo,
+	namedTunnel *connection.TunnelProperties,
+	rotatingCredentials *credentials.RotatingTunnelCredentials,
+	kubernetes *kubernetesMode,
+	log *zerolog.Logger,
+) error {
+	if err := cliutil.InitSentry(c, sentryDSN); err != nil {
 		return err
 	}
+	panicSampleRate := c.Float64(cfdflags.PanicReportSampleRate)
+	if panicSampleRate < 0 || panicSampleRate > 1 {
+		return fmt.Errorf("%s must be between 0 and 1, got %v", cfdflags.PanicReportSampleRate, panicSampleRate)
+	}
+	recovery.SetSentrySampleRate(panicSampleRate)
 	var wg sync.WaitGroup
 	listeners := gracenet.Net{}
 	errC := make(chan error)
 
+	reporters := []ServiceStatusReporter{serviceStatusReporter}
+	systemd := newSystemdNotifier(log)
+	if systemd != nil {
+		reporters = append(reporters, systemd.report)
+	}
+	if kubernetes != nil {
+		reporters = append(reporters, kubernetes.report)
+	}
+	status := newServiceStatus(c.Duration(cfdflags.ServiceUnhealthyTimeout), reporters...)
+	defer status.stop()
+	if status.enabled() {
+		go func() {
+			<-graceShutdownC
+			status.stop()
+		}()
+	}
+
 	// Only log for locally configured tunnels (Token is blank).
 	if config.GetConfiguration().Source() == "" && c.String(TunnelTokenFlag) == "" {
 		log.Info().Msg(config.ErrNoConfigFile.Error())
@@ -387,11 +487,26 @@ func StartServer(
 	info.Log(log)
 	logClientOptions(c, log)
 
+	if endpoint := c.String(cfdflags.OTLPEndpoint); endpoint != "" {
+		stopExport, err := startTraceExport(c, endpoint, log)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = stopExport(ctx)
+		}()
+	}
+
 	// this context drives the server, when it's cancelled tunnel and all other components (origins, dns, etc...) should stop
 	ctx, cancel := context.WithCancel(c.Context)
 	defer cancel()
+	if systemd != nil {
+		go systemd.runWatchdog(ctx.Done())
+	}
 
-	go waitForSignal(graceShutdownC, log)
+	go waitForSignal(graceShutdownC, c.Duration(cfdflags.PreStopDelay), log)
 
 	if c.IsSet(cfdflags.ProxyDns) {
 		dnsReadySignal := make(chan struct{})
@@ -405,7 +520,6 @@ func StartServer(
 	}
 
 	connectedSignal := signal.New(make(chan struct{}))
-	go notifySystemd(connectedSignal)
 	if c.IsSet("pidfile") {
 		go writePidFile(connectedSignal, c.String("pidfile"), log)
 	}
@@ -423,6 +537,7 @@ func StartServer(
 	// Serve DNS proxy stand-alone if no tunnel type (quick, adhoc, named) is going to run
 	if dnsProxyStandAlone(c, namedTunnel) {
 		connectedSignal.Notify()
+		status.ready()
 		// no grace period, handle SIGINT/SIGTERM immediately
 		return waitToShutdown(&wg, cancel, errC, graceShutdownC, 0, log)
 	}
@@ -430,6 +545,7 @@ func StartServer(
 	logTransport := logger.CreateTransportLoggerFromContext(c, logger.EnableTerminalLog)
 
 	observer := connection.NewObserver(log, logTransport)
+	observer.RegisterSink(status)
 
 	// Send Quick Tunnel URL to UI if applicable
 	var quickTunnelURL string
@@ -445,7 +561,21 @@ func StartServer(
 		log.Err(err).Msg("Couldn't start tunnel")
 		return err
 	}
+	orchestratorConfig.CopyWatermarks, err = backpressureWatermarks(c)
+	if err != nil {
+		return err
+	}
 	connectorID := tunnelConfig.ClientConfig.ConnectorID
+	if rotatingCredentials != nil {
+		tunnelConfig.TunnelCredentials = rotatingCredentials.Current
+	}
+
+	if namedTunnel != nil && quickTunnelURL == "" {
+		orchestratorConfig.ValidateIngress, err = newDNSRouteVerifier(c, namedTunnel.Credentials.TunnelID, log)
+		if err != nil {
+			return err
+		}
+	}
 
 	// Disable ICMP packet routing for quick tunnels
 	if quickTunnelURL != "" {
@@ -468,12 +598,35 @@ func StartServer(
 		logger.ManagementLogger.Log,
 		logger.ManagementLogger,
 	)
+	if ring := orchestratorConfig.AccessLog.Ring(); ring != nil {
+		mgmt.ServeAccessLogs(ring)
+	}
+	if meter := orchestratorConfig.Accounting; meter != nil {
+		go meter.Run(ctx)
+	}
+	if memory := orchestratorConfig.Memory; memory != nil {
+		go memory.Run(ctx)
+	}
+	mgmt.ServeLogSettings(logger.SettingsHandler(log))
 	internalRules := []ingress.Rule{ingress.NewManagementRule(mgmt)}
+	if systemd != nil {
+		orchestratorConfig.OnApply = systemd.reloading
+	}
 	orchestrator, err := orchestration.NewOrchestrator(ctx, orchestratorConfig, tunnelConfig.Tags, internalRules, tunnelConfig.Log)
 	if err != nil {
 		return err
 	}
+	maintenanceHandler := proxy.MaintenanceHandler(orchestrator.Maintenance(), log)
+	mgmt.ServeMaintenance(maintenanceHandler)
 
+	labelPolicy, err := newMetricsLabelPolicy(c)
+	if err != nil {
+		return err
+	}
+	debugEndpoints, metricsTLSConfig, err := newMetricsDebugEndpoints(c)
+	if err != nil {
+		return err
+	}
 	metricsListener, err := metrics.CreateMetricsListener(&listeners, c.String("metrics"))
 	if err != nil {
 		log.Err(err).Msg("Error opening metrics server listener")
@@ -481,61 +634,167 @@ func StartServer(
 	}
 
 	defer metricsListener.Close()
+	tracker := tunnelstate.NewConnTracker(log)
+	observer.RegisterSink(tracker)
+	if interval := c.Duration(cfdflags.LeakCheckInterval); interval > 0 {
+		go newLeakCheck(interval, tunnelConfig.HAConnections, tracker, log).Run(ctx)
+	}
+
+	ipv4, ipv6, err := determineICMPSources(c, log)
+	sources := make([]string, 0)
+	if err == nil {
+		sources = append(sources, ipv4.String())
+		sources = append(sources, ipv6.String())
+	}
+	cliFlags := nonSecretCliFlags(log, c, nonSecretFlagsList)
+	diagnosticHandler := diagnostic.NewDiagnosticHandler(
+		log,
+		0,
+		diagnostic.NewSystemCollectorImpl(buildInfo.CloudflaredVersion),
+		tunnelConfig.NamedTunnel.Credentials.TunnelID,
+		connectorID,
+		tracker,
+		cliFlags,
+		sources,
+	)
+	diagnosticHandler.SetBundleSources(diagnostic.BundleSources{
+		Config:    orchestrator.GetVersionedConfigJSON,
+		EdgeAddrs: bundleEdgeAddrs(tunnelConfig, log),
+	})
+	mgmt.ServeDiagnostics(http.HandlerFunc(diagnosticHandler.BundleHandler))
+
+	shutdownC := (<-chan struct{})(graceShutdownC)
+	socketPath := c.String(cfdflags.ControlSocket)
+	var (
+		drainC     chan struct{}
+		tunnelHost *supervisor.TunnelHost
+	)
+	if socketPath != "" {
+		drainC = make(chan struct{})
+		shutdownC = mergeShutdownSignals(graceShutdownC, drainC)
+		// Tunnels can only be hosted next to the main one through the control API
+		tunnelHost = supervisor.NewTunnelHost(ctx, shutdownC, log)
+	}
+
 	wg.Add(1)
 
 	go func() {
 		defer wg.Done()
-		tracker := tunnelstate.NewConnTracker(log)
-		observer.RegisterSink(tracker)
-
-		ipv4, ipv6, err := determineICMPSources(c, log)
-		sources := make([]string, 0)
-		if err == nil {
-			sources = append(sources, ipv4.String())
-			sources = append(sources, ipv6.String())
-		}
 
 		readinessServer := metrics.NewReadyServer(connectorID, tracker)
-		cliFlags := nonSecretCliFlags(log, c, nonSecretFlagsList)
-		diagnosticHandler := diagnostic.NewDiagnosticHandler(
-			log,
-			0,
-			diagnostic.NewSystemCollectorImpl(buildInfo.CloudflaredVersion),
-			tunnelConfig.NamedTunnel.Credentials.TunnelID,
-			connectorID,
-			tracker,
-			cliFlags,
-			sources,
-		)
+		healthServer := metrics.NewHealthServer(metrics.HealthCriteria{
+			MinConnections:        uint(max(c.Int(cfdflags.HealthMinConnections), 0)),
+			MaxTimeSinceConnected: c.Duration(cfdflags.HealthMaxTimeSinceConnected),
+			RequireRemoteConfig:   c.Bool(cfdflags.HealthRequireRemoteConfig),
+		}, tracker, orchestrator.ConfigVersion)
 		metricsConfig := metrics.Config{
 			ReadyServer:         readinessServer,
+			HealthServer:        healthServer,
 			DiagnosticHandler:   diagnosticHandler,
 			QuickTunnelHostname: quickTunnelURL,
 			Orchestrator:        orchestrator,
+			FeatureSnapshots:    tunnelConfig.FeatureSnapshots,
+			LabelPolicy:         labelPolicy,
+			Debug:               debugEndpoints,
+			TLSConfig:           metricsTLSConfig,
+		}
+		if tunnelHost != nil {
+			metricsConfig.Gatherer = tunnelHost.Gatherer()
 		}
 		errC <- metrics.ServeMetrics(metricsListener, ctx, metricsConfig, log)
 	}()
 
+	if tunnelConfig.EdgeTrust != nil {
+		go tunnelConfig.EdgeTrust.Watch(ctx)
+	}
+
 	reconnectCh := make(chan supervisor.ReconnectSignal, c.Int(cfdflags.HaConnections))
+	if rotatingCredentials != nil {
+		go rotatingCredentials.Run(ctx, func(connection.Credentials) {
+			reregisterConnections(ctx, reconnectCh, c.Int(cfdflags.HaConnections), log)
+		})
+	}
 	if c.IsSet("stdin-control") {
 		log.Info().Msg("Enabling control through stdin")
 		go stdinControl(reconnectCh, log)
 	}
 
+	if socketPath != "" {
+		controlServer := control.NewServer(control.Config{
+			SocketPath:    socketPath,
+			Version:       buildInfo.CloudflaredVersion,
+			ConnectorID:   connectorID,
+			TunnelID:      tunnelConfig.NamedTunnel.Credentials.TunnelID,
+			Tracker:       tracker,
+			Observer:      observer,
+			ConfigVersion: orchestrator.ConfigVersion,
+			ReconnectCh:   reconnectCh,
+			Drain:         func() { close(drainC) },
+			Maintenance:   maintenanceHandler,
+
+			ProtocolOverrides: tunnelConfig.ProtocolOverrides,
+			FeatureSnapshots:  tunnelConfig.FeatureSnapshots,
+			Precheck: func(ctx context.Context) (*supervisor.PrecheckReport, error) {
+				return supervisor.Precheck(ctx, tunnelConfig)
+			},
+			Debug:         debugEndpoints,
+			DryRun:        orchestrator.DryRun,
+			ConfigHistory: orchestrator.ConfigHistory,
+			Rollback:      orchestrator.Rollback,
+			Tunnels: &hostedTunnels{
+				TunnelHost:       tunnelHost,
+				base:             tunnelConfig,
+				baseOrchestrator: orchestratorConfig,
+			},
+		}, log)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errC <- controlServer.Serve(ctx)
+		}()
+		go func() {
+			defer wg.Done()
+			<-ctx.Done()
+			tunnelHost.Wait()
+		}()
+	}
+
+	if c.Bool(cfdflags.EdgePrecheck) {
+		if report, err := supervisor.Precheck(ctx, tunnelConfig); err != nil {
+			log.Err(err).Msg("Unable to run the edge precheck")
+		} else {
+			report.Log(log)
+		}
+	}
+
 	wg.Add(1)
 	go func() {
 		defer func() {
 			wg.Done()
 			log.Info().Msg("Tunnel server stopped")
 		}()
-		errC <- supervisor.StartTunnelDaemon(ctx, tunnelConfig, orchestrator, connectedSignal, reconnectCh, graceShutdownC)
+		errC <- supervisor.StartTunnelDaemon(ctx, tunnelConfig, orchestrator, connectedSignal, reconnectCh, shutdownC)
 	}()
 
 	gracePeriod, err := gracePeriod(c)
 	if err != nil {
 		return err
 	}
-	return waitToShutdown(&wg, cancel, errC, graceShutdownC, gracePeriod, log)
+	return waitToShutdown(&wg, cancel, errC, shutdownC, gracePeriod, log)
+}
+
+// mergeShutdownSignals returns a channel closed once either graceShutdownC or drainC is closed. drainC is closed
+// separately from graceShutdownC, since the signal handler and the Windows service close graceShutdownC.
+func mergeShutdownSignals(graceShutdownC, drainC <-chan struct{}) <-chan struct{} {
+	shutdownC := make(chan struct{})
+	go func() {
+		select {
+		case <-graceShutdownC:
+		case <-drainC:
+		}
+		close(shutdownC)
+	}()
+	return shutdownC
 }
 
 func waitToShutdown(wg *sync.WaitGroup,
@@ -552,12 +811,21 @@ func waitToShutdown(wg *sync.WaitGroup,
 	case <-graceShutdownC:
 		log.Debug().Msg("Graceful shutdown signalled")
 		if gracePeriod > 0 {
-			// wait for either grace period or service termination
-			ticker := time.NewTicker(gracePeriod)
-			defer ticker.Stop()
+			// The connections unregister, so that the edge stops routing requests to them, then wait for their
+			// in-flight requests and streams to finish. The service terminates once they're done, or the server
+			// context is canceled at the end of the grace period. Canceling it any earlier, e.g. as soon as
+			// nothing is in flight, would cut the requests the edge routes while the connections unregister.
+			drainCtx, cancelDrain := context.WithTimeout(context.Background(), gracePeriod)
+			go cfdflow.Active.Drain(drainCtx, log)
 			select {
-			case <-ticker.C:
 			case <-errC:
+			case <-drainCtx.Done():
+			}
+			cancelDrain()
+			if remaining := cfdflow.Active.Draining(); len(remaining) > 0 {
+				log.Warn().Msgf("Forcibly terminating %s still in flight", cfdflow.FormatCounts(remaining))
+			} else {
+				log.Info().Msg("All in-flight requests and streams finished")
 			}
 		}
 	}
@@ -582,11 +850,6 @@ func waitToShutdown(wg *sync.WaitGroup,
 	return err
 }
 
-func notifySystemd(waitForSignal *signal.Signal) {
-	<-waitForSignal.Wait()
-	_, _ = daemon.SdNotify(false, "READY=1")
-}
-
 func writePidFile(waitForSignal *signal.Signal, pidPathname string, log *zerolog.Logger) {
 	<-waitForSignal.Wait()
 	expandedPath, err := homedir.Expand(pidPathname)
@@ -632,6 +895,7 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 	flags := configureCloudflaredFlags(shouldHide)
 	flags = append(flags, configureProxyFlags(shouldHide)...)
 	flags = append(flags, cliutil.ConfigureLoggingFlags(shouldHide)...)
+	flags = append(flags, cliutil.ConfigureSentryFlags(shouldHide)...)
 	flags = append(flags, configureProxyDNSFlags(shouldHide)...)
 	flags = append(flags, []cli.Flag{
 		credentialsFileFlag,
@@ -652,9 +916,24 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 			Usage:   "Cloudflare Edge region to connect to. Omit or set to empty to connect to the global region.",
 			EnvVars: []string{"TUNNEL_REGION"},
 		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.RegionFailoverWindow,
+			Usage:   "Fall back to the global region when none of the connections can connect to --region for this long, and move them back once the region is reachable again. 0 disables the fallback.",
+			EnvVars: []string{"TUNNEL_REGION_FAILOVER_WINDOW"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.EdgeBootstrapURL,
+			Usage:   "HTTPS endpoint serving the signed edge addresses, used when the SRV lookups of the edge discovery fail, e.g. on networks blocking them. Requires --edge-bootstrap-public-key.",
+			EnvVars: []string{"TUNNEL_EDGE_BOOTSTRAP_URL"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.EdgeBootstrapPublicKey,
+			Usage:   "Base64 encoded ed25519 public key verifying the signatures of the documents of --edge-bootstrap-url.",
+			EnvVars: []string{"TUNNEL_EDGE_BOOTSTRAP_PUBLIC_KEY"},
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    cfdflags.EdgeIpVersion,
-			Usage:   "Cloudflare Edge IP address version to connect with. {4, 6, auto}",
+			Usage:   "Cloudflare Edge IP address version to connect with. {4, 6, auto}. IPv6 is used on hosts without an IPv4 route unless 4 is set explicitly.",
 			EnvVars: []string{"TUNNEL_EDGE_IP_VERSION"},
 			Value:   "4",
 			Hidden:  false,
@@ -671,6 +950,16 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 			EnvVars: []string{"TUNNEL_CACERT"},
 			Hidden:  true,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    tlsconfig.EdgeCABundleFlag,
+			Usage:   "PEM bundle of the root Certificate Authorities trusted for the connections with Cloudflare's edge network, instead of the system and Cloudflare ones. Reloaded when the file is written.",
+			EnvVars: []string{"TUNNEL_EDGE_CA_BUNDLE"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    tlsconfig.EdgeSPKIPinsFlag,
+			Usage:   "File of base64 encoded SHA-256 hashes of public keys, one per line, one of which must be in the certificate chain of Cloudflare's edge network. Reloaded when the file is written.",
+			EnvVars: []string{"TUNNEL_EDGE_SPKI_PINS"},
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    "hostname",
 			Usage:   "Set a hostname on a Cloudflare zone to route traffic through this tunnel.",
@@ -733,6 +1022,12 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 			Value:  time.Second * 5,
 			Hidden: true,
 		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.ConfigHistorySize,
+			Usage:   "Number of the configurations applied that are kept to roll back to through the control API.",
+			Value:   10,
+			EnvVars: []string{"TUNNEL_CONFIG_HISTORY_SIZE"},
+		}),
 		// Note TUN-3758 , we use Int because UInt is not supported with altsrc
 		altsrc.NewIntFlag(&cli.IntFlag{
 			Name:   "heartbeat-count",
@@ -754,11 +1049,103 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 			EnvVars: []string{"TUNNEL_RETRIES"},
 			Hidden:  shouldHide,
 		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.RetryBaseTime,
+			Value:   retry.DefaultBaseTime,
+			Usage:   "Backoff before the first retry of a connection to the edge, multiplied by --retry-multiplier with each retry.",
+			EnvVars: []string{"TUNNEL_RETRY_BASE_TIME"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.RetryMaxTime,
+			Usage:   "Maximum backoff between the retries of a connection to the edge. 0 doesn't cap it.",
+			EnvVars: []string{"TUNNEL_RETRY_MAX_TIME"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewFloat64Flag(&cli.Float64Flag{
+			Name:    cfdflags.RetryMultiplier,
+			Value:   retry.DefaultMultiplier,
+			Usage:   "Multiplier of the backoff with each retry of a connection to the edge.",
+			EnvVars: []string{"TUNNEL_RETRY_MULTIPLIER"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.RetryJitter,
+			Value:   retry.FullJitter.String(),
+			Usage:   "How the backoff between the retries of a connection to the edge is randomized, so that cloudflared instances failing together don't retry together. {full, equal, none, decorrelated}",
+			EnvVars: []string{"TUNNEL_RETRY_JITTER"},
+			Hidden:  shouldHide,
+		}),
 		altsrc.NewIntFlag(&cli.IntFlag{
 			Name:   cfdflags.HaConnections,
 			Value:  4,
 			Hidden: true,
 		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    cfdflags.ProtocolOverride,
+			Usage:   "Forces the protocol of a connection regardless of --protocol, in the form <connection index>=<protocol>, e.g. 3=http2. For debugging, the connection doesn't fall back to another protocol. Can be repeated.",
+			EnvVars: []string{"TUNNEL_PROTOCOL_OVERRIDE"},
+			Hidden:  true,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.HaParallelism,
+			Usage:   "Number of HA connections dialed at once on startup. Below 2, the first connection registers before the others are dialed one at a time.",
+			EnvVars: []string{"TUNNEL_HA_PARALLELISM"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.HaReadyConnections,
+			Value:   1,
+			Usage:   "Number of HA connections that must register before cloudflared reports it's ready, when they're dialed in parallel.",
+			EnvVars: []string{"TUNNEL_HA_READY_CONNECTIONS"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.HaReadyDeadline,
+			Usage:   "Fail the startup if no connection registers within this duration, when the HA connections are dialed in parallel. 0 waits indefinitely.",
+			EnvVars: []string{"TUNNEL_HA_READY_DEADLINE"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.WatchdogFlappingPeriod,
+			Usage:   "Restart all the connections to the edge when none of them stays connected for this long while they keep reconnecting. 0 disables the check.",
+			EnvVars: []string{"TUNNEL_WATCHDOG_FLAPPING_PERIOD"},
+			Value:   10 * time.Minute,
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.WatchdogConfigApplyTimeout,
+			Usage:   "Exit, for the process manager to restart cloudflared, when applying a configuration takes longer than this. 0 disables the check.",
+			EnvVars: []string{"TUNNEL_WATCHDOG_CONFIG_APPLY_TIMEOUT"},
+			Value:   5 * time.Minute,
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.WatchdogMaxGoroutines,
+			Usage:   "Exit, for the process manager to restart cloudflared, when it keeps running more goroutines than this. 0 disables the check.",
+			EnvVars: []string{"TUNNEL_WATCHDOG_MAX_GOROUTINES"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.WatchdogMaxRestarts,
+			Usage:   "Maximum number of watchdog restarts of the flapping connections per hour, after which cloudflared exits. Defaults to 3.",
+			EnvVars: []string{"TUNNEL_WATCHDOG_MAX_RESTARTS"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.ProtocolRecoveryInterval,
+			Usage:   "While connections are on the fallback protocol, e.g. http2 because UDP was blocked, time between the probes of the preferred protocol. Once it's reachable again, the connections are moved back to it one at a time. 0 disables it.",
+			EnvVars: []string{"TUNNEL_PROTOCOL_RECOVERY_INTERVAL"},
+			Value:   2 * time.Minute,
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.ProtocolRecoverySuccesses,
+			Usage:   "Number of probes of the preferred protocol in a row that must succeed before a connection is moved back to it.",
+			EnvVars: []string{"TUNNEL_PROTOCOL_RECOVERY_SUCCESSES"},
+			Value:   3,
+			Hidden:  shouldHide,
+		}),
 		altsrc.NewDurationFlag(&cli.DurationFlag{
 			Name:   cfdflags.RpcTimeout,
 			Value:  5 * time.Second,
@@ -778,6 +1165,19 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 			Value:   false,
 			Hidden:  true,
 		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    cfdflags.QuicDisableUDPOffload,
+			EnvVars: []string{"TUNNEL_DISABLE_QUIC_UDP_OFFLOAD"},
+			Usage:   "Use this option to disable GSO and batched reads for QUIC connections, sending and reading a packet per syscall.",
+			Value:   false,
+			Hidden:  true,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    cfdflags.NetworkChangeReconnect,
+			EnvVars: []string{"TUNNEL_NETWORK_CHANGE_RECONNECT"},
+			Usage:   "Reconnect the connections to the edge right away when a change of the interfaces or default routes of the host, such as switching Wi-Fi networks, breaks them.",
+			Value:   true,
+		}),
 		altsrc.NewIntFlag(&cli.IntFlag{
 			Name:    cfdflags.QuicConnLevelFlowControlLimit,
 			EnvVars: []string{"TUNNEL_QUIC_CONN_LEVEL_FLOW_CONTROL_LIMIT"},
@@ -792,6 +1192,53 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 			Value:   6 * (1 << 20), // 6 MB
 			Hidden:  true,
 		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.UDPFlowPacketsPerSecond,
+			EnvVars: []string{"TUNNEL_UDP_FLOW_PACKETS_PER_SECOND"},
+			Usage:   "Drops the datagrams of a UDP flow over this many packets per second, in either direction. 0 means unlimited.",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.UDPFlowBytesPerSecond,
+			EnvVars: []string{"TUNNEL_UDP_FLOW_BYTES_PER_SECOND"},
+			Usage:   "Drops the datagrams of a UDP flow over this many bytes per second, in either direction. 0 means unlimited.",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.UDPGlobalPacketsPerSecond,
+			EnvVars: []string{"TUNNEL_UDP_GLOBAL_PACKETS_PER_SECOND"},
+			Usage:   "Drops the datagrams of the UDP flows over this many packets per second for all the flows together. 0 means unlimited.",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.UDPGlobalBytesPerSecond,
+			EnvVars: []string{"TUNNEL_UDP_GLOBAL_BYTES_PER_SECOND"},
+			Usage:   "Drops the datagrams of the UDP flows over this many bytes per second for all the flows together. 0 means unlimited.",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.UDPMaxFlowsPerConnection,
+			EnvVars: []string{"TUNNEL_UDP_MAX_FLOWS_PER_CONNECTION"},
+			Usage:   "Rejects new UDP flows registered through an edge connection that already has this many flows. This is a limit per edge connection, not per eyeball, since an edge connection carries the flows of many eyeballs. 0 means unlimited.",
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.UDPFlowMigrationGracePeriod,
+			EnvVars: []string{"TUNNEL_UDP_FLOW_MIGRATION_GRACE_PERIOD"},
+			Usage:   "How long the UDP flows of an edge connection that goes away wait to be migrated to another edge connection before being closed. 0 closes them along with their connection.",
+			Value:   10 * time.Second,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.UDPDemuxWorkers,
+			EnvVars: []string{"TUNNEL_UDP_DEMUX_WORKERS"},
+			Usage:   "Number of workers processing the UDP and ICMP datagrams received from each edge connection. 0 uses the number of CPUs.",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.UDPDemuxQueueDepth,
+			EnvVars: []string{"TUNNEL_UDP_DEMUX_QUEUE_DEPTH"},
+			Usage:   "Number of datagrams received from an edge connection waiting for a worker before new ones are dropped. 0 uses 1024.",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.UDPSessionManager,
+			EnvVars: []string{"TUNNEL_UDP_SESSION_MANAGER"},
+			Usage:   "Implementation holding the UDP flows: default, or sharded to spread them over shards with their own locks, for hosts with more than 100k concurrent flows.",
+			Value:   "default",
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:  cfdflags.ConnectorLabel,
 			Usage: "Use this option to give a meaningful label to a specific connector. When a tunnel starts up, a connector id unique to the tunnel is generated. This is a uuid. To make it easier to identify a connector, we will use the hostname of the machine the tunnel is running on along with the connector ID. This option exists if one wants to have more control over what their individual connectors are called.",
@@ -864,12 +1311,206 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 			Aliases: []string{"pq"},
 			EnvVars: []string{"TUNNEL_POST_QUANTUM"},
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.PostQuantumMode,
+			Usage:   "Post-quantum key agreement of the connections to the edge, prefer or strict, optionally by transport protocol, e.g. quic=strict,http2=prefer. Strict is only supported by quic, and strict for every protocol is the same as --post-quantum.",
+			EnvVars: []string{"TUNNEL_POST_QUANTUM_MODE"},
+		}),
 		altsrc.NewBoolFlag(&cli.BoolFlag{
 			Name:    "management-diagnostics",
 			Usage:   "Enables the in-depth diagnostic routes to be made available over the management service (/debug/pprof, /metrics, etc.)",
 			EnvVars: []string{"TUNNEL_MANAGEMENT_DIAGNOSTICS"},
 			Value:   true,
 		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.HealthMinConnections,
+			Usage:   "Number of connections to the edge needed for /readyz of the metrics server to succeed.",
+			EnvVars: []string{"TUNNEL_HEALTH_MIN_CONNECTIONS"},
+			Value:   1,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.HealthMaxTimeSinceConnected,
+			Usage:   "How long cloudflared can have less than --health-min-connections since a connection was last registered before /healthz of the metrics server fails. 0 never fails /healthz.",
+			EnvVars: []string{"TUNNEL_HEALTH_MAX_TIME_SINCE_CONNECTED"},
+			Value:   5 * time.Minute,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    cfdflags.HealthRequireRemoteConfig,
+			Usage:   "Fail /readyz of the metrics server until the configuration of a remotely managed tunnel is received from the edge.",
+			EnvVars: []string{"TUNNEL_HEALTH_REQUIRE_REMOTE_CONFIG"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.OTLPEndpoint,
+			Usage:   "Export the OpenTelemetry spans of the proxied requests to the OTLP collector at this URL, over HTTP. e.g. http://localhost:4318/v1/traces",
+			EnvVars: []string{"TUNNEL_OTLP_ENDPOINT"},
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    cfdflags.OTLPHeaders,
+			Usage:   "Headers added to the requests to the OTLP collector, as name=value. Can be specified multiple times.",
+			EnvVars: []string{"TUNNEL_OTLP_HEADERS"},
+		}),
+		altsrc.NewFloat64Flag(&cli.Float64Flag{
+			Name:    cfdflags.OTLPSampleRatio,
+			Usage:   "Ratio of the requests, between 0 and 1, whose spans are exported to the OTLP collector. Requests with a sampled traceparent are always exported.",
+			EnvVars: []string{"TUNNEL_OTLP_SAMPLE_RATIO"},
+			Value:   1,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.AccessLogFile,
+			Usage:   "Write an access log entry, as JSON, for every HTTP request, TCP stream and UDP session proxied to an origin to this file.",
+			EnvVars: []string{"TUNNEL_ACCESS_LOG_FILE"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.AccessLogMaxSize,
+			Usage:   "Size in megabytes of the access log file before it's rotated.",
+			EnvVars: []string{"TUNNEL_ACCESS_LOG_MAX_SIZE"},
+			Value:   100,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.AccessLogMaxBackups,
+			Usage:   "Number of rotated access log files to keep. 0 keeps all of them.",
+			EnvVars: []string{"TUNNEL_ACCESS_LOG_MAX_BACKUPS"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.AccessLogMaxAge,
+			Usage:   "Number of days to keep the rotated access log files. 0 keeps them regardless of their age.",
+			EnvVars: []string{"TUNNEL_ACCESS_LOG_MAX_AGE"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    cfdflags.AccessLogSyslog,
+			Usage:   "Write the access log entries to the local syslog daemon. Not supported on Windows.",
+			EnvVars: []string{"TUNNEL_ACCESS_LOG_SYSLOG"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.AccessLogRingSize,
+			Usage:   "Number of access log entries kept in memory, readable from /access_logs of the management service. 0 disables it.",
+			EnvVars: []string{"TUNNEL_ACCESS_LOG_RING_SIZE"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.AccountingFile,
+			Usage:   "Count the requests, flows and bytes proxied by the tunnel, persisting the usage to this file every minute.",
+			EnvVars: []string{"TUNNEL_ACCOUNTING_FILE"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.AccountingPeriod,
+			Usage:   "Period after which the usage is reset: day or month.",
+			EnvVars: []string{"TUNNEL_ACCOUNTING_PERIOD"},
+			Value:   string(accounting.PeriodMonth),
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.AccountingQuotaBytes,
+			Usage:   "Soft quota of the bytes proxied in a period. 0 means unlimited.",
+			EnvVars: []string{"TUNNEL_ACCOUNTING_QUOTA_BYTES"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.AccountingQuotaRequests,
+			Usage:   "Soft quota of the HTTP requests and TCP and UDP flows proxied in a period. 0 means unlimited.",
+			EnvVars: []string{"TUNNEL_ACCOUNTING_QUOTA_REQUESTS"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.AccountingQuotaAction,
+			Usage:   "What happens once a quota is exceeded: warn logs a warning, throttle also rejects new requests and flows until the next period.",
+			EnvVars: []string{"TUNNEL_ACCOUNTING_QUOTA_ACTION"},
+			Value:   string(accounting.QuotaWarn),
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.MemorySoftLimit,
+			Usage:   "Memory, in bytes, above which cloudflared sheds load until it drops back below 90% of it: new UDP sessions are rejected, the requests of the ingress rules with a low priority are answered with 503 and the buffer pools are emptied. It's also the memory limit of the Go runtime, unless GOMEMLIMIT is set. 0 disables it.",
+			EnvVars: []string{"TUNNEL_MEMORY_SOFT_LIMIT"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.LeakCheckInterval,
+			Usage:   "Time between the audits of the goroutines, UDP sessions, streams and connections of cloudflared for leaks. Counts growing while the load doesn't are logged with stack samples, the last audit is served at /debug/leaks of the metrics server. 0 disables it.",
+			EnvVars: []string{"TUNNEL_LEAK_CHECK_INTERVAL"},
+			Value:   time.Minute,
+		}),
+		altsrc.NewFloat64Flag(&cli.Float64Flag{
+			Name:    cfdflags.PanicReportSampleRate,
+			Usage:   "Share, between 0 and 1, of the panics recovered from by the goroutines of cloudflared that are reported to Sentry, tagged with their subsystem and connection. The panics are always logged and counted.",
+			EnvVars: []string{"TUNNEL_PANIC_REPORT_SAMPLE_RATE"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.ControlHeartbeatInterval,
+			Usage:   "Time between the heartbeats sent to the edge on the control stream of each connection. Their round trip time is exported as a metric, and a connection whose heartbeats go unanswered is dropped and dialed again before the transport notices. 0 disables them.",
+			EnvVars: []string{"TUNNEL_CONTROL_HEARTBEAT_INTERVAL"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.ControlHeartbeatLossWindow,
+			Usage:   "How long the edge can leave the heartbeats of a connection unanswered before it's dropped and dialed again. Defaults to three heartbeat intervals.",
+			EnvVars: []string{"TUNNEL_CONTROL_HEARTBEAT_LOSS_WINDOW"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.ControlSocket,
+			Usage:   "Serve a local API to observe and control cloudflared on the Unix socket at this path, only accessible to the user running cloudflared.",
+			EnvVars: []string{"TUNNEL_CONTROL_SOCKET"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    cfdflags.EdgePrecheck,
+			Usage:   "Before connecting, test QUIC handshakes over UDP/7844 and TLS handshakes over TCP/7844 against a few edge addresses of each region, and log whether the network blocks the edge.",
+			EnvVars: []string{"TUNNEL_EDGE_PRECHECK"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.EdgeDSCP,
+			Usage:   "Mark the packets of the connections to the edge with DSCP code points, as comma separated values optionally prefixed with the protocol and connection index they apply to, e.g. af41,quic=ef,http2:0=cs3. Not supported on Windows.",
+			EnvVars: []string{"TUNNEL_EDGE_DSCP"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.EdgeSocketMark,
+			Usage:   "Set this firewall mark (SO_MARK), in decimal or hexadecimal, on the sockets of the connections to the edge, so that ip rules can route them, e.g. outside of a VPN. Linux only, requires CAP_NET_ADMIN.",
+			EnvVars: []string{"TUNNEL_EDGE_SOCKET_MARK"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.EdgeBindInterface,
+			Usage:   "Bind the sockets of the connections to the edge to this network interface (SO_BINDTODEVICE), so that they leave through it whatever the routing table. Linux only.",
+			EnvVars: []string{"TUNNEL_EDGE_BIND_INTERFACE"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.ServiceUnhealthyTimeout,
+			Usage:   "Once no connection to the edge has been registered for this long, the Windows service exits with an error so that it's restarted, and the systemd watchdog isn't kept alive anymore. 0 disables it.",
+			Value:   5 * time.Minute,
+			EnvVars: []string{"TUNNEL_SERVICE_UNHEALTHY_TIMEOUT"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    cfdflags.KubernetesMode,
+			Usage:   fmt.Sprintf("Run as a Kubernetes workload: exit with code 0 once drained cleanly, %d when no connection was ever registered and %d when the tunnel credentials are invalid, and print a Kubernetes Event as a JSON line on stdout on these transitions.", exitCodeNeverConnected, exitCodeInvalidCredentials),
+			EnvVars: []string{"TUNNEL_KUBERNETES_MODE"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.PreStopDelay,
+			Usage:   "On SIGTERM, keep serving for this long before unregistering the connections and draining, so that the replacing connector can register its own first. The termination grace period of the pod must cover it and the grace period.",
+			EnvVars: []string{"TUNNEL_PRE_STOP_DELAY"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.BackpressureHighWatermark,
+			Usage:   "Once the requests and streams proxied to the origin of an ingress rule, or the private network flows, hold this many bytes read from the edge or the origins but not written to the other side yet, stop reading them until it drops below --backpressure-low-watermark, so that flow control slows the senders down. 0 disables it.",
+			EnvVars: []string{"TUNNEL_BACKPRESSURE_HIGH_WATERMARK"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.BackpressureLowWatermark,
+			Usage:   "Count of buffered bytes below which the proxy reads from the edge and the origins again after reaching --backpressure-high-watermark. Defaults to half the high watermark.",
+			EnvVars: []string{"TUNNEL_BACKPRESSURE_LOW_WATERMARK"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.StateFile,
+			Usage:   "Persist the edge addresses, protocol and TLS sessions of the connections to this file, so that cloudflared reconnects to the same edge addresses with the protocol that worked when it restarts.",
+			EnvVars: []string{"TUNNEL_STATE_FILE"},
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    cfdflags.EventSink,
+			Usage:   "Publish the connection events to this URL: an http(s) webhook, a Kafka topic through a REST proxy as kafka+http(s)://<proxy>/<topic> or a NATS subject as nats://[user:password@]<server>/<subject>. Can be repeated.",
+			EnvVars: []string{"TUNNEL_EVENT_SINK"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.EventSinkTemplate,
+			Usage:   "Go template rendering the payloads of the connection events, given the fields of the default JSON payload, e.g. '{\"text\": \"{{.Hostname}} connection {{.ConnIndex}} {{.Event}}\"}'.",
+			EnvVars: []string{"TUNNEL_EVENT_SINK_TEMPLATE"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.DNSRouteVerification,
+			Usage:   "Cross-check the ingress rule hostnames against the DNS routes of the tunnel when the configuration is loaded. Requires an origin certificate. {off, warn, strict}",
+			EnvVars: []string{"TUNNEL_DNS_ROUTE_VERIFICATION"},
+			Value:   dnsRouteVerificationOff,
+		}),
 		selectProtocolFlag,
 		overwriteDNSFlag,
 	}...)
@@ -919,6 +1560,49 @@ and virtualized host network stacks from each other`,
 			EnvVars: []string{"TUNNEL_METRICS"},
 			Hidden:  shouldHide,
 		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    cfdflags.MetricsDropLabel,
+			Usage:   "Drop labels from the metric families matching a glob, aggregating their series, e.g. cloudflared_tunnel_origin_*=rule or cloudflared_quic_client_*=conn_index. Can be repeated.",
+			EnvVars: []string{"TUNNEL_METRICS_DROP_LABEL"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    cfdflags.MetricsLabelAllowlist,
+			Usage:   "Only keep these values of a label, collapsing the others into \"other\", e.g. rule=0,1,2. Can be repeated.",
+			EnvVars: []string{"TUNNEL_METRICS_LABEL_ALLOWLIST"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    cfdflags.MetricsDebug,
+			Usage:   "Serve pprof, expvar and request traces under /debug/ of the metrics server, along with the diagnostics bundle and the CLI configuration under /diag/. They can also be enabled and disabled at runtime through the control API.",
+			EnvVars: []string{"TUNNEL_METRICS_DEBUG"},
+			Value:   true,
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.MetricsDebugToken,
+			Usage:   "Require this bearer token to reach the debug endpoints of the metrics server, including the diagnostics bundle and the CLI configuration.",
+			EnvVars: []string{"TUNNEL_METRICS_DEBUG_TOKEN"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.MetricsTLSCert,
+			Usage:   "Serve the metrics server over TLS with this certificate.",
+			EnvVars: []string{"TUNNEL_METRICS_TLS_CERT"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.MetricsTLSKey,
+			Usage:   "Private key of --metrics-tls-cert.",
+			EnvVars: []string{"TUNNEL_METRICS_TLS_KEY"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.MetricsClientCA,
+			Usage:   "Let the clients presenting a certificate issued by this CA reach the debug endpoints of the metrics server. Requires --metrics-tls-cert.",
+			EnvVars: []string{"TUNNEL_METRICS_CLIENT_CA"},
+			Hidden:  shouldHide,
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    "pidfile",
 			Usage:   "Write the application's PID to this file after first successful connection.",
@@ -1214,6 +1898,44 @@ func configureProxyDNSFlags(shouldHide bool) []cli.Flag {
 	}
 }
 
+// reregisterConnections reconnects the connections one at a time, so that they register with rotated credentials
+// while the others keep serving.
+func reregisterConnections(ctx context.Context, reconnectCh chan<- supervisor.ReconnectSignal, haConnections int, log *zerolog.Logger) {
+	log.Info().Msg("Re-registering the tunnel connections with the rotated credentials")
+	for i := 0; i < haConnections; i++ {
+		// nolint: gosec
+		connIndex := uint8(i)
+		if supervisor.SendReconnect(ctx, reconnectCh, supervisor.ReconnectSignal{Target: &connIndex, Reason: "credentials rotated"}) != nil {
+			return
+		}
+		select {
+		case <-time.After(credentialsReregisterInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// bundleEdgeAddrs resolves the edge addresses the connections would use, for diagnostics bundles to test their
+// reachability.
+func bundleEdgeAddrs(config *supervisor.TunnelConfig, log *zerolog.Logger) func() ([]*net.TCPAddr, error) {
+	return func() ([]*net.TCPAddr, error) {
+		edge, err := edgediscovery.ResolveEdge(log, config.Region, config.EdgeIPVersion)
+		if err != nil {
+			return nil, err
+		}
+		addrs := make([]*net.TCPAddr, 0, config.HAConnections)
+		for i := 0; i < config.HAConnections; i++ {
+			addr, err := edge.GetAddr(i)
+			if err != nil {
+				break
+			}
+			addrs = append(addrs, addr.TCP)
+		}
+		return addrs, nil
+	}
+}
+
 func stdinControl(reconnectCh chan supervisor.ReconnectSignal, log *zerolog.Logger) {
 	for {
 		scanner := bufio.NewScanner(os.Stdin)
@@ -1225,7 +1947,7 @@ func stdinControl(reconnectCh chan supervisor.ReconnectSignal, log *zerolog.Logg
 			case "":
 				break
 			case "reconnect":
-				var reconnect supervisor.ReconnectSignal
+				reconnect := supervisor.ReconnectSignal{Reason: "requested on stdin"}
 				if len(parts) > 1 {
 					var err error
 					if reconnect.Delay, err = time.ParseDuration(parts[1]); err != nil {
@@ -1233,7 +1955,7 @@ func stdinControl(reconnectCh chan supervisor.ReconnectSignal, log *zerolog.Logg
 						continue
 					}
 				}
-				log.Info().Msgf("Sending %+v", reconnect)
+				log.Info().Msgf("Sending reconnect signal to %s", reconnect)
 				reconnectCh <- reconnect
 			default:
 				log.Info().Str(LogFieldCommand, command).Msg("Unknown command")
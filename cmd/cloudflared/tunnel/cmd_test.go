@@ -1,6 +1,8 @@
 package tunnel
 
 import (
+	"net"
+	"net/netip"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -15,3 +17,28 @@ func TestHostnameFromURI(t *testing.T) {
 	assert.Equal(t, "", hostnameFromURI("trash"))
 	assert.Equal(t, "", hostnameFromURI("https://awesomesauce.com"))
 }
+
+func TestPickICMPv6Src(t *testing.T) {
+	interfaces := []interfaceIP{
+		{name: "eth0", ip: net.ParseIP("fe80::1")},
+		{name: "eth1", ip: net.ParseIP("fd00::1")},
+		{name: "eth0", ip: net.ParseIP("2001:db8::1")},
+	}
+	addr, zone, ok := pickICMPv6Src(interfaces, "eth0")
+	assert.True(t, ok)
+	assert.Equal(t, netip.MustParseAddr("2001:db8::1"), addr)
+	assert.Equal(t, "eth0", zone)
+
+	// Unique local addresses reach the private ranges
+	addr, zone, ok = pickICMPv6Src(interfaces, "eth2")
+	assert.True(t, ok)
+	assert.Equal(t, netip.MustParseAddr("fd00::1"), addr)
+	assert.Equal(t, "eth1", zone)
+
+	// Link-local and loopback addresses aren't picked
+	_, _, ok = pickICMPv6Src([]interfaceIP{
+		{name: "eth0", ip: net.ParseIP("fe80::1")},
+		{name: "lo", ip: net.ParseIP("::1")},
+	}, "eth0")
+	assert.False(t, ok)
+}
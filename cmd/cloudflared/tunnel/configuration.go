@@ -2,10 +2,13 @@ package tunnel
 
 import (
 	"context"
+	"crypto/ed25519"
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"net"
 	"net/netip"
+	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -17,26 +20,46 @@ import (
 	"github.com/urfave/cli/v2/altsrc"
 	"golang.org/x/term"
 
+	"github.com/cloudflare/cloudflared/accesslog"
+	"github.com/cloudflare/cloudflared/accounting"
+	"github.com/cloudflare/cloudflared/cfio"
 	"github.com/cloudflare/cloudflared/client"
 	"github.com/cloudflare/cloudflared/cmd/cloudflared/cliutil"
 	"github.com/cloudflare/cloudflared/cmd/cloudflared/flags"
 	"github.com/cloudflare/cloudflared/config"
 	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/datagramsession"
+	"github.com/cloudflare/cloudflared/dscp"
 	"github.com/cloudflare/cloudflared/edgediscovery"
 	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+	"github.com/cloudflare/cloudflared/eventsink"
 	"github.com/cloudflare/cloudflared/features"
+	cfdflow "github.com/cloudflare/cloudflared/flow"
 	"github.com/cloudflare/cloudflared/ingress"
 	"github.com/cloudflare/cloudflared/ingress/origins"
+	"github.com/cloudflare/cloudflared/leakcheck"
+	"github.com/cloudflare/cloudflared/memlimit"
+	"github.com/cloudflare/cloudflared/metrics"
 	"github.com/cloudflare/cloudflared/orchestration"
+	v3 "github.com/cloudflare/cloudflared/quic/v3"
+	"github.com/cloudflare/cloudflared/retry"
+	"github.com/cloudflare/cloudflared/sockmark"
 	"github.com/cloudflare/cloudflared/supervisor"
 	"github.com/cloudflare/cloudflared/tlsconfig"
+	"github.com/cloudflare/cloudflared/tracing"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
+	"github.com/cloudflare/cloudflared/tunnelstate"
 )
 
 const (
 	secretValue       = "*****"
 	icmpFunnelTimeout = time.Second * 10
-	fedRampRegion     = "fed" // const string denoting the region used to connect to FEDRamp servers
+	// Addresses of the Cloudflare DNS resolver used to check the routes of the host, no packet is sent to them
+	ipv4RouteProbeAddr    = "1.1.1.1:53"
+	ipv6RouteProbeAddr    = "[2606:4700:4700::1111]:53"
+	localNAT64Auto        = "auto"
+	nat64DiscoveryTimeout = 5 * time.Second
+	fedRampRegion         = "fed" // const string denoting the region used to connect to FEDRamp servers
 )
 
 var (
@@ -129,6 +152,17 @@ func prepareTunnelConfig(
 ) (*supervisor.TunnelConfig, *orchestration.Config, error) {
 	transportProtocol := c.String(flags.Protocol)
 	isPostQuantumEnforced := c.Bool(flags.PostQuantum)
+	pqModes, err := features.ParsePostQuantumModes(c.String(flags.PostQuantumMode))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid --%s", flags.PostQuantumMode)
+	}
+	if mode, ok := pqModes[""]; ok {
+		isPostQuantumEnforced = isPostQuantumEnforced || mode == features.PostQuantumStrict
+		delete(pqModes, "")
+	}
+	if pqModes[connection.HTTP2.String()] == features.PostQuantumStrict {
+		return nil, nil, fmt.Errorf("post-quantum is only supported with the quic transport")
+	}
 	featureSelector, err := features.NewFeatureSelector(ctx, namedTunnel.Credentials.AccountTag, c.StringSlice(flags.Features), isPostQuantumEnforced, log)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "Failed to create feature selector")
@@ -170,6 +204,85 @@ func prepareTunnelConfig(
 	}
 	log.Info().Msgf("Initial protocol %s", protocolSelector.Current())
 
+	var edgeTrust *tlsconfig.EdgeTrust
+	if c.String(tlsconfig.EdgeCABundleFlag) != "" || c.String(tlsconfig.EdgeSPKIPinsFlag) != "" {
+		edgeTrust, err = tlsconfig.NewEdgeTrust(c.String(tlsconfig.EdgeCABundleFlag), c.String(tlsconfig.EdgeSPKIPinsFlag), log)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	retryJitter, err := retry.ParseJitter(c.String(flags.RetryJitter))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid --%s", flags.RetryJitter)
+	}
+	retryStrategy := retry.Strategy{
+		BaseTime:   c.Duration(flags.RetryBaseTime),
+		MaxTime:    c.Duration(flags.RetryMaxTime),
+		Multiplier: c.Float64(flags.RetryMultiplier),
+		Jitter:     retryJitter,
+	}
+	if err := retryStrategy.Validate(); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid retry backoff")
+	}
+
+	registration := supervisor.RegistrationConfig{
+		Parallelism:      c.Int(flags.HaParallelism),
+		ReadyConnections: c.Int(flags.HaReadyConnections),
+		ReadyDeadline:    c.Duration(flags.HaReadyDeadline),
+	}
+	if err := registration.Validate(); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid HA connections registration")
+	}
+
+	watchdog := supervisor.WatchdogConfig{
+		FlappingPeriod:     c.Duration(flags.WatchdogFlappingPeriod),
+		ConfigApplyTimeout: c.Duration(flags.WatchdogConfigApplyTimeout),
+		MaxGoroutines:      c.Int(flags.WatchdogMaxGoroutines),
+		MaxRestarts:        c.Int(flags.WatchdogMaxRestarts),
+	}
+	if err := watchdog.Validate(); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid watchdog")
+	}
+
+	protocolRecovery := supervisor.ProtocolRecoveryConfig{
+		Interval:  c.Duration(flags.ProtocolRecoveryInterval),
+		Successes: c.Int(flags.ProtocolRecoverySuccesses),
+	}
+	if err := protocolRecovery.Validate(); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid protocol recovery")
+	}
+
+	protocolOverrides, err := supervisor.ParseProtocolOverrides(c.StringSlice(flags.ProtocolOverride))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid --%s", flags.ProtocolOverride)
+	}
+	if isPostQuantumEnforced {
+		for connIndex, protocol := range protocolOverrides.All() {
+			if protocol != connection.QUIC.String() {
+				return nil, nil, fmt.Errorf("connection %d can't be forced to %s, post-quantum is only supported with the quic transport", connIndex, protocol)
+			}
+		}
+	}
+
+	var stateFile *tunnelstate.StateFile
+	if path := c.String(flags.StateFile); path != "" {
+		stateFile, err = tunnelstate.NewStateFile(path, namedTunnel.Credentials.TunnelID, tunnelstate.StateFileMaxAge, log)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid --%s", flags.StateFile)
+		}
+		observer.RegisterSink(stateFile)
+	}
+
+	eventSinks, err := newEventSinks(c, eventsink.Metadata{TunnelID: namedTunnel.Credentials.TunnelID, ConnectorID: clientConfig.ConnectorID}, log)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, sink := range eventSinks {
+		observer.RegisterSink(sink)
+		go sink.Run(ctx)
+	}
+
 	edgeTLSConfigs := make(map[connection.Protocol]*tls.Config, len(connection.ProtocolList))
 	for _, p := range connection.ProtocolList {
 		tlsSettings := p.TLSSettings()
@@ -183,6 +296,12 @@ func prepareTunnelConfig(
 		if len(tlsSettings.NextProtos) > 0 {
 			edgeTLSConfig.NextProtos = tlsSettings.NextProtos
 		}
+		if edgeTrust != nil {
+			edgeTrust.Apply(edgeTLSConfig)
+		}
+		if stateFile != nil {
+			edgeTLSConfig.ClientSessionCache = stateFile.SessionCache()
+		}
 		edgeTLSConfigs[p] = edgeTLSConfig
 	}
 
@@ -206,6 +325,13 @@ func prepareTunnelConfig(
 		// This is not a fatal error, we just overrode edgeIPVersion
 		log.Warn().Str("edgeIPVersion", edgeIPVersion.String()).Err(err).Msg("Overriding edge-ip-version")
 	}
+	// IPv6-only hosts, e.g. in IPv6-only Kubernetes clusters, can only reach the AAAA records of the edge. The default
+	// IPv4 edge is only kept if it was explicitly asked for.
+	ipVersionDetectable := edgeIPVersion == allregions.Auto || !c.IsSet(flags.EdgeIpVersion)
+	if ipVersionDetectable && edgeBindAddr == nil && !hasRoute("udp4", ipv4RouteProbeAddr) && hasRoute("udp6", ipv6RouteProbeAddr) {
+		log.Info().Msg("The host has no IPv4 route, connecting to the edge over IPv6")
+		edgeIPVersion = allregions.IPv6Only
+	}
 
 	region := c.String(flags.Region)
 	endpoint := namedTunnel.Credentials.Endpoint
@@ -220,13 +346,45 @@ func prepareTunnelConfig(
 	} else if endpoint != "" {
 		resolvedRegion = endpoint
 	}
+	regionFailoverWindow := c.Duration(flags.RegionFailoverWindow)
+	if regionFailoverWindow < 0 {
+		return nil, nil, fmt.Errorf("%s can't be negative", flags.RegionFailoverWindow)
+	}
+	if regionFailoverWindow > 0 && resolvedRegion == fedRampRegion {
+		return nil, nil, fmt.Errorf("%s can't be used with the %s region", flags.RegionFailoverWindow, fedRampRegion)
+	}
+	if c.IsSet(flags.ConfigHistorySize) && c.Int(flags.ConfigHistorySize) < 1 {
+		return nil, nil, fmt.Errorf("%s must be at least 1", flags.ConfigHistorySize)
+	}
+	edgeBootstrap, err := newEdgeBootstrap(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	allregions.SetBootstrap(edgeBootstrap)
 
+	if err := ingress.ValidateQoSRules(cfg.WarpRouting.QoS); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid warp-routing configuration")
+	}
 	warpRoutingConfig := ingress.NewWarpRoutingConfig(&cfg.WarpRouting)
+	icmpPolicy, err := ingress.NewICMPPolicy(cfg.WarpRouting.ICMP)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid warp-routing configuration")
+	}
+	nat64, err := ingress.NewNAT64(cfg.WarpRouting.NAT64Prefix)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid warp-routing configuration")
+	}
+	localNAT64, err := newLocalNAT64(cfg.WarpRouting.LocalNAT64Prefix, log)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid warp-routing configuration")
+	}
 
 	// Setup origin dialer service and virtual services
 	originDialerService := ingress.NewOriginDialer(ingress.OriginConfig{
 		DefaultDialer:   ingress.NewDialer(warpRoutingConfig),
 		TCPWriteTimeout: c.Duration(flags.WriteStreamTimeout),
+		NAT64:           nat64,
+		LocalNAT64:      localNAT64,
 	}, log)
 
 	// Setup DNS Resolver Service
@@ -240,25 +398,66 @@ func prepareTunnelConfig(
 		}
 		dnsService = origins.NewStaticDNSResolverService(addrs, origins.NewDNSDialer(), log, originMetrics)
 	}
+	if c.Int(flags.VirtualDNSServiceCacheSize) < 0 {
+		return nil, nil, fmt.Errorf("%s can't be negative", flags.VirtualDNSServiceCacheSize)
+	}
+	dnsService.Configure(origins.DNSResolverConfig{
+		UpstreamTimeout: c.Duration(flags.VirtualDNSServiceResolverTimeout),
+		CacheSize:       c.Int(flags.VirtualDNSServiceCacheSize),
+		CacheMinTTL:     c.Duration(flags.VirtualDNSServiceCacheMinTTL),
+		CacheMaxTTL:     c.Duration(flags.VirtualDNSServiceCacheMaxTTL),
+		DNS64:           nat64,
+	})
 	originDialerService.AddReservedService(dnsService, []netip.AddrPort{origins.VirtualDNSServiceAddr})
 
+	udpDemux, err := parseUDPDemuxConfig(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	udpSessionLimits, err := parseUDPSessionLimits(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	udpSessionManager, err := v3.ParseSessionManagerKind(c.String(flags.UDPSessionManager))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	edgeDSCP, err := dscp.Parse(c.String(flags.EdgeDSCP))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid --%s", flags.EdgeDSCP)
+	}
+	edgeSocketMark, err := sockmark.ParseMark(c.String(flags.EdgeSocketMark))
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid --%s", flags.EdgeSocketMark)
+	}
+	edgeRouting := sockmark.Options{Mark: edgeSocketMark, Interface: c.String(flags.EdgeBindInterface)}
+	if err := edgeRouting.Validate(); err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid --%s or --%s", flags.EdgeSocketMark, flags.EdgeBindInterface)
+	}
+
 	tunnelConfig := &supervisor.TunnelConfig{
-		ClientConfig:    clientConfig,
-		GracePeriod:     gracePeriod,
-		EdgeAddrs:       c.StringSlice(flags.Edge),
-		Region:          resolvedRegion,
-		EdgeIPVersion:   edgeIPVersion,
-		EdgeBindAddr:    edgeBindAddr,
-		HAConnections:   c.Int(flags.HaConnections),
-		IsAutoupdated:   c.Bool(flags.IsAutoUpdated),
-		LBPool:          c.String(flags.LBPool),
-		Tags:            tags,
-		Log:             log,
-		LogTransport:    logTransport,
-		Observer:        observer,
-		ReportedVersion: info.Version(),
+		ClientConfig:         clientConfig,
+		GracePeriod:          gracePeriod,
+		EdgeAddrs:            c.StringSlice(flags.Edge),
+		Region:               resolvedRegion,
+		EdgeIPVersion:        edgeIPVersion,
+		EdgeBindAddr:         edgeBindAddr,
+		HAConnections:        c.Int(flags.HaConnections),
+		Registration:         registration,
+		Watchdog:             watchdog,
+		ProtocolRecovery:     protocolRecovery,
+		RegionFailoverWindow: regionFailoverWindow,
+		IsAutoupdated:        c.Bool(flags.IsAutoUpdated),
+		LBPool:               c.String(flags.LBPool),
+		Tags:                 tags,
+		Log:                  log,
+		LogTransport:         logTransport,
+		Observer:             observer,
+		ReportedVersion:      info.Version(),
 		// Note TUN-3758 , we use Int because UInt is not supported with altsrc
 		Retries:                             uint(c.Int(flags.Retries)), // nolint: gosec
+		RetryStrategy:                       retryStrategy,
 		RunFromTerminal:                     isRunningFromTerminal(),
 		NamedTunnel:                         namedTunnel,
 		ProtocolSelector:                    protocolSelector,
@@ -267,26 +466,252 @@ func prepareTunnelConfig(
 		RPCTimeout:                          c.Duration(flags.RpcTimeout),
 		WriteStreamTimeout:                  c.Duration(flags.WriteStreamTimeout),
 		DisableQUICPathMTUDiscovery:         c.Bool(flags.QuicDisablePathMTUDiscovery),
+		NetworkChangeReconnect:              c.Bool(flags.NetworkChangeReconnect),
+		DisableQUICUDPOffload:               c.Bool(flags.QuicDisableUDPOffload),
+		EdgeDSCP:                            edgeDSCP,
+		EdgeRouting:                         edgeRouting,
+		EdgeTrust:                           edgeTrust,
+		StateFile:                           stateFile,
+		PostQuantumModes:                    pqModes,
+		ProtocolOverrides:                   protocolOverrides,
+		FeatureSnapshots:                    supervisor.NewFeatureSnapshots(),
 		QUICConnectionLevelFlowControlLimit: c.Uint64(flags.QuicConnLevelFlowControlLimit),
 		QUICStreamLevelFlowControlLimit:     c.Uint64(flags.QuicStreamLevelFlowControlLimit),
 		OriginDNSService:                    dnsService,
 		OriginDialerService:                 originDialerService,
+		UDPSessionLimits:                    udpSessionLimits,
+		UDPDemux:                            udpDemux,
+		UDPSessionManager:                   udpSessionManager,
+	}
+	tunnelConfig.Heartbeat = connection.HeartbeatConfig{
+		Interval:   c.Duration(flags.ControlHeartbeatInterval),
+		LossWindow: c.Duration(flags.ControlHeartbeatLossWindow),
 	}
-	icmpRouter, err := newICMPRouter(c, log)
+	icmpRouter, err := newICMPRouter(c, icmpPolicy, log)
 	if err != nil {
 		log.Warn().Err(err).Msg("ICMP proxy feature is disabled")
 	} else {
+		log.Info().Msgf("ICMP proxy uses %s", icmpRouter.Capability())
 		tunnelConfig.ICMPRouterServer = icmpRouter
 	}
+	meter, err := newAccountingMeter(c, log)
+	if err != nil {
+		return nil, nil, err
+	}
+	accessLog, err := newAccessLog(c, meter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if accessLog != nil {
+		cfdflow.SetRecorder(accessLog.LogFlow)
+	}
+	memory, err := newMemoryMonitor(c, log)
+	if err != nil {
+		return nil, nil, err
+	}
 	orchestratorConfig := &orchestration.Config{
 		Ingress:             &ingressRules,
 		WarpRouting:         warpRoutingConfig,
 		OriginDialerService: originDialerService,
 		ConfigurationFlags:  parseConfigFlags(c),
+		AccessLog:           accessLog,
+		Accounting:          meter,
+		Memory:              memory,
+		ConfigHistorySize:   c.Int(flags.ConfigHistorySize),
 	}
 	return tunnelConfig, orchestratorConfig, nil
 }
 
+// newAccessLog returns the HTTP access log configured by the flags, or nil if no sink is configured. The usage meter
+// is a sink too, when accounting is enabled.
+func newAccessLog(c *cli.Context, meter *accounting.Meter) (*accesslog.Logger, error) {
+	var sinks []accesslog.Sink
+	if meter != nil {
+		sinks = append(sinks, meter)
+	}
+	if path := c.String(flags.AccessLogFile); path != "" {
+		sink, err := accesslog.NewFileSink(accesslog.FileConfig{
+			Path:       path,
+			MaxSize:    c.Int(flags.AccessLogMaxSize),
+			MaxBackups: c.Int(flags.AccessLogMaxBackups),
+			MaxAge:     c.Int(flags.AccessLogMaxAge),
+		})
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if c.Bool(flags.AccessLogSyslog) {
+		sink, err := accesslog.NewSyslogSink("cloudflared")
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to write access logs to syslog")
+		}
+		sinks = append(sinks, sink)
+	}
+	if size := c.Int(flags.AccessLogRingSize); size > 0 {
+		sinks = append(sinks, accesslog.NewRing(size))
+	}
+	return accesslog.New(sinks...), nil
+}
+
+func parseUDPSessionLimits(c *cli.Context) (v3.SessionLimits, error) {
+	limits := make(map[string]uint64, 5)
+	for _, flag := range []string{
+		flags.UDPFlowPacketsPerSecond,
+		flags.UDPFlowBytesPerSecond,
+		flags.UDPGlobalPacketsPerSecond,
+		flags.UDPGlobalBytesPerSecond,
+		flags.UDPMaxFlowsPerConnection,
+	} {
+		value := c.Int(flag)
+		if value < 0 {
+			return v3.SessionLimits{}, fmt.Errorf("%s can't be negative", flag)
+		}
+		limits[flag] = uint64(value)
+	}
+	migrationGracePeriod := c.Duration(flags.UDPFlowMigrationGracePeriod)
+	if migrationGracePeriod < 0 {
+		return v3.SessionLimits{}, fmt.Errorf("%s can't be negative", flags.UDPFlowMigrationGracePeriod)
+	}
+	return v3.SessionLimits{
+		PacketsPerSecond:         limits[flags.UDPFlowPacketsPerSecond],
+		BytesPerSecond:           limits[flags.UDPFlowBytesPerSecond],
+		GlobalPacketsPerSecond:   limits[flags.UDPGlobalPacketsPerSecond],
+		GlobalBytesPerSecond:     limits[flags.UDPGlobalBytesPerSecond],
+		MaxSessionsPerConnection: limits[flags.UDPMaxFlowsPerConnection],
+		MigrationGracePeriod:     migrationGracePeriod,
+	}, nil
+}
+
+// newAccountingMeter returns the usage meter configured by the flags, or nil if accounting is disabled.
+func newAccountingMeter(c *cli.Context, log *zerolog.Logger) (*accounting.Meter, error) {
+	path := c.String(flags.AccountingFile)
+	if path == "" {
+		return nil, nil
+	}
+	period, err := accounting.ParsePeriod(c.String(flags.AccountingPeriod))
+	if err != nil {
+		return nil, err
+	}
+	action, err := accounting.ParseQuotaAction(c.String(flags.AccountingQuotaAction))
+	if err != nil {
+		return nil, err
+	}
+	quotaBytes, quotaRequests := c.Int(flags.AccountingQuotaBytes), c.Int(flags.AccountingQuotaRequests)
+	if quotaBytes < 0 {
+		return nil, fmt.Errorf("%s can't be negative", flags.AccountingQuotaBytes)
+	}
+	if quotaRequests < 0 {
+		return nil, fmt.Errorf("%s can't be negative", flags.AccountingQuotaRequests)
+	}
+	return accounting.New(accounting.Config{
+		Store:  accounting.NewFileStore(path),
+		Period: period,
+		Quota: accounting.Quota{
+			Bytes:    uint64(quotaBytes),
+			Requests: uint64(quotaRequests),
+			Action:   action,
+		},
+	}, log)
+}
+
+// newMemoryMonitor returns the monitor shedding load above the memory soft limit, or nil if there's none.
+func newMemoryMonitor(c *cli.Context, log *zerolog.Logger) (*memlimit.Monitor, error) {
+	limit := c.Int(flags.MemorySoftLimit)
+	if limit < 0 {
+		return nil, fmt.Errorf("%s can't be negative", flags.MemorySoftLimit)
+	}
+	if limit == 0 {
+		return nil, nil
+	}
+	return memlimit.New(memlimit.Config{SoftLimit: uint64(limit)}, log), nil
+}
+
+// newLeakCheck returns the auditor comparing the UDP sessions, data streams and connections held with the flows and
+// connections they serve.
+func newLeakCheck(interval time.Duration, haConnections int, tracker *tunnelstate.ConnTracker, log *zerolog.Logger) *leakcheck.Auditor {
+	auditor := leakcheck.New(leakcheck.Config{Interval: interval}, log)
+	auditor.Register(leakcheck.Probe{
+		Subsystem: leakcheck.SubsystemDatagram,
+		Resource:  "udp_sessions",
+		Expected: func() int64 {
+			return cfdflow.Active.Counts()[cfdflow.KindUDP]
+		},
+		Actual: func() int64 {
+			return datagramsession.ActiveSessions() + v3.ActiveSessions()
+		},
+	})
+	auditor.Register(leakcheck.Probe{
+		Subsystem: leakcheck.SubsystemProxy,
+		Resource:  "data_streams",
+		Expected: func() int64 {
+			counts := cfdflow.Active.Counts()
+			return counts[cfdflow.KindHTTP] + counts[cfdflow.KindTCP]
+		},
+		Actual: connection.ActiveDataStreams,
+	})
+	auditor.Register(leakcheck.Probe{
+		Subsystem: leakcheck.SubsystemSupervisor,
+		Resource:  "connections",
+		Expected: func() int64 {
+			return int64(haConnections)
+		},
+		Actual: func() int64 {
+			return int64(tracker.CountActiveConns())
+		},
+	})
+	return auditor
+}
+
+// newMetricsLabelPolicy returns the policy lowering the cardinality of the metrics, or nil if no flag configures it.
+func newMetricsLabelPolicy(c *cli.Context) (*metrics.LabelPolicy, error) {
+	drops, allowlists := c.StringSlice(flags.MetricsDropLabel), c.StringSlice(flags.MetricsLabelAllowlist)
+	if len(drops) == 0 && len(allowlists) == 0 {
+		return nil, nil
+	}
+	return metrics.ParseLabelPolicy(drops, allowlists)
+}
+
+// newMetricsDebugEndpoints returns the debug endpoints of the metrics server and, when it's served over TLS, its TLS
+// configuration. Clients may present a certificate, which is only required to reach the debug endpoints.
+func newMetricsDebugEndpoints(c *cli.Context) (*metrics.DebugEndpoints, *tls.Config, error) {
+	cert, key, clientCA := c.String(flags.MetricsTLSCert), c.String(flags.MetricsTLSKey), c.String(flags.MetricsClientCA)
+	if (cert == "") != (key == "") {
+		return nil, nil, fmt.Errorf("--%s and --%s must be set together", flags.MetricsTLSCert, flags.MetricsTLSKey)
+	}
+	if clientCA != "" && cert == "" {
+		return nil, nil, fmt.Errorf("--%s requires --%s", flags.MetricsClientCA, flags.MetricsTLSCert)
+	}
+	var tlsConfig *tls.Config
+	if cert != "" {
+		params := &tlsconfig.TLSParameters{Cert: cert, Key: key, MinVersion: tls.VersionTLS12}
+		if clientCA != "" {
+			params.ClientCAs = []string{clientCA}
+		}
+		var err error
+		if tlsConfig, err = tlsconfig.GetConfig(params); err != nil {
+			return nil, nil, errors.Wrap(err, "invalid metrics server TLS configuration")
+		}
+		if clientCA != "" {
+			// Scrapers don't need a client certificate to reach /metrics
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	debug := metrics.NewDebugEndpoints(c.String(flags.MetricsDebugToken), clientCA != "", c.Bool(flags.MetricsDebug))
+	return debug, tlsConfig, nil
+}
+
+func parseUDPDemuxConfig(c *cli.Context) (v3.DemuxConfig, error) {
+	workers, queueDepth := c.Int(flags.UDPDemuxWorkers), c.Int(flags.UDPDemuxQueueDepth)
+	if workers < 0 {
+		return v3.DemuxConfig{}, fmt.Errorf("%s can't be negative", flags.UDPDemuxWorkers)
+	}
+	if queueDepth < 0 {
+		return v3.DemuxConfig{}, fmt.Errorf("%s can't be negative", flags.UDPDemuxQueueDepth)
+	}
+	return v3.DemuxConfig{Workers: workers, QueueDepth: queueDepth}, nil
+}
+
 func parseConfigFlags(c *cli.Context) map[string]string {
 	result := make(map[string]string)
 
@@ -307,6 +732,23 @@ func gracePeriod(c *cli.Context) (time.Duration, error) {
 	return period, nil
 }
 
+// backpressureWatermarks returns the watermarks of the budgets of the copies of each ingress rule, zero if they
+// aren't limited.
+func backpressureWatermarks(c *cli.Context) (cfio.Watermarks, error) {
+	high := c.Int(flags.BackpressureHighWatermark)
+	if high <= 0 {
+		return cfio.Watermarks{}, nil
+	}
+	watermarks := cfio.Watermarks{High: int64(high), Low: int64(high / 2)}
+	if c.IsSet(flags.BackpressureLowWatermark) {
+		watermarks.Low = int64(c.Int(flags.BackpressureLowWatermark))
+	}
+	if err := watermarks.Validate(); err != nil {
+		return cfio.Watermarks{}, errors.Wrapf(err, "invalid --%s or --%s", flags.BackpressureHighWatermark, flags.BackpressureLowWatermark)
+	}
+	return watermarks, nil
+}
+
 func isRunningFromTerminal() bool {
 	return term.IsTerminal(int(os.Stdout.Fd()))
 }
@@ -371,7 +813,58 @@ func adjustIPVersionByBindAddress(ipVersion allregions.ConfigIPVersion, ip net.I
 	}
 }
 
-func newICMPRouter(c *cli.Context, logger *zerolog.Logger) (ingress.ICMPRouterServer, error) {
+func newEventSinks(c *cli.Context, metadata eventsink.Metadata, log *zerolog.Logger) ([]*eventsink.Sink, error) {
+	urls := c.StringSlice(flags.EventSink)
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	tmpl, err := eventsink.ParseTemplate(c.String(flags.EventSinkTemplate))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid --%s", flags.EventSinkTemplate)
+	}
+	sinks := make([]*eventsink.Sink, 0, len(urls))
+	for _, url := range urls {
+		sink, err := eventsink.New(url, tmpl, metadata, log)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid --%s", flags.EventSink)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// hasRoute returns whether the host has a route to addr. Connecting a UDP socket picks the route without sending
+// anything.
+func hasRoute(network, addr string) bool {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// newLocalNAT64 returns the NAT64 prefix IPv4 origins are dialed through, if any. With "auto", it's discovered from
+// the DNS64 resolver of the host when the host has no IPv4 route.
+func newLocalNAT64(prefix string, log *zerolog.Logger) (*ingress.NAT64, error) {
+	if prefix != localNAT64Auto {
+		return ingress.NewNAT64(prefix)
+	}
+	if hasRoute("udp4", ipv4RouteProbeAddr) {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), nat64DiscoveryTimeout)
+	defer cancel()
+	nat64, err := ingress.DiscoverNAT64(ctx, net.DefaultResolver.LookupNetIP)
+	if err != nil {
+		log.Warn().Err(err).Msg("The host has no IPv4 route and no NAT64 prefix was discovered, IPv4 origins can't be reached")
+		return nil, nil
+	}
+	log.Info().Str("prefix", nat64.String()).Msg("The host has no IPv4 route, dialing IPv4 origins through NAT64")
+	return nat64, nil
+}
+
+func newICMPRouter(c *cli.Context, policy ingress.ICMPPolicy, logger *zerolog.Logger) (ingress.ICMPRouterServer, error) {
 	ipv4Src, ipv6Src, err := determineICMPSources(c, logger)
 	if err != nil {
 		return nil, err
@@ -381,6 +874,7 @@ func newICMPRouter(c *cli.Context, logger *zerolog.Logger) (ingress.ICMPRouterSe
 	if err != nil {
 		return nil, err
 	}
+	icmpRouter.UpdatePolicy(policy)
 	return icmpRouter, nil
 }
 
@@ -443,29 +937,25 @@ func determineICMPv6Src(userDefinedSrc string, logger *zerolog.Logger, ipv4Src n
 		return netip.Addr{}, "", fmt.Errorf("expect IPv6, but %s is IPv4", userDefinedSrc)
 	}
 
-	// Loop through all the interfaces, the preference is
-	// 1. The interface where ipv4Src is in
-	// 2. Interface with IPv6 address
-	// 3. Unspecified interface
-
 	interfaces, err := net.Interfaces()
 	if err != nil {
 		return netip.IPv6Unspecified(), "", nil
 	}
 
-	interfacesWithIPv6 := make([]interfaceIP, 0)
+	var (
+		interfacesWithIPv6 []interfaceIP
+		ipv4SrcInterface   string
+	)
 	for _, interf := range interfaces {
 		interfaceAddrs, err := interf.Addrs()
 		if err != nil {
 			continue
 		}
-
-		foundIPv4SrcInterface := false
 		for _, interfaceAddr := range interfaceAddrs {
 			if ipnet, ok := interfaceAddr.(*net.IPNet); ok {
 				ip := ipnet.IP
 				if ip.Equal(ipv4Src.AsSlice()) {
-					foundIPv4SrcInterface = true
+					ipv4SrcInterface = interf.Name
 				}
 				if ip.To4() == nil {
 					interfacesWithIPv6 = append(interfacesWithIPv6, interfaceIP{
@@ -475,33 +965,36 @@ func determineICMPv6Src(userDefinedSrc string, logger *zerolog.Logger, ipv4Src n
 				}
 			}
 		}
-		// Found the interface of ipv4Src. Loop through the addresses to see if there is an IPv6
-		if foundIPv4SrcInterface {
-			for _, interfaceAddr := range interfaceAddrs {
-				if ipnet, ok := interfaceAddr.(*net.IPNet); ok {
-					ip := ipnet.IP
-					if ip.To4() == nil {
-						addr, err := netip.ParseAddr(ip.String())
-						if err == nil {
-							return addr, interf.Name, nil
-						}
-					}
-				}
-			}
-		}
 	}
 
-	for _, interf := range interfacesWithIPv6 {
-		addr, err := netip.ParseAddr(interf.ip.String())
-		if err == nil {
-			return addr, interf.name, nil
-		}
+	if addr, zone, ok := pickICMPv6Src(interfacesWithIPv6, ipv4SrcInterface); ok {
+		return addr, zone, nil
 	}
-	logger.Debug().Err(err).Msgf("Failed to determine the IPv6 for this machine. It will use %s to send/listen for ICMPv6 echo", netip.IPv6Unspecified())
+	logger.Debug().Msgf("Failed to determine the IPv6 for this machine. It will use %s to send/listen for ICMPv6 echo", netip.IPv6Unspecified())
 
 	return netip.IPv6Unspecified(), "", nil
 }
 
+// pickICMPv6Src picks the source of the ICMPv6 echo requests, preferring a global unicast address, unique local
+// addresses included, of the interface of the IPv4 source, then of any interface. Link-local addresses only reach the
+// origins on their link, not the private ranges routed through the tunnel, so the unspecified address, which lets the
+// OS pick the source by destination, is preferred to them.
+func pickICMPv6Src(interfacesWithIPv6 []interfaceIP, ipv4SrcInterface string) (addr netip.Addr, zone string, ok bool) {
+	for _, interf := range interfacesWithIPv6 {
+		candidate, valid := netip.AddrFromSlice(interf.ip)
+		if !valid || !candidate.IsGlobalUnicast() {
+			continue
+		}
+		if interf.name == ipv4SrcInterface {
+			return candidate, interf.name, true
+		}
+		if !addr.IsValid() {
+			addr, zone = candidate, interf.name
+		}
+	}
+	return addr, zone, addr.IsValid()
+}
+
 // FindLocalAddr tries to dial UDP and returns the local address picked by the OS
 func findLocalAddr(dst net.IP, port int) (netip.Addr, error) {
 	udpConn, err := net.DialUDP("udp", nil, &net.UDPAddr{
@@ -535,3 +1028,41 @@ func parseResolverAddrPorts(input []string) ([]netip.AddrPort, error) {
 	}
 	return addrs, nil
 }
+
+// startTraceExport exports the spans of the proxied requests to the OTLP collector at endpoint.
+func startTraceExport(c *cli.Context, endpoint string, log *zerolog.Logger) (func(context.Context) error, error) {
+	ratio := c.Float64(flags.OTLPSampleRatio)
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("--%s must be between 0 and 1", flags.OTLPSampleRatio)
+	}
+	headers := make(map[string]string)
+	for _, header := range c.StringSlice(flags.OTLPHeaders) {
+		name, value, ok := strings.Cut(header, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("--%s expects headers as name=value, got %q", flags.OTLPHeaders, header)
+		}
+		headers[name] = value
+	}
+	return tracing.StartExport(c.Context, tracing.ExportConfig{
+		Endpoint:    endpoint,
+		Headers:     headers,
+		SampleRatio: ratio,
+	}, log)
+}
+
+// newEdgeBootstrap returns the HTTPS endpoint the edge discovery falls back to, if configured.
+func newEdgeBootstrap(c *cli.Context) (*allregions.Bootstrap, error) {
+	bootstrapURL := c.String(flags.EdgeBootstrapURL)
+	if bootstrapURL == "" {
+		return nil, nil
+	}
+	parsed, err := url.Parse(bootstrapURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return nil, fmt.Errorf("%s must be an https URL, got %q", flags.EdgeBootstrapURL, bootstrapURL)
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(c.String(flags.EdgeBootstrapPublicKey))
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s requires %s to be a base64 encoded ed25519 public key", flags.EdgeBootstrapURL, flags.EdgeBootstrapPublicKey)
+	}
+	return allregions.NewBootstrap(bootstrapURL, ed25519.PublicKey(publicKey)), nil
+}
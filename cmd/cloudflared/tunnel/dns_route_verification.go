@@ -0,0 +1,77 @@
+package tunnel
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+
+	"github.com/cloudflare/cloudflared/cfapi"
+	cfdflags "github.com/cloudflare/cloudflared/cmd/cloudflared/flags"
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+const (
+	dnsRouteVerificationOff    = "off"
+	dnsRouteVerificationWarn   = "warn"
+	dnsRouteVerificationStrict = "strict"
+)
+
+// newDNSRouteVerifier returns a function cross-checking ingress rules against the DNS routes of the tunnel, or nil
+// if verification is disabled. In warn mode mismatches are logged, in strict mode they reject the configuration.
+func newDNSRouteVerifier(c *cli.Context, tunnelID uuid.UUID, log *zerolog.Logger) (func(ingress.Ingress) error, error) {
+	mode := c.String(cfdflags.DNSRouteVerification)
+	switch mode {
+	case "", dnsRouteVerificationOff:
+		return nil, nil
+	case dnsRouteVerificationWarn, dnsRouteVerificationStrict:
+	default:
+		return nil, fmt.Errorf("invalid --%s %q, expected one of %s, %s, %s", cfdflags.DNSRouteVerification, mode,
+			dnsRouteVerificationOff, dnsRouteVerificationWarn, dnsRouteVerificationStrict)
+	}
+
+	sc, err := newSubcommandContext(c)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sc.client()
+	if err != nil {
+		if mode == dnsRouteVerificationStrict {
+			return nil, errors.Wrap(err, "DNS route verification requires an origin certificate")
+		}
+		log.Warn().Err(err).Msg("DNS route verification is disabled because the Cloudflare API can't be reached without an origin certificate")
+		return nil, nil
+	}
+	return func(ing ingress.Ingress) error {
+		return verifyDNSRoutes(client, tunnelID, ing, mode == dnsRouteVerificationStrict, log)
+	}, nil
+}
+
+func verifyDNSRoutes(client cfapi.HostnameClient, tunnelID uuid.UUID, ing ingress.Ingress, strict bool, log *zerolog.Logger) error {
+	routes, err := client.ListDNSRoutes(tunnelID)
+	if err != nil {
+		if strict {
+			return errors.Wrap(err, "failed to list the DNS routes of the tunnel")
+		}
+		log.Warn().Err(err).Msg("Failed to list the DNS routes of the tunnel, skipping DNS route verification")
+		return nil
+	}
+
+	mismatches := ing.FindDNSRouteMismatches(routes.Zone, routes.Hostnames)
+	if len(mismatches) == 0 {
+		log.Debug().Str("zone", routes.Zone).Msg("Ingress rules match the DNS routes of the tunnel")
+		return nil
+	}
+	descriptions := make([]string, 0, len(mismatches))
+	for _, mismatch := range mismatches {
+		log.Warn().Str("zone", routes.Zone).Msgf("DNS route verification: %s", mismatch)
+		descriptions = append(descriptions, mismatch.String())
+	}
+	if strict {
+		return fmt.Errorf("ingress rules don't match the DNS routes of the tunnel: %s", strings.Join(descriptions, "; "))
+	}
+	return nil
+}
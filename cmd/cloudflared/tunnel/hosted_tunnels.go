@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/control"
+	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/orchestration"
+	"github.com/cloudflare/cloudflared/supervisor"
+)
+
+// hostedTunnels hosts the tunnels added through the control API. They inherit the flags of the main tunnel, and
+// share its origin dialer and access log, but have their own credentials and ingress rules.
+type hostedTunnels struct {
+	*supervisor.TunnelHost
+	base             *supervisor.TunnelConfig
+	baseOrchestrator *orchestration.Config
+}
+
+func (t *hostedTunnels) Add(spec control.TunnelSpec) error {
+	credentials, err := readHostedTunnelCredentials(spec.CredentialsFile)
+	if err != nil {
+		return err
+	}
+	conf, err := readHostedTunnelConfig(spec.ConfigFile)
+	if err != nil {
+		return err
+	}
+	ingressRules, err := ingress.ParseIngress(conf)
+	if err != nil {
+		return errors.Wrapf(err, "invalid ingress rules in %s", spec.ConfigFile)
+	}
+	if err := ingress.ValidateQoSRules(conf.WarpRouting.QoS); err != nil {
+		return errors.Wrap(err, "invalid warp-routing configuration")
+	}
+
+	return t.TunnelHost.Add(spec.Name, func(ctx context.Context) (*supervisor.TunnelConfig, *orchestration.Orchestrator, error) {
+		tunnelConfig, err := t.HostedConfig(t.base, spec.Name, &connection.TunnelProperties{Credentials: credentials})
+		if err != nil {
+			return nil, nil, err
+		}
+		orchestratorConfig := &orchestration.Config{
+			Ingress:             &ingressRules,
+			WarpRouting:         ingress.NewWarpRoutingConfig(&conf.WarpRouting),
+			OriginDialerService: t.baseOrchestrator.OriginDialerService,
+			ConfigurationFlags:  t.baseOrchestrator.ConfigurationFlags,
+			AccessLog:           t.baseOrchestrator.AccessLog,
+			ConfigHistorySize:   t.baseOrchestrator.ConfigHistorySize,
+		}
+		orchestrator, err := orchestration.NewOrchestrator(ctx, orchestratorConfig, tunnelConfig.Tags, nil, tunnelConfig.Log)
+		if err != nil {
+			return nil, nil, err
+		}
+		return tunnelConfig, orchestrator, nil
+	})
+}
+
+func readHostedTunnelCredentials(path string) (connection.Credentials, error) {
+	var credentials connection.Credentials
+	if path == "" {
+		return credentials, errors.New("a hosted tunnel needs a credentials file")
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return credentials, errors.Wrapf(err, "couldn't read tunnel credentials from %v", path)
+	}
+	if err := json.Unmarshal(body, &credentials); err != nil {
+		return credentials, invalidJSONCredentialError{path: path, err: err}
+	}
+	if credentials.TunnelID == uuid.Nil {
+		return credentials, errors.Errorf("tunnel credentials file %v has no tunnel ID", path)
+	}
+	return credentials, nil
+}
+
+func readHostedTunnelConfig(path string) (*config.Configuration, error) {
+	if path == "" {
+		return nil, errors.New("a hosted tunnel needs a configuration file with its ingress rules")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var conf config.Configuration
+	if err := yaml.NewDecoder(file).Decode(&conf); err != nil {
+		return nil, errors.Wrap(err, "error parsing YAML in config file at "+path)
+	}
+	return &conf, nil
+}
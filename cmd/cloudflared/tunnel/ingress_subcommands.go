@@ -1,13 +1,23 @@
 package tunnel
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/cloudflare/cloudflared/cmd/cloudflared/cliutil"
 	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/connection"
 	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/logger"
+	"github.com/cloudflare/cloudflared/orchestration"
 
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
@@ -22,6 +32,28 @@ var ingressDataJSON = &cli.StringFlag{
 	EnvVars: []string{"TUNNEL_INGRESS_VALIDATE_JSON"},
 }
 
+var ingressValidateOutput = &cli.StringFlag{
+	Name:    "output",
+	Usage:   "Output format of the validation, text or json. json lists every problem found, with its severity, rule index and field, for tools checking configurations.",
+	Value:   "text",
+	EnvVars: []string{"TUNNEL_INGRESS_VALIDATE_OUTPUT"},
+}
+
+var (
+	ingressServeListen = &cli.StringFlag{
+		Name:    "listen",
+		Usage:   "Local address to serve the ingress rules on",
+		Value:   "localhost:8080",
+		EnvVars: []string{"TUNNEL_INGRESS_SERVE_LISTEN"},
+	}
+	ingressServeCountry = &cli.StringFlag{
+		Name:    "country",
+		Usage:   "Country code reported to the origins in the Cf-Ipcountry header",
+		Value:   connection.StubCountry,
+		EnvVars: []string{"TUNNEL_INGRESS_SERVE_COUNTRY"},
+	}
+)
+
 func buildIngressSubcommand() *cli.Command {
 	return &cli.Command{
 		Name:      "ingress",
@@ -45,10 +77,11 @@ func buildIngressSubcommand() *cli.Command {
 
 		To ensure cloudflared can route all incoming requests, the last rule must be a catch-all
 		rule that matches all traffic. You can validate these rules with the 'ingress validate'
-		command, and test which rule matches a particular URL with 'ingress rule <URL>'.
+		command, test which rule matches a particular URL with 'ingress rule <URL>', and try them
+		locally, without a tunnel, with 'ingress serve'.
 
 		Multiple-origin routing is incompatible with the --url flag.`,
-		Subcommands: []*cli.Command{buildValidateIngressCommand(), buildTestURLCommand()},
+		Subcommands: []*cli.Command{buildValidateIngressCommand(), buildTestURLCommand(), buildServeIngressCommand()},
 	}
 }
 
@@ -59,7 +92,7 @@ func buildValidateIngressCommand() *cli.Command {
 		Usage:       "Validate the ingress configuration ",
 		UsageText:   "cloudflared tunnel [--config FILEPATH] ingress validate",
 		Description: "Validates the configuration file, ensuring your ingress rules are OK.",
-		Flags:       []cli.Flag{ingressDataJSON},
+		Flags:       []cli.Flag{ingressDataJSON, ingressValidateOutput},
 	}
 }
 
@@ -78,12 +111,34 @@ func buildTestURLCommand() *cli.Command {
 	}
 }
 
+func buildServeIngressCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "serve",
+		Action:    cliutil.ConfiguredAction(serveIngressCommand),
+		Usage:     "Serve the ingress rules locally, without connecting to Cloudflare",
+		UsageText: "cloudflared tunnel [--config FILEPATH] ingress serve [--listen ADDRESS] [--country CODE]",
+		Description: "Serves the ingress rules of the configuration file on a local address, so that origins can be " +
+			"tested behind cloudflared without a tunnel or credentials. Requests are given the headers Cloudflare " +
+			"would add, like Cf-Ray, Cf-Connecting-Ip, Cf-Ipcountry, Cf-Visitor and X-Forwarded-For. Only HTTP " +
+			"and websocket requests are served, the Host header of the requests selects the ingress rule.",
+		Flags: []cli.Flag{ingressServeListen, ingressServeCountry},
+	}
+}
+
 // validateIngressCommand check the syntax of the ingress rules in the cloudflared config file
 func validateIngressCommand(c *cli.Context, warnings string) error {
-	conf, err := getConfiguration(c)
+	output := c.String(ingressValidateOutput.Name)
+	jsonOutput := output == "json"
+	if output != "" && output != "text" && !jsonOutput {
+		return fmt.Errorf("invalid --%s %q, expected text or json", ingressValidateOutput.Name, output)
+	}
+	conf, err := getConfiguration(c, !jsonOutput)
 	if err != nil {
 		return err
 	}
+	if jsonOutput {
+		return printIngressFindings(ingress.Validate(conf))
+	}
 
 	if _, err := ingress.ParseIngress(conf); err != nil {
 		return errors.Wrap(err, "Validation failed")
@@ -100,11 +155,26 @@ func validateIngressCommand(c *cli.Context, warnings string) error {
 	return nil
 }
 
-func getConfiguration(c *cli.Context) (*config.Configuration, error) {
+// printIngressFindings prints the findings as JSON, failing if any of them is an error.
+func printIngressFindings(findings ingress.Findings) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(findings); err != nil {
+		return err
+	}
+	if findings.HasErrors() {
+		return errors.New("Validation failed")
+	}
+	return nil
+}
+
+func getConfiguration(c *cli.Context, verbose bool) (*config.Configuration, error) {
 	var conf *config.Configuration
 	if c.IsSet(ingressDataJSONFlagName) {
 		ingressJSON := c.String(ingressDataJSONFlagName)
-		fmt.Println("Validating rules from cmdline flag --json")
+		if verbose {
+			fmt.Println("Validating rules from cmdline flag --json")
+		}
 		err := json.Unmarshal([]byte(ingressJSON), &conf)
 		return conf, err
 	}
@@ -112,7 +182,9 @@ func getConfiguration(c *cli.Context) (*config.Configuration, error) {
 	if conf.Source() == "" {
 		return nil, errors.New("No configuration file was found. Please create one, or use the --config flag to specify its filepath. You can use the help command to learn more about configuration files")
 	}
-	fmt.Println("Validating rules from", conf.Source())
+	if verbose {
+		fmt.Println("Validating rules from", conf.Source())
+	}
 	return conf, nil
 }
 
@@ -143,3 +215,50 @@ func testURLCommand(c *cli.Context) error {
 	fmt.Println(ing.Rules[i].MultiLineString())
 	return nil
 }
+
+// serveIngressCommand serves the ingress rules on a local address until cloudflared is interrupted.
+func serveIngressCommand(c *cli.Context) error {
+	log := logger.CreateLoggerFromContext(c, logger.EnableTerminalLog)
+	conf := config.GetConfiguration()
+	if conf.Source() == "" {
+		return errors.New("No configuration file was found. Please create one, or use the --config flag to specify its filepath")
+	}
+	ing, err := ingress.ParseIngress(conf)
+	if err != nil {
+		return errors.Wrap(err, "Validation failed")
+	}
+	warpRouting := ingress.NewWarpRoutingConfig(&conf.WarpRouting)
+
+	ctx, cancel := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	orchestrator, err := orchestration.NewOrchestrator(ctx, &orchestration.Config{
+		Ingress:     &ing,
+		WarpRouting: warpRouting,
+		OriginDialerService: ingress.NewOriginDialer(ingress.OriginConfig{
+			DefaultDialer: ingress.NewDialer(warpRouting),
+		}, log),
+	}, nil, nil, log)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", c.String(ingressServeListen.Name))
+	if err != nil {
+		return errors.Wrap(err, "failed to listen")
+	}
+	server := &http.Server{
+		Handler:           connection.NewStubHandler(orchestrator, c.String(ingressServeCountry.Name), log),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	log.Info().Msgf("Serving the ingress rules from %s on http://%s", conf.Source(), listener.Addr())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
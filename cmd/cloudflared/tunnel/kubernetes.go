@@ -0,0 +1,228 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+
+	cfdflags "github.com/cloudflare/cloudflared/cmd/cloudflared/flags"
+)
+
+const (
+	// exitCodeNeverConnected is the exit code when cloudflared fails before registering any connection (EX_UNAVAILABLE)
+	exitCodeNeverConnected = 69
+	// exitCodeInvalidCredentials is the exit code when the tunnel credentials are invalid or rejected (EX_NOPERM)
+	exitCodeInvalidCredentials = 77
+
+	kubernetesNamespaceFile   = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	kubernetesTerminationLog  = "/dev/termination-log"
+	kubernetesEventNormal     = "Normal"
+	kubernetesEventWarning    = "Warning"
+	kubernetesEventsComponent = "cloudflared"
+)
+
+// kubernetesMode makes cloudflared behave as a Kubernetes workload: it exits with distinct codes when it never
+// connected, when its credentials are invalid and when it drained cleanly, so that restart policies and alerts can
+// tell them apart, and it prints a Kubernetes Event, as a JSON line on stdout, on each of these transitions.
+type kubernetesMode struct {
+	out            io.Writer
+	terminationLog string
+	pod, namespace string
+	host           string
+
+	lock            sync.Mutex
+	connected       bool
+	everConnected   bool
+	terminationSent bool
+}
+
+// kubernetesEvent is the subset of the fields of a core/v1 Event that cloudflared fills in.
+type kubernetesEvent struct {
+	APIVersion         string                `json:"apiVersion"`
+	Kind               string                `json:"kind"`
+	Metadata           kubernetesObjectMeta  `json:"metadata"`
+	InvolvedObject     kubernetesObjectRef   `json:"involvedObject"`
+	Reason             string                `json:"reason"`
+	Message            string                `json:"message"`
+	Type               string                `json:"type"`
+	Source             kubernetesEventSource `json:"source"`
+	FirstTimestamp     string                `json:"firstTimestamp"`
+	LastTimestamp      string                `json:"lastTimestamp"`
+	Count              int                   `json:"count"`
+	ReportingComponent string                `json:"reportingComponent"`
+	ReportingInstance  string                `json:"reportingInstance"`
+}
+
+type kubernetesObjectMeta struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+type kubernetesObjectRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+}
+
+type kubernetesEventSource struct {
+	Component string `json:"component"`
+	Host      string `json:"host,omitempty"`
+}
+
+// newKubernetesMode returns nil unless cloudflared runs in Kubernetes mode.
+func newKubernetesMode(c *cli.Context) *kubernetesMode {
+	if !c.Bool(cfdflags.KubernetesMode) {
+		return nil
+	}
+	host, _ := os.Hostname()
+	pod := os.Getenv("POD_NAME")
+	if pod == "" {
+		pod = host
+	}
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		if data, err := os.ReadFile(kubernetesNamespaceFile); err == nil {
+			namespace = strings.TrimSpace(string(data))
+		}
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	return &kubernetesMode{
+		out:            os.Stdout,
+		terminationLog: kubernetesTerminationLog,
+		pod:            pod,
+		namespace:      namespace,
+		host:           os.Getenv("NODE_NAME"),
+	}
+}
+
+func (k *kubernetesMode) report(state ServiceState) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	switch state {
+	case ServiceRunning:
+		if k.connected {
+			return
+		}
+		k.connected = true
+		if k.everConnected {
+			k.emit(kubernetesEventNormal, "TunnelRecovered", "The tunnel registered a connection to the edge again")
+			return
+		}
+		k.everConnected = true
+		k.emit(kubernetesEventNormal, "TunnelConnected", "The tunnel registered its first connection to the edge")
+	case ServiceUnhealthy:
+		k.connected = false
+		k.emit(kubernetesEventWarning, "TunnelUnhealthy", "The tunnel has had no connection registered to the edge for too long")
+	case ServiceStopping:
+		k.emit(kubernetesEventNormal, "TunnelStopping", "The tunnel is shutting down")
+	}
+}
+
+// exit reports how cloudflared terminates, returning the error making it exit with the matching code. It's a no-op
+// outside of Kubernetes mode.
+func (k *kubernetesMode) exit(err error) error {
+	if k == nil {
+		return err
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.terminationSent {
+		return err
+	}
+	k.terminationSent = true
+
+	var eventType, reason, message string
+	code := 1
+	switch {
+	case err == nil:
+		eventType, reason, message = kubernetesEventNormal, "TunnelDrained", "The tunnel drained cleanly"
+		code = 0
+	case isInvalidCredentialsError(err):
+		eventType, reason = kubernetesEventWarning, "TunnelCredentialsInvalid"
+		message = fmt.Sprintf("The tunnel credentials are invalid: %v", err)
+		code = exitCodeInvalidCredentials
+	case !k.everConnected:
+		eventType, reason = kubernetesEventWarning, "TunnelNeverConnected"
+		message = fmt.Sprintf("The tunnel exited without registering any connection: %v", err)
+		code = exitCodeNeverConnected
+	default:
+		eventType, reason = kubernetesEventWarning, "TunnelFailed"
+		message = fmt.Sprintf("The tunnel exited: %v", err)
+	}
+	k.emit(eventType, reason, message)
+	k.writeTerminationMessage(message)
+	if code == 0 {
+		return nil
+	}
+	return cli.Exit(err.Error(), code)
+}
+
+// caller must hold the lock
+func (k *kubernetesMode) emit(eventType, reason, message string) {
+	now := time.Now().UTC()
+	timestamp := now.Format(time.RFC3339)
+	event := kubernetesEvent{
+		APIVersion: "v1",
+		Kind:       "Event",
+		Metadata: kubernetesObjectMeta{
+			// The naming scheme of client-go's event recorder
+			Name:      fmt.Sprintf("%s.%x", k.pod, now.UnixNano()),
+			Namespace: k.namespace,
+		},
+		InvolvedObject: kubernetesObjectRef{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Name:       k.pod,
+			Namespace:  k.namespace,
+		},
+		Reason:             reason,
+		Message:            message,
+		Type:               eventType,
+		Source:             kubernetesEventSource{Component: kubernetesEventsComponent, Host: k.host},
+		FirstTimestamp:     timestamp,
+		LastTimestamp:      timestamp,
+		Count:              1,
+		ReportingComponent: kubernetesEventsComponent,
+		ReportingInstance:  k.pod,
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	_, _ = k.out.Write(append(data, '\n'))
+}
+
+// writeTerminationMessage writes the message to the termination log of the container, which Kubernetes shows in the
+// status of the pod. The caller must hold the lock.
+func (k *kubernetesMode) writeTerminationMessage(message string) {
+	if k.terminationLog == "" {
+		return
+	}
+	// Only write to the file Kubernetes mounted, never create it
+	file, err := os.OpenFile(k.terminationLog, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	_, _ = file.WriteString(message)
+}
+
+// isInvalidCredentialsError returns whether the tunnel exited because its credentials can't be parsed, or were
+// rejected by the edge.
+func isInvalidCredentialsError(err error) bool {
+	var jsonErr invalidJSONCredentialError
+	if errors.As(err, &jsonErr) {
+		return true
+	}
+	return strings.Contains(err.Error(), "Unauthorized")
+}
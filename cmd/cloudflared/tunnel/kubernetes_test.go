@@ -0,0 +1,113 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+func newTestKubernetesMode(t *testing.T) (*kubernetesMode, *bytes.Buffer) {
+	var out bytes.Buffer
+	terminationLog := filepath.Join(t.TempDir(), "termination-log")
+	require.NoError(t, os.WriteFile(terminationLog, nil, 0o600))
+	return &kubernetesMode{
+		out:            &out,
+		terminationLog: terminationLog,
+		pod:            "cloudflared-7d9f",
+		namespace:      "tunnels",
+	}, &out
+}
+
+func readKubernetesEvents(t *testing.T, out *bytes.Buffer) []kubernetesEvent {
+	var events []kubernetesEvent
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		var event kubernetesEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestKubernetesModeExitCodes(t *testing.T) {
+	unauthorized := connection.ServerRegisterTunnelError{Cause: errors.New("Unauthorized: Invalid tunnel secret"), Permanent: true}
+	tests := []struct {
+		name      string
+		connected bool
+		err       error
+		code      int
+		reason    string
+	}{
+		{name: "drained", connected: true, code: 0, reason: "TunnelDrained"},
+		{name: "never connected", err: errors.New("no route to host"), code: exitCodeNeverConnected, reason: "TunnelNeverConnected"},
+		{name: "rejected credentials", err: errors.Wrap(unauthorized, "tunnel failed"), code: exitCodeInvalidCredentials, reason: "TunnelCredentialsInvalid"},
+		{name: "invalid credentials file", err: invalidJSONCredentialError{path: "creds.json", err: errors.New("EOF")}, code: exitCodeInvalidCredentials, reason: "TunnelCredentialsInvalid"},
+		{name: "failed after connecting", connected: true, err: errors.New("crashed"), code: 1, reason: "TunnelFailed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			k, out := newTestKubernetesMode(t)
+			if test.connected {
+				k.report(ServiceRunning)
+				out.Reset()
+			}
+
+			err := k.exit(test.err)
+			if test.code == 0 {
+				require.NoError(t, err)
+			} else {
+				var exitErr cli.ExitCoder
+				require.True(t, errors.As(err, &exitErr))
+				assert.Equal(t, test.code, exitErr.ExitCode())
+			}
+			events := readKubernetesEvents(t, out)
+			require.Len(t, events, 1)
+			assert.Equal(t, test.reason, events[0].Reason)
+
+			message, err := os.ReadFile(k.terminationLog)
+			require.NoError(t, err)
+			assert.Equal(t, events[0].Message, string(message))
+
+			// Only the first exit is reported
+			require.Equal(t, test.err, k.exit(test.err))
+			assert.Empty(t, readKubernetesEvents(t, out))
+		})
+	}
+}
+
+func TestKubernetesModeEvents(t *testing.T) {
+	k, out := newTestKubernetesMode(t)
+	k.report(ServiceStarting)
+	k.report(ServiceRunning)
+	k.report(ServiceRunning)
+	k.report(ServiceUnhealthy)
+	k.report(ServiceRunning)
+	k.report(ServiceStopping)
+
+	events := readKubernetesEvents(t, out)
+	var reasons []string
+	for _, event := range events {
+		reasons = append(reasons, event.Reason)
+	}
+	assert.Equal(t, []string{"TunnelConnected", "TunnelUnhealthy", "TunnelRecovered", "TunnelStopping"}, reasons)
+
+	event := events[1]
+	assert.Equal(t, "v1", event.APIVersion)
+	assert.Equal(t, "Event", event.Kind)
+	assert.Equal(t, kubernetesEventWarning, event.Type)
+	assert.Equal(t, kubernetesObjectRef{APIVersion: "v1", Kind: "Pod", Name: "cloudflared-7d9f", Namespace: "tunnels"}, event.InvolvedObject)
+	assert.Equal(t, "tunnels", event.Metadata.Namespace)
+	assert.Contains(t, event.Metadata.Name, "cloudflared-7d9f.")
+	assert.Equal(t, "cloudflared", event.Source.Component)
+	assert.NotEmpty(t, event.LastTimestamp)
+}
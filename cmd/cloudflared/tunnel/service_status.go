@@ -0,0 +1,172 @@
+package tunnel
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+// ServiceState is the state of the tunnel reported to the service manager running cloudflared.
+type ServiceState int
+
+const (
+	// ServiceStarting means no connection to the edge was registered yet
+	ServiceStarting ServiceState = iota
+	// ServiceRunning means at least a connection to the edge is registered
+	ServiceRunning
+	// ServiceUnhealthy means no connection to the edge has been registered for longer than the unhealthy timeout
+	ServiceUnhealthy
+	// ServiceStopping means cloudflared is shutting down
+	ServiceStopping
+)
+
+func (s ServiceState) String() string {
+	switch s {
+	case ServiceStarting:
+		return "starting"
+	case ServiceRunning:
+		return "running"
+	case ServiceUnhealthy:
+		return "unhealthy"
+	case ServiceStopping:
+		return "stopping"
+	default:
+		return "unknown"
+	}
+}
+
+// ServiceStatusReporter is called with each change of the state of the tunnel, in order.
+type ServiceStatusReporter func(ServiceState)
+
+var serviceStatusReporter ServiceStatusReporter
+
+// SetServiceStatusReporter sets what reports the state of the tunnel to the service manager. It must be called
+// before the app runs.
+func SetServiceStatusReporter(reporter ServiceStatusReporter) {
+	serviceStatusReporter = reporter
+}
+
+// serviceStatus derives the state of the tunnel from the connection events, and reports it to the service managers.
+// The tunnel is unhealthy once it had no registered connection for unhealthyTimeout, unless it's zero.
+type serviceStatus struct {
+	reporters        []ServiceStatusReporter
+	unhealthyTimeout time.Duration
+
+	lock      sync.Mutex
+	state     ServiceState
+	connected map[uint8]bool
+	unhealthy *time.Timer
+}
+
+func newServiceStatus(unhealthyTimeout time.Duration, reporters ...ServiceStatusReporter) *serviceStatus {
+	s := &serviceStatus{
+		unhealthyTimeout: unhealthyTimeout,
+		state:            ServiceStarting,
+		connected:        make(map[uint8]bool),
+	}
+	for _, reporter := range reporters {
+		if reporter != nil {
+			s.reporters = append(s.reporters, reporter)
+		}
+	}
+	if !s.enabled() {
+		return s
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.report(ServiceStarting)
+	s.startUnhealthyTimer()
+	return s
+}
+
+// enabled returns whether the state is reported to any service manager.
+func (s *serviceStatus) enabled() bool {
+	return len(s.reporters) > 0
+}
+
+func (s *serviceStatus) OnTunnelEvent(event connection.Event) {
+	if !s.enabled() {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch event.EventType {
+	case connection.Connected:
+		s.connected[event.Index] = true
+	case connection.Disconnected, connection.Reconnecting, connection.RegisteringTunnel, connection.Unregistering:
+		delete(s.connected, event.Index)
+	default:
+		return
+	}
+	if len(s.connected) > 0 {
+		s.stopUnhealthyTimer()
+		s.setState(ServiceRunning)
+	} else if s.state == ServiceRunning {
+		s.startUnhealthyTimer()
+	}
+}
+
+// ready reports the tunnel as running without connections, for cloudflared serving the DNS proxy on its own.
+func (s *serviceStatus) ready() {
+	if !s.enabled() {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stopUnhealthyTimer()
+	s.setState(ServiceRunning)
+}
+
+// stop reports cloudflared as stopping. The states changes that follow aren't reported.
+func (s *serviceStatus) stop() {
+	if !s.enabled() {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stopUnhealthyTimer()
+	s.setState(ServiceStopping)
+}
+
+// caller must hold the lock
+func (s *serviceStatus) setState(state ServiceState) {
+	if s.state == state || s.state == ServiceStopping {
+		return
+	}
+	s.state = state
+	s.report(state)
+}
+
+// caller must hold the lock
+func (s *serviceStatus) report(state ServiceState) {
+	for _, reporter := range s.reporters {
+		reporter(state)
+	}
+}
+
+// caller must hold the lock
+func (s *serviceStatus) startUnhealthyTimer() {
+	if s.unhealthyTimeout <= 0 || s.unhealthy != nil {
+		return
+	}
+	// The caller holds the lock, so timer is set before the function runs
+	var timer *time.Timer
+	timer = time.AfterFunc(s.unhealthyTimeout, func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.unhealthy == timer && len(s.connected) == 0 {
+			s.unhealthy = nil
+			s.setState(ServiceUnhealthy)
+		}
+	})
+	s.unhealthy = timer
+}
+
+// caller must hold the lock
+func (s *serviceStatus) stopUnhealthyTimer() {
+	if s.unhealthy != nil {
+		s.unhealthy.Stop()
+		s.unhealthy = nil
+	}
+}
@@ -0,0 +1,77 @@
+package tunnel
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+type recordedStates struct {
+	lock   sync.Mutex
+	states []ServiceState
+}
+
+func (r *recordedStates) report(state ServiceState) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.states = append(r.states, state)
+}
+
+func (r *recordedStates) get() []ServiceState {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]ServiceState{}, r.states...)
+}
+
+func TestServiceStatus(t *testing.T) {
+	var recorded recordedStates
+	status := newServiceStatus(0, recorded.report)
+	assert.Equal(t, []ServiceState{ServiceStarting}, recorded.get())
+
+	status.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.RegisteringTunnel})
+	status.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected})
+	status.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Connected})
+	status.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Reconnecting})
+	assert.Equal(t, []ServiceState{ServiceStarting, ServiceRunning}, recorded.get())
+
+	status.stop()
+	status.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected})
+	status.stop()
+	assert.Equal(t, []ServiceState{ServiceStarting, ServiceRunning, ServiceStopping}, recorded.get())
+}
+
+func TestServiceStatusUnhealthy(t *testing.T) {
+	var recorded recordedStates
+	status := newServiceStatus(50*time.Millisecond, recorded.report)
+	require.Eventually(t, func() bool {
+		return len(recorded.get()) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []ServiceState{ServiceStarting, ServiceUnhealthy}, recorded.get())
+
+	status.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected})
+	status.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Disconnected})
+	require.Eventually(t, func() bool {
+		return len(recorded.get()) == 4
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []ServiceState{ServiceStarting, ServiceUnhealthy, ServiceRunning, ServiceUnhealthy}, recorded.get())
+
+	// A connection registered in time keeps the tunnel running
+	status.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected})
+	status.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Disconnected})
+	status.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected})
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, ServiceRunning, recorded.get()[len(recorded.get())-1])
+	status.stop()
+}
+
+func TestServiceStatusWithoutReporter(t *testing.T) {
+	status := newServiceStatus(time.Millisecond, nil)
+	status.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected})
+	status.ready()
+	status.stop()
+}
@@ -4,18 +4,32 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/rs/zerolog"
 )
 
-// waitForSignal closes graceShutdownC to indicate that we should start graceful shutdown sequence
-func waitForSignal(graceShutdownC chan struct{}, logger *zerolog.Logger) {
+// waitForSignal closes graceShutdownC to indicate that we should start graceful shutdown sequence. On SIGTERM, the
+// tunnel keeps serving for preStopDelay first, so that its replacement can register its connections, unless another
+// signal is received meanwhile.
+func waitForSignal(graceShutdownC chan struct{}, preStopDelay time.Duration, logger *zerolog.Logger) {
 	signals := make(chan os.Signal, 10)
 	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
 	defer signal.Stop(signals)
 
 	select {
 	case s := <-signals:
+		if s == syscall.SIGTERM && preStopDelay > 0 {
+			logger.Info().Msgf("Received signal %s, initiating graceful shutdown in %v ...", s, preStopDelay)
+			delay := time.NewTimer(preStopDelay)
+			defer delay.Stop()
+			select {
+			case <-delay.C:
+			case s = <-signals:
+			case <-graceShutdownC:
+				return
+			}
+		}
 		logger.Info().Msgf("Initiating graceful shutdown due to signal %s ...", s)
 		close(graceShutdownC)
 	case <-graceShutdownC:
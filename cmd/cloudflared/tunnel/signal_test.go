@@ -52,11 +52,42 @@ func TestSignalShutdown(t *testing.T) {
 			}
 		})
 
-		waitForSignal(graceShutdownC, &log)
+		waitForSignal(graceShutdownC, 0, &log)
 		assert.True(t, channelClosed(graceShutdownC))
 	}
 }
 
+func TestSignalPreStopDelay(t *testing.T) {
+	log := zerolog.Nop()
+	const preStopDelay = 3 * tick
+
+	graceShutdownC := make(chan struct{})
+	go waitForSignal(graceShutdownC, preStopDelay, &log)
+	time.Sleep(tick)
+	_ = syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	time.Sleep(tick)
+	assert.False(t, channelClosed(graceShutdownC), "shutdown started before the preStop delay")
+	select {
+	case <-graceShutdownC:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown didn't start after the preStop delay")
+	}
+
+	// A second signal cuts the delay short
+	graceShutdownC = make(chan struct{})
+	go waitForSignal(graceShutdownC, time.Minute, &log)
+	time.Sleep(tick)
+	_ = syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	time.Sleep(tick)
+	assert.False(t, channelClosed(graceShutdownC))
+	_ = syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	select {
+	case <-graceShutdownC:
+	case <-time.After(time.Second):
+		t.Fatal("a second signal didn't start the shutdown")
+	}
+}
+
 func TestWaitForShutdown(t *testing.T) {
 	log := zerolog.Nop()
 
@@ -1,6 +1,7 @@
 package tunnel
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -267,7 +268,7 @@ func (sc *subcommandContext) run(tunnelID uuid.UUID) error {
 			sc.log.Error().Msgf("The credentials file at %s contained invalid JSON. This is probably caused by passing the wrong filepath. Reminder: the credentials file is a .json file created via `cloudflared tunnel create`.", e.path)
 			sc.log.Error().Msgf("Invalid JSON when parsing credentials file: %s", e.err.Error())
 		}
-		return err
+		return newKubernetesMode(sc.c).exit(err)
 	}
 
 	return sc.runWithCredentials(credentials)
@@ -284,6 +285,25 @@ func (sc *subcommandContext) runWithCredentials(credentials connection.Credentia
 	)
 }
 
+// runWithProvider runs the tunnel with the credentials of provider, re-registering the connections when they're
+// rotated.
+func (sc *subcommandContext) runWithProvider(provider credentials.TunnelCredentialsProvider) error {
+	rotatingCredentials, err := credentials.NewRotatingTunnelCredentials(context.Background(), provider, sc.c.Duration(CredRotationFlag), sc.log)
+	if err != nil {
+		return err
+	}
+	tunnelCredentials := rotatingCredentials.Current()
+	sc.log.Info().Str(LogFieldTunnelID, tunnelCredentials.TunnelID.String()).Msgf("Starting tunnel with the credentials from %s", provider.Name())
+
+	return startServer(
+		sc.c,
+		buildInfo,
+		&connection.TunnelProperties{Credentials: tunnelCredentials},
+		rotatingCredentials,
+		sc.log,
+	)
+}
+
 func (sc *subcommandContext) cleanupConnections(tunnelIDs []uuid.UUID) error {
 	params := cfapi.NewCleanupParams()
 	extraLog := ""
@@ -29,6 +29,7 @@ import (
 	"github.com/cloudflare/cloudflared/cmd/cloudflared/updater"
 	"github.com/cloudflare/cloudflared/config"
 	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/credentials"
 	"github.com/cloudflare/cloudflared/diagnostic"
 	"github.com/cloudflare/cloudflared/fips"
 	"github.com/cloudflare/cloudflared/metrics"
@@ -42,6 +43,8 @@ const (
 	CredContentsFlag        = "credentials-contents"
 	TunnelTokenFlag         = "token"
 	TunnelTokenFileFlag     = "token-file"
+	CredProviderFlag        = "credentials-provider"
+	CredRotationFlag        = "credentials-rotation-interval"
 	overwriteDNSFlagName    = "overwrite-dns"
 	noDiagLogsFlagName      = "no-diag-logs"
 	noDiagMetricsFlagName   = "no-diag-metrics"
@@ -50,6 +53,7 @@ const (
 	noDiagNetworkFlagName   = "no-diag-network"
 	diagContainerIDFlagName = "diag-container-id"
 	diagPodFlagName         = "diag-pod-id"
+	diagBundleFlagName      = "bundle"
 
 	LogFieldTunnelID = "tunnelID"
 )
@@ -135,6 +139,19 @@ var (
 		Usage:   "Filepath at which to read the tunnel token. When provided along with credentials, this will take precedence.",
 		EnvVars: []string{"TUNNEL_TOKEN_FILE"},
 	})
+	credentialsProviderFlag = altsrc.NewStringFlag(&cli.StringFlag{
+		Name: CredProviderFlag,
+		Usage: "Load the tunnel credentials, or token, from a provider: file:PATH, env:NAME, kubernetes:DIR#KEY for a mounted secret, " +
+			"vault:PATH[#FIELD] with VAULT_ADDR and VAULT_TOKEN, or aws-secretsmanager:SECRET-ID with the AWS credentials of the environment. " +
+			"When provided, this will take precedence over the token and credentials.",
+		EnvVars: []string{"TUNNEL_CRED_PROVIDER"},
+	})
+	credentialsRotationFlag = altsrc.NewDurationFlag(&cli.DurationFlag{
+		Name:    CredRotationFlag,
+		Usage:   "How often the credentials of --credentials-provider are reloaded. The connections re-register one at a time when they changed. 0 disables it.",
+		EnvVars: []string{"TUNNEL_CRED_ROTATION_INTERVAL"},
+		Value:   time.Minute,
+	})
 	forceDeleteFlag = &cli.BoolFlag{
 		Name:    flags.Force,
 		Aliases: []string{"f"},
@@ -231,6 +248,11 @@ var (
 		Usage: "Runtime information collection will not be performed",
 		Value: false,
 	}
+	diagBundleFlag = &cli.BoolFlag{
+		Name:  diagBundleFlagName,
+		Usage: "Download the bundle generated by the instance: recent logs, connection states, profiles, redacted configuration, edge reachability and QUIC statistics",
+		Value: false,
+	}
 	noDiagNetworkFlag = &cli.BoolFlag{
 		Name:  noDiagNetworkFlagName,
 		Usage: "Network diagnostics won't be performed",
@@ -246,6 +268,27 @@ var (
 		Usage:   "Overrides the dynamic DNS resolver resolution to use these address:port's instead.",
 		EnvVars: []string{"TUNNEL_DNS_RESOLVER_ADDRS"},
 	}
+	dnsResolverTimeoutFlag = &cli.DurationFlag{
+		Name:    flags.VirtualDNSServiceResolverTimeout,
+		Usage:   "How long a DNS query over UDP waits for the answer of a resolver before being sent to another of the resolvers.",
+		Value:   2 * time.Second,
+		EnvVars: []string{"TUNNEL_DNS_RESOLVER_TIMEOUT"},
+	}
+	dnsResolverCacheSizeFlag = &cli.IntFlag{
+		Name:    flags.VirtualDNSServiceCacheSize,
+		Usage:   "Number of answers to DNS queries over UDP to cache. 0 disables the cache.",
+		EnvVars: []string{"TUNNEL_DNS_RESOLVER_CACHE_SIZE"},
+	}
+	dnsResolverCacheMinTTLFlag = &cli.DurationFlag{
+		Name:    flags.VirtualDNSServiceCacheMinTTL,
+		Usage:   "Minimum TTL of the cached DNS answers.",
+		EnvVars: []string{"TUNNEL_DNS_RESOLVER_CACHE_MIN_TTL"},
+	}
+	dnsResolverCacheMaxTTLFlag = &cli.DurationFlag{
+		Name:    flags.VirtualDNSServiceCacheMaxTTL,
+		Usage:   "Maximum TTL of the cached DNS answers. 0 keeps the TTL of the answers.",
+		EnvVars: []string{"TUNNEL_DNS_RESOLVER_CACHE_MAX_TTL"},
+	}
 )
 
 func buildCreateCommand() *cli.Command {
@@ -720,10 +763,16 @@ func buildRunCommand() *cli.Command {
 		featuresFlag,
 		tunnelTokenFlag,
 		tunnelTokenFileFlag,
+		credentialsProviderFlag,
+		credentialsRotationFlag,
 		icmpv4SrcFlag,
 		icmpv6SrcFlag,
 		maxActiveFlowsFlag,
 		dnsResolverAddrsFlag,
+		dnsResolverTimeoutFlag,
+		dnsResolverCacheSizeFlag,
+		dnsResolverCacheMinTTLFlag,
+		dnsResolverCacheMaxTTLFlag,
 	}
 	flags = append(flags, configureProxyFlags(false)...)
 	return &cli.Command{
@@ -761,6 +810,14 @@ func runCommand(c *cli.Context) error {
 			"your origin will not be reachable. You should remove the `hostname` property to avoid this warning.")
 	}
 
+	if providerSpec := c.String(CredProviderFlag); providerSpec != "" {
+		provider, err := credentials.NewTunnelCredentialsProvider(providerSpec)
+		if err != nil {
+			return cliutil.UsageError("Invalid --%s: %s", CredProviderFlag, err.Error())
+		}
+		return sc.runWithProvider(provider)
+	}
+
 	tokenStr := c.String(TunnelTokenFlag)
 	// Check if tokenStr is blank before checking for tokenFile
 	if tokenStr == "" {
@@ -1089,6 +1146,7 @@ func buildDiagCommand() *cli.Command {
 			noDiagSystemFlag,
 			noDiagRuntimeFlag,
 			noDiagNetworkFlag,
+			diagBundleFlag,
 		},
 		CustomHelpTemplate: commandHelpTemplate(),
 	}
@@ -1112,6 +1170,7 @@ func diagCommand(ctx *cli.Context) error {
 			NoDiagRuntime: sctx.c.Bool(noDiagRuntimeFlagName),
 			NoDiagNetwork: sctx.c.Bool(noDiagNetworkFlagName),
 		},
+		Bundle: sctx.c.Bool(diagBundleFlagName),
 	}
 
 	if options.Address == "" {
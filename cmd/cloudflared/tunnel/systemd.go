@@ -0,0 +1,105 @@
+package tunnel
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/rs/zerolog"
+)
+
+// systemdNotifier reports the state of the tunnel to systemd, for units of Type=notify: ready once a connection is
+// registered, reloading while a configuration is applied, and stopping on shutdown. The watchdog is kept alive while
+// the tunnel is healthy, so that systemd restarts connectors that can't register connections anymore.
+type systemdNotifier struct {
+	log *zerolog.Logger
+
+	lock    sync.Mutex
+	ready   bool
+	healthy bool
+}
+
+// newSystemdNotifier returns nil when cloudflared isn't run by systemd with a notification socket.
+func newSystemdNotifier(log *zerolog.Logger) *systemdNotifier {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return nil
+	}
+	return &systemdNotifier{log: log, healthy: true}
+}
+
+func (n *systemdNotifier) report(state ServiceState) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	switch state {
+	case ServiceStarting:
+		n.notify("STATUS=Connecting to the edge")
+	case ServiceRunning:
+		n.healthy = true
+		if !n.ready {
+			n.ready = true
+			n.notify("READY=1\nSTATUS=Connected to the edge")
+		} else {
+			n.notify("STATUS=Connected to the edge")
+		}
+	case ServiceUnhealthy:
+		// The watchdog isn't kept alive anymore
+		n.healthy = false
+		n.notify("STATUS=No connection registered to the edge")
+	case ServiceStopping:
+		n.notify("STOPPING=1\nSTATUS=Shutting down")
+	}
+}
+
+// reloading reports that a configuration is being applied, returning the function reporting that it's done.
+func (n *systemdNotifier) reloading() func() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if !n.ready {
+		return func() {}
+	}
+	state := "RELOADING=1"
+	if usec, ok := monotonicUsec(); ok {
+		state += fmt.Sprintf("\nMONOTONIC_USEC=%d", usec)
+	}
+	n.notify(state)
+	return func() {
+		n.lock.Lock()
+		defer n.lock.Unlock()
+		n.notify("READY=1")
+	}
+}
+
+// runWatchdog keeps the watchdog of the unit alive while the tunnel is healthy, until stopC is closed.
+func (n *systemdNotifier) runWatchdog(stopC <-chan struct{}) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		n.log.Err(err).Msg("Unable to read the systemd watchdog interval")
+		return
+	}
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.lock.Lock()
+			if n.healthy {
+				n.notify("WATCHDOG=1")
+			}
+			n.lock.Unlock()
+		case <-stopC:
+			return
+		}
+	}
+}
+
+// caller must hold the lock
+func (n *systemdNotifier) notify(state string) {
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		n.log.Debug().Err(err).Msg("Unable to notify systemd")
+	}
+}
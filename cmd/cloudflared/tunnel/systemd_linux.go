@@ -0,0 +1,14 @@
+//go:build linux
+
+package tunnel
+
+import "golang.org/x/sys/unix"
+
+// monotonicUsec returns the CLOCK_MONOTONIC time systemd expects with RELOADING=1, in microseconds.
+func monotonicUsec() (int64, bool) {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0, false
+	}
+	return ts.Nano() / 1000, true
+}
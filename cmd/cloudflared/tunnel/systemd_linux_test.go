@@ -0,0 +1,83 @@
+package tunnel
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listenNotifySocket(t *testing.T) *net.UnixConn {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 1024)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestSystemdNotifier(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	log := zerolog.Nop()
+	require.Nil(t, newSystemdNotifier(&log))
+
+	conn := listenNotifySocket(t)
+	notifier := newSystemdNotifier(&log)
+	require.NotNil(t, notifier)
+
+	// Configurations applied before the tunnel is ready don't reload it
+	notifier.reloading()()
+	notifier.report(ServiceStarting)
+	assert.Equal(t, "STATUS=Connecting to the edge", readNotification(t, conn))
+	notifier.report(ServiceRunning)
+	assert.Equal(t, "READY=1\nSTATUS=Connected to the edge", readNotification(t, conn))
+
+	done := notifier.reloading()
+	assert.True(t, strings.HasPrefix(readNotification(t, conn), "RELOADING=1\nMONOTONIC_USEC="))
+	done()
+	assert.Equal(t, "READY=1", readNotification(t, conn))
+
+	notifier.report(ServiceStopping)
+	assert.Equal(t, "STOPPING=1\nSTATUS=Shutting down", readNotification(t, conn))
+}
+
+func TestSystemdWatchdog(t *testing.T) {
+	conn := listenNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	log := zerolog.Nop()
+	notifier := newSystemdNotifier(&log)
+	stopC := make(chan struct{})
+	defer close(stopC)
+	go notifier.runWatchdog(stopC)
+
+	assert.Equal(t, "WATCHDOG=1", readNotification(t, conn))
+
+	// The watchdog isn't kept alive while the tunnel is unhealthy
+	notifier.report(ServiceUnhealthy)
+	notification := readNotification(t, conn)
+	for notification == "WATCHDOG=1" {
+		notification = readNotification(t, conn)
+	}
+	assert.Equal(t, "STATUS=No connection registered to the edge", notification)
+	buf := make([]byte, 1024)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+	_, err := conn.Read(buf)
+	require.Error(t, err)
+
+	notifier.report(ServiceRunning)
+	assert.Equal(t, "READY=1\nSTATUS=Connected to the edge", readNotification(t, conn))
+	assert.Equal(t, "WATCHDOG=1", readNotification(t, conn))
+}
@@ -20,6 +20,7 @@ import (
 	"golang.org/x/sys/windows/svc/mgr"
 
 	"github.com/cloudflare/cloudflared/cmd/cloudflared/cliutil"
+	"github.com/cloudflare/cloudflared/cmd/cloudflared/tunnel"
 	"github.com/cloudflare/cloudflared/logger"
 )
 
@@ -31,6 +32,17 @@ const (
 	recoverActionDelay      = time.Second * 20
 	failureCountResetPeriod = time.Hour * 24
 
+	// serviceStartWaitHint is how long the service control manager waits for the next check point while starting
+	serviceStartWaitHint      = time.Second * 30
+	serviceCheckPointInterval = time.Second * 10
+	// serviceAnnounceTimeout is how long commands have to start a tunnel, which reports its state, before the service
+	// is reported as running
+	serviceAnnounceTimeout = time.Second * 30
+	// serviceStopWaitHint covers the default grace period
+	serviceStopWaitHint = time.Second * 45
+	// serviceUnhealthyExitCode is the service specific exit code once no connection is registered for too long
+	serviceUnhealthyExitCode = 2
+
 	// not defined in golang.org/x/sys/windows package
 	// https://msdn.microsoft.com/en-us/library/windows/desktop/ms681988(v=vs.85).aspx
 	serviceConfigFailureActionsFlag = 4
@@ -130,26 +142,84 @@ func (s *windowsService) Execute(serviceArgs []string, r <-chan svc.ChangeReques
 	}
 	elog.Info(1, fmt.Sprintf("%s service arguments: %v", windowsServiceName, args))
 
-	statusChan <- svc.Status{State: svc.StartPending}
+	// The tunnel reports its state from the connection events, the service is only running once a connection is
+	// registered
+	states := make(chan tunnel.ServiceState, 16)
+	tunnel.SetServiceStatusReporter(func(state tunnel.ServiceState) {
+		select {
+		case states <- state:
+		default:
+		}
+	})
+
+	startPending := svc.Status{State: svc.StartPending, WaitHint: uint32(serviceStartWaitHint / time.Millisecond)}
+	statusChan <- startPending
 	errC := make(chan error)
 	go func() {
 		errC <- s.app.Run(args)
 	}()
-	statusChan <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	var (
+		startedAt = time.Now()
+		announced bool
+		running   bool
+		unhealthy bool
+	)
+	checkPoints := time.NewTicker(serviceCheckPointInterval)
+	defer checkPoints.Stop()
+	setRunning := func() {
+		if !running {
+			running = true
+			checkPoints.Stop()
+			statusChan <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPreShutdown}
+		}
+	}
+	shutdown := func() bool {
+		if s.graceShutdownC == nil {
+			return false
+		}
+		close(s.graceShutdownC)
+		s.graceShutdownC = nil
+		statusChan <- svc.Status{State: svc.StopPending, WaitHint: uint32(serviceStopWaitHint / time.Millisecond)}
+		return true
+	}
 
 	for {
 		select {
+		case state := <-states:
+			switch state {
+			case tunnel.ServiceStarting:
+				announced = true
+			case tunnel.ServiceRunning:
+				if !running {
+					elog.Info(1, "cloudflared registered a connection to the edge")
+				}
+				setRunning()
+			case tunnel.ServiceUnhealthy:
+				// Exiting with an error has the service control manager apply the recovery actions
+				elog.Error(1, "cloudflared has no connection registered to the edge, stopping so that the service is restarted")
+				unhealthy = true
+				shutdown()
+			}
+		case <-checkPoints.C:
+			if running {
+				continue
+			}
+			// Commands other than tunnels don't report their state
+			if !announced && time.Since(startedAt) >= serviceAnnounceTimeout {
+				setRunning()
+				continue
+			}
+			startPending.CheckPoint++
+			statusChan <- startPending
 		case c := <-r:
 			switch c.Cmd {
 			case svc.Interrogate:
 				statusChan <- c.CurrentStatus
-			case svc.Stop, svc.Shutdown:
-				if s.graceShutdownC != nil {
+			case svc.Stop, svc.Shutdown, svc.PreShutdown:
+				if shutdown() {
 					// start graceful shutdown
 					elog.Info(1, "cloudflared starting graceful shutdown")
-					close(s.graceShutdownC)
-					s.graceShutdownC = nil
-					statusChan <- svc.Status{State: svc.StopPending}
 					continue
 				}
 				// repeated attempts at graceful shutdown forces immediate stop
@@ -164,6 +234,10 @@ func (s *windowsService) Execute(serviceArgs []string, r <-chan svc.ChangeReques
 				elog.Error(1, fmt.Sprintf("cloudflared terminated with error %v", err))
 				ssec = true
 				errno = 1
+			} else if unhealthy {
+				elog.Error(1, "cloudflared terminated without connection to the edge")
+				ssec = true
+				errno = serviceUnhealthyExitCode
 			} else {
 				elog.Info(1, "cloudflared terminated without error")
 				errno = 0
@@ -231,6 +231,345 @@ type OriginRequestConfig struct {
 	Http2Origin *bool `yaml:"http2Origin" json:"http2Origin,omitempty"`
 	// Access holds all access related configs
 	Access *AccessConfig `yaml:"access" json:"access,omitempty"`
+	// LoadBalancer holds the origins and health checks of a load_balancer service
+	LoadBalancer *LoadBalancerConfig `yaml:"loadBalancer" json:"loadBalancer,omitempty"`
+	// Canary configures the origins a canary service splits requests between
+	Canary *CanaryConfig `yaml:"canary" json:"canary,omitempty"`
+	// Maps edge-provided client fingerprint names (e.g. ja3, ja4) to the request header used to forward them to the origin
+	FingerprintHeaders map[string]string `yaml:"fingerprintHeaders" json:"fingerprintHeaders,omitempty"`
+	// Retry configures retries of idempotent requests that failed to reach the origin
+	Retry *RetryConfig `yaml:"retry" json:"retry,omitempty"`
+	// Hedging sends a second copy of idempotent requests the origin is slow to respond to
+	Hedging *HedgingConfig `yaml:"hedging" json:"hedging,omitempty"`
+	// CircuitBreaker configures fast-failing requests while the origin is down
+	CircuitBreaker *CircuitBreakerConfig `yaml:"circuitBreaker" json:"circuitBreaker,omitempty"`
+	// Cache configures caching of origin responses in cloudflared
+	Cache *CacheConfig `yaml:"cache" json:"cache,omitempty"`
+	// Inspection sends the requests of the rule to registered inspectors
+	Inspection *InspectionConfig `yaml:"inspection" json:"inspection,omitempty"`
+	// FastCGI configures the parameters sent to fastcgi:// origins
+	FastCGI *FastCGIConfig `yaml:"fastcgi" json:"fastcgi,omitempty"`
+	// Static configures static file services
+	Static *StaticConfig `yaml:"static" json:"static,omitempty"`
+	// Streaming configures how response bodies are buffered before being sent to the edge
+	Streaming *StreamingConfig `yaml:"streaming" json:"streaming,omitempty"`
+	// WebSocket configures compression, size limits and idle timeout of websocket connections
+	WebSocket *WebSocketConfig `yaml:"websocket" json:"websocket,omitempty"`
+	// ProxyProtocol prepends a PROXY protocol header carrying the eyeball address to tcp origin connections
+	ProxyProtocol *ProxyProtocolConfig `yaml:"proxyProtocol" json:"proxyProtocol,omitempty"`
+	// UDP configures udp:// services
+	UDP *UDPConfig `yaml:"udp" json:"udp,omitempty"`
+	// IPAccess restricts the eyeball IPs that can reach the origin
+	IPAccess *IPAccessConfig `yaml:"ipAccess" json:"ipAccess,omitempty"`
+	// RateLimit throttles the requests proxied to the origin
+	RateLimit *RateLimitConfig `yaml:"rateLimit" json:"rateLimit,omitempty"`
+	// OriginCompression compresses the bodies exchanged with origins that support it
+	OriginCompression *OriginCompressionConfig `yaml:"originCompression" json:"originCompression,omitempty"`
+	// Maintenance serves a static response instead of proxying to the origin
+	Maintenance *MaintenanceConfig `yaml:"maintenance" json:"maintenance,omitempty"`
+	// Mirror sends a copy of a share of the requests to a secondary origin
+	Mirror *MirrorConfig `yaml:"mirror" json:"mirror,omitempty"`
+	// RequestLimits bounds the size of requests and the time their body takes to be received
+	RequestLimits *RequestLimitsConfig `yaml:"requestLimits" json:"requestLimits,omitempty"`
+	// Dialer is the name of the dialer registered by the embedder the origin is dialed with, instead of dialing it
+	// directly
+	Dialer *string `yaml:"dialer" json:"dialer,omitempty"`
+	// SSHJump dials the origin through an SSH jump host
+	SSHJump *SSHJumpConfig `yaml:"sshJump" json:"sshJump,omitempty"`
+	// SPNEGO authenticates the requests to origins with Windows integrated authentication
+	SPNEGO *SPNEGOConfig `yaml:"spnego" json:"spnego,omitempty"`
+	// NTLMAffinity pins the legs of the NTLM handshakes of an eyeball to a single origin connection
+	NTLMAffinity *bool `yaml:"ntlmAffinity" json:"ntlmAffinity,omitempty"`
+	// TrustOnFirstUse pins the certificate presented by the origin on the first connection
+	TrustOnFirstUse *TrustOnFirstUseConfig `yaml:"trustOnFirstUse" json:"trustOnFirstUse,omitempty"`
+	// Priority is normal, or low for the requests to be answered with 503 while the connector sheds load
+	Priority *string `yaml:"priority" json:"priority,omitempty"`
+}
+
+type RetryConfig struct {
+	// MaxRetries is the maximum number of times an idempotent request without body is retried.
+	MaxRetries uint `yaml:"maxRetries" json:"maxRetries"`
+
+	// PerTryTimeout bounds the time each attempt can take to receive the response headers. Zero means no timeout.
+	PerTryTimeout CustomDuration `yaml:"perTryTimeout" json:"perTryTimeout"`
+
+	// BudgetPercent caps retries to this percentage of the requests proxied to the rule. Defaults to 20.
+	BudgetPercent uint `yaml:"budgetPercent" json:"budgetPercent,omitempty"`
+}
+
+// HedgingConfig cuts the tail latency of read-heavy origins: when the origin hasn't sent the response headers of an
+// idempotent request without body after Delay, a copy of the request is sent and whichever response arrives first is
+// served. With a load_balancer service, the copy goes to one of the healthy origins.
+type HedgingConfig struct {
+	// Delay is how long to wait for the response headers before hedging the request. Zero disables hedging.
+	Delay CustomDuration `yaml:"delay" json:"delay"`
+
+	// BudgetPercent caps hedged requests to this percentage of the requests proxied to the rule. Defaults to 10.
+	BudgetPercent uint `yaml:"budgetPercent" json:"budgetPercent,omitempty"`
+}
+
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed requests that opens the circuit.
+	FailureThreshold uint `yaml:"failureThreshold" json:"failureThreshold"`
+
+	// OpenDuration is how long the circuit stays open before a request is let through to probe the origin.
+	// Defaults to 30s.
+	OpenDuration CustomDuration `yaml:"openDuration" json:"openDuration"`
+
+	// ErrorStatus is the status code returned while the circuit is open. Defaults to 503.
+	ErrorStatus int `yaml:"errorStatus" json:"errorStatus,omitempty"`
+
+	// ErrorBody is the body returned while the circuit is open.
+	ErrorBody string `yaml:"errorBody" json:"errorBody,omitempty"`
+
+	// ErrorContentType is the content type of ErrorBody. Defaults to text/plain.
+	ErrorContentType string `yaml:"errorContentType" json:"errorContentType,omitempty"`
+}
+
+type CacheConfig struct {
+	// Enabled turns on caching of origin responses, honoring their Cache-Control and ETag headers.
+	Enabled bool `yaml:"enabled" json:"enabled"`
+
+	// MaxSize is the total size in bytes of the cached response bodies. Defaults to 64MiB.
+	MaxSize uint64 `yaml:"maxSize" json:"maxSize,omitempty"`
+
+	// MaxEntrySize is the size in bytes above which a response body is not cached. Defaults to 1MiB.
+	MaxEntrySize uint64 `yaml:"maxEntrySize" json:"maxEntrySize,omitempty"`
+
+	// Dir, when set, stores cached response bodies in this directory instead of memory.
+	Dir string `yaml:"dir" json:"dir,omitempty"`
+}
+
+type InspectionConfig struct {
+	// Inspectors are the names of the registered inspectors observing the requests of the rule.
+	Inspectors []string `yaml:"inspectors" json:"inspectors"`
+
+	// SampleRate is the fraction of requests inspected, between 0 and 1. Zero inspects every request.
+	SampleRate float64 `yaml:"sampleRate" json:"sampleRate,omitempty"`
+
+	// SampleBytes is the number of request and response body bytes shared with inspectors. Zero shares headers only.
+	SampleBytes uint `yaml:"sampleBytes" json:"sampleBytes,omitempty"`
+}
+
+type FastCGIConfig struct {
+	// DocumentRoot is the directory of the scripts, the request path is appended to it to build SCRIPT_FILENAME.
+	DocumentRoot string `yaml:"documentRoot" json:"documentRoot,omitempty"`
+
+	// ScriptFilename, when set, is the script handling every request, e.g. the front controller of a PHP framework.
+	ScriptFilename string `yaml:"scriptFilename" json:"scriptFilename,omitempty"`
+
+	// Index is the script run for request paths ending with a slash. Defaults to index.php.
+	Index string `yaml:"index" json:"index,omitempty"`
+
+	// Params are additional FastCGI parameters, overriding the ones derived from the request.
+	Params map[string]string `yaml:"params,omitempty" json:"params,omitempty"`
+}
+
+type StaticConfig struct {
+	// IndexFiles are served, in order of preference, for directory paths. Defaults to index.html.
+	IndexFiles []string `yaml:"indexFiles,omitempty" json:"indexFiles,omitempty"`
+
+	// DirectoryListing lists the content of directories without index file.
+	DirectoryListing bool `yaml:"directoryListing" json:"directoryListing,omitempty"`
+
+	// DisableRanges ignores Range headers, always serving full files.
+	DisableRanges bool `yaml:"disableRanges" json:"disableRanges,omitempty"`
+
+	// Compression gzips text responses for eyeballs that accept it.
+	Compression bool `yaml:"compression" json:"compression,omitempty"`
+
+	// Dotfiles is the policy for paths with a segment starting with a dot: ignore (404, the default), deny (403) or allow.
+	Dotfiles string `yaml:"dotfiles" json:"dotfiles,omitempty"`
+}
+
+type StreamingConfig struct {
+	// Mode is auto (the default), where responses are flushed according to their headers and the settings below, or
+	// stream, where every write is flushed right away regardless of the Content-Length of the response.
+	Mode string `yaml:"mode" json:"mode,omitempty"`
+
+	// FlushInterval is the longest time response bytes are buffered before being flushed. Zero leaves it to the
+	// connection with the edge.
+	FlushInterval CustomDuration `yaml:"flushInterval" json:"flushInterval,omitempty"`
+
+	// MaxBufferedBytes is the number of response bytes written after which they are flushed. Zero leaves it to the
+	// connection with the edge.
+	MaxBufferedBytes uint64 `yaml:"maxBufferedBytes" json:"maxBufferedBytes,omitempty"`
+}
+
+type WebSocketConfig struct {
+	// DisableCompression stops forwarding the permessage-deflate offers of eyeballs to the origin.
+	DisableCompression bool `yaml:"disableCompression" json:"disableCompression,omitempty"`
+
+	// MaxFrameSize is the largest frame payload, in bytes, accepted in either direction. Zero is unlimited.
+	MaxFrameSize uint64 `yaml:"maxFrameSize" json:"maxFrameSize,omitempty"`
+
+	// MaxMessageSize is the largest message, in bytes of possibly compressed payload, accepted in either direction.
+	// Zero is unlimited.
+	MaxMessageSize uint64 `yaml:"maxMessageSize" json:"maxMessageSize,omitempty"`
+
+	// IdleTimeout closes connections without any frame in either direction for this long. Zero never closes them.
+	IdleTimeout CustomDuration `yaml:"idleTimeout" json:"idleTimeout,omitempty"`
+}
+
+// SSHJumpConfig reaches the origin through an SSH jump host, like ssh -J does.
+type SSHJumpConfig struct {
+	// Host is the address of the jump host, on port 22 if it has none.
+	Host string `yaml:"host" json:"host,omitempty"`
+
+	// User is the user authenticating to the jump host.
+	User string `yaml:"user" json:"user,omitempty"`
+
+	// KeyFile is the path of the unencrypted private key authenticating the user.
+	KeyFile string `yaml:"keyFile" json:"keyFile,omitempty"`
+
+	// Agent authenticates the user with the keys of the SSH agent listening on SSH_AUTH_SOCK.
+	Agent bool `yaml:"agent" json:"agent,omitempty"`
+
+	// KnownHostsFile is the known_hosts file verifying the host key of the jump host, ~/.ssh/known_hosts by default.
+	KnownHostsFile string `yaml:"knownHostsFile" json:"knownHostsFile,omitempty"`
+}
+
+// SPNEGOConfig authenticates cloudflared to origins with Windows integrated authentication, such as IIS or Exchange,
+// by attaching a Kerberos token to the requests with the Negotiate scheme.
+type SPNEGOConfig struct {
+	// SPN is the service principal name of the origin, HTTP/<hostname of the origin> by default.
+	SPN string `yaml:"spn" json:"spn,omitempty"`
+
+	// Provider is the name of the registered provider obtaining the tokens.
+	Provider string `yaml:"provider" json:"provider,omitempty"`
+
+	// TokenCommand obtains the tokens when there's no provider. It's run with the SPN as last argument and prints a
+	// base64 encoded SPNEGO token.
+	TokenCommand []string `yaml:"tokenCommand" json:"tokenCommand,omitempty"`
+
+	// RefreshCommand renews the Kerberos ticket cache, e.g. kinit -R, every RefreshInterval and when the origin
+	// rejects a token.
+	RefreshCommand []string `yaml:"refreshCommand" json:"refreshCommand,omitempty"`
+
+	// RefreshInterval is how often the ticket cache is renewed, 1 hour by default.
+	RefreshInterval CustomDuration `yaml:"refreshInterval" json:"refreshInterval,omitempty"`
+}
+
+// Enabled returns whether the requests are authenticated with SPNEGO.
+func (c SPNEGOConfig) Enabled() bool {
+	return c.Provider != "" || len(c.TokenCommand) > 0
+}
+
+type TrustOnFirstUseConfig struct {
+	// Enabled trusts the certificate presented by the origin on the first connection, and only it afterwards.
+	// Without a CA pool, it replaces the verification of the certificate, which is useful for origins with
+	// self-signed certificates. With a CA pool, the certificate must be verified by it too.
+	Enabled bool `yaml:"enabled" json:"enabled,omitempty"`
+
+	// File keeps the pinned certificates across restarts. They are only kept in memory when empty.
+	File string `yaml:"file" json:"file,omitempty"`
+
+	// RepinOnChange reports the origins presenting another certificate than the pinned one and pins the new one,
+	// instead of rejecting the connections. It leaves the origins open to interception, so it's only meant for
+	// origins whose certificates are rotated without notice.
+	RepinOnChange bool `yaml:"repinOnChange" json:"repinOnChange,omitempty"`
+}
+
+type ProxyProtocolConfig struct {
+	// Version of the PROXY protocol header sent to tcp origins, v1 or v2. No header is sent when empty.
+	Version string `yaml:"version" json:"version,omitempty"`
+
+	// Authority sends the hostname requested by the eyeball in a PP2_TYPE_AUTHORITY TLV. v2 only.
+	Authority bool `yaml:"authority" json:"authority,omitempty"`
+
+	// UniqueID sends the Cf-Ray of the request in a PP2_TYPE_UNIQUE_ID TLV. v2 only.
+	UniqueID bool `yaml:"uniqueID" json:"uniqueID,omitempty"`
+
+	// TLVs are additional TLVs sent with every header. v2 only.
+	TLVs []ProxyProtocolTLV `yaml:"tlvs,omitempty" json:"tlvs,omitempty"`
+}
+
+type ProxyProtocolTLV struct {
+	// Type of the TLV. Types 0xE0 to 0xEF are reserved for custom use by the PROXY protocol.
+	Type uint8 `yaml:"type" json:"type"`
+
+	// Value of the TLV.
+	Value string `yaml:"value" json:"value"`
+}
+
+type UDPConfig struct {
+	// Address is the IP address and port the UDP sessions for the service are sent to through the tunnel. Defaults to
+	// the address of the service when it is an IP address.
+	Address string `yaml:"address" json:"address,omitempty"`
+
+	// IdleTimeout closes sessions without traffic for this long, overriding the idle timeout requested by the edge.
+	IdleTimeout CustomDuration `yaml:"idleTimeout" json:"idleTimeout,omitempty"`
+
+	// MaxSessions is the number of concurrent sessions to the service. Zero is unlimited.
+	MaxSessions uint64 `yaml:"maxSessions" json:"maxSessions,omitempty"`
+}
+
+// LoadBalancerConfig lists the origins a load_balancer ingress service spreads requests across.
+type LoadBalancerConfig struct {
+	// Origins are the HTTP origins that requests are balanced across.
+	Origins []LoadBalancerOrigin `yaml:"origins" json:"origins"`
+
+	// HealthCheck configures the active health checks used to remove unhealthy origins from rotation.
+	HealthCheck HealthCheckConfig `yaml:"healthCheck" json:"healthCheck"`
+}
+
+// LoadBalancerOrigin is an origin of a load_balancer ingress service.
+type LoadBalancerOrigin struct {
+	// URL of the origin, e.g. http://localhost:8080
+	URL string `yaml:"url" json:"url"`
+
+	// Weight is the relative share of requests sent to this origin. Zero is treated as 1.
+	Weight uint `yaml:"weight" json:"weight,omitempty"`
+}
+
+// CanaryConfig splits the requests of a canary ingress service between a primary and a canary origin. Requests
+// matching any of Match go to the canary origin, and so does a Weight percent of the eyeballs. Eyeballs are assigned
+// by a hash of their IP, so that they keep hitting the same origin.
+type CanaryConfig struct {
+	// Primary is the URL of the origin serving the requests not routed to the canary, e.g. http://localhost:8080
+	Primary string `yaml:"primary" json:"primary"`
+
+	// Canary is the URL of the canary origin, e.g. http://localhost:8081
+	Canary string `yaml:"canary" json:"canary"`
+
+	// Weight is the percentage of eyeballs routed to the canary origin, between 0 and 100.
+	Weight float64 `yaml:"weight" json:"weight,omitempty"`
+
+	// Match routes the requests matching any of the rules to the canary origin, regardless of Weight.
+	Match []CanaryMatch `yaml:"match" json:"match,omitempty"`
+}
+
+// CanaryMatch matches requests by a header or a cookie. Exactly one of Header and Cookie is set.
+type CanaryMatch struct {
+	// Header is the name of the request header matched.
+	Header string `yaml:"header" json:"header,omitempty"`
+
+	// Cookie is the name of the request cookie matched.
+	Cookie string `yaml:"cookie" json:"cookie,omitempty"`
+
+	// Value is the value the header or cookie must have. When empty, any request with the header or cookie matches.
+	Value string `yaml:"value" json:"value,omitempty"`
+}
+
+// HealthCheckConfig configures the active health checks of the origins of a load_balancer ingress service.
+type HealthCheckConfig struct {
+	// Type is either "http" (GET request) or "tcp" (connect only). Defaults to "http".
+	Type string `yaml:"type" json:"type,omitempty"`
+
+	// Path requested by "http" health checks. Defaults to "/".
+	Path string `yaml:"path" json:"path,omitempty"`
+
+	// Interval between health checks of each origin.
+	Interval CustomDuration `yaml:"interval" json:"interval"`
+
+	// Timeout of a single health check.
+	Timeout CustomDuration `yaml:"timeout" json:"timeout"`
+
+	// UnhealthyThreshold is the number of consecutive failures before an origin is removed from rotation.
+	UnhealthyThreshold uint `yaml:"unhealthyThreshold" json:"unhealthyThreshold,omitempty"`
+
+	// HealthyThreshold is the number of consecutive successes before an origin is put back in rotation.
+	HealthyThreshold uint `yaml:"healthyThreshold" json:"healthyThreshold,omitempty"`
 }
 
 type AccessConfig struct {
@@ -244,6 +583,88 @@ type AccessConfig struct {
 	AudTag []string `yaml:"audTag" json:"audTag"`
 }
 
+// IPAccessConfig restricts the eyeballs that can reach the origin of a rule by their IP, as reported by the edge in
+// Cf-Connecting-Ip.
+type IPAccessConfig struct {
+	// Allow lists the networks, in CIDR notation, that can reach the origin. All the networks that aren't denied can
+	// when empty.
+	Allow []string `yaml:"allow" json:"allow,omitempty"`
+	// Deny lists the networks, in CIDR notation, that can't reach the origin, even if they belong to an allowed
+	// network.
+	Deny []string `yaml:"deny" json:"deny,omitempty"`
+}
+
+// RateLimitConfig throttles the requests of an ingress rule with a token bucket per eyeball IP or request header.
+type RateLimitConfig struct {
+	// RequestsPerSecond is the rate of requests allowed for each key. 0 disables rate limiting.
+	RequestsPerSecond float64 `yaml:"requestsPerSecond" json:"requestsPerSecond,omitempty"`
+	// Burst is the number of requests allowed at once for each key. Defaults to RequestsPerSecond, and at least 1.
+	Burst uint `yaml:"burst" json:"burst,omitempty"`
+	// Key is what requests are limited by: "ip" for the eyeball IP, the default, or "header".
+	Key string `yaml:"key" json:"key,omitempty"`
+	// Header is the request header whose value requests are limited by, when Key is "header".
+	Header string `yaml:"header" json:"header,omitempty"`
+}
+
+// OriginCompressionConfig negotiates the compression of the bodies exchanged with the origin of a rule, to save
+// bandwidth to origins reached over slow links. Responses are requested compressed and decompressed for the eyeballs
+// that don't accept the encoding. Request bodies are compressed once the origin advertises support with an
+// Accept-Encoding response header.
+type OriginCompressionConfig struct {
+	// Encodings lists the content codings to use with the origin, by preference. Only gzip is supported.
+	Encodings []string `yaml:"encodings" json:"encodings,omitempty"`
+	// Level is the compression level of request bodies, from 1 (fastest) to 9 (smallest). Defaults to 6.
+	Level int `yaml:"level" json:"level,omitempty"`
+	// MinSize is the size in bytes under which request bodies of known length are sent as is.
+	MinSize int64 `yaml:"minSize" json:"minSize,omitempty"`
+}
+
+// MaintenanceConfig configures the response served instead of the origin's while an ingress rule is in maintenance.
+// Rules are in maintenance when Enabled is set, or when maintenance is turned on through the management or control
+// API.
+type MaintenanceConfig struct {
+	// Enabled puts the rule in maintenance.
+	Enabled bool `yaml:"enabled" json:"enabled,omitempty"`
+	// Status is the status code of the response. Defaults to 503.
+	Status int `yaml:"status" json:"status,omitempty"`
+	// Body is the body of the response, e.g. an HTML page or a JSON document.
+	Body string `yaml:"body" json:"body,omitempty"`
+	// ContentType is the content type of Body. Defaults to text/html; charset=utf-8.
+	ContentType string `yaml:"contentType" json:"contentType,omitempty"`
+	// RetryAfter, when set, is sent to eyeballs in a Retry-After header.
+	RetryAfter CustomDuration `yaml:"retryAfter" json:"retryAfter,omitempty"`
+}
+
+// MirrorConfig mirrors a share of the requests of an ingress rule to a secondary origin, e.g. to test a new backend
+// with production traffic. Mirrored requests are sent in the background and their responses are discarded, so the
+// secondary origin never affects the responses served to eyeballs.
+type MirrorConfig struct {
+	// Origin is the http:// or https:// URL of the secondary origin.
+	Origin string `yaml:"origin" json:"origin"`
+	// Percent is the percentage of requests mirrored, between 0 and 100.
+	Percent float64 `yaml:"percent" json:"percent"`
+	// Timeout bounds the time a mirrored request can take. Defaults to 10s.
+	Timeout CustomDuration `yaml:"timeout" json:"timeout,omitempty"`
+	// MaxBodySize is the size in bytes of the largest request body mirrored, since mirrored bodies are buffered.
+	// Requests with larger bodies aren't mirrored. Defaults to 64KiB.
+	MaxBodySize int64 `yaml:"maxBodySize" json:"maxBodySize,omitempty"`
+}
+
+// RequestLimitsConfig protects origins from large requests and from eyeballs sending their request body slowly to
+// tie origins up. Requests are rejected with a 413 when their body is too large, a 431 when their headers are, and a
+// 408 when their body isn't received in time. Headers are received whole from the edge, so only their size is
+// limited.
+type RequestLimitsConfig struct {
+	// MaxBodySize is the size in bytes of the largest request body proxied. Zero doesn't limit it.
+	MaxBodySize int64 `yaml:"maxBodySize" json:"maxBodySize,omitempty"`
+	// MaxHeaderSize is the size in bytes of the largest request line and headers proxied. Zero doesn't limit it.
+	MaxHeaderSize int `yaml:"maxHeaderSize" json:"maxHeaderSize,omitempty"`
+	// ReadBodyTimeout bounds the time the request body takes to be received. Zero doesn't limit it.
+	ReadBodyTimeout CustomDuration `yaml:"readBodyTimeout" json:"readBodyTimeout,omitempty"`
+	// ReadBodyIdleTimeout bounds the time without receiving any byte of the request body. Zero doesn't limit it.
+	ReadBodyIdleTimeout CustomDuration `yaml:"readBodyIdleTimeout" json:"readBodyIdleTimeout,omitempty"`
+}
+
 type IngressIPRule struct {
 	Prefix *string `yaml:"prefix" json:"prefix"`
 	Ports  []int   `yaml:"ports" json:"ports"`
@@ -262,6 +683,35 @@ type WarpRoutingConfig struct {
 	ConnectTimeout *CustomDuration `yaml:"connectTimeout" json:"connectTimeout,omitempty"`
 	MaxActiveFlows *uint64         `yaml:"maxActiveFlows" json:"maxActiveFlows,omitempty"`
 	TCPKeepAlive   *CustomDuration `yaml:"tcpKeepAlive" json:"tcpKeepAlive,omitempty"`
+	// QoS assigns priority classes to UDP flows by destination network
+	QoS []QoSRule `yaml:"qos,omitempty" json:"qos,omitempty"`
+	// ICMP sets the policy of the ICMP packets proxied to private networks. It's only read from the local configuration.
+	ICMP *ICMPPolicyConfig `yaml:"icmp,omitempty" json:"icmp,omitempty"`
+	// NAT64Prefix is the IPv6 prefix, such as 64:ff9b::/96, that IPv4 origins are reached through by IPv6-only
+	// clients. AAAA records are synthesized in it by the DNS resolver service. It's only read from the local
+	// configuration.
+	NAT64Prefix string `yaml:"nat64Prefix,omitempty" json:"nat64Prefix,omitempty"`
+	// LocalNAT64Prefix is the IPv6 prefix that IPv4 origins are dialed through when cloudflared runs on an IPv6-only
+	// host, or "auto" to discover it from the DNS64 resolver of the host when it has no IPv4 route. It's only read
+	// from the local configuration.
+	LocalNAT64Prefix string `yaml:"localNat64Prefix,omitempty" json:"localNat64Prefix,omitempty"`
+}
+
+// ICMPPolicyConfig restricts the ICMP echo requests proxied to private networks.
+type ICMPPolicyConfig struct {
+	// AllowedDestinations lists the networks, in CIDR notation, that can be pinged. All destinations are allowed when
+	// empty.
+	AllowedDestinations []string `yaml:"allowedDestinations" json:"allowedDestinations,omitempty"`
+	// MaxEchoRatePerSource limits the echo requests per second of each source IP. 0 means unlimited.
+	MaxEchoRatePerSource uint64 `yaml:"maxEchoRatePerSource" json:"maxEchoRatePerSource,omitempty"`
+	// MaxTTL clamps the TTL of the echo requests sent to origins. 0 keeps the TTL of the requests.
+	MaxTTL uint8 `yaml:"maxTTL" json:"maxTTL,omitempty"`
+}
+
+// QoSRule assigns the UDP flows to Network, in CIDR notation, to a QoS class: bulk, standard or realtime.
+type QoSRule struct {
+	Network string `yaml:"network" json:"network"`
+	Class   string `yaml:"class" json:"class"`
 }
 
 type configFileSettings struct {
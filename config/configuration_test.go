@@ -144,7 +144,8 @@ var rawJsonConfig = []byte(`
 			"allow": true
 		}
 	],
-	"http2Origin": true
+	"http2Origin": true,
+	"fingerprintHeaders": {"ja4": "X-JA4"}
 }
 `)
 
@@ -192,6 +193,7 @@ func assertConfig(
 	assert.Equal(t, uint(9000), *config.ProxyPort)
 	assert.Equal(t, "socks", *config.ProxyType)
 	assert.Equal(t, true, *config.Http2Origin)
+	assert.Equal(t, map[string]string{"ja4": "X-JA4"}, config.FingerprintHeaders)
 
 	privateV4 := "10.0.0.0/8"
 	privateV6 := "fc00::/7"
@@ -14,6 +14,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/cloudflare/cloudflared/tracing"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
@@ -317,3 +318,11 @@ func FindCfRayHeader(req *http.Request) string {
 func IsLBProbeRequest(req *http.Request) bool {
 	return strings.HasPrefix(req.UserAgent(), lbProbeUserAgentPrefix)
 }
+
+func endRequestSpan(span trace.Span, err error) {
+	if err != nil {
+		tracing.EndWithErrorStatus(span, err)
+		return
+	}
+	tracing.End(span)
+}
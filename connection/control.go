@@ -3,7 +3,9 @@ package connection
 import (
 	"context"
 	"io"
+	"math"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -13,6 +15,34 @@ import (
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
 )
 
+const (
+	// registrationRetries is how many times a registration that timed out is retried on the same control stream.
+	registrationRetries = 2
+	// defaultHeartbeatLossFactor is the number of heartbeat intervals without answer after which the connection is
+	// considered lost, unless the loss window is set.
+	defaultHeartbeatLossFactor = 3
+)
+
+// ErrHeartbeatsLost is returned when the edge stopped answering the heartbeats of the control stream.
+var ErrHeartbeatsLost = errors.New("the edge stopped answering the heartbeats of the control stream")
+
+// HeartbeatConfig configures the heartbeats sent to the edge on the control stream, which detect a silently dropped
+// connection sooner than the idle timeout of the transport. The zero value disables them.
+type HeartbeatConfig struct {
+	// Interval is the time between heartbeats
+	Interval time.Duration
+	// LossWindow is how long the edge can leave the heartbeats unanswered before the connection is dropped, three
+	// intervals if 0.
+	LossWindow time.Duration
+}
+
+func (c HeartbeatConfig) lossWindow() time.Duration {
+	if c.LossWindow > 0 {
+		return c.LossWindow
+	}
+	return defaultHeartbeatLossFactor * c.Interval
+}
+
 // registerClient derives a named tunnel rpc client that can then be used to register and unregister connections.
 type registerClientFunc func(context.Context, io.ReadWriteCloser, time.Duration) tunnelrpc.RegistrationClient
 
@@ -31,6 +61,8 @@ type controlStream struct {
 	gracefulShutdownC <-chan struct{}
 	gracePeriod       time.Duration
 	stoppedGracefully bool
+
+	heartbeat HeartbeatConfig
 }
 
 // ControlStreamHandler registers connections with origintunneld and initiates graceful shutdown.
@@ -57,6 +89,7 @@ func NewControlStream(
 	gracefulShutdownC <-chan struct{},
 	gracePeriod time.Duration,
 	protocol Protocol,
+	heartbeat HeartbeatConfig,
 ) ControlStreamHandler {
 	if registerClientFunc == nil {
 		registerClientFunc = tunnelrpc.NewRegistrationClient
@@ -72,6 +105,7 @@ func NewControlStream(
 		gracefulShutdownC:  gracefulShutdownC,
 		gracePeriod:        gracePeriod,
 		protocol:           protocol,
+		heartbeat:          heartbeat,
 	}
 }
 
@@ -83,19 +117,17 @@ func (c *controlStream) ServeControlStream(
 ) error {
 	registrationClient := c.registerClientFunc(ctx, rw, c.registerTimeout)
 
-	registrationDetails, err := registrationClient.RegisterConnection(
-		ctx,
-		c.tunnelProperties.Credentials.Auth(),
-		c.tunnelProperties.Credentials.TunnelID,
-		connOptions,
-		c.connIndex,
-		c.edgeAddress)
+	registrationDetails, err := c.registerConnection(ctx, registrationClient, connOptions)
 	if err != nil {
 		defer registrationClient.Close()
 		if err.Error() == DuplicateConnectionError {
 			c.observer.metrics.regFail.WithLabelValues("dup_edge_conn", "registerConnection").Inc()
 			return errDuplicationConnection
 		}
+		if isRegistrationTimeout(ctx, err) {
+			// The edge may just be slow to answer, so the connection is worth dialing again
+			return ServerRegisterTunnelError{Cause: err, Permanent: false}
+		}
 		c.observer.metrics.regFail.WithLabelValues("server_error", "registerConnection").Inc()
 		return serverRegistrationErrorFromRPC(err)
 	}
@@ -121,9 +153,62 @@ func (c *controlStream) ServeControlStream(
 	return c.waitForUnregister(ctx, registrationClient)
 }
 
+// registerConnection registers the connection, retrying on the same stream when the registration times out.
+//
+// The edge may have registered the connection even though its answer didn't arrive in time, in which case a plain
+// retry would be rejected as a duplicate and the connection torn down. The retries are made idempotent instead: they
+// are keyed by the client ID of the connector and the index of the connection, and replace the registration of that
+// key if the edge has it, so that any number of attempts leaves exactly one registration.
+func (c *controlStream) registerConnection(
+	ctx context.Context,
+	registrationClient tunnelrpc.RegistrationClient,
+	connOptions *pogs.ConnectionOptions,
+) (*pogs.ConnectionDetails, error) {
+	options := *connOptions
+	for attempt := 0; ; attempt++ {
+		registrationDetails, err := registrationClient.RegisterConnection(
+			ctx,
+			c.tunnelProperties.Credentials.Auth(),
+			c.tunnelProperties.Credentials.TunnelID,
+			&options,
+			c.connIndex,
+			c.edgeAddress)
+		if err == nil || !isRegistrationTimeout(ctx, err) {
+			return registrationDetails, err
+		}
+		c.observer.metrics.regFail.WithLabelValues("timeout", "registerConnection").Inc()
+		if attempt >= registrationRetries {
+			return nil, err
+		}
+		c.observer.log.Warn().
+			Int(management.EventTypeKey, int(management.Cloudflared)).
+			Uint8(LogFieldConnIndex, c.connIndex).
+			IPAddr(LogFieldIPAddress, c.edgeAddress).
+			Int("attempt", attempt+1).
+			Msg("Registration of the tunnel connection timed out, retrying")
+		options.ReplaceExisting = true
+		if options.NumPreviousAttempts < math.MaxUint8 {
+			options.NumPreviousAttempts++
+		}
+	}
+}
+
+// isRegistrationTimeout returns whether the registration failed because the edge didn't answer in time, rather than
+// because the connection is shutting down.
+func isRegistrationTimeout(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	// The RPC layer doesn't always keep the context error in the chain
+	return errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), context.DeadlineExceeded.Error())
+}
+
 func (c *controlStream) waitForUnregister(ctx context.Context, registrationClient tunnelrpc.RegistrationClient) error {
 	// wait for connection termination or start of graceful shutdown
 	defer registrationClient.Close()
+	heartbeatCtx, stopHeartbeats := context.WithCancel(ctx)
+	defer stopHeartbeats()
+	heartbeatsLostC := c.sendHeartbeats(heartbeatCtx, registrationClient)
 	var shutdownError error
 	select {
 	case <-ctx.Done():
@@ -131,7 +216,17 @@ func (c *controlStream) waitForUnregister(ctx context.Context, registrationClien
 		break
 	case <-c.gracefulShutdownC:
 		c.stoppedGracefully = true
+	case <-heartbeatsLostC:
+		// Unregistering would time out too, the connection is dropped so that it's dialed again
+		c.observer.log.Warn().
+			Int(management.EventTypeKey, int(management.Cloudflared)).
+			Uint8(LogFieldConnIndex, c.connIndex).
+			IPAddr(LogFieldIPAddress, c.edgeAddress).
+			Dur("lossWindow", c.heartbeat.lossWindow()).
+			Msg("The edge stopped answering the heartbeats of the connection, reconnecting")
+		return ErrHeartbeatsLost
 	}
+	stopHeartbeats()
 
 	c.observer.sendUnregisteringEvent(c.connIndex)
 	err := registrationClient.GracefulShutdown(ctx, c.gracePeriod)
@@ -146,6 +241,47 @@ func (c *controlStream) waitForUnregister(ctx context.Context, registrationClien
 	return shutdownError
 }
 
+// sendHeartbeats sends heartbeats to the edge until ctx is done, returning a channel closed once the edge left them
+// unanswered for the loss window. The channel is nil when the heartbeats are disabled.
+func (c *controlStream) sendHeartbeats(ctx context.Context, registrationClient tunnelrpc.RegistrationClient) <-chan struct{} {
+	heartbeater, ok := registrationClient.(tunnelrpc.Heartbeater)
+	if !ok || c.heartbeat.Interval <= 0 {
+		return nil
+	}
+	lostC := make(chan struct{})
+	connectionID := uint8ToString(c.connIndex)
+	go func() {
+		ticker := time.NewTicker(c.heartbeat.Interval)
+		defer ticker.Stop()
+		lastAnswer := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			heartbeatCtx, cancel := context.WithTimeout(ctx, c.heartbeat.Interval)
+			start := time.Now()
+			err := heartbeater.Heartbeat(heartbeatCtx)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				lastAnswer = time.Now()
+				c.observer.metrics.heartbeatRTT.WithLabelValues(connectionID).Set(lastAnswer.Sub(start).Seconds())
+				continue
+			}
+			c.observer.metrics.heartbeatsLost.WithLabelValues(connectionID).Inc()
+			if time.Since(lastAnswer) >= c.heartbeat.lossWindow() {
+				close(lostC)
+				return
+			}
+		}
+	}()
+	return lostC
+}
+
 func (c *controlStream) IsStopped() bool {
 	return c.stoppedGracefully
 }
@@ -0,0 +1,163 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/tunnelrpc"
+	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
+)
+
+// timingOutRegistrationClient times out the first timeouts registrations.
+type timingOutRegistrationClient struct {
+	timeouts int
+	err      error
+	attempts []pogs.ConnectionOptions
+}
+
+func (c *timingOutRegistrationClient) RegisterConnection(
+	_ context.Context,
+	_ pogs.TunnelAuth,
+	_ uuid.UUID,
+	options *pogs.ConnectionOptions,
+	_ uint8,
+	_ net.IP,
+) (*pogs.ConnectionDetails, error) {
+	c.attempts = append(c.attempts, *options)
+	if len(c.attempts) <= c.timeouts {
+		return nil, c.err
+	}
+	return &pogs.ConnectionDetails{UUID: uuid.New(), Location: "LIS"}, nil
+}
+
+func (c *timingOutRegistrationClient) SendLocalConfiguration(context.Context, []byte) error {
+	return nil
+}
+
+func (c *timingOutRegistrationClient) GracefulShutdown(context.Context, time.Duration) error {
+	return nil
+}
+
+func (c *timingOutRegistrationClient) Close() {}
+
+func newTestControlStream(client tunnelrpc.RegistrationClient, heartbeat HeartbeatConfig) *controlStream {
+	log := zerolog.Nop()
+	return NewControlStream(
+		NewObserver(&log, &log),
+		mockConnectedFuse{},
+		&TunnelProperties{},
+		1,
+		nil,
+		func(context.Context, io.ReadWriteCloser, time.Duration) tunnelrpc.RegistrationClient { return client },
+		time.Second,
+		nil,
+		time.Second,
+		QUIC,
+		heartbeat,
+	).(*controlStream)
+}
+
+// heartbeatingRegistrationClient registers right away, then answers the heartbeats until lost is closed.
+type heartbeatingRegistrationClient struct {
+	timingOutRegistrationClient
+	lost chan struct{}
+}
+
+func (c *heartbeatingRegistrationClient) Heartbeat(ctx context.Context) error {
+	select {
+	case <-c.lost:
+		<-ctx.Done()
+		return ctx.Err()
+	default:
+		return nil
+	}
+}
+
+func TestControlStreamReconnectsOnLostHeartbeats(t *testing.T) {
+	client := &heartbeatingRegistrationClient{lost: make(chan struct{})}
+	controlStream := newTestControlStream(client, HeartbeatConfig{Interval: 10 * time.Millisecond, LossWindow: 50 * time.Millisecond})
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- controlStream.ServeControlStream(t.Context(), nil, &pogs.ConnectionOptions{}, nil)
+	}()
+	// The connection is kept while the edge answers
+	select {
+	case err := <-errC:
+		t.Fatalf("control stream returned while the heartbeats were answered: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(client.lost)
+	select {
+	case err := <-errC:
+		require.ErrorIs(t, err, ErrHeartbeatsLost)
+	case <-time.After(5 * time.Second):
+		t.Fatal("control stream wasn't dropped once the heartbeats were lost")
+	}
+}
+
+func TestControlStreamWithoutHeartbeats(t *testing.T) {
+	client := &heartbeatingRegistrationClient{lost: make(chan struct{})}
+	close(client.lost)
+	controlStream := newTestControlStream(client, HeartbeatConfig{})
+
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	err := controlStream.ServeControlStream(ctx, nil, &pogs.ConnectionOptions{}, nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRegisterConnectionRetriesTimeouts(t *testing.T) {
+	client := &timingOutRegistrationClient{timeouts: 2, err: errors.New("rpc: context deadline exceeded")}
+	controlStream := newTestControlStream(client, HeartbeatConfig{})
+
+	details, err := controlStream.registerConnection(t.Context(), client, &pogs.ConnectionOptions{NumPreviousAttempts: 3})
+	require.NoError(t, err)
+	assert.Equal(t, "LIS", details.Location)
+	require.Len(t, client.attempts, 3)
+	// The first attempt is sent as is, the retries replace the registration the edge may have made
+	assert.False(t, client.attempts[0].ReplaceExisting)
+	assert.Equal(t, uint8(3), client.attempts[0].NumPreviousAttempts)
+	assert.True(t, client.attempts[1].ReplaceExisting)
+	assert.Equal(t, uint8(4), client.attempts[1].NumPreviousAttempts)
+	assert.True(t, client.attempts[2].ReplaceExisting)
+	assert.Equal(t, uint8(5), client.attempts[2].NumPreviousAttempts)
+}
+
+func TestRegisterConnectionGivesUpOnTimeouts(t *testing.T) {
+	client := &timingOutRegistrationClient{timeouts: 10, err: context.DeadlineExceeded}
+	controlStream := newTestControlStream(client, HeartbeatConfig{})
+
+	err := controlStream.ServeControlStream(t.Context(), nil, &pogs.ConnectionOptions{}, nil)
+	var registerErr ServerRegisterTunnelError
+	require.ErrorAs(t, err, &registerErr)
+	assert.False(t, registerErr.Permanent)
+	assert.Len(t, client.attempts, registrationRetries+1)
+}
+
+func TestRegisterConnectionDoesntRetryOtherErrors(t *testing.T) {
+	client := &timingOutRegistrationClient{timeouts: 10, err: errors.New("Unauthorized")}
+	controlStream := newTestControlStream(client, HeartbeatConfig{})
+
+	_, err := controlStream.registerConnection(t.Context(), client, &pogs.ConnectionOptions{})
+	require.Error(t, err)
+	assert.Len(t, client.attempts, 1)
+
+	// Nor when the connection is shutting down
+	client = &timingOutRegistrationClient{timeouts: 10, err: context.DeadlineExceeded}
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	_, err = controlStream.registerConnection(ctx, client, &pogs.ConnectionOptions{})
+	require.Error(t, err)
+	assert.Len(t, client.attempts, 1)
+}
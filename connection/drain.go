@@ -0,0 +1,118 @@
+package connection
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/errcodes"
+)
+
+const (
+	http2FrameHeaderLen = 9
+	http2FrameGoAway    = 0x7
+
+	// edgeDrainTimeout bounds the time a draining connection keeps serving its in-flight requests.
+	edgeDrainTimeout = 90 * time.Second
+)
+
+// ErrEdgeDraining is returned when the edge asked to drain the connection with a GOAWAY frame on HTTP/2. The
+// connection should be replaced right away, it is not a failure. QUIC has no such signal: a connection closed by the
+// edge is lost like any other.
+var ErrEdgeDraining = errcodes.New(errcodes.EdgeDraining, "edge is draining the connection")
+
+// drainError is ErrEdgeDraining for a connection that keeps serving its in-flight requests until done is closed.
+type drainError struct {
+	done <-chan struct{}
+}
+
+func (e *drainError) Error() string {
+	return ErrEdgeDraining.Error()
+}
+
+func (e *drainError) Unwrap() error {
+	return ErrEdgeDraining
+}
+
+// AfterDrained calls f once the connection that returned err has finished: right away, unless err reports a
+// drained connection still serving its in-flight requests.
+func AfterDrained(err error, f func()) {
+	var drainErr *drainError
+	if !errors.As(err, &drainErr) {
+		f()
+		return
+	}
+	go func() {
+		<-drainErr.done
+		f()
+	}()
+}
+
+func logEdgeDrain(log *zerolog.Logger, connIndex uint8, protocol Protocol) {
+	newTunnelMetrics().edgeDrains.WithLabelValues(protocol.String()).Inc()
+	log.Info().Uint8(LogFieldConnIndex, connIndex).Msgf("Edge is draining the %s connection, replacing it", protocol)
+}
+
+// goAwayConn watches the frames the edge sends on an HTTP/2 connection for GOAWAY. The http2 server gracefully
+// shuts down on GOAWAY by itself, but doesn't tell its caller, which would only see the connection close once the
+// in-flight requests are done.
+type goAwayConn struct {
+	net.Conn
+	onGoAway func()
+	once     sync.Once
+
+	// The edge is the HTTP/2 client, so the stream starts with the client preface
+	prefaceLeft int
+	header      [http2FrameHeaderLen]byte
+	headerLen   int
+	payloadLeft int
+}
+
+func newGoAwayConn(conn net.Conn, onGoAway func()) *goAwayConn {
+	return &goAwayConn{
+		Conn:        conn,
+		onGoAway:    onGoAway,
+		prefaceLeft: len(http2ClientPreface),
+	}
+}
+
+// The preface is defined by RFC 9113, section 3.4
+const http2ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+func (c *goAwayConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.observe(b[:n])
+	return n, err
+}
+
+// observe tracks frame boundaries in the bytes read. Reads only happen on the frame reader goroutine of the
+// http2 server, so no locking is needed.
+func (c *goAwayConn) observe(b []byte) {
+	for len(b) > 0 {
+		switch {
+		case c.prefaceLeft > 0:
+			skip := min(c.prefaceLeft, len(b))
+			c.prefaceLeft -= skip
+			b = b[skip:]
+		case c.payloadLeft > 0:
+			skip := min(c.payloadLeft, len(b))
+			c.payloadLeft -= skip
+			b = b[skip:]
+		default:
+			copied := copy(c.header[c.headerLen:], b)
+			c.headerLen += copied
+			b = b[copied:]
+			if c.headerLen < http2FrameHeaderLen {
+				continue
+			}
+			c.headerLen = 0
+			c.payloadLeft = int(c.header[0])<<16 | int(c.header[1])<<8 | int(c.header[2])
+			if c.header[3] == http2FrameGoAway {
+				c.once.Do(c.onGoAway)
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package connection
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+// chunkedConn returns the bytes of its reader in reads of at most chunkSize bytes.
+type chunkedConn struct {
+	net.Conn
+	r         io.Reader
+	chunkSize int
+}
+
+func (c *chunkedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b[:min(len(b), c.chunkSize)])
+}
+
+func TestGoAwayConn(t *testing.T) {
+	var stream bytes.Buffer
+	stream.WriteString(http2ClientPreface)
+	framer := http2.NewFramer(&stream, nil)
+	require.NoError(t, framer.WriteSettings(http2.Setting{ID: http2.SettingMaxFrameSize, Val: 1 << 14}))
+	// The payload of this frame contains the GOAWAY frame type, it must not be mistaken for a frame header
+	require.NoError(t, framer.WriteData(1, false, bytes.Repeat([]byte{http2FrameGoAway}, 100)))
+	require.NoError(t, framer.WritePing(false, [8]byte{}))
+
+	withoutGoAway := stream.Len()
+	require.NoError(t, framer.WriteGoAway(1, http2.ErrCodeNo, nil))
+	require.NoError(t, framer.WriteGoAway(1, http2.ErrCodeNo, []byte("again")))
+
+	for _, chunkSize := range []int{1, 3, 9, 16, 4096} {
+		goAways := 0
+		conn := newGoAwayConn(&chunkedConn{r: bytes.NewReader(stream.Bytes()), chunkSize: chunkSize}, func() {
+			goAways++
+		})
+
+		_, err := io.CopyN(io.Discard, conn, int64(withoutGoAway))
+		require.NoError(t, err)
+		require.Equal(t, 0, goAways, "chunk size %d", chunkSize)
+
+		_, err = io.Copy(io.Discard, conn)
+		require.NoError(t, err)
+		require.Equal(t, 1, goAways, "chunk size %d", chunkSize)
+	}
+}
+
+func TestAfterDrained(t *testing.T) {
+	called := make(chan struct{}, 1)
+	AfterDrained(errEdgeConnectionClosed, func() { called <- struct{}{} })
+	require.Len(t, called, 1)
+	<-called
+
+	done := make(chan struct{})
+	err := fmt.Errorf("serve: %w", &drainError{done: done})
+	require.ErrorIs(t, err, ErrEdgeDraining)
+	AfterDrained(err, func() { called <- struct{}{} })
+	select {
+	case <-called:
+		t.Fatal("called before the drained connection finished")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(done)
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Fatal("not called once the drained connection finished")
+	}
+}
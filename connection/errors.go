@@ -1,7 +1,12 @@
 package connection
 
 import (
+	"errors"
+
+	"github.com/quic-go/quic-go"
+
 	"github.com/cloudflare/cloudflared/edgediscovery"
+	"github.com/cloudflare/cloudflared/errcodes"
 	tunnelpogs "github.com/cloudflare/cloudflared/tunnelrpc/pogs"
 )
 
@@ -17,9 +22,15 @@ func (e DupConnRegisterTunnelError) Error() string {
 	return "already connected to this server, trying another address"
 }
 
+func (e DupConnRegisterTunnelError) ErrorCode() errcodes.Code {
+	return errcodes.DupRegistration
+}
+
 // Dial to edge server with quic failed
 type EdgeQuicDialError struct {
 	Cause error
+	// PostQuantum is set when the handshake required post-quantum key agreement.
+	PostQuantum bool
 }
 
 func (e *EdgeQuicDialError) Error() string {
@@ -30,6 +41,20 @@ func (e *EdgeQuicDialError) Unwrap() error {
 	return e.Cause
 }
 
+func (e *EdgeQuicDialError) ErrorCode() errcodes.Code {
+	if errcodes.IsTimeout(e.Cause) {
+		return errcodes.EdgeDialTimeout
+	}
+	var transportErr *quic.TransportError
+	if errors.As(e.Cause, &transportErr) && transportErr.ErrorCode.IsCryptoError() {
+		if e.PostQuantum {
+			return errcodes.PQHandshake
+		}
+		return errcodes.EdgeTLSHandshake
+	}
+	return errcodes.EdgeQUICDial
+}
+
 // RegisterTunnel error from server
 type ServerRegisterTunnelError struct {
 	Cause     error
@@ -40,6 +65,13 @@ func (e ServerRegisterTunnelError) Error() string {
 	return e.Cause.Error()
 }
 
+func (e ServerRegisterTunnelError) ErrorCode() errcodes.Code {
+	if e.Permanent {
+		return errcodes.RegistrationRejected
+	}
+	return errcodes.RegistrationRetryable
+}
+
 func serverRegistrationErrorFromRPC(err error) ServerRegisterTunnelError {
 	if retryable, ok := err.(*tunnelpogs.RetryableError); ok {
 		return ServerRegisterTunnelError{
@@ -1,6 +1,11 @@
 package connection
 
-import "net"
+import (
+	"fmt"
+	"net"
+
+	"github.com/cloudflare/cloudflared/errcodes"
+)
 
 // Event is something that happened to a connection, e.g. disconnection or registration.
 type Event struct {
@@ -10,6 +15,8 @@ type Event struct {
 	Protocol    Protocol
 	URL         string
 	EdgeAddress net.IP
+	// ErrorCode is the code of the error that disconnected the connection, if any.
+	ErrorCode errcodes.Code
 }
 
 // Status is the status of a connection.
@@ -28,4 +35,27 @@ const (
 	RegisteringTunnel
 	// We're unregistering tunnel from the edge in preparation for a disconnect
 	Unregistering
+	// ProtocolFallback means the connection switches to another protocol to reconnect, set in the event.
+	ProtocolFallback
 )
+
+func (s Status) String() string {
+	switch s {
+	case Disconnected:
+		return "disconnected"
+	case Connected:
+		return "connected"
+	case Reconnecting:
+		return "reconnecting"
+	case SetURL:
+		return "set_url"
+	case RegisteringTunnel:
+		return "registering"
+	case Unregistering:
+		return "unregistering"
+	case ProtocolFallback:
+		return "protocol_fallback"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
@@ -0,0 +1,72 @@
+package connection
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
+)
+
+// QUICMetadataFingerprintPrefix prefixes the connect request metadata keys carrying the client fingerprints
+// computed by the edge, e.g. "Fingerprint:ja3".
+const QUICMetadataFingerprintPrefix = "Fingerprint:"
+
+// HTTP2FingerprintHeaderPrefix prefixes the request headers carrying the client fingerprints computed by the edge over
+// HTTP/2 connections, e.g. "Cf-Cloudflared-Fingerprint-Ja3". The edge strips the internal headers sent by eyeballs, so
+// they can't be spoofed.
+const HTTP2FingerprintHeaderPrefix = "Cf-Cloudflared-Fingerprint-"
+
+// Fingerprints are the client TLS/HTTP fingerprints (JA3, JA4, HTTP/2...) computed by the edge for an eyeball
+// request, keyed by lower-cased fingerprint name.
+type Fingerprints map[string]string
+
+type fingerprintsContextKey struct{}
+
+// ContextWithFingerprints returns a copy of ctx carrying the provided fingerprints.
+func ContextWithFingerprints(ctx context.Context, fingerprints Fingerprints) context.Context {
+	return context.WithValue(ctx, fingerprintsContextKey{}, fingerprints)
+}
+
+// FingerprintsFromContext returns the fingerprints of the request, or nil if the edge didn't provide any.
+func FingerprintsFromContext(ctx context.Context) Fingerprints {
+	fingerprints, _ := ctx.Value(fingerprintsContextKey{}).(Fingerprints)
+	return fingerprints
+}
+
+// fingerprintsFromMetadata extracts the fingerprints sent by the edge as part of the connect request metadata.
+func fingerprintsFromMetadata(metadata []pogs.Metadata) Fingerprints {
+	var fingerprints Fingerprints
+	for _, m := range metadata {
+		name, ok := strings.CutPrefix(m.Key, QUICMetadataFingerprintPrefix)
+		if !ok || name == "" {
+			continue
+		}
+		if fingerprints == nil {
+			fingerprints = make(Fingerprints)
+		}
+		fingerprints[strings.ToLower(name)] = m.Val
+	}
+	return fingerprints
+}
+
+// fingerprintsFromHeaders extracts the fingerprints sent by the edge as request headers, removing the headers so that
+// they aren't forwarded to the origin as is.
+func fingerprintsFromHeaders(header http.Header) Fingerprints {
+	var fingerprints Fingerprints
+	for key, values := range header {
+		name, ok := strings.CutPrefix(key, HTTP2FingerprintHeaderPrefix)
+		if !ok {
+			continue
+		}
+		delete(header, key)
+		if name == "" || len(values) == 0 {
+			continue
+		}
+		if fingerprints == nil {
+			fingerprints = make(Fingerprints)
+		}
+		fingerprints[strings.ToLower(name)] = values[0]
+	}
+	return fingerprints
+}
@@ -0,0 +1,32 @@
+package connection
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
+)
+
+func TestFingerprintsFromMetadata(t *testing.T) {
+	fingerprints := fingerprintsFromMetadata([]pogs.Metadata{
+		{Key: HTTPMethodKey, Val: http.MethodGet},
+		{Key: QUICMetadataFingerprintPrefix + "JA3", Val: "771,4865-4866"},
+		{Key: QUICMetadataFingerprintPrefix, Val: "unnamed"},
+	})
+	assert.Equal(t, Fingerprints{"ja3": "771,4865-4866"}, fingerprints)
+	assert.Nil(t, fingerprintsFromMetadata([]pogs.Metadata{{Key: HTTPMethodKey, Val: http.MethodGet}}))
+}
+
+func TestFingerprintsFromHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("Accept", "*/*")
+	header.Set(HTTP2FingerprintHeaderPrefix+"Ja3", "771,4865-4866")
+	header.Set(HTTP2FingerprintHeaderPrefix+"Ja4", "t13d1516h2")
+
+	assert.Equal(t, Fingerprints{"ja3": "771,4865-4866", "ja4": "t13d1516h2"}, fingerprintsFromHeaders(header))
+	// The fingerprints aren't forwarded to the origin as headers
+	assert.Equal(t, http.Header{"Accept": {"*/*"}}, header)
+	assert.Nil(t, fingerprintsFromHeaders(header))
+}
@@ -11,6 +11,7 @@ import (
 	"runtime/debug"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
@@ -48,6 +49,8 @@ type HTTP2Connection struct {
 	controlStreamHandler ControlStreamHandler
 	stoppedGracefully    bool
 	controlStreamErr     error // result of running control stream handler
+	// drainC is closed when the edge sends a GOAWAY frame
+	drainC chan struct{}
 }
 
 // NewHTTP2Connection returns a new instance of HTTP2Connection.
@@ -60,8 +63,9 @@ func NewHTTP2Connection(
 	controlStreamHandler ControlStreamHandler,
 	log *zerolog.Logger,
 ) *HTTP2Connection {
+	drainC := make(chan struct{})
 	return &HTTP2Connection{
-		conn: conn,
+		conn: newGoAwayConn(conn, func() { close(drainC) }),
 		server: &http2.Server{
 			MaxConcurrentStreams: MaxConcurrentStreams,
 		},
@@ -71,19 +75,53 @@ func NewHTTP2Connection(
 		connIndex:            connIndex,
 		controlStreamHandler: controlStreamHandler,
 		log:                  log,
+		drainC:               drainC,
 	}
 }
 
 // Serve serves an HTTP2 server that the edge can talk to.
 func (c *HTTP2Connection) Serve(ctx context.Context) error {
+	// A draining connection keeps serving its in-flight requests after Serve returned, so that a replacement
+	// connection can be established in the meantime. Its requests are detached from ctx for that reason.
+	serveCtx, cancelServe := context.WithCancel(context.WithoutCancel(ctx))
+	servedC := make(chan struct{})
+	closedC := make(chan struct{})
 	go func() {
-		<-ctx.Done()
+		defer close(closedC)
+		select {
+		case <-ctx.Done():
+		case <-c.drainC:
+		}
+		// ctx is also canceled once Serve returned because of the drain, which must not cut the in-flight requests
+		select {
+		case <-c.drainC:
+			select {
+			case <-servedC:
+			case <-time.After(edgeDrainTimeout):
+			}
+		default:
+		}
+		cancelServe()
 		c.close()
 	}()
-	c.server.ServeConn(c.conn, &http2.ServeConnOpts{
-		Context: ctx,
-		Handler: c,
-	})
+	go func() {
+		defer close(servedC)
+		c.server.ServeConn(c.conn, &http2.ServeConnOpts{
+			Context: serveCtx,
+			Handler: c,
+		})
+	}()
+
+	select {
+	case <-servedC:
+	case <-c.drainC:
+	}
+	select {
+	case <-c.drainC:
+		logEdgeDrain(c.observer.log, c.connIndex, HTTP2)
+		return &drainError{done: closedC}
+	default:
+	}
 
 	switch {
 	case c.controlStreamHandler.IsStopped():
@@ -128,9 +166,15 @@ func (c *HTTP2Connection) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	case TypeWebsocket, TypeHTTP:
 		stripWebsocketUpgradeHeader(r)
+		if fingerprints := fingerprintsFromHeaders(r.Header); fingerprints != nil {
+			r = r.WithContext(ContextWithFingerprints(r.Context(), fingerprints))
+		}
 		// Check for tracing on request
 		tr := tracing.NewTracedHTTPRequest(r, c.connIndex, c.log)
-		if err := originProxy.ProxyHTTP(respWriter, tr, connType == TypeWebsocket); err != nil {
+		span := tr.StartRequestSpan(tracing.Http2TransportAttribute)
+		err := originProxy.ProxyHTTP(respWriter, tr, connType == TypeWebsocket)
+		endRequestSpan(span, err)
+		if err != nil {
 			requestErr = fmt.Errorf("Failed to proxy HTTP: %w", err)
 		}
 
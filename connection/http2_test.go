@@ -45,6 +45,7 @@ func newTestHTTP2Connection() (*HTTP2Connection, net.Conn) {
 		nil,
 		1*time.Second,
 		HTTP2,
+		HeartbeatConfig{},
 	)
 	return NewHTTP2Connection(
 		cfdConn,
@@ -375,6 +376,7 @@ func TestServeControlStream(t *testing.T) {
 		nil,
 		1*time.Second,
 		HTTP2,
+		HeartbeatConfig{},
 	)
 	http2Conn.controlStreamHandler = controlStream
 
@@ -429,6 +431,7 @@ func TestFailRegistration(t *testing.T) {
 		nil,
 		1*time.Second,
 		HTTP2,
+		HeartbeatConfig{},
 	)
 	http2Conn.controlStreamHandler = controlStream
 
@@ -479,6 +482,7 @@ func TestGracefulShutdownHTTP2(t *testing.T) {
 		shutdownC,
 		1*time.Second,
 		HTTP2,
+		HeartbeatConfig{},
 	)
 
 	http2Conn.controlStreamHandler = controlStream
@@ -29,8 +29,12 @@ type tunnelMetrics struct {
 	regFail    *prometheus.CounterVec
 	rpcFail    *prometheus.CounterVec
 
+	heartbeatRTT   *prometheus.GaugeVec
+	heartbeatsLost *prometheus.CounterVec
+
 	tunnelsHA           tunnelsForHA
 	userHostnamesCounts *prometheus.CounterVec
+	edgeDrains          *prometheus.CounterVec
 
 	localConfigMetrics *localConfigMetrics
 }
@@ -123,6 +127,17 @@ func initTunnelMetrics() *tunnelMetrics {
 	)
 	prometheus.MustRegister(userHostnamesCounts)
 
+	edgeDrains := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "edge_drains",
+			Help:      "Count of connections the edge asked to drain, by protocol",
+		},
+		[]string{"protocol"},
+	)
+	prometheus.MustRegister(edgeDrains)
+
 	registerSuccess := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: MetricsNamespace,
@@ -134,6 +149,28 @@ func initTunnelMetrics() *tunnelMetrics {
 	)
 	prometheus.MustRegister(registerSuccess)
 
+	heartbeatRTT := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "control_heartbeat_rtt_seconds",
+			Help:      "Round trip time of the last heartbeat answered by the edge on the control stream of each connection",
+		},
+		[]string{"connection_id"},
+	)
+	prometheus.MustRegister(heartbeatRTT)
+
+	heartbeatsLost := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "control_heartbeats_lost",
+			Help:      "Count of the heartbeats the edge didn't answer on the control stream of each connection",
+		},
+		[]string{"connection_id"},
+	)
+	prometheus.MustRegister(heartbeatsLost)
+
 	return &tunnelMetrics{
 		serverLocations:     serverLocations,
 		oldServerLocations:  make(map[string]string),
@@ -141,7 +178,10 @@ func initTunnelMetrics() *tunnelMetrics {
 		regSuccess:          registerSuccess,
 		regFail:             registerFail,
 		rpcFail:             rpcFail,
+		heartbeatRTT:        heartbeatRTT,
+		heartbeatsLost:      heartbeatsLost,
 		userHostnamesCounts: userHostnamesCounts,
+		edgeDrains:          edgeDrains,
 		localConfigMetrics:  newLocalConfigMetrics(),
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 
+	"github.com/cloudflare/cloudflared/errcodes"
 	"github.com/cloudflare/cloudflared/management"
 )
 
@@ -81,12 +82,17 @@ func (o *Observer) SendReconnect(connIndex uint8) {
 	o.sendEvent(Event{Index: connIndex, EventType: Reconnecting})
 }
 
+func (o *Observer) SendProtocolFallback(connIndex uint8, protocol Protocol) {
+	o.sendEvent(Event{Index: connIndex, EventType: ProtocolFallback, Protocol: protocol})
+}
+
 func (o *Observer) sendUnregisteringEvent(connIndex uint8) {
 	o.sendEvent(Event{Index: connIndex, EventType: Unregistering})
 }
 
-func (o *Observer) SendDisconnect(connIndex uint8) {
-	o.sendEvent(Event{Index: connIndex, EventType: Disconnected})
+// SendDisconnect reports that a connection was disconnected, with the code of the error that disconnected it.
+func (o *Observer) SendDisconnect(connIndex uint8, code errcodes.Code) {
+	o.sendEvent(Event{Index: connIndex, EventType: Disconnected, ErrorCode: code})
 }
 
 func (o *Observer) sendEvent(e Event) {
@@ -0,0 +1,63 @@
+package connection
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+
+	"github.com/cloudflare/cloudflared/sockmark"
+)
+
+const (
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	udpHeaderLen  = 8
+	// quic-go requires initial packets of at least 1200 bytes, and doesn't send packets larger than 1452 bytes
+	minQUICPacketSize = 1200
+	maxQUICPacketSize = 1452
+	// quicDatagramOverhead bounds what a QUIC short header packet with a single DATAGRAM frame adds to the frame's
+	// data: the flags, a connection ID of up to 20 bytes, a packet number of up to 4 bytes, the AEAD tag, and the frame
+	// type and length.
+	quicDatagramOverhead = 1 + 20 + 4 + 16 + 1 + 2
+	// datagramHeaderLen is the session ID and type that datagrams version 2 and 3 add to the UDP payload
+	datagramHeaderLen = 17
+	// maxDatagramPayloadLen is the largest UDP payload carried by datagrams, as allowed by the WARP client
+	maxDatagramPayloadLen = 1280
+)
+
+// ErrPathMTUUnsupported is returned when the MTU of the path to the edge can't be discovered on the platform.
+var ErrPathMTUUnsupported = errors.New("path MTU discovery isn't supported on this platform")
+
+// PathMTU is the MTU of the path to an edge address, and the sizes the QUIC connection uses on it.
+type PathMTU struct {
+	MTU int
+	// PacketSize is the initial size of the QUIC packets
+	PacketSize uint16
+	// MaxDatagramPayload is the largest UDP payload the datagrams carry
+	MaxDatagramPayload int
+}
+
+// NewPathMTU derives the sizes of the QUIC connection from the MTU of the path.
+func NewPathMTU(mtu int, isIPv4 bool) PathMTU {
+	headerLen := ipv6HeaderLen + udpHeaderLen
+	if isIPv4 {
+		headerLen = ipv4HeaderLen + udpHeaderLen
+	}
+	packetSize := min(max(mtu-headerLen, minQUICPacketSize), maxQUICPacketSize)
+	return PathMTU{
+		MTU:                mtu,
+		PacketSize:         uint16(packetSize), // nolint: gosec
+		MaxDatagramPayload: min(packetSize-quicDatagramOverhead-datagramHeaderLen, maxDatagramPayloadLen),
+	}
+}
+
+// ProbePathMTU discovers the MTU of the path to an edge address as known by the kernel, which accounts for the MTU
+// of the interface the edge is routed through, such as the WARP client's, and the ICMP "packet too big" messages
+// received for the edge address.
+func ProbePathMTU(edgeAddr netip.AddrPort, bindAddr net.IP, routing sockmark.Options) (PathMTU, error) {
+	mtu, err := pathMTU(edgeAddr, bindAddr, routing)
+	if err != nil {
+		return PathMTU{}, err
+	}
+	return NewPathMTU(mtu, edgeAddr.Addr().Unmap().Is4()), nil
+}
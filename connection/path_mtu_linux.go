@@ -0,0 +1,45 @@
+//go:build linux
+
+package connection
+
+import (
+	"net"
+	"net/netip"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/cloudflare/cloudflared/sockmark"
+)
+
+// pathMTU reads the MTU of the route to edgeAddr from a connected UDP socket. Connecting the socket sends no packet.
+func pathMTU(edgeAddr netip.AddrPort, bindAddr net.IP, routing sockmark.Options) (int, error) {
+	network, level, option := "udp4", unix.IPPROTO_IP, unix.IP_MTU
+	if !edgeAddr.Addr().Unmap().Is4() {
+		network, level, option = "udp6", unix.IPPROTO_IPV6, unix.IPV6_MTU
+	}
+	var dialer net.Dialer
+	if !routing.IsZero() {
+		// The route, and so its MTU, depends on the firewall mark and the interface of the socket
+		dialer.Control = sockmark.Control(routing)
+	}
+	if bindAddr != nil {
+		dialer.LocalAddr = &net.UDPAddr{IP: bindAddr}
+	}
+	conn, err := dialer.Dial(network, net.UDPAddrFromAddrPort(edgeAddr).String())
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	rawConn, err := conn.(*net.UDPConn).SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var mtu int
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		mtu, sockErr = unix.GetsockoptInt(int(fd), level, option)
+	}); err != nil {
+		return 0, err
+	}
+	return mtu, sockErr
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package connection
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/cloudflare/cloudflared/sockmark"
+)
+
+func pathMTU(_ netip.AddrPort, _ net.IP, _ sockmark.Options) (int, error) {
+	return 0, ErrPathMTUUnsupported
+}
@@ -0,0 +1,48 @@
+package connection
+
+import (
+	"net/netip"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/sockmark"
+)
+
+func TestNewPathMTU(t *testing.T) {
+	tests := []struct {
+		name    string
+		mtu     int
+		isIPv4  bool
+		want    uint16
+		payload int
+	}{
+		{name: "ethernet ipv4", mtu: 1500, isIPv4: true, want: 1452, payload: 1280},
+		{name: "ethernet ipv6", mtu: 1500, want: 1452, payload: 1280},
+		{name: "warp ipv4", mtu: 1280, isIPv4: true, want: 1252, payload: 1191},
+		{name: "warp ipv6", mtu: 1280, want: 1232, payload: 1171},
+		{name: "below the QUIC minimum", mtu: 1000, isIPv4: true, want: 1200, payload: 1139},
+		{name: "loopback", mtu: 65536, isIPv4: true, want: 1452, payload: 1280},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pathMTU := NewPathMTU(test.mtu, test.isIPv4)
+			assert.Equal(t, test.mtu, pathMTU.MTU)
+			assert.Equal(t, test.want, pathMTU.PacketSize)
+			assert.Equal(t, test.payload, pathMTU.MaxDatagramPayload)
+		})
+	}
+}
+
+func TestProbePathMTU(t *testing.T) {
+	pathMTU, err := ProbePathMTU(netip.MustParseAddrPort("127.0.0.1:7844"), nil, sockmark.Options{})
+	if runtime.GOOS != "linux" {
+		require.ErrorIs(t, err, ErrPathMTUUnsupported)
+		return
+	}
+	require.NoError(t, err)
+	assert.Positive(t, pathMTU.MTU)
+	assert.GreaterOrEqual(t, pathMTU.PacketSize, uint16(minQUICPacketSize))
+}
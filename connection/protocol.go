@@ -60,6 +60,16 @@ func (p Protocol) String() string {
 	}
 }
 
+// ParseProtocol parses the name of a protocol of ProtocolList.
+func ParseProtocol(name string) (Protocol, error) {
+	for _, p := range ProtocolList {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown protocol %s, expected quic or http2", name)
+}
+
 func (p Protocol) TLSSettings() *TLSSettings {
 	switch p {
 	case HTTP2:
@@ -84,6 +94,9 @@ type TLSSettings struct {
 type ProtocolSelector interface {
 	Current() Protocol
 	Fallback() (Protocol, bool)
+	// RecordReconnect records a reconnect of the connection and reports whether it reconnects so often that it
+	// should use the fallback protocol, even though none of its errors triggered the fallback.
+	RecordReconnect(connIndex uint8) bool
 }
 
 // staticProtocolSelector will not provide a different protocol for Fallback
@@ -99,6 +112,10 @@ func (s *staticProtocolSelector) Fallback() (Protocol, bool) {
 	return s.current, false
 }
 
+func (s *staticProtocolSelector) RecordReconnect(uint8) bool {
+	return false
+}
+
 // remoteProtocolSelector will fetch a list of remote protocols to provide for edge discovery
 type remoteProtocolSelector struct {
 	lock sync.RWMutex
@@ -112,6 +129,7 @@ type remoteProtocolSelector struct {
 	fetchFunc       edgediscovery.PercentageFetcher
 	refreshAfter    time.Time
 	ttl             time.Duration
+	reconnectRate   *reconnectRate
 	log             *zerolog.Logger
 }
 
@@ -130,6 +148,7 @@ func newRemoteProtocolSelector(
 		fetchFunc:       fetchFunc,
 		refreshAfter:    time.Now().Add(ttl),
 		ttl:             ttl,
+		reconnectRate:   newReconnectRate(),
 		log:             log,
 	}
 }
@@ -158,6 +177,10 @@ func (s *remoteProtocolSelector) Fallback() (Protocol, bool) {
 	return s.current.fallback()
 }
 
+func (s *remoteProtocolSelector) RecordReconnect(connIndex uint8) bool {
+	return s.reconnectRate.record(connIndex)
+}
+
 func getProtocol(protocolPool []Protocol, fetchFunc edgediscovery.PercentageFetcher, switchThreshold int32) (Protocol, error) {
 	protocolPercentages, err := fetchFunc()
 	if err != nil {
@@ -176,15 +199,17 @@ func getProtocol(protocolPool []Protocol, fetchFunc edgediscovery.PercentageFetc
 
 // defaultProtocolSelector will allow for a protocol to have a fallback
 type defaultProtocolSelector struct {
-	lock    sync.RWMutex
-	current Protocol
+	lock          sync.RWMutex
+	current       Protocol
+	reconnectRate *reconnectRate
 }
 
 func newDefaultProtocolSelector(
 	current Protocol,
 ) *defaultProtocolSelector {
 	return &defaultProtocolSelector{
-		current: current,
+		current:       current,
+		reconnectRate: newReconnectRate(),
 	}
 }
 
@@ -200,6 +225,10 @@ func (s *defaultProtocolSelector) Fallback() (Protocol, bool) {
 	return s.current.fallback()
 }
 
+func (s *defaultProtocolSelector) RecordReconnect(connIndex uint8) bool {
+	return s.reconnectRate.record(connIndex)
+}
+
 func NewProtocolSelector(
 	protocolFlag string,
 	accountTag string,
@@ -3,6 +3,7 @@ package connection
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -171,3 +172,22 @@ func TestAutoProtocolSelectorNoRefreshWithToken(t *testing.T) {
 	fetcher.protocolPercents = edgediscovery.ProtocolPercents{edgediscovery.ProtocolPercent{Protocol: "http2", Percentage: 100}}
 	assert.Equal(t, QUIC, selector.Current())
 }
+
+func TestReconnectRateWindow(t *testing.T) {
+	rate := newReconnectRate()
+	now := time.Now()
+	rate.nowFunc = func() time.Time { return now }
+
+	for i := 0; i < flappingReconnects-1; i++ {
+		assert.False(t, rate.record(0))
+	}
+	// Reconnects older than the window are forgotten
+	now = now.Add(flappingWindow)
+	assert.False(t, rate.record(0))
+	for i := 0; i < flappingReconnects-2; i++ {
+		assert.False(t, rate.record(0))
+	}
+	assert.True(t, rate.record(0))
+	// The history starts over once the connection was reported
+	assert.False(t, rate.record(0))
+}
@@ -11,6 +11,8 @@ import (
 
 	"github.com/quic-go/quic-go"
 	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/sockmark"
 )
 
 var (
@@ -25,14 +27,22 @@ func DialQuic(
 	edgeAddr netip.AddrPort,
 	localAddr net.IP,
 	connIndex uint8,
+	socketOptions UDPSocketOptions,
 	logger *zerolog.Logger,
 ) (quic.Connection, error) {
 	udpConn, err := createUDPConnForConnIndex(connIndex, localAddr, edgeAddr, logger)
 	if err != nil {
 		return nil, err
 	}
+	if !socketOptions.Routing.IsZero() {
+		// Unlike DSCP marking, the connection mustn't go through another route than the configured one
+		if err := sockmark.Set(udpConn, socketOptions.Routing); err != nil {
+			udpConn.Close()
+			return nil, err
+		}
+	}
 
-	conn, err := quic.Dial(ctx, udpConn, net.UDPAddrFromAddrPort(edgeAddr), tlsConfig, quicConfig)
+	conn, err := quic.Dial(ctx, prepareUDPConn(udpConn, socketOptions, logger), net.UDPAddrFromAddrPort(edgeAddr), tlsConfig, quicConfig)
 	if err != nil {
 		// close the udp server socket in case of error connecting to the edge
 		udpConn.Close()
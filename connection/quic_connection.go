@@ -21,6 +21,7 @@ import (
 	cfdflow "github.com/cloudflare/cloudflared/flow"
 
 	cfdquic "github.com/cloudflare/cloudflared/quic"
+	"github.com/cloudflare/cloudflared/recovery"
 	"github.com/cloudflare/cloudflared/tracing"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
 	rpcquic "github.com/cloudflare/cloudflared/tunnelrpc/quic"
@@ -37,6 +38,14 @@ const (
 	QUICMetadataFlowID = "FlowID"
 )
 
+// activeDataStreams counts the QUIC streams proxying a request or a TCP stream, on all the connections.
+var activeDataStreams atomic.Int64
+
+// ActiveDataStreams returns the number of QUIC streams proxying a request or a TCP stream, on all the connections.
+func ActiveDataStreams() int64 {
+	return activeDataStreams.Load()
+}
+
 // quicConnection represents the type that facilitates Proxying via QUIC streams.
 type quicConnection struct {
 	conn                 quic.Connection
@@ -99,19 +108,14 @@ func (q *quicConnection) Serve(ctx context.Context) error {
 	// In the future, if cloudflared can autonomously push traffic to the edge, we have to make sure the control
 	// stream is already fully registered before the other goroutines can proceed.
 	errGroup.Go(func() error {
-		// err is equal to nil if we exit due to unregistration. If that happens we want to wait the full
-		// amount of the grace period, allowing requests to finish before we cancel the context, which will
+		// err is equal to nil if we exit due to unregistration. If that happens we want to wait for the in-flight
+		// requests and sessions to finish, up to the grace period, before we cancel the context, which will
 		// make cloudflared exit.
 		if err := q.serveControlStream(ctx, controlStream); err == nil {
 			if q.gracePeriod > 0 {
-				// In Go1.23 this can be removed and replaced with time.Ticker
-				// see https://pkg.go.dev/time#Tick
-				ticker := time.NewTicker(q.gracePeriod)
-				defer ticker.Stop()
-				select {
-				case <-ctx.Done():
-				case <-ticker.C:
-				}
+				drainCtx, cancelDrain := context.WithTimeout(ctx, q.gracePeriod)
+				_ = cfdflow.Active.Wait(drainCtx)
+				cancelDrain()
 			}
 		}
 		cancel()
@@ -155,6 +159,7 @@ func (q *quicConnection) acceptStream(ctx context.Context) error {
 }
 
 func (q *quicConnection) runStream(quicStream quic.Stream) {
+	defer recovery.Recover(q.logger, recovery.SubsystemProxy, int(q.connIndex))
 	ctx := quicStream.Context()
 	stream := cfdquic.NewSafeStreamCloser(quicStream, q.streamWriteTimeout, q.logger)
 	defer stream.Close()
@@ -175,6 +180,8 @@ func (q *quicConnection) runStream(quicStream quic.Stream) {
 }
 
 func (q *quicConnection) handleDataStream(ctx context.Context, stream *rpcquic.RequestServerStream) error {
+	activeDataStreams.Add(1)
+	defer activeDataStreams.Add(-1)
 	request, err := stream.ReadConnectRequestData()
 	if err != nil {
 		return err
@@ -218,7 +225,10 @@ func (q *quicConnection) dispatchRequest(ctx context.Context, stream *rpcquic.Re
 			return err, false
 		}
 		w := newHTTPResponseAdapter(stream)
-		return originProxy.ProxyHTTP(&w, tracedReq, request.Type == pogs.ConnectionTypeWebsocket), w.connectResponseSent
+		span := tracedReq.StartRequestSpan(tracing.QuicTransportAttribute)
+		err = originProxy.ProxyHTTP(&w, tracedReq, request.Type == pogs.ConnectionTypeWebsocket)
+		endRequestSpan(span, err)
+		return err, w.connectResponseSent
 
 	case pogs.ConnectionTypeTCP:
 		rwa := &streamReadWriteAcker{RequestServerStream: stream}
@@ -338,6 +348,10 @@ func buildHTTPRequest(
 	host := metadata[HTTPHostKey]
 	isWebsocket := connectRequest.Type == pogs.ConnectionTypeWebsocket
 
+	if fingerprints := fingerprintsFromMetadata(connectRequest.Metadata); fingerprints != nil {
+		ctx = ContextWithFingerprints(ctx, fingerprints)
+	}
+
 	req, err := http.NewRequestWithContext(ctx, method, dest, body)
 	if err != nil {
 		return nil, err
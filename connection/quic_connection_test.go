@@ -699,7 +699,7 @@ func serveSession(ctx context.Context, datagramConn *datagramV2Connection, edgeQ
 
 	sessionDone := make(chan struct{})
 	go func() {
-		datagramConn.serveUDPSession(session, time.Millisecond*50)
+		datagramConn.serveUDPSession(session, time.Millisecond*50, cfdConn.LocalAddr().String(), originConn.LocalAddr().String())
 		close(sessionDone)
 	}()
 
@@ -814,6 +814,7 @@ func testTunnelConnection(t *testing.T, serverAddr netip.AddrPort, index uint8)
 		serverAddr,
 		nil, // connect on a random port
 		index,
+		UDPSocketOptions{},
 		&log,
 	)
 	require.NoError(t, err)
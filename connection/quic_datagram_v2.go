@@ -23,6 +23,7 @@ import (
 	"github.com/cloudflare/cloudflared/management"
 	"github.com/cloudflare/cloudflared/packet"
 	cfdquic "github.com/cloudflare/cloudflared/quic"
+	"github.com/cloudflare/cloudflared/recovery"
 	"github.com/cloudflare/cloudflared/tracing"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
 	tunnelpogs "github.com/cloudflare/cloudflared/tunnelrpc/pogs"
@@ -76,10 +77,12 @@ func NewDatagramV2Connection(ctx context.Context,
 	rpcTimeout time.Duration,
 	streamWriteTimeout time.Duration,
 	flowLimiter cfdflow.Limiter,
+	maxDatagramPayload int,
 	logger *zerolog.Logger,
 ) DatagramSessionHandler {
 	sessionDemuxChan := make(chan *packet.Session, demuxChanCapacity)
 	datagramMuxer := cfdquic.NewDatagramMuxerV2(conn, logger, sessionDemuxChan)
+	datagramMuxer.SetMaxPayloadSize(maxDatagramPayload)
 	sessionManager := datagramsession.NewManager(logger, datagramMuxer.SendToSession, sessionDemuxChan)
 	packetRouter := ingress.NewPacketRouter(icmpRouter, datagramMuxer, index, logger)
 
@@ -166,6 +169,11 @@ func (q *datagramV2Connection) RegisterUdpSession(ctx context.Context, sessionID
 		q.flowLimiter.Release()
 		return nil, err
 	}
+	if timeouts, ok := q.originDialer.(ingress.OriginUDPIdleTimeouts); ok {
+		if timeout := timeouts.UDPIdleTimeout(dstAddrPort); timeout > 0 {
+			closeAfterIdleHint = timeout
+		}
+	}
 	registerSpan.SetAttributes(
 		attribute.Bool("socket-bind-success", true),
 		attribute.String("src", originProxy.LocalAddr().String()),
@@ -180,9 +188,12 @@ func (q *datagramV2Connection) RegisterUdpSession(ctx context.Context, sessionID
 		return nil, err
 	}
 
+	cfdflow.Active.Begin(cfdflow.KindUDP)
 	go func() {
+		defer recovery.Recover(q.logger, recovery.SubsystemDatagram, int(q.index))
+		defer cfdflow.Active.End(cfdflow.KindUDP)
 		defer q.flowLimiter.Release() // we do the release here, instead of inside the `serveUDPSession` just to keep all acquire/release calls in the same method.
-		q.serveUDPSession(session, closeAfterIdleHint)
+		q.serveUDPSession(session, closeAfterIdleHint, originProxy.LocalAddr().String(), dstAddrPort.String())
 	}()
 
 	log.Debug().
@@ -204,9 +215,23 @@ func (q *datagramV2Connection) UnregisterUdpSession(ctx context.Context, session
 	return q.sessionManager.UnregisterSession(ctx, sessionID, message, true)
 }
 
-func (q *datagramV2Connection) serveUDPSession(session *datagramsession.Session, closeAfterIdleHint time.Duration) {
+func (q *datagramV2Connection) serveUDPSession(session *datagramsession.Session, closeAfterIdleHint time.Duration, src, dst string) {
 	ctx := q.conn.Context()
+	start := time.Now()
 	closedByRemote, err := session.Serve(ctx, closeAfterIdleHint)
+	record := cfdflow.Record{
+		Kind:        cfdflow.KindUDP,
+		ID:          session.ID.String(),
+		Version:     "v2",
+		Src:         src,
+		Dst:         dst,
+		Start:       start,
+		Duration:    time.Since(start),
+		CloseReason: datagramsession.CloseReason(closedByRemote, err),
+		ConnIndex:   q.index,
+	}
+	session.Counters().Fill(&record)
+	cfdflow.Emit(record)
 	// If session is terminated by remote, then we know it has been unregistered from session manager and edge
 	if !closedByRemote {
 		if err != nil {
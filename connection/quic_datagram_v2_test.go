@@ -89,6 +89,7 @@ func TestRateLimitOnNewDatagramV2UDPSession(t *testing.T) {
 		0*time.Second,
 		0*time.Second,
 		flowLimiterMock,
+		0,
 		&log,
 	)
 
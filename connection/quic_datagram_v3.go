@@ -36,6 +36,8 @@ func NewDatagramV3Connection(ctx context.Context,
 	icmpRouter ingress.ICMPRouter,
 	index uint8,
 	metrics cfdquic.Metrics,
+	maxDatagramPayload int,
+	demuxConfig cfdquic.DemuxConfig,
 	logger *zerolog.Logger,
 ) DatagramSessionHandler {
 	log := logger.
@@ -43,7 +45,7 @@ func NewDatagramV3Connection(ctx context.Context,
 		Int(management.EventTypeKey, int(management.UDP)).
 		Uint8(LogFieldConnIndex, index).
 		Logger()
-	datagramMuxer := cfdquic.NewDatagramConn(conn, sessionManager, icmpRouter, index, metrics, &log)
+	datagramMuxer := cfdquic.NewDatagramConnWithConfig(conn, sessionManager, icmpRouter, index, metrics, maxDatagramPayload, demuxConfig, &log)
 
 	return &datagramV3Connection{
 		conn,
@@ -0,0 +1,115 @@
+package connection
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/dscp"
+	"github.com/cloudflare/cloudflared/sockmark"
+)
+
+// UDPSocketOptions configures the socket of a QUIC connection to the edge.
+type UDPSocketOptions struct {
+	// DisableOffload stops quic-go from sending with GSO and reading packets in batches
+	DisableOffload bool
+	// MarkDSCP marks the packets of the connection with the DSCP code point
+	MarkDSCP bool
+	DSCP     uint8
+	// Routing sets the firewall mark and the interface of the socket for policy routing
+	Routing sockmark.Options
+}
+
+// udpOffload describes the UDP offloads quic-go uses for the socket of a QUIC connection.
+type udpOffload struct {
+	// gso sends the packets of a flight to the edge with a single syscall, segmented by the kernel or the NIC.
+	// quic-go falls back to a packet per syscall if the interface rejects it.
+	gso bool
+	// batching reads several packets with a single recvmmsg syscall.
+	batching bool
+}
+
+var (
+	quicUDPOffload = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "quic_udp_offload",
+			Help:      "Whether the QUIC connections to the edge use a UDP offload, by offload",
+		},
+		[]string{"offload"},
+	)
+	logUDPOffloadOnce sync.Once
+)
+
+func init() {
+	prometheus.MustRegister(quicUDPOffload)
+}
+
+// prepareUDPConn returns the socket handed to quic-go. quic-go only uses GSO, batched reads and ECN with sockets
+// it can send control messages with, so hiding them behind a plain net.PacketConn disables the offloads.
+func prepareUDPConn(udpConn *net.UDPConn, options UDPSocketOptions, logger *zerolog.Logger) net.PacketConn {
+	var (
+		conn    net.PacketConn = udpConn
+		offload udpOffload
+	)
+	if options.MarkDSCP {
+		if err := dscp.Set(udpConn, options.DSCP); err != nil {
+			logger.Warn().Err(err).Uint8("dscp", options.DSCP).Msg("Unable to set the DSCP code point of the QUIC connection")
+		}
+	}
+	switch {
+	case options.DisableOffload:
+		conn = &plainUDPConn{udpConn}
+	case options.MarkDSCP:
+		// The ECN control messages of quic-go would reset the code point of the socket
+		conn = &dscpUDPConn{UDPConn: udpConn, dscp: options.DSCP}
+		offload = detectUDPOffload(udpConn)
+	default:
+		offload = detectUDPOffload(udpConn)
+	}
+	quicUDPOffload.WithLabelValues("gso").Set(boolToFloat(offload.gso))
+	quicUDPOffload.WithLabelValues("batching").Set(boolToFloat(offload.batching))
+	logUDPOffloadOnce.Do(func() {
+		logger.Info().Bool("gso", offload.gso).Bool("batching", offload.batching).Msg("UDP offloads of the QUIC connections")
+	})
+	return conn
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// dscpUDPConn marks the TOS and traffic class control messages quic-go sends packets with.
+type dscpUDPConn struct {
+	*net.UDPConn
+	dscp uint8
+}
+
+func (c *dscpUDPConn) WriteMsgUDP(b, oob []byte, addr *net.UDPAddr) (n, oobn int, err error) {
+	dscp.MarkControlMessages(oob, c.dscp)
+	return c.UDPConn.WriteMsgUDP(b, oob, addr)
+}
+
+// plainUDPConn only exposes the methods of net.PacketConn, and the ones quic-go sizes the socket buffers with.
+type plainUDPConn struct {
+	udpConn *net.UDPConn
+}
+
+func (c *plainUDPConn) ReadFrom(p []byte) (int, net.Addr, error) { return c.udpConn.ReadFrom(p) }
+func (c *plainUDPConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return c.udpConn.WriteTo(p, addr)
+}
+func (c *plainUDPConn) Close() error                       { return c.udpConn.Close() }
+func (c *plainUDPConn) LocalAddr() net.Addr                { return c.udpConn.LocalAddr() }
+func (c *plainUDPConn) SetDeadline(t time.Time) error      { return c.udpConn.SetDeadline(t) }
+func (c *plainUDPConn) SetReadDeadline(t time.Time) error  { return c.udpConn.SetReadDeadline(t) }
+func (c *plainUDPConn) SetWriteDeadline(t time.Time) error { return c.udpConn.SetWriteDeadline(t) }
+func (c *plainUDPConn) SetReadBuffer(bytes int) error      { return c.udpConn.SetReadBuffer(bytes) }
+func (c *plainUDPConn) SetWriteBuffer(bytes int) error     { return c.udpConn.SetWriteBuffer(bytes) }
@@ -0,0 +1,53 @@
+//go:build linux
+
+package connection
+
+import (
+	"net"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// detectUDPOffload mirrors the checks of quic-go: GSO needs a 5.x kernel with UDP_SEGMENT, and can be disabled with
+// QUIC_GO_DISABLE_GSO. Reads are always batched on Linux.
+func detectUDPOffload(udpConn *net.UDPConn) udpOffload {
+	return udpOffload{
+		gso:      isGSOSupported(udpConn),
+		batching: true,
+	}
+}
+
+func isGSOSupported(udpConn *net.UDPConn) bool {
+	if disabled, err := strconv.ParseBool(os.Getenv("QUIC_GO_DISABLE_GSO")); err == nil && disabled {
+		return false
+	}
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil || kernelMajorVersion(unix.ByteSliceToString(uname.Release[:])) < 5 {
+		return false
+	}
+	rawConn, err := udpConn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		_, sockErr = unix.GetsockoptInt(int(fd), unix.IPPROTO_UDP, unix.UDP_SEGMENT)
+	}); err != nil {
+		return false
+	}
+	return sockErr == nil
+}
+
+// kernelMajorVersion parses the major version of a kernel release such as 6.1.0-13-amd64.
+func kernelMajorVersion(release string) int {
+	major := 0
+	for _, c := range release {
+		if c < '0' || c > '9' {
+			break
+		}
+		major = major*10 + int(c-'0')
+	}
+	return major
+}
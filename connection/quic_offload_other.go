@@ -0,0 +1,10 @@
+//go:build !linux
+
+package connection
+
+import "net"
+
+// detectUDPOffload reports no offload, since quic-go only uses GSO and batched reads on Linux.
+func detectUDPOffload(_ *net.UDPConn) udpOffload {
+	return udpOffload{}
+}
@@ -0,0 +1,39 @@
+package connection
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrepareUDPConnDisablesOffload(t *testing.T) {
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer udpConn.Close()
+	log := zerolog.Nop()
+
+	require.Same(t, udpConn, prepareUDPConn(udpConn, UDPSocketOptions{}, &log))
+
+	conn := prepareUDPConn(udpConn, UDPSocketOptions{DisableOffload: true}, &log)
+	// quic-go only uses the offloads with connections that can read and write control messages
+	_, oob := conn.(interface {
+		ReadMsgUDP(b, oob []byte) (n, oobn, flags int, addr *net.UDPAddr, err error)
+	})
+	require.False(t, oob)
+	_, raw := conn.(interface {
+		SyscallConn() (syscall.RawConn, error)
+	})
+	require.False(t, raw)
+	_, buffer := conn.(interface{ SetReadBuffer(int) error })
+	require.True(t, buffer)
+
+	_, err = conn.WriteTo([]byte("ping"), udpConn.LocalAddr())
+	require.NoError(t, err)
+	buf := make([]byte, 16)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf[:n]))
+}
@@ -0,0 +1,50 @@
+package connection
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// A connection that reconnects flappingReconnects times within flappingWindow is considered to be flapping.
+	flappingReconnects = 5
+	flappingWindow     = 5 * time.Minute
+)
+
+// reconnectRate tracks the recent reconnects of each connection. A connection that keeps connecting then dropping
+// never exhausts its backoff, so the rate of its reconnects is the only sign that its protocol doesn't work well.
+type reconnectRate struct {
+	lock       sync.Mutex
+	reconnects map[uint8][]time.Time
+
+	// nowFunc is overridden in tests
+	nowFunc func() time.Time
+}
+
+func newReconnectRate() *reconnectRate {
+	return &reconnectRate{
+		reconnects: make(map[uint8][]time.Time),
+		nowFunc:    time.Now,
+	}
+}
+
+// record adds a reconnect of the connection and reports whether the connection is flapping. The history of the
+// connection is cleared once it is reported, so that the next protocol gets a fresh start.
+func (r *reconnectRate) record(connIndex uint8) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := r.nowFunc()
+	recent := r.reconnects[connIndex][:0]
+	for _, t := range r.reconnects[connIndex] {
+		if now.Sub(t) < flappingWindow {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) >= flappingReconnects {
+		delete(r.reconnects, connIndex)
+		return true
+	}
+	r.reconnects[connIndex] = recent
+	return false
+}
@@ -0,0 +1,256 @@
+package connection
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/tracing"
+)
+
+const (
+	// StubColo is the data center reported in the Cf-Ray header of requests served by a StubHandler.
+	StubColo = "DEV"
+	// StubCountry is the default country reported in the Cf-Ipcountry header of requests served by a StubHandler.
+	StubCountry = "XX"
+)
+
+// StubHandler serves the ingress rules of an Orchestrator on a local listener, without any connection to the edge.
+// Requests get the headers the edge would add, so that applications can be tested behind the same proxy
+// semantics as in production.
+type StubHandler struct {
+	orchestrator Orchestrator
+	country      string
+	log          *zerolog.Logger
+}
+
+// NewStubHandler returns a StubHandler reporting visitors as connecting from country.
+func NewStubHandler(orchestrator Orchestrator, country string, log *zerolog.Logger) *StubHandler {
+	if country == "" {
+		country = StubCountry
+	}
+	return &StubHandler{
+		orchestrator: orchestrator,
+		country:      country,
+		log:          log,
+	}
+}
+
+func (s *StubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	originProxy, err := s.orchestrator.GetOriginProxy()
+	if err != nil {
+		s.log.Error().Err(err).Msg("Stub failed to get the origin proxy")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	isWebsocket := isHTTP1WebsocketUpgrade(r)
+	connType := TypeHTTP
+	if isWebsocket {
+		connType = TypeWebsocket
+	}
+	handleMissingRequestParts(connType, r)
+	s.addEdgeHeaders(r, isWebsocket)
+
+	var respWriter stubResponseWriter
+	if isWebsocket {
+		// The upgraded connection is streamed both ways by the proxy, which needs to own it from the start
+		wsWriter, err := newStubWebsocketWriter(w, r)
+		if err != nil {
+			s.log.Error().Err(err).Msg("Stub failed to take over the websocket connection")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer wsWriter.close()
+		respWriter = wsWriter
+	} else {
+		respWriter = &stubHTTPWriter{ResponseWriter: w, log: s.log}
+	}
+
+	tr := tracing.NewTracedHTTPRequest(r, 0, s.log)
+	if err := originProxy.ProxyHTTP(respWriter, tr, isWebsocket); err != nil {
+		s.log.Error().Err(err).Str("url", r.URL.String()).Msg("Stub failed to proxy the request")
+		if !respWriter.statusWritten() {
+			// This is the status the edge responds with when cloudflared can't reach the origin
+			_ = respWriter.WriteRespHeaders(http.StatusBadGateway, http.Header{"Content-Length": []string{"0"}})
+		}
+	}
+}
+
+// addEdgeHeaders sets the headers the edge adds to the requests of a visitor.
+func (s *StubHandler) addEdgeHeaders(r *http.Request, isWebsocket bool) {
+	visitorIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		visitorIP = host
+	}
+	scheme := "https"
+	if isWebsocket {
+		scheme = "wss"
+	}
+
+	r.Header.Set("Cf-Ray", newStubRayID())
+	r.Header.Set("Cf-Connecting-Ip", visitorIP)
+	r.Header.Set("Cf-Ipcountry", s.country)
+	r.Header.Set("Cf-Visitor", fmt.Sprintf(`{"scheme":"%s"}`, scheme))
+	r.Header.Set("Cdn-Loop", "cloudflare")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	if prior := r.Header.Get("X-Forwarded-For"); prior != "" {
+		r.Header.Set("X-Forwarded-For", prior+", "+visitorIP)
+	} else {
+		r.Header.Set("X-Forwarded-For", visitorIP)
+	}
+}
+
+func newStubRayID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id) + "-" + StubColo
+}
+
+func isHTTP1WebsocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+type stubResponseWriter interface {
+	ResponseWriter
+	statusWritten() bool
+}
+
+// stubHTTPWriter writes the origin response to the visitor, flushing every write like the edge streams responses.
+type stubHTTPWriter struct {
+	http.ResponseWriter
+	log     *zerolog.Logger
+	written bool
+}
+
+func (w *stubHTTPWriter) WriteRespHeaders(status int, header http.Header) error {
+	dest := w.Header()
+	for name, values := range header {
+		dest[name] = values
+	}
+	w.WriteHeader(status)
+	return nil
+}
+
+func (w *stubHTTPWriter) WriteHeader(status int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *stubHTTPWriter) AddTrailer(trailerName, trailerValue string) {
+	if !w.written {
+		w.log.Warn().Msg("Tried to add Trailer to response before status written. Ignoring...")
+		return
+	}
+	w.Header().Add(http.TrailerPrefix+trailerName, trailerValue)
+}
+
+func (w *stubHTTPWriter) Write(p []byte) (int, error) {
+	w.written = true
+	n, err := w.ResponseWriter.Write(p)
+	if err == nil {
+		w.Flush()
+	}
+	return n, err
+}
+
+func (w *stubHTTPWriter) Flush() {
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *stubHTTPWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *stubHTTPWriter) statusWritten() bool {
+	return w.written
+}
+
+// stubWebsocketWriter writes the origin response of a websocket upgrade directly on the visitor connection, which
+// is then used as a raw stream in both directions.
+type stubWebsocketWriter struct {
+	conn    net.Conn
+	rw      *bufio.ReadWriter
+	header  http.Header
+	lock    sync.Mutex
+	written bool
+}
+
+func newStubWebsocketWriter(w http.ResponseWriter, r *http.Request) (*stubWebsocketWriter, error) {
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to hijack the visitor connection")
+	}
+	r.Body = io.NopCloser(rw.Reader)
+	return &stubWebsocketWriter{
+		conn:   conn,
+		rw:     rw,
+		header: make(http.Header),
+	}, nil
+}
+
+func (w *stubWebsocketWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *stubWebsocketWriter) WriteRespHeaders(status int, header http.Header) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.written {
+		return nil
+	}
+	w.written = true
+	if _, err := fmt.Fprintf(w.rw, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status)); err != nil {
+		return err
+	}
+	if err := header.Write(w.rw); err != nil {
+		return err
+	}
+	if _, err := w.rw.WriteString("\r\n"); err != nil {
+		return err
+	}
+	return w.rw.Flush()
+}
+
+func (w *stubWebsocketWriter) WriteHeader(status int) {
+	_ = w.WriteRespHeaders(status, w.header)
+}
+
+// AddTrailer is a no-op, an upgraded connection has no trailers.
+func (w *stubWebsocketWriter) AddTrailer(string, string) {}
+
+func (w *stubWebsocketWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	n, err := w.rw.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, w.rw.Flush()
+}
+
+// Flush is a no-op, every write is flushed.
+func (w *stubWebsocketWriter) Flush() {}
+
+func (w *stubWebsocketWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, w.rw, nil
+}
+
+func (w *stubWebsocketWriter) statusWritten() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.written
+}
+
+func (w *stubWebsocketWriter) close() {
+	_ = w.rw.Flush()
+	_ = w.conn.Close()
+}
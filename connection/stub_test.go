@@ -0,0 +1,97 @@
+package connection
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/tracing"
+)
+
+// stubEchoProxy responds with the edge headers it received, and echoes the stream of websocket requests.
+type stubEchoProxy struct{}
+
+func (stubEchoProxy) ProxyHTTP(w ResponseWriter, tr *tracing.TracedHTTPRequest, isWebsocket bool) error {
+	req := tr.Request
+	if req.URL.Path == "/error" {
+		return fmt.Errorf("origin is down")
+	}
+	header := http.Header{}
+	for _, name := range []string{"Cf-Ray", "Cf-Connecting-Ip", "Cf-Ipcountry", "Cf-Visitor", "X-Forwarded-For", "X-Forwarded-Proto"} {
+		header.Set("Echo-"+name, req.Header.Get(name))
+	}
+	if !isWebsocket {
+		return w.WriteRespHeaders(http.StatusOK, header)
+	}
+	header.Set("Connection", "Upgrade")
+	header.Set("Upgrade", "websocket")
+	if err := w.WriteRespHeaders(http.StatusSwitchingProtocols, header); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, req.Body)
+	return err
+}
+
+func (stubEchoProxy) ProxyTCP(context.Context, ReadWriteAcker, *TCPRequest) error {
+	return fmt.Errorf("not implemented")
+}
+
+func TestStubHandler(t *testing.T) {
+	server := httptest.NewServer(NewStubHandler(&mockOrchestrator{originProxy: stubEchoProxy{}}, "FR", &log))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/ok", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasSuffix(resp.Header.Get("Echo-Cf-Ray"), "-"+StubColo))
+	assert.Equal(t, "127.0.0.1", resp.Header.Get("Echo-Cf-Connecting-Ip"))
+	assert.Equal(t, "FR", resp.Header.Get("Echo-Cf-Ipcountry"))
+	assert.Equal(t, `{"scheme":"https"}`, resp.Header.Get("Echo-Cf-Visitor"))
+	assert.Equal(t, "198.51.100.1, 127.0.0.1", resp.Header.Get("Echo-X-Forwarded-For"))
+	assert.Equal(t, "https", resp.Header.Get("Echo-X-Forwarded-Proto"))
+
+	// Errors reaching the origin are reported like the edge does
+	errResp, err := server.Client().Get(server.URL + "/error")
+	require.NoError(t, err)
+	defer errResp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, errResp.StatusCode)
+}
+
+func TestStubHandlerWebsocket(t *testing.T) {
+	server := httptest.NewServer(NewStubHandler(&mockOrchestrator{originProxy: stubEchoProxy{}}, "", &log))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = fmt.Fprint(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, StubCountry, resp.Header.Get("Echo-Cf-Ipcountry"))
+	assert.Equal(t, `{"scheme":"wss"}`, resp.Header.Get("Echo-Cf-Visitor"))
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	echo := make([]byte, 4)
+	_, err = io.ReadFull(reader, echo)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(echo))
+}
@@ -0,0 +1,90 @@
+package control
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/errcodes"
+)
+
+// Subscribers are sent events without blocking the observer, so slow subscribers miss the events that don't fit in
+// their buffer.
+const eventsBufferSize = 64
+
+// Event is a connection event streamed by the control API.
+type Event struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	ConnIndex   uint8     `json:"connIndex"`
+	Protocol    string    `json:"protocol,omitempty"`
+	Location    string    `json:"location,omitempty"`
+	EdgeAddress net.IP    `json:"edgeAddress,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	// ErrorCode is the code of the error that disconnected the connection, see the errcodes package.
+	ErrorCode errcodes.Code `json:"errorCode,omitempty"`
+}
+
+var eventTypes = map[connection.Status]string{
+	connection.Disconnected:      "disconnected",
+	connection.Connected:         "connected",
+	connection.Reconnecting:      "reconnecting",
+	connection.SetURL:            "url",
+	connection.RegisteringTunnel: "registering",
+	connection.Unregistering:     "unregistering",
+	connection.ProtocolFallback:  "protocol_fallback",
+}
+
+func newEvent(e connection.Event) Event {
+	event := Event{
+		Time:        time.Now(),
+		Type:        eventTypes[e.EventType],
+		ConnIndex:   e.Index,
+		Location:    e.Location,
+		EdgeAddress: e.EdgeAddress,
+		URL:         e.URL,
+		ErrorCode:   e.ErrorCode,
+	}
+	if e.EventType == connection.Connected || e.EventType == connection.ProtocolFallback {
+		event.Protocol = e.Protocol.String()
+	}
+	return event
+}
+
+// eventBroadcaster is registered to the connection observer, and sends its events to the subscribers of the control
+// API.
+type eventBroadcaster struct {
+	lock        sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func newEventBroadcaster() *eventBroadcaster {
+	return &eventBroadcaster{subscribers: make(map[chan Event]struct{})}
+}
+
+func (b *eventBroadcaster) OnTunnelEvent(e connection.Event) {
+	event := newEvent(e)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+func (b *eventBroadcaster) subscribe() chan Event {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	events := make(chan Event, eventsBufferSize)
+	b.subscribers[events] = struct{}{}
+	return events
+}
+
+func (b *eventBroadcaster) unsubscribe(events chan Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.subscribers, events)
+}
@@ -0,0 +1,484 @@
+// Package control serves a local API to observe and control a running connector, over a Unix socket.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/logger"
+	"github.com/cloudflare/cloudflared/metrics"
+	"github.com/cloudflare/cloudflared/orchestration"
+	"github.com/cloudflare/cloudflared/supervisor"
+	"github.com/cloudflare/cloudflared/tunnelstate"
+)
+
+const (
+	shutdownTimeout = 5 * time.Second
+	// maxConfigSize bounds the configurations submitted for a dry run
+	maxConfigSize = 4 << 20
+)
+
+// Config holds what the control API observes and controls.
+type Config struct {
+	// SocketPath is the path of the Unix socket the API is served on. It's only accessible to the user running
+	// cloudflared.
+	SocketPath  string
+	Version     string
+	ConnectorID uuid.UUID
+	TunnelID    uuid.UUID
+	Tracker     *tunnelstate.ConnTracker
+	Observer    *connection.Observer
+	// ConfigVersion returns the version of the configuration applied from the edge, or -1 if none was applied.
+	ConfigVersion func() int32
+	// ReconnectCh is the channel of the reconnect signals read by the connections.
+	ReconnectCh chan<- supervisor.ReconnectSignal
+	// Drain starts the graceful shutdown of the connector.
+	Drain func()
+	// Maintenance, when set, serves the maintenance toggles of the ingress rules.
+	Maintenance http.Handler
+	// ProtocolOverrides, when set, lets the protocol of connections be forced for debugging.
+	ProtocolOverrides *supervisor.ProtocolOverrides
+	// FeatureSnapshots, when set, serves the features the connections were established with.
+	FeatureSnapshots *supervisor.FeatureSnapshots
+	// Precheck, when set, tests the reachability of the edge.
+	Precheck func(ctx context.Context) (*supervisor.PrecheckReport, error)
+	// Tunnels, when set, lets named tunnels be hosted next to the main tunnel at runtime.
+	Tunnels TunnelHost
+	// Debug, when set, lets the pprof and expvar endpoints of the metrics server be enabled and disabled.
+	Debug *metrics.DebugEndpoints
+	// DryRun, when set, reports what applying a configuration would change without applying it.
+	DryRun func(configJSON []byte) *orchestration.DryRunReport
+	// ConfigHistory, when set, returns the last configurations applied, newest first.
+	ConfigHistory func() []orchestration.AppliedConfig
+	// Rollback, when set, applies again the configuration of a version in the history.
+	Rollback func(version int32) error
+}
+
+// Server serves the control API:
+//
+//	GET    /status                          status of the connector
+//	GET    /connections                     connections to the edge
+//	POST   /connections/reconnect           reconnects all the connections, after the delay query parameter if any
+//	POST   /connections/{index}/reconnect   reconnects a connection, after the delay query parameter if any, logging
+//	                                        the reason query parameter if any
+//	POST   /drain                           unregisters the connections and shuts down
+//	GET    /log_level                       log levels and sampling rates
+//	PUT    /log_level                       sets log levels and sampling rates, e.g. {"level": "debug"}
+//	GET    /events                          streams the connection events, as JSON lines
+//	GET    /maintenance                     maintenance toggles of the ingress rules
+//	PUT    /maintenance                     toggles the maintenance, e.g. {"enabled": true} or {"rule": 2, "enabled": true}
+//	GET    /connections/protocols           protocols forced on connections, by connection index
+//	PUT    /connections/{index}/protocol    forces the protocol of a connection and reconnects it, e.g. {"protocol": "http2"}
+//	DELETE /connections/{index}/protocol    removes the protocol forced on a connection and reconnects it
+//	GET    /connections/features            features of the connections, e.g. datagram version, by connection index
+//	POST   /diagnose                        tests the reachability of the edge over QUIC and TLS
+//	GET    /tunnels                         tunnels hosted next to the main tunnel
+//	POST   /tunnels                         hosts a tunnel, e.g. {"name": "blog", "credentialsFile": "...", "configFile": "..."}
+//	DELETE /tunnels/{name}                  gracefully shuts down and removes a hosted tunnel
+//	GET    /debug_endpoints                 whether pprof and expvar are served by the metrics server
+//	PUT    /debug_endpoints                 enables or disables pprof and expvar, e.g. {"enabled": true}
+//	POST   /config/dry_run                  validates a configuration as pushed by the edge, and reports the ingress
+//	                                        rules it would add, remove and modify without applying it
+//	GET    /config/history                  last configurations applied, newest first
+//	POST   /config/rollback                 applies again a configuration of the history, e.g. {"version": 12}
+type Server struct {
+	config    Config
+	events    *eventBroadcaster
+	drainOnce sync.Once
+	draining  chan struct{}
+	log       *zerolog.Logger
+}
+
+func NewServer(config Config, log *zerolog.Logger) *Server {
+	server := &Server{
+		config:   config,
+		events:   newEventBroadcaster(),
+		draining: make(chan struct{}),
+		log:      log,
+	}
+	if config.Observer != nil {
+		config.Observer.RegisterSink(server.events)
+	}
+	return server
+}
+
+func (s *Server) handler() http.Handler {
+	router := http.NewServeMux()
+	router.HandleFunc("GET /status", s.getStatus)
+	router.HandleFunc("GET /connections", s.getConnections)
+	router.HandleFunc("POST /connections/reconnect", s.reconnectAll)
+	router.HandleFunc("POST /connections/{index}/reconnect", s.reconnect)
+	router.HandleFunc("POST /drain", s.drain)
+	router.Handle("GET /log_level", logger.SettingsHandler(s.log))
+	router.Handle("PUT /log_level", logger.SettingsHandler(s.log))
+	router.HandleFunc("GET /events", s.streamEvents)
+	if s.config.Maintenance != nil {
+		router.Handle("GET /maintenance", s.config.Maintenance)
+		router.Handle("PUT /maintenance", s.config.Maintenance)
+	}
+	if s.config.ProtocolOverrides != nil {
+		router.HandleFunc("GET /connections/protocols", s.getProtocolOverrides)
+		router.HandleFunc("PUT /connections/{index}/protocol", s.setProtocolOverride)
+		router.HandleFunc("DELETE /connections/{index}/protocol", s.clearProtocolOverride)
+	}
+	if s.config.FeatureSnapshots != nil {
+		router.HandleFunc("GET /connections/features", s.getFeatureSnapshots)
+	}
+	if s.config.Precheck != nil {
+		router.HandleFunc("POST /diagnose", s.diagnose)
+	}
+	if s.config.Debug != nil {
+		router.HandleFunc("GET /debug_endpoints", s.getDebugEndpoints)
+		router.HandleFunc("PUT /debug_endpoints", s.setDebugEndpoints)
+	}
+	if s.config.DryRun != nil {
+		router.HandleFunc("POST /config/dry_run", s.dryRunConfig)
+	}
+	if s.config.ConfigHistory != nil {
+		router.HandleFunc("GET /config/history", s.getConfigHistory)
+	}
+	if s.config.Rollback != nil {
+		router.HandleFunc("POST /config/rollback", s.rollbackConfig)
+	}
+	if s.config.Tunnels != nil {
+		router.HandleFunc("GET /tunnels", s.getTunnels)
+		router.HandleFunc("POST /tunnels", s.addTunnel)
+		router.HandleFunc("DELETE /tunnels/{name}", s.removeTunnel)
+	}
+	return router
+}
+
+// Serve serves the API until ctx is done.
+func (s *Server) Serve(ctx context.Context) error {
+	listener, err := listen(s.config.SocketPath)
+	if err != nil {
+		return err
+	}
+	// The listener only removes the socket it created, which was moved
+	defer os.Remove(s.config.SocketPath)
+	server := &http.Server{
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	s.log.Info().Str("socket", s.config.SocketPath).Msg("Serving the control API")
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// listen listens on a Unix socket at path. The socket is created in a private directory and restricted to the
+// current user before it's moved to path, so that other users can't connect to it in the meantime. It replaces a
+// socket left by a previous run, but no other kind of file.
+func listen(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket == 0 {
+		return nil, fmt.Errorf("%s is not a socket, refusing to replace it with the control socket", path)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("unable to check the previous control socket: %w", err)
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".control-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the control socket: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	privatePath := filepath.Join(dir, "sock")
+	listener, err := net.Listen("unix", privatePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on the control socket: %w", err)
+	}
+	if err := os.Chmod(privatePath, 0600); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("unable to restrict the access to the control socket: %w", err)
+	}
+	if err := os.Rename(privatePath, path); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("unable to move the control socket in place: %w", err)
+	}
+	return listener, nil
+}
+
+type status struct {
+	Version           string    `json:"version"`
+	ConnectorID       uuid.UUID `json:"connectorId"`
+	TunnelID          uuid.UUID `json:"tunnelId"`
+	ActiveConnections uint      `json:"activeConnections"`
+	ConfigVersion     int32     `json:"configVersion"`
+	LogLevel          string    `json:"logLevel,omitempty"`
+	Draining          bool      `json:"draining"`
+}
+
+func (s *Server) getStatus(w http.ResponseWriter, _ *http.Request) {
+	st := status{
+		Version:           s.config.Version,
+		ConnectorID:       s.config.ConnectorID,
+		TunnelID:          s.config.TunnelID,
+		ActiveConnections: s.config.Tracker.CountActiveConns(),
+		ConfigVersion:     s.config.ConfigVersion(),
+	}
+	if level, err := logger.GetLevel(logger.MainLogger); err == nil {
+		st.LogLevel = level.String()
+	}
+	select {
+	case <-s.draining:
+		st.Draining = true
+	default:
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (s *Server) getConnections(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.config.Tracker.GetActiveConnections())
+}
+
+// connIndex parses the connection index of the path.
+func connIndex(r *http.Request) (uint8, error) {
+	index, err := strconv.ParseUint(r.PathValue("index"), 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid connection index: %w", err)
+	}
+	return uint8(index), nil
+}
+
+// reconnectSignal parses the delay and reason query parameters of a reconnect request.
+func reconnectSignal(r *http.Request) (supervisor.ReconnectSignal, error) {
+	signal := supervisor.ReconnectSignal{Reason: r.URL.Query().Get("reason")}
+	if signal.Reason == "" {
+		signal.Reason = "requested through the control API"
+	}
+	if d := r.URL.Query().Get("delay"); d != "" {
+		delay, err := time.ParseDuration(d)
+		if err != nil {
+			return signal, fmt.Errorf("invalid delay: %w", err)
+		}
+		signal.Delay = delay
+	}
+	return signal, nil
+}
+
+func (s *Server) reconnect(w http.ResponseWriter, r *http.Request) {
+	target, err := connIndex(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	signal, err := reconnectSignal(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	signal.Target = &target
+	if supervisor.SendReconnect(r.Context(), s.config.ReconnectCh, signal) != nil {
+		return
+	}
+	s.log.Info().Uint8(connection.LogFieldConnIndex, target).Msg("Reconnecting connection as requested through the control API")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) reconnectAll(w http.ResponseWriter, r *http.Request) {
+	signal, err := reconnectSignal(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	signal.All = true
+	if supervisor.SendReconnect(r.Context(), s.config.ReconnectCh, signal) != nil {
+		return
+	}
+	s.log.Info().Msg("Reconnecting all the connections as requested through the control API")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) signalReconnect(ctx context.Context, target uint8, reason string) bool {
+	return supervisor.SendReconnect(ctx, s.config.ReconnectCh, supervisor.ReconnectSignal{Target: &target, Reason: reason}) == nil
+}
+
+type protocolOverride struct {
+	Protocol string `json:"protocol"`
+}
+
+func (s *Server) getProtocolOverrides(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.config.ProtocolOverrides.All())
+}
+
+func (s *Server) getFeatureSnapshots(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.config.FeatureSnapshots.All())
+}
+
+func (s *Server) setProtocolOverride(w http.ResponseWriter, r *http.Request) {
+	target, err := connIndex(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var override protocolOverride
+	if err := json.NewDecoder(r.Body).Decode(&override); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid protocol override: %w", err))
+		return
+	}
+	protocol, err := connection.ParseProtocol(override.Protocol)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.config.ProtocolOverrides.Set(target, protocol)
+	s.log.Info().Uint8(connection.LogFieldConnIndex, target).Msgf("Forcing protocol %s as requested through the control API", protocol)
+	if !s.signalReconnect(r.Context(), target, fmt.Sprintf("protocol %s forced", protocol)) {
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) clearProtocolOverride(w http.ResponseWriter, r *http.Request) {
+	target, err := connIndex(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !s.config.ProtocolOverrides.Clear(target) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("connection %d has no protocol override", target))
+		return
+	}
+	s.log.Info().Uint8(connection.LogFieldConnIndex, target).Msg("Removing the protocol override as requested through the control API")
+	if !s.signalReconnect(r.Context(), target, "protocol override removed") {
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) drain(w http.ResponseWriter, _ *http.Request) {
+	s.drainOnce.Do(func() {
+		s.log.Info().Msg("Initiating graceful shutdown as requested through the control API")
+		close(s.draining)
+		s.config.Drain()
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type debugEndpoints struct {
+	Enabled bool `json:"enabled"`
+}
+
+func (s *Server) getDebugEndpoints(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, debugEndpoints{Enabled: s.config.Debug.Enabled()})
+}
+
+func (s *Server) setDebugEndpoints(w http.ResponseWriter, r *http.Request) {
+	var settings debugEndpoints
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid debug endpoints settings: %w", err))
+		return
+	}
+	s.config.Debug.SetEnabled(settings.Enabled)
+	s.log.Info().Bool("enabled", settings.Enabled).Msg("Toggled the debug endpoints of the metrics server as requested through the control API")
+	writeJSON(w, http.StatusOK, settings)
+}
+
+func (s *Server) diagnose(w http.ResponseWriter, r *http.Request) {
+	report, err := s.config.Precheck(r.Context())
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	report.Log(s.log)
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (s *Server) dryRunConfig(w http.ResponseWriter, r *http.Request) {
+	configJSON, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unable to read the configuration: %w", err))
+		return
+	}
+	report := s.config.DryRun(configJSON)
+	s.log.Info().Bool("wouldApply", report.WouldApply).
+		Int("added", len(report.Added)).
+		Int("removed", len(report.Removed)).
+		Int("modified", len(report.Modified)).
+		Msg("Dry run of a configuration requested through the control API")
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (s *Server) getConfigHistory(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.config.ConfigHistory())
+}
+
+func (s *Server) rollbackConfig(w http.ResponseWriter, r *http.Request) {
+	var rollback struct {
+		Version *int32 `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&rollback); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid rollback: %w", err))
+		return
+	}
+	if rollback.Version == nil {
+		writeError(w, http.StatusBadRequest, errors.New("the version to roll back to is missing"))
+		return
+	}
+	if err := s.config.Rollback(*rollback.Version); err != nil {
+		status := http.StatusUnprocessableEntity
+		if errors.Is(err, orchestration.ErrConfigVersionNotFound) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+	s.log.Info().Int32("version", *rollback.Version).Msg("Configuration rolled back as requested through the control API")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	events := s.events.subscribe()
+	defer s.events.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case event := <-events:
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
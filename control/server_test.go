@@ -0,0 +1,409 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/metrics"
+	"github.com/cloudflare/cloudflared/orchestration"
+	"github.com/cloudflare/cloudflared/supervisor"
+	"github.com/cloudflare/cloudflared/tunnelstate"
+)
+
+func newTestServer(t *testing.T) (*Server, chan supervisor.ReconnectSignal, chan struct{}) {
+	log := zerolog.Nop()
+	tracker := tunnelstate.NewConnTracker(&log)
+	tracker.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected, Protocol: connection.QUIC, Location: "lis01"})
+	reconnectCh := make(chan supervisor.ReconnectSignal, 1)
+	drainC := make(chan struct{})
+	server := NewServer(Config{
+		SocketPath:    filepath.Join(t.TempDir(), "control.sock"),
+		Version:       "test",
+		ConnectorID:   uuid.New(),
+		TunnelID:      uuid.New(),
+		Tracker:       tracker,
+		ConfigVersion: func() int32 { return 3 },
+		ReconnectCh:   reconnectCh,
+		Drain:         func() { close(drainC) },
+
+		ProtocolOverrides: supervisor.NewProtocolOverrides(),
+		FeatureSnapshots:  supervisor.NewFeatureSnapshots(),
+		Precheck: func(context.Context) (*supervisor.PrecheckReport, error) {
+			return &supervisor.PrecheckReport{Results: []supervisor.PrecheckResult{
+				{Region: 1, Address: "198.41.192.1:7844", Protocol: "quic", Reachable: true},
+			}}, nil
+		},
+		Tunnels: &fakeTunnelHost{},
+		Debug:   metrics.NewDebugEndpoints("", false, false),
+		DryRun: func(configJSON []byte) *orchestration.DryRunReport {
+			return &orchestration.DryRunReport{
+				WouldApply: true,
+				Added:      []orchestration.RuleChange{{Hostname: string(configJSON), Service: "http://localhost:8080"}},
+			}
+		},
+		ConfigHistory: func() []orchestration.AppliedConfig {
+			return []orchestration.AppliedConfig{{Version: 3}, {Version: 2}}
+		},
+		Rollback: func(version int32) error {
+			if version != 2 {
+				return orchestration.ErrConfigVersionNotFound
+			}
+			return nil
+		},
+	}, &log)
+	return server, reconnectCh, drainC
+}
+
+func TestStatus(t *testing.T) {
+	server, _, drainC := newTestServer(t)
+	handler := server.handler()
+
+	var st status
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&st))
+	assert.Equal(t, "test", st.Version)
+	assert.Equal(t, server.config.TunnelID, st.TunnelID)
+	assert.Equal(t, uint(1), st.ActiveConnections)
+	assert.Equal(t, int32(3), st.ConfigVersion)
+	assert.False(t, st.Draining)
+
+	// Draining twice only drains once
+	for range 2 {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/drain", nil))
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	}
+	<-drainC
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&st))
+	assert.True(t, st.Draining)
+}
+
+func TestConnections(t *testing.T) {
+	server, _, _ := newTestServer(t)
+
+	var conns []tunnelstate.IndexedConnectionInfo
+	w := httptest.NewRecorder()
+	server.handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/connections", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&conns))
+	require.Len(t, conns, 1)
+	assert.Equal(t, uint8(0), conns[0].Index)
+}
+
+func TestReconnect(t *testing.T) {
+	server, reconnectCh, _ := newTestServer(t)
+	handler := server.handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/connections/2/reconnect?delay=1s", nil))
+	require.Equal(t, http.StatusAccepted, w.Code)
+	signal := <-reconnectCh
+	require.NotNil(t, signal.Target)
+	assert.Equal(t, uint8(2), *signal.Target)
+	assert.Equal(t, time.Second, signal.Delay)
+	assert.Equal(t, "requested through the control API", signal.Reason)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/connections/reconnect?reason=maintenance", nil))
+	require.Equal(t, http.StatusAccepted, w.Code)
+	signal = <-reconnectCh
+	assert.True(t, signal.All)
+	assert.Nil(t, signal.Target)
+	assert.Equal(t, "maintenance", signal.Reason)
+
+	for _, path := range []string{"/connections/256/reconnect", "/connections/x/reconnect", "/connections/1/reconnect?delay=x", "/connections/reconnect?delay=x"} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+	}
+}
+
+func TestProtocolOverrides(t *testing.T) {
+	server, reconnectCh, _ := newTestServer(t)
+	handler := server.handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/connections/3/protocol", strings.NewReader(`{"protocol": "http2"}`)))
+	require.Equal(t, http.StatusAccepted, w.Code)
+	signal := <-reconnectCh
+	require.NotNil(t, signal.Target)
+	assert.Equal(t, uint8(3), *signal.Target)
+	protocol, ok := server.config.ProtocolOverrides.Get(3)
+	require.True(t, ok)
+	assert.Equal(t, connection.HTTP2, protocol)
+
+	var overrides map[uint8]string
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/connections/protocols", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&overrides))
+	assert.Equal(t, map[uint8]string{3: "http2"}, overrides)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/connections/3/protocol", nil))
+	require.Equal(t, http.StatusAccepted, w.Code)
+	<-reconnectCh
+	_, ok = server.config.ProtocolOverrides.Get(3)
+	assert.False(t, ok)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/connections/3/protocol", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	for _, body := range []string{`{"protocol": "h2mux"}`, `{}`, `http2`} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/connections/1/protocol", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+func TestFeatureSnapshots(t *testing.T) {
+	server, _, _ := newTestServer(t)
+
+	var snapshots map[uint8]supervisor.ConnectionFeatures
+	w := httptest.NewRecorder()
+	server.handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/connections/features", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&snapshots))
+	assert.Empty(t, snapshots)
+}
+
+func TestDiagnose(t *testing.T) {
+	server, _, _ := newTestServer(t)
+
+	var report supervisor.PrecheckReport
+	w := httptest.NewRecorder()
+	server.handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/diagnose", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	assert.Equal(t, 1, report.Reachable(connection.QUIC))
+	assert.Equal(t, 0, report.Reachable(connection.HTTP2))
+}
+
+func TestDryRunConfig(t *testing.T) {
+	server, _, _ := newTestServer(t)
+
+	var report orchestration.DryRunReport
+	w := httptest.NewRecorder()
+	server.handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/config/dry_run", strings.NewReader("example.com")))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+	assert.True(t, report.WouldApply)
+	require.Len(t, report.Added, 1)
+	assert.Equal(t, "example.com", report.Added[0].Hostname)
+}
+
+func TestConfigRollback(t *testing.T) {
+	server, _, _ := newTestServer(t)
+
+	var history []orchestration.AppliedConfig
+	w := httptest.NewRecorder()
+	server.handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/config/history", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+	require.Len(t, history, 2)
+	assert.Equal(t, int32(3), history[0].Version)
+
+	for body, status := range map[string]int{
+		`{"version": 2}`: http.StatusNoContent,
+		`{"version": 1}`: http.StatusNotFound,
+		`{}`:             http.StatusBadRequest,
+		`version`:        http.StatusBadRequest,
+	} {
+		w = httptest.NewRecorder()
+		server.handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/config/rollback", strings.NewReader(body)))
+		assert.Equal(t, status, w.Code, body)
+	}
+}
+
+type fakeTunnelHost struct {
+	tunnels []supervisor.HostedTunnelStatus
+}
+
+func (h *fakeTunnelHost) Add(spec TunnelSpec) error {
+	if spec.CredentialsFile == "" {
+		return errors.New("a hosted tunnel needs a credentials file")
+	}
+	for _, tunnel := range h.tunnels {
+		if tunnel.Name == spec.Name {
+			return supervisor.ErrHostedTunnelExists
+		}
+	}
+	h.tunnels = append(h.tunnels, supervisor.HostedTunnelStatus{Name: spec.Name, State: supervisor.HostedTunnelConnecting})
+	return nil
+}
+
+func (h *fakeTunnelHost) Remove(name string) error {
+	for i, tunnel := range h.tunnels {
+		if tunnel.Name == name {
+			h.tunnels = append(h.tunnels[:i], h.tunnels[i+1:]...)
+			return nil
+		}
+	}
+	return supervisor.ErrHostedTunnelNotFound
+}
+
+func (h *fakeTunnelHost) Tunnels() []supervisor.HostedTunnelStatus {
+	return h.tunnels
+}
+
+func TestHostedTunnels(t *testing.T) {
+	server, _, _ := newTestServer(t)
+	handler := server.handler()
+
+	addTunnel := func(body string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/tunnels", strings.NewReader(body)))
+		return w.Code
+	}
+	spec := `{"name": "blog", "credentialsFile": "/etc/cloudflared/blog.json", "configFile": "/etc/cloudflared/blog.yml"}`
+	assert.Equal(t, http.StatusCreated, addTunnel(spec))
+	assert.Equal(t, http.StatusConflict, addTunnel(spec))
+	assert.Equal(t, http.StatusBadRequest, addTunnel(`{"name": "wiki"}`))
+	assert.Equal(t, http.StatusBadRequest, addTunnel(`blog`))
+
+	var tunnels []supervisor.HostedTunnelStatus
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tunnels", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&tunnels))
+	require.Len(t, tunnels, 1)
+	assert.Equal(t, "blog", tunnels[0].Name)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/tunnels/blog", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/tunnels/blog", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestDebugEndpoints(t *testing.T) {
+	server, _, _ := newTestServer(t)
+	handler := server.handler()
+
+	var settings debugEndpoints
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug_endpoints", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&settings))
+	assert.False(t, settings.Enabled)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/debug_endpoints", strings.NewReader(`{"enabled": true}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, server.config.Debug.Enabled())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/debug_endpoints", strings.NewReader(`enabled`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSetInvalidLogLevel(t *testing.T) {
+	server, _, _ := newTestServer(t)
+
+	for _, body := range []string{`{"level": "loud"}`, `{}`, `level`} {
+		w := httptest.NewRecorder()
+		server.handler().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/log_level", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+func TestServeEvents(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the permissions of the control socket are not enforced on Windows")
+	}
+	server, _, _ := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error)
+	go func() {
+		serveErr <- server.Serve(ctx)
+	}()
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", server.config.SocketPath)
+		},
+	}}
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		var err error
+		resp, err = client.Get("http://control/events")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	info, err := os.Stat(server.config.SocketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// The subscriber is registered before the response headers are sent
+	server.events.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.ProtocolFallback, Protocol: connection.HTTP2})
+	server.events.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Connected, Protocol: connection.HTTP2, Location: "lis01"})
+
+	scanner := bufio.NewScanner(resp.Body)
+	var events []Event
+	for len(events) < 2 && scanner.Scan() {
+		var event Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.Len(t, events, 2)
+	assert.Equal(t, "protocol_fallback", events[0].Type)
+	assert.Equal(t, "http2", events[0].Protocol)
+	assert.Equal(t, "connected", events[1].Type)
+	assert.Equal(t, "lis01", events[1].Location)
+
+	cancel()
+	require.NoError(t, <-serveErr)
+}
+
+func TestListenReplacesOnlySockets(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the permissions of the control socket are not enforced on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "control.sock")
+	listener, err := listen(path)
+	require.NoError(t, err)
+	require.NoError(t, listener.Close())
+
+	// The socket left by a previous run is replaced
+	listener, err = listen(path)
+	require.NoError(t, err)
+	require.NoError(t, listener.Close())
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the private directory is removed")
+
+	// A regular file, e.g. from a typo in the configuration, is left alone
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, os.WriteFile(path, []byte("config"), 0600))
+	_, err = listen(path)
+	require.Error(t, err)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "config", string(content))
+}
@@ -0,0 +1,63 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/cloudflare/cloudflared/supervisor"
+)
+
+// TunnelSpec describes a named tunnel to host next to the main tunnel of the process.
+type TunnelSpec struct {
+	// Name identifies the tunnel in the API, the logs and the metrics
+	Name string `json:"name"`
+	// CredentialsFile is the path of the credentials file of the tunnel, created by `cloudflared tunnel create`
+	CredentialsFile string `json:"credentialsFile"`
+	// ConfigFile is the path of a configuration file holding the ingress rules of the tunnel
+	ConfigFile string `json:"configFile"`
+}
+
+// TunnelHost adds and removes the named tunnels hosted by the process.
+type TunnelHost interface {
+	Add(spec TunnelSpec) error
+	Remove(name string) error
+	Tunnels() []supervisor.HostedTunnelStatus
+}
+
+func (s *Server) getTunnels(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.config.Tunnels.Tunnels())
+}
+
+func (s *Server) addTunnel(w http.ResponseWriter, r *http.Request) {
+	var spec TunnelSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid tunnel: %w", err))
+		return
+	}
+	if err := s.config.Tunnels.Add(spec); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, supervisor.ErrHostedTunnelExists) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, err)
+		return
+	}
+	s.log.Info().Str(supervisor.LogFieldHostedTunnel, spec.Name).Msg("Hosting tunnel as requested through the control API")
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) removeTunnel(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := s.config.Tunnels.Remove(name); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, supervisor.ErrHostedTunnelNotFound) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+	s.log.Info().Str(supervisor.LogFieldHostedTunnel, name).Msg("Removing hosted tunnel as requested through the control API")
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,68 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/pkg/errors"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+// awsSecretsManagerCredentials reads the credentials from the string of an AWS Secrets Manager secret. The AWS
+// credentials, region and endpoint are resolved like the AWS CLI does: from the environment, the shared configuration
+// files, the web identity token of EKS service accounts, or the roles of the ECS task and the EC2 instance.
+type awsSecretsManagerCredentials struct {
+	secretID string
+
+	lock   sync.Mutex
+	client *secretsmanager.Client
+}
+
+func newAWSSecretsManagerCredentials(secretID string) (*awsSecretsManagerCredentials, error) {
+	return &awsSecretsManagerCredentials{secretID: secretID}, nil
+}
+
+func (p *awsSecretsManagerCredentials) Name() string {
+	return "AWS Secrets Manager secret " + p.secretID
+}
+
+func (p *awsSecretsManagerCredentials) Load(ctx context.Context) (connection.Credentials, error) {
+	ctx, cancel := context.WithTimeout(ctx, secretStoreTimeout)
+	defer cancel()
+	client, err := p.secretsManager(ctx)
+	if err != nil {
+		return connection.Credentials{}, err
+	}
+	secret, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(p.secretID)})
+	if err != nil {
+		return connection.Credentials{}, errors.Wrap(err, "unable to read the AWS Secrets Manager secret")
+	}
+	if aws.ToString(secret.SecretString) == "" {
+		return connection.Credentials{}, fmt.Errorf("AWS Secrets Manager secret %s has no secret string", p.secretID)
+	}
+	return ParseTunnelSecret([]byte(*secret.SecretString))
+}
+
+// secretsManager returns the Secrets Manager client, loading the AWS configuration on first use. The client caches
+// the AWS credentials and renews them before they expire.
+func (p *awsSecretsManagerCredentials) secretsManager(ctx context.Context) (*secretsmanager.Client, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.client != nil {
+		return p.client, nil
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithEC2IMDSRegion())
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load the AWS configuration")
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("the AWS region must be set, e.g. with AWS_REGION, to read the credentials from AWS Secrets Manager")
+	}
+	p.client = secretsmanager.NewFromConfig(cfg)
+	return p.client, nil
+}
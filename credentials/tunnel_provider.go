@@ -0,0 +1,135 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+// TunnelCredentialsProvider loads the credentials of a named tunnel from where they're stored. Every Load reads them
+// again, so that rotated credentials are picked up.
+type TunnelCredentialsProvider interface {
+	// Name describes where the credentials are loaded from, for the logs
+	Name() string
+	Load(ctx context.Context) (connection.Credentials, error)
+}
+
+// NewTunnelCredentialsProvider makes the provider described by spec, which is one of:
+//   - file:PATH, a tunnel credentials JSON file or a file containing a tunnel token
+//   - env:NAME, an environment variable containing a tunnel token or credentials JSON
+//   - kubernetes:DIR#KEY, the KEY of a Kubernetes secret mounted at DIR
+//   - vault:PATH[#FIELD], a HashiCorp Vault secret read at VAULT_ADDR with VAULT_TOKEN
+//   - aws-secretsmanager:SECRET-ID, an AWS Secrets Manager secret read with the standard AWS credential chain
+func NewTunnelCredentialsProvider(spec string) (TunnelCredentialsProvider, error) {
+	kind, location, ok := strings.Cut(spec, ":")
+	if !ok || location == "" {
+		return nil, fmt.Errorf("credentials provider %q should be KIND:LOCATION", spec)
+	}
+	switch kind {
+	case "file":
+		path, err := homedir.Expand(location)
+		if err != nil {
+			return nil, err
+		}
+		return fileCredentials{path: path}, nil
+	case "env":
+		return envCredentials{name: location}, nil
+	case "kubernetes":
+		dir, key, ok := strings.Cut(location, "#")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("kubernetes credentials provider %q should be kubernetes:DIR#KEY", spec)
+		}
+		return kubernetesCredentials{dir: dir, key: key}, nil
+	case "vault":
+		return newVaultCredentials(location)
+	case "aws-secretsmanager":
+		return newAWSSecretsManagerCredentials(location)
+	default:
+		return nil, fmt.Errorf("unknown credentials provider %q, expected file, env, kubernetes, vault or aws-secretsmanager", kind)
+	}
+}
+
+// ParseTunnelSecret parses the credentials of a tunnel from the JSON of a credentials file, or a tunnel token.
+func ParseTunnelSecret(secret []byte) (connection.Credentials, error) {
+	secret = bytes.TrimSpace(secret)
+	if len(secret) == 0 {
+		return connection.Credentials{}, errors.New("the tunnel credentials are empty")
+	}
+	if secret[0] == '{' {
+		var credentials connection.Credentials
+		if err := json.Unmarshal(secret, &credentials); err != nil {
+			return connection.Credentials{}, errors.Wrap(err, "invalid tunnel credentials JSON")
+		}
+		return credentials, nil
+	}
+	content, err := base64.StdEncoding.DecodeString(string(secret))
+	if err != nil {
+		return connection.Credentials{}, errors.New("the tunnel credentials are neither JSON nor a tunnel token")
+	}
+	var token connection.TunnelToken
+	if err := json.Unmarshal(content, &token); err != nil {
+		return connection.Credentials{}, errors.Wrap(err, "invalid tunnel token")
+	}
+	return token.Credentials(), nil
+}
+
+type fileCredentials struct {
+	path string
+}
+
+func (p fileCredentials) Name() string {
+	return "file " + p.path
+}
+
+func (p fileCredentials) Load(context.Context) (connection.Credentials, error) {
+	secret, err := os.ReadFile(p.path)
+	if err != nil {
+		return connection.Credentials{}, err
+	}
+	return ParseTunnelSecret(secret)
+}
+
+type envCredentials struct {
+	name string
+}
+
+func (p envCredentials) Name() string {
+	return "environment variable " + p.name
+}
+
+func (p envCredentials) Load(context.Context) (connection.Credentials, error) {
+	secret, ok := os.LookupEnv(p.name)
+	if !ok {
+		return connection.Credentials{}, fmt.Errorf("environment variable %s isn't set", p.name)
+	}
+	return ParseTunnelSecret([]byte(secret))
+}
+
+// kubernetesCredentials reads a key of a mounted secret. The kubelet updates the mount by swapping the ..data
+// symlink of its directory, so the key is read through it every time.
+type kubernetesCredentials struct {
+	dir string
+	key string
+}
+
+func (p kubernetesCredentials) Name() string {
+	return fmt.Sprintf("kubernetes secret %s in %s", p.key, p.dir)
+}
+
+func (p kubernetesCredentials) Load(context.Context) (connection.Credentials, error) {
+	secret, err := os.ReadFile(filepath.Join(p.dir, p.key))
+	if err != nil {
+		return connection.Credentials{}, errors.Wrapf(err, "the secret isn't mounted at %s", p.dir)
+	}
+	return ParseTunnelSecret(secret)
+}
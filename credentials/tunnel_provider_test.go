@@ -0,0 +1,262 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+var testTunnelCredentials = connection.Credentials{
+	AccountTag:   "account",
+	TunnelSecret: []byte("secret"),
+	TunnelID:     uuid.MustParse("f3e1a4b2-8d7c-4c7e-9b4a-2f6d1e0c9a8b"),
+}
+
+func testTunnelToken(t *testing.T, credentials connection.Credentials) string {
+	token, err := connection.TunnelToken{
+		AccountTag:   credentials.AccountTag,
+		TunnelSecret: credentials.TunnelSecret,
+		TunnelID:     credentials.TunnelID,
+	}.Encode()
+	require.NoError(t, err)
+	return token
+}
+
+func TestParseTunnelSecret(t *testing.T) {
+	credentialsJSON, err := json.Marshal(testTunnelCredentials)
+	require.NoError(t, err)
+
+	for _, secret := range []string{string(credentialsJSON), testTunnelToken(t, testTunnelCredentials) + "\n"} {
+		credentials, err := ParseTunnelSecret([]byte(secret))
+		require.NoError(t, err)
+		assert.Equal(t, testTunnelCredentials, credentials)
+	}
+
+	for _, invalid := range []string{"", " \n", "{not json", "not a token!"} {
+		_, err := ParseTunnelSecret([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestNewTunnelCredentialsProvider(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "https://vault.example.com/")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	tests := []struct {
+		spec string
+		name string
+	}{
+		{spec: "file:/etc/cloudflared/tunnel.json", name: "file /etc/cloudflared/tunnel.json"},
+		{spec: "env:TUNNEL_SECRET", name: "environment variable TUNNEL_SECRET"},
+		{spec: "kubernetes:/var/run/secrets/tunnel#token", name: "kubernetes secret token in /var/run/secrets/tunnel"},
+		{spec: "vault:secret/data/cloudflared#token", name: "vault secret secret/data/cloudflared"},
+		{spec: "aws-secretsmanager:cloudflared/tunnel", name: "AWS Secrets Manager secret cloudflared/tunnel"},
+	}
+	for _, test := range tests {
+		provider, err := NewTunnelCredentialsProvider(test.spec)
+		require.NoError(t, err, test.spec)
+		assert.Equal(t, test.name, provider.Name())
+	}
+
+	for _, invalid := range []string{"", "file", "file:", "kubernetes:/var/run/secrets/tunnel", "gcp:secret"} {
+		_, err := NewTunnelCredentialsProvider(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestLocalTunnelCredentialsProviders(t *testing.T) {
+	dir := t.TempDir()
+	token := testTunnelToken(t, testTunnelCredentials)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "token"), []byte(token), 0o600))
+	t.Setenv("TEST_TUNNEL_TOKEN", token)
+
+	for _, spec := range []string{"file:" + filepath.Join(dir, "token"), "env:TEST_TUNNEL_TOKEN", "kubernetes:" + dir + "#token"} {
+		provider, err := NewTunnelCredentialsProvider(spec)
+		require.NoError(t, err)
+		credentials, err := provider.Load(context.Background())
+		require.NoError(t, err, spec)
+		assert.Equal(t, testTunnelCredentials, credentials)
+	}
+
+	for _, spec := range []string{"file:" + filepath.Join(dir, "missing"), "env:TEST_TUNNEL_TOKEN_MISSING", "kubernetes:" + dir + "#missing"} {
+		provider, err := NewTunnelCredentialsProvider(spec)
+		require.NoError(t, err)
+		_, err = provider.Load(context.Background())
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestVaultTunnelCredentials(t *testing.T) {
+	token := testTunnelToken(t, testTunnelCredentials)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/cloudflared":
+			_, _ = w.Write([]byte(`{"data": {"data": {"token": "` + token + `"}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/cloudflared":
+			_, _ = w.Write([]byte(`{"data": {"AccountTag": "account", "TunnelSecret": "c2VjcmV0", "TunnelID": "f3e1a4b2-8d7c-4c7e-9b4a-2f6d1e0c9a8b"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "vault-token")
+
+	for _, spec := range []string{"vault:secret/data/cloudflared#token", "vault:/kv/cloudflared"} {
+		provider, err := NewTunnelCredentialsProvider(spec)
+		require.NoError(t, err)
+		credentials, err := provider.Load(context.Background())
+		require.NoError(t, err, spec)
+		assert.Equal(t, testTunnelCredentials, credentials)
+	}
+
+	for _, spec := range []string{"vault:secret/data/cloudflared#missing", "vault:secret/data/missing"} {
+		provider, err := NewTunnelCredentialsProvider(spec)
+		require.NoError(t, err)
+		_, err = provider.Load(context.Background())
+		assert.Error(t, err, spec)
+	}
+
+	t.Setenv("VAULT_TOKEN", "")
+	t.Setenv("VAULT_TOKEN_FILE", filepath.Join(t.TempDir(), "missing"))
+	provider, err := NewTunnelCredentialsProvider("vault:secret/data/cloudflared#token")
+	require.NoError(t, err)
+	_, err = provider.Load(context.Background())
+	assert.ErrorContains(t, err, "VAULT_TOKEN_FILE")
+}
+
+func TestAWSSecretsManagerTunnelCredentials(t *testing.T) {
+	token := testTunnelToken(t, testTunnelCredentials)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=access-key/") ||
+			!strings.Contains(auth, "/eu-west-1/secretsmanager/aws4_request, ") ||
+			r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" || r.Header.Get("X-Amz-Security-Token") != "session" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var input struct{ SecretId string }
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.SecretId != "cloudflared/tunnel" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"Name": "cloudflared/tunnel", "SecretString": "` + token + `"}`))
+	}))
+	defer server.Close()
+	// Only the environment provides the AWS configuration
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ENDPOINT_URL_SECRETS_MANAGER", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "access-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret-key")
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+
+	provider, err := newAWSSecretsManagerCredentials("cloudflared/tunnel")
+	require.NoError(t, err)
+	credentials, err := provider.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, testTunnelCredentials, credentials)
+
+	// Without credentials in the environment or anywhere else in the chain, the secret can't be read
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	provider, err = newAWSSecretsManagerCredentials("cloudflared/tunnel")
+	require.NoError(t, err)
+	_, err = provider.Load(context.Background())
+	assert.Error(t, err)
+}
+
+type stubTunnelCredentialsProvider struct {
+	lock        sync.Mutex
+	credentials connection.Credentials
+	err         error
+}
+
+func (p *stubTunnelCredentialsProvider) Name() string {
+	return "stub"
+}
+
+func (p *stubTunnelCredentialsProvider) Load(context.Context) (connection.Credentials, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.credentials, p.err
+}
+
+func TestRotatingTunnelCredentials(t *testing.T) {
+	log := zerolog.Nop()
+	provider := &stubTunnelCredentialsProvider{credentials: testTunnelCredentials}
+	rotating, err := NewRotatingTunnelCredentials(context.Background(), provider, time.Minute, &log)
+	require.NoError(t, err)
+	assert.Equal(t, testTunnelCredentials, rotating.Current())
+
+	_, changed := rotating.reload(context.Background())
+	assert.False(t, changed)
+
+	rotated := testTunnelCredentials
+	rotated.TunnelSecret = []byte("rotated")
+	provider.credentials = rotated
+	credentials, changed := rotating.reload(context.Background())
+	assert.True(t, changed)
+	assert.Equal(t, rotated, credentials)
+	assert.Equal(t, rotated, rotating.Current())
+
+	// Invalid credentials keep the current ones
+	provider.err = assert.AnError
+	_, changed = rotating.reload(context.Background())
+	assert.False(t, changed)
+	provider.err = nil
+	provider.credentials.TunnelID = uuid.New()
+	_, changed = rotating.reload(context.Background())
+	assert.False(t, changed)
+	assert.Equal(t, rotated, rotating.Current())
+
+	_, err = NewRotatingTunnelCredentials(context.Background(), &stubTunnelCredentialsProvider{err: assert.AnError}, time.Minute, &log)
+	assert.Error(t, err)
+	_, err = NewRotatingTunnelCredentials(context.Background(), &stubTunnelCredentialsProvider{}, time.Minute, &log)
+	assert.Error(t, err)
+}
+
+func TestRotatingTunnelCredentialsRun(t *testing.T) {
+	log := zerolog.Nop()
+	provider := &stubTunnelCredentialsProvider{credentials: testTunnelCredentials}
+	rotating, err := NewRotatingTunnelCredentials(context.Background(), provider, time.Millisecond, &log)
+	require.NoError(t, err)
+
+	rotated := testTunnelCredentials
+	rotated.TunnelSecret = []byte("rotated")
+	rotatedC := make(chan connection.Credentials, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rotating.Run(ctx, func(credentials connection.Credentials) {
+		rotatedC <- credentials
+	})
+	provider.lock.Lock()
+	provider.credentials = rotated
+	provider.lock.Unlock()
+
+	select {
+	case credentials := <-rotatedC:
+		assert.Equal(t, rotated, credentials)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the rotated credentials weren't reported")
+	}
+}
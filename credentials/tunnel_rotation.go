@@ -0,0 +1,91 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+// RotatingTunnelCredentials keeps the latest credentials of a tunnel loaded by a provider.
+type RotatingTunnelCredentials struct {
+	provider TunnelCredentialsProvider
+	interval time.Duration
+	log      *zerolog.Logger
+
+	lock    sync.RWMutex
+	current connection.Credentials
+}
+
+// NewRotatingTunnelCredentials loads the credentials from provider, failing if they can't be loaded.
+func NewRotatingTunnelCredentials(ctx context.Context, provider TunnelCredentialsProvider, interval time.Duration, log *zerolog.Logger) (*RotatingTunnelCredentials, error) {
+	credentials, err := provider.Load(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot load the tunnel credentials from %s", provider.Name())
+	}
+	if credentials.TunnelID == uuid.Nil {
+		return nil, errors.Errorf("the tunnel credentials from %s have no tunnel ID", provider.Name())
+	}
+	return &RotatingTunnelCredentials{
+		provider: provider,
+		interval: interval,
+		log:      log,
+		current:  credentials,
+	}, nil
+}
+
+// Current returns the latest credentials.
+func (r *RotatingTunnelCredentials) Current() connection.Credentials {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.current
+}
+
+// Run reloads the credentials every interval until ctx is done, calling rotated when they changed. The previous
+// credentials are kept when the new ones can't be loaded, or belong to another tunnel.
+func (r *RotatingTunnelCredentials) Run(ctx context.Context, rotated func(connection.Credentials)) {
+	if r.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if credentials, changed := r.reload(ctx); changed {
+			rotated(credentials)
+		}
+	}
+}
+
+func (r *RotatingTunnelCredentials) reload(ctx context.Context) (connection.Credentials, bool) {
+	credentials, err := r.provider.Load(ctx)
+	if err != nil {
+		r.log.Err(err).Msgf("Cannot reload the tunnel credentials from %s, keeping the current ones", r.provider.Name())
+		return connection.Credentials{}, false
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if credentials.TunnelID != r.current.TunnelID {
+		r.log.Error().Msgf("The tunnel credentials from %s are now for tunnel %s instead of %s, keeping the current ones",
+			r.provider.Name(), credentials.TunnelID, r.current.TunnelID)
+		return connection.Credentials{}, false
+	}
+	if credentials.AccountTag == r.current.AccountTag && credentials.Endpoint == r.current.Endpoint &&
+		bytes.Equal(credentials.TunnelSecret, r.current.TunnelSecret) {
+		return connection.Credentials{}, false
+	}
+	r.current = credentials
+	r.log.Info().Msgf("The tunnel credentials from %s were rotated", r.provider.Name())
+	return credentials, true
+}
@@ -0,0 +1,113 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+const secretStoreTimeout = 15 * time.Second
+
+// vaultCredentials reads the credentials from a HashiCorp Vault secret with the HTTP API, supporting both versions of
+// the KV secrets engine. The secret either has a field holding a tunnel token or credentials JSON, or the fields of
+// a credentials file.
+type vaultCredentials struct {
+	addr   string
+	path   string
+	field  string
+	client *http.Client
+}
+
+func newVaultCredentials(location string) (*vaultCredentials, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, errors.New("VAULT_ADDR must be set to read the credentials from Vault")
+	}
+	path, field, _ := strings.Cut(location, "#")
+	return &vaultCredentials{
+		addr:   strings.TrimSuffix(addr, "/"),
+		path:   strings.Trim(path, "/"),
+		field:  field,
+		client: &http.Client{Timeout: secretStoreTimeout},
+	}, nil
+}
+
+func (p *vaultCredentials) Name() string {
+	return "vault secret " + p.path
+}
+
+func (p *vaultCredentials) Load(ctx context.Context) (connection.Credentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/v1/%s", p.addr, p.path), nil)
+	if err != nil {
+		return connection.Credentials{}, err
+	}
+	// The token is read every time, since the Vault agent renews it in the environment or file
+	token := os.Getenv("VAULT_TOKEN")
+	if tokenFile := os.Getenv("VAULT_TOKEN_FILE"); token == "" && tokenFile != "" {
+		content, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return connection.Credentials{}, errors.Wrap(err, "cannot read VAULT_TOKEN_FILE")
+		}
+		token = strings.TrimSpace(string(content))
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return connection.Credentials{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return connection.Credentials{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return connection.Credentials{}, fmt.Errorf("vault responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return connection.Credentials{}, errors.Wrap(err, "invalid vault response")
+	}
+	data := secret.Data
+	// Version 2 of the KV secrets engine nests the fields of the secret with its metadata
+	if nested, ok := data["data"]; ok {
+		if _, ok := data["metadata"]; ok {
+			if err := json.Unmarshal(nested, &data); err != nil {
+				return connection.Credentials{}, errors.Wrap(err, "invalid vault KV v2 secret")
+			}
+		}
+	}
+
+	if p.field == "" {
+		fields, err := json.Marshal(data)
+		if err != nil {
+			return connection.Credentials{}, err
+		}
+		return ParseTunnelSecret(fields)
+	}
+	value, ok := data[p.field]
+	if !ok {
+		return connection.Credentials{}, fmt.Errorf("vault secret %s has no field %s", p.path, p.field)
+	}
+	var str string
+	if err := json.Unmarshal(value, &str); err != nil {
+		// The field holds the credentials JSON as an object
+		return ParseTunnelSecret(value)
+	}
+	return ParseTunnelSecret([]byte(str))
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -22,8 +23,16 @@ const (
 var (
 	errSessionManagerClosed = fmt.Errorf("session manager closed")
 	LogFieldSessionID       = "sessionID"
+
+	// activeSessions counts the sessions held by all the managers
+	activeSessions atomic.Int64
 )
 
+// ActiveSessions returns the number of sessions held by all the managers.
+func ActiveSessions() int64 {
+	return activeSessions.Load()
+}
+
 func FormatSessionID(sessionID uuid.UUID) string {
 	sessionIDStr := sessionID.String()
 	sessionIDStr = strings.ReplaceAll(sessionIDStr, "-", "")
@@ -128,6 +137,7 @@ func (m *manager) RegisterSession(ctx context.Context, sessionID uuid.UUID, orig
 func (m *manager) registerSession(ctx context.Context, registration *registerSessionEvent) {
 	session := m.newSession(registration.sessionID, registration.originProxy)
 	m.sessions[registration.sessionID] = session
+	activeSessions.Add(1)
 	registration.resultChan <- session
 	incrementUDPSessions()
 }
@@ -174,6 +184,7 @@ func (m *manager) unregisterSession(unregistration *unregisterSessionEvent) {
 	session, ok := m.sessions[unregistration.sessionID]
 	if ok {
 		delete(m.sessions, unregistration.sessionID)
+		activeSessions.Add(-1)
 		session.close(unregistration.err)
 		decrementUDPActiveSessions()
 	}
@@ -11,15 +11,43 @@ import (
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 
+	"github.com/cloudflare/cloudflared/cfio"
+	cfdflow "github.com/cloudflare/cloudflared/flow"
 	"github.com/cloudflare/cloudflared/packet"
+	"github.com/cloudflare/cloudflared/recovery"
 )
 
 const (
 	defaultCloseIdleAfter = time.Second * 210
 )
 
+type errSessionIdle struct {
+	timeout time.Duration
+}
+
+func (e errSessionIdle) Error() string {
+	return fmt.Sprintf("session idle for %v", e.timeout)
+}
+
 func SessionIdleErr(timeout time.Duration) error {
-	return fmt.Errorf("session idle for %v", timeout)
+	return errSessionIdle{timeout: timeout}
+}
+
+// CloseReason returns the reason of the flow record of a session that stopped serving with err.
+func CloseReason(closedByRemote bool, err error) string {
+	var idleErr errSessionIdle
+	switch {
+	case closedByRemote:
+		return cfdflow.CloseByEdge
+	case errors.As(err, &idleErr):
+		return cfdflow.CloseIdle
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return cfdflow.CloseConnection
+	case err == nil, errors.Is(err, net.ErrClosed), errors.Is(err, io.EOF):
+		return cfdflow.CloseByOrigin
+	default:
+		return cfdflow.CloseError
+	}
 }
 
 type transportSender func(session *packet.Session) error
@@ -47,14 +75,24 @@ type Session struct {
 	activeAtChan chan time.Time
 	closeChan    chan error
 	log          *zerolog.Logger
+	// counters counts the datagrams written to and read from dstConn
+	counters cfdflow.Counters
+}
+
+// Counters returns the traffic counts of the session, in which the origin is dstConn.
+func (s *Session) Counters() *cfdflow.Counters {
+	return &s.counters
 }
 
 func (s *Session) Serve(ctx context.Context, closeAfterIdle time.Duration) (closedByRemote bool, err error) {
 	go func() {
+		defer recovery.Recover(s.log, recovery.SubsystemDatagram, recovery.NoConnIndex)
 		// QUIC implementation copies data to another buffer before returning https://github.com/quic-go/quic-go/blob/v0.24.0/session.go#L1967-L1975
 		// This makes it safe to share readBuffer between iterations
 		const maxPacketSize = 1500
-		readBuffer := make([]byte, maxPacketSize)
+		pooledBuffer := cfio.GetBuffer(maxPacketSize)
+		defer cfio.PutBuffer(pooledBuffer)
+		readBuffer := *pooledBuffer
 		for {
 			if closeSession, err := s.dstToTransport(readBuffer); err != nil {
 				if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
@@ -115,6 +153,7 @@ func (s *Session) dstToTransport(buffer []byte) (closeSession bool, err error) {
 	s.markActive()
 	// https://pkg.go.dev/io#Reader suggests caller should always process n > 0 bytes
 	if n > 0 || err == nil {
+		s.counters.FromOrigin(n)
 		session := packet.Session{
 			ID:      s.ID,
 			Payload: buffer[:n],
@@ -131,6 +170,8 @@ func (s *Session) transportToDst(payload []byte) (int, error) {
 	n, err := s.dstConn.Write(payload)
 	if err != nil {
 		s.log.Err(err).Msg("Failed to write payload to session")
+	} else {
+		s.counters.ToOrigin(n)
 	}
 	return n, err
 }
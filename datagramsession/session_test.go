@@ -16,6 +16,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sync/errgroup"
 
+	cfdflow "github.com/cloudflare/cloudflared/flow"
 	"github.com/cloudflare/cloudflared/packet"
 )
 
@@ -95,6 +96,45 @@ func testSessionReturns(t *testing.T, closeBy closeMethod, closeAfterIdle time.D
 	cancel()
 }
 
+// TestSessionCounters makes sure the datagrams proxied in both directions are counted
+func TestSessionCounters(t *testing.T) {
+	sessionID := uuid.New()
+	cfdConn, originConn := net.Pipe()
+	payload := testPayload(sessionID)
+
+	sender := newMockTransportSender(sessionID, payload)
+	mg := NewManager(&nopLogger, sender.muxSession, nil)
+	session := mg.newSession(sessionID, cfdConn)
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		n, err := session.transportToDst(payload)
+		assert.NoError(t, err)
+		assert.Equal(t, len(payload), n)
+	}()
+	readBuffer := make([]byte, len(payload)+1)
+	n, err := originConn.Read(readBuffer)
+	require.NoError(t, err)
+	require.Equal(t, len(payload), n)
+	// The datagram is counted once the write returns
+	<-written
+
+	go func() {
+		_, _ = originConn.Write(payload)
+	}()
+	closeSession, err := session.dstToTransport(make([]byte, len(payload)+1))
+	require.NoError(t, err)
+	require.False(t, closeSession)
+
+	var record cfdflow.Record
+	session.Counters().Fill(&record)
+	assert.Equal(t, uint64(len(payload)), record.BytesToOrigin)
+	assert.Equal(t, uint64(1), record.PacketsToOrigin)
+	assert.Equal(t, uint64(len(payload)), record.BytesFromOrigin)
+	assert.Equal(t, uint64(1), record.PacketsFromOrigin)
+}
+
 type closeMethod int
 
 const (
@@ -263,3 +303,20 @@ func (sots *sendOnceTransportSender) muxSession(session *packet.Session) error {
 	defer close(sots.sentChan)
 	return sots.baseSender.muxSession(session)
 }
+
+func TestCloseReason(t *testing.T) {
+	tests := []struct {
+		closedByRemote bool
+		err            error
+		expected       string
+	}{
+		{closedByRemote: true, err: &errClosedSession{message: "eyeball closed", byRemote: true}, expected: cfdflow.CloseByEdge},
+		{err: SessionIdleErr(time.Second), expected: cfdflow.CloseIdle},
+		{err: context.Canceled, expected: cfdflow.CloseConnection},
+		{err: io.EOF, expected: cfdflow.CloseByOrigin},
+		{err: fmt.Errorf("write: connection refused"), expected: cfdflow.CloseError},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, CloseReason(test.closedByRemote, test.err), test.err)
+	}
+}
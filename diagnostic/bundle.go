@@ -0,0 +1,291 @@
+package diagnostic
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+
+	cfdflags "github.com/cloudflare/cloudflared/cmd/cloudflared/flags"
+)
+
+const (
+	bundleLogTailBytes    = 1 << 20 // maximum number of bytes of the log file included in a bundle
+	bundleEdgeDialTimeout = 3 * time.Second
+	bundleCollectorName   = "bundle" // used for logging purposes
+	redactedValue         = "REDACTED"
+	quicMetricsPrefix     = "quic_"
+)
+
+// secretKeyPattern matches the configuration keys whose values are never included in a bundle.
+var secretKeyPattern = regexp.MustCompile(`(?i)(secret|token|password|passwd|credential|key$)`)
+
+// BundleSources are the parts of a diagnostics bundle that the Handler doesn't own.
+type BundleSources struct {
+	// Config returns the effective remote or local configuration of the tunnel as JSON.
+	Config func() ([]byte, error)
+	// EdgeAddrs resolves the edge addresses whose reachability is tested.
+	EdgeAddrs func() ([]*net.TCPAddr, error)
+	// Gatherer provides the QUIC statistics. Defaults to prometheus.DefaultGatherer.
+	Gatherer prometheus.Gatherer
+}
+
+// EdgeReachability is the result of dialing an edge address from a bundle.
+type EdgeReachability struct {
+	Address string `json:"address"`
+	Latency string `json:"latency,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// SetBundleSources configures the sources of the bundles served by the handler.
+func (handler *Handler) SetBundleSources(sources BundleSources) {
+	handler.bundleSources = sources
+}
+
+// BundleHandler serves a gzipped tarball with the recent logs, connection states, goroutine and heap profiles,
+// redacted effective configuration, edge reachability and QUIC statistics of this instance.
+func (handler *Handler) BundleHandler(writer http.ResponseWriter, request *http.Request) {
+	log := handler.log.With().Str(collectorField, bundleCollectorName).Logger()
+	log.Info().Msg("Collection started")
+
+	defer log.Info().Msg("Collection finished")
+
+	ctx, cancel := context.WithTimeout(request.Context(), handler.timeout)
+	defer cancel()
+
+	writer.Header().Set("Content-Type", "application/gzip")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", bundleFilename(time.Now())))
+
+	if err := handler.WriteBundle(ctx, writer); err != nil {
+		log.Error().Err(err).Msg("error occurred whilst writing bundle")
+	}
+}
+
+// WriteBundle writes a diagnostics bundle to writer. A part that fails to be collected is replaced by a file
+// describing the error so that one broken source doesn't prevent the rest from being collected.
+func (handler *Handler) WriteBundle(ctx context.Context, writer io.Writer) error {
+	gz := gzip.NewWriter(writer)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	parts := []struct {
+		name    string
+		collect func(context.Context) ([]byte, error)
+	}{
+		{tunnelStateBaseName, handler.bundleTunnelState},
+		{cliConfigurationBaseName, handler.bundleCliConfiguration},
+		{configurationBaseName, handler.bundleConfiguration},
+		{goroutinePprofBaseName, bundleProfile("goroutine")},
+		{heapPprofBaseName, bundleProfile("heap")},
+		{logFilename, handler.bundleLogs},
+		{edgeReachabilityBaseName, handler.bundleEdgeReachability},
+		{quicStatsBaseName, handler.bundleQUICStats},
+	}
+	for _, part := range parts {
+		name := part.name
+		data, err := part.collect(ctx)
+		if err != nil {
+			name += ".error"
+			data = []byte(err.Error())
+		}
+		if err := writeTarFile(tw, name, data, now); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("error closing tarball: %w", err)
+	}
+	return gz.Close()
+}
+
+func (handler *Handler) bundleTunnelState(context.Context) ([]byte, error) {
+	return json.MarshalIndent(TunnelState{
+		handler.tunnelID,
+		handler.connectorID,
+		handler.tracker.GetActiveConnections(),
+		handler.icmpSources,
+	}, "", "  ")
+}
+
+func (handler *Handler) bundleCliConfiguration(context.Context) ([]byte, error) {
+	flags := make(map[string]interface{}, len(handler.cliFlags))
+	for k, v := range handler.cliFlags {
+		flags[k] = v
+	}
+	return json.MarshalIndent(redact(flags), "", "  ")
+}
+
+func (handler *Handler) bundleConfiguration(context.Context) ([]byte, error) {
+	if handler.bundleSources.Config == nil {
+		return nil, ErrNoConfigurationSource
+	}
+	raw, err := handler.bundleSources.Config()
+	if err != nil {
+		return nil, err
+	}
+	var config interface{}
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("error decoding configuration: %w", err)
+	}
+	return json.MarshalIndent(redact(config), "", "  ")
+}
+
+func bundleProfile(name string) func(context.Context) ([]byte, error) {
+	return func(context.Context) ([]byte, error) {
+		profile := pprof.Lookup(name)
+		if profile == nil {
+			return nil, fmt.Errorf("unknown profile %s", name)
+		}
+		var buf bytes.Buffer
+		if err := profile.WriteTo(&buf, 0); err != nil {
+			return nil, fmt.Errorf("error writing %s profile: %w", name, err)
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+// bundleLogs returns the tail of the log file this instance writes to, if any.
+func (handler *Handler) bundleLogs(context.Context) ([]byte, error) {
+	path := handler.cliFlags[cfdflags.LogFile]
+	if path == "" {
+		if dir := handler.cliFlags[cfdflags.LogDirectory]; dir != "" {
+			path = filepath.Join(dir, "cloudflared.log")
+		}
+	}
+	if path == "" {
+		return nil, ErrLogConfigurationIsInvalid
+	}
+	return tailFile(path, bundleLogTailBytes)
+}
+
+func (handler *Handler) bundleEdgeReachability(ctx context.Context) ([]byte, error) {
+	if handler.bundleSources.EdgeAddrs == nil {
+		return nil, ErrNoEdgeSource
+	}
+	addrs, err := handler.bundleSources.EdgeAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("error resolving edge addresses: %w", err)
+	}
+
+	results := make([]EdgeReachability, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr *net.TCPAddr) {
+			defer wg.Done()
+			results[i] = probeEdge(ctx, addr)
+		}(i, addr)
+	}
+	wg.Wait()
+	return json.MarshalIndent(results, "", "  ")
+}
+
+func probeEdge(ctx context.Context, addr *net.TCPAddr) EdgeReachability {
+	result := EdgeReachability{Address: addr.String()}
+	dialer := net.Dialer{Timeout: bundleEdgeDialTimeout}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", addr.String())
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	_ = conn.Close()
+	result.Latency = time.Since(start).String()
+	return result
+}
+
+// bundleQUICStats returns the QUIC connection metrics in the Prometheus text format.
+func (handler *Handler) bundleQUICStats(context.Context) ([]byte, error) {
+	gatherer := handler.bundleSources.Gatherer
+	if gatherer == nil {
+		gatherer = prometheus.DefaultGatherer
+	}
+	families, err := gatherer.Gather()
+	if err != nil {
+		return nil, fmt.Errorf("error gathering metrics: %w", err)
+	}
+	var buf bytes.Buffer
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), quicMetricsPrefix) {
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+			return nil, fmt.Errorf("error encoding metrics: %w", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// redact replaces the values of secret looking keys of a decoded JSON document.
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, inner := range v {
+			if secretKeyPattern.MatchString(key) {
+				v[key] = redactedValue
+				continue
+			}
+			v[key] = redact(inner)
+		}
+	case []interface{}:
+		for i, inner := range v {
+			v[i] = redact(inner)
+		}
+	}
+	return value
+}
+
+func tailFile(path string, maxBytes int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening log file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("error reading log file: %w", err)
+	}
+	if offset := info.Size() - maxBytes; offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("error reading log file: %w", err)
+		}
+	}
+	return io.ReadAll(file)
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0o600,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("error writing %s header: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("error writing %s: %w", name, err)
+	}
+	return nil
+}
+
+func bundleFilename(now time.Time) string {
+	return fmt.Sprintf("%s-bundle-%s.tar.gz", zipName, now.UTC().Format("2006-01-02T15-04-05"))
+}
@@ -0,0 +1,116 @@
+package diagnostic_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfdflags "github.com/cloudflare/cloudflared/cmd/cloudflared/flags"
+	"github.com/cloudflare/cloudflared/diagnostic"
+)
+
+func readBundle(t *testing.T, r io.Reader) map[string][]byte {
+	t.Helper()
+
+	gz, err := gzip.NewReader(r)
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	files := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[header.Name] = data
+	}
+}
+
+func TestBundleHandler(t *testing.T) {
+	t.Parallel()
+
+	log := zerolog.Nop()
+	logFile := filepath.Join(t.TempDir(), "cloudflared.log")
+	require.NoError(t, os.WriteFile(logFile, []byte("recent log line\n"), 0o600))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	registry := prometheus.NewRegistry()
+	quicCounter := prometheus.NewCounter(prometheus.CounterOpts{Name: "quic_client_test_total", Help: "test"})
+	otherCounter := prometheus.NewCounter(prometheus.CounterOpts{Name: "cloudflared_test_total", Help: "test"})
+	registry.MustRegister(quicCounter, otherCounter)
+
+	flags := map[string]string{
+		cfdflags.LogFile: logFile,
+		"token":          "secret-token",
+	}
+	handler := diagnostic.NewDiagnosticHandler(&log, 0, nil, uuid.New(), uuid.New(), newTrackerFromConns(t, nil), flags, nil)
+	handler.SetBundleSources(diagnostic.BundleSources{
+		Config: func() ([]byte, error) {
+			return []byte(`{"version":1,"config":{"ingress":[{"service":"http://localhost","originRequest":{"access":{"teamName":"team"},"clientSecret":"shh"}}]}}`), nil
+		},
+		EdgeAddrs: func() ([]*net.TCPAddr, error) {
+			return []*net.TCPAddr{listener.Addr().(*net.TCPAddr)}, nil
+		},
+		Gatherer: registry,
+	})
+
+	recorder := httptest.NewRecorder()
+	handler.BundleHandler(recorder, httptest.NewRequest(http.MethodGet, "/diag/bundle", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/gzip", recorder.Header().Get("Content-Type"))
+
+	files := readBundle(t, recorder.Body)
+	for _, name := range []string{"tunnelstate.json", "cli-configuration.json", "configuration.json", "goroutine.pprof", "heap.pprof", "cloudflared_logs.txt", "edge-reachability.json", "quic-stats.txt"} {
+		assert.Contains(t, files, name)
+	}
+
+	assert.Equal(t, "recent log line\n", string(files["cloudflared_logs.txt"]))
+	assert.NotContains(t, string(files["cli-configuration.json"]), "secret-token")
+	assert.NotContains(t, string(files["configuration.json"]), "shh")
+	assert.Contains(t, string(files["configuration.json"]), "team")
+	assert.Contains(t, string(files["quic-stats.txt"]), "quic_client_test_total")
+	assert.NotContains(t, string(files["quic-stats.txt"]), "cloudflared_test_total")
+
+	var reachability []diagnostic.EdgeReachability
+	require.NoError(t, json.Unmarshal(files["edge-reachability.json"], &reachability))
+	require.Len(t, reachability, 1)
+	assert.Equal(t, listener.Addr().String(), reachability[0].Address)
+	assert.Empty(t, reachability[0].Error)
+}
+
+func TestBundleReportsMissingSources(t *testing.T) {
+	t.Parallel()
+
+	log := zerolog.Nop()
+	handler := diagnostic.NewDiagnosticHandler(&log, 0, nil, uuid.New(), uuid.New(), newTrackerFromConns(t, nil), map[string]string{}, nil)
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(handler.WriteBundle(context.Background(), pw))
+	}()
+	files := readBundle(t, pr)
+
+	assert.Equal(t, diagnostic.ErrNoConfigurationSource.Error(), string(files["configuration.json.error"]))
+	assert.Equal(t, diagnostic.ErrNoEdgeSource.Error(), string(files["edge-reachability.json.error"]))
+	assert.Equal(t, diagnostic.ErrLogConfigurationIsInvalid.Error(), string(files["cloudflared_logs.txt.error"]))
+	assert.Contains(t, files, "tunnelstate.json")
+}
@@ -119,6 +119,15 @@ func (client *httpClient) GetGoroutineDump(ctx context.Context, writer io.Writer
 	return copyToWriter(response, writer)
 }
 
+func (client *httpClient) GetBundle(ctx context.Context, writer io.Writer) error {
+	response, err := client.GET(ctx, bundleEndpoint)
+	if err != nil {
+		return err
+	}
+
+	return copyToWriter(response, writer)
+}
+
 func (client *httpClient) GetTunnelState(ctx context.Context) (*TunnelState, error) {
 	response, err := client.GET(ctx, tunnelStateEndpoint)
 	if err != nil {
@@ -18,6 +18,7 @@ const (
 	cliConfigurationEndpoint    = "/diag/configuration"
 	tunnelStateEndpoint         = "/diag/tunnel"
 	systemInformationEndpoint   = "/diag/system"
+	bundleEndpoint              = "/diag/bundle"
 	memoryDumpEndpoint          = "debug/pprof/heap"
 	goroutineDumpEndpoint       = "debug/pprof/goroutine"
 	metricsEndpoint             = "metrics"
@@ -34,4 +35,6 @@ const (
 	cliConfigurationBaseName  = "cli-configuration.json"
 	configurationBaseName     = "configuration.json"
 	taskResultBaseName        = "task-result.json"
+	edgeReachabilityBaseName  = "edge-reachability.json"
+	quicStatsBaseName         = "quic-stats.txt"
 )
@@ -93,6 +93,8 @@ type Options struct {
 	ContainerID    string
 	PodID          string
 	Toggles        Toggles
+	// Bundle downloads the bundle generated by the instance itself instead of running the diagnostic procedure.
+	Bundle bool
 }
 
 func collectLogs(
@@ -519,6 +521,10 @@ func RunDiagnostic(
 
 	defer cancel()
 
+	if options.Bundle {
+		return nil, downloadBundle(ctx, client, log)
+	}
+
 	jobs := createJobs(
 		client,
 		tunnel,
@@ -559,3 +565,23 @@ func RunDiagnostic(
 
 	return nil, gerr
 }
+
+func downloadBundle(ctx context.Context, client *httpClient, log *zerolog.Logger) error {
+	name := bundleFilename(time.Now())
+
+	file, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("error creating bundle file: %w", err)
+	}
+
+	defer file.Close()
+
+	if err := client.GetBundle(ctx, file); err != nil {
+		os.Remove(name)
+		return err
+	}
+
+	log.Info().Msgf("Diagnostic bundle written: %v", name)
+
+	return nil
+}
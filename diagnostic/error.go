@@ -25,4 +25,8 @@ var (
 	ErrMultipleMetricsServerFound = errors.New("multiple metrics server found")
 	// Error used when a temporary file creation fails within the diagnostic procedure
 	ErrCreatingTemporaryFile = errors.New("temporary file creation failed")
+	// Error used when a bundle is requested from a handler without a configuration source.
+	ErrNoConfigurationSource = errors.New("no configuration source")
+	// Error used when a bundle is requested from a handler without an edge address source.
+	ErrNoEdgeSource = errors.New("no edge address source")
 )
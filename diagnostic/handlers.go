@@ -23,6 +23,7 @@ type Handler struct {
 	tracker         *tunnelstate.ConnTracker
 	cliFlags        map[string]string
 	icmpSources     []string
+	bundleSources   BundleSources
 }
 
 func NewDiagnosticHandler(
@@ -53,10 +54,16 @@ func NewDiagnosticHandler(
 	}
 }
 
-func (handler *Handler) InstallEndpoints(router *http.ServeMux) {
-	router.HandleFunc(cliConfigurationEndpoint, handler.ConfigurationHandler)
+// InstallEndpoints registers the diagnostic endpoints to router. The endpoints exposing profiles or the
+// configuration are wrapped by guard, if set, to restrict them like the debug endpoints.
+func (handler *Handler) InstallEndpoints(router *http.ServeMux, guard func(http.Handler) http.Handler) {
+	if guard == nil {
+		guard = func(h http.Handler) http.Handler { return h }
+	}
+	router.Handle(cliConfigurationEndpoint, guard(http.HandlerFunc(handler.ConfigurationHandler)))
 	router.HandleFunc(tunnelStateEndpoint, handler.TunnelStateHandler)
 	router.HandleFunc(systemInformationEndpoint, handler.SystemHandler)
+	router.Handle(bundleEndpoint, guard(http.HandlerFunc(handler.BundleHandler)))
 }
 
 type SystemInformationResponse struct {
@@ -0,0 +1,126 @@
+// Package dscp marks the packets of the connections to the edge with DSCP code points, so that networks can
+// prioritize the traffic of the tunnel.
+package dscp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxValue is the largest DSCP code point.
+const MaxValue = 63
+
+var errUnsupported = errors.New("DSCP marking is not supported on this platform")
+
+// names are the code points of RFC 2474, RFC 2597 and RFC 3246.
+var names = map[string]uint8{
+	"cs0": 0, "cs1": 8, "cs2": 16, "cs3": 24, "cs4": 32, "cs5": 40, "cs6": 48, "cs7": 56,
+	"af11": 10, "af12": 12, "af13": 14,
+	"af21": 18, "af22": 20, "af23": 22,
+	"af31": 26, "af32": 28, "af33": 30,
+	"af41": 34, "af42": 36, "af43": 38,
+	"ef": 46,
+}
+
+// ParseValue parses a code point, either a number up to 63 or a name such as af41 or ef.
+func ParseValue(s string) (uint8, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if value, ok := names[s]; ok {
+		return value, nil
+	}
+	value, err := strconv.ParseUint(s, 0, 8)
+	if err != nil || value > MaxValue {
+		return 0, fmt.Errorf("invalid DSCP value %q, expected a number up to %d or a name such as af41 or ef", s, MaxValue)
+	}
+	return uint8(value), nil
+}
+
+type rule struct {
+	protocol  string
+	connIndex int
+	value     uint8
+}
+
+// Config holds the code points of the connections to the edge, by protocol and connection index. The zero value
+// leaves every connection unmarked.
+type Config struct {
+	rules []rule
+}
+
+// Parse parses a comma separated list of marks. Each mark is a value, optionally prefixed with the protocol and
+// connection index it applies to: "af41", "quic=ef", "2=cs3" or "http2:0=af21". The most specific mark of a
+// connection applies.
+func Parse(spec string) (Config, error) {
+	var config Config
+	if strings.TrimSpace(spec) == "" {
+		return config, nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		r := rule{connIndex: -1}
+		selector, value, found := strings.Cut(entry, "=")
+		if !found {
+			value, selector = selector, ""
+		}
+		selector = strings.ToLower(strings.TrimSpace(selector))
+		if selector != "" {
+			protocol, index, hasIndex := strings.Cut(selector, ":")
+			if !hasIndex {
+				if _, err := strconv.ParseUint(protocol, 10, 8); err == nil {
+					protocol, index, hasIndex = "", protocol, true
+				}
+			}
+			if protocol != "" && protocol != "quic" && protocol != "http2" {
+				return Config{}, fmt.Errorf("invalid DSCP protocol %q, expected quic or http2", protocol)
+			}
+			r.protocol = protocol
+			if hasIndex {
+				connIndex, err := strconv.ParseUint(index, 10, 8)
+				if err != nil {
+					return Config{}, fmt.Errorf("invalid DSCP connection index %q", index)
+				}
+				r.connIndex = int(connIndex)
+			}
+		}
+		var err error
+		if r.value, err = ParseValue(value); err != nil {
+			return Config{}, err
+		}
+		config.rules = append(config.rules, r)
+	}
+	if !supported {
+		return Config{}, errUnsupported
+	}
+	return config, nil
+}
+
+// For returns the code point of a connection, and whether it should be marked.
+func (c Config) For(protocol string, connIndex uint8) (uint8, bool) {
+	best, bestScore := uint8(0), -1
+	for _, r := range c.rules {
+		score := 0
+		if r.protocol != "" {
+			if r.protocol != protocol {
+				continue
+			}
+			score++
+		}
+		if r.connIndex >= 0 {
+			if r.connIndex != int(connIndex) {
+				continue
+			}
+			score += 2
+		}
+		// Later marks override earlier ones of the same specificity
+		if score >= bestScore {
+			best, bestScore = r.value, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+// TOS returns the value of the IPv4 TOS and IPv6 traffic class fields carrying a code point, without ECN bits.
+func TOS(value uint8) int {
+	return int(value) << 2
+}
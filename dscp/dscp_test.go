@@ -0,0 +1,58 @@
+package dscp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseValue(t *testing.T) {
+	for input, expected := range map[string]uint8{"ef": 46, "AF41": 34, "cs0": 0, "63": 63, "0x2e": 46} {
+		value, err := ParseValue(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, value, input)
+	}
+	for _, input := range []string{"", "64", "af51", "-1"} {
+		_, err := ParseValue(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestConfigFor(t *testing.T) {
+	config, err := Parse("af21, quic=ef, 1=cs3, http2:1=af41")
+	require.NoError(t, err)
+
+	cases := []struct {
+		protocol  string
+		connIndex uint8
+		expected  uint8
+	}{
+		{"http2", 0, 18},
+		{"quic", 0, 46},
+		{"quic", 1, 24},
+		{"http2", 1, 34},
+	}
+	for _, c := range cases {
+		value, mark := config.For(c.protocol, c.connIndex)
+		assert.True(t, mark)
+		assert.Equal(t, c.expected, value, "%s:%d", c.protocol, c.connIndex)
+	}
+
+	config, err = Parse("quic:2=ef")
+	require.NoError(t, err)
+	_, mark := config.For("quic", 0)
+	assert.False(t, mark)
+	_, mark = config.For("http2", 2)
+	assert.False(t, mark)
+
+	_, mark = Config{}.For("quic", 0)
+	assert.False(t, mark)
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{"tcp=ef", "quic:x=ef", "quic=", "quic:256=ef", "ef,"} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,48 @@
+//go:build linux
+
+package dscp
+
+import (
+	"net"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestSet(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, Set(conn, 46))
+	rawConn, err := conn.SyscallConn()
+	require.NoError(t, err)
+	var tos int
+	require.NoError(t, rawConn.Control(func(fd uintptr) {
+		tos, err = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS)
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, 46<<2, tos&^ecnMask)
+}
+
+func TestMarkControlMessages(t *testing.T) {
+	// A packet info message, which is left as is, followed by an IPv4 TOS message carrying ECT(0)
+	oob := make([]byte, unix.CmsgSpace(12)+unix.CmsgSpace(1))
+	header := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	header.Level = unix.IPPROTO_IP
+	header.Type = unix.IP_PKTINFO
+	header.SetLen(unix.CmsgLen(12))
+	tos := oob[unix.CmsgSpace(12):]
+	header = (*unix.Cmsghdr)(unsafe.Pointer(&tos[0]))
+	header.Level = unix.IPPROTO_IP
+	header.Type = unix.IP_TOS
+	header.SetLen(unix.CmsgLen(1))
+	tos[unix.CmsgLen(0)] = 0x2
+
+	MarkControlMessages(oob, 46)
+	assert.Equal(t, byte(46<<2|0x2), tos[unix.CmsgLen(0)])
+	assert.Equal(t, make([]byte, 12), oob[unix.CmsgLen(0):unix.CmsgLen(12)])
+}
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package dscp
+
+import "syscall"
+
+const supported = false
+
+// Set marks the packets sent through a socket.
+func Set(_ syscall.Conn, _ uint8) error {
+	return errUnsupported
+}
+
+// Control returns a Control function for net.Dialer that marks the sockets it dials.
+func Control(_ uint8) func(network, address string, rawConn syscall.RawConn) error {
+	return func(_, _ string, _ syscall.RawConn) error {
+		return errUnsupported
+	}
+}
+
+// MarkControlMessages sets the code point in the IPv4 TOS and IPv6 traffic class control messages of oob.
+func MarkControlMessages(_ []byte, _ uint8) {}
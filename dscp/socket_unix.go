@@ -0,0 +1,75 @@
+//go:build unix
+
+package dscp
+
+import (
+	"encoding/binary"
+	"errors"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	supported = true
+	ecnMask   = 0x3
+)
+
+// Set marks the packets sent through a socket. Both the IPv4 and IPv6 options are set, since dual stack sockets
+// send IPv4 packets with the IPv4 one.
+func Set(conn syscall.Conn, value uint8) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return set(rawConn, value)
+}
+
+// Control returns a Control function for net.Dialer that marks the sockets it dials.
+func Control(value uint8) func(network, address string, rawConn syscall.RawConn) error {
+	return func(_, _ string, rawConn syscall.RawConn) error {
+		return set(rawConn, value)
+	}
+}
+
+func set(rawConn syscall.RawConn, value uint8) error {
+	var errIPv4, errIPv6 error
+	if err := rawConn.Control(func(fd uintptr) {
+		errIPv4 = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, TOS(value))
+		errIPv6 = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, TOS(value))
+	}); err != nil {
+		return err
+	}
+	if errIPv4 != nil && errIPv6 != nil {
+		return errors.Join(errIPv4, errIPv6)
+	}
+	return nil
+}
+
+// MarkControlMessages sets the code point in the IPv4 TOS and IPv6 traffic class control messages of oob, keeping
+// their ECN bits. These messages override the marking of the socket for the packet they are sent with.
+func MarkControlMessages(oob []byte, value uint8) {
+	headerLen := unix.CmsgLen(0)
+	for len(oob) >= headerLen {
+		h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+		msgLen := int(h.Len) // nolint: gosec
+		if msgLen < headerLen || msgLen > len(oob) {
+			return
+		}
+		if (h.Level == unix.IPPROTO_IP && h.Type == unix.IP_TOS) || (h.Level == unix.IPPROTO_IPV6 && h.Type == unix.IPV6_TCLASS) {
+			switch data := oob[headerLen:msgLen]; len(data) {
+			case 1:
+				data[0] = data[0]&ecnMask | byte(TOS(value))
+			case 4:
+				tos := binary.NativeEndian.Uint32(data)
+				binary.NativeEndian.PutUint32(data, tos&ecnMask|uint32(TOS(value)))
+			}
+		}
+		next := unix.CmsgSpace(msgLen - headerLen)
+		if next >= len(oob) {
+			return
+		}
+		oob = oob[next:]
+	}
+}
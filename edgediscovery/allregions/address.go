@@ -1,5 +1,7 @@
 package allregions
 
+import "net"
+
 // Region contains cloudflared edge addresses. The edge is partitioned into several regions for
 // redundancy purposes.
 type AddrSet map[*EdgeAddr]UsedBy
@@ -45,6 +47,18 @@ func (a AddrSet) Use(addr *EdgeAddr, connID int) {
 	a[addr] = InUse(connID)
 }
 
+// UseIP assigns the unused address with the given IP to a proxy connection.
+// Returns nil if there is no such address, or it's in use.
+func (a AddrSet) UseIP(ip net.IP, connID int) *EdgeAddr {
+	for addr, usedby := range a {
+		if !usedby.Used && addr.UDP.IP.Equal(ip) {
+			a.Use(addr, connID)
+			return addr
+		}
+	}
+	return nil
+}
+
 // GetAnyAddress returns an arbitrary address from the region.
 func (a AddrSet) GetAnyAddress() *EdgeAddr {
 	for addr := range a {
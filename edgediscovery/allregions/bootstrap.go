@@ -0,0 +1,173 @@
+package allregions
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	bootstrapTimeout = 15 * time.Second
+	// bootstrapMaxBytes bounds the size of the bootstrap documents
+	bootstrapMaxBytes = 1 << 20
+	// BootstrapSignatureHeader holds the base64 encoded ed25519 signature of the body of a bootstrap document
+	BootstrapSignatureHeader = "Cf-Edge-Signature"
+)
+
+var (
+	ErrBootstrapSignature = errors.New("edge bootstrap document signature verification failed")
+
+	bootstrapLock sync.RWMutex
+	bootstrap     *Bootstrap
+)
+
+// bootstrapDocument lists the addresses of each region of the edge, by SRV service name, e.g.
+//
+//	{"services": {"v2-origintunneld": [["198.41.192.7:7844", "198.41.192.37:7844"], ["198.41.200.13:7844"]]}}
+type bootstrapDocument struct {
+	Services map[string][][]string `json:"services"`
+}
+
+// Bootstrap fetches the edge addresses from an HTTPS endpoint, for the networks blocking the SRV lookups. The documents
+// must be signed with the private key of PublicKey. The last verified document is kept and revalidated with its ETag,
+// and is used as is when the endpoint can't be reached.
+type Bootstrap struct {
+	url       string
+	publicKey ed25519.PublicKey
+	client    *http.Client
+
+	lock     sync.Mutex
+	etag     string
+	document *bootstrapDocument
+}
+
+func NewBootstrap(url string, publicKey ed25519.PublicKey) *Bootstrap {
+	return &Bootstrap{
+		url:       url,
+		publicKey: publicKey,
+		client:    &http.Client{Timeout: bootstrapTimeout},
+	}
+}
+
+// SetBootstrap sets the HTTPS endpoint the edge discovery falls back to when the SRV lookups fail. nil disables it.
+func SetBootstrap(b *Bootstrap) {
+	bootstrapLock.Lock()
+	defer bootstrapLock.Unlock()
+	bootstrap = b
+}
+
+func currentBootstrap() *Bootstrap {
+	bootstrapLock.RLock()
+	defer bootstrapLock.RUnlock()
+	return bootstrap
+}
+
+// Lookup returns the addresses of each region of the SRV service.
+func (b *Bootstrap) Lookup(ctx context.Context, service string) ([][]*EdgeAddr, error) {
+	document, fetchErr := b.fetch(ctx)
+	if fetchErr != nil {
+		b.lock.Lock()
+		document = b.document
+		b.lock.Unlock()
+		if document == nil {
+			return nil, fetchErr
+		}
+	}
+	regions, ok := document.Services[service]
+	if !ok || len(regions) == 0 {
+		return nil, fmt.Errorf("edge bootstrap document has no addresses for %s", service)
+	}
+	resolved := make([][]*EdgeAddr, 0, len(regions))
+	for _, region := range regions {
+		addrs := make([]*EdgeAddr, 0, len(region))
+		for _, addr := range region {
+			edgeAddr, err := parseEdgeAddr(addr)
+			if err != nil {
+				return nil, err
+			}
+			addrs = append(addrs, edgeAddr)
+		}
+		resolved = append(resolved, addrs)
+	}
+	return resolved, nil
+}
+
+func (b *Bootstrap) fetch(ctx context.Context) (*bootstrapDocument, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid edge bootstrap request")
+	}
+	b.lock.Lock()
+	if b.etag != "" && b.document != nil {
+		req.Header.Set("If-None-Match", b.etag)
+	}
+	b.lock.Unlock()
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch the edge bootstrap document")
+	}
+	defer resp.Body.Close()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if b.document == nil {
+			return nil, errors.New("edge bootstrap endpoint returned 304 without a cached document")
+		}
+		return b.document, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("edge bootstrap endpoint returned %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, bootstrapMaxBytes))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the edge bootstrap document")
+	}
+	signature, err := base64.StdEncoding.DecodeString(resp.Header.Get(BootstrapSignatureHeader))
+	if err != nil || !ed25519.Verify(b.publicKey, body, signature) {
+		return nil, ErrBootstrapSignature
+	}
+	var document bootstrapDocument
+	if err := json.Unmarshal(body, &document); err != nil {
+		return nil, errors.Wrap(err, "invalid edge bootstrap document")
+	}
+	b.document = &document
+	b.etag = resp.Header.Get("ETag")
+	return b.document, nil
+}
+
+func parseEdgeAddr(addr string) (*EdgeAddr, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid edge address %s in bootstrap document", addr)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid edge address %s in bootstrap document: not an IP address", addr)
+	}
+	portNumber, err := net.LookupPort("tcp", port)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid edge address %s in bootstrap document", addr)
+	}
+	version := V6
+	if ip.To4() != nil {
+		version = V4
+	}
+	return &EdgeAddr{
+		TCP:       &net.TCPAddr{IP: ip, Port: portNumber},
+		UDP:       &net.UDPAddr{IP: ip, Port: portNumber},
+		IPVersion: version,
+	}, nil
+}
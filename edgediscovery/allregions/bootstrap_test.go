@@ -0,0 +1,114 @@
+package allregions
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBootstrapDocument = `{"services": {"v2-origintunneld": [["198.41.192.7:7844", "[2606:4700:a0::1]:7844"], ["198.41.200.13:7844"]]}}`
+
+func newTestBootstrapServer(t *testing.T, privateKey ed25519.PrivateKey, requests *atomic.Int32, unavailable *atomic.Bool) *httptest.Server {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if unavailable.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		signature := ed25519.Sign(privateKey, []byte(testBootstrapDocument))
+		w.Header().Set(BootstrapSignatureHeader, base64.StdEncoding.EncodeToString(signature))
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(testBootstrapDocument))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestBootstrapLookup(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	var requests atomic.Int32
+	var unavailable atomic.Bool
+	server := newTestBootstrapServer(t, privateKey, &requests, &unavailable)
+
+	b := NewBootstrap(server.URL, publicKey)
+	b.client = server.Client()
+
+	regions, err := b.Lookup(context.Background(), srvService)
+	require.NoError(t, err)
+	require.Len(t, regions, 2)
+	require.Len(t, regions[0], 2)
+	assert.Equal(t, net.ParseIP("198.41.192.7").To4(), regions[0][0].UDP.IP.To4())
+	assert.Equal(t, 7844, regions[0][0].TCP.Port)
+	assert.Equal(t, V4, regions[0][0].IPVersion)
+	assert.Equal(t, V6, regions[0][1].IPVersion)
+
+	// Revalidated with the ETag
+	_, err = b.Lookup(context.Background(), srvService)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// The last verified document is used when the endpoint fails
+	unavailable.Store(true)
+	regions, err = b.Lookup(context.Background(), srvService)
+	require.NoError(t, err)
+	assert.Len(t, regions, 2)
+
+	_, err = b.Lookup(context.Background(), "us-"+srvService)
+	assert.Error(t, err)
+}
+
+func TestBootstrapSignature(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	var requests atomic.Int32
+	var unavailable atomic.Bool
+	server := newTestBootstrapServer(t, privateKey, &requests, &unavailable)
+
+	b := NewBootstrap(server.URL, otherPublicKey)
+	b.client = server.Client()
+	_, err = b.Lookup(context.Background(), srvService)
+	assert.ErrorIs(t, err, ErrBootstrapSignature)
+}
+
+func TestEdgeDiscoveryBootstrapFallback(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	var requests atomic.Int32
+	var unavailable atomic.Bool
+	server := newTestBootstrapServer(t, privateKey, &requests, &unavailable)
+
+	netLookupSRV = func(string, string, string) (string, []*net.SRV, error) {
+		return "", nil, &net.DNSError{Err: "blocked", Name: srvName}
+	}
+	fallbackLookupSRV = netLookupSRV
+	b := NewBootstrap(server.URL, publicKey)
+	b.client = server.Client()
+	SetBootstrap(b)
+	t.Cleanup(func() {
+		netLookupSRV = net.LookupSRV
+		fallbackLookupSRV = lookupSRVWithDOT
+		SetBootstrap(nil)
+	})
+
+	l := zerolog.Nop()
+	regions, err := edgeDiscovery(&l, srvService)
+	require.NoError(t, err)
+	assert.Len(t, regions, 2)
+}
@@ -120,6 +120,16 @@ func edgeDiscovery(log *zerolog.Logger, srvService string) ([][]*EdgeAddr, error
 	if err != nil {
 		_, fallbackAddrs, fallbackErr := fallbackLookupSRV(srvService, srvProto, srvName)
 		if fallbackErr != nil || len(fallbackAddrs) == 0 {
+			if b := currentBootstrap(); b != nil {
+				ctx, cancel := context.WithTimeout(context.Background(), bootstrapTimeout)
+				defer cancel()
+				regions, bootstrapErr := b.Lookup(ctx, srvService)
+				if bootstrapErr == nil {
+					logger.Info().Err(err).Msg("edge discovery: the DNS query failed, using the edge addresses of the HTTPS bootstrap endpoint")
+					return regions, nil
+				}
+				logger.Err(bootstrapErr).Msg("edge discovery: error fetching the edge addresses from the HTTPS bootstrap endpoint")
+			}
 			// use the original DNS error `err` in messages, not `fallbackErr`
 			logger.Err(err).Msg("edge discovery: error looking up Cloudflare edge IPs: the DNS query failed")
 			for _, s := range friendlyDNSErrorLines {
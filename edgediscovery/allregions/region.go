@@ -1,6 +1,9 @@
 package allregions
 
-import "time"
+import (
+	"net"
+	"time"
+)
 
 const (
 	timeoutDuration = 10 * time.Minute
@@ -98,6 +101,12 @@ func (r Region) AssignAnyAddress(connID int, excluding *EdgeAddr) *EdgeAddr {
 	return nil
 }
 
+// AssignAddress assigns the unused address with the given IP in this region now to the connID.
+// Returns nil if the active addresses of the region don't include an unused one with this IP.
+func (r Region) AssignAddress(ip net.IP, connID int) *EdgeAddr {
+	return r.active.UseIP(ip, connID)
+}
+
 // GetAnyAddress returns an arbitrary address from the region.
 func (r Region) GetAnyAddress() *EdgeAddr {
 	return r.active.GetAnyAddress()
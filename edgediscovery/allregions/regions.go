@@ -3,10 +3,14 @@ package allregions
 import (
 	"fmt"
 	"math/rand"
+	"net"
 
 	"github.com/rs/zerolog"
 )
 
+// avoidedConnID marks the addrs held aside by GetUnusedAddrAvoiding, which no connection uses.
+const avoidedConnID = -1
+
 // Regions stores Cloudflare edge network IPs, partitioned into two regions.
 // This is NOT thread-safe. Users of this package should use it with a lock.
 type Regions struct {
@@ -102,6 +106,40 @@ func (rs *Regions) GetUnusedAddr(excluding *EdgeAddr, connID int) *EdgeAddr {
 	return getAddrs(excluding, connID, &rs.region2, &rs.region1)
 }
 
+// GetUnusedAddrAvoiding gets an unused addr from the edge like GetUnusedAddr, except that the addrs avoid returns true
+// for are only given when no other is left.
+func (rs *Regions) GetUnusedAddrAvoiding(excluding *EdgeAddr, connID int, avoid func(*EdgeAddr) bool) *EdgeAddr {
+	// Hold the avoided addrs aside as used while the others are looked for
+	var avoided []AddrSet
+	var avoidedAddrs []*EdgeAddr
+	for _, set := range []AddrSet{rs.region1.primary, rs.region1.secondary, rs.region2.primary, rs.region2.secondary} {
+		for addr, usedBy := range set {
+			if !usedBy.Used && avoid(addr) {
+				set[addr] = InUse(avoidedConnID)
+				avoided = append(avoided, set)
+				avoidedAddrs = append(avoidedAddrs, addr)
+			}
+		}
+	}
+	addr := rs.GetUnusedAddr(excluding, connID)
+	for i, set := range avoided {
+		set.GiveBack(avoidedAddrs[i])
+	}
+	if addr != nil {
+		return addr
+	}
+	return rs.GetUnusedAddr(excluding, connID)
+}
+
+// AssignAddr assigns the unused addr of the edge with the given IP to connID.
+// Returns nil if the edge doesn't have an unused address with this IP.
+func (rs *Regions) AssignAddr(ip net.IP, connID int) *EdgeAddr {
+	if addr := rs.region1.AssignAddress(ip, connID); addr != nil {
+		return addr
+	}
+	return rs.region2.AssignAddress(ip, connID)
+}
+
 // getAddrs tries to grab address form `first` region, then `second` region
 // this is an unrolled loop over 2 element array
 func getAddrs(excluding *EdgeAddr, connID int, first *Region, second *Region) *EdgeAddr {
@@ -139,3 +177,24 @@ func getRegionalServiceName(region string) string {
 
 	return srvService // Global service is just `v2-origintunneld`
 }
+
+// ResolveRegionAddrs resolves the addresses of each region of the Cloudflare edge, keeping only the ones of the IP
+// version when it isn't Auto.
+func ResolveRegionAddrs(log *zerolog.Logger, region string, ipVersion ConfigIPVersion) ([][]*EdgeAddr, error) {
+	edgeAddrs, err := edgeDiscovery(log, getRegionalServiceName(region))
+	if err != nil {
+		return nil, err
+	}
+	regions := make([][]*EdgeAddr, 0, len(edgeAddrs))
+	for _, addrs := range edgeAddrs {
+		kept := make([]*EdgeAddr, 0, len(addrs))
+		for _, addr := range addrs {
+			if (ipVersion == IPv4Only && addr.IPVersion != V4) || (ipVersion == IPv6Only && addr.IPVersion != V6) {
+				continue
+			}
+			kept = append(kept, addr)
+		}
+		regions = append(regions, kept)
+	}
+	return regions, nil
+}
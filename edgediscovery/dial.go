@@ -3,22 +3,28 @@ package edgediscovery
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"net"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
 	"golang.org/x/net/proxy"
+
+	"github.com/cloudflare/cloudflared/errcodes"
 )
 
-// DialEdge makes a TLS connection to a Cloudflare edge node
+// DialEdge makes a TLS connection to a Cloudflare edge node. control, if any, is called on the socket before it
+// connects.
 func DialEdge(
 	ctx context.Context,
 	timeout time.Duration,
 	tlsConfig *tls.Config,
 	edgeTCPAddr *net.TCPAddr,
 	localIP net.IP,
+	control func(network, address string, rawConn syscall.RawConn) error,
 ) (net.Conn, error) {
-	dialer := net.Dialer{}
+	dialer := net.Dialer{Control: control}
 	if localIP != nil {
 		dialer.LocalAddr = &net.TCPAddr{IP: localIP, Port: 0}
 	}
@@ -39,27 +45,37 @@ func DialEdge(
 		edgeConn, err = proxyDialer.Dial("tcp", edgeTCPAddr.String())
 	}
 	if err != nil {
-		return nil, newDialError(err, "DialContext error")
+		return nil, newDialError(err, "DialContext error", errcodes.EdgeDial)
 	}
 
 	tlsEdgeConn := tls.Client(edgeConn, tlsConfig)
 	tlsEdgeConn.SetDeadline(time.Now().Add(timeout))
 
 	if err = tlsEdgeConn.Handshake(); err != nil {
-		return nil, newDialError(err, "TLS handshake with edge error")
+		return nil, newDialError(err, "TLS handshake with edge error", errcodes.EdgeTLSHandshake)
 	}
 	// clear the deadline on the conn; http2 has its own timeouts
 	tlsEdgeConn.SetDeadline(time.Time{})
 	return tlsEdgeConn, nil
 }
 
+// CheckBindAddrFamily returns a DialError when localIP, if any, can't reach edgeIP because they're of different IP
+// families, so that another edge address is tried.
+func CheckBindAddrFamily(edgeIP, localIP net.IP) error {
+	if localIP == nil || (edgeIP.To4() != nil) == (localIP.To4() != nil) {
+		return nil
+	}
+	return newDialError(fmt.Errorf("edge address %s and edge-bind-address %s are of different IP families", edgeIP, localIP), "Can't dial edge", errcodes.EdgeDial)
+}
+
 // DialError is an error returned from DialEdge
 type DialError struct {
 	cause error
+	code  errcodes.Code
 }
 
-func newDialError(err error, message string) error {
-	return DialError{cause: errors.Wrap(err, message)}
+func newDialError(err error, message string, code errcodes.Code) error {
+	return DialError{cause: errors.Wrap(err, message), code: code}
 }
 
 func (e DialError) Error() string {
@@ -69,3 +85,10 @@ func (e DialError) Error() string {
 func (e DialError) Cause() error {
 	return e.cause
 }
+
+func (e DialError) ErrorCode() errcodes.Code {
+	if errcodes.IsTimeout(e.cause) {
+		return errcodes.EdgeDialTimeout
+	}
+	return e.code
+}
@@ -0,0 +1,23 @@
+package edgediscovery
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudflare/cloudflared/errcodes"
+)
+
+func TestCheckBindAddrFamily(t *testing.T) {
+	v4, v6 := net.ParseIP("198.41.200.13"), net.ParseIP("2606:4700:a0::1")
+	assert.NoError(t, CheckBindAddrFamily(v4, nil))
+	assert.NoError(t, CheckBindAddrFamily(v6, nil))
+	assert.NoError(t, CheckBindAddrFamily(v4, net.ParseIP("10.0.0.2")))
+	assert.NoError(t, CheckBindAddrFamily(v6, net.ParseIP("2001:db8::2")))
+
+	err := CheckBindAddrFamily(v4, net.ParseIP("2001:db8::2"))
+	assert.IsType(t, DialError{}, err)
+	assert.Equal(t, errcodes.EdgeDial, errcodes.Of(err))
+	assert.Error(t, CheckBindAddrFamily(v6, net.ParseIP("10.0.0.2")))
+}
@@ -1,11 +1,14 @@
 package edgediscovery
 
 import (
+	"net"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 
 	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+	"github.com/cloudflare/cloudflared/errcodes"
 	"github.com/cloudflare/cloudflared/management"
 )
 
@@ -22,11 +25,17 @@ func (e ErrNoAddressesLeft) Error() string {
 	return "there are no free edge addresses left to resolve to"
 }
 
+func (e ErrNoAddressesLeft) ErrorCode() errcodes.Code {
+	return errcodes.NoEdgeAddresses
+}
+
 // Edge finds addresses on the Cloudflare edge and hands them out to connections.
 type Edge struct {
 	regions *allregions.Regions
 	sync.Mutex
 	log *zerolog.Logger
+	// cooldowns are the IPs of the addrs cooling down, with the time they stop cooling down at
+	cooldowns map[string]time.Time
 }
 
 // ------------------------------------
@@ -89,7 +98,7 @@ func (ed *Edge) GetAddr(connIndex int) (*allregions.EdgeAddr, error) {
 	}
 
 	// Otherwise, give it an unused one
-	addr := ed.regions.GetUnusedAddr(nil, connIndex)
+	addr := ed.regions.GetUnusedAddrAvoiding(nil, connIndex, ed.coolingDown)
 	if addr == nil {
 		log.Debug().Msg("edge discovery: no addresses left in pool to give proxy connection")
 		return nil, errNoAddressesLeft
@@ -98,6 +107,26 @@ func (ed *Edge) GetAddr(connIndex int) (*allregions.EdgeAddr, error) {
 	return addr, nil
 }
 
+// PreferAddr gives this proxy connection the edge Addr with the given IP, e.g. the one it used before restarting,
+// unless it already has one. Returns whether the connection uses it.
+func (ed *Edge) PreferAddr(connIndex int, ip net.IP) bool {
+	ed.Lock()
+	defer ed.Unlock()
+	if addr := ed.regions.AddrUsedBy(connIndex); addr != nil {
+		return addr.UDP.IP.Equal(ip)
+	}
+	addr := ed.regions.AssignAddr(ip, connIndex)
+	if addr == nil {
+		return false
+	}
+	ed.log.Debug().
+		Int(LogFieldConnIndex, connIndex).
+		Int(management.EventTypeKey, int(management.Cloudflared)).
+		IPAddr(LogFieldIPAddress, addr.UDP.IP).
+		Msg("edge discovery: giving previously used address to connection")
+	return true
+}
+
 // GetDifferentAddr gives back the proxy connection's edge Addr and uses a new one.
 func (ed *Edge) GetDifferentAddr(connIndex int, hasConnectivityError bool) (*allregions.EdgeAddr, error) {
 	log := ed.log.With().
@@ -111,7 +140,7 @@ func (ed *Edge) GetDifferentAddr(connIndex int, hasConnectivityError bool) (*all
 	if oldAddr != nil {
 		ed.regions.GiveBack(oldAddr, hasConnectivityError)
 	}
-	addr := ed.regions.GetUnusedAddr(oldAddr, connIndex)
+	addr := ed.regions.GetUnusedAddrAvoiding(oldAddr, connIndex, ed.coolingDown)
 	if addr == nil {
 		log.Debug().Msg("edge discovery: no addresses left in pool to give proxy connection")
 		// note: if oldAddr were not nil, it will become available on the next iteration
@@ -124,6 +153,37 @@ func (ed *Edge) GetDifferentAddr(connIndex int, hasConnectivityError bool) (*all
 	return addr, nil
 }
 
+// CoolDown keeps the addr with the given IP from being given to the connections for d, unless no other is left. Used
+// when the edge still holds a connection of this tunnel on it, so that each connection doesn't find out on its own.
+func (ed *Edge) CoolDown(ip net.IP, d time.Duration) {
+	ed.Lock()
+	defer ed.Unlock()
+	if ed.cooldowns == nil {
+		ed.cooldowns = make(map[string]time.Time)
+	}
+	ed.cooldowns[ip.String()] = time.Now().Add(d)
+	ed.log.Debug().
+		Int(management.EventTypeKey, int(management.Cloudflared)).
+		IPAddr(LogFieldIPAddress, ip).
+		Dur("cooldown", d).
+		Msg("edge discovery: address cooling down")
+}
+
+// coolingDown returns whether the addr is cooling down, forgetting the cooldown once it's over. The caller must hold
+// the lock.
+func (ed *Edge) coolingDown(addr *allregions.EdgeAddr) bool {
+	key := addr.UDP.IP.String()
+	until, ok := ed.cooldowns[key]
+	if !ok {
+		return false
+	}
+	if time.Now().Before(until) {
+		return true
+	}
+	delete(ed.cooldowns, key)
+	return false
+}
+
 // AvailableAddrs returns how many unused addresses there are left.
 func (ed *Edge) AvailableAddrs() int {
 	ed.Lock()
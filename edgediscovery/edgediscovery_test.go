@@ -3,6 +3,7 @@ package edgediscovery
 import (
 	"net"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
@@ -253,6 +254,54 @@ func TestGetDifferentAddr(t *testing.T) {
 	assert.Equal(t, 3, edge.AvailableAddrs())
 }
 
+func TestPreferAddr(t *testing.T) {
+	edge := MockEdge(&testLogger, []*allregions.EdgeAddr{&addr0, &addr1, &addr2, &addr3})
+
+	assert.True(t, edge.PreferAddr(0, addr2.UDP.IP))
+	addr, err := edge.GetAddr(0)
+	assert.NoError(t, err)
+	assert.Equal(t, &addr2, addr)
+	assert.Equal(t, 3, edge.AvailableAddrs())
+
+	// The address is used by another connection, or unknown
+	assert.False(t, edge.PreferAddr(1, addr2.UDP.IP))
+	assert.False(t, edge.PreferAddr(1, net.ParseIP("198.41.200.1")))
+	assert.Equal(t, 3, edge.AvailableAddrs())
+
+	// The connection already has an address
+	assert.True(t, edge.PreferAddr(0, addr2.UDP.IP))
+	assert.False(t, edge.PreferAddr(0, addr3.UDP.IP))
+	assert.Equal(t, 3, edge.AvailableAddrs())
+}
+
+func TestCoolDown(t *testing.T) {
+	edge := MockEdge(&testLogger, []*allregions.EdgeAddr{&addr0, &addr1, &addr2, &addr3})
+
+	// The addresses cooling down aren't given while others are left
+	edge.CoolDown(addr0.UDP.IP, time.Minute)
+	edge.CoolDown(addr1.UDP.IP, time.Minute)
+	for connID := 0; connID < 2; connID++ {
+		addr, err := edge.GetAddr(connID)
+		assert.NoError(t, err)
+		assert.False(t, addr.UDP.IP.Equal(addr0.UDP.IP) || addr.UDP.IP.Equal(addr1.UDP.IP))
+	}
+	// The addresses held aside are left unused
+	assert.Equal(t, 2, edge.AvailableAddrs())
+
+	// Then they are
+	addr, err := edge.GetAddr(2)
+	assert.NoError(t, err)
+	assert.True(t, addr.UDP.IP.Equal(addr0.UDP.IP) || addr.UDP.IP.Equal(addr1.UDP.IP))
+
+	// Once the cooldown is over, the address is given again
+	edge = MockEdge(&testLogger, []*allregions.EdgeAddr{&addr0, &addr1})
+	edge.CoolDown(addr0.UDP.IP, -time.Second)
+	edge.CoolDown(addr1.UDP.IP, time.Minute)
+	addr, err = edge.GetAddr(0)
+	assert.NoError(t, err)
+	assert.Equal(t, &addr0, addr)
+}
+
 // MockEdge creates a Cloudflare Edge from arbitrary TCP addresses. Used for testing.
 func MockEdge(log *zerolog.Logger, addrs []*allregions.EdgeAddr) *Edge {
 	regions := allregions.NewNoResolve(addrs)
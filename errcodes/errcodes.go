@@ -0,0 +1,99 @@
+// Package errcodes defines stable codes for the errors of the connections to the edge. Codes are included in logs,
+// metrics and the control API, so that automation doesn't depend on error messages, which change between releases.
+package errcodes
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/quic-go/quic-go"
+)
+
+// LogField is the log field of the code of an error.
+const LogField = "errorCode"
+
+// Code identifies a kind of error. Codes are never renamed or reused.
+type Code string
+
+const (
+	// Unknown is the code of the errors that weren't given one yet.
+	Unknown Code = "ERR_UNKNOWN"
+	// Canceled is the code of the connections stopped by cloudflared, e.g. on shutdown.
+	Canceled Code = "ERR_CANCELED"
+	// NoEdgeAddresses means every edge address is already used by a connection.
+	NoEdgeAddresses Code = "ERR_NO_EDGE_ADDRESSES"
+	// EdgeDial means the edge couldn't be dialed, e.g. the connection was refused.
+	EdgeDial Code = "ERR_EDGE_DIAL"
+	// EdgeDialTimeout means the edge didn't answer in time while dialing it or handshaking with it.
+	EdgeDialTimeout Code = "ERR_EDGE_DIAL_TIMEOUT"
+	// EdgeTLSHandshake means the TLS handshake with the edge failed.
+	EdgeTLSHandshake Code = "ERR_EDGE_TLS_HANDSHAKE"
+	// PQHandshake means the handshake with the edge failed while post-quantum key agreement was required.
+	PQHandshake Code = "ERR_PQ_HANDSHAKE"
+	// EdgeQUICDial means the QUIC connection to the edge couldn't be established.
+	EdgeQUICDial Code = "ERR_EDGE_QUIC_DIAL"
+	// EdgeIdleTimeout means the edge stopped answering on an established connection.
+	EdgeIdleTimeout Code = "ERR_EDGE_IDLE_TIMEOUT"
+	// DupRegistration means the connection was registered to an edge server that already has one of the connector.
+	DupRegistration Code = "ERR_DUP_REGISTRATION"
+	// RegistrationRejected means the edge refused to register the connection, and retrying won't help.
+	RegistrationRejected Code = "ERR_REGISTRATION_REJECTED"
+	// RegistrationRetryable means the edge couldn't register the connection this time.
+	RegistrationRetryable Code = "ERR_REGISTRATION_RETRYABLE"
+	// EdgeDraining means the edge asked to move the connection to another server.
+	EdgeDraining Code = "ERR_EDGE_DRAINING"
+	// ReconnectRequested means the connection was closed to reconnect, e.g. through the control API.
+	ReconnectRequested Code = "ERR_RECONNECT_REQUESTED"
+)
+
+// Coder is implemented by the errors that have a code.
+type Coder interface {
+	ErrorCode() Code
+}
+
+// Of returns the code of err, the one of the first error of its chain that has one. Nil errors have no code.
+func Of(err error) Code {
+	if err == nil {
+		return ""
+	}
+	var coder Coder
+	if errors.As(err, &coder) {
+		return coder.ErrorCode()
+	}
+	var idleTimeoutErr *quic.IdleTimeoutError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	case errors.As(err, &idleTimeoutErr):
+		return EdgeIdleTimeout
+	}
+	return Unknown
+}
+
+// IsTimeout returns whether err is a timeout, to tell the errors of edges that didn't answer in time apart.
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+type codedError struct {
+	code    Code
+	message string
+}
+
+// New returns an error with a code, e.g. for sentinel errors.
+func New(code Code, message string) error {
+	return &codedError{code: code, message: message}
+}
+
+func (e *codedError) Error() string {
+	return e.message
+}
+
+func (e *codedError) ErrorCode() Code {
+	return e.code
+}
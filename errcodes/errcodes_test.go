@@ -0,0 +1,56 @@
+package errcodes_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery"
+	"github.com/cloudflare/cloudflared/errcodes"
+	"github.com/cloudflare/cloudflared/supervisor"
+)
+
+func TestOf(t *testing.T) {
+	cryptoErr := &quic.TransportError{ErrorCode: quic.TransportErrorCode(0x100 + 40)}
+	tests := []struct {
+		err  error
+		code errcodes.Code
+	}{
+		{nil, ""},
+		{errors.New("unexpected"), errcodes.Unknown},
+		{fmt.Errorf("serve: %w", context.Canceled), errcodes.Canceled},
+		{&quic.IdleTimeoutError{}, errcodes.EdgeIdleTimeout},
+		{edgediscovery.ErrNoAddressesLeft{}, errcodes.NoEdgeAddresses},
+		{connection.DupConnRegisterTunnelError{}, errcodes.DupRegistration},
+		{connection.ServerRegisterTunnelError{Cause: errors.New("unauthorized"), Permanent: true}, errcodes.RegistrationRejected},
+		{connection.ServerRegisterTunnelError{Cause: errors.New("overloaded")}, errcodes.RegistrationRetryable},
+		{fmt.Errorf("serve: %w", connection.ErrEdgeDraining), errcodes.EdgeDraining},
+		{supervisor.ReconnectSignal{}, errcodes.ReconnectRequested},
+		{&connection.EdgeQuicDialError{Cause: &quic.IdleTimeoutError{}}, errcodes.EdgeDialTimeout},
+		{&connection.EdgeQuicDialError{Cause: cryptoErr}, errcodes.EdgeTLSHandshake},
+		{&connection.EdgeQuicDialError{Cause: cryptoErr, PostQuantum: true}, errcodes.PQHandshake},
+		{&connection.EdgeQuicDialError{Cause: errors.New("network unreachable")}, errcodes.EdgeQUICDial},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.code, errcodes.Of(test.err), "%v", test.err)
+	}
+}
+
+func TestOfDialError(t *testing.T) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	addr := listener.Addr().(*net.TCPAddr)
+	require.NoError(t, listener.Close())
+
+	_, err = edgediscovery.DialEdge(context.Background(), time.Second, nil, addr, nil, nil)
+	require.Error(t, err)
+	assert.Equal(t, errcodes.EdgeDial, errcodes.Of(err))
+}
@@ -0,0 +1,229 @@
+// Package eventsink publishes the events of the connections to the edge, such as registrations and disconnections,
+// to external systems: HTTP webhooks, Kafka topics through a Kafka REST proxy, or NATS subjects.
+package eventsink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+const (
+	queueSize       = 256
+	publishTimeout  = 10 * time.Second
+	maxAttempts     = 5
+	baseRetryDelay  = time.Second
+	maxRetryDelay   = 30 * time.Second
+	resultDelivered = "delivered"
+	resultFailed    = "failed"
+	resultDropped   = "dropped"
+)
+
+var sinkEvents = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: connection.MetricsNamespace,
+		Subsystem: connection.TunnelSubsystem,
+		Name:      "event_sink_events",
+		Help:      "Number of connection events published to the event sinks, by sink type and result",
+	},
+	[]string{"sink", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(sinkEvents)
+}
+
+// Metadata identifies the cloudflared instance the events come from.
+type Metadata struct {
+	TunnelID    uuid.UUID
+	ConnectorID uuid.UUID
+}
+
+// Payload is the data of an event, rendered by the payload template.
+type Payload struct {
+	Time        time.Time `json:"time"`
+	TunnelID    string    `json:"tunnelID"`
+	ConnectorID string    `json:"connectorID"`
+	Hostname    string    `json:"hostname"`
+	Event       string    `json:"event"`
+	ConnIndex   uint8     `json:"connIndex"`
+	Location    string    `json:"location,omitempty"`
+	Protocol    string    `json:"protocol,omitempty"`
+	EdgeAddress string    `json:"edgeAddress,omitempty"`
+	ErrorCode   string    `json:"errorCode,omitempty"`
+}
+
+// publisher delivers a rendered payload to an external system.
+type publisher interface {
+	publish(ctx context.Context, payload []byte) error
+	close()
+}
+
+// Sink publishes the connection events to an external system in the background, retrying failed deliveries. Events
+// are dropped when the system can't keep up rather than slowing down the connections.
+type Sink struct {
+	kind      string
+	publisher publisher
+	template  *template.Template
+	metadata  Metadata
+	hostname  string
+	events    chan connection.Event
+	log       *zerolog.Logger
+	// retryDelay is overridden in tests
+	retryDelay func(attempt int) time.Duration
+}
+
+// New returns the sink publishing to rawURL, which is either an http(s) webhook URL, a Kafka REST proxy
+// (kafka+http://proxy:8082/<topic> or kafka+https://) or a NATS server (nats://[user:password@]server:4222/<subject>).
+// The payloads are rendered by tmpl, or encoded to JSON if tmpl is nil.
+func New(rawURL string, tmpl *template.Template, metadata Metadata, log *zerolog.Logger) (*Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event sink URL: %w", err)
+	}
+	var publisher publisher
+	switch u.Scheme {
+	case "http", "https":
+		publisher, err = newWebhookPublisher(u)
+	case "kafka+http", "kafka+https":
+		publisher, err = newKafkaPublisher(u)
+	case "nats":
+		publisher, err = newNATSPublisher(u)
+	default:
+		err = fmt.Errorf("unsupported event sink %s, expected an http, https, kafka+http, kafka+https or nats URL", u.Redacted())
+	}
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	return &Sink{
+		kind:       kindOf(u.Scheme),
+		publisher:  publisher,
+		template:   tmpl,
+		metadata:   metadata,
+		hostname:   hostname,
+		events:     make(chan connection.Event, queueSize),
+		log:        log,
+		retryDelay: retryDelay,
+	}, nil
+}
+
+func kindOf(scheme string) string {
+	switch scheme {
+	case "kafka+http", "kafka+https":
+		return "kafka"
+	case "nats":
+		return "nats"
+	default:
+		return "webhook"
+	}
+}
+
+// ParseTemplate parses the template of the payloads, which is given a Payload.
+func ParseTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New("payload").Option("missingkey=error").Parse(text)
+}
+
+// OnTunnelEvent queues an event, it never blocks.
+func (s *Sink) OnTunnelEvent(event connection.Event) {
+	select {
+	case s.events <- event:
+	default:
+		sinkEvents.WithLabelValues(s.kind, resultDropped).Inc()
+		s.log.Warn().Str("sink", s.kind).Msg("Dropping connection event, the event sink can't keep up")
+	}
+}
+
+// Run publishes the queued events until ctx is done.
+func (s *Sink) Run(ctx context.Context) {
+	defer s.publisher.close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-s.events:
+			payload, err := s.render(event, time.Now())
+			if err != nil {
+				sinkEvents.WithLabelValues(s.kind, resultFailed).Inc()
+				s.log.Err(err).Str("sink", s.kind).Msg("Unable to render the connection event payload")
+				continue
+			}
+			if err := s.publish(ctx, payload); err != nil {
+				sinkEvents.WithLabelValues(s.kind, resultFailed).Inc()
+				s.log.Err(err).Str("sink", s.kind).Msg("Unable to publish connection event")
+				continue
+			}
+			sinkEvents.WithLabelValues(s.kind, resultDelivered).Inc()
+		}
+	}
+}
+
+func (s *Sink) publish(ctx context.Context, payload []byte) error {
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(s.retryDelay(attempt)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		publishCtx, cancel := context.WithTimeout(ctx, publishTimeout)
+		err = s.publisher.publish(publishCtx, payload)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		s.log.Debug().Err(err).Str("sink", s.kind).Int("attempt", attempt+1).Msg("Failed to publish connection event")
+	}
+	return err
+}
+
+func retryDelay(attempt int) time.Duration {
+	delay := baseRetryDelay << (attempt - 1)
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+func (s *Sink) render(event connection.Event, now time.Time) ([]byte, error) {
+	payload := Payload{
+		Time:        now.UTC(),
+		TunnelID:    s.metadata.TunnelID.String(),
+		ConnectorID: s.metadata.ConnectorID.String(),
+		Hostname:    s.hostname,
+		Event:       event.EventType.String(),
+		ConnIndex:   event.Index,
+		Location:    event.Location,
+		ErrorCode:   string(event.ErrorCode),
+	}
+	if event.EventType == connection.Connected || event.EventType == connection.ProtocolFallback {
+		payload.Protocol = event.Protocol.String()
+	}
+	if event.EdgeAddress != nil {
+		payload.EdgeAddress = event.EdgeAddress.String()
+	}
+	if s.template == nil {
+		return json.Marshal(payload)
+	}
+	var buf bytes.Buffer
+	if err := s.template.Execute(&buf, payload); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,214 @@
+package eventsink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/errcodes"
+)
+
+var testMetadata = Metadata{TunnelID: uuid.New(), ConnectorID: uuid.New()}
+
+func newTestSink(t *testing.T, rawURL, tmplText string) *Sink {
+	tmpl, err := ParseTemplate(tmplText)
+	require.NoError(t, err)
+	log := zerolog.Nop()
+	sink, err := New(rawURL, tmpl, testMetadata, &log)
+	require.NoError(t, err)
+	sink.retryDelay = func(int) time.Duration { return time.Millisecond }
+	return sink
+}
+
+func TestNewValidatesURL(t *testing.T) {
+	log := zerolog.Nop()
+	for _, invalid := range []string{
+		"ftp://example.com/events",
+		"https:///events",
+		"kafka+http://proxy:8082/",
+		"kafka+https://proxy:8082/a/b",
+		"nats://nats:4222/",
+		"nats:///events",
+	} {
+		_, err := New(invalid, nil, testMetadata, &log)
+		assert.Error(t, err, invalid)
+	}
+	_, err := ParseTemplate("{{.Event")
+	assert.Error(t, err)
+}
+
+func TestRender(t *testing.T) {
+	sink := newTestSink(t, "https://example.com/events", "")
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	payload, err := sink.render(connection.Event{
+		Index:       2,
+		EventType:   connection.Connected,
+		Location:    "lhr01",
+		Protocol:    connection.QUIC,
+		EdgeAddress: net.ParseIP("198.41.200.13"),
+	}, now)
+	require.NoError(t, err)
+	var decoded Payload
+	require.NoError(t, json.Unmarshal(payload, &decoded))
+	assert.Equal(t, Payload{
+		Time:        now,
+		TunnelID:    testMetadata.TunnelID.String(),
+		ConnectorID: testMetadata.ConnectorID.String(),
+		Hostname:    sink.hostname,
+		Event:       "connected",
+		ConnIndex:   2,
+		Location:    "lhr01",
+		Protocol:    "quic",
+		EdgeAddress: "198.41.200.13",
+	}, decoded)
+
+	sink = newTestSink(t, "https://example.com/events", `{"text": "connection {{.ConnIndex}} {{.Event}} ({{.ErrorCode}})"}`)
+	payload, err = sink.render(connection.Event{Index: 1, EventType: connection.Disconnected, ErrorCode: errcodes.EdgeDialTimeout}, now)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"text": "connection 1 disconnected (ERR_EDGE_DIAL_TIMEOUT)"}`, string(payload))
+}
+
+func TestWebhookRetries(t *testing.T) {
+	var lock sync.Mutex
+	var attempts int
+	received := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(r.Body)
+		received <- body
+	}))
+	defer server.Close()
+
+	sink := newTestSink(t, server.URL+"/events", "")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sink.Run(ctx)
+	sink.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Reconnecting})
+
+	select {
+	case body := <-received:
+		assert.Contains(t, string(body), `"event":"reconnecting"`)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not delivered")
+	}
+	lock.Lock()
+	assert.Equal(t, 3, attempts)
+	lock.Unlock()
+}
+
+func TestKafkaRecords(t *testing.T) {
+	type request struct {
+		path, contentType string
+		body              []byte
+	}
+	requests := make(chan request, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{path: r.URL.Path, contentType: r.Header.Get("Content-Type"), body: body}
+	}))
+	defer server.Close()
+
+	publisher, err := newKafkaPublisher(mustParseURL(t, strings.Replace(server.URL, "http://", "kafka+http://", 1)+"/tunnel-events"))
+	require.NoError(t, err)
+	require.NoError(t, publisher.publish(context.Background(), []byte(`{"event":"connected"}`)))
+	require.NoError(t, publisher.publish(context.Background(), []byte("connection 0 connected")))
+
+	req := <-requests
+	assert.Equal(t, "/topics/tunnel-events", req.path)
+	assert.Equal(t, kafkaJSONContentType, req.contentType)
+	assert.JSONEq(t, `{"records":[{"value":{"event":"connected"}}]}`, string(req.body))
+	req = <-requests
+	assert.Equal(t, kafkaBinaryContentType, req.contentType)
+	assert.JSONEq(t, `{"records":[{"value":"Y29ubmVjdGlvbiAwIGNvbm5lY3RlZA=="}]}`, string(req.body))
+}
+
+// serveNATS accepts a connection, expecting the CONNECT options then the publications, which are sent to published.
+func serveNATS(t *testing.T, listener net.Listener, published chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	_, _ = conn.Write([]byte(`INFO {"server_id":"test","max_payload":1048576}` + "\r\n"))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "CONNECT "):
+			var options natsConnectOptions
+			assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &options))
+			assert.Equal(t, "cloudflared", options.User)
+			assert.Equal(t, "secret", options.Pass)
+		case strings.HasPrefix(line, "PUB "):
+			var subject string
+			var size int
+			_, err := fmt.Sscanf(line, "PUB %s %d", &subject, &size)
+			assert.NoError(t, err)
+			payload := make([]byte, size+2)
+			_, err = io.ReadFull(reader, payload)
+			assert.NoError(t, err)
+			published <- subject + " " + string(payload[:size])
+		case line == "PING\r\n":
+			_, _ = conn.Write([]byte("PONG\r\n"))
+		}
+	}
+}
+
+func TestNATSPublish(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	published := make(chan string, 2)
+	go serveNATS(t, listener, published)
+
+	publisher, err := newNATSPublisher(mustParseURL(t, "nats://cloudflared:secret@"+listener.Addr().String()+"/tunnel.events"))
+	require.NoError(t, err)
+	defer publisher.close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, publisher.publish(ctx, []byte(`{"event":"connected"}`)))
+	require.NoError(t, publisher.publish(ctx, []byte(`{"event":"disconnected"}`)))
+	assert.Equal(t, `tunnel.events {"event":"connected"}`, <-published)
+	assert.Equal(t, `tunnel.events {"event":"disconnected"}`, <-published)
+}
+
+func TestOnTunnelEventDoesntBlock(t *testing.T) {
+	sink := newTestSink(t, "https://example.com/events", "")
+	// Nothing publishes the events, the queue fills up and the next events are dropped
+	for i := 0; i < queueSize+10; i++ {
+		sink.OnTunnelEvent(connection.Event{EventType: connection.Connected})
+	}
+	assert.Len(t, sink.events, queueSize)
+}
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	return u
+}
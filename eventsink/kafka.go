@@ -0,0 +1,64 @@
+package eventsink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	kafkaJSONContentType   = "application/vnd.kafka.json.v2+json"
+	kafkaBinaryContentType = "application/vnd.kafka.binary.v2+json"
+)
+
+// kafkaPublisher produces the payloads to a Kafka topic through a Kafka REST proxy, since speaking the Kafka protocol
+// takes a full client. JSON payloads are produced as JSON records, others as binary records.
+type kafkaPublisher struct {
+	webhook *webhookPublisher
+}
+
+func newKafkaPublisher(u *url.URL) (*kafkaPublisher, error) {
+	topic := strings.Trim(u.Path, "/")
+	if u.Host == "" || topic == "" || strings.Contains(topic, "/") {
+		return nil, fmt.Errorf("kafka event sink %s must be in the form kafka+http(s)://<REST proxy>/<topic>", u.Redacted())
+	}
+	proxyURL := *u
+	proxyURL.Scheme = strings.TrimPrefix(u.Scheme, "kafka+")
+	proxyURL.Path = "/topics/" + url.PathEscape(topic)
+	webhook, err := newWebhookPublisher(&proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaPublisher{webhook: webhook}, nil
+}
+
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaRecord struct {
+	Value any `json:"value"`
+}
+
+func (p *kafkaPublisher) publish(ctx context.Context, payload []byte) error {
+	contentType := kafkaJSONContentType
+	var value any = json.RawMessage(payload)
+	if !json.Valid(payload) {
+		// Binary records are base64 encoded, which encoding/json does for byte slices
+		contentType = kafkaBinaryContentType
+		value = payload
+	}
+	body, err := json.Marshal(kafkaRecords{Records: []kafkaRecord{{Value: value}}})
+	if err != nil {
+		return err
+	}
+	webhook := *p.webhook
+	webhook.contentType = contentType
+	return webhook.publish(ctx, body)
+}
+
+func (p *kafkaPublisher) close() {
+	p.webhook.close()
+}
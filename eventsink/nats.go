@@ -0,0 +1,160 @@
+package eventsink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const natsDefaultPort = "4222"
+
+// natsPublisher publishes the payloads to a NATS subject with the core NATS text protocol, waiting for the PONG of a
+// PING after each publication to make sure the server processed it.
+type natsPublisher struct {
+	addr    string
+	subject string
+	user    *url.Userinfo
+
+	lock   sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newNATSPublisher(u *url.URL) (*natsPublisher, error) {
+	subject := strings.Trim(u.Path, "/")
+	if u.Hostname() == "" || subject == "" || strings.ContainsAny(subject, "/ \t\r\n") {
+		return nil, fmt.Errorf("NATS event sink %s must be in the form nats://[user:password@]<server>[:port]/<subject>", u.Redacted())
+	}
+	port := u.Port()
+	if port == "" {
+		port = natsDefaultPort
+	}
+	return &natsPublisher{addr: net.JoinHostPort(u.Hostname(), port), subject: subject, user: u.User}, nil
+}
+
+type natsConnectOptions struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+}
+
+type natsServerInfo struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+func (p *natsPublisher) publish(ctx context.Context, payload []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.conn == nil {
+		if err := p.connect(ctx); err != nil {
+			return err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = p.conn.SetDeadline(deadline)
+	}
+	err := p.roundTrip(fmt.Sprintf("PUB %s %d\r\n%s\r\nPING\r\n", p.subject, len(payload), payload))
+	if err != nil {
+		// The connection is in an unknown state, the next publication reconnects
+		p.closeConn()
+	}
+	return err
+}
+
+func (p *natsPublisher) connect(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Now().Add(publishTimeout))
+	}
+	p.conn = conn
+	p.reader = bufio.NewReader(conn)
+
+	line, err := p.reader.ReadString('\n')
+	if err != nil {
+		p.closeConn()
+		return fmt.Errorf("failed to read the NATS server info: %w", err)
+	}
+	var info natsServerInfo
+	if !strings.HasPrefix(line, "INFO ") || json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info) != nil {
+		p.closeConn()
+		return fmt.Errorf("unexpected NATS server greeting %q", strings.TrimSpace(line))
+	}
+	if info.TLSRequired {
+		p.closeConn()
+		return fmt.Errorf("NATS server %s requires TLS, which isn't supported", p.addr)
+	}
+
+	options := natsConnectOptions{Name: "cloudflared"}
+	if p.user != nil {
+		if password, ok := p.user.Password(); ok {
+			options.User, options.Pass = p.user.Username(), password
+		} else {
+			options.Token = p.user.Username()
+		}
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		p.closeConn()
+		return err
+	}
+	if err := p.roundTrip(fmt.Sprintf("CONNECT %s\r\nPING\r\n", connect)); err != nil {
+		p.closeConn()
+		return err
+	}
+	return nil
+}
+
+// roundTrip writes commands ending with a PING and waits for its PONG. Errors of the server, e.g. authorization
+// violations, come before it.
+func (p *natsPublisher) roundTrip(commands string) error {
+	if _, err := p.conn.Write([]byte(commands)); err != nil {
+		return err
+	}
+	for {
+		line, err := p.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := p.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// +OK and INFO updates are ignored
+	}
+}
+
+func (p *natsPublisher) closeConn() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+		p.reader = nil
+	}
+}
+
+func (p *natsPublisher) close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.closeConn()
+}
@@ -0,0 +1,47 @@
+package eventsink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// webhookPublisher posts the payloads to an HTTP endpoint.
+type webhookPublisher struct {
+	url         string
+	contentType string
+	client      *http.Client
+}
+
+func newWebhookPublisher(u *url.URL) (*webhookPublisher, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("webhook URL %s has no host", u.Redacted())
+	}
+	return &webhookPublisher{url: u.String(), contentType: "application/json", client: &http.Client{}}, nil
+}
+
+func (p *webhookPublisher) publish(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", p.contentType)
+	req.Header.Set("User-Agent", "cloudflared")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s answered %s", req.URL.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+func (p *webhookPublisher) close() {
+	p.client.CloseIdleConnections()
+}
@@ -0,0 +1,46 @@
+package features
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (m PostQuantumMode) String() string {
+	switch m {
+	case PostQuantumPrefer:
+		return "prefer"
+	case PostQuantumStrict:
+		return "strict"
+	default:
+		return "unknown"
+	}
+}
+
+// ParsePostQuantumModes parses a comma separated list of post-quantum modes, each optionally prefixed with the
+// transport protocol it applies to: "strict" or "quic=strict,http2=prefer". Modes without protocol are keyed by
+// the empty string.
+func ParsePostQuantumModes(spec string) (map[string]PostQuantumMode, error) {
+	modes := make(map[string]PostQuantumMode)
+	if strings.TrimSpace(spec) == "" {
+		return modes, nil
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		protocol, value, found := strings.Cut(entry, "=")
+		if !found {
+			protocol, value = "", protocol
+		}
+		protocol = strings.ToLower(strings.TrimSpace(protocol))
+		if protocol != "" && protocol != "quic" && protocol != "http2" {
+			return nil, fmt.Errorf("invalid post-quantum protocol %q, expected quic or http2", protocol)
+		}
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "prefer":
+			modes[protocol] = PostQuantumPrefer
+		case "strict":
+			modes[protocol] = PostQuantumStrict
+		default:
+			return nil, fmt.Errorf("invalid post-quantum mode %q, expected prefer or strict", value)
+		}
+	}
+	return modes, nil
+}
@@ -0,0 +1,27 @@
+package features
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePostQuantumModes(t *testing.T) {
+	modes, err := ParsePostQuantumModes("")
+	require.NoError(t, err)
+	assert.Empty(t, modes)
+
+	modes, err = ParsePostQuantumModes("strict")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]PostQuantumMode{"": PostQuantumStrict}, modes)
+
+	modes, err = ParsePostQuantumModes("QUIC=strict, http2=prefer")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]PostQuantumMode{"quic": PostQuantumStrict, "http2": PostQuantumPrefer}, modes)
+
+	for _, spec := range []string{"always", "tcp=strict", "quic="} {
+		_, err := ParsePostQuantumModes(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,178 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// Kinds of in-flight flows
+	KindHTTP = "http"
+	KindTCP  = "tcp"
+	KindUDP  = "udp"
+
+	drainProgressInterval = 5 * time.Second
+)
+
+// Active counts the HTTP requests, TCP streams and UDP sessions proxied by cloudflared, so that a graceful shutdown
+// can wait for them to finish.
+var Active = NewInFlight()
+
+// InFlight counts in-flight flows by kind.
+//
+// UDP sessions are counted, but don't hold a drain up: nothing tells they're done until their idle timeout, so they
+// are closed along with the connections instead.
+type InFlight struct {
+	lock   sync.Mutex
+	counts map[string]int64
+	// draining is the count of the flows that hold a drain up
+	draining int64
+	// drainedC is closed when the count of flows holding a drain up goes back to 0
+	drainedC chan struct{}
+}
+
+func NewInFlight() *InFlight {
+	drainedC := make(chan struct{})
+	close(drainedC)
+	return &InFlight{
+		counts:   make(map[string]int64),
+		drainedC: drainedC,
+	}
+}
+
+// Begin counts a new flow of kind. It must be followed by End once the flow is done.
+func (f *InFlight) Begin(kind string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.counts[kind]++
+	if !holdsDrain(kind) {
+		return
+	}
+	if f.draining == 0 {
+		f.drainedC = make(chan struct{})
+	}
+	f.draining++
+}
+
+// End counts a flow of kind as done.
+func (f *InFlight) End(kind string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.counts[kind] <= 0 {
+		return
+	}
+	f.counts[kind]--
+	if !holdsDrain(kind) {
+		return
+	}
+	f.draining--
+	if f.draining == 0 {
+		close(f.drainedC)
+	}
+}
+
+func holdsDrain(kind string) bool {
+	return kind != KindUDP
+}
+
+// Counts returns the number of in-flight flows by kind, omitting the kinds without any.
+func (f *InFlight) Counts() map[string]int64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	counts := make(map[string]int64, len(f.counts))
+	for kind, count := range f.counts {
+		if count > 0 {
+			counts[kind] = count
+		}
+	}
+	return counts
+}
+
+// Draining returns the counts of the in-flight flows holding a drain up, by kind.
+func (f *InFlight) Draining() map[string]int64 {
+	counts := f.Counts()
+	for kind := range counts {
+		if !holdsDrain(kind) {
+			delete(counts, kind)
+		}
+	}
+	return counts
+}
+
+// Wait blocks until there are no flows holding a drain up in flight, or ctx is done.
+func (f *InFlight) Wait(ctx context.Context) error {
+	f.lock.Lock()
+	drainedC := f.drainedC
+	f.lock.Unlock()
+	select {
+	case <-drainedC:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Drain waits for the in-flight flows holding a drain up to finish until ctx is done, logging the progress. It
+// returns the counts of those still in flight, which are about to be terminated.
+func (f *InFlight) Drain(ctx context.Context, log *zerolog.Logger) map[string]int64 {
+	ticker := time.NewTicker(drainProgressInterval)
+	defer ticker.Stop()
+	waitC := make(chan error, 1)
+	go func() {
+		waitC <- f.Wait(ctx)
+	}()
+	if counts := f.Draining(); len(counts) > 0 {
+		log.Info().Msgf("Waiting for %s to finish", FormatCounts(counts))
+	}
+	for {
+		select {
+		case <-waitC:
+			return f.Draining()
+		case <-ticker.C:
+			if counts := f.Draining(); len(counts) > 0 {
+				log.Info().Msgf("Still waiting for %s to finish", FormatCounts(counts))
+			}
+		}
+	}
+}
+
+// FormatCounts describes counts of flows by kind, e.g. "3 HTTP requests and 1 UDP session".
+func FormatCounts(counts map[string]int64) string {
+	var parts []string
+	for _, kind := range []string{KindHTTP, KindTCP, KindUDP} {
+		if count := counts[kind]; count > 0 {
+			parts = append(parts, formatCount(count, kind))
+		}
+	}
+	switch len(parts) {
+	case 0:
+		return "no flows"
+	case 1:
+		return parts[0]
+	default:
+		return strings.Join(parts[:len(parts)-1], ", ") + " and " + parts[len(parts)-1]
+	}
+}
+
+func formatCount(count int64, kind string) string {
+	var name string
+	switch kind {
+	case KindHTTP:
+		name = "HTTP request"
+	case KindTCP:
+		name = "TCP stream"
+	case KindUDP:
+		name = "UDP session"
+	default:
+		name = kind + " flow"
+	}
+	if count != 1 {
+		name += "s"
+	}
+	return fmt.Sprintf("%d %s", count, name)
+}
@@ -0,0 +1,70 @@
+package flow_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/flow"
+)
+
+func TestInFlight_Wait(t *testing.T) {
+	inFlight := flow.NewInFlight()
+	require.NoError(t, inFlight.Wait(context.Background()))
+
+	inFlight.Begin(flow.KindHTTP)
+	inFlight.Begin(flow.KindUDP)
+	require.Equal(t, map[string]int64{flow.KindHTTP: 1, flow.KindUDP: 1}, inFlight.Counts())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, inFlight.Wait(ctx), context.DeadlineExceeded)
+
+	waitC := make(chan error)
+	go func() {
+		waitC <- inFlight.Wait(context.Background())
+	}()
+	// The UDP sessions don't hold the wait up
+	inFlight.End(flow.KindHTTP)
+	require.NoError(t, <-waitC)
+	require.Equal(t, map[string]int64{flow.KindUDP: 1}, inFlight.Counts())
+	inFlight.End(flow.KindUDP)
+	require.Empty(t, inFlight.Counts())
+
+	// Ending a flow that didn't begin doesn't make the count negative
+	inFlight.End(flow.KindTCP)
+	inFlight.Begin(flow.KindTCP)
+	require.Equal(t, map[string]int64{flow.KindTCP: 1}, inFlight.Counts())
+}
+
+func TestInFlight_Drain(t *testing.T) {
+	log := zerolog.Nop()
+	inFlight := flow.NewInFlight()
+	inFlight.Begin(flow.KindHTTP)
+	inFlight.Begin(flow.KindHTTP)
+	inFlight.Begin(flow.KindTCP)
+	inFlight.Begin(flow.KindUDP)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		inFlight.End(flow.KindHTTP)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	remaining := inFlight.Drain(ctx, &log)
+	require.Equal(t, map[string]int64{flow.KindHTTP: 1, flow.KindTCP: 1}, remaining)
+
+	inFlight.End(flow.KindHTTP)
+	inFlight.End(flow.KindTCP)
+	require.Empty(t, inFlight.Drain(context.Background(), &log))
+}
+
+func TestFormatCounts(t *testing.T) {
+	require.Equal(t, "no flows", flow.FormatCounts(nil))
+	require.Equal(t, "1 HTTP request", flow.FormatCounts(map[string]int64{flow.KindHTTP: 1}))
+	require.Equal(t, "3 HTTP requests, 1 TCP stream and 2 UDP sessions",
+		flow.FormatCounts(map[string]int64{flow.KindUDP: 2, flow.KindHTTP: 3, flow.KindTCP: 1}))
+}
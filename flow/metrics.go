@@ -7,6 +7,9 @@ import (
 
 const (
 	namespace = "flow"
+
+	directionToOrigin   = "to_origin"
+	directionFromOrigin = "from_origin"
 )
 
 var (
@@ -20,4 +23,29 @@ var (
 	},
 		labels,
 	)
+
+	flowsClosed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "records",
+		Name:      "closed_total",
+		Help:      "Count of the flows proxied to origins that were closed, by close reason",
+	},
+		[]string{"flow_type", "close_reason"},
+	)
+	flowBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "records",
+		Name:      "bytes_total",
+		Help:      "Count of the bytes proxied by the closed flows, by direction",
+	},
+		[]string{"flow_type", "direction"},
+	)
+	flowPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "records",
+		Name:      "packets_total",
+		Help:      "Count of the packets proxied by the closed flows, by direction",
+	},
+		[]string{"flow_type", "direction"},
+	)
 )
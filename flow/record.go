@@ -0,0 +1,97 @@
+package flow
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Reasons a flow was closed for
+	CloseIdle       = "idle"
+	CloseByEdge     = "closed_by_edge"
+	CloseByOrigin   = "closed_by_origin"
+	CloseConnection = "connection_closed"
+	CloseTerminated = "terminated"
+	// CloseCompleted is the reason of the streams that ended without an error
+	CloseCompleted = "completed"
+	CloseError     = "error"
+)
+
+// Record describes a flow proxied to an origin once it's closed, for auditing which flows traversed cloudflared.
+type Record struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+	// Version is the version of the protocol carrying the flow, e.g. the datagram version of UDP sessions.
+	Version string `json:"version,omitempty"`
+	// Src is the local address of the socket the flow was proxied from.
+	Src string `json:"src,omitempty"`
+	Dst string `json:"dst"`
+	// Route is how the destination was chosen: the index of the matched ingress rule, or warp-routing for the flows
+	// to private networks.
+	Route             string        `json:"route,omitempty"`
+	Start             time.Time     `json:"start"`
+	Duration          time.Duration `json:"duration"`
+	BytesToOrigin     uint64        `json:"bytesToOrigin"`
+	BytesFromOrigin   uint64        `json:"bytesFromOrigin"`
+	PacketsToOrigin   uint64        `json:"packetsToOrigin,omitempty"`
+	PacketsFromOrigin uint64        `json:"packetsFromOrigin,omitempty"`
+	CloseReason       string        `json:"closeReason"`
+	ConnIndex         uint8         `json:"connIndex"`
+}
+
+// Counters counts the traffic of a flow. It's safe for concurrent use.
+type Counters struct {
+	bytesToOrigin     atomic.Uint64
+	bytesFromOrigin   atomic.Uint64
+	packetsToOrigin   atomic.Uint64
+	packetsFromOrigin atomic.Uint64
+}
+
+// ToOrigin counts a packet, or a write of a stream, of n bytes sent to the origin.
+func (c *Counters) ToOrigin(n int) {
+	c.bytesToOrigin.Add(uint64(n))
+	c.packetsToOrigin.Add(1)
+}
+
+// FromOrigin counts a packet, or a read of a stream, of n bytes received from the origin.
+func (c *Counters) FromOrigin(n int) {
+	c.bytesFromOrigin.Add(uint64(n))
+	c.packetsFromOrigin.Add(1)
+}
+
+// Fill sets the traffic of record to the counts.
+func (c *Counters) Fill(record *Record) {
+	record.BytesToOrigin = c.bytesToOrigin.Load()
+	record.BytesFromOrigin = c.bytesFromOrigin.Load()
+	record.PacketsToOrigin = c.packetsToOrigin.Load()
+	record.PacketsFromOrigin = c.packetsFromOrigin.Load()
+}
+
+var (
+	recorderLock sync.RWMutex
+	recorder     func(Record)
+)
+
+// SetRecorder sets the function the flow records are emitted to, e.g. an access log. nil discards the records.
+func SetRecorder(r func(Record)) {
+	recorderLock.Lock()
+	defer recorderLock.Unlock()
+	recorder = r
+}
+
+// Emit counts a closed flow in the metrics and sends its record to the recorder, if any.
+func Emit(record Record) {
+	flowsClosed.WithLabelValues(record.Kind, record.CloseReason).Inc()
+	flowBytes.WithLabelValues(record.Kind, directionToOrigin).Add(float64(record.BytesToOrigin))
+	flowBytes.WithLabelValues(record.Kind, directionFromOrigin).Add(float64(record.BytesFromOrigin))
+	flowPackets.WithLabelValues(record.Kind, directionToOrigin).Add(float64(record.PacketsToOrigin))
+	flowPackets.WithLabelValues(record.Kind, directionFromOrigin).Add(float64(record.PacketsFromOrigin))
+
+	recorderLock.RLock()
+	r := recorder
+	recorderLock.RUnlock()
+	if r != nil {
+		r(record)
+	}
+}
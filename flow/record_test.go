@@ -0,0 +1,43 @@
+package flow_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/flow"
+)
+
+func TestEmitRecord(t *testing.T) {
+	var counters flow.Counters
+	counters.ToOrigin(10)
+	counters.ToOrigin(5)
+	counters.FromOrigin(100)
+
+	record := flow.Record{
+		Kind:        flow.KindUDP,
+		ID:          "1",
+		Src:         "127.0.0.1:4000",
+		Dst:         "10.0.0.1:53",
+		Start:       time.Now(),
+		Duration:    time.Second,
+		CloseReason: flow.CloseIdle,
+	}
+	counters.Fill(&record)
+	require.Equal(t, uint64(15), record.BytesToOrigin)
+	require.Equal(t, uint64(2), record.PacketsToOrigin)
+	require.Equal(t, uint64(100), record.BytesFromOrigin)
+	require.Equal(t, uint64(1), record.PacketsFromOrigin)
+
+	// Records are discarded without a recorder
+	flow.Emit(record)
+
+	var emitted []flow.Record
+	flow.SetRecorder(func(r flow.Record) {
+		emitted = append(emitted, r)
+	})
+	defer flow.SetRecorder(nil)
+	flow.Emit(record)
+	require.Equal(t, []flow.Record{record}, emitted)
+}
@@ -49,6 +49,7 @@ type WarpRoutingConfig struct {
 	ConnectTimeout config.CustomDuration `yaml:"connectTimeout" json:"connectTimeout,omitempty"`
 	MaxActiveFlows uint64                `yaml:"maxActiveFlows" json:"MaxActiveFlows,omitempty"`
 	TCPKeepAlive   config.CustomDuration `yaml:"tcpKeepAlive" json:"tcpKeepAlive,omitempty"`
+	QoS            []QoSRule             `yaml:"qos" json:"qos,omitempty"`
 }
 
 func NewWarpRoutingConfig(raw *config.WarpRoutingConfig) WarpRoutingConfig {
@@ -66,6 +67,10 @@ func NewWarpRoutingConfig(raw *config.WarpRoutingConfig) WarpRoutingConfig {
 	if raw.TCPKeepAlive != nil {
 		cfg.TCPKeepAlive = *raw.TCPKeepAlive
 	}
+	// Invalid rules are rejected by ValidateQoSRules before the configuration is applied
+	if qos, err := parseQoSRules(raw.QoS); err == nil && len(qos) > 0 {
+		cfg.QoS = qos
+	}
 	return cfg
 }
 
@@ -80,6 +85,9 @@ func (c *WarpRoutingConfig) RawConfig() config.WarpRoutingConfig {
 	if c.TCPKeepAlive.Duration != defaultTCPKeepAlive.Duration {
 		raw.TCPKeepAlive = &c.TCPKeepAlive
 	}
+	for _, rule := range c.QoS {
+		raw.QoS = append(raw.QoS, config.QoSRule{Network: rule.Network.String(), Class: rule.Class.String()})
+	}
 	return raw
 }
 
@@ -112,6 +120,9 @@ func (rc *RemoteConfig) UnmarshalJSON(b []byte) error {
 	if err != nil {
 		return err
 	}
+	if err := ValidateQoSRules(rawConfig.WarpRouting.QoS); err != nil {
+		return err
+	}
 
 	rc.Ingress = ingress
 	rc.WarpRouting = NewWarpRoutingConfig(&rawConfig.WarpRouting)
@@ -269,6 +280,24 @@ func originRequestFromConfig(c config.OriginRequestConfig) OriginRequestConfig {
 	if c.ProxyType != nil {
 		out.ProxyType = *c.ProxyType
 	}
+	if c.Dialer != nil {
+		out.Dialer = *c.Dialer
+	}
+	if c.SSHJump != nil {
+		out.SSHJump = *c.SSHJump
+	}
+	if c.SPNEGO != nil {
+		out.SPNEGO = *c.SPNEGO
+	}
+	if c.NTLMAffinity != nil {
+		out.NTLMAffinity = *c.NTLMAffinity
+	}
+	if c.TrustOnFirstUse != nil {
+		out.TrustOnFirstUse = *c.TrustOnFirstUse
+	}
+	if c.Priority != nil {
+		out.Priority = *c.Priority
+	}
 	if len(c.IPRules) > 0 {
 		for _, r := range c.IPRules {
 			rule, err := ipaccess.NewRuleByCIDR(r.Prefix, r.Ports, r.Allow)
@@ -283,6 +312,66 @@ func originRequestFromConfig(c config.OriginRequestConfig) OriginRequestConfig {
 	if c.Access != nil {
 		out.Access = *c.Access
 	}
+	if c.LoadBalancer != nil {
+		out.LoadBalancer = *c.LoadBalancer
+	}
+	if c.Canary != nil {
+		out.Canary = *c.Canary
+	}
+	if len(c.FingerprintHeaders) > 0 {
+		out.FingerprintHeaders = c.FingerprintHeaders
+	}
+	if c.Retry != nil {
+		out.Retry = *c.Retry
+	}
+	if c.Hedging != nil {
+		out.Hedging = *c.Hedging
+	}
+	if c.CircuitBreaker != nil {
+		out.CircuitBreaker = *c.CircuitBreaker
+	}
+	if c.Cache != nil {
+		out.Cache = *c.Cache
+	}
+	if c.Inspection != nil {
+		out.Inspection = *c.Inspection
+	}
+	if c.FastCGI != nil {
+		out.FastCGI = *c.FastCGI
+	}
+	if c.Static != nil {
+		out.Static = *c.Static
+	}
+	if c.Streaming != nil {
+		out.Streaming = *c.Streaming
+	}
+	if c.WebSocket != nil {
+		out.WebSocket = *c.WebSocket
+	}
+	if c.ProxyProtocol != nil {
+		out.ProxyProtocol = *c.ProxyProtocol
+	}
+	if c.UDP != nil {
+		out.UDP = *c.UDP
+	}
+	if c.IPAccess != nil {
+		out.IPAccess = *c.IPAccess
+	}
+	if c.RateLimit != nil {
+		out.RateLimit = *c.RateLimit
+	}
+	if c.OriginCompression != nil {
+		out.OriginCompression = *c.OriginCompression
+	}
+	if c.Maintenance != nil {
+		out.Maintenance = *c.Maintenance
+	}
+	if c.Mirror != nil {
+		out.Mirror = *c.Mirror
+	}
+	if c.RequestLimits != nil {
+		out.RequestLimits = *c.RequestLimits
+	}
 	return out
 }
 
@@ -333,6 +422,83 @@ type OriginRequestConfig struct {
 
 	// Access holds all access related configs
 	Access config.AccessConfig `yaml:"access" json:"access,omitempty"`
+
+	// LoadBalancer holds the origins and health checks of a load_balancer service
+	LoadBalancer config.LoadBalancerConfig `yaml:"loadBalancer" json:"loadBalancer,omitzero"`
+	// Canary holds the origins a canary service splits requests between
+	Canary config.CanaryConfig `yaml:"canary" json:"canary,omitzero"`
+
+	// Maps edge-provided client fingerprint names (e.g. ja3, ja4) to the request header used to forward them to the origin
+	FingerprintHeaders map[string]string `yaml:"fingerprintHeaders" json:"fingerprintHeaders,omitempty"`
+
+	// Retry configures retries of idempotent requests that failed to reach the origin
+	Retry config.RetryConfig `yaml:"retry" json:"retry,omitzero"`
+
+	// Hedging sends a second copy of idempotent requests the origin is slow to respond to
+	Hedging config.HedgingConfig `yaml:"hedging" json:"hedging,omitzero"`
+
+	// CircuitBreaker configures fast-failing requests while the origin is down
+	CircuitBreaker config.CircuitBreakerConfig `yaml:"circuitBreaker" json:"circuitBreaker,omitzero"`
+
+	// Cache configures caching of origin responses in cloudflared
+	Cache config.CacheConfig `yaml:"cache" json:"cache,omitzero"`
+
+	// Inspection sends the requests of the rule to registered inspectors
+	Inspection config.InspectionConfig `yaml:"inspection" json:"inspection,omitzero"`
+
+	// FastCGI configures the parameters sent to fastcgi:// origins
+	FastCGI config.FastCGIConfig `yaml:"fastcgi" json:"fastcgi,omitzero"`
+
+	// Static configures static file services
+	Static config.StaticConfig `yaml:"static" json:"static,omitzero"`
+
+	// Streaming configures how response bodies are buffered before being sent to the edge
+	Streaming config.StreamingConfig `yaml:"streaming" json:"streaming,omitzero"`
+
+	// WebSocket configures compression, size limits and idle timeout of websocket connections
+	WebSocket config.WebSocketConfig `yaml:"websocket" json:"websocket,omitzero"`
+
+	// ProxyProtocol prepends a PROXY protocol header carrying the eyeball address to tcp origin connections
+	ProxyProtocol config.ProxyProtocolConfig `yaml:"proxyProtocol" json:"proxyProtocol,omitzero"`
+
+	// UDP configures udp:// services
+	UDP config.UDPConfig `yaml:"udp" json:"udp,omitzero"`
+
+	// IPAccess restricts the eyeball IPs that can reach the origin
+	IPAccess config.IPAccessConfig `yaml:"ipAccess" json:"ipAccess,omitzero"`
+
+	// RateLimit throttles the requests proxied to the origin
+	RateLimit config.RateLimitConfig `yaml:"rateLimit" json:"rateLimit,omitzero"`
+
+	// OriginCompression compresses the bodies exchanged with origins that support it
+	OriginCompression config.OriginCompressionConfig `yaml:"originCompression" json:"originCompression,omitzero"`
+
+	// Maintenance serves a static response instead of proxying to the origin
+	Maintenance config.MaintenanceConfig `yaml:"maintenance" json:"maintenance,omitzero"`
+
+	// Mirror sends a copy of a share of the requests to a secondary origin
+	Mirror config.MirrorConfig `yaml:"mirror" json:"mirror,omitzero"`
+
+	// RequestLimits bounds the size of requests and the time their body takes to be received
+	RequestLimits config.RequestLimitsConfig `yaml:"requestLimits" json:"requestLimits,omitzero"`
+
+	// Dialer is the name of the registered dialer the origin is dialed with, see RegisterDialer
+	Dialer string `yaml:"dialer" json:"dialer,omitempty"`
+
+	// SSHJump dials the origin through an SSH jump host
+	SSHJump config.SSHJumpConfig `yaml:"sshJump" json:"sshJump,omitzero"`
+
+	// SPNEGO authenticates the requests to origins with Windows integrated authentication
+	SPNEGO config.SPNEGOConfig `yaml:"spnego" json:"spnego,omitzero"`
+
+	// NTLMAffinity pins the legs of the NTLM handshakes of an eyeball to a single origin connection
+	NTLMAffinity bool `yaml:"ntlmAffinity" json:"ntlmAffinity,omitempty"`
+
+	// TrustOnFirstUse pins the certificate presented by the origin on the first connection
+	TrustOnFirstUse config.TrustOnFirstUseConfig `yaml:"trustOnFirstUse" json:"trustOnFirstUse,omitzero"`
+
+	// Priority is normal, or low for the requests to be answered with 503 while the connector sheds load
+	Priority string `yaml:"priority" json:"priority,omitempty"`
 }
 
 func (defaults *OriginRequestConfig) setConnectTimeout(overrides config.OriginRequestConfig) {
@@ -456,6 +622,162 @@ func (defaults *OriginRequestConfig) setAccess(overrides config.OriginRequestCon
 	}
 }
 
+func (defaults *OriginRequestConfig) setLoadBalancer(overrides config.OriginRequestConfig) {
+	if val := overrides.LoadBalancer; val != nil {
+		defaults.LoadBalancer = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setCanary(overrides config.OriginRequestConfig) {
+	if val := overrides.Canary; val != nil {
+		defaults.Canary = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setFingerprintHeaders(overrides config.OriginRequestConfig) {
+	if val := overrides.FingerprintHeaders; len(val) > 0 {
+		defaults.FingerprintHeaders = val
+	}
+}
+
+func (defaults *OriginRequestConfig) setRetry(overrides config.OriginRequestConfig) {
+	if val := overrides.Retry; val != nil {
+		defaults.Retry = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setHedging(overrides config.OriginRequestConfig) {
+	if val := overrides.Hedging; val != nil {
+		defaults.Hedging = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setCircuitBreaker(overrides config.OriginRequestConfig) {
+	if val := overrides.CircuitBreaker; val != nil {
+		defaults.CircuitBreaker = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setCache(overrides config.OriginRequestConfig) {
+	if val := overrides.Cache; val != nil {
+		defaults.Cache = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setInspection(overrides config.OriginRequestConfig) {
+	if val := overrides.Inspection; val != nil {
+		defaults.Inspection = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setFastCGI(overrides config.OriginRequestConfig) {
+	if val := overrides.FastCGI; val != nil {
+		defaults.FastCGI = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setStatic(overrides config.OriginRequestConfig) {
+	if val := overrides.Static; val != nil {
+		defaults.Static = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setStreaming(overrides config.OriginRequestConfig) {
+	if val := overrides.Streaming; val != nil {
+		defaults.Streaming = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setWebSocket(overrides config.OriginRequestConfig) {
+	if val := overrides.WebSocket; val != nil {
+		defaults.WebSocket = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setProxyProtocol(overrides config.OriginRequestConfig) {
+	if val := overrides.ProxyProtocol; val != nil {
+		defaults.ProxyProtocol = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setUDP(overrides config.OriginRequestConfig) {
+	if val := overrides.UDP; val != nil {
+		defaults.UDP = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setIPAccess(overrides config.OriginRequestConfig) {
+	if val := overrides.IPAccess; val != nil {
+		defaults.IPAccess = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setRateLimit(overrides config.OriginRequestConfig) {
+	if val := overrides.RateLimit; val != nil {
+		defaults.RateLimit = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setOriginCompression(overrides config.OriginRequestConfig) {
+	if val := overrides.OriginCompression; val != nil {
+		defaults.OriginCompression = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setMaintenance(overrides config.OriginRequestConfig) {
+	if val := overrides.Maintenance; val != nil {
+		defaults.Maintenance = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setMirror(overrides config.OriginRequestConfig) {
+	if val := overrides.Mirror; val != nil {
+		defaults.Mirror = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setRequestLimits(overrides config.OriginRequestConfig) {
+	if val := overrides.RequestLimits; val != nil {
+		defaults.RequestLimits = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setDialer(overrides config.OriginRequestConfig) {
+	if val := overrides.Dialer; val != nil {
+		defaults.Dialer = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setSSHJump(overrides config.OriginRequestConfig) {
+	if val := overrides.SSHJump; val != nil {
+		defaults.SSHJump = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setSPNEGO(overrides config.OriginRequestConfig) {
+	if val := overrides.SPNEGO; val != nil {
+		defaults.SPNEGO = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setNTLMAffinity(overrides config.OriginRequestConfig) {
+	if val := overrides.NTLMAffinity; val != nil {
+		defaults.NTLMAffinity = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setPriority(overrides config.OriginRequestConfig) {
+	if val := overrides.Priority; val != nil {
+		defaults.Priority = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setTrustOnFirstUse(overrides config.OriginRequestConfig) {
+	if val := overrides.TrustOnFirstUse; val != nil {
+		defaults.TrustOnFirstUse = *val
+	}
+}
+
 // SetConfig gets config for the requests that cloudflared sends to origins.
 // Each field has a setter method which sets a value for the field by trying to find:
 //  1. The user config for this rule
@@ -485,6 +807,32 @@ func setConfig(defaults OriginRequestConfig, overrides config.OriginRequestConfi
 	cfg.setIPRules(overrides)
 	cfg.setHttp2Origin(overrides)
 	cfg.setAccess(overrides)
+	cfg.setLoadBalancer(overrides)
+	cfg.setCanary(overrides)
+	cfg.setFingerprintHeaders(overrides)
+	cfg.setRetry(overrides)
+	cfg.setHedging(overrides)
+	cfg.setCircuitBreaker(overrides)
+	cfg.setCache(overrides)
+	cfg.setInspection(overrides)
+	cfg.setFastCGI(overrides)
+	cfg.setStatic(overrides)
+	cfg.setStreaming(overrides)
+	cfg.setWebSocket(overrides)
+	cfg.setProxyProtocol(overrides)
+	cfg.setUDP(overrides)
+	cfg.setIPAccess(overrides)
+	cfg.setRateLimit(overrides)
+	cfg.setOriginCompression(overrides)
+	cfg.setMaintenance(overrides)
+	cfg.setMirror(overrides)
+	cfg.setRequestLimits(overrides)
+	cfg.setDialer(overrides)
+	cfg.setSSHJump(overrides)
+	cfg.setSPNEGO(overrides)
+	cfg.setNTLMAffinity(overrides)
+	cfg.setTrustOnFirstUse(overrides)
+	cfg.setPriority(overrides)
 
 	return cfg
 }
@@ -497,6 +845,28 @@ func ConvertToRawOriginConfig(c OriginRequestConfig) config.OriginRequestConfig
 	var keepAliveTimeout *config.CustomDuration
 	var proxyAddress *string
 	var access *config.AccessConfig
+	var loadBalancer *config.LoadBalancerConfig
+	var canary *config.CanaryConfig
+	var retry *config.RetryConfig
+	var hedging *config.HedgingConfig
+	var circuitBreaker *config.CircuitBreakerConfig
+	var cache *config.CacheConfig
+	var inspection *config.InspectionConfig
+	var fastCGI *config.FastCGIConfig
+	var static *config.StaticConfig
+	var streaming *config.StreamingConfig
+	var webSocket *config.WebSocketConfig
+	var proxyProtocol *config.ProxyProtocolConfig
+	var udp *config.UDPConfig
+	var ipAccess *config.IPAccessConfig
+	var rateLimit *config.RateLimitConfig
+	var originCompression *config.OriginCompressionConfig
+	var maintenance *config.MaintenanceConfig
+	var mirror *config.MirrorConfig
+	var requestLimits *config.RequestLimitsConfig
+	var sshJump *config.SSHJumpConfig
+	var spnego *config.SPNEGOConfig
+	var trustOnFirstUse *config.TrustOnFirstUseConfig
 
 	if c.ConnectTimeout != defaultHTTPConnectTimeout {
 		connectTimeout = &c.ConnectTimeout
@@ -519,6 +889,72 @@ func ConvertToRawOriginConfig(c OriginRequestConfig) config.OriginRequestConfig
 	if c.Access.Required {
 		access = &c.Access
 	}
+	if len(c.LoadBalancer.Origins) > 0 {
+		loadBalancer = &c.LoadBalancer
+	}
+	if c.Canary.Primary != "" || c.Canary.Canary != "" {
+		canary = &c.Canary
+	}
+	if c.Retry.MaxRetries > 0 {
+		retry = &c.Retry
+	}
+	if c.Hedging.Delay.Duration > 0 {
+		hedging = &c.Hedging
+	}
+	if c.CircuitBreaker.FailureThreshold > 0 {
+		circuitBreaker = &c.CircuitBreaker
+	}
+	if c.Cache.Enabled {
+		cache = &c.Cache
+	}
+	if len(c.Inspection.Inspectors) > 0 {
+		inspection = &c.Inspection
+	}
+	if c.FastCGI.DocumentRoot != "" || c.FastCGI.ScriptFilename != "" || c.FastCGI.Index != "" || len(c.FastCGI.Params) > 0 {
+		fastCGI = &c.FastCGI
+	}
+	if len(c.Static.IndexFiles) > 0 || c.Static.DirectoryListing || c.Static.DisableRanges || c.Static.Compression || c.Static.Dotfiles != "" {
+		static = &c.Static
+	}
+	if c.Streaming != (config.StreamingConfig{}) {
+		streaming = &c.Streaming
+	}
+	if c.WebSocket != (config.WebSocketConfig{}) {
+		webSocket = &c.WebSocket
+	}
+	if c.ProxyProtocol.Version != "" {
+		proxyProtocol = &c.ProxyProtocol
+	}
+	if c.UDP != (config.UDPConfig{}) {
+		udp = &c.UDP
+	}
+	if len(c.IPAccess.Allow) > 0 || len(c.IPAccess.Deny) > 0 {
+		ipAccess = &c.IPAccess
+	}
+	if c.RateLimit.RequestsPerSecond > 0 {
+		rateLimit = &c.RateLimit
+	}
+	if len(c.OriginCompression.Encodings) > 0 {
+		originCompression = &c.OriginCompression
+	}
+	if c.Maintenance != (config.MaintenanceConfig{}) {
+		maintenance = &c.Maintenance
+	}
+	if c.Mirror.Origin != "" {
+		mirror = &c.Mirror
+	}
+	if c.RequestLimits != (config.RequestLimitsConfig{}) {
+		requestLimits = &c.RequestLimits
+	}
+	if c.SSHJump != (config.SSHJumpConfig{}) {
+		sshJump = &c.SSHJump
+	}
+	if c.SPNEGO.Enabled() {
+		spnego = &c.SPNEGO
+	}
+	if c.TrustOnFirstUse != (config.TrustOnFirstUseConfig{}) {
+		trustOnFirstUse = &c.TrustOnFirstUse
+	}
 
 	return config.OriginRequestConfig{
 		ConnectTimeout:         connectTimeout,
@@ -540,6 +976,32 @@ func ConvertToRawOriginConfig(c OriginRequestConfig) config.OriginRequestConfig
 		IPRules:                convertToRawIPRules(c.IPRules),
 		Http2Origin:            defaultBoolToNil(c.Http2Origin),
 		Access:                 access,
+		LoadBalancer:           loadBalancer,
+		Canary:                 canary,
+		FingerprintHeaders:     c.FingerprintHeaders,
+		Retry:                  retry,
+		Hedging:                hedging,
+		CircuitBreaker:         circuitBreaker,
+		Cache:                  cache,
+		Inspection:             inspection,
+		FastCGI:                fastCGI,
+		Static:                 static,
+		Streaming:              streaming,
+		WebSocket:              webSocket,
+		ProxyProtocol:          proxyProtocol,
+		UDP:                    udp,
+		IPAccess:               ipAccess,
+		RateLimit:              rateLimit,
+		OriginCompression:      originCompression,
+		Maintenance:            maintenance,
+		Mirror:                 mirror,
+		RequestLimits:          requestLimits,
+		Dialer:                 emptyStringToNil(c.Dialer),
+		SSHJump:                sshJump,
+		SPNEGO:                 spnego,
+		NTLMAffinity:           defaultBoolToNil(c.NTLMAffinity),
+		TrustOnFirstUse:        trustOnFirstUse,
+		Priority:               emptyStringToNil(c.Priority),
 	}
 }
 
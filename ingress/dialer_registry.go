@@ -0,0 +1,72 @@
+package ingress
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// ContextDialer dials the origins of the ingress rules bound to it with originRequest.dialer, e.g. through a service
+// mesh or a jump host instead of directly. *net.Dialer is one.
+//
+// It's used by the http, unix, tcp over websocket, bastion and fastcgi services, with the network and address of
+// the origin.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+var (
+	dialersLock sync.RWMutex
+	dialers     = make(map[string]ContextDialer)
+)
+
+// RegisterDialer makes a dialer available to ingress rules under name. It panics if name is already registered.
+// Dialers must be registered before the ingress rules binding to them are parsed.
+func RegisterDialer(name string, dialer ContextDialer) {
+	dialersLock.Lock()
+	defer dialersLock.Unlock()
+	if dialer == nil {
+		panic("ingress: RegisterDialer dialer is nil")
+	}
+	if _, dup := dialers[name]; dup {
+		panic("ingress: RegisterDialer called twice for dialer " + name)
+	}
+	dialers[name] = dialer
+}
+
+// LookupDialer returns the dialer registered under name.
+func LookupDialer(name string) (ContextDialer, error) {
+	dialersLock.RLock()
+	defer dialersLock.RUnlock()
+	dialer, ok := dialers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown dialer %q, registered dialers are %v", name, registeredDialers())
+	}
+	return dialer, nil
+}
+
+// caller must hold the lock
+func registeredDialers() []string {
+	names := make([]string, 0, len(dialers))
+	for name := range dialers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// customDialer returns the dialer the rule is bound to, the registered dialer it names or its SSH jump host, or nil if
+// the origin is dialed directly.
+func customDialer(log *zerolog.Logger, shutdownC <-chan struct{}, cfg OriginRequestConfig) (ContextDialer, error) {
+	if cfg.SSHJump.Host != "" {
+		return newSSHJumpDialer(cfg.SSHJump, cfg.ConnectTimeout.Duration, log, shutdownC)
+	}
+	if cfg.Dialer == "" {
+		return nil, nil
+	}
+	return LookupDialer(cfg.Dialer)
+}
@@ -0,0 +1,96 @@
+package ingress
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+// recordingContextDialer dials directly, recording the addresses dialed.
+type recordingContextDialer struct {
+	lock      sync.Mutex
+	addresses []string
+}
+
+func (d *recordingContextDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.lock.Lock()
+	d.addresses = append(d.addresses, network+" "+address)
+	d.lock.Unlock()
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, address)
+}
+
+func (d *recordingContextDialer) dialed() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]string(nil), d.addresses...)
+}
+
+func TestCustomDialer(t *testing.T) {
+	dialer := &recordingContextDialer{}
+	RegisterDialer("test-mesh", dialer)
+	assert.Panics(t, func() { RegisterDialer("test-mesh", dialer) })
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer origin.Close()
+	originAddr := origin.Listener.Addr().String()
+
+	dialerName := "test-mesh"
+	ing, err := ParseIngress(&config.Configuration{
+		TunnelID: t.Name(),
+		Ingress: []config.UnvalidatedIngressRule{
+			{Hostname: "app.example.com", Service: origin.URL, OriginRequest: config.OriginRequestConfig{Dialer: &dialerName}},
+			{Hostname: "ssh.example.com", Service: "tcp://" + originAddr, OriginRequest: config.OriginRequestConfig{Dialer: &dialerName}},
+			{Service: origin.URL},
+		},
+	})
+	require.NoError(t, err)
+	raw := ConvertToRawOriginConfig(ing.Rules[0].Config)
+	require.NotNil(t, raw.Dialer)
+	assert.Equal(t, "test-mesh", *raw.Dialer)
+
+	log := zerolog.Nop()
+	shutdownC := make(chan struct{})
+	defer close(shutdownC)
+	require.NoError(t, ing.StartOrigins(&log, shutdownC))
+
+	req, err := http.NewRequest(http.MethodGet, origin.URL, nil)
+	require.NoError(t, err)
+	resp, err := ing.Rules[0].Service.(HTTPOriginProxy).RoundTrip(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	conn, err := ing.Rules[1].Service.(StreamBasedOriginProxy).EstablishConnection(context.Background(), "", &log)
+	require.NoError(t, err)
+	conn.Close()
+
+	// The catch-all rule isn't bound to the dialer
+	resp, err = ing.Rules[2].Service.(HTTPOriginProxy).RoundTrip(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	assert.Equal(t, []string{"tcp " + originAddr, "tcp " + originAddr}, dialer.dialed())
+}
+
+func TestUnknownDialer(t *testing.T) {
+	dialerName := "unregistered"
+	_, err := ParseIngress(&config.Configuration{
+		TunnelID: t.Name(),
+		Ingress: []config.UnvalidatedIngressRule{
+			{Service: "http://localhost:8080", OriginRequest: config.OriginRequestConfig{Dialer: &dialerName}},
+		},
+	})
+	require.ErrorContains(t, err, `unknown dialer "unregistered"`)
+}
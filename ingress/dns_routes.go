@@ -0,0 +1,85 @@
+package ingress
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DNSRouteMismatch is an inconsistency between the ingress rules and the DNS routes of the tunnel. A hostname
+// without DNS route is unreachable, and a DNS route without ingress rule ends up in a catch-all status code.
+type DNSRouteMismatch struct {
+	Hostname string
+	// MissingRoute is true if an ingress rule hostname has no DNS route, false if a DNS route has no ingress rule.
+	MissingRoute bool
+}
+
+func (m DNSRouteMismatch) String() string {
+	if m.MissingRoute {
+		return fmt.Sprintf("ingress rule hostname %s has no DNS route to the tunnel", m.Hostname)
+	}
+	return fmt.Sprintf("DNS route %s doesn't match any ingress rule", m.Hostname)
+}
+
+// FindDNSRouteMismatches cross-checks the ingress rule hostnames against the hostnames routed to the tunnel in zone.
+// Hostnames outside of zone can't be verified and are ignored. DNS routes without rule are only reported when the
+// catch-all rule returns a status code, otherwise they are served by the catch-all origin on purpose.
+func (ing Ingress) FindDNSRouteMismatches(zone string, routes []string) []DNSRouteMismatch {
+	zone = normalizeHostname(zone)
+	normalizedRoutes := make([]string, 0, len(routes))
+	for _, route := range routes {
+		normalizedRoutes = append(normalizedRoutes, normalizeHostname(route))
+	}
+
+	var mismatches []DNSRouteMismatch
+	var ruleHosts []string
+	seen := make(map[string]bool)
+	for _, rule := range ing.Rules {
+		ruleHost := normalizeHostname(rule.Hostname)
+		if ruleHost == "" || ruleHost == "*" {
+			continue
+		}
+		ruleHosts = append(ruleHosts, ruleHost)
+		if seen[ruleHost] || !inZone(strings.TrimPrefix(ruleHost, "*."), zone) {
+			continue
+		}
+		seen[ruleHost] = true
+		routed := false
+		for _, route := range normalizedRoutes {
+			if matchHost(ruleHost, route) || matchHost(route, ruleHost) {
+				routed = true
+				break
+			}
+		}
+		if !routed {
+			mismatches = append(mismatches, DNSRouteMismatch{Hostname: ruleHost, MissingRoute: true})
+		}
+	}
+
+	if ing.IsEmpty() {
+		return mismatches
+	}
+	if _, ok := ing.CatchAll().Service.(*statusCode); !ok {
+		return mismatches
+	}
+	for _, route := range normalizedRoutes {
+		matched := false
+		for _, ruleHost := range ruleHosts {
+			if matchHost(ruleHost, route) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			mismatches = append(mismatches, DNSRouteMismatch{Hostname: route})
+		}
+	}
+	return mismatches
+}
+
+func normalizeHostname(hostname string) string {
+	return strings.TrimSuffix(strings.ToLower(hostname), ".")
+}
+
+func inZone(hostname, zone string) bool {
+	return hostname == zone || strings.HasSuffix(hostname, "."+zone)
+}
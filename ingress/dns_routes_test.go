@@ -0,0 +1,47 @@
+package ingress
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindDNSRouteMismatches(t *testing.T) {
+	ing, err := ParseIngress(MustReadIngress(`
+ingress:
+- hostname: app.example.com
+  service: http://localhost:8000
+- hostname: "*.static.example.com"
+  service: http://localhost:8001
+- hostname: missing.example.com
+  service: http://localhost:8002
+- hostname: other.example.org
+  service: http://localhost:8003
+- service: http_status:404
+`))
+	require.NoError(t, err)
+
+	mismatches := ing.FindDNSRouteMismatches("example.com", []string{
+		"APP.example.com.",
+		"img.static.example.com",
+		"forgotten.example.com",
+	})
+	require.Equal(t, []DNSRouteMismatch{
+		{Hostname: "missing.example.com", MissingRoute: true},
+		{Hostname: "forgotten.example.com"},
+	}, mismatches)
+}
+
+func TestFindDNSRouteMismatchesCatchAllOrigin(t *testing.T) {
+	ing, err := ParseIngress(MustReadIngress(`
+ingress:
+- hostname: app.example.com
+  service: http://localhost:8000
+- service: http://localhost:8001
+`))
+	require.NoError(t, err)
+
+	// Routes without rule are served by the catch-all origin
+	mismatches := ing.FindDNSRouteMismatches("example.com", []string{"app.example.com", "www.example.com"})
+	require.Empty(t, mismatches)
+}
@@ -28,8 +28,10 @@ type icmpProxy struct {
 	srcFunnelTracker *packet.FunnelTracker
 	echoIDTracker    *echoIDTracker
 	conn             *icmp.PacketConn
-	logger           *zerolog.Logger
-	idleTimeout      time.Duration
+	// The TTL of conn, which is shared by all the flows
+	connTTL     socketTTL
+	logger      *zerolog.Logger
+	idleTimeout time.Duration
 }
 
 // echoIDTracker tracks which ID has been assigned. It first loops through assignment from lastAssignment to then end,
@@ -112,6 +114,15 @@ func (snf echoFunnelID) String() string {
 	return strconv.FormatUint(uint64(snf), 10)
 }
 
+// Opens a non-privileged ICMP socket, which reads all the ICMP messages sent to listenIP, including the time
+// exceeded messages of the routers on the path of the requests.
+func newICMPConn(listenIP netip.Addr) (*icmp.PacketConn, error) {
+	if listenIP.Is4() {
+		return icmp.ListenPacket("udp4", listenIP.String())
+	}
+	return icmp.ListenPacket("udp6", listenIP.String())
+}
+
 func newICMPProxy(listenIP netip.Addr, logger *zerolog.Logger, idleTimeout time.Duration) (*icmpProxy, error) {
 	conn, err := newICMPConn(listenIP)
 	if err != nil {
@@ -161,7 +172,7 @@ func (ip *icmpProxy) Request(ctx context.Context, pk *packet.ICMP, responder ICM
 			ip.echoIDTracker.release(echoIDTrackerKey, assignedEchoID)
 			return nil
 		}
-		icmpFlow := newICMPEchoFlow(pk.Src, closeCallback, ip.conn, responder, int(assignedEchoID), originalEcho.ID)
+		icmpFlow := newICMPEchoFlow(pk.Src, closeCallback, ip.conn, &ip.connTTL, responder, int(assignedEchoID), originalEcho.ID)
 		return icmpFlow, nil
 	}
 	funnelID := echoFunnelID(assignedEchoID)
@@ -185,7 +196,7 @@ func (ip *icmpProxy) Request(ctx context.Context, pk *packet.ICMP, responder ICM
 		return err
 	}
 
-	err = icmpFlow.sendToDst(pk.Dst, pk.Message)
+	err = icmpFlow.sendToDst(pk.Dst, pk.Message, pk.TTL)
 	if err != nil {
 		tracing.EndWithErrorStatus(span, err)
 		return err
@@ -210,6 +221,12 @@ func (ip *icmpProxy) Serve(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
+		if exceeded, ok := parseTimeExceeded(from, buf[:n]); ok {
+			if err := ip.sendTimeExceeded(exceeded); err != nil {
+				ip.logger.Debug().Err(err).Str("router", exceeded.from.String()).Msg("Failed to send ICMP time exceeded")
+			}
+			continue
+		}
 		reply, err := parseReply(from, buf[:n])
 		if err != nil {
 			ip.logger.Debug().Err(err).Str("dst", from.String()).Msg("Failed to parse ICMP reply, continue to parse as full packet")
@@ -251,6 +268,18 @@ func (ip *icmpProxy) handleFullPacket(ctx context.Context, decoder *packet.ICMPD
 	return nil
 }
 
+func (ip *icmpProxy) sendTimeExceeded(exceeded *timeExceeded) error {
+	funnel, ok := ip.srcFunnelTracker.Get(echoFunnelID(exceeded.echoID))
+	if !ok {
+		return packet.ErrFunnelNotFound
+	}
+	icmpFlow, err := toICMPEchoFlow(funnel)
+	if err != nil {
+		return err
+	}
+	return icmpFlow.returnTimeExceeded(exceeded)
+}
+
 func (ip *icmpProxy) sendReply(ctx context.Context, reply *echoReply) error {
 	funnelID := echoFunnelID(reply.echo.ID)
 	funnel, ok := ip.srcFunnelTracker.Get(funnelID)
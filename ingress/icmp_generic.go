@@ -1,4 +1,4 @@
-//go:build !darwin && !linux && (!windows || !cgo)
+//go:build !darwin && !linux && !windows
 
 package ingress
 
@@ -14,6 +14,8 @@ import (
 	"github.com/cloudflare/cloudflared/packet"
 )
 
+const icmpBackend = "unsupported"
+
 var errICMPProxyNotImplemented = fmt.Errorf("ICMP proxy is not implemented on %s %s", runtime.GOOS, runtime.GOARCH)
 
 type icmpProxy struct{}
@@ -9,17 +9,22 @@ package ingress
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"net"
 	"net/netip"
 	"os"
 	"regexp"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
 
 	"github.com/cloudflare/cloudflared/packet"
 	"github.com/cloudflare/cloudflared/tracing"
@@ -28,6 +33,8 @@ import (
 const (
 	// https://lwn.net/Articles/550551/ IPv4 and IPv6 share the same path
 	pingGroupPath = "/proc/sys/net/ipv4/ping_group_range"
+	// Size of struct sock_extended_err, see https://man7.org/linux/man-pages/man7/ip.7.html
+	sizeofSockExtendedErr = 16
 )
 
 var (
@@ -125,7 +132,7 @@ func (ip *icmpProxy) Request(ctx context.Context, pk *packet.ICMP, responder ICM
 		span.SetAttributes(attribute.Int("port", localUDPAddr.Port))
 
 		echoID := localUDPAddr.Port
-		icmpFlow := newICMPEchoFlow(pk.Src, closeCallback, conn, responder, echoID, originalEcho.ID)
+		icmpFlow := newICMPEchoFlow(pk.Src, closeCallback, conn, &socketTTL{}, responder, echoID, originalEcho.ID)
 		return icmpFlow, nil
 	}
 	funnelID := flow3Tuple{
@@ -155,7 +162,7 @@ func (ip *icmpProxy) Request(ctx context.Context, pk *packet.ICMP, responder ICM
 			ip.srcFunnelTracker.Unregister(funnelID, icmpFlow)
 		}()
 	}
-	if err := icmpFlow.sendToDst(pk.Dst, pk.Message); err != nil {
+	if err := icmpFlow.sendToDst(pk.Dst, pk.Message, pk.TTL); err != nil {
 		tracing.EndWithErrorStatus(span, err)
 		return errors.Wrap(err, "failed to send ICMP echo request")
 	}
@@ -192,6 +199,16 @@ func (ip *icmpProxy) handleResponse(ctx context.Context, flow *icmpEchoFlow, buf
 			tracing.EndWithErrorStatus(span, fmt.Errorf("flow was closed"))
 			return true
 		}
+		// ICMP errors caused by the requests fail the read, they are then read from the error queue
+		if icmpErr, ok := readICMPError(flow.originConn, buf); ok {
+			tracing.End(span)
+			if exceeded, ok := icmpErr.timeExceeded(); ok {
+				if err := flow.returnTimeExceeded(exceeded); err != nil {
+					ip.logger.Debug().Err(err).Str("router", exceeded.from.String()).Msg("Failed to send ICMP time exceeded")
+				}
+			}
+			return false
+		}
 		ip.logger.Error().Err(err).Str("socket", flow.originConn.LocalAddr().String()).Msg("Failed to read from ICMP socket")
 		tracing.EndWithErrorStatus(span, err)
 		return true
@@ -220,6 +237,150 @@ func (ip *icmpProxy) handleResponse(ctx context.Context, flow *icmpEchoFlow, buf
 	return false
 }
 
+// Opens a non-privileged ICMP socket. The ICMP errors caused by its requests, such as the time exceeded messages of
+// the routers on their path, are queued on the error queue of the socket, see IP_RECVERR in
+// https://man7.org/linux/man-pages/man7/ip.7.html
+func newICMPConn(listenIP netip.Addr) (net.PacketConn, error) {
+	var (
+		family, proto, level, recvErr int
+		sa                            unix.Sockaddr
+	)
+	if listenIP.Is4() {
+		family, proto, level, recvErr = unix.AF_INET, unix.IPPROTO_ICMP, unix.IPPROTO_IP, unix.IP_RECVERR
+		sa = &unix.SockaddrInet4{Addr: listenIP.As4()}
+	} else {
+		family, proto, level, recvErr = unix.AF_INET6, unix.IPPROTO_ICMPV6, unix.IPPROTO_IPV6, unix.IPV6_RECVERR
+		sa6 := &unix.SockaddrInet6{Addr: listenIP.As16()}
+		if zone := listenIP.Zone(); zone != "" {
+			ifi, err := net.InterfaceByName(zone)
+			if err != nil {
+				return nil, err
+			}
+			sa6.ZoneId = uint32(ifi.Index) // nolint: gosec
+		}
+		sa = sa6
+	}
+	fd, err := unix.Socket(family, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := unix.SetsockoptInt(fd, level, recvErr, 1); err != nil {
+		_ = unix.Close(fd)
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		_ = unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	f := os.NewFile(uintptr(fd), "datagram-oriented icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
+
+// icmpError is an ICMP error read from the error queue of a socket.
+type icmpError struct {
+	origin   uint8
+	icmpType uint8
+	// offender is the router or host that sent the error
+	offender netip.Addr
+	// dst is the destination of the request that caused the error
+	dst netip.Addr
+	// request is the ICMP message of the request
+	request []byte
+}
+
+// readICMPError reads an ICMP error from the error queue of conn, without blocking.
+func readICMPError(conn net.PacketConn, buf []byte) (*icmpError, bool) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, false
+	}
+	rawConn, err := sc.SyscallConn()
+	if err != nil {
+		return nil, false
+	}
+	oob := make([]byte, 128)
+	var (
+		n, oobn int
+		from    unix.Sockaddr
+		recvErr error
+	)
+	err = rawConn.Read(func(fd uintptr) bool {
+		n, oobn, _, from, recvErr = unix.Recvmsg(int(fd), buf, oob, unix.MSG_ERRQUEUE)
+		return true
+	})
+	if err != nil || recvErr != nil {
+		return nil, false
+	}
+	cmsgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, false
+	}
+	for _, cmsg := range cmsgs {
+		if (cmsg.Header.Level == unix.SOL_IP && cmsg.Header.Type == unix.IP_RECVERR) ||
+			(cmsg.Header.Level == unix.SOL_IPV6 && cmsg.Header.Type == unix.IPV6_RECVERR) {
+			icmpErr, ok := parseSockExtendedErr(cmsg.Data)
+			if !ok {
+				return nil, false
+			}
+			icmpErr.dst = sockaddrAddr(from)
+			icmpErr.request = buf[:n]
+			return icmpErr, true
+		}
+	}
+	return nil, false
+}
+
+// parseSockExtendedErr parses a struct sock_extended_err, followed by the address of the offender.
+func parseSockExtendedErr(data []byte) (*icmpError, bool) {
+	if len(data) < sizeofSockExtendedErr {
+		return nil, false
+	}
+	icmpErr := &icmpError{
+		origin:   data[4],
+		icmpType: data[5],
+	}
+	offender := data[sizeofSockExtendedErr:]
+	if len(offender) >= 2 {
+		switch binary.NativeEndian.Uint16(offender) {
+		case unix.AF_INET:
+			if len(offender) >= unix.SizeofSockaddrInet4 {
+				icmpErr.offender = netip.AddrFrom4([4]byte(offender[4:8]))
+			}
+		case unix.AF_INET6:
+			if len(offender) >= unix.SizeofSockaddrInet6 {
+				icmpErr.offender = netip.AddrFrom16([16]byte(offender[8:24]))
+			}
+		}
+	}
+	return icmpErr, true
+}
+
+// timeExceeded returns the error as a time exceeded message, if it is one.
+func (e *icmpError) timeExceeded() (*timeExceeded, bool) {
+	isTimeExceeded := (e.origin == unix.SO_EE_ORIGIN_ICMP && e.icmpType == uint8(ipv4.ICMPTypeTimeExceeded)) ||
+		(e.origin == unix.SO_EE_ORIGIN_ICMP6 && e.icmpType == uint8(ipv6.ICMPTypeTimeExceeded))
+	if !isTimeExceeded || !e.offender.IsValid() || !e.dst.IsValid() || len(e.request) < 8 {
+		return nil, false
+	}
+	return &timeExceeded{
+		from:   e.offender,
+		dst:    e.dst,
+		echoID: int(binary.BigEndian.Uint16(e.request[4:6])),
+		seq:    int(binary.BigEndian.Uint16(e.request[6:8])),
+	}, true
+}
+
+func sockaddrAddr(sa unix.Sockaddr) netip.Addr {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return netip.AddrFrom4(sa.Addr)
+	case *unix.SockaddrInet6:
+		return netip.AddrFrom16(sa.Addr)
+	}
+	return netip.Addr{}
+}
+
 // Only linux uses flow3Tuple as FunnelID
 func (ft flow3Tuple) Type() string {
 	return "srcIP_dstIP_echoID"
@@ -3,11 +3,49 @@
 package ingress
 
 import (
+	"encoding/binary"
+	"net/netip"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
+
 	"github.com/cloudflare/cloudflared/packet"
 )
 
 func getFunnel(t *testing.T, proxy *icmpProxy, tuple flow3Tuple) (packet.Funnel, bool) {
 	return proxy.srcFunnelTracker.Get(tuple)
 }
+
+func TestParseSockExtendedErr(t *testing.T) {
+	// struct sock_extended_err of a time exceeded message, followed by the sockaddr_in of the router
+	data := make([]byte, sizeofSockExtendedErr+unix.SizeofSockaddrInet4)
+	binary.NativeEndian.PutUint32(data, uint32(unix.EHOSTUNREACH))
+	data[4] = unix.SO_EE_ORIGIN_ICMP
+	data[5] = uint8(ipv4.ICMPTypeTimeExceeded)
+	binary.NativeEndian.PutUint16(data[sizeofSockExtendedErr:], unix.AF_INET)
+	copy(data[sizeofSockExtendedErr+4:], []byte{10, 0, 0, 254})
+
+	icmpErr, ok := parseSockExtendedErr(data)
+	require.True(t, ok)
+	icmpErr.dst = netip.MustParseAddr("10.1.2.3")
+	// The request as sent by the socket, with the echo ID assigned by the kernel
+	icmpErr.request = []byte{8, 0, 0, 0, 0x9c, 0x40, 0, 3}
+	exceeded, ok := icmpErr.timeExceeded()
+	require.True(t, ok)
+	assert.Equal(t, timeExceeded{
+		from:   netip.MustParseAddr("10.0.0.254"),
+		dst:    netip.MustParseAddr("10.1.2.3"),
+		echoID: 40000,
+		seq:    3,
+	}, *exceeded)
+
+	// Other errors, such as destination unreachable, are not proxied
+	data[5] = uint8(ipv4.ICMPTypeDestinationUnreachable)
+	icmpErr, ok = parseSockExtendedErr(data)
+	require.True(t, ok)
+	_, ok = icmpErr.timeExceeded()
+	assert.False(t, ok)
+}
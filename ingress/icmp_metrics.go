@@ -21,12 +21,26 @@ var (
 		Name:      "total_replies",
 		Help:      "Total count of ICMP replies that have been proxied from any origin",
 	})
+	icmpTimeExceeded = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "icmp",
+		Name:      "total_time_exceeded",
+		Help:      "Total count of ICMP time exceeded messages from routers between cloudflared and the origins that have been proxied",
+	})
+	icmpUnproxied = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "icmp",
+		Name:      "unproxied_total",
+		Help:      "Total count of ICMP messages dropped because they only make sense on the link of the eyeball, per reason",
+	}, []string{"reason"})
 )
 
 func init() {
 	prometheus.MustRegister(
 		icmpRequests,
 		icmpReplies,
+		icmpTimeExceeded,
+		icmpUnproxied,
 	)
 }
 
@@ -37,3 +51,7 @@ func incrementICMPRequest() {
 func incrementICMPReply() {
 	icmpReplies.Inc()
 }
+
+func incrementICMPTimeExceeded() {
+	icmpTimeExceeded.Inc()
+}
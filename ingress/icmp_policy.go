@@ -0,0 +1,111 @@
+package ingress
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/packet"
+)
+
+const (
+	icmpPolicyDestination = "destination"
+	icmpPolicyRate        = "rate"
+)
+
+var icmpPolicyDrops = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "icmp",
+	Name:      "policy_dropped_total",
+	Help:      "Total count of ICMP requests dropped by the ICMP policy, per reason",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(icmpPolicyDrops)
+}
+
+// ICMPPolicy restricts the ICMP requests proxied by the ICMPRouter. The zero value allows every request.
+type ICMPPolicy struct {
+	// AllowedDestinations are the networks that can be pinged, all of them when empty.
+	AllowedDestinations []netip.Prefix
+	// MaxEchoRatePerSource limits the echo requests per second of each source IP, unlimited when 0.
+	MaxEchoRatePerSource uint64
+	// MaxTTL clamps the TTL of the requests sent to origins, unless it is 0.
+	MaxTTL uint8
+}
+
+// NewICMPPolicy parses the ICMP policy of the warp-routing configuration.
+func NewICMPPolicy(raw *config.ICMPPolicyConfig) (ICMPPolicy, error) {
+	if raw == nil {
+		return ICMPPolicy{}, nil
+	}
+	policy := ICMPPolicy{
+		MaxEchoRatePerSource: raw.MaxEchoRatePerSource,
+		MaxTTL:               raw.MaxTTL,
+	}
+	for _, network := range raw.AllowedDestinations {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return ICMPPolicy{}, errors.Wrapf(err, "invalid ICMP allowed destination %q", network)
+		}
+		policy.AllowedDestinations = append(policy.AllowedDestinations, prefix.Masked())
+	}
+	return policy, nil
+}
+
+func (p ICMPPolicy) allowsDestination(dst netip.Addr) bool {
+	if len(p.AllowedDestinations) == 0 {
+		return true
+	}
+	dst = dst.Unmap()
+	for _, prefix := range p.AllowedDestinations {
+		if prefix.Contains(dst) {
+			return true
+		}
+	}
+	return false
+}
+
+// icmpPolicyEnforcer applies an ICMPPolicy to the requests of an ICMPRouter.
+type icmpPolicyEnforcer struct {
+	lock   sync.Mutex
+	policy ICMPPolicy
+	// Echo requests of each source in the current one second window
+	window  time.Time
+	sources map[netip.Addr]uint64
+}
+
+func (e *icmpPolicyEnforcer) update(policy ICMPPolicy) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.policy = policy
+	e.sources = nil
+}
+
+// admit returns the reason pk is dropped, if any, after clamping its TTL.
+func (e *icmpPolicyEnforcer) admit(pk *packet.ICMP, now time.Time) string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if !e.policy.allowsDestination(pk.Dst) {
+		return icmpPolicyDestination
+	}
+	if limit := e.policy.MaxEchoRatePerSource; limit > 0 {
+		// The window is reset as a whole so that sources that stopped sending are forgotten
+		if e.sources == nil || now.Sub(e.window) >= time.Second {
+			e.window = now
+			e.sources = make(map[netip.Addr]uint64)
+		}
+		if e.sources[pk.Src] >= limit {
+			return icmpPolicyRate
+		}
+		e.sources[pk.Src]++
+	}
+	if e.policy.MaxTTL > 0 {
+		pk.TTL = min(pk.TTL, e.policy.MaxTTL)
+	}
+	return ""
+}
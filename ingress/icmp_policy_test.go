@@ -0,0 +1,91 @@
+package ingress
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/packet"
+)
+
+func newTestEchoRequest(src, dst string, ttl uint8) *packet.ICMP {
+	return &packet.ICMP{
+		IP: &packet.IP{
+			Src:      netip.MustParseAddr(src),
+			Dst:      netip.MustParseAddr(dst),
+			Protocol: 1,
+			TTL:      ttl,
+		},
+		Message: &icmp.Message{
+			Type: ipv4.ICMPTypeEcho,
+			Body: &icmp.Echo{ID: 1, Seq: 1},
+		},
+	}
+}
+
+func TestNewICMPPolicy(t *testing.T) {
+	policy, err := NewICMPPolicy(nil)
+	require.NoError(t, err)
+	assert.Equal(t, ICMPPolicy{}, policy)
+
+	policy, err = NewICMPPolicy(&config.ICMPPolicyConfig{
+		AllowedDestinations:  []string{"10.1.2.3/16", "fd00::/8"},
+		MaxEchoRatePerSource: 5,
+		MaxTTL:               8,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16"), netip.MustParsePrefix("fd00::/8")}, policy.AllowedDestinations)
+	assert.Equal(t, uint64(5), policy.MaxEchoRatePerSource)
+	assert.Equal(t, uint8(8), policy.MaxTTL)
+
+	_, err = NewICMPPolicy(&config.ICMPPolicyConfig{AllowedDestinations: []string{"10.0.0.1"}})
+	require.Error(t, err)
+}
+
+func TestICMPPolicyEnforcer(t *testing.T) {
+	var enforcer icmpPolicyEnforcer
+	now := time.Now()
+
+	// The zero policy allows everything and keeps the TTL
+	pk := newTestEchoRequest("192.168.0.1", "8.8.8.8", 64)
+	assert.Empty(t, enforcer.admit(pk, now))
+	assert.Equal(t, uint8(64), pk.TTL)
+
+	enforcer.update(ICMPPolicy{
+		AllowedDestinations:  []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		MaxEchoRatePerSource: 2,
+		MaxTTL:               4,
+	})
+	assert.Equal(t, icmpPolicyDestination, enforcer.admit(newTestEchoRequest("192.168.0.1", "8.8.8.8", 64), now))
+
+	for i := 0; i < 2; i++ {
+		pk = newTestEchoRequest("192.168.0.1", "10.0.0.1", 64)
+		assert.Empty(t, enforcer.admit(pk, now))
+		assert.Equal(t, uint8(4), pk.TTL)
+	}
+	assert.Equal(t, icmpPolicyRate, enforcer.admit(newTestEchoRequest("192.168.0.1", "10.0.0.1", 64), now))
+
+	// Other sources have their own rate
+	pk = newTestEchoRequest("192.168.0.2", "10.0.0.1", 3)
+	assert.Empty(t, enforcer.admit(pk, now))
+	assert.Equal(t, uint8(3), pk.TTL)
+
+	// The rate applies per second
+	assert.Empty(t, enforcer.admit(newTestEchoRequest("192.168.0.1", "10.0.0.1", 64), now.Add(time.Second)))
+}
+
+func TestICMPRouterPolicyDrops(t *testing.T) {
+	router := &icmpRouter{}
+	router.UpdatePolicy(ICMPPolicy{AllowedDestinations: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}})
+
+	// Denied requests are dropped before reaching a proxy
+	require.NoError(t, router.Request(context.Background(), newTestEchoRequest("192.168.0.1", "8.8.8.8", 64), nil))
+	require.Error(t, router.Request(context.Background(), newTestEchoRequest("192.168.0.1", "10.0.0.1", 64), nil))
+}
@@ -5,25 +5,24 @@ package ingress
 // This file extracts logic shared by Linux and Darwin implementation if ICMPProxy.
 
 import (
+	"encoding/binary"
 	"fmt"
 	"net"
 	"net/netip"
+	"sync"
 	"sync/atomic"
 
 	"github.com/google/gopacket/layers"
 	"github.com/rs/zerolog"
 	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 
 	"github.com/cloudflare/cloudflared/packet"
 )
 
-// Opens a non-privileged ICMP socket on Linux and Darwin
-func newICMPConn(listenIP netip.Addr) (*icmp.PacketConn, error) {
-	if listenIP.Is4() {
-		return icmp.ListenPacket("udp4", listenIP.String())
-	}
-	return icmp.ListenPacket("udp6", listenIP.String())
-}
+// Non-privileged ICMP datagram sockets, which Linux allows to the groups in ping_group_range
+const icmpBackend = "icmp_socket"
 
 func netipAddr(addr net.Addr) (netip.Addr, bool) {
 	udpAddr, ok := addr.(*net.UDPAddr)
@@ -46,19 +45,21 @@ type icmpEchoFlow struct {
 	closeCallback  func() error
 	closed         *atomic.Bool
 	src            netip.Addr
-	originConn     *icmp.PacketConn
+	originConn     net.PacketConn
+	originTTL      *socketTTL
 	responder      ICMPResponder
 	assignedEchoID int
 	originalEchoID int
 }
 
-func newICMPEchoFlow(src netip.Addr, closeCallback func() error, originConn *icmp.PacketConn, responder ICMPResponder, assignedEchoID, originalEchoID int) *icmpEchoFlow {
+func newICMPEchoFlow(src netip.Addr, closeCallback func() error, originConn net.PacketConn, originTTL *socketTTL, responder ICMPResponder, assignedEchoID, originalEchoID int) *icmpEchoFlow {
 	return &icmpEchoFlow{
 		ActivityTracker: packet.NewActivityTracker(),
 		closeCallback:   closeCallback,
 		closed:          &atomic.Bool{},
 		src:             src,
 		originConn:      originConn,
+		originTTL:       originTTL,
 		responder:       responder,
 		assignedEchoID:  assignedEchoID,
 		originalEchoID:  originalEchoID,
@@ -91,8 +92,9 @@ func (ief *icmpEchoFlow) IsClosed() bool {
 	return ief.closed.Load()
 }
 
-// sendToDst rewrites the echo ID to the one assigned to this flow
-func (ief *icmpEchoFlow) sendToDst(dst netip.Addr, msg *icmp.Message) error {
+// sendToDst rewrites the echo ID to the one assigned to this flow. The request is sent with ttl, so that the
+// routers between cloudflared and dst see the hops left to the eyeball's request.
+func (ief *icmpEchoFlow) sendToDst(dst netip.Addr, msg *icmp.Message, ttl uint8) error {
 	ief.UpdateLastActive()
 	originalEcho, err := getICMPEcho(msg)
 	if err != nil {
@@ -113,12 +115,81 @@ func (ief *icmpEchoFlow) sendToDst(dst netip.Addr, msg *icmp.Message) error {
 	if err != nil {
 		return err
 	}
+	// The TTL is set on the socket, which can be shared by other flows until the request is written
+	ief.originTTL.lock.Lock()
+	defer ief.originTTL.lock.Unlock()
+	if err := ief.originTTL.set(ief.originConn, dst, ttl); err != nil {
+		return fmt.Errorf("failed to set the TTL of the ICMP socket: %w", err)
+	}
 	_, err = ief.originConn.WriteTo(serializedPacket, &net.UDPAddr{
 		IP: dst.AsSlice(),
 	})
 	return err
 }
 
+// socketTTL tracks the TTL set on an ICMP socket, so that it's only changed when the TTL of the requests does.
+type socketTTL struct {
+	lock sync.Mutex
+	ttl  uint8
+}
+
+// set must be called with the lock held. A ttl of 0 keeps the TTL of the socket.
+func (st *socketTTL) set(conn net.PacketConn, dst netip.Addr, ttl uint8) error {
+	if ttl == 0 || ttl == st.ttl {
+		return nil
+	}
+	var err error
+	if icmpConn, ok := conn.(*icmp.PacketConn); ok {
+		if dst.Is4() {
+			err = icmpConn.IPv4PacketConn().SetTTL(int(ttl))
+		} else {
+			err = icmpConn.IPv6PacketConn().SetHopLimit(int(ttl))
+		}
+	} else if dst.Is4() {
+		err = ipv4.NewPacketConn(conn).SetTTL(int(ttl))
+	} else {
+		err = ipv6.NewPacketConn(conn).SetHopLimit(int(ttl))
+	}
+	if err != nil {
+		return err
+	}
+	st.ttl = ttl
+	return nil
+}
+
+// returnTimeExceeded tells the eyeball that its request expired in transit at the router that sent exceeded.
+// The request is rebuilt as the eyeball sent it, so that traceroute can match the hop with its probe.
+func (ief *icmpEchoFlow) returnTimeExceeded(exceeded *timeExceeded) error {
+	ief.UpdateLastActive()
+	var echoType icmp.Type = ipv4.ICMPTypeEcho
+	protocol := layers.IPProtocolICMPv4
+	if exceeded.dst.Is6() {
+		echoType = ipv6.ICMPTypeEchoRequest
+		protocol = layers.IPProtocolICMPv6
+	}
+	request := packet.ICMP{
+		IP: &packet.IP{
+			Src:      ief.src,
+			Dst:      exceeded.dst,
+			Protocol: protocol,
+			TTL:      1,
+		},
+		Message: &icmp.Message{
+			Type: echoType,
+			Body: &icmp.Echo{
+				ID:  ief.originalEchoID,
+				Seq: exceeded.seq,
+			},
+		},
+	}
+	rawRequest, err := packet.NewEncoder().Encode(&request)
+	if err != nil {
+		return err
+	}
+	incrementICMPTimeExceeded()
+	return ief.responder.ReturnPacket(packet.NewICMPTTLExceedPacket(request.IP, rawRequest, exceeded.from))
+}
+
 // returnToSrc rewrites the echo ID to the original echo ID from the eyeball
 func (ief *icmpEchoFlow) returnToSrc(reply *echoReply) error {
 	ief.UpdateLastActive()
@@ -166,6 +237,80 @@ func parseReply(from net.Addr, rawMsg []byte) (*echoReply, error) {
 	}, nil
 }
 
+// timeExceeded is an ICMP time exceeded message sent by a router for an echo request of a flow.
+type timeExceeded struct {
+	// from is the router that dropped the request
+	from netip.Addr
+	// dst, echoID and seq identify the request
+	dst    netip.Addr
+	echoID int
+	seq    int
+}
+
+// parseTimeExceeded parses rawMsg as a time exceeded message quoting an echo request.
+func parseTimeExceeded(from net.Addr, rawMsg []byte) (*timeExceeded, bool) {
+	fromAddr, ok := netipAddr(from)
+	if !ok {
+		return nil, false
+	}
+	proto := layers.IPProtocolICMPv4
+	if fromAddr.Is6() {
+		proto = layers.IPProtocolICMPv6
+	}
+	msg, err := icmp.ParseMessage(int(proto), rawMsg)
+	if err != nil || (msg.Type != ipv4.ICMPTypeTimeExceeded && msg.Type != ipv6.ICMPTypeTimeExceeded) {
+		return nil, false
+	}
+	body, ok := msg.Body.(*icmp.TimeExceeded)
+	if !ok {
+		return nil, false
+	}
+	exceeded, ok := parseQuotedEcho(body.Data)
+	if !ok {
+		return nil, false
+	}
+	exceeded.from = fromAddr
+	return exceeded, true
+}
+
+// parseQuotedEcho parses the echo request quoted by an ICMP error message, starting with its IP header.
+func parseQuotedEcho(quoted []byte) (*timeExceeded, bool) {
+	if len(quoted) == 0 {
+		return nil, false
+	}
+	var (
+		dst    netip.Addr
+		echo   []byte
+		isEcho bool
+	)
+	switch quoted[0] >> 4 {
+	case 4:
+		headerLen := int(quoted[0]&0x0f) * 4
+		if headerLen < ipv4.HeaderLen || len(quoted) < headerLen+8 || quoted[9] != byte(layers.IPProtocolICMPv4) {
+			return nil, false
+		}
+		dst = netip.AddrFrom4([4]byte(quoted[16:20]))
+		echo = quoted[headerLen:]
+		isEcho = echo[0] == byte(ipv4.ICMPTypeEcho)
+	case 6:
+		// Extension headers aren't expected in the requests sent by cloudflared
+		if len(quoted) < ipv6.HeaderLen+8 || quoted[6] != byte(layers.IPProtocolICMPv6) {
+			return nil, false
+		}
+		dst = netip.AddrFrom16([16]byte(quoted[24:40]))
+		echo = quoted[ipv6.HeaderLen:]
+		isEcho = echo[0] == byte(ipv6.ICMPTypeEchoRequest)
+	}
+	if !isEcho {
+		return nil, false
+	}
+	return &timeExceeded{
+		dst:    dst,
+		echoID: int(binary.BigEndian.Uint16(echo[4:6])),
+		seq:    int(binary.BigEndian.Uint16(echo[6:8])),
+	}, true
+}
+
 func toICMPEchoFlow(funnel packet.Funnel) (*icmpEchoFlow, error) {
 	icmpFlow, ok := funnel.(*icmpEchoFlow)
 	if !ok {
@@ -0,0 +1,95 @@
+//go:build darwin || linux
+
+package ingress
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/cloudflare/cloudflared/packet"
+)
+
+func encodeTestEchoRequest(t *testing.T, src, dst netip.Addr, id, seq int) []byte {
+	var echoType icmp.Type = ipv4.ICMPTypeEcho
+	protocol := layers.IPProtocolICMPv4
+	if dst.Is6() {
+		echoType = ipv6.ICMPTypeEchoRequest
+		protocol = layers.IPProtocolICMPv6
+	}
+	rawPacket, err := packet.NewEncoder().Encode(&packet.ICMP{
+		IP: &packet.IP{Src: src, Dst: dst, Protocol: protocol, TTL: 1},
+		Message: &icmp.Message{
+			Type: echoType,
+			Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("probe")},
+		},
+	})
+	require.NoError(t, err)
+	return append([]byte(nil), rawPacket.Data...)
+}
+
+func TestParseTimeExceeded(t *testing.T) {
+	tests := []struct {
+		router, src, dst string
+		timeExceededType icmp.Type
+	}{
+		{router: "10.0.0.254", src: "172.16.0.1", dst: "10.1.2.3", timeExceededType: ipv4.ICMPTypeTimeExceeded},
+		{router: "fd00::fe", src: "fd01::1", dst: "fd02::3", timeExceededType: ipv6.ICMPTypeTimeExceeded},
+	}
+	for _, test := range tests {
+		router := netip.MustParseAddr(test.router)
+		dst := netip.MustParseAddr(test.dst)
+		msg := icmp.Message{
+			Type: test.timeExceededType,
+			Body: &icmp.TimeExceeded{Data: encodeTestEchoRequest(t, netip.MustParseAddr(test.src), dst, 4000, 7)},
+		}
+		rawMsg, err := msg.Marshal(nil)
+		require.NoError(t, err)
+
+		exceeded, ok := parseTimeExceeded(&net.UDPAddr{IP: router.AsSlice()}, rawMsg)
+		require.True(t, ok)
+		assert.Equal(t, timeExceeded{from: router, dst: dst, echoID: 4000, seq: 7}, *exceeded)
+	}
+
+	// Echo replies aren't time exceeded messages
+	reply := icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 1, Seq: 1}}
+	rawReply, err := reply.Marshal(nil)
+	require.NoError(t, err)
+	_, ok := parseTimeExceeded(&net.UDPAddr{IP: net.ParseIP("10.0.0.1")}, rawReply)
+	assert.False(t, ok)
+
+	// Only time exceeded messages quoting echo requests are proxied
+	_, ok = parseQuotedEcho([]byte{0x45, 0, 0, 20})
+	assert.False(t, ok)
+}
+
+func TestReturnTimeExceeded(t *testing.T) {
+	muxer := newMockMuxer(1)
+	responder := newPacketResponder(muxer, 0, packet.NewEncoder())
+	eyeball := netip.MustParseAddr("172.16.0.1")
+	flow := newICMPEchoFlow(eyeball, nil, nil, &socketTTL{}, responder, 40000, 12)
+
+	router := netip.MustParseAddr("10.0.0.254")
+	dst := netip.MustParseAddr("10.1.2.3")
+	require.NoError(t, flow.returnTimeExceeded(&timeExceeded{from: router, dst: dst, echoID: 40000, seq: 3}))
+
+	pk, err := packet.NewICMPDecoder().Decode(packet.RawPacket{Data: (<-muxer.cfdToEdge).Payload()})
+	require.NoError(t, err)
+	assert.Equal(t, router, pk.Src)
+	assert.Equal(t, eyeball, pk.Dst)
+	assert.Equal(t, ipv4.ICMPTypeTimeExceeded, pk.Type)
+
+	// The quoted request is the one sent by the eyeball, with its original echo ID
+	body, ok := pk.Body.(*icmp.TimeExceeded)
+	require.True(t, ok)
+	quoted, ok := parseQuotedEcho(body.Data)
+	require.True(t, ok)
+	assert.Equal(t, timeExceeded{dst: dst, echoID: 12, seq: 3}, *quoted)
+}
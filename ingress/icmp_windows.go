@@ -1,12 +1,7 @@
-//go:build windows && cgo
+//go:build windows
 
 package ingress
 
-/*
-#include <iphlpapi.h>
-#include <icmpapi.h>
-*/
-import "C"
 import (
 	"context"
 	"encoding/binary"
@@ -34,6 +29,8 @@ const (
 	AF_INET6          = 23
 	icmpEchoReplyCode = 0
 	nullParameter     = uintptr(0)
+	// The ICMP helper API sends echo requests without raw sockets, so it doesn't need administrator privileges
+	icmpBackend = "icmp_send_echo"
 )
 
 var (
@@ -224,6 +221,9 @@ type icmpProxy struct {
 }
 
 func newICMPProxy(listenIP netip.Addr, logger *zerolog.Logger, idleTimeout time.Duration) (*icmpProxy, error) {
+	if err := checkICMPHelperAPI(listenIP); err != nil {
+		return nil, err
+	}
 	var (
 		srcSocketAddr *sockAddrIn6
 		handle        uintptr
@@ -250,6 +250,21 @@ func newICMPProxy(listenIP netip.Addr, logger *zerolog.Logger, idleTimeout time.
 	}, nil
 }
 
+// checkICMPHelperAPI returns an error if the procedures to send ICMP echo requests for the IP version of listenIP
+// can't be loaded, e.g. on stripped down Windows images.
+func checkICMPHelperAPI(listenIP netip.Addr) error {
+	procs := []*syscall.LazyProc{IcmpCreateFile_proc, IcmpSendEcho_proc}
+	if listenIP.Is6() {
+		procs = []*syscall.LazyProc{Icmp6CreateFile_proc, Icmp6SendEcho_proc}
+	}
+	for _, proc := range procs {
+		if err := proc.Find(); err != nil {
+			return errors.Wrap(err, "the ICMP helper API is not available")
+		}
+	}
+	return nil
+}
+
 func (ip *icmpProxy) Serve(ctx context.Context) error {
 	<-ctx.Done()
 	syscall.CloseHandle(syscall.Handle(ip.handle))
@@ -3,6 +3,7 @@ package ingress
 import (
 	"fmt"
 	"net"
+	"net/netip"
 	"net/url"
 	"regexp"
 	"strconv"
@@ -15,7 +16,9 @@ import (
 
 	"github.com/cloudflare/cloudflared/config"
 	"github.com/cloudflare/cloudflared/ingress/middleware"
+	"github.com/cloudflare/cloudflared/inspect"
 	"github.com/cloudflare/cloudflared/ipaccess"
+	"github.com/cloudflare/cloudflared/spnego"
 )
 
 var (
@@ -31,6 +34,36 @@ const (
 	ServiceBastion     = "bastion"
 	ServiceSocksProxy  = "socks-proxy"
 	ServiceWarpRouting = "warp-routing"
+	// ServiceLoadBalancer balances requests across the origins listed in originRequest.loadBalancer
+	ServiceLoadBalancer = "load_balancer"
+	// ServiceCanary splits requests between the primary and canary origins of originRequest.canary
+	ServiceCanary = "canary"
+)
+
+const (
+	// StreamingModeAuto flushes responses according to their headers and the streaming settings of the rule
+	StreamingModeAuto = "auto"
+	// StreamingModeStream flushes every write of the response body, regardless of its headers
+	StreamingModeStream = "stream"
+)
+
+const (
+	// PriorityNormal rules are proxied whatever the load of the connector
+	PriorityNormal = "normal"
+	// PriorityLow rules are answered with 503 while the connector sheds load
+	PriorityLow = "low"
+)
+
+const (
+	// RateLimitKeyIP limits the requests of each eyeball IP
+	RateLimitKeyIP = "ip"
+	// RateLimitKeyHeader limits the requests by the value of a request header
+	RateLimitKeyHeader = "header"
+)
+
+const (
+	// OriginCompressionGzip is the only content coding supported by originCompression
+	OriginCompressionGzip = "gzip"
 )
 
 // FindMatchingRule returns the index of the Ingress Rule which matches the given
@@ -52,7 +85,7 @@ func (ing Ingress) FindMatchingRule(hostname, path string) (*Rule, int) {
 		}
 	}
 	for i, rule := range ing.Rules {
-		if rule.Matches(hostname, path) {
+		if !rule.isUDP() && rule.Matches(hostname, path) {
 			return &rule, i
 		}
 	}
@@ -242,120 +275,414 @@ func validateAccessConfiguration(cfg *config.AccessConfig) error {
 	return nil
 }
 
+func validateStreamingConfiguration(cfg OriginRequestConfig) error {
+	switch cfg.Streaming.Mode {
+	case "", StreamingModeAuto:
+	case StreamingModeStream:
+		if cfg.Cache.Enabled {
+			return errors.New("responses can't be cached in stream mode")
+		}
+	default:
+		return fmt.Errorf("invalid streaming mode %q, expected auto or stream", cfg.Streaming.Mode)
+	}
+	if cfg.Streaming.FlushInterval.Duration < 0 {
+		return errors.New("streaming.flushInterval can't be negative")
+	}
+	return nil
+}
+
+func validateRateLimitConfiguration(cfg config.RateLimitConfig) error {
+	if cfg.RequestsPerSecond < 0 {
+		return errors.New("rateLimit.requestsPerSecond can't be negative")
+	}
+	switch cfg.Key {
+	case "", RateLimitKeyIP:
+		if cfg.Header != "" {
+			return errors.New("rateLimit.header requires rateLimit.key to be header")
+		}
+	case RateLimitKeyHeader:
+		if cfg.Header == "" {
+			return errors.New("rateLimit.header is required to limit requests by header")
+		}
+	default:
+		return fmt.Errorf("invalid rateLimit.key %q, expected ip or header", cfg.Key)
+	}
+	return nil
+}
+
+func validateOriginCompressionConfiguration(cfg config.OriginCompressionConfig) error {
+	for _, encoding := range cfg.Encodings {
+		if encoding != OriginCompressionGzip {
+			return fmt.Errorf("invalid originCompression encoding %q, expected gzip", encoding)
+		}
+	}
+	if cfg.Level < 0 || cfg.Level > 9 {
+		return errors.New("originCompression.level must be between 1 and 9")
+	}
+	if cfg.MinSize < 0 {
+		return errors.New("originCompression.minSize can't be negative")
+	}
+	return nil
+}
+
+func validateMaintenanceConfiguration(cfg config.MaintenanceConfig) error {
+	if cfg.Status != 0 && (cfg.Status < 200 || cfg.Status > 599) {
+		return fmt.Errorf("invalid maintenance.status %d, expected a status code between 200 and 599", cfg.Status)
+	}
+	if cfg.RetryAfter.Duration < 0 {
+		return errors.New("maintenance.retryAfter can't be negative")
+	}
+	return nil
+}
+
+func validateMirrorConfiguration(cfg config.MirrorConfig) error {
+	if cfg.Origin == "" {
+		return nil
+	}
+	u, err := url.Parse(cfg.Origin)
+	if err != nil {
+		return errors.Wrap(err, "invalid mirror.origin")
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid mirror.origin %q, expected an http:// or https:// URL", cfg.Origin)
+	}
+	if cfg.Percent <= 0 || cfg.Percent > 100 {
+		return fmt.Errorf("invalid mirror.percent %v, expected a percentage above 0 and up to 100", cfg.Percent)
+	}
+	if cfg.Timeout.Duration < 0 {
+		return errors.New("mirror.timeout can't be negative")
+	}
+	if cfg.MaxBodySize < 0 {
+		return errors.New("mirror.maxBodySize can't be negative")
+	}
+	return nil
+}
+
+func validateHedgingConfiguration(cfg config.HedgingConfig) error {
+	if cfg.Delay.Duration < 0 {
+		return errors.New("hedging.delay can't be negative")
+	}
+	if cfg.BudgetPercent > 100 {
+		return fmt.Errorf("invalid hedging.budgetPercent %d, expected a percentage up to 100", cfg.BudgetPercent)
+	}
+	return nil
+}
+
+func validateRequestLimitsConfiguration(cfg config.RequestLimitsConfig) error {
+	if cfg.MaxBodySize < 0 {
+		return errors.New("requestLimits.maxBodySize can't be negative")
+	}
+	if cfg.MaxHeaderSize < 0 {
+		return errors.New("requestLimits.maxHeaderSize can't be negative")
+	}
+	if cfg.ReadBodyTimeout.Duration < 0 || cfg.ReadBodyIdleTimeout.Duration < 0 {
+		return errors.New("requestLimits timeouts can't be negative")
+	}
+	return nil
+}
+
+func validateSSHJumpConfiguration(cfg OriginRequestConfig) error {
+	if cfg.SSHJump == (config.SSHJumpConfig{}) {
+		return nil
+	}
+	if cfg.SSHJump.Host == "" {
+		return errors.New("sshJump.host is required")
+	}
+	if cfg.SSHJump.User == "" {
+		return errors.New("sshJump.user is required")
+	}
+	if cfg.SSHJump.KeyFile == "" && !cfg.SSHJump.Agent {
+		return errors.New("sshJump needs a keyFile or the agent to authenticate")
+	}
+	if cfg.Dialer != "" {
+		return errors.New("sshJump can't be used with a dialer")
+	}
+	return nil
+}
+
+func validateSPNEGOConfiguration(cfg config.SPNEGOConfig) error {
+	if !cfg.Enabled() {
+		if cfg.SPN != "" || len(cfg.RefreshCommand) > 0 {
+			return errors.New("spnego needs a provider or a tokenCommand")
+		}
+		return nil
+	}
+	if cfg.Provider != "" {
+		if len(cfg.TokenCommand) > 0 {
+			return errors.New("spnego.provider and spnego.tokenCommand can't be used together")
+		}
+		if _, err := spnego.Lookup(cfg.Provider); err != nil {
+			return err
+		}
+	}
+	if cfg.RefreshInterval.Duration < 0 {
+		return errors.New("spnego.refreshInterval can't be negative")
+	}
+	return nil
+}
+
+func validateTrustOnFirstUseConfiguration(cfg OriginRequestConfig) error {
+	if !cfg.TrustOnFirstUse.Enabled {
+		if cfg.TrustOnFirstUse != (config.TrustOnFirstUseConfig{}) {
+			return errors.New("trustOnFirstUse.file and trustOnFirstUse.repinOnChange need trustOnFirstUse.enabled")
+		}
+		return nil
+	}
+	if cfg.NoTLSVerify {
+		return errors.New("trustOnFirstUse can't be used with noTLSVerify")
+	}
+	return nil
+}
+
+func validateWebSocketConfiguration(cfg config.WebSocketConfig) error {
+	if cfg.IdleTimeout.Duration < 0 {
+		return errors.New("websocket.idleTimeout can't be negative")
+	}
+	return nil
+}
+
 func validateIngress(ingress []config.UnvalidatedIngressRule, defaults OriginRequestConfig) (Ingress, error) {
 	rules := make([]Rule, len(ingress))
+	udpAddresses := make(map[netip.AddrPort]int)
 	for i, r := range ingress {
-		cfg := setConfig(defaults, r.OriginRequest)
-		var service OriginService
-
-		if prefix := "unix:"; strings.HasPrefix(r.Service, prefix) {
-			// No validation necessary for unix socket filepath services
-			path := strings.TrimPrefix(r.Service, prefix)
-			service = &unixSocketPath{path: path, scheme: "http"}
-		} else if prefix := "unix+tls:"; strings.HasPrefix(r.Service, prefix) {
-			path := strings.TrimPrefix(r.Service, prefix)
-			service = &unixSocketPath{path: path, scheme: "https"}
-		} else if prefix := "http_status:"; strings.HasPrefix(r.Service, prefix) {
-			statusCode, err := strconv.Atoi(strings.TrimPrefix(r.Service, prefix))
-			if err != nil {
-				return Ingress{}, errors.Wrap(err, "invalid HTTP status code")
-			}
-			if statusCode < 100 || statusCode > 999 {
-				return Ingress{}, fmt.Errorf("invalid HTTP status code: %d", statusCode)
-			}
-			srv := newStatusCode(statusCode)
-			service = &srv
-		} else if r.Service == HelloWorldFlag || r.Service == HelloWorldService {
-			service = new(helloWorld)
-		} else if r.Service == ServiceSocksProxy {
-			rules := make([]ipaccess.Rule, len(r.OriginRequest.IPRules))
-
-			for i, ipRule := range r.OriginRequest.IPRules {
-				rule, err := ipaccess.NewRuleByCIDR(ipRule.Prefix, ipRule.Ports, ipRule.Allow)
-				if err != nil {
-					return Ingress{}, fmt.Errorf("unable to create ip rule for %s: %s", r.Service, err)
-				}
-				rules[i] = rule
-			}
+		rule, err := validateRule(r, i, len(ingress), defaults, udpAddresses)
+		if err != nil {
+			return Ingress{}, err
+		}
+		rules[i] = rule
+	}
+	return Ingress{Rules: rules, Defaults: defaults}, nil
+}
 
-			accessPolicy, err := ipaccess.NewPolicy(false, rules)
+// validateRule validates the rule at index i of totalRules rules. udpAddresses holds the addresses of the udp services
+// of the previous rules, by rule index.
+func validateRule(r config.UnvalidatedIngressRule, i, totalRules int, defaults OriginRequestConfig, udpAddresses map[netip.AddrPort]int) (Rule, error) {
+	cfg := setConfig(defaults, r.OriginRequest)
+	var service OriginService
+
+	if unixSocket, ok := parseUnixSocketService(r.Service); ok {
+		// The socket is validated when the origin is started, so that the configuration can be parsed elsewhere
+		service = unixSocket
+	} else if fastCGI, ok := parseFastCGIUnixService(r.Service); ok {
+		if err := validateFastCGIConfiguration(cfg.FastCGI); err != nil {
+			return Rule{}, invalidField("originRequest.fastcgi", errors.Wrapf(err, "Rule #%d has an invalid fastcgi configuration", i+1))
+		}
+		service = fastCGI
+	} else if static, ok := parseStaticService(r.Service); ok {
+		if err := validateStaticConfiguration(cfg.Static); err != nil {
+			return Rule{}, invalidField("originRequest.static", errors.Wrapf(err, "Rule #%d has an invalid static configuration", i+1))
+		}
+		service = static
+	} else if prefix := "http_status:"; strings.HasPrefix(r.Service, prefix) {
+		statusCode, err := strconv.Atoi(strings.TrimPrefix(r.Service, prefix))
+		if err != nil {
+			return Rule{}, invalidField("service", errors.Wrap(err, "invalid HTTP status code"))
+		}
+		if statusCode < 100 || statusCode > 999 {
+			return Rule{}, invalidField("service", fmt.Errorf("invalid HTTP status code: %d", statusCode))
+		}
+		srv := newStatusCode(statusCode)
+		service = &srv
+	} else if r.Service == HelloWorldFlag || r.Service == HelloWorldService {
+		service = new(helloWorld)
+	} else if r.Service == ServiceSocksProxy {
+		rules := make([]ipaccess.Rule, len(r.OriginRequest.IPRules))
+
+		for i, ipRule := range r.OriginRequest.IPRules {
+			rule, err := ipaccess.NewRuleByCIDR(ipRule.Prefix, ipRule.Ports, ipRule.Allow)
 			if err != nil {
-				return Ingress{}, fmt.Errorf("unable to create ip access policy for %s: %s", r.Service, err)
+				return Rule{}, invalidField("originRequest.ipRules", fmt.Errorf("unable to create ip rule for %s: %s", r.Service, err))
 			}
+			rules[i] = rule
+		}
 
-			service = newSocksProxyOverWSService(accessPolicy)
-		} else if r.Service == ServiceBastion || cfg.BastionMode {
-			// Bastion mode will always start a Websocket proxy server, which will
-			// overwrite the localService.URL field when `start` is called. So,
-			// leave the URL field empty for now.
-			cfg.BastionMode = true
-			service = newBastionService()
-		} else {
-			// Validate URL services
-			u, err := url.Parse(r.Service)
-			if err != nil {
-				return Ingress{}, err
-			}
+		accessPolicy, err := ipaccess.NewPolicy(false, rules)
+		if err != nil {
+			return Rule{}, invalidField("originRequest.ipRules", fmt.Errorf("unable to create ip access policy for %s: %s", r.Service, err))
+		}
 
-			if u.Scheme == "" || u.Hostname() == "" {
-				return Ingress{}, fmt.Errorf("%s is an invalid address, please make sure it has a scheme and a hostname", r.Service)
-			}
+		service = newSocksProxyOverWSService(accessPolicy)
+	} else if r.Service == ServiceLoadBalancer {
+		lb, err := newLoadBalancerService(cfg.LoadBalancer)
+		if err != nil {
+			return Rule{}, invalidField("originRequest.loadBalancer", errors.Wrapf(err, "Rule #%d has an invalid load_balancer service", i+1))
+		}
+		service = lb
+	} else if r.Service == ServiceCanary {
+		canary, err := newCanaryService(cfg.Canary)
+		if err != nil {
+			return Rule{}, invalidField("originRequest.canary", errors.Wrapf(err, "Rule #%d has an invalid canary service", i+1))
+		}
+		service = canary
+	} else if r.Service == ServiceBastion || cfg.BastionMode {
+		// Bastion mode will always start a Websocket proxy server, which will
+		// overwrite the localService.URL field when `start` is called. So,
+		// leave the URL field empty for now.
+		cfg.BastionMode = true
+		service = newBastionService()
+	} else {
+		// Validate URL services
+		u, err := url.Parse(r.Service)
+		if err != nil {
+			return Rule{}, invalidField("service", err)
+		}
 
-			if u.Path != "" {
-				return Ingress{}, fmt.Errorf("%s is an invalid address, ingress rules don't support proxying to a different path on the origin service. The path will be the same as the eyeball request's path", r.Service)
-			}
-			if isHTTPService(u) {
-				service = &httpService{url: u}
-			} else {
-				service = newTCPOverWSService(u)
-			}
+		if u.Scheme == "" || u.Hostname() == "" {
+			return Rule{}, invalidField("service", fmt.Errorf("%s is an invalid address, please make sure it has a scheme and a hostname", r.Service))
 		}
 
-		var handlers []middleware.Handler
-		if access := r.OriginRequest.Access; access != nil {
-			if err := validateAccessConfiguration(access); err != nil {
-				return Ingress{}, err
+		if u.Path != "" {
+			return Rule{}, invalidField("service", fmt.Errorf("%s is an invalid address, ingress rules don't support proxying to a different path on the origin service. The path will be the same as the eyeball request's path", r.Service))
+		}
+		if isHTTPService(u) || isH2CService(u) {
+			service = &httpService{url: u}
+		} else if isGRPCService(u) {
+			service = &grpcService{url: u}
+		} else if isFastCGIService(u) {
+			if err := validateFastCGIConfiguration(cfg.FastCGI); err != nil {
+				return Rule{}, invalidField("originRequest.fastcgi", errors.Wrapf(err, "Rule #%d has an invalid fastcgi configuration", i+1))
 			}
-			if access.Required {
-				verifier := middleware.NewJWTValidator(access.TeamName, "", access.AudTag)
-				handlers = append(handlers, verifier)
+			service = &fastCGIService{url: u}
+		} else if isUDPService(u) {
+			udp, err := newUDPService(u, cfg.UDP)
+			if err != nil {
+				return Rule{}, invalidField("service", errors.Wrapf(err, "Rule #%d has an invalid udp service", i+1))
+			}
+			if prior, ok := udpAddresses[udp.address]; ok {
+				return Rule{}, invalidField("service", fmt.Errorf("Rules #%d and #%d are both udp services for %s", prior+1, i+1, udp.address))
 			}
+			udpAddresses[udp.address] = i
+			service = udp
+		} else {
+			service = newTCPOverWSService(u)
 		}
+	}
 
-		if err := validateHostname(r, i, len(ingress)); err != nil {
-			return Ingress{}, err
+	var handlers []middleware.Handler
+	if access := r.OriginRequest.Access; access != nil {
+		if err := validateAccessConfiguration(access); err != nil {
+			return Rule{}, invalidField("originRequest.access", err)
+		}
+		if access.Required {
+			verifier := middleware.NewJWTValidator(access.TeamName, "", access.AudTag)
+			handlers = append(handlers, verifier)
 		}
+	}
+	if len(cfg.IPAccess.Allow) > 0 || len(cfg.IPAccess.Deny) > 0 {
+		ipAccessList, err := middleware.NewIPAccessList(cfg.IPAccess)
+		if err != nil {
+			return Rule{}, invalidField("originRequest.ipAccess", errors.Wrapf(err, "Rule #%d has an invalid ipAccess configuration", i+1))
+		}
+		// Eyeballs are filtered before their token is checked
+		handlers = append([]middleware.Handler{ipAccessList}, handlers...)
+	}
 
-		isCatchAllRule := (r.Hostname == "" || r.Hostname == "*") && r.Path == ""
-		punycodeHostname := ""
-		if !isCatchAllRule {
-			punycode, err := idna.Lookup.ToASCII(r.Hostname)
-			// Don't provide the punycode hostname if it is the same as the original hostname
-			if err == nil && punycode != r.Hostname {
-				punycodeHostname = punycode
-			}
+	if _, isUDP := service.(*udpService); isUDP {
+		// UDP services are matched by the address of the sessions, they can't be the catch-all HTTP rule
+		if r.Hostname != "" || r.Path != "" {
+			return Rule{}, invalidField("hostname", fmt.Errorf("Rule #%d is a udp service, which can't match a hostname or path", i+1))
 		}
+		if i == totalRules-1 {
+			return Rule{}, invalidField("hostname", errLastRuleNotCatchAll)
+		}
+	} else if err := validateHostname(r, i, totalRules); err != nil {
+		return Rule{}, invalidField("hostname", err)
+	}
 
-		var pathRegexp *Regexp
-		if r.Path != "" {
-			var err error
-			regex, err := regexp.Compile(r.Path)
-			if err != nil {
-				return Ingress{}, errors.Wrapf(err, "Rule #%d has an invalid regex", i+1)
-			}
-			pathRegexp = &Regexp{Regexp: regex}
+	if err := validateStreamingConfiguration(cfg); err != nil {
+		return Rule{}, invalidField("originRequest.streaming", errors.Wrapf(err, "Rule #%d has an invalid streaming configuration", i+1))
+	}
+
+	if err := validateRateLimitConfiguration(cfg.RateLimit); err != nil {
+		return Rule{}, invalidField("originRequest.rateLimit", errors.Wrapf(err, "Rule #%d has an invalid rate limit configuration", i+1))
+	}
+
+	if err := validateOriginCompressionConfiguration(cfg.OriginCompression); err != nil {
+		return Rule{}, invalidField("originRequest.originCompression", errors.Wrapf(err, "Rule #%d has an invalid origin compression configuration", i+1))
+	}
+
+	if err := validateMaintenanceConfiguration(cfg.Maintenance); err != nil {
+		return Rule{}, invalidField("originRequest.maintenance", errors.Wrapf(err, "Rule #%d has an invalid maintenance configuration", i+1))
+	}
+
+	if err := validateMirrorConfiguration(cfg.Mirror); err != nil {
+		return Rule{}, invalidField("originRequest.mirror", errors.Wrapf(err, "Rule #%d has an invalid mirror configuration", i+1))
+	}
+
+	if err := validateHedgingConfiguration(cfg.Hedging); err != nil {
+		return Rule{}, invalidField("originRequest.hedging", errors.Wrapf(err, "Rule #%d has an invalid hedging configuration", i+1))
+	}
+
+	if err := validateRequestLimitsConfiguration(cfg.RequestLimits); err != nil {
+		return Rule{}, invalidField("originRequest.requestLimits", errors.Wrapf(err, "Rule #%d has an invalid request limits configuration", i+1))
+	}
+
+	if err := validateProxyProtocolConfiguration(cfg); err != nil {
+		return Rule{}, invalidField("originRequest.proxyProtocol", errors.Wrapf(err, "Rule #%d has an invalid proxyProtocol configuration", i+1))
+	}
+
+	if err := validateWebSocketConfiguration(cfg.WebSocket); err != nil {
+		return Rule{}, invalidField("originRequest.websocket", errors.Wrapf(err, "Rule #%d has an invalid websocket configuration", i+1))
+	}
+
+	if cfg.Dialer != "" {
+		if _, err := LookupDialer(cfg.Dialer); err != nil {
+			return Rule{}, invalidField("originRequest.dialer", errors.Wrapf(err, "Rule #%d has an invalid dialer", i+1))
 		}
+	}
+
+	if err := validateSPNEGOConfiguration(cfg.SPNEGO); err != nil {
+		return Rule{}, invalidField("originRequest.spnego", errors.Wrapf(err, "Rule #%d has an invalid spnego configuration", i+1))
+	}
 
-		rules[i] = Rule{
-			Hostname:         r.Hostname,
-			punycodeHostname: punycodeHostname,
-			Service:          service,
-			Path:             pathRegexp,
-			Handlers:         handlers,
-			Config:           cfg,
+	if err := validateSSHJumpConfiguration(cfg); err != nil {
+		return Rule{}, invalidField("originRequest.sshJump", errors.Wrapf(err, "Rule #%d has an invalid sshJump configuration", i+1))
+	}
+
+	if err := validateTrustOnFirstUseConfiguration(cfg); err != nil {
+		return Rule{}, invalidField("originRequest.trustOnFirstUse", errors.Wrapf(err, "Rule #%d has an invalid trustOnFirstUse configuration", i+1))
+	}
+
+	switch cfg.Priority {
+	case "", PriorityNormal, PriorityLow:
+	default:
+		return Rule{}, invalidField("originRequest.priority", fmt.Errorf("Rule #%d has an invalid priority %q, expected normal or low", i+1, cfg.Priority))
+	}
+
+	for _, name := range cfg.Inspection.Inspectors {
+		if _, err := inspect.Lookup(name); err != nil {
+			return Rule{}, invalidField("originRequest.inspection", errors.Wrapf(err, "Rule #%d has an invalid inspection configuration", i+1))
 		}
 	}
-	return Ingress{Rules: rules, Defaults: defaults}, nil
+
+	isCatchAllRule := (r.Hostname == "" || r.Hostname == "*") && r.Path == ""
+	punycodeHostname := ""
+	if !isCatchAllRule {
+		punycode, err := idna.Lookup.ToASCII(r.Hostname)
+		// Don't provide the punycode hostname if it is the same as the original hostname
+		if err == nil && punycode != r.Hostname {
+			punycodeHostname = punycode
+		}
+	}
+
+	var pathRegexp *Regexp
+	if r.Path != "" {
+		var err error
+		regex, err := regexp.Compile(r.Path)
+		if err != nil {
+			return Rule{}, invalidField("path", errors.Wrapf(err, "Rule #%d has an invalid regex", i+1))
+		}
+		pathRegexp = &Regexp{Regexp: regex}
+	}
+
+	return Rule{
+		Hostname:         r.Hostname,
+		punycodeHostname: punycodeHostname,
+		Service:          service,
+		Path:             pathRegexp,
+		Handlers:         handlers,
+		Config:           cfg,
+	}, nil
 }
 
 func validateHostname(r config.UnvalidatedIngressRule, ruleIndex, totalRules int) error {
@@ -397,3 +724,8 @@ func (e ruleShouldNotBeCatchAllError) Error() string {
 func isHTTPService(url *url.URL) bool {
 	return url.Scheme == "http" || url.Scheme == "https" || url.Scheme == "ws" || url.Scheme == "wss"
 }
+
+// isH2CService returns whether the origin speaks cleartext HTTP/2 with prior knowledge.
+func isH2CService(url *url.URL) bool {
+	return url.Scheme == "h2c"
+}
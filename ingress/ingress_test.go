@@ -832,6 +832,135 @@ func TestParseAccessConfig(t *testing.T) {
 	}
 }
 
+func TestParseStreamingConfig(t *testing.T) {
+	ing, err := ParseIngress(MustReadIngress(`
+ingress:
+  - hostname: events.example.com
+    service: http://localhost:8000
+    originRequest:
+      streaming:
+        mode: stream
+  - service: http://localhost:8001
+    originRequest:
+      streaming:
+        flushInterval: 100ms
+        maxBufferedBytes: 65536
+`))
+	require.NoError(t, err)
+	assert.Equal(t, StreamingModeStream, ing.Rules[0].Config.Streaming.Mode)
+	assert.Equal(t, 100*time.Millisecond, ing.Rules[1].Config.Streaming.FlushInterval.Duration)
+	assert.Equal(t, uint64(65536), ing.Rules[1].Config.Streaming.MaxBufferedBytes)
+
+	_, err = ParseIngress(MustReadIngress(`
+ingress:
+  - service: http://localhost:8000
+    originRequest:
+      streaming:
+        mode: buffered
+`))
+	require.Error(t, err)
+
+	// Cached responses are buffered entirely, which defeats stream mode
+	_, err = ParseIngress(MustReadIngress(`
+ingress:
+  - service: http://localhost:8000
+    originRequest:
+      cache:
+        enabled: true
+      streaming:
+        mode: stream
+`))
+	require.Error(t, err)
+}
+
+func TestParseIPAccessConfig(t *testing.T) {
+	ing, err := ParseIngress(MustReadIngress(`
+originRequest:
+  ipAccess:
+    allow: [10.0.0.0/8]
+ingress:
+  - hostname: internal.example.com
+    service: http://localhost:8000
+    originRequest:
+      access:
+        required: true
+        teamName: team
+  - hostname: public.example.com
+    service: http://localhost:8001
+    originRequest:
+      ipAccess:
+        deny: [192.0.2.0/24]
+  - service: http_status:404
+`))
+	require.NoError(t, err)
+	// The ACL of the defaults applies to all the rules, before the Access token is validated
+	require.Len(t, ing.Rules[0].Handlers, 2)
+	assert.Equal(t, "IPAccessList", ing.Rules[0].Handlers[0].Name())
+	assert.Equal(t, []string{"10.0.0.0/8"}, ing.Rules[0].Config.IPAccess.Allow)
+	assert.Equal(t, []string{"192.0.2.0/24"}, ing.Rules[1].Config.IPAccess.Deny)
+	require.Len(t, ing.Rules[2].Handlers, 1)
+
+	_, err = ParseIngress(MustReadIngress(`
+ingress:
+  - service: http://localhost:8000
+    originRequest:
+      ipAccess:
+        allow: [10.0.0.300/8]
+`))
+	require.Error(t, err)
+}
+
+func TestValidateRateLimitConfiguration(t *testing.T) {
+	require.NoError(t, validateRateLimitConfiguration(config.RateLimitConfig{}))
+	require.NoError(t, validateRateLimitConfiguration(config.RateLimitConfig{RequestsPerSecond: 10, Key: RateLimitKeyIP}))
+	require.NoError(t, validateRateLimitConfiguration(config.RateLimitConfig{RequestsPerSecond: 10, Key: RateLimitKeyHeader, Header: "X-Api-Key"}))
+	require.Error(t, validateRateLimitConfiguration(config.RateLimitConfig{RequestsPerSecond: -1}))
+	require.Error(t, validateRateLimitConfiguration(config.RateLimitConfig{RequestsPerSecond: 10, Key: RateLimitKeyHeader}))
+	require.Error(t, validateRateLimitConfiguration(config.RateLimitConfig{RequestsPerSecond: 10, Header: "X-Api-Key"}))
+	require.Error(t, validateRateLimitConfiguration(config.RateLimitConfig{RequestsPerSecond: 10, Key: "path"}))
+}
+
+func TestValidateMaintenanceConfiguration(t *testing.T) {
+	require.NoError(t, validateMaintenanceConfiguration(config.MaintenanceConfig{}))
+	require.NoError(t, validateMaintenanceConfiguration(config.MaintenanceConfig{Enabled: true, Status: 200, RetryAfter: config.CustomDuration{Duration: time.Minute}}))
+	require.Error(t, validateMaintenanceConfiguration(config.MaintenanceConfig{Status: 99}))
+	require.Error(t, validateMaintenanceConfiguration(config.MaintenanceConfig{Status: 600}))
+	require.Error(t, validateMaintenanceConfiguration(config.MaintenanceConfig{RetryAfter: config.CustomDuration{Duration: -time.Second}}))
+}
+
+func TestValidateMirrorConfiguration(t *testing.T) {
+	require.NoError(t, validateMirrorConfiguration(config.MirrorConfig{}))
+	require.NoError(t, validateMirrorConfiguration(config.MirrorConfig{Origin: "https://shadow.example.com", Percent: 100}))
+	require.NoError(t, validateMirrorConfiguration(config.MirrorConfig{Origin: "http://localhost:8080", Percent: 0.5}))
+	require.Error(t, validateMirrorConfiguration(config.MirrorConfig{Origin: "tcp://localhost:8080", Percent: 10}))
+	require.Error(t, validateMirrorConfiguration(config.MirrorConfig{Origin: "http://localhost:8080"}))
+	require.Error(t, validateMirrorConfiguration(config.MirrorConfig{Origin: "http://localhost:8080", Percent: 101}))
+	require.Error(t, validateMirrorConfiguration(config.MirrorConfig{Origin: "http://localhost:8080", Percent: 10, MaxBodySize: -1}))
+}
+
+func TestValidateHedgingConfiguration(t *testing.T) {
+	require.NoError(t, validateHedgingConfiguration(config.HedgingConfig{}))
+	require.NoError(t, validateHedgingConfiguration(config.HedgingConfig{Delay: config.CustomDuration{Duration: 50 * time.Millisecond}, BudgetPercent: 5}))
+	require.Error(t, validateHedgingConfiguration(config.HedgingConfig{Delay: config.CustomDuration{Duration: -time.Second}}))
+	require.Error(t, validateHedgingConfiguration(config.HedgingConfig{BudgetPercent: 101}))
+}
+
+func TestValidateRequestLimitsConfiguration(t *testing.T) {
+	require.NoError(t, validateRequestLimitsConfiguration(config.RequestLimitsConfig{}))
+	require.NoError(t, validateRequestLimitsConfiguration(config.RequestLimitsConfig{MaxBodySize: 1 << 20, MaxHeaderSize: 8192, ReadBodyTimeout: config.CustomDuration{Duration: time.Minute}}))
+	require.Error(t, validateRequestLimitsConfiguration(config.RequestLimitsConfig{MaxBodySize: -1}))
+	require.Error(t, validateRequestLimitsConfiguration(config.RequestLimitsConfig{MaxHeaderSize: -1}))
+	require.Error(t, validateRequestLimitsConfiguration(config.RequestLimitsConfig{ReadBodyIdleTimeout: config.CustomDuration{Duration: -time.Second}}))
+}
+
+func TestValidateOriginCompressionConfiguration(t *testing.T) {
+	require.NoError(t, validateOriginCompressionConfiguration(config.OriginCompressionConfig{}))
+	require.NoError(t, validateOriginCompressionConfiguration(config.OriginCompressionConfig{Encodings: []string{"gzip"}, Level: 9}))
+	require.Error(t, validateOriginCompressionConfiguration(config.OriginCompressionConfig{Encodings: []string{"zstd"}}))
+	require.Error(t, validateOriginCompressionConfiguration(config.OriginCompressionConfig{Encodings: []string{"gzip"}, Level: 10}))
+	require.Error(t, validateOriginCompressionConfiguration(config.OriginCompressionConfig{Encodings: []string{"gzip"}, MinSize: -1}))
+}
+
 func MustReadIngress(s string) *config.Configuration {
 	var conf config.Configuration
 	err := yaml.Unmarshal([]byte(s), &conf)
@@ -840,3 +969,12 @@ func MustReadIngress(s string) *config.Configuration {
 	}
 	return &conf
 }
+
+func TestValidateSPNEGOConfiguration(t *testing.T) {
+	require.NoError(t, validateSPNEGOConfiguration(config.SPNEGOConfig{}))
+	require.NoError(t, validateSPNEGOConfiguration(config.SPNEGOConfig{TokenCommand: []string{"spnego-token"}, RefreshCommand: []string{"kinit", "-R"}}))
+	require.Error(t, validateSPNEGOConfiguration(config.SPNEGOConfig{SPN: "HTTP/iis.example.com"}))
+	require.Error(t, validateSPNEGOConfiguration(config.SPNEGOConfig{Provider: "unregistered"}))
+	require.Error(t, validateSPNEGOConfiguration(config.SPNEGOConfig{Provider: "krb5", TokenCommand: []string{"spnego-token"}}))
+	require.Error(t, validateSPNEGOConfiguration(config.SPNEGOConfig{TokenCommand: []string{"spnego-token"}, RefreshInterval: config.CustomDuration{Duration: -time.Minute}}))
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/ipaccess"
+)
+
+const headerKeyConnectingIP = "Cf-Connecting-Ip"
+
+// IPAccessList is an implementation of Handler that filters the requests by the IP of the eyeball, as reported by
+// the edge. Denied networks take precedence over allowed ones, and requests without eyeball IP are denied.
+type IPAccessList struct {
+	policy *ipaccess.Policy
+}
+
+func NewIPAccessList(cfg config.IPAccessConfig) (*IPAccessList, error) {
+	var rules []ipaccess.Rule
+	for _, list := range []struct {
+		prefixes []string
+		allow    bool
+	}{
+		// The first matching rule applies, so deny rules come first
+		{prefixes: cfg.Deny, allow: false},
+		{prefixes: cfg.Allow, allow: true},
+	} {
+		for _, prefix := range list.prefixes {
+			rule, err := ipaccess.NewRuleByCIDR(&prefix, nil, list.allow)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rule)
+		}
+	}
+	// Without allowed networks, all the networks that aren't denied are allowed
+	policy, err := ipaccess.NewPolicy(len(cfg.Allow) == 0, rules)
+	if err != nil {
+		return nil, err
+	}
+	return &IPAccessList{policy: policy}, nil
+}
+
+func (l *IPAccessList) Name() string {
+	return "IPAccessList"
+}
+
+func (l *IPAccessList) Handle(_ context.Context, r *http.Request) (*HandleResult, error) {
+	connectingIP := r.Header.Get(headerKeyConnectingIP)
+	ip := net.ParseIP(connectingIP)
+	if ip == nil {
+		return &HandleResult{
+			ShouldFilterRequest: true,
+			StatusCode:          http.StatusForbidden,
+			Reason:              fmt.Sprintf("eyeball IP %q is unknown", connectingIP),
+		}, nil
+	}
+	allowed, rule := l.policy.Allowed(ip, 0)
+	if allowed {
+		return &HandleResult{ShouldFilterRequest: false}, nil
+	}
+	reason := fmt.Sprintf("eyeball IP %s isn't in the allowed networks", ip)
+	if rule != nil {
+		reason = fmt.Sprintf("eyeball IP %s is in the denied network %s", ip, rule.StringCIDR())
+	}
+	return &HandleResult{
+		ShouldFilterRequest: true,
+		StatusCode:          http.StatusForbidden,
+		Reason:              reason,
+	}, nil
+}
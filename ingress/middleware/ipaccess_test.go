@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestIPAccessList(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.IPAccessConfig
+		ip       string
+		filtered bool
+	}{
+		{name: "allowed", cfg: config.IPAccessConfig{Allow: []string{"10.0.0.0/8"}}, ip: "10.1.2.3"},
+		{name: "not allowed", cfg: config.IPAccessConfig{Allow: []string{"10.0.0.0/8"}}, ip: "192.0.2.1", filtered: true},
+		{name: "allowed ipv6", cfg: config.IPAccessConfig{Allow: []string{"2001:db8::/32"}}, ip: "2001:db8::1"},
+		{name: "denied", cfg: config.IPAccessConfig{Deny: []string{"192.0.2.0/24"}}, ip: "192.0.2.1", filtered: true},
+		{name: "not denied", cfg: config.IPAccessConfig{Deny: []string{"192.0.2.0/24"}}, ip: "198.51.100.1"},
+		{
+			name:     "denied in allowed network",
+			cfg:      config.IPAccessConfig{Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.66.0.0/16"}},
+			ip:       "10.66.0.1",
+			filtered: true,
+		},
+		{name: "unknown ip", cfg: config.IPAccessConfig{Deny: []string{"192.0.2.0/24"}}, ip: "", filtered: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			list, err := NewIPAccessList(test.cfg)
+			require.NoError(t, err)
+			req := httptest.NewRequest("GET", "http://example.com", nil)
+			if test.ip != "" {
+				req.Header.Set(headerKeyConnectingIP, test.ip)
+			}
+			result, err := list.Handle(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, test.filtered, result.ShouldFilterRequest)
+			if test.filtered {
+				assert.Equal(t, http.StatusForbidden, result.StatusCode)
+				assert.Contains(t, result.Reason, test.ip)
+			}
+		})
+	}
+
+	_, err := NewIPAccessList(config.IPAccessConfig{Allow: []string{"10.0.0.1"}})
+	assert.Error(t, err)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 )
@@ -14,8 +15,25 @@ const (
 
 var (
 	cloudflareAccessCertsURL = "https://%s.cloudflareaccess.com"
+
+	// The public certs of the teams are fetched once and cached by their key set, which refreshes them when a token
+	// is signed by an unknown key. Key sets are shared by the validators of the same team, so they survive
+	// configuration updates.
+	keySets  = map[string]*oidc.RemoteKeySet{}
+	keySetsM sync.Mutex
 )
 
+func remoteKeySet(certsEndpoint string) *oidc.RemoteKeySet {
+	keySetsM.Lock()
+	defer keySetsM.Unlock()
+	keySet, ok := keySets[certsEndpoint]
+	if !ok {
+		keySet = oidc.NewRemoteKeySet(context.Background(), certsEndpoint)
+		keySets[certsEndpoint] = keySet
+	}
+	return keySet
+}
+
 // JWTValidator is an implementation of Verifier that validates access based JWT tokens.
 type JWTValidator struct {
 	*oidc.IDTokenVerifier
@@ -32,9 +50,7 @@ func NewJWTValidator(teamName string, certsURL string, audTags []string) *JWTVal
 		SkipClientIDCheck: true,
 	}
 
-	ctx := context.Background()
-	keySet := oidc.NewRemoteKeySet(ctx, certsEndpoint)
-	verifier := oidc.NewVerifier(certsURL, keySet, config)
+	verifier := oidc.NewVerifier(certsURL, remoteKeySet(certsEndpoint), config)
 	return &JWTValidator{
 		IDTokenVerifier: verifier,
 		audTags:         audTags,
@@ -59,7 +75,13 @@ func (v *JWTValidator) Handle(ctx context.Context, r *http.Request) (*HandleResu
 
 	token, err := v.IDTokenVerifier.Verify(ctx, accessJWT)
 	if err != nil {
-		return nil, err
+		// Tokens that are expired, not issued by the team or not signed by its keys are rejected like missing ones,
+		// instead of failing the request as if the origin was unreachable.
+		return &HandleResult{
+			ShouldFilterRequest: true,
+			StatusCode:          http.StatusForbidden,
+			Reason:              fmt.Sprintf("invalid access token: %v", err),
+		}, nil
 	}
 
 	// We want at least one audTag to match
@@ -8,6 +8,7 @@ import (
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
@@ -113,6 +114,64 @@ func TestJWTValidator(t *testing.T) {
 	}
 }
 
+func TestJWTValidatorRejectsInvalidTokens(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keySet := oidc.StaticKeySet{PublicKeys: []crypto.PublicKey{key.Public()}}
+	validator := JWTValidator{
+		IDTokenVerifier: oidc.NewVerifier(issuer, &keySet, &oidc.Config{
+			SkipClientIDCheck:    true,
+			SupportedSigningAlgs: []string{string(jose.ES256)},
+		}),
+		audTags: []string{"d7ec5b7fda23ffa8f8c8559fb37c66a2278208a78dbe376a3394b5ffec6911ba"},
+	}
+	validClaims := func() accessTokenClaims {
+		issued := time.Now()
+		return accessTokenClaims{
+			Claims: jwt.Claims{
+				Issuer:   issuer,
+				Audience: jwt.Audience(validator.audTags),
+				Expiry:   jwt.NewNumericDate(issued.Add(time.Hour)),
+				IssuedAt: jwt.NewNumericDate(issued),
+			},
+		}
+	}
+
+	expired := validClaims()
+	expired.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	otherTeam := validClaims()
+	otherTeam.Issuer = fmt.Sprintf(cloudflareAccessCertsURL, "otherteam")
+	tests := map[string]string{
+		"valid":       signToken(t, validClaims(), key),
+		"expired":     signToken(t, expired, key),
+		"other team":  signToken(t, otherTeam, key),
+		"unknown key": signToken(t, validClaims(), otherKey),
+		"not a token": "not-a-jwt",
+	}
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com", nil)
+			req.Header.Set(headerKeyAccessJWTAssertion, token)
+			result, err := validator.Handle(context.Background(), req)
+			require.NoError(t, err)
+			if name == "valid" {
+				assert.False(t, result.ShouldFilterRequest)
+				return
+			}
+			assert.True(t, result.ShouldFilterRequest)
+			assert.Equal(t, http.StatusForbidden, result.StatusCode)
+		})
+	}
+}
+
+func TestRemoteKeySetSharedByTeam(t *testing.T) {
+	certsEndpoint := fmt.Sprintf(cloudflareAccessCertsURL, "testteam") + "/cdn-cgi/access/certs"
+	assert.Same(t, remoteKeySet(certsEndpoint), remoteKeySet(certsEndpoint))
+	assert.NotSame(t, remoteKeySet(certsEndpoint), remoteKeySet(fmt.Sprintf(cloudflareAccessCertsURL, "otherteam")+"/cdn-cgi/access/certs"))
+}
+
 func signToken(t *testing.T, token accessTokenClaims, key *ecdsa.PrivateKey) string {
 	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, &jose.SignerOptions{})
 	require.NoError(t, err)
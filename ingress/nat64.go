@@ -0,0 +1,117 @@
+package ingress
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+// nat64DiscoveryName is the name whose AAAA records are synthesized by the DNS64 resolvers from its well-known IPv4
+// addresses, revealing the NAT64 prefix of the network. See RFC 7050.
+const nat64DiscoveryName = "ipv4only.arpa"
+
+var nat64DiscoveryAddrs = []netip.Addr{netip.MustParseAddr("192.0.0.170"), netip.MustParseAddr("192.0.0.171")}
+
+// nat64PrefixLengths are the lengths of the prefixes IPv4 addresses can be embedded in, see RFC 6052 section 2.2.
+var nat64PrefixLengths = []int{32, 40, 48, 56, 64, 96}
+
+// NAT64 embeds IPv4 addresses in an IPv6 prefix, as defined by RFC 6052, so that IPv6-only eyeballs can reach IPv4
+// origins. A nil NAT64 translates nothing.
+type NAT64 struct {
+	prefix netip.Prefix
+}
+
+// NewNAT64 returns the translation of the IPv4 addresses into prefix, in CIDR notation, or nil if prefix is empty.
+func NewNAT64(prefix string) (*NAT64, error) {
+	if prefix == "" {
+		return nil, nil
+	}
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NAT64 prefix: %w", err)
+	}
+	if !p.Addr().Is6() || p.Addr().Is4In6() {
+		return nil, fmt.Errorf("NAT64 prefix %s must be an IPv6 prefix", prefix)
+	}
+	if !slices.Contains(nat64PrefixLengths, p.Bits()) {
+		return nil, fmt.Errorf("NAT64 prefix %s must have a length of 32, 40, 48, 56, 64 or 96 bits", prefix)
+	}
+	return &NAT64{prefix: p.Masked()}, nil
+}
+
+// DiscoverNAT64 discovers the NAT64 prefix of the network from the AAAA records of ipv4only.arpa, as defined by
+// RFC 7050. lookup is typically net.DefaultResolver.LookupNetIP.
+func DiscoverNAT64(ctx context.Context, lookup func(ctx context.Context, network, host string) ([]netip.Addr, error)) (*NAT64, error) {
+	addrs, err := lookup(ctx, "ip6", nat64DiscoveryName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", nat64DiscoveryName, err)
+	}
+	for _, addr := range addrs {
+		if !addr.Is6() || addr.Is4In6() {
+			continue
+		}
+		// The longest prefix is by far the most common, try it first
+		for i := len(nat64PrefixLengths) - 1; i >= 0; i-- {
+			nat64 := &NAT64{prefix: netip.PrefixFrom(addr.WithZone(""), nat64PrefixLengths[i]).Masked()}
+			if ipv4, ok := nat64.Extract(addr); ok && slices.Contains(nat64DiscoveryAddrs, ipv4) {
+				return nat64, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no NAT64 prefix found in the AAAA records of %s", nat64DiscoveryName)
+}
+
+func (n *NAT64) String() string {
+	return n.prefix.String()
+}
+
+// Synthesize returns the IPv6 address embedding ipv4.
+func (n *NAT64) Synthesize(ipv4 netip.Addr) netip.Addr {
+	ip := n.prefix.Addr().As16()
+	i := n.prefix.Bits() / 8
+	for _, b := range ipv4.As4() {
+		// Bits 64 to 71 are reserved
+		if i == 8 {
+			i++
+		}
+		ip[i] = b
+		i++
+	}
+	return netip.AddrFrom16(ip)
+}
+
+// Map returns the address addr is reached through when it's an IPv4 address, or addr otherwise. It's used to dial
+// IPv4 origins from IPv6-only hosts.
+func (n *NAT64) Map(addr netip.AddrPort) netip.AddrPort {
+	if n == nil || !addr.Addr().Unmap().Is4() {
+		return addr
+	}
+	return netip.AddrPortFrom(n.Synthesize(addr.Addr().Unmap()), addr.Port())
+}
+
+// Extract returns the IPv4 address embedded in ipv6, if it belongs to the prefix.
+func (n *NAT64) Extract(ipv6 netip.Addr) (netip.Addr, bool) {
+	if n == nil || !ipv6.Is6() || ipv6.Is4In6() || !n.prefix.Contains(ipv6.WithZone("")) {
+		return netip.Addr{}, false
+	}
+	ip := ipv6.As16()
+	var ipv4 [4]byte
+	i := n.prefix.Bits() / 8
+	for j := range ipv4 {
+		if i == 8 {
+			i++
+		}
+		ipv4[j] = ip[i]
+		i++
+	}
+	return netip.AddrFrom4(ipv4), true
+}
+
+// Translate returns the address of the IPv4 origin of a flow to addr, or addr if it doesn't belong to the prefix.
+func (n *NAT64) Translate(addr netip.AddrPort) netip.AddrPort {
+	if ipv4, ok := n.Extract(addr.Addr()); ok {
+		return netip.AddrPortFrom(ipv4, addr.Port())
+	}
+	return addr
+}
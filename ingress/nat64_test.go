@@ -0,0 +1,139 @@
+package ingress
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNAT64(t *testing.T) {
+	nat64, err := NewNAT64("")
+	require.NoError(t, err)
+	assert.Nil(t, nat64)
+	addr := netip.MustParseAddrPort("[64:ff9b::c000:221]:53")
+	assert.Equal(t, addr, nat64.Translate(addr))
+
+	for _, invalid := range []string{"192.0.2.0/24", "64:ff9b::/80", "::ffff:0:0/96", "64:ff9b::"} {
+		_, err := NewNAT64(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	// Examples of RFC 6052 section 2.4
+	ipv4 := netip.MustParseAddr("192.0.2.33")
+	tests := []struct {
+		prefix   string
+		expected string
+	}{
+		{prefix: "2001:db8::/32", expected: "2001:db8:c000:221::"},
+		{prefix: "2001:db8:100::/40", expected: "2001:db8:1c0:2:21::"},
+		{prefix: "2001:db8:122::/48", expected: "2001:db8:122:c000:2:2100::"},
+		{prefix: "2001:db8:122:300::/56", expected: "2001:db8:122:3c0:0:221::"},
+		{prefix: "2001:db8:122:344::/64", expected: "2001:db8:122:344:c0:2:2100:0"},
+		{prefix: "2001:db8:122:344::/96", expected: "2001:db8:122:344::c000:221"},
+		{prefix: "64:ff9b::/96", expected: "64:ff9b::c000:221"},
+	}
+	for _, test := range tests {
+		nat64, err := NewNAT64(test.prefix)
+		require.NoError(t, err)
+		ipv6 := nat64.Synthesize(ipv4)
+		assert.Equal(t, netip.MustParseAddr(test.expected), ipv6, test.prefix)
+		extracted, ok := nat64.Extract(ipv6)
+		require.True(t, ok)
+		assert.Equal(t, ipv4, extracted)
+	}
+
+	nat64, err = NewNAT64("64:ff9b::/96")
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddrPort("192.0.2.33:443"), nat64.Translate(netip.MustParseAddrPort("[64:ff9b::c000:221]:443")))
+	// Addresses outside of the prefix are left as is
+	outside := netip.MustParseAddrPort("[2001:db8::1]:443")
+	assert.Equal(t, outside, nat64.Translate(outside))
+}
+
+type recordingDialer struct {
+	dialed []netip.AddrPort
+}
+
+func (d *recordingDialer) DialTCP(_ context.Context, addr netip.AddrPort) (net.Conn, error) {
+	d.dialed = append(d.dialed, addr)
+	return nil, nil
+}
+
+func (d *recordingDialer) DialUDP(addr netip.AddrPort) (net.Conn, error) {
+	d.dialed = append(d.dialed, addr)
+	return nil, nil
+}
+
+func TestOriginDialerNAT64(t *testing.T) {
+	nat64, err := NewNAT64("64:ff9b::/96")
+	require.NoError(t, err)
+	dialer := &recordingDialer{}
+	log := zerolog.Nop()
+	service := NewOriginDialer(OriginConfig{DefaultDialer: dialer, NAT64: nat64}, &log)
+
+	_, err = service.dialTCP(t.Context(), netip.MustParseAddrPort("[64:ff9b::a00:1]:443"))
+	require.NoError(t, err)
+	_, err = service.DialUDP(netip.MustParseAddrPort("[64:ff9b::a00:1]:53"))
+	require.NoError(t, err)
+	_, err = service.DialUDP(netip.MustParseAddrPort("[2001:db8::1]:53"))
+	require.NoError(t, err)
+	assert.Equal(t, []netip.AddrPort{
+		netip.MustParseAddrPort("10.0.0.1:443"),
+		netip.MustParseAddrPort("10.0.0.1:53"),
+		netip.MustParseAddrPort("[2001:db8::1]:53"),
+	}, dialer.dialed)
+}
+
+func TestDiscoverNAT64(t *testing.T) {
+	lookup := func(answer ...string) func(context.Context, string, string) ([]netip.Addr, error) {
+		return func(_ context.Context, network, host string) ([]netip.Addr, error) {
+			assert.Equal(t, "ip6", network)
+			assert.Equal(t, "ipv4only.arpa", host)
+			var addrs []netip.Addr
+			for _, a := range answer {
+				addrs = append(addrs, netip.MustParseAddr(a))
+			}
+			return addrs, nil
+		}
+	}
+
+	nat64, err := DiscoverNAT64(t.Context(), lookup("64:ff9b::c000:aa", "64:ff9b::c000:ab"))
+	require.NoError(t, err)
+	assert.Equal(t, "64:ff9b::/96", nat64.String())
+
+	nat64, err = DiscoverNAT64(t.Context(), lookup("2001:db8:122:344:c0:0:aa00:0"))
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8:122:344::/64", nat64.String())
+
+	// Without DNS64, ipv4only.arpa has no AAAA records
+	_, err = DiscoverNAT64(t.Context(), lookup())
+	assert.Error(t, err)
+	_, err = DiscoverNAT64(t.Context(), lookup("2001:db8::1"))
+	assert.Error(t, err)
+}
+
+func TestOriginDialerLocalNAT64(t *testing.T) {
+	localNAT64, err := NewNAT64("64:ff9b::/96")
+	require.NoError(t, err)
+	dialer := &recordingDialer{}
+	log := zerolog.Nop()
+	service := NewOriginDialer(OriginConfig{DefaultDialer: dialer, LocalNAT64: localNAT64}, &log)
+
+	_, err = service.dialTCP(t.Context(), netip.MustParseAddrPort("10.0.0.1:443"))
+	require.NoError(t, err)
+	_, err = service.DialUDP(netip.MustParseAddrPort("[::ffff:10.0.0.1]:53"))
+	require.NoError(t, err)
+	_, err = service.DialUDP(netip.MustParseAddrPort("[2001:db8::1]:53"))
+	require.NoError(t, err)
+	assert.Equal(t, []netip.AddrPort{
+		netip.MustParseAddrPort("[64:ff9b::a00:1]:443"),
+		netip.MustParseAddrPort("[64:ff9b::a00:1]:53"),
+		netip.MustParseAddrPort("[2001:db8::1]:53"),
+	}, dialer.dialed)
+}
@@ -0,0 +1,165 @@
+package ingress
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultNTLMAffinityIdleTimeout = 90 * time.Second
+	// ntlmTokenPrefix is the base64 encoding of the NTLMSSP signature starting the NTLM messages
+	ntlmTokenPrefix = "TlRMTVNTUA"
+
+	// Types of the NTLM messages sent by the clients
+	ntlmNegotiate    uint32 = 1
+	ntlmAuthenticate uint32 = 3
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// ntlmAffinity pins the legs of the NTLM handshakes of an eyeball to a single connection to the origin. NTLM
+// authenticates connections rather than requests, so the authenticate message must be sent on the connection that
+// received the challenge.
+//
+// The edge doesn't identify the connections of the eyeballs, so eyeballs are told apart by their IP and user agent,
+// which the eyeballs behind the same NAT may share. An authenticated connection is therefore never reused: it's
+// closed after the request carrying the authenticate message, and the following requests of the eyeball go through
+// the shared connections, to be challenged again by the origin. Handshakes of eyeballs sharing a key may replace each
+// other, failing the authentication, but never authenticate a connection used by another eyeball.
+type ntlmAffinity struct {
+	base        *http.Transport
+	idleTimeout time.Duration
+
+	lock      sync.Mutex
+	pinned    map[string]*pinnedTransport
+	lastSweep time.Time
+}
+
+type pinnedTransport struct {
+	transport *http.Transport
+	lastUsed  time.Time
+}
+
+func newNTLMAffinity(base *http.Transport, idleTimeout time.Duration, shutdownC <-chan struct{}) *ntlmAffinity {
+	if idleTimeout <= 0 {
+		idleTimeout = defaultNTLMAffinityIdleTimeout
+	}
+	a := &ntlmAffinity{
+		base:        base,
+		idleTimeout: idleTimeout,
+		pinned:      make(map[string]*pinnedTransport),
+		lastSweep:   time.Now(),
+	}
+	if shutdownC != nil {
+		go func() {
+			<-shutdownC
+			a.close()
+		}()
+	}
+	return a
+}
+
+// transport returns the transport to send the request with: the one of the connection pinned to the handshake of
+// the eyeball for the legs of an NTLM handshake, or the shared one otherwise. The request carrying the authenticate
+// message is marked to close its connection.
+func (a *ntlmAffinity) transport(req *http.Request) http.RoundTripper {
+	key := req.Header.Get("Cf-Connecting-Ip") + "|" + req.UserAgent()
+	now := time.Now()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.sweep(now)
+	switch ntlmMessageType(req.Header.Get("Authorization")) {
+	case ntlmNegotiate:
+		// A new handshake replaces the one in progress
+		if pinned, ok := a.pinned[key]; ok {
+			pinned.transport.CloseIdleConnections()
+		}
+		transport := a.newTransport()
+		a.pinned[key] = &pinnedTransport{transport: transport, lastUsed: now}
+		return transport
+	case ntlmAuthenticate:
+		req.Close = true
+		pinned, ok := a.pinned[key]
+		if !ok {
+			// Without a handshake in progress, the message is sent on a connection of its own
+			transport := a.newTransport()
+			transport.DisableKeepAlives = true
+			return transport
+		}
+		delete(a.pinned, key)
+		return pinned.transport
+	default:
+		return a.base
+	}
+}
+
+// newTransport returns a transport holding a single connection. NTLM doesn't work over HTTP/2, which multiplexes
+// the requests of every eyeball on a connection.
+func (a *ntlmAffinity) newTransport() *http.Transport {
+	transport := http1Transport(a.base)
+	transport.MaxConnsPerHost = 1
+	transport.MaxIdleConnsPerHost = 1
+	transport.IdleConnTimeout = a.idleTimeout
+	return transport
+}
+
+// sweep unpins the connections of the handshakes idle for longer than the idle timeout. The caller must hold the lock.
+func (a *ntlmAffinity) sweep(now time.Time) {
+	if now.Sub(a.lastSweep) < a.idleTimeout/2 {
+		return
+	}
+	a.lastSweep = now
+	for key, pinned := range a.pinned {
+		if now.Sub(pinned.lastUsed) > a.idleTimeout {
+			pinned.transport.CloseIdleConnections()
+			delete(a.pinned, key)
+		}
+	}
+}
+
+// close unpins the connections of every eyeball, closing the idle ones.
+func (a *ntlmAffinity) close() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for key, pinned := range a.pinned {
+		pinned.transport.CloseIdleConnections()
+		delete(a.pinned, key)
+	}
+}
+
+// isNTLMAuthorization returns whether the Authorization header carries an NTLM message, with the NTLM scheme or
+// with the Negotiate scheme falling back to NTLM.
+func isNTLMAuthorization(authorization string) bool {
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok {
+		return false
+	}
+	switch {
+	case strings.EqualFold(scheme, "NTLM"):
+		return true
+	case strings.EqualFold(scheme, "Negotiate"):
+		return strings.HasPrefix(strings.TrimSpace(token), ntlmTokenPrefix)
+	default:
+		return false
+	}
+}
+
+// ntlmMessageType returns the type of the NTLM message carried by the Authorization header, 0 if it doesn't carry
+// one.
+func ntlmMessageType(authorization string) uint32 {
+	if !isNTLMAuthorization(authorization) {
+		return 0
+	}
+	_, token, _ := strings.Cut(authorization, " ")
+	message, err := base64.StdEncoding.DecodeString(strings.TrimSpace(token))
+	if err != nil || len(message) < len(ntlmSignature)+4 || !bytes.HasPrefix(message, ntlmSignature) {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(message[len(ntlmSignature):])
+}
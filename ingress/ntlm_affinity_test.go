@@ -0,0 +1,162 @@
+package ingress
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+const (
+	ntlmNegotiateMessage    = "NTLM TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAKAGFKAAAADw=="
+	ntlmAuthenticateMessage = "NTLM TlRMTVNTUAADAAAAGAAYAEgAAAAYABgAYAAAAAAAAAB4AAAAAAAAAHgAAAAAAAAAeAAAAA=="
+)
+
+// ntlmOrigin authenticates connections like an NTLM origin: the authenticate message must be sent on the connection
+// that received the challenge, which is then authenticated.
+type ntlmOrigin struct {
+	lock          sync.Mutex
+	challenged    map[string]bool
+	authenticated map[string]bool
+}
+
+func (o *ntlmOrigin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	switch r.Header.Get("Authorization") {
+	case ntlmNegotiateMessage:
+		o.challenged[r.RemoteAddr] = true
+		w.Header().Set("WWW-Authenticate", "NTLM TlRMTVNTUAACAAAAAAAAACgAAAABggAAU3J2Tm9uY2UAAAAAAAAAAA==")
+		w.WriteHeader(http.StatusUnauthorized)
+	case ntlmAuthenticateMessage:
+		if !o.challenged[r.RemoteAddr] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		o.authenticated[r.RemoteAddr] = true
+	default:
+		if !o.authenticated[r.RemoteAddr] {
+			w.Header().Set("WWW-Authenticate", "NTLM")
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestNTLMAffinity(t *testing.T) {
+	origin := httptest.NewServer(&ntlmOrigin{challenged: make(map[string]bool), authenticated: make(map[string]bool)})
+	defer origin.Close()
+
+	ntlmAffinity := true
+	ing, err := ParseIngress(&config.Configuration{
+		TunnelID: t.Name(),
+		Ingress: []config.UnvalidatedIngressRule{
+			{Service: origin.URL, OriginRequest: config.OriginRequestConfig{NTLMAffinity: &ntlmAffinity}},
+		},
+	})
+	require.NoError(t, err)
+	log := zerolog.Nop()
+	shutdownC := make(chan struct{})
+	defer close(shutdownC)
+	require.NoError(t, ing.StartOrigins(&log, shutdownC))
+	service := ing.Rules[0].Service.(*httpService)
+
+	send := func(eyeball, authorization string) int {
+		req, err := http.NewRequest(http.MethodGet, origin.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("Cf-Connecting-Ip", eyeball)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := service.RoundTrip(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, send("192.0.2.1", ""))
+	assert.Equal(t, http.StatusUnauthorized, send("192.0.2.1", ntlmNegotiateMessage))
+	// The requests of the other eyeballs don't use the connection of the handshake
+	assert.Equal(t, http.StatusUnauthorized, send("192.0.2.2", ""))
+	assert.Equal(t, http.StatusOK, send("192.0.2.1", ntlmAuthenticateMessage))
+	// The authenticated connection is closed with the request carrying the authenticate message
+	assert.Equal(t, http.StatusUnauthorized, send("192.0.2.1", ""))
+	assert.Equal(t, http.StatusUnauthorized, send("192.0.2.2", ""))
+}
+
+// TestNTLMAffinitySharedKey makes sure the eyeballs behind the same NAT, with the same user agent, never get a
+// connection authenticated by another eyeball.
+func TestNTLMAffinitySharedKey(t *testing.T) {
+	base := &http.Transport{}
+	affinity := newNTLMAffinity(base, time.Minute, nil)
+	newRequest := func(authorization string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://origin.example.com", nil)
+		req.Header.Set("Cf-Connecting-Ip", "192.0.2.1")
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		return req
+	}
+
+	pinned := affinity.transport(newRequest(ntlmNegotiateMessage))
+	require.NotEqual(t, base, pinned)
+	// Another eyeball with the same key doesn't use the connection of the handshake
+	require.Equal(t, base, affinity.transport(newRequest("")))
+
+	authenticate := newRequest(ntlmAuthenticateMessage)
+	require.Equal(t, pinned, affinity.transport(authenticate))
+	require.True(t, authenticate.Close)
+	// Nor the authenticated connection, which isn't pinned anymore
+	require.Equal(t, base, affinity.transport(newRequest("")))
+	require.Empty(t, affinity.pinned)
+
+	// An authenticate message without a handshake gets a connection of its own
+	authenticate = newRequest(ntlmAuthenticateMessage)
+	unpinned := affinity.transport(authenticate)
+	require.NotEqual(t, base, unpinned)
+	require.NotEqual(t, pinned, unpinned)
+	require.True(t, authenticate.Close)
+}
+
+func TestNTLMAffinityExpiry(t *testing.T) {
+	base := &http.Transport{}
+	affinity := newNTLMAffinity(base, time.Minute, nil)
+	req := httptest.NewRequest(http.MethodGet, "http://origin.example.com", nil)
+	req.Header.Set("Cf-Connecting-Ip", "192.0.2.1")
+
+	require.Equal(t, base, affinity.transport(req))
+	req.Header.Set("Authorization", ntlmNegotiateMessage)
+	pinned := affinity.transport(req)
+	require.NotEqual(t, base, pinned)
+	require.Contains(t, affinity.pinned, "192.0.2.1|")
+
+	affinity.lastSweep = time.Now().Add(-time.Hour)
+	affinity.pinned["192.0.2.1|"].lastUsed = time.Now().Add(-time.Hour)
+	req.Header.Del("Authorization")
+	require.Equal(t, base, affinity.transport(req))
+	require.Empty(t, affinity.pinned)
+}
+
+func TestNTLMMessageType(t *testing.T) {
+	assert.Equal(t, ntlmNegotiate, ntlmMessageType(ntlmNegotiateMessage))
+	assert.Equal(t, ntlmAuthenticate, ntlmMessageType(ntlmAuthenticateMessage))
+	assert.Equal(t, ntlmNegotiate, ntlmMessageType("Negotiate TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAKAGFKAAAADw=="))
+	assert.Equal(t, uint32(0), ntlmMessageType("NTLM"))
+	assert.Equal(t, uint32(0), ntlmMessageType("NTLM not-base64"))
+	assert.Equal(t, uint32(0), ntlmMessageType("Basic dXNlcjpwYXNz"))
+}
+
+func TestIsNTLMAuthorization(t *testing.T) {
+	assert.True(t, isNTLMAuthorization(ntlmNegotiateMessage))
+	assert.True(t, isNTLMAuthorization("Negotiate TlRMTVNTUAABAAAAB4IIogAAAAAAAAAAAAAAAAAAAAAKAGFKAAAADw=="))
+	assert.False(t, isNTLMAuthorization("Negotiate YIIGhgYGKwYBBQUCoIIGejCCBnagMDAuBgkqhkiC9xIBAgIGCSqGSIb3EgECAgYKKwYBBAGCNwICHgYKKwYBBAGCNwICCqKCBkAEggY8"))
+	assert.False(t, isNTLMAuthorization("Basic dXNlcjpwYXNz"))
+	assert.False(t, isNTLMAuthorization(""))
+}
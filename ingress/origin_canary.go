@@ -0,0 +1,144 @@
+package ingress
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+const (
+	// canaryBuckets is the number of buckets eyeballs are hashed into, so that weights have a 0.01% precision.
+	canaryBuckets   = 10_000
+	eyeballIPHeader = "Cf-Connecting-Ip"
+)
+
+// canaryService is an OriginService splitting HTTP requests between a primary and a canary origin, for progressive
+// rollouts. Requests matching a header or cookie go to the canary origin, and so does a share of the eyeballs,
+// assigned by a hash of their IP so that each eyeball sticks to an origin while the weight doesn't change.
+type canaryService struct {
+	primary *httpService
+	canary  *httpService
+	// canaryShare is the number of the canaryBuckets buckets routed to the canary origin.
+	canaryShare uint32
+	match       []config.CanaryMatch
+}
+
+func newCanaryService(cfg config.CanaryConfig) (*canaryService, error) {
+	primary, err := parseCanaryOrigin("primary", cfg.Primary)
+	if err != nil {
+		return nil, err
+	}
+	canary, err := parseCanaryOrigin("canary", cfg.Canary)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Weight < 0 || cfg.Weight > 100 {
+		return nil, fmt.Errorf("canary.weight %v must be a percentage between 0 and 100", cfg.Weight)
+	}
+	for _, match := range cfg.Match {
+		if (match.Header == "") == (match.Cookie == "") {
+			return nil, errors.New("canary.match rules must match either a header or a cookie")
+		}
+	}
+	return &canaryService{
+		primary:     primary,
+		canary:      canary,
+		canaryShare: uint32(cfg.Weight * canaryBuckets / 100),
+		match:       cfg.Match,
+	}, nil
+}
+
+func parseCanaryOrigin(name, origin string) (*httpService, error) {
+	if origin == "" {
+		return nil, fmt.Errorf("canary service requires a %s origin in originRequest.canary.%s", name, name)
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return nil, err
+	}
+	if !isHTTPService(u) || u.Hostname() == "" {
+		return nil, fmt.Errorf("%s is an invalid canary %s origin, please make sure it is a http(s) URL with a hostname", origin, name)
+	}
+	if u.Path != "" {
+		return nil, fmt.Errorf("%s is an invalid canary %s origin, origins don't support proxying to a different path", origin, name)
+	}
+	return &httpService{url: u}, nil
+}
+
+func (o *canaryService) String() string {
+	return ServiceCanary
+}
+
+func (o canaryService) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.String())
+}
+
+func (o *canaryService) start(log *zerolog.Logger, shutdownC <-chan struct{}, cfg OriginRequestConfig) error {
+	if err := o.primary.start(log, shutdownC, cfg); err != nil {
+		return err
+	}
+	return o.canary.start(log, shutdownC, cfg)
+}
+
+// RoundTrip proxies the request to the canary origin if it's routed to it, otherwise to the primary origin.
+func (o *canaryService) RoundTrip(req *http.Request) (*http.Response, error) {
+	if o.routeToCanary(req) {
+		return o.canary.RoundTrip(req)
+	}
+	return o.primary.RoundTrip(req)
+}
+
+func (o *canaryService) routeToCanary(req *http.Request) bool {
+	for _, match := range o.match {
+		if matchesCanary(req, match) {
+			return true
+		}
+	}
+	if o.canaryShare == 0 {
+		return false
+	}
+	return eyeballBucket(req) < o.canaryShare
+}
+
+func matchesCanary(req *http.Request, match config.CanaryMatch) bool {
+	if match.Header != "" {
+		values, ok := req.Header[http.CanonicalHeaderKey(match.Header)]
+		if !ok {
+			return false
+		}
+		if match.Value == "" {
+			return true
+		}
+		for _, value := range values {
+			if value == match.Value {
+				return true
+			}
+		}
+		return false
+	}
+	cookie, err := req.Cookie(match.Cookie)
+	if err != nil {
+		return false
+	}
+	return match.Value == "" || cookie.Value == match.Value
+}
+
+// eyeballBucket hashes the IP of the eyeball into a bucket. Requests without eyeball IP get a random bucket.
+func eyeballBucket(req *http.Request) uint32 {
+	ip := req.Header.Get(eyeballIPHeader)
+	if ip == "" {
+		// nolint: gosec
+		return uint32(rand.Int63n(canaryBuckets))
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(ip))
+	return h.Sum32() % canaryBuckets
+}
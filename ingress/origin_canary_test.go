@@ -0,0 +1,115 @@
+package ingress
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestParseCanary(t *testing.T) {
+	rawYAML := `
+ingress:
+- hostname: app.example.com
+  service: canary
+  originRequest:
+    canary:
+      primary: http://localhost:8000
+      canary: http://localhost:8001
+      weight: 12.5
+      match:
+      - header: X-Canary
+      - cookie: release
+        value: beta
+- service: http_status:404
+`
+	ing, err := ParseIngress(MustReadIngress(rawYAML))
+	require.NoError(t, err)
+	canary, ok := ing.Rules[0].Service.(*canaryService)
+	require.True(t, ok)
+	require.Equal(t, "http://localhost:8000", canary.primary.String())
+	require.Equal(t, "http://localhost:8001", canary.canary.String())
+	require.Equal(t, uint32(1250), canary.canaryShare)
+	require.Len(t, canary.match, 2)
+	require.Equal(t, ServiceCanary, canary.String())
+}
+
+func TestParseCanaryInvalid(t *testing.T) {
+	for name, cfg := range map[string]config.CanaryConfig{
+		"no primary":       {Canary: "http://localhost:8001"},
+		"no canary":        {Primary: "http://localhost:8000"},
+		"non http origin":  {Primary: "tcp://localhost:8000", Canary: "http://localhost:8001"},
+		"origin with path": {Primary: "http://localhost:8000/v2", Canary: "http://localhost:8001"},
+		"invalid weight":   {Primary: "http://localhost:8000", Canary: "http://localhost:8001", Weight: 101},
+		"empty match":      {Primary: "http://localhost:8000", Canary: "http://localhost:8001", Match: []config.CanaryMatch{{Value: "1"}}},
+		"ambiguous match":  {Primary: "http://localhost:8000", Canary: "http://localhost:8001", Match: []config.CanaryMatch{{Header: "X-Canary", Cookie: "canary"}}},
+	} {
+		_, err := newCanaryService(cfg)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestCanaryRouting(t *testing.T) {
+	newOrigin := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(name))
+		}))
+	}
+	primary := newOrigin("primary")
+	defer primary.Close()
+	canaryOrigin := newOrigin("canary")
+	defer canaryOrigin.Close()
+
+	canary, err := newCanaryService(config.CanaryConfig{
+		Primary: primary.URL,
+		Canary:  canaryOrigin.URL,
+		Weight:  50,
+		Match: []config.CanaryMatch{
+			{Header: "X-Canary", Value: "1"},
+			{Cookie: "release"},
+		},
+	})
+	require.NoError(t, err)
+	log := zerolog.Nop()
+	shutdownC := make(chan struct{})
+	defer close(shutdownC)
+	require.NoError(t, canary.start(&log, shutdownC, originRequestFromConfig(config.OriginRequestConfig{})))
+
+	roundTrip := func(modify func(*http.Request)) string {
+		req := httptest.NewRequest(http.MethodGet, "http://app.example.com/", nil)
+		modify(req)
+		resp, err := canary.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	assert.Equal(t, "canary", roundTrip(func(req *http.Request) { req.Header.Set("X-Canary", "1") }))
+	assert.Equal(t, "canary", roundTrip(func(req *http.Request) { req.AddCookie(&http.Cookie{Name: "release", Value: "any"}) }))
+
+	// Eyeballs stick to an origin, and half of them are routed to the canary
+	routedToCanary := 0
+	for i := 0; i < 200; i++ {
+		ip := fmt.Sprintf("198.51.100.%d", i)
+		origin := roundTrip(func(req *http.Request) { req.Header.Set("Cf-Connecting-Ip", ip) })
+		for j := 0; j < 3; j++ {
+			require.Equal(t, origin, roundTrip(func(req *http.Request) { req.Header.Set("Cf-Connecting-Ip", ip) }))
+		}
+		if origin == "canary" {
+			routedToCanary++
+		}
+	}
+	assert.InDelta(t, 100, routedToCanary, 40)
+
+	canary.canaryShare = 0
+	assert.Equal(t, "primary", roundTrip(func(req *http.Request) { req.Header.Set("Cf-Connecting-Ip", "198.51.100.1") }))
+}
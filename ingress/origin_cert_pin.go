@@ -0,0 +1,153 @@
+package ingress
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+var (
+	originCertificateChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "origin",
+		Name:      "certificate_changes",
+		Help:      "Count of origins presenting another certificate than the one pinned on first use",
+	}, []string{"origin"})
+
+	// certPinStores are shared by the rules, by file, so that pins outlive configuration updates
+	certPinStoresLock sync.Mutex
+	certPinStores     = make(map[string]*certPinStore)
+)
+
+func init() {
+	prometheus.MustRegister(originCertificateChanges)
+}
+
+// certPinStore keeps the SHA-256 fingerprints of the certificates pinned for the origins, persisting them to a file
+// if it has one.
+type certPinStore struct {
+	file string
+
+	lock sync.Mutex
+	pins map[string]string
+}
+
+func getCertPinStore(file string) (*certPinStore, error) {
+	certPinStoresLock.Lock()
+	defer certPinStoresLock.Unlock()
+	if store, ok := certPinStores[file]; ok {
+		return store, nil
+	}
+	store := &certPinStore{file: file, pins: make(map[string]string)}
+	if file != "" {
+		data, err := os.ReadFile(filepath.Clean(file))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "unable to read the pinned origin certificates")
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &store.pins); err != nil {
+				return nil, errors.Wrapf(err, "unable to parse the pinned origin certificates of %s", file)
+			}
+		}
+	}
+	certPinStores[file] = store
+	return store, nil
+}
+
+// pin pins the fingerprint for the origin if it has none, or if replace is set. It returns the fingerprint pinned
+// before, if any.
+func (s *certPinStore) pin(origin, fingerprint string, replace bool) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	previous := s.pins[origin]
+	if previous == fingerprint || (previous != "" && !replace) {
+		return previous, nil
+	}
+	s.pins[origin] = fingerprint
+	return previous, s.save()
+}
+
+// caller must hold the lock
+func (s *certPinStore) save() error {
+	if s.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.pins, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+// trustOnFirstUseVerifier returns the tls.Config VerifyConnection function pinning the certificate presented by the
+// origin on the first connection.
+//
+// It only replaces the verification of the certificate chain when no CA pool is configured, see
+// applyTrustOnFirstUse.
+func trustOnFirstUseVerifier(cfg config.TrustOnFirstUseConfig, origin string, log *zerolog.Logger) (func(tls.ConnectionState) error, error) {
+	store, err := getCertPinStore(cfg.File)
+	if err != nil {
+		return nil, err
+	}
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("the origin didn't present a certificate")
+		}
+		sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+		fingerprint := hex.EncodeToString(sum[:])
+		key := origin
+		if cs.ServerName != "" {
+			key = fmt.Sprintf("%s (%s)", origin, cs.ServerName)
+		}
+
+		previous, err := store.pin(key, fingerprint, cfg.RepinOnChange)
+		if err != nil {
+			log.Err(err).Str("file", cfg.File).Msg("Unable to save the pinned origin certificates")
+		}
+		switch previous {
+		case fingerprint:
+			return nil
+		case "":
+			log.Info().Str("origin", key).Str("sha256", fingerprint).Msg("Pinned the certificate of the origin on first use")
+			return nil
+		}
+		originCertificateChanges.WithLabelValues(origin).Inc()
+		if cfg.RepinOnChange {
+			log.Warn().Str("origin", key).Str("sha256", fingerprint).Str("previous", previous).
+				Msg("The origin presented another certificate than the pinned one, pinning the new one")
+			return nil
+		}
+		log.Error().Str("origin", key).Str("sha256", fingerprint).Str("pinned", previous).
+			Msg("The origin presented another certificate than the pinned one, refusing to connect")
+		return fmt.Errorf("the certificate of origin %s doesn't match the pinned one", key)
+	}, nil
+}
+
+// applyTrustOnFirstUse pins the certificate of the origin in tlsConfig. Without a CA pool, the pinned certificate is
+// trusted instead of being verified, otherwise it must also be verified by the pool.
+func applyTrustOnFirstUse(tlsConfig *tls.Config, cfg OriginRequestConfig, origin string, log *zerolog.Logger) error {
+	verifyConnection, err := trustOnFirstUseVerifier(cfg.TrustOnFirstUse, origin, log)
+	if err != nil {
+		return err
+	}
+	if cfg.CAPool == "" {
+		tlsConfig.InsecureSkipVerify = true // nolint: gosec
+	}
+	tlsConfig.VerifyConnection = verifyConnection
+	return nil
+}
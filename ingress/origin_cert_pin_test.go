@@ -0,0 +1,157 @@
+package ingress
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestTrustOnFirstUse(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("self-signed"))
+	}))
+	defer origin.Close()
+
+	file := filepath.Join(t.TempDir(), "pins.json")
+	ing, err := ParseIngress(&config.Configuration{
+		TunnelID: t.Name(),
+		Ingress: []config.UnvalidatedIngressRule{
+			{Service: origin.URL, OriginRequest: config.OriginRequestConfig{
+				TrustOnFirstUse: &config.TrustOnFirstUseConfig{Enabled: true, File: file},
+			}},
+		},
+	})
+	require.NoError(t, err)
+	log := zerolog.Nop()
+	shutdownC := make(chan struct{})
+	defer close(shutdownC)
+	require.NoError(t, ing.StartOrigins(&log, shutdownC))
+
+	req, err := http.NewRequest(http.MethodGet, origin.URL, nil)
+	require.NoError(t, err)
+	resp, err := ing.Rules[0].Service.(HTTPOriginProxy).RoundTrip(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	var pins map[string]string
+	require.NoError(t, json.Unmarshal(data, &pins))
+	assert.Len(t, pins, 1)
+}
+
+func TestTrustOnFirstUseWithCAPool(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("self-signed"))
+	}))
+	defer origin.Close()
+
+	dir := t.TempDir()
+	writePool := func(name string, cert *x509.Certificate) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600))
+		return path
+	}
+	roundTrip := func(caPool string) error {
+		ing, err := ParseIngress(&config.Configuration{
+			TunnelID: t.Name(),
+			Ingress: []config.UnvalidatedIngressRule{
+				{Service: origin.URL, OriginRequest: config.OriginRequestConfig{
+					CAPool:          &caPool,
+					TrustOnFirstUse: &config.TrustOnFirstUseConfig{Enabled: true, File: caPool + ".pins"},
+				}},
+			},
+		})
+		require.NoError(t, err)
+		log := zerolog.Nop()
+		shutdownC := make(chan struct{})
+		defer close(shutdownC)
+		require.NoError(t, ing.StartOrigins(&log, shutdownC))
+		req, err := http.NewRequest(http.MethodGet, origin.URL, nil)
+		require.NoError(t, err)
+		resp, err := ing.Rules[0].Service.(HTTPOriginProxy).RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	// The chain is still verified by the CA pool, the first certificate isn't trusted blindly
+	require.Error(t, roundTrip(writePool("other.pem", newTestCertificate(t))))
+	require.NoError(t, roundTrip(writePool("origin.pem", origin.Certificate())))
+}
+
+func newTestCertificate(t *testing.T) *x509.Certificate {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "origin.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestTrustOnFirstUseCertificateChange(t *testing.T) {
+	log := zerolog.Nop()
+	first := tls.ConnectionState{PeerCertificates: []*x509.Certificate{newTestCertificate(t)}, ServerName: "origin.example.com"}
+	second := tls.ConnectionState{PeerCertificates: []*x509.Certificate{newTestCertificate(t)}, ServerName: "origin.example.com"}
+
+	file := filepath.Join(t.TempDir(), "pins.json")
+	enforced, err := trustOnFirstUseVerifier(config.TrustOnFirstUseConfig{Enabled: true, File: file}, "https://enforced:8443", &log)
+	require.NoError(t, err)
+	require.NoError(t, enforced(first))
+	require.NoError(t, enforced(first))
+	require.Error(t, enforced(second))
+	require.NoError(t, enforced(first))
+	require.Error(t, enforced(tls.ConnectionState{}))
+
+	reported, err := trustOnFirstUseVerifier(config.TrustOnFirstUseConfig{Enabled: true, File: file, RepinOnChange: true}, "https://reported:8443", &log)
+	require.NoError(t, err)
+	require.NoError(t, reported(first))
+	require.NoError(t, reported(second))
+	// The new certificate was pinned
+	require.NoError(t, reported(second))
+
+	// The pins are kept across restarts
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	var pins map[string]string
+	require.NoError(t, json.Unmarshal(data, &pins))
+	assert.Len(t, pins, 2)
+	delete(certPinStores, file)
+	restarted, err := trustOnFirstUseVerifier(config.TrustOnFirstUseConfig{Enabled: true, File: file}, "https://enforced:8443", &log)
+	require.NoError(t, err)
+	require.NoError(t, restarted(first))
+	require.Error(t, restarted(second))
+}
+
+func TestValidateTrustOnFirstUseConfiguration(t *testing.T) {
+	require.NoError(t, validateTrustOnFirstUseConfiguration(OriginRequestConfig{}))
+	require.NoError(t, validateTrustOnFirstUseConfiguration(OriginRequestConfig{TrustOnFirstUse: config.TrustOnFirstUseConfig{Enabled: true, RepinOnChange: true}}))
+	require.Error(t, validateTrustOnFirstUseConfiguration(OriginRequestConfig{TrustOnFirstUse: config.TrustOnFirstUseConfig{File: "pins.json"}}))
+	require.Error(t, validateTrustOnFirstUseConfiguration(OriginRequestConfig{NoTLSVerify: true, TrustOnFirstUse: config.TrustOnFirstUseConfig{Enabled: true}}))
+}
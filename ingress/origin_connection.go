@@ -8,6 +8,7 @@ import (
 
 	"github.com/rs/zerolog"
 
+	"github.com/cloudflare/cloudflared/cfio"
 	"github.com/cloudflare/cloudflared/ipaccess"
 	"github.com/cloudflare/cloudflared/socks"
 	"github.com/cloudflare/cloudflared/stream"
@@ -37,8 +38,16 @@ type tcpConnection struct {
 	logger       *zerolog.Logger
 }
 
-func (tc *tcpConnection) Stream(_ context.Context, tunnelConn io.ReadWriter, _ *zerolog.Logger) {
-	stream.Pipe(tunnelConn, tc, tc.logger)
+func (tc *tcpConnection) Stream(ctx context.Context, tunnelConn io.ReadWriter, _ *zerolog.Logger) {
+	stream.PipeWithBackpressure(tunnelConn, tc, cfio.BackpressureFromContext(ctx), tc.logger)
+}
+
+// Unwrap lets cfio.Copy splice to the connection when writes have no deadline.
+func (tc *tcpConnection) Unwrap() io.ReadWriter {
+	if tc.writeTimeout > 0 {
+		return nil
+	}
+	return tc.Conn
 }
 
 func (tc *tcpConnection) Write(b []byte) (int, error) {
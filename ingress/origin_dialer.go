@@ -21,6 +21,17 @@ type OriginUDPDialer interface {
 	DialUDP(addr netip.AddrPort) (net.Conn, error)
 }
 
+// OriginUDPIdleTimeouts provides the idle timeout of the UDP sessions to a requested address.
+type OriginUDPIdleTimeouts interface {
+	// UDPIdleTimeout returns zero when the idle timeout requested by the edge applies.
+	UDPIdleTimeout(addr netip.AddrPort) time.Duration
+}
+
+// OriginQoSClassifier assigns a QoS class to the UDP flows to a requested address.
+type OriginQoSClassifier interface {
+	QoSClass(addr netip.AddrPort) QoSClass
+}
+
 // OriginDialer provides both TCP and UDP dial operations to an address.
 type OriginDialer interface {
 	OriginTCPDialer
@@ -32,6 +43,10 @@ type OriginConfig struct {
 	DefaultDialer OriginDialer
 	// Timeout on write operations for TCP connections to the origin.
 	TCPWriteTimeout time.Duration
+	// NAT64 translates the flows to its prefix into flows to the embedded IPv4 addresses.
+	NAT64 *NAT64
+	// LocalNAT64 maps the IPv4 origins into the NAT64 prefix of the network of IPv6-only hosts, if any.
+	LocalNAT64 *NAT64
 }
 
 // OriginDialerService provides a proxy TCP and UDP dialer to origin services while allowing reserved
@@ -42,11 +57,18 @@ type OriginDialerService struct {
 	reservedTCPServices map[netip.AddrPort]OriginTCPDialer
 	// Reserved UDP services for reserved AddrPort values
 	reservedUDPServices map[netip.AddrPort]OriginUDPDialer
+	// UDP services of the ingress rules, replaced with every new configuration
+	ingressUDPServices  map[netip.AddrPort]OriginUDPDialer
+	ingressUDPServicesM sync.RWMutex
 	// The default Dialer used if no reserved services are found for an origin request
 	defaultDialer  OriginDialer
 	defaultDialerM sync.RWMutex
 	// Write timeout for TCP connections
 	writeTimeout time.Duration
+	// Translation of the flows to IPv4 origins, if any
+	nat64 *NAT64
+	// Mapping of the IPv4 origins dialed from an IPv6-only host, if any
+	localNAT64 *NAT64
 
 	logger *zerolog.Logger
 }
@@ -57,6 +79,8 @@ func NewOriginDialer(config OriginConfig, logger *zerolog.Logger) *OriginDialerS
 		reservedUDPServices: map[netip.AddrPort]OriginUDPDialer{},
 		defaultDialer:       config.DefaultDialer,
 		writeTimeout:        config.TCPWriteTimeout,
+		nat64:               config.NAT64,
+		localNAT64:          config.LocalNAT64,
 		logger:              logger,
 	}
 }
@@ -77,6 +101,21 @@ func (d *OriginDialerService) UpdateDefaultDialer(dialer *Dialer) {
 	d.defaultDialer = dialer
 }
 
+// UpdateIngressUDPServices replaces the UDP services of the ingress rules. Sessions to their address are dialed
+// by them instead of the default dialer.
+func (d *OriginDialerService) UpdateIngressUDPServices(services map[netip.AddrPort]OriginUDPDialer) {
+	d.ingressUDPServicesM.Lock()
+	defer d.ingressUDPServicesM.Unlock()
+	d.ingressUDPServices = services
+}
+
+func (d *OriginDialerService) ingressUDPService(addr netip.AddrPort) (OriginUDPDialer, bool) {
+	d.ingressUDPServicesM.RLock()
+	defer d.ingressUDPServicesM.RUnlock()
+	service, ok := d.ingressUDPServices[netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())]
+	return service, ok
+}
+
 // DialTCP will perform a dial TCP to the requested addr.
 func (d *OriginDialerService) DialTCP(ctx context.Context, addr netip.AddrPort) (net.Conn, error) {
 	conn, err := d.dialTCP(ctx, addr)
@@ -96,6 +135,7 @@ func (d *OriginDialerService) dialTCP(ctx context.Context, addr netip.AddrPort)
 	if dialer, ok := d.reservedTCPServices[addr]; ok {
 		return dialer.DialTCP(ctx, addr)
 	}
+	addr = d.localNAT64.Map(d.nat64.Translate(addr))
 	d.defaultDialerM.RLock()
 	dialer := d.defaultDialer
 	d.defaultDialerM.RUnlock()
@@ -108,14 +148,44 @@ func (d *OriginDialerService) DialUDP(addr netip.AddrPort) (net.Conn, error) {
 	if dialer, ok := d.reservedUDPServices[addr]; ok {
 		return dialer.DialUDP(addr)
 	}
+	addr = d.nat64.Translate(addr)
+	if service, ok := d.ingressUDPService(addr); ok {
+		return service.DialUDP(addr)
+	}
+	addr = d.localNAT64.Map(addr)
 	d.defaultDialerM.RLock()
 	dialer := d.defaultDialer
 	d.defaultDialerM.RUnlock()
 	return dialer.DialUDP(addr)
 }
 
+// UDPIdleTimeout returns the idle timeout of the sessions to addr, as configured by the udp service of the ingress
+// rules for addr.
+func (d *OriginDialerService) UDPIdleTimeout(addr netip.AddrPort) time.Duration {
+	addr = d.nat64.Translate(addr)
+	if service, ok := d.ingressUDPService(addr); ok {
+		if timeouts, ok := service.(OriginUDPIdleTimeouts); ok {
+			return timeouts.UDPIdleTimeout(addr)
+		}
+	}
+	return 0
+}
+
+// QoSClass returns the QoS class of the UDP flows to addr, as configured on the default dialer.
+func (d *OriginDialerService) QoSClass(addr netip.AddrPort) QoSClass {
+	addr = d.nat64.Translate(addr)
+	d.defaultDialerM.RLock()
+	dialer := d.defaultDialer
+	d.defaultDialerM.RUnlock()
+	if classifier, ok := dialer.(OriginQoSClassifier); ok {
+		return classifier.QoSClass(addr)
+	}
+	return QoSUnclassified
+}
+
 type Dialer struct {
 	Dialer net.Dialer
+	qos    []QoSRule
 }
 
 func NewDialer(config WarpRoutingConfig) *Dialer {
@@ -124,9 +194,15 @@ func NewDialer(config WarpRoutingConfig) *Dialer {
 			Timeout:   config.ConnectTimeout.Duration,
 			KeepAlive: config.TCPKeepAlive.Duration,
 		},
+		qos: config.QoS,
 	}
 }
 
+// QoSClass returns the QoS class of the UDP flows to dest.
+func (d *Dialer) QoSClass(dest netip.AddrPort) QoSClass {
+	return classify(d.qos, dest.Addr())
+}
+
 func (d *Dialer) DialTCP(ctx context.Context, dest netip.AddrPort) (net.Conn, error) {
 	conn, err := d.Dialer.DialContext(ctx, "tcp", dest.String())
 	if err != nil {
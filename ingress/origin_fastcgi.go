@@ -0,0 +1,391 @@
+package ingress
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+const (
+	fastCGIUnixPrefix = "fastcgi+unix:"
+
+	fastCGIVersion       = 1
+	fastCGIRequestID     = 1
+	fastCGIHeaderLen     = 8
+	fastCGIMaxContent    = 65535
+	fastCGIRoleResponder = 1
+
+	fastCGIBeginRequest = 1
+	fastCGIEndRequest   = 3
+	fastCGIParams       = 4
+	fastCGIStdin        = 5
+	fastCGIStdout       = 6
+	fastCGIStderr       = 7
+
+	defaultFastCGIIndex = "index.php"
+	// Requests without Content-Length are buffered to compute it, since FastCGI applications rely on CONTENT_LENGTH
+	maxFastCGIBufferedBody = 32 * 1024 * 1024
+)
+
+// fastCGIService proxies requests to a FastCGI responder, such as PHP-FPM, listening on TCP (fastcgi://host:port) or
+// on a unix socket (fastcgi+unix:/path). A new connection is used for each request.
+type fastCGIService struct {
+	url        *url.URL
+	socketPath string
+	config     config.FastCGIConfig
+	dialer     net.Dialer
+	// customDialer is the registered dialer of the rule, if any, used instead of dialer
+	customDialer ContextDialer
+	log          *zerolog.Logger
+}
+
+func isFastCGIService(url *url.URL) bool {
+	return url.Scheme == "fastcgi"
+}
+
+// parseFastCGIUnixService parses the fastcgi+unix:/path and fastcgi+unix:///path forms of a FastCGI service.
+func parseFastCGIUnixService(service string) (*fastCGIService, bool) {
+	socketPath, ok := trimServicePrefix(service, fastCGIUnixPrefix)
+	if !ok {
+		return nil, false
+	}
+	return &fastCGIService{socketPath: socketPath}, true
+}
+
+func validateFastCGIConfiguration(cfg config.FastCGIConfig) error {
+	if cfg.DocumentRoot == "" && cfg.ScriptFilename == "" {
+		return errors.New("fastcgi services require originRequest.fastcgi.documentRoot or originRequest.fastcgi.scriptFilename")
+	}
+	return nil
+}
+
+func (o *fastCGIService) String() string {
+	if o.socketPath != "" {
+		return fastCGIUnixPrefix + o.socketPath
+	}
+	return o.url.String()
+}
+
+func (o fastCGIService) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.String())
+}
+
+func (o *fastCGIService) start(log *zerolog.Logger, shutdownC <-chan struct{}, cfg OriginRequestConfig) error {
+	if o.socketPath != "" {
+		if err := validateUnixSocket(o.socketPath); err != nil {
+			return err
+		}
+	}
+	o.config = cfg.FastCGI
+	if o.config.Index == "" {
+		o.config.Index = defaultFastCGIIndex
+	}
+	o.dialer = net.Dialer{
+		Timeout:   cfg.ConnectTimeout.Duration,
+		KeepAlive: cfg.TCPKeepAlive.Duration,
+	}
+	customDialer, err := customDialer(log, shutdownC, cfg)
+	if err != nil {
+		return err
+	}
+	o.customDialer = customDialer
+	o.log = log
+	return nil
+}
+
+func (o *fastCGIService) dial(ctx context.Context) (net.Conn, error) {
+	var dialer ContextDialer = &o.dialer
+	if o.customDialer != nil {
+		dialer = o.customDialer
+	}
+	if o.socketPath != "" {
+		return dialer.DialContext(ctx, "unix", o.socketPath)
+	}
+	return dialer.DialContext(ctx, "tcp", o.url.Host)
+}
+
+func (o *fastCGIService) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := req.Body
+	contentLength := req.ContentLength
+	if body == nil {
+		body = http.NoBody
+		contentLength = 0
+	}
+	if contentLength < 0 {
+		buffered, err := io.ReadAll(io.LimitReader(body, maxFastCGIBufferedBody+1))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read request body")
+		}
+		if len(buffered) > maxFastCGIBufferedBody {
+			return nil, fmt.Errorf("request body without Content-Length is larger than %d bytes", maxFastCGIBufferedBody)
+		}
+		body = io.NopCloser(bytes.NewReader(buffered))
+		contentLength = int64(len(buffered))
+	}
+
+	conn, err := o.dial(req.Context())
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to dial fastcgi origin")
+	}
+	// Unblock reads and writes if the eyeball goes away
+	stop := context.AfterFunc(req.Context(), func() { _ = conn.Close() })
+
+	w := bufio.NewWriter(conn)
+	if err := writeFastCGIBeginRequest(w); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if err := writeFastCGIParams(w, o.params(req, contentLength)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	// The request body is sent while the response is read, responders may start answering before reading it all
+	go func() {
+		if err := writeFastCGIStream(w, fastCGIStdin, body); err != nil {
+			o.log.Debug().Err(err).Msg("failed to send request body to fastcgi origin")
+		}
+		_ = w.Flush()
+	}()
+
+	stdout, stdoutWriter := io.Pipe()
+	go o.readRecords(conn, stdoutWriter)
+
+	respBody := &fastCGIResponseBody{Reader: bufio.NewReader(stdout), stdout: stdout, conn: conn, stop: stop}
+	resp, err := readCGIResponse(req, respBody)
+	if err != nil {
+		_ = respBody.Close()
+		return nil, errors.Wrap(err, "invalid response from fastcgi origin")
+	}
+	return resp, nil
+}
+
+// params builds the CGI/1.1 meta-variables of the request.
+func (o *fastCGIService) params(req *http.Request, contentLength int64) map[string]string {
+	scriptName, pathInfo := splitScriptPath(req.URL.Path, o.config.Index)
+	scriptFilename := o.config.ScriptFilename
+	if scriptFilename == "" {
+		scriptFilename = path.Join(o.config.DocumentRoot, scriptName)
+	}
+	host, port, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		host = req.Host
+		port = "443"
+	}
+	remoteAddr := req.Header.Get("Cf-Connecting-Ip")
+	if remoteAddr == "" {
+		remoteAddr = "127.0.0.1"
+	}
+
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "cloudflared",
+		"SERVER_PROTOCOL":   req.Proto,
+		"SERVER_NAME":       host,
+		"SERVER_PORT":       port,
+		"REMOTE_ADDR":       remoteAddr,
+		"REQUEST_METHOD":    req.Method,
+		"REQUEST_URI":       req.URL.RequestURI(),
+		"QUERY_STRING":      req.URL.RawQuery,
+		"DOCUMENT_URI":      req.URL.Path,
+		"DOCUMENT_ROOT":     o.config.DocumentRoot,
+		"SCRIPT_NAME":       scriptName,
+		"SCRIPT_FILENAME":   scriptFilename,
+		"PATH_INFO":         pathInfo,
+		"CONTENT_TYPE":      req.Header.Get("Content-Type"),
+		"CONTENT_LENGTH":    strconv.FormatInt(contentLength, 10),
+	}
+	if req.Header.Get("X-Forwarded-Proto") == "https" {
+		params["HTTPS"] = "on"
+	}
+	for name, values := range req.Header {
+		name = strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		if name == "CONTENT_TYPE" || name == "CONTENT_LENGTH" || name == "PROXY" {
+			// Proxy is skipped to avoid httpoxy
+			continue
+		}
+		params["HTTP_"+name] = strings.Join(values, ", ")
+	}
+	params["HTTP_HOST"] = req.Host
+	for name, value := range o.config.Params {
+		params[name] = value
+	}
+	return params
+}
+
+// splitScriptPath splits the request path into the script and the trailing PATH_INFO, e.g. /index.php/users into
+// /index.php and /users.
+func splitScriptPath(requestPath, index string) (scriptName, pathInfo string) {
+	// The path is decoded from the URL, so it's cleaned to keep the script inside the document root
+	cleaned := path.Clean("/" + requestPath)
+	if strings.HasSuffix(requestPath, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	requestPath = cleaned
+	if i := strings.Index(requestPath, ".php/"); i >= 0 {
+		return requestPath[:i+len(".php")], requestPath[i+len(".php"):]
+	}
+	if strings.HasSuffix(requestPath, "/") {
+		return requestPath + index, ""
+	}
+	return requestPath, ""
+}
+
+// readRecords forwards the stdout stream of the responder to stdout until the request ends. Stderr is logged.
+func (o *fastCGIService) readRecords(conn net.Conn, stdout *io.PipeWriter) {
+	r := bufio.NewReader(conn)
+	header := make([]byte, fastCGIHeaderLen)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			_ = stdout.CloseWithError(err)
+			return
+		}
+		recordType := header[1]
+		contentLength := int(binary.BigEndian.Uint16(header[4:6]))
+		paddingLength := int(header[6])
+		content := make([]byte, contentLength+paddingLength)
+		if _, err := io.ReadFull(r, content); err != nil {
+			_ = stdout.CloseWithError(err)
+			return
+		}
+		content = content[:contentLength]
+		switch recordType {
+		case fastCGIStdout:
+			if _, err := stdout.Write(content); err != nil {
+				return
+			}
+		case fastCGIStderr:
+			if len(content) > 0 {
+				o.log.Warn().Str("origin", o.String()).Msgf("fastcgi origin error: %s", strings.TrimSpace(string(content)))
+			}
+		case fastCGIEndRequest:
+			_ = stdout.Close()
+			return
+		}
+	}
+}
+
+// readCGIResponse parses the CGI headers at the beginning of the responder output.
+func readCGIResponse(req *http.Request, body *fastCGIResponseBody) (*http.Response, error) {
+	mimeHeader, err := textproto.NewReader(body.Reader).ReadMIMEHeader()
+	if err != nil && !(err == io.EOF && len(mimeHeader) > 0) {
+		return nil, err
+	}
+	header := http.Header(mimeHeader)
+	statusCode := http.StatusOK
+	if status := header.Get("Status"); status != "" {
+		code, _, _ := strings.Cut(status, " ")
+		statusCode, err = strconv.Atoi(code)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Status header %q", status)
+		}
+		header.Del("Status")
+	} else if header.Get("Location") != "" {
+		statusCode = http.StatusFound
+	}
+	contentLength := int64(-1)
+	if cl, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil {
+		contentLength = cl
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          body,
+		ContentLength: contentLength,
+		Request:       req,
+	}, nil
+}
+
+type fastCGIResponseBody struct {
+	*bufio.Reader
+	stdout *io.PipeReader
+	conn   net.Conn
+	stop   func() bool
+}
+
+func (b *fastCGIResponseBody) Close() error {
+	b.stop()
+	_ = b.stdout.Close()
+	return b.conn.Close()
+}
+
+func writeFastCGIRecord(w io.Writer, recordType byte, content []byte) error {
+	header := [fastCGIHeaderLen]byte{fastCGIVersion, recordType}
+	binary.BigEndian.PutUint16(header[2:4], fastCGIRequestID)
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(content))) // nolint: gosec
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(content)
+	return err
+}
+
+func writeFastCGIBeginRequest(w io.Writer) error {
+	// Role, then flags: the connection is closed by the responder at the end of the request
+	body := [8]byte{0, fastCGIRoleResponder, 0}
+	return writeFastCGIRecord(w, fastCGIBeginRequest, body[:])
+}
+
+func writeFastCGIParams(w io.Writer, params map[string]string) error {
+	var buf bytes.Buffer
+	for name, value := range params {
+		writeFastCGILength(&buf, len(name))
+		writeFastCGILength(&buf, len(value))
+		buf.WriteString(name)
+		buf.WriteString(value)
+	}
+	return writeFastCGIStream(w, fastCGIParams, &buf)
+}
+
+func writeFastCGILength(buf *bytes.Buffer, length int) {
+	if length < 128 {
+		buf.WriteByte(byte(length))
+		return
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(length)|1<<31) // nolint: gosec
+	buf.Write(b[:])
+}
+
+// writeFastCGIStream sends r as records of recordType, terminated by an empty record.
+func writeFastCGIStream(w io.Writer, recordType byte, r io.Reader) error {
+	buf := make([]byte, fastCGIMaxContent)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if err := writeFastCGIRecord(w, recordType, buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return writeFastCGIRecord(w, recordType, nil)
+}
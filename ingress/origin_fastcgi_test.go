@@ -0,0 +1,114 @@
+package ingress
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestFastCGIService(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		_ = fcgi.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			env := fcgi.ProcessEnv(r)
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Script", env["SCRIPT_FILENAME"])
+			w.WriteHeader(http.StatusCreated)
+			_, _ = fmt.Fprintf(w, "%s %s %s %s %s", r.Method, r.URL.Path, r.Header.Get("X-Test"), env["APP_ENV"], body)
+		}))
+	}()
+
+	log := zerolog.Nop()
+	service := &fastCGIService{url: &url.URL{Scheme: "fastcgi", Host: listener.Addr().String()}}
+	cfg := OriginRequestConfig{FastCGI: config.FastCGIConfig{
+		DocumentRoot: "/var/www",
+		Params:       map[string]string{"APP_ENV": "test"},
+	}}
+	require.NoError(t, service.start(&log, nil, cfg))
+
+	req, err := http.NewRequest(http.MethodPost, "http://example.com/index.php/users?id=1", io.NopCloser(strings.NewReader("hello")))
+	require.NoError(t, err)
+	// Unknown length bodies are buffered to send CONTENT_LENGTH
+	req.ContentLength = -1
+	req.Header.Set("X-Test", "value")
+	resp, err := service.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, "/var/www/index.php", resp.Header.Get("X-Script"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "POST /index.php/users value test hello", string(body))
+}
+
+func TestSplitScriptPath(t *testing.T) {
+	tests := []struct {
+		path, scriptName, pathInfo string
+	}{
+		{path: "/", scriptName: "/index.php"},
+		{path: "/blog/", scriptName: "/blog/index.php"},
+		{path: "/info.php", scriptName: "/info.php"},
+		{path: "/index.php/users/1", scriptName: "/index.php", pathInfo: "/users/1"},
+		{path: "", scriptName: "/index.php"},
+		{path: "/../../etc/x.php", scriptName: "/etc/x.php"},
+		{path: "/blog/../../", scriptName: "/index.php"},
+		{path: "/blog/../admin/./info.php/a/../b", scriptName: "/admin/info.php", pathInfo: "/b"},
+	}
+	for _, test := range tests {
+		scriptName, pathInfo := splitScriptPath(test.path, defaultFastCGIIndex)
+		require.Equal(t, test.scriptName, scriptName, test.path)
+		require.Equal(t, test.pathInfo, pathInfo, test.path)
+	}
+}
+
+func TestFastCGIParamsPathTraversal(t *testing.T) {
+	service := &fastCGIService{config: config.FastCGIConfig{DocumentRoot: "/var/www", Index: defaultFastCGIIndex}}
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/%2e%2e/%2e%2e/etc/x.php", nil)
+	require.NoError(t, err)
+	require.Equal(t, "/../../etc/x.php", req.URL.Path)
+
+	params := service.params(req, 0)
+	require.Equal(t, "/var/www/etc/x.php", params["SCRIPT_FILENAME"])
+	require.Equal(t, "/etc/x.php", params["SCRIPT_NAME"])
+}
+
+func TestParseFastCGIIngress(t *testing.T) {
+	rawYAML := `
+ingress:
+- hostname: php.example.com
+  service: fastcgi://127.0.0.1:9000
+  originRequest:
+    fastcgi:
+      documentRoot: /var/www
+- hostname: fpm.example.com
+  service: fastcgi+unix:///run/php/fpm.sock
+  originRequest:
+    fastcgi:
+      scriptFilename: /var/www/index.php
+- service: http_status:404
+`
+	ing, err := ParseIngress(MustReadIngress(rawYAML))
+	require.NoError(t, err)
+	require.IsType(t, &fastCGIService{}, ing.Rules[0].Service)
+	require.Equal(t, "/var/www", ing.Rules[0].Config.FastCGI.DocumentRoot)
+	require.Equal(t, "fastcgi+unix:/run/php/fpm.sock", ing.Rules[1].Service.String())
+
+	_, err = ParseIngress(MustReadIngress(`
+ingress:
+- service: fastcgi://127.0.0.1:9000
+`))
+	require.Error(t, err)
+}
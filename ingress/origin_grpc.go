@@ -0,0 +1,105 @@
+package ingress
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"golang.org/x/net/http2"
+
+	"github.com/cloudflare/cloudflared/tlsconfig"
+)
+
+// grpcService proxies requests to a gRPC origin, always speaking HTTP/2 to it: in cleartext (h2c) for grpc:// origins
+// and over TLS for grpcs:// origins. gRPC doesn't work over HTTP/1.1 because it relies on trailers and full duplex
+// streams.
+type grpcService struct {
+	url        *url.URL
+	hostHeader string
+	transport  *http2.Transport
+}
+
+func isGRPCService(url *url.URL) bool {
+	return url.Scheme == "grpc" || url.Scheme == "grpcs"
+}
+
+func (o *grpcService) String() string {
+	return o.url.String()
+}
+
+func (o grpcService) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.String())
+}
+
+func (o *grpcService) start(log *zerolog.Logger, _ <-chan struct{}, cfg OriginRequestConfig) error {
+	originCertPool, err := tlsconfig.LoadOriginCA(cfg.CAPool, log)
+	if err != nil {
+		return errors.Wrap(err, "Error loading cert pool")
+	}
+	dialer := &net.Dialer{
+		Timeout:   cfg.ConnectTimeout.Duration,
+		KeepAlive: cfg.TCPKeepAlive.Duration,
+	}
+	if cfg.NoHappyEyeballs {
+		dialer.FallbackDelay = -1
+	}
+	cleartext := o.url.Scheme == "grpc"
+	tlsConfig := &tls.Config{
+		RootCAs:            originCertPool,
+		InsecureSkipVerify: cfg.NoTLSVerify, // nolint: gosec
+		ServerName:         cfg.OriginServerName,
+	}
+	if cfg.TrustOnFirstUse.Enabled {
+		if err := applyTrustOnFirstUse(tlsConfig, cfg, o.String(), log); err != nil {
+			return err
+		}
+	}
+
+	o.hostHeader = cfg.HTTPHostHeader
+	o.transport = &http2.Transport{
+		AllowHTTP:       cleartext,
+		TLSClientConfig: tlsConfig,
+		// Send pings on idle connections to detect origins that went away
+		ReadIdleTimeout: cfg.KeepAliveTimeout.Duration,
+		DialTLSContext: func(ctx context.Context, network, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil || cleartext {
+				return conn, err
+			}
+			if cfg.TLSTimeout.Duration > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, cfg.TLSTimeout.Duration)
+				defer cancel()
+			}
+			tlsConn := tls.Client(conn, tlsConfig)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		},
+	}
+	return nil
+}
+
+func (o *grpcService) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Host = o.url.Host
+	if o.url.Scheme == "grpcs" {
+		req.URL.Scheme = "https"
+	} else {
+		req.URL.Scheme = "http"
+	}
+	if o.hostHeader != "" {
+		req.Header.Set("X-Forwarded-Host", req.Host)
+		req.Host = o.hostHeader
+	}
+	// Connection specific headers are meaningless in HTTP/2, and gRPC servers require TE: trailers
+	req.Header.Del("Connection")
+	req.Header.Set("TE", "trailers")
+	return o.transport.RoundTrip(req)
+}
@@ -0,0 +1,70 @@
+package ingress
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestGRPCServiceCleartext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.Header.Get("TE") != "trailers" {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = w.Write(body)
+		w.Header().Set("Grpc-Status", "0")
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go (&http2.Server{}).ServeConn(conn, &http2.ServeConnOpts{Handler: handler})
+		}
+	}()
+
+	ing, err := ParseIngress(MustReadIngress(`
+ingress:
+- service: grpc://` + listener.Addr().String() + `
+`))
+	require.NoError(t, err)
+	service, ok := ing.Rules[0].Service.(*grpcService)
+	require.True(t, ok)
+
+	log := zerolog.Nop()
+	shutdownC := make(chan struct{})
+	defer close(shutdownC)
+	require.NoError(t, service.start(&log, shutdownC, originRequestFromConfig(config.OriginRequestConfig{})))
+
+	req, err := http.NewRequest(http.MethodPost, "http://grpc.example.com/helloworld.Greeter/SayHello", strings.NewReader("message"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Connection", "keep-alive")
+	resp, err := service.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 2, resp.ProtoMajor)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "message", string(body))
+	require.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+}
@@ -21,6 +21,10 @@ const (
 	mtu = 1500
 	// icmpRequestTimeoutMs controls how long to wait for a reply
 	icmpRequestTimeoutMs = 1000
+
+	// Reasons the ICMP messages aren't proxied
+	icmpUnproxiedNeighborDiscovery = "neighbor_discovery"
+	icmpUnproxiedLinkLocal         = "link_local"
 )
 
 var (
@@ -32,6 +36,29 @@ type ICMPRouterServer interface {
 	ICMPRouter
 	// Serve runs the ICMPRouter proxy origin listeners for any of the IPv4 or IPv6 interfaces configured.
 	Serve(ctx context.Context) error
+	// UpdatePolicy replaces the policy applied to the following requests.
+	UpdatePolicy(policy ICMPPolicy)
+	// Capability returns the backend proxying the requests and the IP versions it proxies.
+	Capability() ICMPCapability
+}
+
+// ICMPCapability is the ICMP proxy backend of the platform, and the IP versions it could be created for.
+type ICMPCapability struct {
+	Backend string
+	IPv4    bool
+	IPv6    bool
+}
+
+func (c ICMPCapability) String() string {
+	switch {
+	case c.IPv4 && c.IPv6:
+		return c.Backend + " (ipv4, ipv6)"
+	case c.IPv4:
+		return c.Backend + " (ipv4)"
+	case c.IPv6:
+		return c.Backend + " (ipv6)"
+	}
+	return "disabled"
 }
 
 // ICMPRouter manages out-going ICMP requests towards the origin.
@@ -59,6 +86,7 @@ type icmpRouter struct {
 	ipv4Src   netip.Addr
 	ipv6Proxy *icmpProxy
 	ipv6Src   netip.Addr
+	policy    icmpPolicyEnforcer
 }
 
 // NewICMPRouter doesn't return an error if either ipv4 proxy or ipv6 proxy can be created. The machine might only
@@ -88,6 +116,14 @@ func NewICMPRouter(ipv4Addr, ipv6Addr netip.Addr, logger *zerolog.Logger, funnel
 	}, nil
 }
 
+func (ir *icmpRouter) Capability() ICMPCapability {
+	return ICMPCapability{
+		Backend: icmpBackend,
+		IPv4:    ir.ipv4Proxy != nil,
+		IPv6:    ir.ipv6Proxy != nil,
+	}
+}
+
 func (ir *icmpRouter) Serve(ctx context.Context) error {
 	if ir.ipv4Proxy != nil && ir.ipv6Proxy != nil {
 		errC := make(chan error, 2)
@@ -112,6 +148,16 @@ func (ir *icmpRouter) Request(ctx context.Context, pk *packet.ICMP, responder IC
 	if pk == nil {
 		return errPacketNil
 	}
+	if reason := linkScoped(pk); reason != "" {
+		// The eyeball's stack sends them on the virtual link of the tunnel, dropping them isn't an error
+		icmpUnproxied.WithLabelValues(reason).Inc()
+		return nil
+	}
+	if dropReason := ir.policy.admit(pk, time.Now()); dropReason != "" {
+		// Requests denied by the policy are dropped silently, like a firewall would
+		icmpPolicyDrops.WithLabelValues(dropReason).Inc()
+		return nil
+	}
 	if pk.Dst.Is4() {
 		if ir.ipv4Proxy != nil {
 			return ir.ipv4Proxy.Request(ctx, pk, responder)
@@ -124,6 +170,10 @@ func (ir *icmpRouter) Request(ctx context.Context, pk *packet.ICMP, responder IC
 	return fmt.Errorf("ICMPv6 proxy was not instantiated")
 }
 
+func (ir *icmpRouter) UpdatePolicy(policy ICMPPolicy) {
+	ir.policy.update(policy)
+}
+
 func (ir *icmpRouter) ConvertToTTLExceeded(pk *packet.ICMP, rawPacket packet.RawPacket) *packet.ICMP {
 	var srcIP netip.Addr
 	if pk.Dst.Is4() {
@@ -134,6 +184,26 @@ func (ir *icmpRouter) ConvertToTTLExceeded(pk *packet.ICMP, rawPacket packet.Raw
 	return packet.NewICMPTTLExceedPacket(pk.IP, rawPacket, srcIP)
 }
 
+// linkScoped returns why the message can't be proxied if it's only meant for the link of the eyeball: the neighbor
+// discovery and multicast listener messages of ICMPv6, and the messages to IPv6 link-local or multicast destinations,
+// which can't be routed to an origin.
+func linkScoped(pk *packet.ICMP) string {
+	if !pk.Dst.Is6() || pk.Dst.Is4In6() {
+		return ""
+	}
+	switch pk.Type {
+	case ipv6.ICMPTypeRouterSolicitation, ipv6.ICMPTypeRouterAdvertisement, ipv6.ICMPTypeNeighborSolicitation,
+		ipv6.ICMPTypeNeighborAdvertisement, ipv6.ICMPTypeRedirect, ipv6.ICMPTypeMulticastListenerQuery,
+		ipv6.ICMPTypeMulticastListenerReport, ipv6.ICMPTypeMulticastListenerDone,
+		ipv6.ICMPTypeVersion2MulticastListenerReport:
+		return icmpUnproxiedNeighborDiscovery
+	}
+	if pk.Dst.IsLinkLocalUnicast() || pk.Dst.IsMulticast() {
+		return icmpUnproxiedLinkLocal
+	}
+	return ""
+}
+
 func getICMPEcho(msg *icmp.Message) (*icmp.Echo, error) {
 	echo, ok := msg.Body.(*icmp.Echo)
 	if !ok {
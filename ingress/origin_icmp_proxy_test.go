@@ -346,6 +346,53 @@ func TestICMPRouterRejectNotEcho(t *testing.T) {
 	testICMPRouterRejectNotEcho(t, localhostIPv6, msgsV6)
 }
 
+// TestICMPRouterDropLinkScoped makes sure the ICMPv6 messages meant for the link of the eyeball are dropped, without
+// reaching a proxy
+func TestICMPRouterDropLinkScoped(t *testing.T) {
+	router := &icmpRouter{}
+	newICMPv6 := func(dst string, msg *icmp.Message) *packet.ICMP {
+		return &packet.ICMP{
+			IP: &packet.IP{
+				Src:      netip.MustParseAddr("fd00::2"),
+				Dst:      netip.MustParseAddr(dst),
+				Protocol: layers.IPProtocolICMPv6,
+				TTL:      255,
+			},
+			Message: msg,
+		}
+	}
+	echo := &icmp.Message{Type: ipv6.ICMPTypeEchoRequest, Body: &icmp.Echo{ID: 1, Seq: 1}}
+
+	dropped := []*packet.ICMP{
+		newICMPv6("ff02::1:ff00:1", &icmp.Message{Type: ipv6.ICMPTypeNeighborSolicitation, Body: &icmp.RawBody{}}),
+		newICMPv6("fd00::1", &icmp.Message{Type: ipv6.ICMPTypeNeighborSolicitation, Body: &icmp.RawBody{}}),
+		newICMPv6("ff02::2", &icmp.Message{Type: ipv6.ICMPTypeRouterSolicitation, Body: &icmp.RawBody{}}),
+		newICMPv6("ff02::16", &icmp.Message{Type: ipv6.ICMPTypeVersion2MulticastListenerReport, Body: &icmp.RawBody{}}),
+		newICMPv6("fe80::1", echo),
+		newICMPv6("ff02::1", echo),
+	}
+	for _, pk := range dropped {
+		require.NoError(t, router.Request(context.Background(), pk, nil), "%s to %s", pk.Type, pk.Dst)
+	}
+
+	// Echo requests to the private ranges are proxied
+	require.ErrorContains(t, router.Request(context.Background(), newICMPv6("fd00::1", echo), nil), "ICMPv6 proxy was not instantiated")
+}
+
+func TestICMPRouterCapability(t *testing.T) {
+	router := &icmpRouter{ipv4Proxy: &icmpProxy{}}
+	require.Equal(t, ICMPCapability{Backend: icmpBackend, IPv4: true}, router.Capability())
+	require.Equal(t, icmpBackend+" (ipv4)", router.Capability().String())
+
+	router.ipv6Proxy = &icmpProxy{}
+	require.Equal(t, icmpBackend+" (ipv4, ipv6)", router.Capability().String())
+
+	router.ipv4Proxy = nil
+	require.Equal(t, icmpBackend+" (ipv6)", router.Capability().String())
+
+	require.Equal(t, "disabled", ICMPCapability{}.String())
+}
+
 func testICMPRouterRejectNotEcho(t *testing.T, srcDstIP netip.Addr, msgs []icmp.Message) {
 	router, err := NewICMPRouter(localhostIP, localhostIPv6, &noopLogger, testFunnelIdleTimeout)
 	require.NoError(t, err)
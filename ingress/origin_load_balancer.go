@@ -0,0 +1,266 @@
+package ingress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+const (
+	healthCheckHTTP = "http"
+	healthCheckTCP  = "tcp"
+
+	defaultHealthCheckPath               = "/"
+	defaultHealthCheckInterval           = 10 * time.Second
+	defaultHealthCheckTimeout            = 2 * time.Second
+	defaultHealthCheckUnhealthyThreshold = 3
+	defaultHealthCheckHealthyThreshold   = 2
+)
+
+var (
+	errNoLoadBalancerOrigins = errors.New("load_balancer service requires at least one origin in originRequest.loadBalancer.origins")
+	errNoHealthyOrigins      = errors.New("all origins of the load_balancer service are unhealthy")
+)
+
+// loadBalancerService is an OriginService that spreads HTTP requests across several weighted origins.
+// Origins failing active health checks are removed from rotation until they recover.
+type loadBalancerService struct {
+	origins     []*balancedOrigin
+	healthCheck config.HealthCheckConfig
+	log         *zerolog.Logger
+}
+
+// balancedOrigin is a single origin of a loadBalancerService.
+type balancedOrigin struct {
+	*httpService
+	weight  uint
+	healthy atomic.Bool
+
+	// Consecutive health check results, only accessed by the health check loop
+	successes uint
+	failures  uint
+}
+
+func newLoadBalancerService(cfg config.LoadBalancerConfig) (*loadBalancerService, error) {
+	if len(cfg.Origins) == 0 {
+		return nil, errNoLoadBalancerOrigins
+	}
+	switch cfg.HealthCheck.Type {
+	case "", healthCheckHTTP, healthCheckTCP:
+	default:
+		return nil, fmt.Errorf("unknown health check type %q, valid options are %q or %q", cfg.HealthCheck.Type, healthCheckHTTP, healthCheckTCP)
+	}
+
+	origins := make([]*balancedOrigin, len(cfg.Origins))
+	for i, origin := range cfg.Origins {
+		u, err := url.Parse(origin.URL)
+		if err != nil {
+			return nil, err
+		}
+		if !isHTTPService(u) || u.Hostname() == "" {
+			return nil, fmt.Errorf("%s is an invalid load_balancer origin, please make sure it is a http(s) URL with a hostname", origin.URL)
+		}
+		if u.Path != "" {
+			return nil, fmt.Errorf("%s is an invalid load_balancer origin, origins don't support proxying to a different path", origin.URL)
+		}
+		weight := origin.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		origins[i] = &balancedOrigin{
+			httpService: &httpService{url: u},
+			weight:      weight,
+		}
+	}
+	return &loadBalancerService{
+		origins:     origins,
+		healthCheck: cfg.HealthCheck,
+	}, nil
+}
+
+func (o *loadBalancerService) String() string {
+	return ServiceLoadBalancer
+}
+
+func (o loadBalancerService) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.String())
+}
+
+func (o *loadBalancerService) start(log *zerolog.Logger, shutdownC <-chan struct{}, cfg OriginRequestConfig) error {
+	for _, origin := range o.origins {
+		if err := origin.httpService.start(log, shutdownC, cfg); err != nil {
+			return err
+		}
+		// Origins start in rotation, health checks take them out if needed.
+		origin.healthy.Store(true)
+	}
+	o.log = log
+	go o.runHealthChecks(shutdownC)
+	return nil
+}
+
+// RoundTrip proxies the request to one of the healthy origins, picked at random according to their weights.
+func (o *loadBalancerService) RoundTrip(req *http.Request) (*http.Response, error) {
+	origin := o.pickOrigin()
+	if origin == nil {
+		return nil, errNoHealthyOrigins
+	}
+	return origin.RoundTrip(req)
+}
+
+func (o *loadBalancerService) pickOrigin() *balancedOrigin {
+	var totalWeight uint
+	for _, origin := range o.origins {
+		if origin.healthy.Load() {
+			totalWeight += origin.weight
+		}
+	}
+	if totalWeight == 0 {
+		return nil
+	}
+	// nolint: gosec
+	pick := uint(rand.Int63n(int64(totalWeight)))
+	for _, origin := range o.origins {
+		if !origin.healthy.Load() {
+			continue
+		}
+		if pick < origin.weight {
+			return origin
+		}
+		pick -= origin.weight
+	}
+	return nil
+}
+
+func (o *loadBalancerService) runHealthChecks(shutdownC <-chan struct{}) {
+	interval := o.healthCheck.Interval.Duration
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-shutdownC:
+			return
+		case <-ticker.C:
+			o.checkOrigins()
+		}
+	}
+}
+
+// checkOrigins runs one round of health checks against all origins concurrently.
+func (o *loadBalancerService) checkOrigins() {
+	var wg sync.WaitGroup
+	for _, origin := range o.origins {
+		wg.Add(1)
+		go func(origin *balancedOrigin) {
+			defer wg.Done()
+			o.recordHealthCheck(origin, o.checkOrigin(origin))
+		}(origin)
+	}
+	wg.Wait()
+}
+
+func (o *loadBalancerService) checkOrigin(origin *balancedOrigin) error {
+	timeout := o.healthCheck.Timeout.Duration
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if o.healthCheck.Type == healthCheckTCP {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", originHostPort(origin.url))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	path := o.healthCheck.Path
+	if path == "" {
+		path = defaultHealthCheckPath
+	}
+	checkURL := *origin.url
+	checkURL.Scheme = httpSchemeOf(origin.url)
+	checkURL.Path = path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, checkURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	if origin.hostHeader != "" {
+		req.Host = origin.hostHeader
+	}
+	resp, err := origin.transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	// Only server errors mark an origin as unhealthy, a 404 on the health check path still means it's serving.
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// recordHealthCheck updates the consecutive success/failure counters of an origin and moves it in or out of
+// rotation once the configured thresholds are reached.
+func (o *loadBalancerService) recordHealthCheck(origin *balancedOrigin, checkErr error) {
+	unhealthyThreshold := o.healthCheck.UnhealthyThreshold
+	if unhealthyThreshold == 0 {
+		unhealthyThreshold = defaultHealthCheckUnhealthyThreshold
+	}
+	healthyThreshold := o.healthCheck.HealthyThreshold
+	if healthyThreshold == 0 {
+		healthyThreshold = defaultHealthCheckHealthyThreshold
+	}
+
+	if checkErr != nil {
+		origin.successes = 0
+		origin.failures++
+		if origin.failures >= unhealthyThreshold && origin.healthy.CompareAndSwap(true, false) {
+			o.log.Warn().Err(checkErr).Str("origin", origin.String()).Msg("Origin failed health checks, removing it from rotation")
+		}
+		return
+	}
+	origin.failures = 0
+	origin.successes++
+	if origin.successes >= healthyThreshold && origin.healthy.CompareAndSwap(false, true) {
+		o.log.Info().Str("origin", origin.String()).Msg("Origin passed health checks, adding it back to rotation")
+	}
+}
+
+func httpSchemeOf(u *url.URL) string {
+	switch u.Scheme {
+	case "ws":
+		return "http"
+	case "wss":
+		return "https"
+	default:
+		return u.Scheme
+	}
+}
+
+func originHostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if httpSchemeOf(u) == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
@@ -0,0 +1,139 @@
+package ingress
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestParseLoadBalancer(t *testing.T) {
+	rawYAML := `
+ingress:
+- hostname: lb.example.com
+  service: load_balancer
+  originRequest:
+    loadBalancer:
+      origins:
+      - url: http://localhost:8000
+        weight: 3
+      - url: http://localhost:8001
+      healthCheck:
+        type: tcp
+- service: http_status:404
+`
+	ing, err := ParseIngress(MustReadIngress(rawYAML))
+	require.NoError(t, err)
+	lb, ok := ing.Rules[0].Service.(*loadBalancerService)
+	require.True(t, ok)
+	require.Len(t, lb.origins, 2)
+	require.Equal(t, uint(3), lb.origins[0].weight)
+	require.Equal(t, uint(1), lb.origins[1].weight)
+	require.Equal(t, healthCheckTCP, lb.healthCheck.Type)
+	require.Equal(t, ServiceLoadBalancer, lb.String())
+}
+
+func TestParseLoadBalancerInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawYAML string
+	}{
+		{
+			name: "no origins",
+			rawYAML: `
+ingress:
+- service: load_balancer
+`,
+		},
+		{
+			name: "non http origin",
+			rawYAML: `
+ingress:
+- service: load_balancer
+  originRequest:
+    loadBalancer:
+      origins:
+      - url: ssh://localhost:22
+`,
+		},
+		{
+			name: "unknown health check",
+			rawYAML: `
+ingress:
+- service: load_balancer
+  originRequest:
+    loadBalancer:
+      origins:
+      - url: http://localhost:8000
+      healthCheck:
+        type: icmp
+`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseIngress(MustReadIngress(tc.rawYAML))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestLoadBalancerRemovesUnhealthyOrigins(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, "healthy")
+	}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	lb, err := newLoadBalancerService(config.LoadBalancerConfig{
+		Origins: []config.LoadBalancerOrigin{
+			{URL: healthy.URL},
+			{URL: failing.URL, Weight: 10},
+		},
+		HealthCheck: config.HealthCheckConfig{UnhealthyThreshold: 2, HealthyThreshold: 1},
+	})
+	require.NoError(t, err)
+
+	log := zerolog.Nop()
+	shutdownC := make(chan struct{})
+	defer close(shutdownC)
+	require.NoError(t, lb.start(&log, shutdownC, originRequestFromConfig(config.OriginRequestConfig{})))
+
+	// Both origins start in rotation
+	seen := map[*balancedOrigin]bool{}
+	for i := 0; i < 200; i++ {
+		seen[lb.pickOrigin()] = true
+	}
+	require.Len(t, seen, 2)
+
+	// A single failure is below the threshold
+	lb.checkOrigins()
+	require.True(t, lb.origins[1].healthy.Load())
+	lb.checkOrigins()
+	require.True(t, lb.origins[0].healthy.Load())
+	require.False(t, lb.origins[1].healthy.Load())
+
+	for i := 0; i < 50; i++ {
+		req, err := http.NewRequest(http.MethodGet, "http://lb.example.com/", nil)
+		require.NoError(t, err)
+		resp, err := lb.RoundTrip(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		_ = resp.Body.Close()
+	}
+
+	// Once every origin is out of rotation requests fail fast
+	lb.origins[0].healthy.Store(false)
+	req, err := http.NewRequest(http.MethodGet, "http://lb.example.com/", nil)
+	require.NoError(t, err)
+	_, err = lb.RoundTrip(req)
+	require.ErrorIs(t, err, errNoHealthyOrigins)
+}
@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/http"
 
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 )
 
@@ -40,6 +41,8 @@ func (o *httpService) RoundTrip(req *http.Request) (*http.Response, error) {
 		req.URL.Scheme = "http"
 	case "wss":
 		req.URL.Scheme = "https"
+	case "h2c":
+		req.URL.Scheme = "http"
 	default:
 		req.URL.Scheme = o.url.Scheme
 	}
@@ -55,6 +58,12 @@ func (o *httpService) RoundTrip(req *http.Request) (*http.Response, error) {
 		o.SetOriginServerName(req)
 	}
 
+	if o.upgradeTransport != nil && req.Header.Get("Upgrade") != "" {
+		return o.upgradeTransport.RoundTrip(req)
+	}
+	if o.ntlmAffinity != nil {
+		return o.ntlmAffinity.transport(req).RoundTrip(req)
+	}
 	return o.transport.RoundTrip(req)
 }
 
@@ -64,11 +73,9 @@ func (o *httpService) SetOriginServerName(req *http.Request) {
 		if err != nil {
 			return nil, err
 		}
-		return tls.Client(conn, &tls.Config{
-			RootCAs:            o.transport.TLSClientConfig.RootCAs,
-			InsecureSkipVerify: o.transport.TLSClientConfig.InsecureSkipVerify, // nolint: gosec
-			ServerName:         req.Host,
-		}), nil
+		tlsConfig := o.transport.TLSClientConfig.Clone()
+		tlsConfig.ServerName = req.Host
+		return tls.Client(conn, tlsConfig), nil
 	}
 }
 
@@ -105,10 +112,20 @@ func (o *tcpOverWSService) EstablishConnection(ctx context.Context, dest string,
 		dest = o.dest
 	}
 
-	conn, err := o.dialer.DialContext(ctx, "tcp", dest)
+	var dialer ContextDialer = &o.dialer
+	if o.customDialer != nil {
+		dialer = o.customDialer
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", dest)
 	if err != nil {
 		return nil, err
 	}
+	if o.proxyProtocol.Version != "" {
+		if err := writeProxyProtocolHeader(ctx, conn, o.proxyProtocol, conn.RemoteAddr()); err != nil {
+			_ = conn.Close()
+			return nil, errors.Wrap(err, "failed to write the PROXY protocol header")
+		}
+	}
 	originConn := &tcpOverWSConnection{
 		conn:          conn,
 		streamHandler: o.streamHandler,
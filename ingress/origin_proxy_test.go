@@ -186,6 +186,56 @@ func TestHTTPServiceUsesIngressRuleScheme(t *testing.T) {
 	}
 }
 
+func TestH2CService(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			for k, v := range websocket.NewResponseHeader(r) {
+				w.Header().Set(k, v[0])
+			}
+			w.WriteHeader(http.StatusSwitchingProtocols)
+			return
+		}
+		w.Write([]byte(r.Proto))
+	}
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(handler))
+	origin.Config.Protocols = new(http.Protocols)
+	origin.Config.Protocols.SetHTTP1(true)
+	origin.Config.Protocols.SetUnencryptedHTTP2(true)
+	origin.Start()
+	defer origin.Close()
+
+	ing, err := ParseIngress(MustReadIngress(`
+ingress:
+- service: h2c://` + origin.Listener.Addr().String() + `
+`))
+	require.NoError(t, err)
+	service, ok := ing.Rules[0].Service.(*httpService)
+	require.True(t, ok)
+	shutdownC := make(chan struct{})
+	defer close(shutdownC)
+	require.NoError(t, service.start(TestLogger, shutdownC, OriginRequestConfig{}))
+
+	req, err := http.NewRequest(http.MethodGet, "https://h2c.example.com", nil)
+	require.NoError(t, err)
+	resp, err := service.RoundTrip(req)
+	require.NoError(t, err)
+	respBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/2.0", string(respBody))
+
+	// Websockets are upgraded from HTTP/1.1
+	req, err = http.NewRequest(http.MethodGet, "https://h2c.example.com", nil)
+	require.NoError(t, err)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-Websocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-Websocket-Version", "13")
+	resp, err = service.RoundTrip(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	require.NoError(t, resp.Body.Close())
+}
+
 func tcpListenRoutine(listener net.Listener, closeChan chan struct{}) {
 	go func() {
 		for {
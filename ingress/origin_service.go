@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"net"
 	"net/http"
 	"net/url"
@@ -15,6 +16,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 
+	"github.com/cloudflare/cloudflared/config"
 	"github.com/cloudflare/cloudflared/hello"
 	"github.com/cloudflare/cloudflared/ipaccess"
 	"github.com/cloudflare/cloudflared/management"
@@ -54,8 +56,15 @@ func (o *unixSocketPath) String() string {
 	return fmt.Sprintf("unix%s:%s", scheme, o.path)
 }
 
-func (o *unixSocketPath) start(log *zerolog.Logger, _ <-chan struct{}, cfg OriginRequestConfig) error {
-	transport, err := newHTTPTransport(o, cfg, log)
+func (o *unixSocketPath) start(log *zerolog.Logger, shutdownC <-chan struct{}, cfg OriginRequestConfig) error {
+	if err := validateUnixSocket(o.path); err != nil {
+		// The origin may create its socket after cloudflared starts, the requests fail until it does
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		log.Warn().Err(err).Msg("The origin isn't listening on its unix socket yet")
+	}
+	transport, err := newHTTPTransport(o, cfg, log, shutdownC)
 	if err != nil {
 		return err
 	}
@@ -72,16 +81,27 @@ type httpService struct {
 	hostHeader     string
 	transport      *http.Transport
 	matchSNIToHost bool
+	// ntlmAffinity, when set, pins the requests of the eyeballs doing NTLM authentication to their own connection
+	ntlmAffinity *ntlmAffinity
+	// upgradeTransport, when set, sends the requests upgrading the connection, e.g. to websockets, which HTTP/2
+	// doesn't support
+	upgradeTransport *http.Transport
 }
 
-func (o *httpService) start(log *zerolog.Logger, _ <-chan struct{}, cfg OriginRequestConfig) error {
-	transport, err := newHTTPTransport(o, cfg, log)
+func (o *httpService) start(log *zerolog.Logger, shutdownC <-chan struct{}, cfg OriginRequestConfig) error {
+	transport, err := newHTTPTransport(o, cfg, log, shutdownC)
 	if err != nil {
 		return err
 	}
 	o.hostHeader = cfg.HTTPHostHeader
 	o.transport = transport
 	o.matchSNIToHost = cfg.MatchSNIToHost
+	if o.url != nil && isH2CService(o.url) {
+		o.upgradeTransport = http1Transport(transport)
+	}
+	if cfg.NTLMAffinity {
+		o.ntlmAffinity = newNTLMAffinity(transport, cfg.KeepAliveTimeout.Duration, shutdownC)
+	}
 	return nil
 }
 
@@ -122,6 +142,9 @@ type tcpOverWSService struct {
 	isBastion     bool
 	streamHandler streamHandlerFunc
 	dialer        net.Dialer
+	// customDialer is the registered dialer of the rule, if any, used instead of dialer
+	customDialer  ContextDialer
+	proxyProtocol config.ProxyProtocolConfig
 }
 
 type socksProxyOverWSService struct {
@@ -181,7 +204,7 @@ func (o *tcpOverWSService) String() string {
 	}
 }
 
-func (o *tcpOverWSService) start(log *zerolog.Logger, _ <-chan struct{}, cfg OriginRequestConfig) error {
+func (o *tcpOverWSService) start(log *zerolog.Logger, shutdownC <-chan struct{}, cfg OriginRequestConfig) error {
 	if cfg.ProxyType == socksProxy {
 		o.streamHandler = socks.StreamHandler
 	} else {
@@ -189,6 +212,12 @@ func (o *tcpOverWSService) start(log *zerolog.Logger, _ <-chan struct{}, cfg Ori
 	}
 	o.dialer.Timeout = cfg.ConnectTimeout.Duration
 	o.dialer.KeepAlive = cfg.TCPKeepAlive.Duration
+	customDialer, err := customDialer(log, shutdownC, cfg)
+	if err != nil {
+		return err
+	}
+	o.customDialer = customDialer
+	o.proxyProtocol = cfg.ProxyProtocol
 	return nil
 }
 
@@ -343,7 +372,7 @@ func (nrc *NopReadCloser) Close() error {
 	return nil
 }
 
-func newHTTPTransport(service OriginService, cfg OriginRequestConfig, log *zerolog.Logger) (*http.Transport, error) {
+func newHTTPTransport(service OriginService, cfg OriginRequestConfig, log *zerolog.Logger, shutdownC <-chan struct{}) (*http.Transport, error) {
 	originCertPool, err := tlsconfig.LoadOriginCA(cfg.CAPool, log)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error loading cert pool")
@@ -362,6 +391,11 @@ func newHTTPTransport(service OriginService, cfg OriginRequestConfig, log *zerol
 	if _, isHelloWorld := service.(*helloWorld); !isHelloWorld && cfg.OriginServerName != "" {
 		httpTransport.TLSClientConfig.ServerName = cfg.OriginServerName
 	}
+	if cfg.TrustOnFirstUse.Enabled {
+		if err := applyTrustOnFirstUse(httpTransport.TLSClientConfig, cfg, service.String(), log); err != nil {
+			return nil, err
+		}
+	}
 
 	dialer := &net.Dialer{
 		Timeout:   cfg.ConnectTimeout.Duration,
@@ -373,6 +407,13 @@ func newHTTPTransport(service OriginService, cfg OriginRequestConfig, log *zerol
 
 	// DialContext depends on which kind of origin is being used.
 	dialContext := dialer.DialContext
+	customDialer, err := customDialer(log, shutdownC, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if customDialer != nil {
+		dialContext = customDialer.DialContext
+	}
 	switch service := service.(type) {
 
 	// If this origin is a unix socket, enforce network type "unix".
@@ -386,9 +427,25 @@ func newHTTPTransport(service OriginService, cfg OriginRequestConfig, log *zerol
 		httpTransport.DialContext = dialContext
 	}
 
+	// h2c origins are spoken to with HTTP/2 over cleartext TCP, without upgrading from HTTP/1.1
+	if service, ok := service.(*httpService); ok && service.url != nil && isH2CService(service.url) {
+		protocols := new(http.Protocols)
+		protocols.SetUnencryptedHTTP2(true)
+		httpTransport.Protocols = protocols
+	}
+
 	return &httpTransport, nil
 }
 
+// http1Transport returns a copy of the transport only speaking HTTP/1.1.
+func http1Transport(transport *http.Transport) *http.Transport {
+	http1 := transport.Clone()
+	http1.Protocols = nil
+	http1.ForceAttemptHTTP2 = false
+	http1.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	return http1
+}
+
 // MockOriginHTTPService should only be used by other packages to mock OriginService. Set Transport to configure desired RoundTripper behavior.
 type MockOriginHTTPService struct {
 	Transport http.RoundTripper
@@ -0,0 +1,272 @@
+package ingress
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+const (
+	defaultSSHPort           = "22"
+	defaultKnownHostsFile    = "~/.ssh/known_hosts"
+	sshAuthSockEnv           = "SSH_AUTH_SOCK"
+	defaultSSHConnectTimeout = 30 * time.Second
+	// sshKeepaliveInterval is how often the connection to the jump host is checked with a keepalive request, so that
+	// a connection silently dropped by a middlebox is established again instead of failing the dials
+	sshKeepaliveInterval = 30 * time.Second
+	sshKeepaliveRequest  = "keepalive@openssh.com"
+)
+
+// sshJumpDialer dials origins through an SSH jump host, with direct-tcpip channels for tcp origins and
+// direct-streamlocal channels for unix sockets. A single SSH connection to the jump host is shared by the dials, and
+// established again on the next dial once it's lost, or once it doesn't answer a keepalive request.
+type sshJumpDialer struct {
+	address        string
+	clientConfig   *ssh.ClientConfig
+	connectTimeout time.Duration
+	log            *zerolog.Logger
+	// agentSocket is the socket of the SSH agent, if the user is authenticated by it
+	agentSocket string
+
+	lock   sync.Mutex
+	client *ssh.Client
+	// agentConn is the connection to the SSH agent, established again when authenticating fails
+	agentConn net.Conn
+	closed    bool
+}
+
+func newSSHJumpDialer(cfg config.SSHJumpConfig, connectTimeout time.Duration, log *zerolog.Logger, shutdownC <-chan struct{}) (*sshJumpDialer, error) {
+	address := cfg.Host
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultSSHPort)
+	}
+	if connectTimeout <= 0 {
+		connectTimeout = defaultSSHConnectTimeout
+	}
+	hostKeyCallback, err := knownHostsCallback(cfg.KnownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+	d := &sshJumpDialer{
+		address:        address,
+		connectTimeout: connectTimeout,
+		log:            log,
+	}
+	var auth []ssh.AuthMethod
+	if cfg.KeyFile != "" {
+		signer, err := loadSSHKey(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Agent {
+		d.agentSocket = os.Getenv(sshAuthSockEnv)
+		if d.agentSocket == "" {
+			return nil, errors.Errorf("sshJump uses the SSH agent, but %s isn't set", sshAuthSockEnv)
+		}
+		if err := d.dialAgent(); err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeysCallback(d.agentSigners))
+	}
+	d.clientConfig = &ssh.ClientConfig{
+		User:            cfg.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         connectTimeout,
+	}
+	if shutdownC != nil {
+		go func() {
+			<-shutdownC
+			d.close()
+		}()
+	}
+	return d, nil
+}
+
+func knownHostsCallback(path string) (ssh.HostKeyCallback, error) {
+	if path == "" {
+		path = defaultKnownHostsFile
+	}
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to find the known_hosts file of sshJump")
+	}
+	callback, err := knownhosts.New(filepath.Clean(path))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load the known_hosts file %s of sshJump", path)
+	}
+	return callback, nil
+}
+
+func loadSSHKey(path string) (ssh.Signer, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to find the key file of sshJump")
+	}
+	key, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read the key file of sshJump")
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse the key file %s of sshJump", path)
+	}
+	return signer, nil
+}
+
+func (d *sshJumpDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	client, err := d.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to dial %s through the SSH jump host %s", address, d.address)
+	}
+	return conn, nil
+}
+
+// getClient returns the SSH connection to the jump host, establishing it if there is none.
+func (d *sshJumpDialer) getClient(ctx context.Context) (*ssh.Client, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return nil, errors.New("the SSH jump host dialer is closed")
+	}
+	if d.client != nil {
+		return d.client, nil
+	}
+
+	dialer := net.Dialer{Timeout: d.connectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", d.address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to connect to the SSH jump host %s", d.address)
+	}
+	// Bound the handshake like ssh.Dial does
+	_ = conn.SetDeadline(time.Now().Add(d.connectTimeout))
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, d.address, d.clientConfig)
+	if err != nil {
+		_ = conn.Close()
+		// The agent may have been restarted, or its connection lost
+		d.closeAgent()
+		return nil, errors.Wrapf(err, "unable to authenticate to the SSH jump host %s", d.address)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(sshConn, chans, reqs)
+	d.client = client
+	d.log.Debug().Str("sshJumpHost", d.address).Msg("Connected to the SSH jump host")
+
+	done := make(chan struct{})
+	go func() {
+		err := client.Wait()
+		close(done)
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		if d.client == client {
+			d.client = nil
+			if !d.closed {
+				d.log.Warn().Err(err).Str("sshJumpHost", d.address).Msg("Lost the connection to the SSH jump host, it will be established again on the next dial")
+			}
+		}
+	}()
+	go d.keepalive(client, done, sshKeepaliveInterval)
+	return client, nil
+}
+
+// keepalive sends keepalive requests to the jump host every interval until done is closed, closing the client when
+// one isn't answered in time so that the next dial establishes the connection again.
+func (d *sshJumpDialer) keepalive(client *ssh.Client, done <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		answered := make(chan error, 1)
+		go func() {
+			// OpenSSH replies with a failure to the requests it doesn't know, which still proves the connection is alive
+			_, _, err := client.SendRequest(sshKeepaliveRequest, true, nil)
+			answered <- err
+		}()
+		var err error
+		select {
+		case <-done:
+			return
+		case err = <-answered:
+		case <-time.After(d.connectTimeout):
+			err = errors.New("keepalive timed out")
+		}
+		if err != nil {
+			d.log.Warn().Err(err).Str("sshJumpHost", d.address).Msg("The SSH jump host didn't answer a keepalive request, closing the connection")
+			d.lock.Lock()
+			if d.client == client {
+				d.client = nil
+			}
+			d.lock.Unlock()
+			_ = client.Close()
+			return
+		}
+	}
+}
+
+// dialAgent connects to the SSH agent. The caller must hold the lock, unless the dialer isn't used yet.
+func (d *sshJumpDialer) dialAgent() error {
+	agentConn, err := net.Dial("unix", d.agentSocket)
+	if err != nil {
+		return errors.Wrap(err, "unable to connect to the SSH agent")
+	}
+	d.agentConn = agentConn
+	return nil
+}
+
+// agentSigners returns the keys of the SSH agent, connecting to it again if its connection was closed. It's called
+// while authenticating, with the lock held.
+func (d *sshJumpDialer) agentSigners() ([]ssh.Signer, error) {
+	if d.agentConn == nil {
+		if err := d.dialAgent(); err != nil {
+			return nil, err
+		}
+	}
+	signers, err := agent.NewClient(d.agentConn).Signers()
+	if err != nil {
+		d.closeAgent()
+		return nil, errors.Wrap(err, "unable to list the keys of the SSH agent")
+	}
+	return signers, nil
+}
+
+// closeAgent closes the connection to the SSH agent, if any. The caller must hold the lock.
+func (d *sshJumpDialer) closeAgent() {
+	if d.agentConn != nil {
+		_ = d.agentConn.Close()
+		d.agentConn = nil
+	}
+}
+
+// close closes the connection to the jump host, failing the dials that follow.
+func (d *sshJumpDialer) close() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.closed = true
+	if d.client != nil {
+		_ = d.client.Close()
+		d.client = nil
+	}
+	d.closeAgent()
+}
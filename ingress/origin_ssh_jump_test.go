@@ -0,0 +1,246 @@
+package ingress
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+// serveSSHJumpHost serves an SSH server forwarding the direct-tcpip channels of the user with the key, returning
+// its address.
+func serveSSHJumpHost(t *testing.T, hostKey ssh.Signer, userKey ssh.PublicKey) string {
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "jump" && string(key.Marshal()) == string(userKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, assert.AnError
+		},
+	}
+	serverConfig.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					var forward struct {
+						Host       string
+						Port       uint32
+						OriginHost string
+						OriginPort uint32
+					}
+					if newChannel.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newChannel.ExtraData(), &forward) != nil {
+						_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported channel")
+						continue
+					}
+					origin, err := net.Dial("tcp", net.JoinHostPort(forward.Host, strconv.Itoa(int(forward.Port))))
+					if err != nil {
+						_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					channel, channelReqs, err := newChannel.Accept()
+					if err != nil {
+						_ = origin.Close()
+						continue
+					}
+					go ssh.DiscardRequests(channelReqs)
+					go func() {
+						_, _ = io.Copy(channel, origin)
+						_ = channel.Close()
+					}()
+					go func() {
+						_, _ = io.Copy(origin, channel)
+						_ = origin.Close()
+					}()
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func newSSHSigner(t *testing.T) (ssh.Signer, ed25519.PrivateKey) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	return signer, key
+}
+
+func TestSSHJumpDialer(t *testing.T) {
+	hostKey, _ := newSSHSigner(t)
+	userKey, userPrivateKey := newSSHSigner(t)
+	jumpHost := serveSSHJumpHost(t, hostKey, userKey.PublicKey())
+
+	dir := t.TempDir()
+	block, err := ssh.MarshalPrivateKey(userPrivateKey, "")
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, "id_ed25519")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600))
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	require.NoError(t, os.WriteFile(knownHostsFile, []byte(knownhosts.Line([]string{knownhosts.Normalize(jumpHost)}, hostKey.PublicKey())+"\n"), 0o600))
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("through the jump host"))
+	}))
+	defer origin.Close()
+
+	sshJump := &config.SSHJumpConfig{Host: jumpHost, User: "jump", KeyFile: keyFile, KnownHostsFile: knownHostsFile}
+	ing, err := ParseIngress(&config.Configuration{
+		TunnelID: t.Name(),
+		Ingress: []config.UnvalidatedIngressRule{
+			{Hostname: "app.example.com", Service: origin.URL, OriginRequest: config.OriginRequestConfig{SSHJump: sshJump}},
+			{Service: "tcp://" + origin.Listener.Addr().String(), OriginRequest: config.OriginRequestConfig{SSHJump: sshJump}},
+		},
+	})
+	require.NoError(t, err)
+	log := zerolog.Nop()
+	shutdownC := make(chan struct{})
+	defer close(shutdownC)
+	require.NoError(t, ing.StartOrigins(&log, shutdownC))
+
+	req, err := http.NewRequest(http.MethodGet, origin.URL, nil)
+	require.NoError(t, err)
+	resp, err := ing.Rules[0].Service.(HTTPOriginProxy).RoundTrip(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "through the jump host", string(body))
+
+	conn, err := ing.Rules[1].Service.(StreamBasedOriginProxy).EstablishConnection(context.Background(), "", &log)
+	require.NoError(t, err)
+	conn.Close()
+
+	// The jump host is only trusted with the host key of known_hosts
+	otherHostKey, _ := newSSHSigner(t)
+	require.NoError(t, os.WriteFile(knownHostsFile, []byte(knownhosts.Line([]string{knownhosts.Normalize(jumpHost)}, otherHostKey.PublicKey())+"\n"), 0o600))
+	dialer, err := newSSHJumpDialer(*sshJump, 0, &log, shutdownC)
+	require.NoError(t, err)
+	_, err = dialer.DialContext(context.Background(), "tcp", origin.Listener.Addr().String())
+	require.ErrorContains(t, err, "key mismatch")
+}
+
+func TestSSHJumpDialerKeepalive(t *testing.T) {
+	hostKey, _ := newSSHSigner(t)
+	userKey, userPrivateKey := newSSHSigner(t)
+	jumpHost := serveSSHJumpHost(t, hostKey, userKey.PublicKey())
+
+	// The jump host is reached through a proxy that stops forwarding once frozen, like a dropped NAT mapping
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	frozen := make(chan struct{})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", jumpHost)
+			if err != nil {
+				_ = conn.Close()
+				return
+			}
+			forward := func(dst, src net.Conn) {
+				buf := make([]byte, 32*1024)
+				for {
+					n, err := src.Read(buf)
+					if err != nil {
+						return
+					}
+					select {
+					case <-frozen:
+						return
+					default:
+					}
+					if _, err := dst.Write(buf[:n]); err != nil {
+						return
+					}
+				}
+			}
+			go forward(upstream, conn)
+			go forward(conn, upstream)
+		}
+	}()
+
+	dir := t.TempDir()
+	block, err := ssh.MarshalPrivateKey(userPrivateKey, "")
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, "id_ed25519")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600))
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	proxyAddr := listener.Addr().String()
+	require.NoError(t, os.WriteFile(knownHostsFile, []byte(knownhosts.Line([]string{knownhosts.Normalize(proxyAddr)}, hostKey.PublicKey())+"\n"), 0o600))
+
+	log := zerolog.Nop()
+	shutdownC := make(chan struct{})
+	defer close(shutdownC)
+	sshJump := config.SSHJumpConfig{Host: proxyAddr, User: "jump", KeyFile: keyFile, KnownHostsFile: knownHostsFile}
+	dialer, err := newSSHJumpDialer(sshJump, 100*time.Millisecond, &log, shutdownC)
+	require.NoError(t, err)
+	client, err := dialer.getClient(context.Background())
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		dialer.keepalive(client, done, 10*time.Millisecond)
+		close(stopped)
+	}()
+	// Answered keepalives keep the connection
+	time.Sleep(50 * time.Millisecond)
+	dialer.lock.Lock()
+	assert.Equal(t, client, dialer.client)
+	dialer.lock.Unlock()
+
+	close(frozen)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the unanswered keepalive didn't close the connection")
+	}
+	close(done)
+	dialer.lock.Lock()
+	assert.Nil(t, dialer.client)
+	dialer.lock.Unlock()
+}
+
+func TestValidateSSHJumpConfiguration(t *testing.T) {
+	require.NoError(t, validateSSHJumpConfiguration(OriginRequestConfig{}))
+	require.NoError(t, validateSSHJumpConfiguration(OriginRequestConfig{SSHJump: config.SSHJumpConfig{Host: "bastion", User: "jump", Agent: true}}))
+	require.Error(t, validateSSHJumpConfiguration(OriginRequestConfig{SSHJump: config.SSHJumpConfig{User: "jump", Agent: true}}))
+	require.Error(t, validateSSHJumpConfiguration(OriginRequestConfig{SSHJump: config.SSHJumpConfig{Host: "bastion", Agent: true}}))
+	require.Error(t, validateSSHJumpConfiguration(OriginRequestConfig{SSHJump: config.SSHJumpConfig{Host: "bastion", User: "jump"}}))
+	require.Error(t, validateSSHJumpConfiguration(OriginRequestConfig{Dialer: "mesh", SSHJump: config.SSHJumpConfig{Host: "bastion", User: "jump", KeyFile: "id_ed25519"}}))
+}
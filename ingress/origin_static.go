@@ -0,0 +1,352 @@
+package ingress
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+const (
+	staticServicePrefix = "static:"
+
+	dotfilesIgnore = "ignore"
+	dotfilesDeny   = "deny"
+	dotfilesAllow  = "allow"
+)
+
+var defaultStaticIndexFiles = []string{"index.html"}
+
+// staticService serves the files of a local directory. Files are opened through an [os.Root], so that symlinks can't
+// be used to escape the directory.
+type staticService struct {
+	dir    string
+	config config.StaticConfig
+	root   *os.Root
+	log    *zerolog.Logger
+}
+
+// parseStaticService parses the static:/path and static:///path forms of a static file service.
+func parseStaticService(service string) (*staticService, bool) {
+	dir, ok := trimServicePrefix(service, staticServicePrefix)
+	if !ok {
+		return nil, false
+	}
+	return &staticService{dir: dir}, true
+}
+
+func validateStaticConfiguration(cfg config.StaticConfig) error {
+	switch cfg.Dotfiles {
+	case "", dotfilesIgnore, dotfilesDeny, dotfilesAllow:
+		return nil
+	default:
+		return fmt.Errorf("invalid dotfiles policy %q, expected one of ignore, deny or allow", cfg.Dotfiles)
+	}
+}
+
+func (o *staticService) String() string {
+	return staticServicePrefix + o.dir
+}
+
+func (o staticService) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.String())
+}
+
+func (o *staticService) start(log *zerolog.Logger, shutdownC <-chan struct{}, cfg OriginRequestConfig) error {
+	root, err := os.OpenRoot(o.dir)
+	if err != nil {
+		return errors.Wrapf(err, "unable to open static directory %s", o.dir)
+	}
+	o.root = root
+	o.config = cfg.Static
+	if len(o.config.IndexFiles) == 0 {
+		o.config.IndexFiles = defaultStaticIndexFiles
+	}
+	if o.config.Dotfiles == "" {
+		o.config.Dotfiles = dotfilesIgnore
+	}
+	o.log = log
+	go func() {
+		<-shutdownC
+		_ = root.Close()
+	}()
+	return nil
+}
+
+func (o *staticService) RoundTrip(req *http.Request) (*http.Response, error) {
+	return roundTripHandler(http.HandlerFunc(o.serveHTTP), req), nil
+}
+
+func (o *staticService) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	urlPath := path.Clean("/" + r.URL.Path)
+	if hasDotSegment(urlPath) {
+		switch o.config.Dotfiles {
+		case dotfilesDeny:
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		case dotfilesIgnore:
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	name := strings.TrimPrefix(urlPath, "/")
+	if name == "" {
+		name = "."
+	}
+	f, err := o.root.Open(filepath.FromSlash(name))
+	if err != nil {
+		writeStaticError(w, r, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeStaticError(w, r, err)
+		return
+	}
+
+	if info.IsDir() {
+		if r.URL.Path != "" && !strings.HasSuffix(r.URL.Path, "/") {
+			target := urlPath + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		for _, index := range o.config.IndexFiles {
+			indexFile, err := o.root.Open(filepath.Join(filepath.FromSlash(name), index))
+			if err != nil {
+				continue
+			}
+			defer indexFile.Close()
+			indexInfo, err := indexFile.Stat()
+			if err != nil || indexInfo.IsDir() {
+				continue
+			}
+			o.serveFile(w, r, indexFile, indexInfo)
+			return
+		}
+		if !o.config.DirectoryListing {
+			http.NotFound(w, r)
+			return
+		}
+		o.listDirectory(w, f)
+		return
+	}
+	o.serveFile(w, r, f, info)
+}
+
+func (o *staticService) serveFile(w http.ResponseWriter, r *http.Request, f *os.File, info fs.FileInfo) {
+	if o.config.DisableRanges {
+		r.Header.Del("Range")
+		w = &noRangesResponseWriter{ResponseWriter: w}
+	}
+	contentType := mime.TypeByExtension(path.Ext(info.Name()))
+	if o.config.Compression && isCompressible(contentType) && acceptsGzip(r) {
+		// The compressed size isn't known upfront, so ranges can't be served
+		r.Header.Del("Range")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		w = gw
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+func (o *staticService) listDirectory(w http.ResponseWriter, dir *os.File) {
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		http.Error(w, "Error reading directory", http.StatusInternalServerError)
+		return
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = fmt.Fprintln(w, "<!doctype html>\n<pre>")
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") && o.config.Dotfiles != dotfilesAllow {
+			continue
+		}
+		if entry.IsDir() {
+			name += "/"
+		}
+		link := url.URL{Path: name}
+		_, _ = fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", link.String(), html.EscapeString(name))
+	}
+	_, _ = fmt.Fprintln(w, "</pre>")
+}
+
+func hasDotSegment(urlPath string) bool {
+	for _, segment := range strings.Split(urlPath, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+func writeStaticError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.NotFound(w, r)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		// Paths escaping the root through symlinks are reported as not found as well
+		http.NotFound(w, r)
+	}
+}
+
+func isCompressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case mediaType == "application/javascript", mediaType == "application/json", mediaType == "application/xml",
+		mediaType == "image/svg+xml", mediaType == "application/wasm":
+		return true
+	default:
+		return false
+	}
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.TrimSpace(name) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+type noRangesResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w *noRangesResponseWriter) WriteHeader(status int) {
+	w.Header().Del("Accept-Ranges")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *noRangesResponseWriter) Write(b []byte) (int, error) {
+	w.Header().Del("Accept-Ranges")
+	return w.ResponseWriter.Write(b)
+}
+
+// gzipResponseWriter compresses the body written by the handler. The gzip stream is only started when the handler
+// writes a body, so that bodyless responses like 304 stay empty.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gw *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	w.Header().Del("Content-Length")
+	w.Header().Del("Accept-Ranges")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if w.gw == nil {
+		w.Header().Del("Content-Length")
+		w.Header().Del("Accept-Ranges")
+		w.gw = gzip.NewWriter(w.ResponseWriter)
+	}
+	return w.gw.Write(b)
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gw != nil {
+		_ = w.gw.Close()
+	}
+}
+
+// roundTripHandler runs handler as if it was the origin, streaming its response back without buffering it.
+func roundTripHandler(handler http.Handler, req *http.Request) *http.Response {
+	body, bodyWriter := io.Pipe()
+	w := &pipeResponseWriter{
+		header:    http.Header{},
+		body:      bodyWriter,
+		responseC: make(chan *http.Response, 1),
+		req:       req,
+		bodyR:     body,
+	}
+	go func() {
+		defer func() {
+			w.WriteHeader(http.StatusOK)
+			_ = bodyWriter.Close()
+		}()
+		handler.ServeHTTP(w, req)
+	}()
+	return <-w.responseC
+}
+
+// pipeResponseWriter turns the output of an http.Handler into an http.Response.
+type pipeResponseWriter struct {
+	header      http.Header
+	body        *io.PipeWriter
+	bodyR       *io.PipeReader
+	responseC   chan *http.Response
+	req         *http.Request
+	wroteHeader bool
+}
+
+func (w *pipeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pipeResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	header := w.header.Clone()
+	contentLength := int64(-1)
+	if cl, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil {
+		contentLength = cl
+	}
+	w.responseC <- &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          w.bodyR,
+		ContentLength: contentLength,
+		Request:       w.req,
+	}
+}
+
+func (w *pipeResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.req.Method == http.MethodHead {
+		return len(b), nil
+	}
+	return w.body.Write(b)
+}
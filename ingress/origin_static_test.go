@@ -0,0 +1,99 @@
+package ingress
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestStaticService(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>home</h1>"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte(strings.Repeat("0123456789", 100)), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("SECRET=1"), 0o600))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "docs"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docs", "a.txt"), []byte("a"), 0o600))
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "passwd"), []byte("root"), 0o600))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "passwd"), filepath.Join(dir, "escape")))
+
+	newService := func(cfg config.StaticConfig) *staticService {
+		log := zerolog.Nop()
+		shutdownC := make(chan struct{})
+		t.Cleanup(func() { close(shutdownC) })
+		service, ok := parseStaticService("static://" + dir)
+		require.True(t, ok)
+		require.NoError(t, service.start(&log, shutdownC, OriginRequestConfig{Static: cfg}))
+		return service
+	}
+	get := func(service *staticService, path string, headers map[string]string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, "http://static.example.com"+path, nil)
+		require.NoError(t, err)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := service.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	service := newService(config.StaticConfig{})
+	resp, body := get(service, "/", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "<h1>home</h1>", body)
+
+	resp, body = get(service, "/notes.txt", map[string]string{"Range": "bytes=0-3"})
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "0123", body)
+
+	resp, _ = get(service, "/docs", nil)
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, "/docs/", resp.Header.Get("Location"))
+
+	// Without listing, directories without index are not found
+	resp, _ = get(service, "/docs/", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = get(service, "/.env", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = get(service, "/escape", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	service = newService(config.StaticConfig{
+		DirectoryListing: true,
+		DisableRanges:    true,
+		Compression:      true,
+		Dotfiles:         dotfilesDeny,
+	})
+	resp, body = get(service, "/docs/", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `<a href="a.txt">a.txt</a>`)
+
+	resp, body = get(service, "/notes.txt", map[string]string{"Range": "bytes=0-3"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, body, 1000)
+	assert.Empty(t, resp.Header.Get("Accept-Ranges"))
+
+	resp, body = get(service, "/notes.txt", map[string]string{"Accept-Encoding": "gzip, br"})
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	gr, err := gzip.NewReader(strings.NewReader(body))
+	require.NoError(t, err)
+	decompressed, err := io.ReadAll(gr)
+	require.NoError(t, err)
+	assert.Len(t, decompressed, 1000)
+
+	resp, _ = get(service, "/.env", nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
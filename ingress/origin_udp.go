@@ -0,0 +1,120 @@
+package ingress
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+// ErrUDPSessionLimit is returned when a udp service already serves its maximum number of sessions.
+var ErrUDPSessionLimit = errors.New("udp service reached its maximum number of sessions")
+
+// udpService exposes a UDP origin through the datagram sessions sent to its address. Unlike the other services, it
+// isn't matched by hostname and path: sessions to address are dialed to the origin instead of address itself.
+type udpService struct {
+	url         *url.URL
+	address     netip.AddrPort
+	idleTimeout time.Duration
+	maxSessions uint64
+	sessions    atomic.Int64
+	dialer      net.Dialer
+}
+
+func isUDPService(url *url.URL) bool {
+	return url.Scheme == "udp"
+}
+
+func newUDPService(u *url.URL, cfg config.UDPConfig) (*udpService, error) {
+	if u.Port() == "" {
+		return nil, fmt.Errorf("%s is missing a port", u)
+	}
+	address := cfg.Address
+	if address == "" {
+		address = u.Host
+	}
+	addr, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s needs udp.address to be set to the IP address and port sessions are sent to", u)
+	}
+	if cfg.IdleTimeout.Duration < 0 {
+		return nil, errors.New("udp.idleTimeout can't be negative")
+	}
+	return &udpService{
+		url:     u,
+		address: netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port()),
+	}, nil
+}
+
+func (o *udpService) String() string {
+	return o.url.String()
+}
+
+func (o *udpService) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.String())
+}
+
+func (o *udpService) start(_ *zerolog.Logger, _ <-chan struct{}, cfg OriginRequestConfig) error {
+	o.idleTimeout = cfg.UDP.IdleTimeout.Duration
+	o.maxSessions = cfg.UDP.MaxSessions
+	o.dialer.Timeout = cfg.ConnectTimeout.Duration
+	return nil
+}
+
+// DialUDP dials the origin of the service, resolving its hostname for every session.
+func (o *udpService) DialUDP(_ netip.AddrPort) (net.Conn, error) {
+	sessions := o.sessions.Add(1)
+	if o.maxSessions > 0 && uint64(sessions) > o.maxSessions { // nolint: gosec
+		o.sessions.Add(-1)
+		return nil, errors.Wrapf(ErrUDPSessionLimit, "unable to dial %s", o.url)
+	}
+	conn, err := o.dialer.Dial("udp", o.url.Host)
+	if err != nil {
+		o.sessions.Add(-1)
+		return nil, fmt.Errorf("unable to dial udp to origin %s: %w", o.url.Host, err)
+	}
+	return &udpServiceConn{Conn: conn, release: func() { o.sessions.Add(-1) }}, nil
+}
+
+// udpServiceConn releases its session of the service once closed.
+type udpServiceConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+func (c *udpServiceConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+func (r *Rule) isUDP() bool {
+	_, ok := r.Service.(*udpService)
+	return ok
+}
+
+// UDPOrigins returns the dialers of the udp services, by the address their sessions are sent to.
+func (ing Ingress) UDPOrigins() map[netip.AddrPort]OriginUDPDialer {
+	origins := make(map[netip.AddrPort]OriginUDPDialer)
+	for _, rule := range ing.Rules {
+		if service, ok := rule.Service.(*udpService); ok {
+			origins[service.address] = service
+		}
+	}
+	return origins
+}
+
+// UDPIdleTimeout returns the idle timeout of the sessions to the udp service, or zero to use the one requested
+// by the edge.
+func (o *udpService) UDPIdleTimeout(_ netip.AddrPort) time.Duration {
+	return o.idleTimeout
+}
@@ -0,0 +1,143 @@
+package ingress
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUDPIngress(t *testing.T) {
+	ing, err := ParseIngress(MustReadIngress(`
+ingress:
+  - service: udp://127.0.0.1:5353
+    originRequest:
+      udp:
+        idleTimeout: 30s
+        maxSessions: 10
+  - service: udp://dns.internal:53
+    originRequest:
+      udp:
+        address: 10.0.0.53:53
+  - service: http_status:404
+`))
+	require.NoError(t, err)
+	log := zerolog.Nop()
+	require.NoError(t, ing.StartOrigins(&log, nil))
+
+	origins := ing.UDPOrigins()
+	require.Len(t, origins, 2)
+	local, ok := origins[netip.MustParseAddrPort("127.0.0.1:5353")].(*udpService)
+	require.True(t, ok)
+	assert.Equal(t, 30*time.Second, local.UDPIdleTimeout(local.address))
+	assert.Equal(t, uint64(10), local.maxSessions)
+	assert.Contains(t, origins, netip.MustParseAddrPort("10.0.0.53:53"))
+
+	// UDP rules never match HTTP requests
+	_, ruleNum := ing.FindMatchingRule("127.0.0.1", "/")
+	assert.Equal(t, 2, ruleNum)
+}
+
+func TestParseUDPIngressErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+	}{
+		{
+			name: "missing port",
+			cfg: `
+ingress:
+  - service: udp://127.0.0.1
+  - service: http_status:404
+`,
+		},
+		{
+			name: "hostname without address",
+			cfg: `
+ingress:
+  - service: udp://dns.internal:53
+  - service: http_status:404
+`,
+		},
+		{
+			name: "duplicate address",
+			cfg: `
+ingress:
+  - service: udp://127.0.0.1:53
+  - service: udp://dns.internal:53
+    originRequest:
+      udp:
+        address: 127.0.0.1:53
+  - service: http_status:404
+`,
+		},
+		{
+			name: "hostname",
+			cfg: `
+ingress:
+  - hostname: dns.example.com
+    service: udp://127.0.0.1:53
+  - service: http_status:404
+`,
+		},
+		{
+			name: "catch-all",
+			cfg: `
+ingress:
+  - service: udp://127.0.0.1:53
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseIngress(MustReadIngress(test.cfg))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestUDPServiceMaxSessions(t *testing.T) {
+	origin, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer origin.Close()
+
+	ing, err := ParseIngress(MustReadIngress(`
+ingress:
+  - service: udp://` + origin.LocalAddr().String() + `
+    originRequest:
+      udp:
+        maxSessions: 1
+  - service: http_status:404
+`))
+	require.NoError(t, err)
+	log := zerolog.Nop()
+	require.NoError(t, ing.StartOrigins(&log, nil))
+
+	dialer := NewOriginDialer(OriginConfig{DefaultDialer: NewDialer(WarpRoutingConfig{})}, &log)
+	dialer.UpdateIngressUDPServices(ing.UDPOrigins())
+	dest := netip.MustParseAddrPort(origin.LocalAddr().String())
+
+	conn, err := dialer.DialUDP(dest)
+	require.NoError(t, err)
+	_, err = dialer.DialUDP(dest)
+	require.ErrorIs(t, err, ErrUDPSessionLimit)
+
+	// Closing a session twice releases its slot once
+	require.NoError(t, conn.Close())
+	_ = conn.Close()
+	conn, err = dialer.DialUDP(dest)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = dialer.DialUDP(dest)
+	require.ErrorIs(t, err, ErrUDPSessionLimit)
+
+	// Other destinations go through the default dialer
+	assert.Equal(t, time.Duration(0), dialer.UDPIdleTimeout(netip.MustParseAddrPort("127.0.0.1:1")))
+	other, err := dialer.DialUDP(netip.MustParseAddrPort("127.0.0.1:1"))
+	require.NoError(t, err)
+	_ = other.Close()
+}
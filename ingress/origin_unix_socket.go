@@ -0,0 +1,60 @@
+package ingress
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	unixSocketPrefix    = "unix:"
+	unixSocketTLSPrefix = "unix+tls:"
+)
+
+// parseUnixSocketService parses the unix:/path, unix:///path and unix+tls: forms of a unix socket service.
+func parseUnixSocketService(service string) (*unixSocketPath, bool) {
+	if path, ok := trimServicePrefix(service, unixSocketPrefix); ok {
+		return &unixSocketPath{path: path, scheme: "http"}, true
+	}
+	if path, ok := trimServicePrefix(service, unixSocketTLSPrefix); ok {
+		return &unixSocketPath{path: path, scheme: "https"}, true
+	}
+	return nil, false
+}
+
+// trimServicePrefix returns the local path of services written as prefix/path or, in URL form, prefix///path.
+func trimServicePrefix(service, prefix string) (string, bool) {
+	if !strings.HasPrefix(service, prefix) {
+		return "", false
+	}
+	path := strings.TrimPrefix(service, prefix)
+	if strings.HasPrefix(path, "///") {
+		path = strings.TrimPrefix(path, "//")
+	}
+	return path, true
+}
+
+// isAbstractUnixSocket reports whether path names a Linux abstract socket, which has no file to validate.
+func isAbstractUnixSocket(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
+// validateUnixSocket checks that the socket at path exists and can be connected to. The error wraps fs.ErrNotExist
+// when there's no socket yet, e.g. because the origin hasn't started. Socket-activated origins need no handoff from
+// cloudflared: systemd creates and listens on their socket, and starts the origin on the first connection.
+func validateUnixSocket(path string) error {
+	if isAbstractUnixSocket(path) {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("unix socket %s does not exist: %w", path, err)
+		}
+		return fmt.Errorf("unable to check unix socket %s: %w", path, err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s is not a unix socket", path)
+	}
+	return checkUnixSocketPermission(path)
+}
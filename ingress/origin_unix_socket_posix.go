@@ -0,0 +1,17 @@
+//go:build !windows
+
+package ingress
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Connecting to a unix socket requires write permission on the socket file.
+func checkUnixSocketPermission(path string) error {
+	if err := unix.Access(path, unix.W_OK); err != nil {
+		return fmt.Errorf("no permission to connect to unix socket %s: %w", path, err)
+	}
+	return nil
+}
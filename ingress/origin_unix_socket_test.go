@@ -0,0 +1,54 @@
+package ingress
+
+import (
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUnixSocketService(t *testing.T) {
+	tests := []struct {
+		service string
+		path    string
+		scheme  string
+	}{
+		{service: "unix:/run/app.sock", path: "/run/app.sock", scheme: "http"},
+		{service: "unix:///run/app.sock", path: "/run/app.sock", scheme: "http"},
+		{service: "unix+tls:///run/app.sock", path: "/run/app.sock", scheme: "https"},
+		{service: "unix:@app", path: "@app", scheme: "http"},
+	}
+	for _, test := range tests {
+		s, ok := parseUnixSocketService(test.service)
+		require.True(t, ok, test.service)
+		require.Equal(t, test.path, s.path, test.service)
+		require.Equal(t, test.scheme, s.scheme, test.service)
+	}
+	_, ok := parseUnixSocketService("http://localhost")
+	require.False(t, ok)
+}
+
+func TestUnixSocketStartValidation(t *testing.T) {
+	log := zerolog.Nop()
+	dir := t.TempDir()
+
+	socketPath := filepath.Join(dir, "origin.sock")
+	l, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	defer l.Close()
+	require.NoError(t, (&unixSocketPath{path: socketPath, scheme: "http"}).start(&log, nil, OriginRequestConfig{}))
+
+	// The origin may create its socket later
+	missing := &unixSocketPath{path: filepath.Join(dir, "missing.sock"), scheme: "http"}
+	require.NoError(t, missing.start(&log, nil, OriginRequestConfig{}))
+	require.ErrorIs(t, validateUnixSocket(missing.path), fs.ErrNotExist)
+
+	regularFile := filepath.Join(dir, "regular")
+	require.NoError(t, os.WriteFile(regularFile, nil, 0o600))
+	notSocket := &unixSocketPath{path: regularFile, scheme: "http"}
+	require.ErrorContains(t, notSocket.start(&log, nil, OriginRequestConfig{}), "is not a unix socket")
+}
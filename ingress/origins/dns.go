@@ -3,6 +3,7 @@ package origins
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"math/big"
 	"net"
 	"net/netip"
@@ -32,6 +33,11 @@ const (
 	// shuffle the resolver if multiple are configured.
 	refreshFreq    = 5 * time.Minute
 	refreshTimeout = 5 * time.Second
+
+	// defaultUpstreamTimeout is how long a query waits for the answer of a resolver before being sent to another one.
+	defaultUpstreamTimeout = 2 * time.Second
+	// unhealthyResolverPeriod is how long a resolver that failed isn't picked while others are available.
+	unhealthyResolverPeriod = 30 * time.Second
 )
 
 var (
@@ -39,28 +45,52 @@ var (
 	VirtualDNSServiceAddr = netip.AddrPortFrom(netip.MustParseAddr("2606:4700:0cf1:2000:0000:0000:0000:0001"), 53)
 
 	defaultResolverAddr = netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), defaultResolverPort)
+
+	errNoDNSResolver = errors.New("no DNS resolver left to send the query to")
 )
 
 type netDial func(network string, address string) (net.Conn, error)
 
+// DNSResolverConfig configures how the DNS resolver service proxies the queries sent over UDP.
+type DNSResolverConfig struct {
+	// UpstreamTimeout is how long a query waits for the answer of a resolver before being sent to another resolver.
+	// Defaults to 2s.
+	UpstreamTimeout time.Duration
+	// CacheSize is the maximum number of answers cached. 0 disables the cache.
+	CacheSize int
+	// CacheMinTTL and CacheMaxTTL clamp the TTL of the cached answers. A zero CacheMaxTTL keeps the TTL of the
+	// answers.
+	CacheMinTTL time.Duration
+	CacheMaxTTL time.Duration
+	// DNS64 synthesizes AAAA records for the names that only have A records, see RFC 6147.
+	DNS64 *ingress.NAT64
+}
+
 // DNSResolverService will make DNS requests to the local DNS resolver via the Dial method.
 type DNSResolverService struct {
 	addresses  []netip.AddrPort
+	unhealthy  map[netip.AddrPort]time.Time
 	addressesM sync.RWMutex
 	static     bool
 	dialer     ingress.OriginDialer
 	resolver   peekResolver
 	logger     *zerolog.Logger
 	metrics    Metrics
+
+	upstreamTimeout time.Duration
+	cache           *dnsCache
+	dns64           *ingress.NAT64
 }
 
 func NewDNSResolverService(dialer ingress.OriginDialer, logger *zerolog.Logger, metrics Metrics) *DNSResolverService {
 	return &DNSResolverService{
-		addresses: []netip.AddrPort{defaultResolverAddr},
-		dialer:    dialer,
-		resolver:  &resolver{dialFunc: net.Dial},
-		logger:    logger,
-		metrics:   metrics,
+		addresses:       []netip.AddrPort{defaultResolverAddr},
+		unhealthy:       make(map[netip.AddrPort]time.Time),
+		dialer:          dialer,
+		resolver:        &resolver{dialFunc: net.Dial},
+		logger:          logger,
+		metrics:         metrics,
+		upstreamTimeout: defaultUpstreamTimeout,
 	}
 }
 
@@ -71,18 +101,63 @@ func NewStaticDNSResolverService(resolverAddrs []netip.AddrPort, dialer ingress.
 	return s
 }
 
+// Configure sets up the cache of the answers and the failover between resolvers. It must be called before the
+// service handles traffic.
+func (s *DNSResolverService) Configure(cfg DNSResolverConfig) {
+	if cfg.UpstreamTimeout > 0 {
+		s.upstreamTimeout = cfg.UpstreamTimeout
+	}
+	s.cache = newDNSCache(cfg.CacheSize, cfg.CacheMinTTL, cfg.CacheMaxTTL)
+	s.dns64 = cfg.DNS64
+}
+
 func (s *DNSResolverService) DialTCP(ctx context.Context, _ netip.AddrPort) (net.Conn, error) {
 	s.metrics.IncrementDNSTCPRequests()
-	dest := s.getAddress()
 	// The dialer ignores the provided address because the request will instead go to the local DNS resolver.
-	return s.dialer.DialTCP(ctx, dest)
+	conn, _, err := s.dialResolver(nil, func(dest netip.AddrPort) (net.Conn, error) {
+		return s.dialer.DialTCP(ctx, dest)
+	})
+	return conn, err
 }
 
 func (s *DNSResolverService) DialUDP(_ netip.AddrPort) (net.Conn, error) {
 	s.metrics.IncrementDNSUDPRequests()
-	dest := s.getAddress()
 	// The dialer ignores the provided address because the request will instead go to the local DNS resolver.
-	return s.dialer.DialUDP(dest)
+	if !s.proxiesQueries() {
+		conn, _, err := s.dialResolver(nil, s.dialer.DialUDP)
+		return conn, err
+	}
+	return newDNSUDPConn(s)
+}
+
+// proxiesQueries returns whether the queries sent over UDP are parsed, to answer them from the cache, synthesize
+// AAAA records or send them again to another resolver when one doesn't answer.
+func (s *DNSResolverService) proxiesQueries() bool {
+	s.addressesM.RLock()
+	defer s.addressesM.RUnlock()
+	return s.cache != nil || s.dns64 != nil || len(s.addresses) > 1
+}
+
+// dialResolver dials a resolver that isn't in tried, preferring the healthy ones. A resolver that can't be dialed is
+// marked unhealthy and the next one is dialed.
+func (s *DNSResolverService) dialResolver(tried []netip.AddrPort, dial func(netip.AddrPort) (net.Conn, error)) (net.Conn, netip.AddrPort, error) {
+	var lastErr error
+	for {
+		dest, ok := s.pickAddress(tried)
+		if !ok {
+			if lastErr == nil {
+				lastErr = errNoDNSResolver
+			}
+			return nil, netip.AddrPort{}, lastErr
+		}
+		conn, err := dial(dest)
+		if err == nil {
+			return conn, dest, nil
+		}
+		s.markUnhealthy(dest, err)
+		tried = append(tried, dest)
+		lastErr = err
+	}
 }
 
 // StartRefreshLoop is a routine that is expected to run in the background to update the DNS local resolver if
@@ -140,14 +215,44 @@ func (s *DNSResolverService) update(ctx context.Context) error {
 // returns the address from the peekResolver or from the static addresses if provided.
 // If multiple addresses are provided in the static addresses pick one randomly.
 func (s *DNSResolverService) getAddress() netip.AddrPort {
+	addr, _ := s.pickAddress(nil)
+	return addr
+}
+
+// pickAddress picks one of the addresses that aren't excluded randomly, among the healthy ones if any. It returns
+// false once all the addresses are excluded.
+func (s *DNSResolverService) pickAddress(exclude []netip.AddrPort) (netip.AddrPort, bool) {
 	s.addressesM.RLock()
 	defer s.addressesM.RUnlock()
-	l := len(s.addresses)
-	if l <= 0 {
-		return defaultResolverAddr
+	if len(s.addresses) == 0 {
+		return defaultResolverAddr, !slices.Contains(exclude, defaultResolverAddr)
+	}
+	now := time.Now()
+	var candidates, healthy []netip.AddrPort
+	for _, addr := range s.addresses {
+		if slices.Contains(exclude, addr) {
+			continue
+		}
+		candidates = append(candidates, addr)
+		if now.After(s.unhealthy[addr]) {
+			healthy = append(healthy, addr)
+		}
 	}
+	switch {
+	case len(healthy) > 0:
+		return randomAddress(healthy), true
+	case len(candidates) > 0:
+		// When all the resolvers failed recently, keep trying them rather than failing the request
+		return randomAddress(candidates), true
+	default:
+		return netip.AddrPort{}, false
+	}
+}
+
+func randomAddress(addresses []netip.AddrPort) netip.AddrPort {
+	l := len(addresses)
 	if l == 1 {
-		return s.addresses[0]
+		return addresses[0]
 	}
 	// Only initialize the random selection if there is more than one element in the list.
 	var i int64 = 0
@@ -157,7 +262,30 @@ func (s *DNSResolverService) getAddress() netip.AddrPort {
 	if err == nil {
 		i = r.Int64()
 	}
-	return s.addresses[i]
+	return addresses[i]
+}
+
+// markUnhealthy avoids picking a resolver for a while, unless all the others failed as well.
+func (s *DNSResolverService) markUnhealthy(addr netip.AddrPort, err error) {
+	s.addressesM.Lock()
+	defer s.addressesM.Unlock()
+	if time.Now().After(s.unhealthy[addr]) {
+		s.logger.Warn().Err(err).Msgf("DNS resolver %s failed, sending queries to other resolvers for %s", addr, unhealthyResolverPeriod)
+	}
+	s.unhealthy[addr] = time.Now().Add(unhealthyResolverPeriod)
+}
+
+// markHealthy makes a resolver that answered a query available again.
+func (s *DNSResolverService) markHealthy(addr netip.AddrPort) {
+	s.addressesM.RLock()
+	_, unhealthy := s.unhealthy[addr]
+	s.addressesM.RUnlock()
+	if !unhealthy {
+		return
+	}
+	s.addressesM.Lock()
+	defer s.addressesM.Unlock()
+	delete(s.unhealthy, addr)
 }
 
 // lock and update the address used for the local DNS resolver
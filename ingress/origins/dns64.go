@@ -0,0 +1,66 @@
+package origins
+
+import (
+	"net/netip"
+
+	"github.com/miekg/dns"
+
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+// needsDNS64 returns whether AAAA records must be synthesized for the answer of a query: successful answers to AAAA
+// queries that don't have any AAAA record.
+func needsDNS64(query, answer *dns.Msg) bool {
+	if len(query.Question) != 1 || answer.Rcode != dns.RcodeSuccess {
+		return false
+	}
+	if question := query.Question[0]; question.Qtype != dns.TypeAAAA || question.Qclass != dns.ClassINET {
+		return false
+	}
+	for _, rr := range answer.Answer {
+		if rr.Header().Rrtype == dns.TypeAAAA {
+			return false
+		}
+	}
+	return true
+}
+
+// synthesizeDNS64 returns the answer of an AAAA query with the A records of aAnswer embedded into the NAT64 prefix, or
+// aaaaAnswer if the name doesn't have A records either.
+func synthesizeDNS64(nat64 *ingress.NAT64, aaaaAnswer, aAnswer *dns.Msg) *dns.Msg {
+	if aAnswer.Rcode != dns.RcodeSuccess {
+		return aaaaAnswer
+	}
+	var records []dns.RR
+	synthesized := false
+	for _, rr := range aAnswer.Answer {
+		switch record := rr.(type) {
+		case *dns.A:
+			ipv4, ok := netip.AddrFromSlice(record.A.To4())
+			if !ok {
+				continue
+			}
+			records = append(records, &dns.AAAA{
+				Hdr: dns.RR_Header{
+					Name:   record.Hdr.Name,
+					Rrtype: dns.TypeAAAA,
+					Class:  dns.ClassINET,
+					Ttl:    record.Hdr.Ttl,
+				},
+				AAAA: nat64.Synthesize(ipv4).AsSlice(),
+			})
+			synthesized = true
+		case *dns.CNAME:
+			records = append(records, record)
+		}
+	}
+	if !synthesized {
+		return aaaaAnswer
+	}
+	answer := aaaaAnswer.Copy()
+	answer.Answer = records
+	answer.Ns = nil
+	// Synthesized records can't be validated
+	answer.AuthenticatedData = false
+	return answer
+}
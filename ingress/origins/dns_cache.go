@@ -0,0 +1,169 @@
+package origins
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type dnsCacheKey struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+	dnssec bool
+}
+
+type dnsCacheEntry struct {
+	key     dnsCacheKey
+	answer  *dns.Msg
+	stored  time.Time
+	expires time.Time
+}
+
+// dnsCache is an LRU cache of the answers of the resolvers. The TTL of the cached records is clamped between minTTL
+// and maxTTL, and decremented by the time spent in the cache when they are served.
+type dnsCache struct {
+	lock    sync.Mutex
+	size    int
+	minTTL  uint32
+	maxTTL  uint32
+	entries map[dnsCacheKey]*list.Element
+	lru     *list.List
+}
+
+// newDNSCache returns a cache of up to size answers, or nil if size is 0.
+func newDNSCache(size int, minTTL, maxTTL time.Duration) *dnsCache {
+	if size <= 0 {
+		return nil
+	}
+	return &dnsCache{
+		size:    size,
+		minTTL:  uint32(minTTL.Seconds()),
+		maxTTL:  uint32(maxTTL.Seconds()),
+		entries: make(map[dnsCacheKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// cacheKey returns the key of the answer of a query. Only the queries of a single question are cached.
+func cacheKey(query *dns.Msg) (dnsCacheKey, bool) {
+	if len(query.Question) != 1 {
+		return dnsCacheKey{}, false
+	}
+	question := query.Question[0]
+	dnssec := false
+	if opt := query.IsEdns0(); opt != nil {
+		dnssec = opt.Do()
+	}
+	return dnsCacheKey{
+		name:   strings.ToLower(question.Name),
+		qtype:  question.Qtype,
+		qclass: question.Qclass,
+		dnssec: dnssec,
+	}, true
+}
+
+// get returns the cached answer of the query, with the ID and question of the query.
+func (c *dnsCache) get(query *dns.Msg, now time.Time) *dns.Msg {
+	if c == nil {
+		return nil
+	}
+	key, ok := cacheKey(query)
+	if !ok {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*dnsCacheEntry)
+	if !now.Before(entry.expires) {
+		c.remove(element)
+		return nil
+	}
+	c.lru.MoveToFront(element)
+
+	answer := entry.answer.Copy()
+	answer.Id = query.Id
+	answer.Question = query.Question
+	elapsed := uint32(now.Sub(entry.stored).Seconds())
+	forEachRecord(answer, func(rr dns.RR) {
+		header := rr.Header()
+		header.Ttl -= min(header.Ttl, elapsed)
+	})
+	return answer
+}
+
+// store caches the answer of the query. Only successful and NXDOMAIN answers are cached, for the smallest TTL of
+// their records. Negative answers are cached for the TTL of their SOA record.
+func (c *dnsCache) store(query, answer *dns.Msg, now time.Time) {
+	if c == nil || answer.Truncated || (answer.Rcode != dns.RcodeSuccess && answer.Rcode != dns.RcodeNameError) {
+		return
+	}
+	key, ok := cacheKey(query)
+	if !ok {
+		return
+	}
+	answer = answer.Copy()
+	ttl, hasRecords := uint32(0), false
+	forEachRecord(answer, func(rr dns.RR) {
+		header := rr.Header()
+		header.Ttl = c.clampTTL(header.Ttl)
+		if !hasRecords || header.Ttl < ttl {
+			ttl = header.Ttl
+		}
+		hasRecords = true
+	})
+	if !hasRecords {
+		// Answers without records can't be cached for longer than the minimum TTL
+		ttl = c.minTTL
+	}
+	if ttl == 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.entries[key] = c.lru.PushFront(&dnsCacheEntry{
+		key:     key,
+		answer:  answer,
+		stored:  now,
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	})
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *dnsCache) clampTTL(ttl uint32) uint32 {
+	ttl = max(ttl, c.minTTL)
+	if c.maxTTL > 0 {
+		ttl = min(ttl, c.maxTTL)
+	}
+	return ttl
+}
+
+func (c *dnsCache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*dnsCacheEntry).key)
+}
+
+// forEachRecord calls fn for the records of all the sections of msg, except the OPT pseudo-record whose TTL field
+// holds flags.
+func forEachRecord(msg *dns.Msg, fn func(dns.RR)) {
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				fn(rr)
+			}
+		}
+	}
+}
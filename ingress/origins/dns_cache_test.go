@@ -0,0 +1,73 @@
+package origins
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testDNSAnswer(query *dns.Msg, ttl uint32) *dns.Msg {
+	answer := new(dns.Msg)
+	answer.SetReply(query)
+	answer.Answer = append(answer.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+		A:   net.IPv4(10, 0, 0, 1),
+	})
+	return answer
+}
+
+func TestDNSCache(t *testing.T) {
+	assert.Nil(t, newDNSCache(0, 0, 0))
+
+	cache := newDNSCache(2, 0, 0)
+	now := time.Now()
+	query := new(dns.Msg).SetQuestion("internal.example.com.", dns.TypeA)
+	cache.store(query, testDNSAnswer(query, 60), now)
+
+	// Names are matched case-insensitively, and answers get the ID and question of the query
+	other := new(dns.Msg).SetQuestion("Internal.Example.com.", dns.TypeA)
+	answer := cache.get(other, now.Add(10*time.Second))
+	require.NotNil(t, answer)
+	assert.Equal(t, other.Id, answer.Id)
+	assert.Equal(t, other.Question, answer.Question)
+	assert.Equal(t, uint32(50), answer.Answer[0].Header().Ttl)
+
+	assert.Nil(t, cache.get(new(dns.Msg).SetQuestion("internal.example.com.", dns.TypeAAAA), now))
+	assert.Nil(t, cache.get(query, now.Add(time.Minute)))
+
+	// Failed answers aren't cached
+	failed := testDNSAnswer(query, 60)
+	failed.Rcode = dns.RcodeServerFailure
+	cache.store(query, failed, now)
+	assert.Nil(t, cache.get(query, now))
+
+	// The least recently used answers are evicted
+	for _, name := range []string{"a.example.com.", "b.example.com.", "c.example.com."} {
+		q := new(dns.Msg).SetQuestion(name, dns.TypeA)
+		cache.store(q, testDNSAnswer(q, 60), now)
+	}
+	assert.Nil(t, cache.get(new(dns.Msg).SetQuestion("a.example.com.", dns.TypeA), now))
+	assert.NotNil(t, cache.get(new(dns.Msg).SetQuestion("c.example.com.", dns.TypeA), now))
+}
+
+func TestDNSCacheClampsTTL(t *testing.T) {
+	cache := newDNSCache(10, 30*time.Second, 5*time.Minute)
+	now := time.Now()
+
+	short := new(dns.Msg).SetQuestion("short.example.com.", dns.TypeA)
+	cache.store(short, testDNSAnswer(short, 1), now)
+	answer := cache.get(short, now.Add(10*time.Second))
+	require.NotNil(t, answer)
+	assert.Equal(t, uint32(20), answer.Answer[0].Header().Ttl)
+
+	long := new(dns.Msg).SetQuestion("long.example.com.", dns.TypeA)
+	cache.store(long, testDNSAnswer(long, 86400), now)
+	answer = cache.get(long, now)
+	require.NotNil(t, answer)
+	assert.Equal(t, uint32(300), answer.Answer[0].Header().Ttl)
+	assert.Nil(t, cache.get(long, now.Add(5*time.Minute)))
+}
@@ -0,0 +1,308 @@
+package origins
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// Answers of the resolvers are read into buffers of the maximum size of a DNS message over UDP.
+	maxDNSMessageSize = dns.MaxMsgSize
+	// Answers are queued until the session reads them.
+	dnsAnswersQueueSize = 16
+)
+
+var (
+	errDNSResolverTimeout = errors.New("DNS resolver didn't answer in time")
+	errDNSDeadline        = errors.New("deadlines are not supported by the connections of the DNS resolver service")
+)
+
+// pendingDNSQuery is a query sent to a resolver that didn't answer yet.
+type pendingDNSQuery struct {
+	raw   []byte
+	query *dns.Msg
+	timer *time.Timer
+	// dns64Query and dns64Answer are the AAAA query and its answer without AAAA records that this A query
+	// synthesizes AAAA records for.
+	dns64Query  *dns.Msg
+	dns64Answer *dns.Msg
+}
+
+// dnsUDPConn proxies the DNS queries of a UDP session to the resolvers. Queries are answered from the cache when
+// possible, and sent again to another resolver when one doesn't answer in time. With DNS64, AAAA records are
+// synthesized for the names that only have A records.
+type dnsUDPConn struct {
+	service   *DNSResolverService
+	answers   chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	lock         sync.Mutex
+	resolver     net.Conn
+	resolverAddr netip.AddrPort
+	tried        []netip.AddrPort
+	pending      map[uint16]*pendingDNSQuery
+}
+
+func newDNSUDPConn(service *DNSResolverService) (*dnsUDPConn, error) {
+	resolver, resolverAddr, err := service.dialResolver(nil, service.dialer.DialUDP)
+	if err != nil {
+		return nil, err
+	}
+	c := &dnsUDPConn{
+		service:      service,
+		answers:      make(chan []byte, dnsAnswersQueueSize),
+		closed:       make(chan struct{}),
+		resolver:     resolver,
+		resolverAddr: resolverAddr,
+		tried:        []netip.AddrPort{resolverAddr},
+		pending:      make(map[uint16]*pendingDNSQuery),
+	}
+	go c.readAnswers(resolver, resolverAddr)
+	return c, nil
+}
+
+// Write sends a query to the resolver, unless its answer is cached. Queries that can't be parsed are sent as is.
+func (c *dnsUDPConn) Write(b []byte) (int, error) {
+	query := new(dns.Msg)
+	if err := query.Unpack(b); err != nil {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return c.resolver.Write(b)
+	}
+	qtype := queryType(query)
+	c.service.metrics.IncrementDNSQuery(qtype)
+	if answer := c.service.cache.get(query, time.Now()); answer != nil {
+		raw, err := answer.Pack()
+		if err == nil {
+			c.service.metrics.IncrementDNSCacheHit(qtype)
+			c.queueAnswer(raw)
+			return len(b), nil
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if previous, ok := c.pending[query.Id]; ok {
+		previous.timer.Stop()
+	}
+	pending := &pendingDNSQuery{raw: append([]byte(nil), b...), query: query}
+	c.pending[query.Id] = pending
+	c.sendLocked(pending)
+	return len(b), nil
+}
+
+// sendLocked sends a pending query to the current resolver, and fails over to another resolver if it doesn't answer
+// in time. It must be called with the lock held.
+func (c *dnsUDPConn) sendLocked(pending *pendingDNSQuery) {
+	resolverAddr := c.resolverAddr
+	id := pending.query.Id
+	pending.timer = time.AfterFunc(c.service.upstreamTimeout, func() {
+		c.resolverTimedOut(id, resolverAddr)
+	})
+	// Write errors are handled like timeouts, since the query won't be answered
+	_, _ = c.resolver.Write(pending.raw)
+}
+
+func (c *dnsUDPConn) resolverTimedOut(id uint16, resolverAddr netip.AddrPort) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	select {
+	case <-c.closed:
+		return
+	default:
+	}
+	if _, ok := c.pending[id]; !ok || c.resolverAddr != resolverAddr {
+		// The query was answered, or already sent to another resolver
+		return
+	}
+	if resolverAddr.IsValid() {
+		c.service.markUnhealthy(resolverAddr, errDNSResolverTimeout)
+	}
+	c.failoverLocked()
+}
+
+// failoverLocked sends the pending queries to a resolver that wasn't tried yet. Once all the resolvers were tried,
+// pending queries are dropped and the eyeball retries them. It must be called with the lock held.
+func (c *dnsUDPConn) failoverLocked() {
+	for _, pending := range c.pending {
+		pending.timer.Stop()
+	}
+	_ = c.resolver.Close()
+	resolver, resolverAddr, err := c.service.dialResolver(c.tried, c.service.dialer.DialUDP)
+	if err != nil {
+		clear(c.pending)
+		// Start again from all the resolvers for the next queries
+		c.tried = nil
+		resolver, resolverAddr, err = c.service.dialResolver(nil, c.service.dialer.DialUDP)
+		if err != nil {
+			c.resolver = closedConn{}
+			c.resolverAddr = netip.AddrPort{}
+			return
+		}
+	}
+	c.service.metrics.IncrementDNSResolverFailover()
+	c.resolver = resolver
+	c.resolverAddr = resolverAddr
+	c.tried = append(c.tried, resolverAddr)
+	go c.readAnswers(resolver, resolverAddr)
+	for _, pending := range c.pending {
+		c.sendLocked(pending)
+	}
+}
+
+// readAnswers reads the answers of a resolver until its connection is closed.
+func (c *dnsUDPConn) readAnswers(resolver net.Conn, resolverAddr netip.AddrPort) {
+	buf := make([]byte, maxDNSMessageSize)
+	for {
+		n, err := resolver.Read(buf)
+		if err != nil {
+			return
+		}
+		if raw := c.handleAnswer(append([]byte(nil), buf[:n]...), resolverAddr); raw != nil {
+			c.queueAnswer(raw)
+		}
+	}
+}
+
+// handleAnswer caches the answer of a resolver and returns the answer to send to the eyeball, if any. Answers
+// without AAAA records are held until AAAA records are synthesized from the A records of the name.
+func (c *dnsUDPConn) handleAnswer(raw []byte, resolverAddr netip.AddrPort) []byte {
+	answer := new(dns.Msg)
+	if err := answer.Unpack(raw); err != nil {
+		return raw
+	}
+	c.service.markHealthy(resolverAddr)
+	c.lock.Lock()
+	pending, ok := c.pending[answer.Id]
+	if !ok {
+		c.lock.Unlock()
+		return raw
+	}
+	pending.timer.Stop()
+	delete(c.pending, answer.Id)
+	if pending.dns64Query == nil && c.service.dns64 != nil && needsDNS64(pending.query, answer) {
+		c.sendDNS64QueryLocked(pending.query, answer)
+		c.lock.Unlock()
+		return nil
+	}
+	c.lock.Unlock()
+
+	query := pending.query
+	if pending.dns64Query != nil {
+		query = pending.dns64Query
+		answer = synthesizeDNS64(c.service.dns64, pending.dns64Answer, answer)
+		var err error
+		if raw, err = answer.Pack(); err != nil {
+			return nil
+		}
+	}
+	c.service.cache.store(query, answer, time.Now())
+	return raw
+}
+
+// sendDNS64QueryLocked queries the A records of the name of an AAAA query. It must be called with the lock held.
+func (c *dnsUDPConn) sendDNS64QueryLocked(query, answer *dns.Msg) {
+	aQuery := query.Copy()
+	aQuery.Question[0].Qtype = dns.TypeA
+	for {
+		aQuery.Id = dns.Id()
+		if _, ok := c.pending[aQuery.Id]; !ok {
+			break
+		}
+	}
+	raw, err := aQuery.Pack()
+	if err != nil {
+		// The eyeball gets the answer without AAAA records
+		c.queueAnswerLocked(answer)
+		return
+	}
+	pending := &pendingDNSQuery{raw: raw, query: aQuery, dns64Query: query, dns64Answer: answer}
+	c.pending[aQuery.Id] = pending
+	c.sendLocked(pending)
+}
+
+// queueAnswerLocked queues an answer without blocking, since it's called with the lock held.
+func (c *dnsUDPConn) queueAnswerLocked(answer *dns.Msg) {
+	raw, err := answer.Pack()
+	if err != nil {
+		return
+	}
+	select {
+	case c.answers <- raw:
+	default:
+	}
+}
+
+func (c *dnsUDPConn) queueAnswer(raw []byte) {
+	select {
+	case c.answers <- raw:
+	case <-c.closed:
+	}
+}
+
+// Read returns the next answer, from the cache or a resolver.
+func (c *dnsUDPConn) Read(b []byte) (int, error) {
+	select {
+	case answer := <-c.answers:
+		return copy(b, answer), nil
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *dnsUDPConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		for _, pending := range c.pending {
+			pending.timer.Stop()
+		}
+		err = c.resolver.Close()
+	})
+	return err
+}
+
+func (c *dnsUDPConn) LocalAddr() net.Addr {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.resolver.LocalAddr()
+}
+
+func (c *dnsUDPConn) RemoteAddr() net.Addr {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.resolver.RemoteAddr()
+}
+
+func (c *dnsUDPConn) SetDeadline(time.Time) error      { return errDNSDeadline }
+func (c *dnsUDPConn) SetReadDeadline(time.Time) error  { return errDNSDeadline }
+func (c *dnsUDPConn) SetWriteDeadline(time.Time) error { return errDNSDeadline }
+
+// closedConn replaces the connection to the resolver when none can be dialed.
+type closedConn struct {
+	net.Conn
+}
+
+func (closedConn) Write([]byte) (int, error) { return 0, net.ErrClosed }
+func (closedConn) Close() error              { return nil }
+func (closedConn) LocalAddr() net.Addr       { return nil }
+func (closedConn) RemoteAddr() net.Addr      { return nil }
+
+// queryType returns the type of the question of a query, as reported in metrics.
+func queryType(query *dns.Msg) string {
+	if len(query.Question) == 0 {
+		return "none"
+	}
+	if qtype, ok := dns.TypeToString[query.Question[0].Qtype]; ok {
+		return qtype
+	}
+	return "other"
+}
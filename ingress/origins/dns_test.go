@@ -6,10 +6,16 @@ import (
 	"net"
 	"net/netip"
 	"slices"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/ingress"
 )
 
 func TestDNSResolver_DefaultResolver(t *testing.T) {
@@ -193,3 +199,107 @@ func validateAddrs(t *testing.T, expected []netip.AddrPort, actual []netip.AddrP
 		}
 	}
 }
+
+// serveTestDNS answers the A queries sent to a local UDP resolver, or ignores them when answer is false.
+func serveTestDNS(t *testing.T, answer bool) (netip.AddrPort, *atomic.Int32) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	queries := &atomic.Int32{}
+	go func() {
+		buf := make([]byte, maxDNSMessageSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			queries.Add(1)
+			query := new(dns.Msg)
+			if !answer || query.Unpack(buf[:n]) != nil {
+				continue
+			}
+			raw, _ := testDNSAnswer(query, 60).Pack()
+			_, _ = conn.WriteTo(raw, addr)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort(), queries
+}
+
+func exchangeTestDNS(t *testing.T, conn net.Conn, name string) *dns.Msg {
+	query := new(dns.Msg).SetQuestion(name, dns.TypeA)
+	raw, err := query.Pack()
+	require.NoError(t, err)
+	_, err = conn.Write(raw)
+	require.NoError(t, err)
+	buf := make([]byte, maxDNSMessageSize)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	answer := new(dns.Msg)
+	require.NoError(t, answer.Unpack(buf[:n]))
+	assert.Equal(t, query.Id, answer.Id)
+	return answer
+}
+
+func TestDNSResolver_FailoverAndCache(t *testing.T) {
+	log := zerolog.Nop()
+	silent, silentQueries := serveTestDNS(t, false)
+	healthy, healthyQueries := serveTestDNS(t, true)
+	service := NewStaticDNSResolverService([]netip.AddrPort{silent, healthy}, NewDNSDialer(), &log, &noopMetrics{})
+	service.Configure(DNSResolverConfig{UpstreamTimeout: 100 * time.Millisecond, CacheSize: 10})
+	// Send the first query to the silent resolver
+	service.markUnhealthy(healthy, errors.New("test"))
+
+	for i := 0; i < 2; i++ {
+		conn, err := service.DialUDP(VirtualDNSServiceAddr)
+		require.NoError(t, err)
+		answer := exchangeTestDNS(t, conn, "internal.example.com.")
+		require.Len(t, answer.Answer, 1)
+		require.NoError(t, conn.Close())
+	}
+	// The first query was sent again to the healthy resolver, and the second one was answered from the cache
+	assert.Equal(t, int32(1), silentQueries.Load())
+	assert.Equal(t, int32(1), healthyQueries.Load())
+
+	// The resolver that didn't answer isn't picked anymore, while the one that answered is available again
+	for i := 0; i < 5; i++ {
+		addr, _ := service.pickAddress(nil)
+		assert.Equal(t, healthy, addr)
+	}
+}
+
+func TestDNSResolver_DNS64(t *testing.T) {
+	log := zerolog.Nop()
+	// The resolver only has A records
+	resolver, _ := serveTestDNS(t, true)
+	nat64, err := ingress.NewNAT64("64:ff9b::/96")
+	require.NoError(t, err)
+	service := NewStaticDNSResolverService([]netip.AddrPort{resolver}, NewDNSDialer(), &log, &noopMetrics{})
+	service.Configure(DNSResolverConfig{DNS64: nat64})
+
+	conn, err := service.DialUDP(VirtualDNSServiceAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	query := new(dns.Msg).SetQuestion("internal.example.com.", dns.TypeAAAA)
+	raw, err := query.Pack()
+	require.NoError(t, err)
+	_, err = conn.Write(raw)
+	require.NoError(t, err)
+	buf := make([]byte, maxDNSMessageSize)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	answer := new(dns.Msg)
+	require.NoError(t, answer.Unpack(buf[:n]))
+
+	assert.Equal(t, query.Id, answer.Id)
+	assert.Equal(t, query.Question, answer.Question)
+	require.Len(t, answer.Answer, 1)
+	aaaa, ok := answer.Answer[0].(*dns.AAAA)
+	require.True(t, ok)
+	assert.Equal(t, "64:ff9b::a00:1", aaaa.AAAA.String())
+
+	// A queries are proxied as is
+	answer = exchangeTestDNS(t, conn, "internal.example.com.")
+	require.Len(t, answer.Answer, 1)
+	assert.Equal(t, dns.TypeA, answer.Answer[0].Header().Rrtype)
+}
@@ -12,10 +12,16 @@ const (
 type Metrics interface {
 	IncrementDNSUDPRequests()
 	IncrementDNSTCPRequests()
+	IncrementDNSQuery(qtype string)
+	IncrementDNSCacheHit(qtype string)
+	IncrementDNSResolverFailover()
 }
 
 type metrics struct {
-	dnsResolverRequests *prometheus.CounterVec
+	dnsResolverRequests  *prometheus.CounterVec
+	dnsQueries           *prometheus.CounterVec
+	dnsCacheHits         *prometheus.CounterVec
+	dnsResolverFailovers prometheus.Counter
 }
 
 func (m *metrics) IncrementDNSUDPRequests() {
@@ -26,6 +32,18 @@ func (m *metrics) IncrementDNSTCPRequests() {
 	m.dnsResolverRequests.WithLabelValues("tcp").Inc()
 }
 
+func (m *metrics) IncrementDNSQuery(qtype string) {
+	m.dnsQueries.WithLabelValues(qtype).Inc()
+}
+
+func (m *metrics) IncrementDNSCacheHit(qtype string) {
+	m.dnsCacheHits.WithLabelValues(qtype).Inc()
+}
+
+func (m *metrics) IncrementDNSResolverFailover() {
+	m.dnsResolverFailovers.Inc()
+}
+
 func NewMetrics(registerer prometheus.Registerer) Metrics {
 	m := &metrics{
 		dnsResolverRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
@@ -34,7 +52,25 @@ func NewMetrics(registerer prometheus.Registerer) Metrics {
 			Name:      "dns_requests_total",
 			Help:      "Total count of DNS requests that have been proxied to the virtual DNS resolver origin",
 		}, []string{"protocol"}),
+		dnsQueries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "dns_queries_total",
+			Help:      "Total count of DNS queries sent over UDP to the virtual DNS resolver origin, by query type. Only counted when the cache or several resolvers are configured",
+		}, []string{"qtype"}),
+		dnsCacheHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "dns_cache_hits_total",
+			Help:      "Total count of DNS queries answered from the cache of the virtual DNS resolver origin, by query type",
+		}, []string{"qtype"}),
+		dnsResolverFailovers: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "dns_resolver_failovers_total",
+			Help:      "Total count of DNS queries sent again to another resolver because a resolver didn't answer in time",
+		}),
 	}
-	registerer.MustRegister(m.dnsResolverRequests)
+	registerer.MustRegister(m.dnsResolverRequests, m.dnsQueries, m.dnsCacheHits, m.dnsResolverFailovers)
 	return m
 }
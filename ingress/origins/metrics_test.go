@@ -2,5 +2,8 @@ package origins
 
 type noopMetrics struct{}
 
-func (noopMetrics) IncrementDNSUDPRequests() {}
-func (noopMetrics) IncrementDNSTCPRequests() {}
+func (noopMetrics) IncrementDNSUDPRequests()      {}
+func (noopMetrics) IncrementDNSTCPRequests()      {}
+func (noopMetrics) IncrementDNSQuery(string)      {}
+func (noopMetrics) IncrementDNSCacheHit(string)   {}
+func (noopMetrics) IncrementDNSResolverFailover() {}
@@ -0,0 +1,190 @@
+package ingress
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/netip"
+
+	"github.com/pkg/errors"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+const (
+	ProxyProtocolV1 = "v1"
+	ProxyProtocolV2 = "v2"
+
+	// TLV types defined by the PROXY protocol specification, section 2.2.
+	pp2TypeAuthority = 0x02
+	pp2TypeUniqueID  = 0x05
+
+	pp2CommandLocal = 0x20
+	pp2CommandProxy = 0x21
+	pp2FamilyUnspec = 0x00
+	pp2FamilyTCP4   = 0x11
+	pp2FamilyTCP6   = 0x21
+)
+
+var proxyProtocolV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// StreamClientInfo describes the eyeball of a stream proxied to a tcp origin.
+type StreamClientInfo struct {
+	// Addr is the address of the eyeball. Its port is zero when the edge doesn't report it.
+	Addr netip.AddrPort
+	// Authority is the hostname requested by the eyeball.
+	Authority string
+	// UniqueID identifies the request, usually its Cf-Ray.
+	UniqueID string
+}
+
+type streamClientInfoKey struct{}
+
+// ContextWithStreamClientInfo returns a context carrying the eyeball of the stream, which tcp origins report in
+// PROXY protocol headers.
+func ContextWithStreamClientInfo(ctx context.Context, info StreamClientInfo) context.Context {
+	return context.WithValue(ctx, streamClientInfoKey{}, info)
+}
+
+func streamClientInfoFromContext(ctx context.Context) (StreamClientInfo, bool) {
+	info, ok := ctx.Value(streamClientInfoKey{}).(StreamClientInfo)
+	return info, ok && info.Addr.IsValid()
+}
+
+func validateProxyProtocolConfiguration(cfg OriginRequestConfig) error {
+	pp := cfg.ProxyProtocol
+	switch pp.Version {
+	case "":
+		return nil
+	case ProxyProtocolV1:
+		if pp.Authority || pp.UniqueID || len(pp.TLVs) > 0 {
+			return errors.New("TLVs require PROXY protocol v2")
+		}
+	case ProxyProtocolV2:
+		for _, tlv := range pp.TLVs {
+			if tlv.Type == 0 {
+				return errors.New("TLV type can't be 0")
+			}
+			if len(tlv.Value) > math.MaxUint16 {
+				return fmt.Errorf("value of TLV type %#x is too long", tlv.Type)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid PROXY protocol version %q, expected v1 or v2", pp.Version)
+	}
+	if cfg.ProxyType == socksProxy {
+		return errors.New("PROXY protocol headers can't be sent to socks proxies")
+	}
+	return nil
+}
+
+// writeProxyProtocolHeader writes the PROXY protocol header of a connection to dst. The connection is reported as
+// local when the eyeball isn't known.
+func writeProxyProtocolHeader(ctx context.Context, w io.Writer, cfg config.ProxyProtocolConfig, dst net.Addr) error {
+	info, ok := streamClientInfoFromContext(ctx)
+	var dstAddr netip.AddrPort
+	if tcpAddr, isTCP := dst.(*net.TCPAddr); isTCP {
+		dstAddr = tcpAddr.AddrPort()
+	}
+	var header []byte
+	if cfg.Version == ProxyProtocolV1 {
+		header = proxyProtocolV1Header(info.Addr, dstAddr, ok)
+	} else {
+		var err error
+		if header, err = proxyProtocolV2Header(cfg, info, dstAddr, ok); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(header)
+	return err
+}
+
+// normalizeProxyProtocolAddrs makes both addresses of the same family, since headers carry a single one.
+func normalizeProxyProtocolAddrs(src, dst netip.AddrPort) (netip.AddrPort, netip.AddrPort, bool) {
+	if !src.IsValid() || !dst.IsValid() {
+		return src, dst, false
+	}
+	src = netip.AddrPortFrom(src.Addr().Unmap(), src.Port())
+	dst = netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())
+	if src.Addr().Is4() != dst.Addr().Is4() {
+		src = netip.AddrPortFrom(netip.AddrFrom16(src.Addr().As16()), src.Port())
+		dst = netip.AddrPortFrom(netip.AddrFrom16(dst.Addr().As16()), dst.Port())
+	}
+	return src, dst, true
+}
+
+func proxyProtocolV1Header(src, dst netip.AddrPort, known bool) []byte {
+	src, dst, valid := normalizeProxyProtocolAddrs(src, dst)
+	if !known || !valid {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	family := "TCP4"
+	if !src.Addr().Is4() {
+		family = "TCP6"
+	}
+	return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", family, src.Addr(), dst.Addr(), src.Port(), dst.Port())
+}
+
+func proxyProtocolV2Header(cfg config.ProxyProtocolConfig, info StreamClientInfo, dst netip.AddrPort, known bool) ([]byte, error) {
+	src, dst, valid := normalizeProxyProtocolAddrs(info.Addr, dst)
+	command := byte(pp2CommandProxy)
+	family := byte(pp2FamilyUnspec)
+	var body bytes.Buffer
+	switch {
+	case !known || !valid:
+		command = pp2CommandLocal
+	case src.Addr().Is4():
+		family = pp2FamilyTCP4
+		srcIP, dstIP := src.Addr().As4(), dst.Addr().As4()
+		body.Write(srcIP[:])
+		body.Write(dstIP[:])
+	default:
+		family = pp2FamilyTCP6
+		srcIP, dstIP := src.Addr().As16(), dst.Addr().As16()
+		body.Write(srcIP[:])
+		body.Write(dstIP[:])
+	}
+	if family != pp2FamilyUnspec {
+		_ = binary.Write(&body, binary.BigEndian, src.Port())
+		_ = binary.Write(&body, binary.BigEndian, dst.Port())
+	}
+
+	if known && cfg.Authority && info.Authority != "" {
+		if err := writeProxyProtocolTLV(&body, pp2TypeAuthority, info.Authority); err != nil {
+			return nil, err
+		}
+	}
+	if known && cfg.UniqueID && info.UniqueID != "" {
+		if err := writeProxyProtocolTLV(&body, pp2TypeUniqueID, info.UniqueID); err != nil {
+			return nil, err
+		}
+	}
+	for _, tlv := range cfg.TLVs {
+		if err := writeProxyProtocolTLV(&body, tlv.Type, tlv.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	if body.Len() > math.MaxUint16 {
+		return nil, fmt.Errorf("PROXY protocol header of %d bytes is too long", body.Len())
+	}
+	header := make([]byte, 0, len(proxyProtocolV2Signature)+4+body.Len())
+	header = append(header, proxyProtocolV2Signature...)
+	header = append(header, command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(body.Len()))
+	return append(header, body.Bytes()...), nil
+}
+
+func writeProxyProtocolTLV(body *bytes.Buffer, tlvType uint8, value string) error {
+	if len(value) > math.MaxUint16 {
+		return fmt.Errorf("value of TLV type %#x is too long", tlvType)
+	}
+	body.WriteByte(tlvType)
+	_ = binary.Write(body, binary.BigEndian, uint16(len(value)))
+	body.WriteString(value)
+	return nil
+}
@@ -0,0 +1,133 @@
+package ingress
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestProxyProtocolV1Header(t *testing.T) {
+	tests := []struct {
+		src, dst string
+		expected string
+	}{
+		{src: "198.51.100.7:0", dst: "127.0.0.1:5432", expected: "PROXY TCP4 198.51.100.7 127.0.0.1 0 5432\r\n"},
+		{src: "[2001:db8::7]:4000", dst: "[::1]:5432", expected: "PROXY TCP6 2001:db8::7 ::1 4000 5432\r\n"},
+		// Addresses of different families are both sent as IPv6
+		{src: "198.51.100.7:4000", dst: "[::1]:5432", expected: "PROXY TCP6 ::ffff:198.51.100.7 ::1 4000 5432\r\n"},
+		{src: "[::ffff:198.51.100.7]:4000", dst: "127.0.0.1:5432", expected: "PROXY TCP4 198.51.100.7 127.0.0.1 4000 5432\r\n"},
+	}
+	for _, test := range tests {
+		header := proxyProtocolV1Header(netip.MustParseAddrPort(test.src), netip.MustParseAddrPort(test.dst), true)
+		assert.Equal(t, test.expected, string(header))
+	}
+	assert.Equal(t, "PROXY UNKNOWN\r\n", string(proxyProtocolV1Header(netip.AddrPort{}, netip.MustParseAddrPort("127.0.0.1:5432"), false)))
+}
+
+func TestProxyProtocolV2Header(t *testing.T) {
+	cfg := config.ProxyProtocolConfig{
+		Version:   ProxyProtocolV2,
+		Authority: true,
+		UniqueID:  true,
+		TLVs:      []config.ProxyProtocolTLV{{Type: 0xE0, Value: "custom"}},
+	}
+	info := StreamClientInfo{
+		Addr:      netip.MustParseAddrPort("198.51.100.7:4000"),
+		Authority: "db.example.com",
+		UniqueID:  "8f3e1c2b4a5d6e7f-DEV",
+	}
+	header, err := proxyProtocolV2Header(cfg, info, netip.MustParseAddrPort("127.0.0.1:5432"), true)
+	require.NoError(t, err)
+
+	var expected bytes.Buffer
+	expected.Write(proxyProtocolV2Signature)
+	expected.Write([]byte{pp2CommandProxy, pp2FamilyTCP4})
+	tlvs := 3 + len(info.Authority) + 3 + len(info.UniqueID) + 3 + len("custom")
+	_ = binary.Write(&expected, binary.BigEndian, uint16(12+tlvs))
+	expected.Write([]byte{198, 51, 100, 7, 127, 0, 0, 1, 0x0f, 0xa0, 0x15, 0x38})
+	expected.Write([]byte{pp2TypeAuthority, 0, byte(len(info.Authority))})
+	expected.WriteString(info.Authority)
+	expected.Write([]byte{pp2TypeUniqueID, 0, byte(len(info.UniqueID))})
+	expected.WriteString(info.UniqueID)
+	expected.Write([]byte{0xE0, 0, 6})
+	expected.WriteString("custom")
+	assert.Equal(t, expected.Bytes(), header)
+
+	// Without eyeball, the connection is reported as local and only the configured TLVs are sent
+	header, err = proxyProtocolV2Header(cfg, StreamClientInfo{}, netip.MustParseAddrPort("127.0.0.1:5432"), false)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{pp2CommandLocal, pp2FamilyUnspec, 0, 9}, header[12:16])
+
+	// Headers whose length doesn't fit in 16 bits are refused
+	info.Authority = strings.Repeat("a", math.MaxUint16+1)
+	_, err = proxyProtocolV2Header(cfg, info, netip.MustParseAddrPort("127.0.0.1:5432"), true)
+	require.Error(t, err)
+	cfg.TLVs = []config.ProxyProtocolTLV{{Type: 0xE0, Value: strings.Repeat("a", math.MaxUint16)}}
+	_, err = proxyProtocolV2Header(cfg, StreamClientInfo{}, netip.MustParseAddrPort("127.0.0.1:5432"), false)
+	require.Error(t, err)
+}
+
+func TestTCPOverWSServiceProxyProtocol(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		header := make([]byte, 128)
+		n, _ := io.ReadAtLeast(conn, header, 1)
+		received <- header[:n]
+	}()
+
+	u, err := url.Parse("tcp://" + listener.Addr().String())
+	require.NoError(t, err)
+	service := newTCPOverWSService(u)
+	cfg := OriginRequestConfig{ProxyProtocol: config.ProxyProtocolConfig{Version: ProxyProtocolV1}}
+	require.NoError(t, validateProxyProtocolConfiguration(cfg))
+	log := zerolog.Nop()
+	require.NoError(t, service.start(&log, nil, cfg))
+
+	ctx := ContextWithStreamClientInfo(context.Background(), StreamClientInfo{Addr: netip.MustParseAddrPort("198.51.100.7:0")})
+	conn, err := service.EstablishConnection(ctx, "", &log)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	assert.Equal(t, "PROXY TCP4 198.51.100.7 127.0.0.1 0 "+strconv.Itoa(port)+"\r\n", string(<-received))
+}
+
+func TestValidateProxyProtocolConfiguration(t *testing.T) {
+	require.NoError(t, validateProxyProtocolConfiguration(OriginRequestConfig{}))
+	require.Error(t, validateProxyProtocolConfiguration(OriginRequestConfig{
+		ProxyProtocol: config.ProxyProtocolConfig{Version: "v3"},
+	}))
+	require.Error(t, validateProxyProtocolConfiguration(OriginRequestConfig{
+		ProxyProtocol: config.ProxyProtocolConfig{Version: ProxyProtocolV1, Authority: true},
+	}))
+	require.Error(t, validateProxyProtocolConfiguration(OriginRequestConfig{
+		ProxyProtocol: config.ProxyProtocolConfig{Version: ProxyProtocolV2, TLVs: []config.ProxyProtocolTLV{{Value: "no type"}}},
+	}))
+	require.Error(t, validateProxyProtocolConfiguration(OriginRequestConfig{
+		ProxyType:     socksProxy,
+		ProxyProtocol: config.ProxyProtocolConfig{Version: ProxyProtocolV2},
+	}))
+}
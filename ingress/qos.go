@@ -0,0 +1,101 @@
+package ingress
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/pkg/errors"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+// QoSClass is the priority of the UDP flows to a destination. When the datagrams can't be sent to the edge fast
+// enough, datagrams of lower classes are dropped first.
+type QoSClass uint8
+
+const (
+	// QoSUnclassified is used when no QoS rules are configured, datagrams are then sent in order without dropping.
+	QoSUnclassified QoSClass = iota
+	QoSBulk
+	QoSStandard
+	QoSRealtime
+)
+
+// QoSClasses lists the classes from the lowest to the highest priority.
+var QoSClasses = []QoSClass{QoSBulk, QoSStandard, QoSRealtime}
+
+func ParseQoSClass(s string) (QoSClass, error) {
+	switch s {
+	case "bulk":
+		return QoSBulk, nil
+	case "standard":
+		return QoSStandard, nil
+	case "realtime":
+		return QoSRealtime, nil
+	default:
+		return QoSUnclassified, fmt.Errorf("unknown QoS class %q, expected one of bulk, standard or realtime", s)
+	}
+}
+
+func (c QoSClass) String() string {
+	switch c {
+	case QoSBulk:
+		return "bulk"
+	case QoSStandard:
+		return "standard"
+	case QoSRealtime:
+		return "realtime"
+	default:
+		return "unclassified"
+	}
+}
+
+func (c QoSClass) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// QoSRule assigns a QoS class to the UDP flows towards a destination network.
+type QoSRule struct {
+	Network netip.Prefix `json:"network"`
+	Class   QoSClass     `json:"class"`
+}
+
+func parseQoSRules(raw []config.QoSRule) ([]QoSRule, error) {
+	rules := make([]QoSRule, 0, len(raw))
+	for _, r := range raw {
+		network, err := netip.ParsePrefix(r.Network)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid QoS rule network %q", r.Network)
+		}
+		class, err := ParseQoSClass(r.Class)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, QoSRule{Network: network.Masked(), Class: class})
+	}
+	return rules, nil
+}
+
+// ValidateQoSRules checks that the QoS rules of the warp-routing configuration can be parsed.
+func ValidateQoSRules(raw []config.QoSRule) error {
+	_, err := parseQoSRules(raw)
+	return err
+}
+
+// classify returns the class of the most specific rule matching addr. Destinations without matching rule are
+// standard, unless no rule is configured at all.
+func classify(rules []QoSRule, addr netip.Addr) QoSClass {
+	if len(rules) == 0 {
+		return QoSUnclassified
+	}
+	class := QoSStandard
+	bits := -1
+	addr = addr.Unmap()
+	for _, rule := range rules {
+		if rule.Network.Bits() > bits && rule.Network.Contains(addr) {
+			class = rule.Class
+			bits = rule.Network.Bits()
+		}
+	}
+	return class
+}
@@ -0,0 +1,50 @@
+package ingress
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestDialerQoSClass(t *testing.T) {
+	raw := config.WarpRoutingConfig{
+		QoS: []config.QoSRule{
+			{Network: "10.0.0.0/8", Class: "bulk"},
+			{Network: "10.1.0.0/16", Class: "realtime"},
+			{Network: "2001:db8::/32", Class: "realtime"},
+		},
+	}
+	require.NoError(t, ValidateQoSRules(raw.QoS))
+	warpRouting := NewWarpRoutingConfig(&raw)
+	dialer := NewDialer(warpRouting)
+
+	tests := []struct {
+		dest     string
+		expected QoSClass
+	}{
+		{dest: "10.2.3.4:53", expected: QoSBulk},
+		{dest: "10.1.3.4:5060", expected: QoSRealtime},
+		{dest: "[::ffff:10.1.3.4]:5060", expected: QoSRealtime},
+		{dest: "[2001:db8::1]:5060", expected: QoSRealtime},
+		{dest: "192.168.1.1:53", expected: QoSStandard},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, dialer.QoSClass(netip.MustParseAddrPort(test.dest)), test.dest)
+	}
+
+	// The rules survive the round trip through the raw configuration
+	rawAgain := warpRouting.RawConfig()
+	assert.Equal(t, raw.QoS, rawAgain.QoS)
+
+	// Without rules flows are unclassified
+	assert.Equal(t, QoSUnclassified, NewDialer(WarpRoutingConfig{}).QoSClass(netip.MustParseAddrPort("10.1.3.4:5060")))
+}
+
+func TestValidateQoSRules(t *testing.T) {
+	require.Error(t, ValidateQoSRules([]config.QoSRule{{Network: "10.0.0.0", Class: "bulk"}}))
+	require.Error(t, ValidateQoSRules([]config.QoSRule{{Network: "10.0.0.0/8", Class: "voice"}}))
+}
@@ -0,0 +1,118 @@
+package ingress
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+// Severity is how serious a Finding is.
+type Severity string
+
+const (
+	// SeverityError findings prevent the configuration from being applied.
+	SeverityError Severity = "error"
+	// SeverityWarning findings are likely mistakes that don't prevent the configuration from being applied.
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is a problem found in a configuration.
+type Finding struct {
+	Severity Severity `json:"severity"`
+	// Rule is the index of the ingress rule, -1 for the findings about the whole configuration.
+	Rule int `json:"rule"`
+	// Field is the path of the field of the rule, e.g. originRequest.mirror, if the finding is about one.
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// Findings are all the problems found in a configuration.
+type Findings []Finding
+
+// HasErrors returns whether any of the findings prevents the configuration from being applied.
+func (f Findings) HasErrors() bool {
+	for _, finding := range f {
+		if finding.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldError is an error about a field of an ingress rule.
+type fieldError struct {
+	field string
+	err   error
+}
+
+func invalidField(field string, err error) error {
+	return &fieldError{field: field, err: err}
+}
+
+func (e *fieldError) Error() string {
+	return e.err.Error()
+}
+
+func (e *fieldError) Unwrap() error {
+	return e.err
+}
+
+// Validate validates the ingress rules of conf like ParseIngress does, but reports every problem found instead of
+// stopping at the first one.
+func Validate(conf *config.Configuration) Findings {
+	if conf == nil || len(conf.Ingress) == 0 {
+		return Findings{{Severity: SeverityError, Rule: -1, Field: "ingress", Message: ErrNoIngressRules.Error()}}
+	}
+	return ValidateRules(conf.Ingress, conf.OriginRequest)
+}
+
+// ValidateRules validates ingress rules with the default origin request configuration, reporting every problem found.
+func ValidateRules(ingress []config.UnvalidatedIngressRule, defaults config.OriginRequestConfig) Findings {
+	if len(ingress) == 0 {
+		return Findings{{Severity: SeverityError, Rule: -1, Field: "ingress", Message: ErrNoIngressRules.Error()}}
+	}
+	findings := Findings{}
+	originRequest := originRequestFromConfig(defaults)
+	udpAddresses := make(map[netip.AddrPort]int)
+	for i, r := range ingress {
+		rule, err := validateRule(r, i, len(ingress), originRequest, udpAddresses)
+		if err != nil {
+			finding := Finding{Severity: SeverityError, Rule: i, Message: err.Error()}
+			if fieldErr, ok := err.(*fieldError); ok {
+				finding.Field = fieldErr.field
+			}
+			findings = append(findings, finding)
+			continue
+		}
+		findings = append(findings, ruleWarnings(ingress, i, rule)...)
+	}
+	return findings
+}
+
+// ruleWarnings returns the likely mistakes of a valid rule.
+func ruleWarnings(ingress []config.UnvalidatedIngressRule, i int, rule Rule) Findings {
+	var findings Findings
+	if _, isUDP := rule.Service.(*udpService); !isUDP && i < len(ingress)-1 {
+		for prior := 0; prior < i; prior++ {
+			if ingress[prior].Hostname == ingress[i].Hostname && ingress[prior].Path == ingress[i].Path {
+				findings = append(findings, Finding{
+					Severity: SeverityWarning,
+					Rule:     i,
+					Field:    "hostname",
+					Message:  fmt.Sprintf("Rule #%d is never matched, rule #%d matches the same hostname and path", i+1, prior+1),
+				})
+				break
+			}
+		}
+	}
+	if rule.Config.NoTLSVerify {
+		findings = append(findings, Finding{
+			Severity: SeverityWarning,
+			Rule:     i,
+			Field:    "originRequest.noTLSVerify",
+			Message:  fmt.Sprintf("Rule #%d doesn't verify the TLS certificate of the origin", i+1),
+		})
+	}
+	return findings
+}
@@ -0,0 +1,45 @@
+package ingress
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestValidate(t *testing.T) {
+	noTLSVerify := true
+	findings := Validate(&config.Configuration{
+		Ingress: []config.UnvalidatedIngressRule{
+			{Hostname: "a.example.com", Service: "http://localhost:8000"},
+			{Hostname: "b.example.com:8080", Service: "http://localhost:8001"},
+			{Hostname: "a.example.com", Service: "https://localhost:8002", OriginRequest: config.OriginRequestConfig{NoTLSVerify: &noTLSVerify}},
+			{Hostname: "c.example.com", Service: "http://localhost:8003", OriginRequest: config.OriginRequestConfig{
+				Mirror: &config.MirrorConfig{Origin: "ftp://mirror"},
+			}},
+			{Hostname: "d.example.com", Path: "(", Service: "http://localhost:8004"},
+			{Service: "localhost"},
+		},
+	})
+	require.True(t, findings.HasErrors())
+	assert.Equal(t, Findings{
+		{Severity: SeverityError, Rule: 1, Field: "hostname", Message: errHostnameContainsPort.Error()},
+		{Severity: SeverityWarning, Rule: 2, Field: "hostname", Message: "Rule #3 is never matched, rule #1 matches the same hostname and path"},
+		{Severity: SeverityWarning, Rule: 2, Field: "originRequest.noTLSVerify", Message: "Rule #3 doesn't verify the TLS certificate of the origin"},
+		{Severity: SeverityError, Rule: 3, Field: "originRequest.mirror", Message: `Rule #4 has an invalid mirror configuration: invalid mirror.origin "ftp://mirror", expected an http:// or https:// URL`},
+		{Severity: SeverityError, Rule: 4, Field: "path", Message: "Rule #5 has an invalid regex: error parsing regexp: missing closing ): `(`"},
+		{Severity: SeverityError, Rule: 5, Field: "service", Message: "localhost is an invalid address, please make sure it has a scheme and a hostname"},
+	}, findings)
+
+	findings = Validate(&config.Configuration{
+		Ingress: []config.UnvalidatedIngressRule{{Service: "http_status:404"}},
+	})
+	assert.Empty(t, findings)
+	assert.False(t, findings.HasErrors())
+
+	findings = Validate(&config.Configuration{})
+	require.Len(t, findings, 1)
+	assert.Equal(t, -1, findings[0].Rule)
+}
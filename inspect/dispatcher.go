@@ -0,0 +1,147 @@
+package inspect
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultQueueSize = 1024
+	defaultWorkers   = 4
+	// defaultBudget is the time each inspector is given to process an event
+	defaultBudget = 50 * time.Millisecond
+	// maxOverruns is how many consecutive events an inspector may overrun its budget for before it's disabled
+	maxOverruns = 3
+	// disabledPeriod is how long an inspector overrunning its budget too often is skipped for
+	disabledPeriod = time.Minute
+)
+
+type namedInspector struct {
+	name      string
+	inspector Inspector
+}
+
+type job struct {
+	inspectors []namedInspector
+	event      *Event
+}
+
+// Dispatcher hands events to inspectors asynchronously. The workers stop waiting for an inspector once it overruns its
+// budget, and skip it until that call returns. An inspector overrunning its budget for maxOverruns events in a row is
+// skipped for disabledPeriod.
+type Dispatcher struct {
+	queue  chan job
+	budget time.Duration
+	// states are the inspectorStates of the inspectors, by name
+	states sync.Map
+
+	// nowFunc is overridden in tests
+	nowFunc func() time.Time
+}
+
+// inspectorState tracks how an inspector keeps up with its budget.
+type inspectorState struct {
+	// overrunning is set while a call that overran the budget hasn't returned
+	overrunning atomic.Bool
+	// overruns counts the consecutive events the inspector overran the budget for
+	overruns atomic.Uint32
+	// disabledUntil is when a disabled inspector is called again, in Unix nanoseconds
+	disabledUntil atomic.Int64
+}
+
+func (s *inspectorState) callable(now time.Time) bool {
+	return !s.overrunning.Load() && now.UnixNano() >= s.disabledUntil.Load()
+}
+
+var (
+	defaultDispatcher     *Dispatcher
+	defaultDispatcherOnce sync.Once
+)
+
+// DefaultDispatcher returns the dispatcher shared by every proxy, starting it on first use.
+func DefaultDispatcher() *Dispatcher {
+	defaultDispatcherOnce.Do(func() {
+		defaultDispatcher = NewDispatcher(defaultQueueSize, defaultWorkers, defaultBudget)
+	})
+	return defaultDispatcher
+}
+
+// NewDispatcher starts workers processing events from a queue of queueSize events, giving each inspector budget
+// to process an event.
+func NewDispatcher(queueSize, workers int, budget time.Duration) *Dispatcher {
+	d := &Dispatcher{
+		queue:   make(chan job, queueSize),
+		budget:  budget,
+		nowFunc: time.Now,
+	}
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// Submit queues the event for the inspectors without blocking. It returns false if the event was dropped because
+// the queue is full.
+func (d *Dispatcher) Submit(pipeline *Pipeline, event *Event) bool {
+	select {
+	case d.queue <- job{inspectors: pipeline.inspectors, event: event}:
+		return true
+	default:
+		eventsDropped.Inc()
+		return false
+	}
+}
+
+func (d *Dispatcher) work() {
+	for j := range d.queue {
+		for _, inspector := range j.inspectors {
+			d.inspect(inspector, j.event)
+		}
+	}
+}
+
+func (d *Dispatcher) inspect(inspector namedInspector, event *Event) {
+	state := d.state(inspector.name)
+	if !state.callable(d.nowFunc()) {
+		eventsSkipped.WithLabelValues(inspector.name).Inc()
+		return
+	}
+	eventsInspected.WithLabelValues(inspector.name).Inc()
+
+	// The inspector runs aside, so that the worker doesn't wait for it past the budget if it ignores ctx
+	ctx, cancel := context.WithTimeout(context.Background(), d.budget)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		inspector.inspector.Inspect(ctx, event)
+	}()
+	select {
+	case <-done:
+		state.overruns.Store(0)
+		return
+	case <-ctx.Done():
+	}
+
+	budgetExceeded.WithLabelValues(inspector.name).Inc()
+	state.overrunning.Store(true)
+	go func() {
+		<-done
+		state.overrunning.Store(false)
+	}()
+	if state.overruns.Add(1) >= maxOverruns {
+		state.overruns.Store(0)
+		state.disabledUntil.Store(d.nowFunc().Add(disabledPeriod).UnixNano())
+		inspectorsDisabled.WithLabelValues(inspector.name).Inc()
+	}
+}
+
+func (d *Dispatcher) state(name string) *inspectorState {
+	if state, ok := d.states.Load(name); ok {
+		return state.(*inspectorState)
+	}
+	state, _ := d.states.LoadOrStore(name, &inspectorState{})
+	return state.(*inspectorState)
+}
@@ -0,0 +1,87 @@
+// Package inspect allows DLP/IDS style integrations to observe the HTTP requests proxied by cloudflared without
+// modifying the proxy. Inspectors register themselves by name, usually from an init function, and ingress rules
+// opt into them with the inspection origin request setting.
+//
+// Inspection never slows down proxying: events are handed to a bounded queue processed by a fixed pool of workers,
+// events are dropped when the queue is full, and each inspector call is bounded by a time budget. The workers don't
+// wait for an inspector past its budget, and an inspector that keeps overrunning it is skipped for a while.
+package inspect
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Inspector observes proxied requests. Implementations must be safe for concurrent use, must not modify the event
+// and should return before ctx is done.
+type Inspector interface {
+	Inspect(ctx context.Context, event *Event)
+}
+
+// InspectorFunc adapts a function to the Inspector interface.
+type InspectorFunc func(ctx context.Context, event *Event)
+
+func (f InspectorFunc) Inspect(ctx context.Context, event *Event) {
+	f(ctx, event)
+}
+
+// Event describes a proxied request and its response. Headers are copies, and samples are at most the configured
+// number of bytes from the start of the bodies.
+type Event struct {
+	// Rule is the index of the ingress rule that matched the request
+	Rule           int
+	Hostname       string
+	Method         string
+	Path           string
+	RequestHeader  http.Header
+	RequestSample  []byte
+	StatusCode     int
+	ResponseHeader http.Header
+	ResponseSample []byte
+	Duration       time.Duration
+	// Error is set when the request couldn't be proxied to the origin
+	Error string
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]Inspector)
+)
+
+// Register makes an inspector available to ingress rules under name. It panics if name is already registered.
+func Register(name string, inspector Inspector) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if inspector == nil {
+		panic("inspect: Register inspector is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("inspect: Register called twice for inspector " + name)
+	}
+	registry[name] = inspector
+}
+
+// Lookup returns the inspector registered under name.
+func Lookup(name string) (Inspector, error) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	inspector, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown inspector %q, registered inspectors are %v", name, registeredNames())
+	}
+	return inspector, nil
+}
+
+// caller must hold the lock
+func registeredNames() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
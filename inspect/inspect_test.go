@@ -0,0 +1,95 @@
+package inspect
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline(t *testing.T) {
+	events := make(chan *Event, 1)
+	Register(t.Name(), InspectorFunc(func(ctx context.Context, event *Event) {
+		events <- event
+	}))
+	require.Panics(t, func() { Register(t.Name(), InspectorFunc(func(context.Context, *Event) {})) })
+
+	_, err := NewPipeline([]string{"unknown"}, 0, 0)
+	require.Error(t, err)
+
+	pipeline, err := NewPipeline([]string{t.Name()}, 0, 16)
+	require.NoError(t, err)
+	require.True(t, pipeline.Sampled())
+	require.Equal(t, 16, pipeline.SampleBytes())
+
+	dispatcher := NewDispatcher(1, 1, time.Second)
+	event := &Event{Hostname: "example.com"}
+	require.True(t, dispatcher.Submit(pipeline, event))
+	select {
+	case inspected := <-events:
+		require.Equal(t, event, inspected)
+	case <-time.After(time.Second):
+		require.Fail(t, "event wasn't inspected")
+	}
+}
+
+func TestDispatcherDropsEventsWhenFull(t *testing.T) {
+	Register(t.Name(), InspectorFunc(func(context.Context, *Event) {}))
+	pipeline, err := NewPipeline([]string{t.Name()}, 1, 0)
+	require.NoError(t, err)
+
+	// Without workers nothing drains the queue
+	dispatcher := NewDispatcher(2, 0, time.Second)
+	require.True(t, dispatcher.Submit(pipeline, &Event{}))
+	require.True(t, dispatcher.Submit(pipeline, &Event{}))
+	require.False(t, dispatcher.Submit(pipeline, &Event{}))
+}
+
+func TestDispatcherSkipsInspectorsOverBudget(t *testing.T) {
+	release := make(chan struct{})
+	slowCalls := make(chan struct{}, 16)
+	Register(t.Name()+"/slow", InspectorFunc(func(context.Context, *Event) {
+		// Ignores ctx, like a misbehaving inspector
+		slowCalls <- struct{}{}
+		<-release
+	}))
+	fastEvents := make(chan *Event, 16)
+	Register(t.Name()+"/fast", InspectorFunc(func(_ context.Context, event *Event) {
+		fastEvents <- event
+	}))
+	pipeline, err := NewPipeline([]string{t.Name() + "/slow", t.Name() + "/fast"}, 1, 0)
+	require.NoError(t, err)
+
+	dispatcher := NewDispatcher(16, 1, 10*time.Millisecond)
+	now := time.Now()
+	dispatcher.nowFunc = func() time.Time { return now }
+	state := dispatcher.state(t.Name() + "/slow")
+	receive := func() {
+		select {
+		case <-fastEvents:
+		case <-time.After(time.Second):
+			require.Fail(t, "the worker waited for the slow inspector")
+		}
+	}
+
+	// The worker stops waiting for the slow inspector, and skips it while it's still running
+	require.True(t, dispatcher.Submit(pipeline, &Event{}))
+	receive()
+	require.True(t, dispatcher.Submit(pipeline, &Event{}))
+	receive()
+	require.Len(t, slowCalls, 1)
+
+	// After overrunning its budget too many times in a row, it's disabled for a while
+	for i := 1; i < maxOverruns; i++ {
+		release <- struct{}{}
+		require.Eventually(t, func() bool { return !state.overrunning.Load() }, time.Second, time.Millisecond)
+		require.True(t, dispatcher.Submit(pipeline, &Event{}))
+		receive()
+	}
+	release <- struct{}{}
+	require.Eventually(t, func() bool { return !state.overrunning.Load() }, time.Second, time.Millisecond)
+	require.False(t, state.callable(now))
+	require.True(t, state.callable(now.Add(disabledPeriod)))
+	close(release)
+}
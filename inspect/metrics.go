@@ -0,0 +1,61 @@
+package inspect
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "cloudflared"
+	metricsSubsystem = "inspection"
+)
+
+var (
+	eventsInspected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "events_inspected",
+			Help:      "Total count of events processed by each inspector",
+		},
+		[]string{"inspector"},
+	)
+	budgetExceeded = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "budget_exceeded",
+			Help:      "Total count of events an inspector took longer than its time budget to process",
+		},
+		[]string{"inspector"},
+	)
+	eventsSkipped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "events_skipped",
+			Help:      "Total count of events an inspector was skipped for, because it was still processing an event past its time budget or was disabled",
+		},
+		[]string{"inspector"},
+	)
+	inspectorsDisabled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "inspector_disabled",
+			Help:      "Total count of the times an inspector was disabled for a while because it kept exceeding its time budget",
+		},
+		[]string{"inspector"},
+	)
+	eventsDropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "events_dropped",
+			Help:      "Total count of events dropped because the inspection queue was full",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(eventsInspected, budgetExceeded, eventsSkipped, inspectorsDisabled, eventsDropped)
+}
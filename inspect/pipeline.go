@@ -0,0 +1,42 @@
+package inspect
+
+import (
+	"math/rand"
+)
+
+// Pipeline is the set of inspectors an ingress rule sends its requests to.
+type Pipeline struct {
+	inspectors  []namedInspector
+	sampleRate  float64
+	sampleBytes int
+}
+
+// NewPipeline resolves the named inspectors. sampleRate is the fraction of requests inspected, 0 meaning all of
+// them, and sampleBytes the number of body bytes included in events.
+func NewPipeline(names []string, sampleRate float64, sampleBytes uint) (*Pipeline, error) {
+	p := &Pipeline{
+		sampleRate:  sampleRate,
+		sampleBytes: int(sampleBytes),
+	}
+	if p.sampleRate <= 0 || p.sampleRate > 1 {
+		p.sampleRate = 1
+	}
+	for _, name := range names {
+		inspector, err := Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		p.inspectors = append(p.inspectors, namedInspector{name: name, inspector: inspector})
+	}
+	return p, nil
+}
+
+// Sampled decides whether a request is inspected.
+func (p *Pipeline) Sampled() bool {
+	return p.sampleRate >= 1 || rand.Float64() < p.sampleRate
+}
+
+// SampleBytes is the number of body bytes included in events.
+func (p *Pipeline) SampleBytes() int {
+	return p.sampleBytes
+}
@@ -0,0 +1,274 @@
+// Package leakcheck audits cloudflared periodically for goroutine and resource leaks, which otherwise go unnoticed
+// until the connector restarts.
+package leakcheck
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultGrowthAudits = 3
+	defaultMinGrowth    = 50
+	stackSamples        = 3
+
+	// ReportPath is where the report of the last audit is served, under the debug endpoints of the metrics server
+	ReportPath = "/debug/leaks"
+)
+
+// served is the auditor whose report is served at ReportPath.
+var served atomic.Pointer[Auditor]
+
+func init() {
+	// Like net/http/pprof and expvar, served by the debug endpoints of the metrics server through the default mux
+	http.HandleFunc(ReportPath, func(w http.ResponseWriter, r *http.Request) {
+		auditor := served.Load()
+		if auditor == nil {
+			http.NotFound(w, r)
+			return
+		}
+		auditor.ServeHTTP(w, r)
+	})
+}
+
+// Probe compares what a subsystem should hold with what it holds, e.g. the UDP sessions counted as in-flight flows
+// with the sessions held by the session managers.
+type Probe struct {
+	Subsystem string
+	Resource  string
+	// Expected returns how many resources the subsystem should hold
+	Expected func() int64
+	// Actual returns how many resources the subsystem holds
+	Actual func() int64
+}
+
+// Config configures an Auditor.
+type Config struct {
+	// Interval is the time between audits
+	Interval time.Duration
+	// GrowthAudits is the number of consecutive audits a count must grow in to be suspicious. It defaults to 3.
+	GrowthAudits int
+	// MinGrowth is the growth over those audits below which growing counts aren't suspicious. It defaults to 50.
+	MinGrowth int64
+}
+
+// Auditor audits the goroutines of each subsystem, and the resources of the probes. A count is suspicious when it
+// grew in each of the last audits while the load of its subsystem, the resources it's expected to hold, didn't.
+// Suspicious counts are logged, with samples of the stacks of the goroutines of the subsystem.
+type Auditor struct {
+	config Config
+	log    *zerolog.Logger
+	// profile returns the goroutines by stack
+	profile func() ([]goroutineStack, error)
+
+	lock    sync.Mutex
+	probes  []Probe
+	history map[string][]sample
+	last    Report
+}
+
+// sample is a count and the load of its subsystem at an audit.
+type sample struct {
+	count, load int64
+}
+
+// Report is the result of an audit, as served by the Auditor.
+type Report struct {
+	Time       time.Time           `json:"time"`
+	Goroutines map[string]int64    `json:"goroutines"`
+	Resources  []ResourceReport    `json:"resources"`
+	Suspicious []SuspiciousGrowth  `json:"suspicious,omitempty"`
+	Stacks     map[string][]string `json:"stacks,omitempty"`
+}
+
+// ResourceReport is the expected and actual count of a resource of a subsystem.
+type ResourceReport struct {
+	Subsystem string `json:"subsystem"`
+	Resource  string `json:"resource"`
+	Expected  int64  `json:"expected"`
+	Actual    int64  `json:"actual"`
+	Delta     int64  `json:"delta"`
+}
+
+// SuspiciousGrowth is a count that grew in each of the last audits while the load of its subsystem didn't.
+type SuspiciousGrowth struct {
+	Subsystem string `json:"subsystem"`
+	Resource  string `json:"resource"`
+	From      int64  `json:"from"`
+	To        int64  `json:"to"`
+}
+
+func New(config Config, log *zerolog.Logger) *Auditor {
+	if config.GrowthAudits <= 0 {
+		config.GrowthAudits = defaultGrowthAudits
+	}
+	if config.MinGrowth <= 0 {
+		config.MinGrowth = defaultMinGrowth
+	}
+	return &Auditor{
+		config:  config,
+		log:     log,
+		profile: profileGoroutines,
+		history: make(map[string][]sample),
+	}
+}
+
+// Register adds a probe to the audits.
+func (a *Auditor) Register(probe Probe) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.probes = append(a.probes, probe)
+}
+
+// Run audits periodically until ctx is done, serving the report of the last audit at ReportPath.
+func (a *Auditor) Run(ctx context.Context) {
+	served.Store(a)
+	defer served.CompareAndSwap(a, nil)
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.audit()
+		}
+	}
+}
+
+func (a *Auditor) audit() {
+	stacks, err := a.profile()
+	if err != nil {
+		a.log.Err(err).Msg("Unable to profile the goroutines for the leak check")
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	report := Report{
+		Time:       time.Now(),
+		Goroutines: make(map[string]int64),
+	}
+	for _, stack := range stacks {
+		report.Goroutines[stack.subsystem] += stack.count
+	}
+
+	load := make(map[string]int64)
+	for _, probe := range a.probes {
+		expected, actual := probe.Expected(), probe.Actual()
+		load[probe.Subsystem] += expected
+		report.Resources = append(report.Resources, ResourceReport{
+			Subsystem: probe.Subsystem,
+			Resource:  probe.Resource,
+			Expected:  expected,
+			Actual:    actual,
+			Delta:     actual - expected,
+		})
+		resourceExpected.WithLabelValues(probe.Subsystem, probe.Resource).Set(float64(expected))
+		resourceActual.WithLabelValues(probe.Subsystem, probe.Resource).Set(float64(actual))
+		resourceDelta.WithLabelValues(probe.Subsystem, probe.Resource).Set(float64(actual - expected))
+	}
+
+	for _, subsystem := range subsystems {
+		count := report.Goroutines[subsystem]
+		goroutines.WithLabelValues(subsystem).Set(float64(count))
+		if growth, ok := a.record(subsystem, "goroutines", count, load[subsystem]); ok {
+			report.Suspicious = append(report.Suspicious, growth)
+		}
+	}
+	for _, resource := range report.Resources {
+		// A resource held beyond what's expected is what leaks, so the delta is what must not grow
+		if growth, ok := a.record(resource.Subsystem, resource.Resource, resource.Delta, resource.Expected); ok {
+			report.Suspicious = append(report.Suspicious, growth)
+		}
+	}
+
+	if len(report.Suspicious) > 0 {
+		report.Stacks = make(map[string][]string)
+		for _, growth := range report.Suspicious {
+			if _, ok := report.Stacks[growth.Subsystem]; ok {
+				continue
+			}
+			report.Stacks[growth.Subsystem] = topStacks(stacks, growth.Subsystem, stackSamples)
+		}
+		for _, growth := range report.Suspicious {
+			suspiciousGrowths.WithLabelValues(growth.Subsystem, growth.Resource).Inc()
+			a.log.Warn().
+				Str("subsystem", growth.Subsystem).
+				Str("resource", growth.Resource).
+				Int64("from", growth.From).
+				Int64("to", growth.To).
+				Int("audits", a.config.GrowthAudits).
+				Strs("stacks", report.Stacks[growth.Subsystem]).
+				Msg("Suspicious growth, this may be a leak")
+		}
+	}
+	a.last = report
+}
+
+// record adds the count of the resource of the subsystem to its history, returning whether it grew suspiciously.
+// The caller must hold the lock.
+func (a *Auditor) record(subsystem, resource string, count, load int64) (SuspiciousGrowth, bool) {
+	key := subsystem + "/" + resource
+	history := append(a.history[key], sample{count: count, load: load})
+	if len(history) > a.config.GrowthAudits+1 {
+		history = history[len(history)-a.config.GrowthAudits-1:]
+	}
+	a.history[key] = history
+	if len(history) <= a.config.GrowthAudits {
+		return SuspiciousGrowth{}, false
+	}
+	for i := 1; i < len(history); i++ {
+		if history[i].count <= history[i-1].count {
+			return SuspiciousGrowth{}, false
+		}
+	}
+	first, last := history[0], history[len(history)-1]
+	if last.count-first.count < a.config.MinGrowth || last.load > first.load {
+		return SuspiciousGrowth{}, false
+	}
+	// Report it once per growth window
+	a.history[key] = history[len(history)-1:]
+	return SuspiciousGrowth{Subsystem: subsystem, Resource: resource, From: first.count, To: last.count}, true
+}
+
+// Last returns the report of the last audit.
+func (a *Auditor) Last() Report {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.last
+}
+
+// ServeHTTP serves the report of the last audit.
+func (a *Auditor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(a.Last())
+}
+
+// topStacks returns the stacks of the subsystem with the most goroutines, with their count.
+func topStacks(stacks []goroutineStack, subsystem string, n int) []string {
+	var matching []goroutineStack
+	for _, stack := range stacks {
+		if stack.subsystem == subsystem {
+			matching = append(matching, stack)
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return matching[i].count > matching[j].count
+	})
+	if len(matching) > n {
+		matching = matching[:n]
+	}
+	samples := make([]string, 0, len(matching))
+	for _, stack := range matching {
+		samples = append(samples, stack.String())
+	}
+	return samples
+}
@@ -0,0 +1,172 @@
+package leakcheck
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testProfile = `goroutine profile: total 7
+4 @ 0x43e2ce 0x44f1a5 0x9c3a51 0x471801
+#	0x9c3a50	github.com/cloudflare/cloudflared/quic/v3.(*session).waitForCloseCondition+0x110	/src/quic/v3/session.go:300
+#	0x9c3a51	github.com/cloudflare/cloudflared/connection.(*quicConnection).runStream+0x90	/src/connection/quic_connection.go:160
+
+2 @ 0x43e2ce 0x44f1a5 0x471801
+# labels: {"component":"test"}
+#	0x8a1b20	io.Copy+0x20	/usr/local/go/src/io/io.go:388
+#	0x8a1b21	github.com/cloudflare/cloudflared/cfio.Copy+0x40	/src/cfio/copy.go:40
+
+1 @ 0x43e2ce 0x471801
+#	0x43e2cd	runtime.gopark+0xcd	/usr/local/go/src/runtime/proc.go:398
+`
+
+func TestParseGoroutineProfile(t *testing.T) {
+	stacks, err := parseGoroutineProfile(bytes.NewBufferString(testProfile))
+	require.NoError(t, err)
+	require.Len(t, stacks, 3)
+
+	assert.Equal(t, int64(4), stacks[0].count)
+	assert.Equal(t, SubsystemDatagram, stacks[0].subsystem)
+	assert.Equal(t, []string{
+		"github.com/cloudflare/cloudflared/quic/v3.(*session).waitForCloseCondition /src/quic/v3/session.go:300",
+		"github.com/cloudflare/cloudflared/connection.(*quicConnection).runStream /src/connection/quic_connection.go:160",
+	}, stacks[0].frames)
+
+	assert.Equal(t, int64(2), stacks[1].count)
+	assert.Equal(t, SubsystemProxy, stacks[1].subsystem)
+	assert.Len(t, stacks[1].frames, 2)
+
+	assert.Equal(t, SubsystemOther, stacks[2].subsystem)
+}
+
+func TestProfileGoroutines(t *testing.T) {
+	stacks, err := profileGoroutines()
+	require.NoError(t, err)
+	var total int64
+	for _, stack := range stacks {
+		total += stack.count
+	}
+	// At least the goroutine running the test
+	assert.Positive(t, total)
+}
+
+func TestSubsystemOf(t *testing.T) {
+	tests := []struct {
+		frame     string
+		subsystem string
+	}{
+		{"github.com/cloudflare/cloudflared/supervisor.(*Supervisor).Run /src/supervisor/supervisor.go:1", SubsystemSupervisor},
+		{"github.com/cloudflare/cloudflared/edgediscovery.(*Edge).GetAddr /src/edgediscovery/edgediscovery.go:1", SubsystemSupervisor},
+		{"github.com/cloudflare/cloudflared/datagramsession.(*manager).Serve /src/datagramsession/manager.go:1", SubsystemDatagram},
+		{"github.com/cloudflare/cloudflared/connection.(*datagramV2Connection).Serve /src/connection/quic_datagram_v2.go:1", SubsystemDatagram},
+		{"github.com/cloudflare/cloudflared/ingress.(*icmpProxy).Serve /src/ingress/icmp_linux.go:1", SubsystemDatagram},
+		{"github.com/cloudflare/cloudflared/ingress.(*httpService).RoundTrip /src/ingress/origin_proxy.go:1", SubsystemProxy},
+		{"github.com/cloudflare/cloudflared/proxy.(*Proxy).ProxyHTTP /src/proxy/proxy.go:1", SubsystemProxy},
+		{"github.com/cloudflare/cloudflared/connection.(*quicConnection).acceptStream /src/connection/quic_connection.go:1", SubsystemConnection},
+		{"github.com/cloudflare/cloudflared/logger.(*resilientMultiWriter).Write /src/logger/logger.go:1", SubsystemOther},
+		{"github.com/quic-go/quic-go.(*connection).run /vendor/quic-go/connection.go:1", SubsystemOther},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.subsystem, subsystemOf([]string{test.frame}), test.frame)
+	}
+}
+
+func TestAuditorSuspiciousGrowth(t *testing.T) {
+	log := zerolog.Nop()
+	auditor := New(Config{GrowthAudits: 3, MinGrowth: 10}, &log)
+	var goroutines, sessions, flows int64
+	auditor.profile = func() ([]goroutineStack, error) {
+		return []goroutineStack{{
+			count:     goroutines,
+			subsystem: SubsystemDatagram,
+			frames:    []string{"github.com/cloudflare/cloudflared/quic/v3.(*session).Serve"},
+		}}, nil
+	}
+	auditor.Register(Probe{
+		Subsystem: SubsystemDatagram,
+		Resource:  "udp_sessions",
+		Expected:  func() int64 { return flows },
+		Actual:    func() int64 { return sessions },
+	})
+
+	// Goroutines growing with the flows aren't suspicious
+	for i := 0; i < 5; i++ {
+		goroutines += 10
+		flows += 5
+		sessions += 5
+		auditor.audit()
+		assert.Empty(t, auditor.Last().Suspicious)
+	}
+
+	// Sessions outliving their flows are
+	for i := 0; i < 3; i++ {
+		goroutines += 10
+		sessions += 5
+		auditor.audit()
+		if i < 2 {
+			assert.Empty(t, auditor.Last().Suspicious)
+		}
+	}
+	report := auditor.Last()
+	assert.Equal(t, []SuspiciousGrowth{
+		{Subsystem: SubsystemDatagram, Resource: "goroutines", From: 50, To: 80},
+		{Subsystem: SubsystemDatagram, Resource: "udp_sessions", From: 0, To: 15},
+	}, report.Suspicious)
+	require.Len(t, report.Stacks[SubsystemDatagram], 1)
+	assert.Contains(t, report.Stacks[SubsystemDatagram][0], "80 goroutines")
+	assert.Equal(t, []ResourceReport{{
+		Subsystem: SubsystemDatagram,
+		Resource:  "udp_sessions",
+		Expected:  25,
+		Actual:    40,
+		Delta:     15,
+	}}, report.Resources)
+
+	// Reported once per growth window
+	goroutines += 10
+	sessions += 5
+	auditor.audit()
+	assert.Empty(t, auditor.Last().Suspicious)
+}
+
+func TestAuditorGrowthBelowMinimum(t *testing.T) {
+	log := zerolog.Nop()
+	auditor := New(Config{GrowthAudits: 3, MinGrowth: 10}, &log)
+	var goroutines int64 = 100
+	auditor.profile = func() ([]goroutineStack, error) {
+		return []goroutineStack{{count: goroutines, subsystem: SubsystemProxy}}, nil
+	}
+	for i := 0; i < 10; i++ {
+		goroutines++
+		auditor.audit()
+		assert.Empty(t, auditor.Last().Suspicious)
+	}
+}
+
+func TestServeReport(t *testing.T) {
+	log := zerolog.Nop()
+	auditor := New(Config{}, &log)
+	auditor.profile = func() ([]goroutineStack, error) {
+		return []goroutineStack{{count: 3, subsystem: SubsystemSupervisor}}, nil
+	}
+
+	recorder := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, ReportPath, nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	served.Store(auditor)
+	t.Cleanup(func() { served.Store(nil) })
+	auditor.audit()
+	recorder = httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, ReportPath, nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var report Report
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&report))
+	assert.Equal(t, int64(3), report.Goroutines[SubsystemSupervisor])
+}
@@ -0,0 +1,149 @@
+package leakcheck
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+)
+
+const (
+	modulePrefix = "github.com/cloudflare/cloudflared/"
+
+	SubsystemSupervisor = "supervisor"
+	SubsystemDatagram   = "datagram"
+	SubsystemProxy      = "proxy"
+	SubsystemConnection = "connection"
+	SubsystemOther      = "other"
+)
+
+// subsystems are the subsystems goroutines are counted for.
+var subsystems = []string{SubsystemSupervisor, SubsystemDatagram, SubsystemProxy, SubsystemConnection, SubsystemOther}
+
+// packageSubsystems maps the packages of cloudflared to their subsystem. More specific packages come first.
+var packageSubsystems = []struct {
+	pkg       string
+	subsystem string
+}{
+	{"quic/v3", SubsystemDatagram},
+	{"datagramsession", SubsystemDatagram},
+	{"packet", SubsystemDatagram},
+	{"supervisor", SubsystemSupervisor},
+	{"edgediscovery", SubsystemSupervisor},
+	{"proxy", SubsystemProxy},
+	{"stream", SubsystemProxy},
+	{"cfio", SubsystemProxy},
+	{"carrier", SubsystemProxy},
+	{"websocket", SubsystemProxy},
+	{"ingress", SubsystemProxy},
+	{"connection", SubsystemConnection},
+	{"quic", SubsystemConnection},
+}
+
+// goroutineStack is a stack shared by count goroutines.
+type goroutineStack struct {
+	count     int64
+	subsystem string
+	// frames are the functions of the stack, innermost first, with their location
+	frames []string
+}
+
+func (s goroutineStack) String() string {
+	return fmt.Sprintf("%d goroutines:\n\t%s", s.count, strings.Join(s.frames, "\n\t"))
+}
+
+// profileGoroutines returns the stacks of the goroutines running, from the goroutine profile of the runtime.
+func profileGoroutines() ([]goroutineStack, error) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return nil, err
+	}
+	return parseGoroutineProfile(&buf)
+}
+
+// parseGoroutineProfile parses a goroutine profile in its legacy text format, each stack being a "<count> @ <pcs>"
+// line followed by a "#\t<pc>\t<function>+<offset>\t<file>:<line>" line per frame.
+func parseGoroutineProfile(buf *bytes.Buffer) ([]goroutineStack, error) {
+	var (
+		stacks  []goroutineStack
+		current *goroutineStack
+	)
+	flush := func() {
+		if current != nil {
+			current.subsystem = subsystemOf(current.frames)
+			stacks = append(stacks, *current)
+			current = nil
+		}
+	}
+	scanner := bufio.NewScanner(buf)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(line, "#"):
+			if current == nil {
+				continue
+			}
+			fields := strings.Split(strings.TrimSpace(strings.TrimPrefix(line, "#")), "\t")
+			if len(fields) < 2 || strings.HasPrefix(fields[0], "labels:") {
+				continue
+			}
+			frame := fields[1]
+			if i := strings.LastIndex(frame, "+0x"); i > 0 {
+				frame = frame[:i]
+			}
+			if len(fields) >= 3 {
+				frame += " " + fields[2]
+			}
+			current.frames = append(current.frames, frame)
+		default:
+			count, _, found := strings.Cut(line, " @ ")
+			if !found {
+				// The header of the profile
+				continue
+			}
+			flush()
+			n, err := strconv.ParseInt(count, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid goroutine profile line %q: %w", line, err)
+			}
+			current = &goroutineStack{count: n}
+		}
+	}
+	flush()
+	return stacks, scanner.Err()
+}
+
+// subsystemOf returns the subsystem of the innermost frame of cloudflared, where the goroutine is blocked, that
+// belongs to one.
+func subsystemOf(frames []string) string {
+	for _, frame := range frames {
+		function, _, _ := strings.Cut(frame, " ")
+		if !strings.HasPrefix(function, modulePrefix) {
+			continue
+		}
+		function = strings.TrimPrefix(function, modulePrefix)
+		// The package ends at the first dot after the last slash, e.g. quic/v3.(*datagramConn).Serve
+		pkg := function
+		if i := strings.Index(function[strings.LastIndex(function, "/")+1:], "."); i >= 0 {
+			pkg = function[:strings.LastIndex(function, "/")+1+i]
+		}
+		if pkg == "connection" || pkg == "quic" || pkg == "ingress" {
+			// The datagram muxers and the ICMP proxy live next to the streams and the origins
+			name := strings.ToLower(function)
+			if strings.Contains(name, "datagram") || strings.Contains(name, "icmp") {
+				return SubsystemDatagram
+			}
+		}
+		for _, mapping := range packageSubsystems {
+			if pkg == mapping.pkg || strings.HasPrefix(pkg, mapping.pkg+"/") {
+				return mapping.subsystem
+			}
+		}
+	}
+	return SubsystemOther
+}
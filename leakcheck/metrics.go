@@ -0,0 +1,57 @@
+package leakcheck
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	goroutines = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "cloudflared",
+			Subsystem: "leakcheck",
+			Name:      "goroutines",
+			Help:      "Goroutines at the last leak check, by subsystem",
+		},
+		[]string{"subsystem"},
+	)
+	resourceExpected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "cloudflared",
+			Subsystem: "leakcheck",
+			Name:      "expected",
+			Help:      "Resources a subsystem should hold at the last leak check",
+		},
+		[]string{"subsystem", "resource"},
+	)
+	resourceActual = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "cloudflared",
+			Subsystem: "leakcheck",
+			Name:      "actual",
+			Help:      "Resources a subsystem held at the last leak check",
+		},
+		[]string{"subsystem", "resource"},
+	)
+	resourceDelta = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "cloudflared",
+			Subsystem: "leakcheck",
+			Name:      "delta",
+			Help:      "Resources a subsystem held beyond what it should at the last leak check",
+		},
+		[]string{"subsystem", "resource"},
+	)
+	suspiciousGrowths = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "cloudflared",
+			Subsystem: "leakcheck",
+			Name:      "suspicious_growths_total",
+			Help:      "Count of the goroutines or resources of a subsystem found growing while its load didn't",
+		},
+		[]string{"subsystem", "resource"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(goroutines, resourceExpected, resourceActual, resourceDelta, suspiciousGrowths)
+}
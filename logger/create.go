@@ -55,7 +55,7 @@ func fallbackLogger(err error) *zerolog.Logger {
 // writer's errors. E.g., when running as a Windows service, the console writer fails, but we don't want to
 // allow that to prevent all logging to fail due to breaking the for loop upon an error.
 type resilientMultiWriter struct {
-	level            zerolog.Level
+	level            *Level
 	writers          []io.Writer
 	managementWriter zerolog.LevelWriter
 }
@@ -71,9 +71,9 @@ func (t resilientMultiWriter) Write(p []byte) (n int, err error) {
 }
 
 func (t resilientMultiWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
-	// Only write the event to normal writers if it exceeds the level, but always write to the
+	// Only write the event to normal writers if it exceeds the level and isn't sampled out, but always write to the
 	// management logger and let it decided with the provided level of the log event.
-	if t.level <= level {
+	if t.level.Get() <= level && sampling.sample(level, p) {
 		for _, w := range t.writers {
 			_, _ = w.Write(p)
 		}
@@ -87,6 +87,12 @@ func (t resilientMultiWriter) WriteLevel(level zerolog.Level, p []byte) (n int,
 var levelErrorLogged = false
 
 func newZerolog(loggerConfig *Config) *zerolog.Logger {
+	log, _ := newZerologWithLevel(loggerConfig)
+	return log
+}
+
+// newZerologWithLevel also returns the level of the logger, to change it at runtime. Fallback loggers have none.
+func newZerologWithLevel(loggerConfig *Config) (*zerolog.Logger, *Level) {
 	var writers []io.Writer
 
 	if loggerConfig.ConsoleConfig != nil {
@@ -96,7 +102,7 @@ func newZerolog(loggerConfig *Config) *zerolog.Logger {
 	if loggerConfig.FileConfig != nil {
 		fileLogger, err := createFileWriter(*loggerConfig.FileConfig)
 		if err != nil {
-			return fallbackLogger(err)
+			return fallbackLogger(err), nil
 		}
 
 		writers = append(writers, fileLogger)
@@ -105,7 +111,7 @@ func newZerolog(loggerConfig *Config) *zerolog.Logger {
 	if loggerConfig.RollingConfig != nil {
 		rollingLogger, err := createRollingLogger(*loggerConfig.RollingConfig)
 		if err != nil {
-			return fallbackLogger(err)
+			return fallbackLogger(err), nil
 		}
 
 		writers = append(writers, rollingLogger)
@@ -118,14 +124,15 @@ func newZerolog(loggerConfig *Config) *zerolog.Logger {
 		level = zerolog.InfoLevel
 	}
 
-	multi := resilientMultiWriter{level, writers, managementWriter}
+	minLevel := NewLevel(level)
+	multi := resilientMultiWriter{minLevel, writers, managementWriter}
 	log := zerolog.New(multi).With().Timestamp().Logger()
 	if !levelErrorLogged && levelErr != nil {
 		log.Error().Msgf("Failed to parse log level %q, using %q instead", loggerConfig.MinLevel, level)
 		levelErrorLogged = true
 	}
 
-	return &log
+	return &log, minLevel
 }
 
 func CreateTransportLoggerFromContext(c *cli.Context, disableTerminal bool) *zerolog.Logger {
@@ -168,7 +175,10 @@ func createFromContext(
 		logFile,
 	)
 
-	log := newZerolog(loggerConfig)
+	log, level := newZerologWithLevel(loggerConfig)
+	if level != nil {
+		registerLevel(logLevelFlagName, level)
+	}
 	if incompatibleFlagsSet := logFile != "" && logDirectory != ""; incompatibleFlagsSet {
 		log.Error().Msgf("Your config includes values for both %s (%s) and %s (%s), but they are incompatible. %s takes precedence.", cfdflags.LogFile, logFile, logDirectoryFlagName, logDirectory, cfdflags.LogFile)
 	}
@@ -82,7 +82,7 @@ func TestResilientMultiWriter_Errors(t *testing.T) {
 			for _, w := range test.writers {
 				writers = append(writers, w)
 			}
-			multiWriter := resilientMultiWriter{zerolog.InfoLevel, writers, nil}
+			multiWriter := resilientMultiWriter{NewLevel(zerolog.InfoLevel), writers, nil}
 
 			logger := zerolog.New(multiWriter).With().Timestamp().Logger()
 			logger.Info().Msg("Test msg")
@@ -120,7 +120,7 @@ func TestResilientMultiWriter_Management(t *testing.T) {
 	} {
 		t.Run(level.String(), func(t *testing.T) {
 			managementWriter := mockedManagementWriter{}
-			multiWriter := resilientMultiWriter{level, []io.Writer{&mockedWriter{}}, &managementWriter}
+			multiWriter := resilientMultiWriter{NewLevel(level), []io.Writer{&mockedWriter{}}, &managementWriter}
 
 			logger := zerolog.New(multiWriter).With().Timestamp().Logger()
 			logger.Info().Msg("Test msg")
@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+
+	cfdflags "github.com/cloudflare/cloudflared/cmd/cloudflared/flags"
+)
+
+const (
+	// MainLogger and TransportLogger name the loggers whose level can be changed at runtime, after the flags their
+	// level is read from.
+	MainLogger      = cfdflags.LogLevel
+	TransportLogger = cfdflags.TransportLogLevel
+)
+
+// Level is the minimum level of the events written by a logger, which can be changed while it's used.
+type Level struct {
+	level atomic.Int32
+}
+
+func NewLevel(level zerolog.Level) *Level {
+	l := &Level{}
+	l.Set(level)
+	return l
+}
+
+func (l *Level) Get() zerolog.Level {
+	return zerolog.Level(l.level.Load())
+}
+
+func (l *Level) Set(level zerolog.Level) {
+	l.level.Store(int32(level))
+}
+
+var (
+	levelsLock sync.Mutex
+	// levels holds the levels of the loggers created from the context, by the flag their level is read from.
+	levels = make(map[string][]*Level)
+)
+
+func registerLevel(logger string, level *Level) {
+	levelsLock.Lock()
+	defer levelsLock.Unlock()
+	levels[logger] = append(levels[logger], level)
+}
+
+// SetLevel changes the level of the loggers created from the context with the level of the flag logger, e.g.
+// MainLogger.
+func SetLevel(logger string, level zerolog.Level) error {
+	levelsLock.Lock()
+	defer levelsLock.Unlock()
+	loggerLevels, ok := levels[logger]
+	if !ok {
+		return fmt.Errorf("no %s logger was created", logger)
+	}
+	for _, l := range loggerLevels {
+		l.Set(level)
+	}
+	return nil
+}
+
+// GetLevel returns the level of the loggers with the level of the flag logger.
+func GetLevel(logger string) (zerolog.Level, error) {
+	levelsLock.Lock()
+	defer levelsLock.Unlock()
+	loggerLevels, ok := levels[logger]
+	if !ok {
+		return zerolog.NoLevel, fmt.Errorf("no %s logger was created", logger)
+	}
+	return loggerLevels[len(loggerLevels)-1].Get(), nil
+}
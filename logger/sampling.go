@@ -0,0 +1,91 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/management"
+)
+
+// eventTypeField prefixes the module of a log event in the JSON written by zerolog.
+var eventTypeField = []byte(`"` + management.EventTypeKey + `":`)
+
+const numModules = int(management.UDP) + 1
+
+// modules are the modules whose logs can be sampled, by the management event type their events are tagged with.
+var modules = []management.LogEventType{management.Cloudflared, management.HTTP, management.TCP, management.UDP}
+
+// moduleSampler logs one of every rate events of a module. Warnings and errors are never sampled, since sampling is
+// meant to quiet the debug and info logs of busy modules.
+type moduleSampler struct {
+	enabled  atomic.Bool
+	rates    [numModules]atomic.Uint32
+	counters [numModules]atomic.Uint32
+}
+
+// sampling is shared by all the loggers, since the events of a module are written by several of them.
+var sampling moduleSampler
+
+// SetSamplingRate logs one of every rate debug and info events of module, e.g. "http". A rate of 0 or 1 logs all of
+// them.
+func SetSamplingRate(module string, rate uint32) error {
+	eventType, err := parseModule(module)
+	if err != nil {
+		return err
+	}
+	sampling.rates[eventType].Store(rate)
+	sampling.counters[eventType].Store(0)
+	enabled := false
+	for _, m := range modules {
+		enabled = enabled || sampling.rates[m].Load() > 1
+	}
+	sampling.enabled.Store(enabled)
+	return nil
+}
+
+func parseModule(module string) (management.LogEventType, error) {
+	eventType, ok := management.ParseLogEventType(module)
+	if !ok {
+		return 0, fmt.Errorf("unknown log module %q", module)
+	}
+	return eventType, nil
+}
+
+// SamplingRates returns the sampling rate of each module.
+func SamplingRates() map[string]uint32 {
+	rates := make(map[string]uint32, len(modules))
+	for _, m := range modules {
+		rates[m.String()] = max(sampling.rates[m].Load(), 1)
+	}
+	return rates
+}
+
+// sample returns whether the event p should be written.
+func (s *moduleSampler) sample(level zerolog.Level, p []byte) bool {
+	if !s.enabled.Load() || level >= zerolog.WarnLevel {
+		return true
+	}
+	module := eventModule(p)
+	rate := s.rates[module].Load()
+	if rate <= 1 {
+		return true
+	}
+	return s.counters[module].Add(1)%rate == 1
+}
+
+// eventModule finds the module of a JSON log event without decoding it. Events without module belong to
+// cloudflared.
+func eventModule(p []byte) management.LogEventType {
+	i := bytes.Index(p, eventTypeField)
+	if i < 0 || i+len(eventTypeField) >= len(p) {
+		return management.Cloudflared
+	}
+	module := management.LogEventType(p[i+len(eventTypeField)] - '0')
+	if module < management.Cloudflared || module > management.UDP {
+		return management.Cloudflared
+	}
+	return module
+}
@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/management"
+)
+
+func TestSampling(t *testing.T) {
+	writer := &mockedWriter{}
+	multi := resilientMultiWriter{level: NewLevel(zerolog.DebugLevel), writers: []io.Writer{writer}}
+	log := zerolog.New(multi)
+	require.NoError(t, SetSamplingRate("http", 3))
+	t.Cleanup(func() { _ = SetSamplingRate("http", 0) })
+
+	for range 9 {
+		log.Debug().Int(management.EventTypeKey, int(management.HTTP)).Msg("request")
+	}
+	assert.Equal(t, 3, writer.writeCalls)
+
+	// Warnings and the events of other modules aren't sampled
+	writer.writeCalls = 0
+	for range 3 {
+		log.Warn().Int(management.EventTypeKey, int(management.HTTP)).Msg("request failed")
+		log.Info().Int(management.EventTypeKey, int(management.TCP)).Msg("stream")
+		log.Info().Msg("connection")
+	}
+	assert.Equal(t, 9, writer.writeCalls)
+
+	require.NoError(t, SetSamplingRate("http", 0))
+	writer.writeCalls = 0
+	for range 3 {
+		log.Debug().Int(management.EventTypeKey, int(management.HTTP)).Msg("request")
+	}
+	assert.Equal(t, 3, writer.writeCalls)
+
+	assert.Error(t, SetSamplingRate("dns", 2))
+}
+
+func TestSettingsHandler(t *testing.T) {
+	main := NewLevel(zerolog.InfoLevel)
+	registerLevel(MainLogger, main)
+	t.Cleanup(func() {
+		levelsLock.Lock()
+		defer levelsLock.Unlock()
+		delete(levels, MainLogger)
+		_ = SetSamplingRate("udp", 0)
+	})
+	log := zerolog.Nop()
+	handler := SettingsHandler(&log)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"level": "debug", "sampling": {"udp": 5}}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, zerolog.DebugLevel, main.Get())
+	assert.Equal(t, uint32(5), SamplingRates()["udp"])
+
+	// Settings are only changed once they are all valid
+	for _, body := range []string{
+		`{"level": "trace", "sampling": {"dns": 5}}`,
+		`{"level": "trace", "transportLevel": "debug"}`,
+		`{"level": "loud"}`,
+		`{}`,
+	} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+	assert.Equal(t, zerolog.DebugLevel, main.Get())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var settings Settings
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&settings))
+	assert.Equal(t, "debug", settings.Level)
+	assert.Empty(t, settings.TransportLevel)
+	assert.Equal(t, uint32(5), settings.Sampling["udp"])
+	assert.Equal(t, uint32(1), settings.Sampling["http"])
+}
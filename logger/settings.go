@@ -0,0 +1,97 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog"
+)
+
+// Settings are the log settings that can be changed at runtime. Settings left empty are not changed.
+type Settings struct {
+	// Level is the level of the main logger.
+	Level string `json:"level,omitempty"`
+	// TransportLevel is the level of the transport logger, e.g. of the QUIC connections.
+	TransportLevel string `json:"transportLevel,omitempty"`
+	// Sampling is the sampling rate of modules, e.g. "http": 10 logs one of every 10 debug and info events of the
+	// proxied HTTP requests.
+	Sampling map[string]uint32 `json:"sampling,omitempty"`
+}
+
+// CurrentSettings returns the current levels and sampling rates of the loggers.
+func CurrentSettings() Settings {
+	settings := Settings{Sampling: SamplingRates()}
+	if level, err := GetLevel(MainLogger); err == nil {
+		settings.Level = level.String()
+	}
+	if level, err := GetLevel(TransportLogger); err == nil {
+		settings.TransportLevel = level.String()
+	}
+	return settings
+}
+
+// Apply changes the settings that are set, once they are all validated.
+func (s Settings) Apply() error {
+	if s.Level == "" && s.TransportLevel == "" && len(s.Sampling) == 0 {
+		return errors.New("no log setting to change")
+	}
+	var changes []func() error
+	for logger, level := range map[string]string{MainLogger: s.Level, TransportLogger: s.TransportLevel} {
+		if level == "" {
+			continue
+		}
+		parsed, err := zerolog.ParseLevel(level)
+		if err != nil {
+			return fmt.Errorf("invalid log level %q", level)
+		}
+		if _, err := GetLevel(logger); err != nil {
+			return err
+		}
+		changes = append(changes, func() error { return SetLevel(logger, parsed) })
+	}
+	for module, rate := range s.Sampling {
+		if _, err := parseModule(module); err != nil {
+			return err
+		}
+		changes = append(changes, func() error { return SetSamplingRate(module, rate) })
+	}
+	for _, change := range changes {
+		if err := change(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SettingsHandler serves the log settings: GET returns them, and PUT changes the settings of its JSON body.
+func SettingsHandler(log *zerolog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var settings Settings
+			if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+				writeSettingsError(w, err)
+				return
+			}
+			if err := settings.Apply(); err != nil {
+				writeSettingsError(w, err)
+				return
+			}
+			log.Info().Interface("settings", settings).Msg("Log settings changed")
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(CurrentSettings())
+	})
+}
+
+func writeSettingsError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -55,6 +55,13 @@ type StreamingFilters struct {
 	Events   []LogEventType `json:"events,omitempty"`
 	Level    *LogLevel      `json:"level,omitempty"`
 	Sampling float64        `json:"sampling,omitempty"`
+	// Hostnames keeps the events of the requests to these hostnames
+	Hostnames []string `json:"hostnames,omitempty"`
+	// Rules keeps the events of the requests matching these ingress rules, by index
+	Rules []int `json:"rules,omitempty"`
+	// MinStatus keeps the events of the responses with a status code of at least this. Events without a status code,
+	// such as request errors, are kept.
+	MinStatus int `json:"min_status,omitempty"`
 }
 
 // EventStopStreaming signifies that the client wishes to halt receiving log events.
@@ -195,6 +202,11 @@ const (
 	EventTypeKey = "event"
 	// FieldsKey is a custom JSON key to match and store every other key for a zerolog event
 	FieldsKey = "fields"
+	// HostKey, RuleKey and StatusKey are the fields of the HTTP events the hostname, ingress rule and status code
+	// filters apply to
+	HostKey   = "host"
+	RuleKey   = "ingressRule"
+	StatusKey = "status"
 )
 
 // Log is the basic structure of the events that are sent to the client.
@@ -16,6 +16,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"nhooyr.io/websocket"
+
+	"github.com/cloudflare/cloudflared/accesslog"
 )
 
 const (
@@ -62,6 +64,8 @@ type ManagementService struct {
 	// to validate this before setting streaming to true.
 	streamingMut sync.Mutex
 	logger       LoggerListener
+
+	enableDiagServices bool
 }
 
 func New(managementHostname string,
@@ -80,6 +84,8 @@ func New(managementHostname string,
 		clientID:       clientID,
 		label:          label,
 		metricsHandler: promhttp.Handler(),
+
+		enableDiagServices: enableDiagServices,
 	}
 	r := chi.NewRouter()
 	r.Use(ValidateAccessTokenQueryMiddleware)
@@ -102,6 +108,38 @@ func New(managementHostname string,
 	return s
 }
 
+// ServeAccessLogs exposes the last access log entries held by ring at /access_logs.
+func (m *ManagementService) ServeAccessLogs(ring *accesslog.Ring) {
+	m.router.With(corsHandler).Get("/access_logs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		_ = json.NewEncoder(w).Encode(ring.Entries())
+	})
+}
+
+// ServeLogSettings exposes the log levels and sampling rates served by handler at /log_level, to read them with GET
+// and change them with PUT.
+func (m *ManagementService) ServeLogSettings(handler http.Handler) {
+	m.router.With(corsHandler).Get("/log_level", handler.ServeHTTP)
+	m.router.With(corsHandler).Put("/log_level", handler.ServeHTTP)
+}
+
+// ServeMaintenance exposes the maintenance toggles of the ingress rules served by handler at /maintenance, to read
+// them with GET and change them with PUT.
+func (m *ManagementService) ServeMaintenance(handler http.Handler) {
+	m.router.With(corsHandler).Get("/maintenance", handler.ServeHTTP)
+	m.router.With(corsHandler).Put("/maintenance", handler.ServeHTTP)
+}
+
+// ServeDiagnostics exposes the diagnostics bundle served by handler at /diagnostics. Since the bundle holds profiles
+// like /debug/pprof, it is only exposed when the diagnostic services are enabled.
+func (m *ManagementService) ServeDiagnostics(handler http.Handler) {
+	if !m.enableDiagServices {
+		return
+	}
+	m.router.With(corsHandler).Get("/diagnostics", handler.ServeHTTP)
+}
+
 func (m *ManagementService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	m.router.ServeHTTP(w, r)
 }
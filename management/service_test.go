@@ -25,7 +25,8 @@ var (
 
 func TestDisableDiagnosticRoutes(t *testing.T) {
 	mgmt := New("management.argotunnel.com", false, "1.1.1.1:80", uuid.Nil, "", &noopLogger, nil)
-	for _, path := range []string{"/metrics", "/debug/pprof/goroutine", "/debug/pprof/heap"} {
+	mgmt.ServeDiagnostics(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }))
+	for _, path := range []string{"/metrics", "/debug/pprof/goroutine", "/debug/pprof/heap", "/diagnostics"} {
 		t.Run(strings.Replace(path, "/", "_", -1), func(t *testing.T) {
 			req := httptest.NewRequest("GET", managementHostname+path+"?access_token="+validToken, nil)
 			recorder := httptest.NewRecorder()
@@ -3,6 +3,9 @@ package management
 import (
 	"context"
 	"math/rand"
+	"net"
+	"slices"
+	"strings"
 	"sync/atomic"
 )
 
@@ -78,6 +81,17 @@ func (s *session) Insert(log *Log) {
 	if len(s.filters.Events) != 0 && !contains(s.filters.Events, log.Event) {
 		return
 	}
+	if len(s.filters.Hostnames) != 0 && !matchesHostname(s.filters.Hostnames, log.Fields[HostKey]) {
+		return
+	}
+	if len(s.filters.Rules) != 0 && !matchesRule(s.filters.Rules, log.Fields[RuleKey]) {
+		return
+	}
+	if s.filters.MinStatus != 0 {
+		if status, ok := log.Fields[StatusKey].(float64); ok && int(status) < s.filters.MinStatus {
+			return
+		}
+	}
 	// Sampling is also optional
 	if s.sampler != nil && !s.sampler.Sample() {
 		return
@@ -108,6 +122,32 @@ func contains(array []LogEventType, t LogEventType) bool {
 	return false
 }
 
+// matchesHostname returns whether the host field of an event is one of hostnames, ignoring its port.
+func matchesHostname(hostnames []string, field interface{}) bool {
+	host, ok := field.(string)
+	if !ok {
+		return false
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, hostname := range hostnames {
+		if strings.EqualFold(hostname, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesRule returns whether the ingress rule field of an event, a JSON number, is one of rules.
+func matchesRule(rules []int, field interface{}) bool {
+	rule, ok := field.(float64)
+	if !ok {
+		return false
+	}
+	return slices.Contains(rules, int(rule))
+}
+
 // sampler will send approximately every p percentage log events out of 100.
 type sampler struct {
 	p int
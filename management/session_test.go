@@ -33,6 +33,7 @@ func TestSession_Insert(t *testing.T) {
 	for _, test := range []struct {
 		name      string
 		filters   StreamingFilters
+		fields    map[string]interface{}
 		expectLog bool
 	}{
 		{
@@ -96,6 +97,52 @@ func TestSession_Insert(t *testing.T) {
 			},
 			expectLog: true,
 		},
+		{
+			name:      "hostname",
+			filters:   StreamingFilters{Hostnames: []string{"api.example.com"}},
+			fields:    map[string]interface{}{HostKey: "API.example.com:443"},
+			expectLog: true,
+		},
+		{
+			name:      "filtered out hostname",
+			filters:   StreamingFilters{Hostnames: []string{"api.example.com"}},
+			fields:    map[string]interface{}{HostKey: "www.example.com"},
+			expectLog: false,
+		},
+		{
+			name:      "filtered out event without hostname",
+			filters:   StreamingFilters{Hostnames: []string{"api.example.com"}},
+			expectLog: false,
+		},
+		{
+			name:      "rule",
+			filters:   StreamingFilters{Rules: []int{0, 2}},
+			fields:    map[string]interface{}{RuleKey: float64(2)},
+			expectLog: true,
+		},
+		{
+			name:      "filtered out rule",
+			filters:   StreamingFilters{Rules: []int{0, 2}},
+			fields:    map[string]interface{}{RuleKey: float64(1)},
+			expectLog: false,
+		},
+		{
+			name:      "min status",
+			filters:   StreamingFilters{MinStatus: 500},
+			fields:    map[string]interface{}{StatusKey: float64(502)},
+			expectLog: true,
+		},
+		{
+			name:      "filtered out status",
+			filters:   StreamingFilters{MinStatus: 500},
+			fields:    map[string]interface{}{StatusKey: float64(200)},
+			expectLog: false,
+		},
+		{
+			name:      "min status without status",
+			filters:   StreamingFilters{MinStatus: 500},
+			expectLog: true,
+		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			session := newSession(4, actor{}, cancel)
@@ -105,6 +152,7 @@ func TestSession_Insert(t *testing.T) {
 				Event:   HTTP,
 				Level:   Info,
 				Message: "test",
+				Fields:  test.fields,
 			}
 			session.Insert(&log)
 			select {
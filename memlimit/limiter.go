@@ -0,0 +1,28 @@
+package memlimit
+
+import (
+	"fmt"
+
+	cfdflow "github.com/cloudflare/cloudflared/flow"
+	"github.com/cloudflare/cloudflared/management"
+)
+
+// ErrMemoryLimit wraps cfdflow.ErrTooManyActiveFlows so that the edge is told the flow was rate limited.
+var ErrMemoryLimit = fmt.Errorf("memory soft limit of the connector exceeded: %w", cfdflow.ErrTooManyActiveFlows)
+
+type sheddingLimiter struct {
+	cfdflow.Limiter
+	monitor *Monitor
+}
+
+// NewLimiter returns a flow limiter also rejecting new UDP sessions while monitor is degraded.
+func NewLimiter(limiter cfdflow.Limiter, monitor *Monitor) cfdflow.Limiter {
+	return &sheddingLimiter{Limiter: limiter, monitor: monitor}
+}
+
+func (l *sheddingLimiter) Acquire(flowType string) error {
+	if flowType == management.UDP.String() && l.monitor.Shed(KindUDPSession) {
+		return ErrMemoryLimit
+	}
+	return l.Limiter.Acquire(flowType)
+}
@@ -0,0 +1,45 @@
+package memlimit
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	softLimit = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "cloudflared",
+			Subsystem: "memory",
+			Name:      "soft_limit_bytes",
+			Help:      "Memory above which the connector sheds load",
+		},
+	)
+	memoryUsed = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "cloudflared",
+			Subsystem: "memory",
+			Name:      "used_bytes",
+			Help:      "Memory used by the Go runtime, as checked against the soft limit",
+		},
+	)
+	degradedGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "cloudflared",
+			Subsystem: "memory",
+			Name:      "degraded",
+			Help:      "Whether the connector is degraded, shedding load because the memory used exceeds the soft limit",
+		},
+	)
+	shedLoad = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "cloudflared",
+			Subsystem: "memory",
+			Name:      "shed_total",
+			Help:      "Count of the UDP sessions and requests rejected because the memory used exceeds the soft limit, by kind",
+		},
+		[]string{"kind"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(softLimit, memoryUsed, degradedGauge, shedLoad)
+}
@@ -0,0 +1,140 @@
+// Package memlimit keeps the memory used by cloudflared under a soft limit, by shedding load while it's exceeded
+// instead of letting the connector be killed for running out of memory, which takes all its services down at once.
+package memlimit
+
+import (
+	"context"
+	"os"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/cfio"
+)
+
+const (
+	defaultCheckInterval = time.Second
+	// resumeRatio is the share of the soft limit the memory must drop below for the load to stop being shed, so that
+	// the connector doesn't flap around the limit.
+	resumeRatio = 0.9
+
+	// Kinds of load shed
+	KindUDPSession = "udp_session"
+	KindRequest    = "request"
+
+	metricTotalMemory    = "/memory/classes/total:bytes"
+	metricReleasedMemory = "/memory/classes/heap/released:bytes"
+)
+
+// Config configures a Monitor.
+type Config struct {
+	// SoftLimit is the memory, in bytes, above which load is shed
+	SoftLimit uint64
+	// CheckInterval is how often the memory used is checked. It defaults to a second.
+	CheckInterval time.Duration
+}
+
+// Monitor checks the memory used by the Go runtime against the soft limit. While it's exceeded the connector is
+// degraded: new UDP sessions are rejected, the requests of the low priority ingress rules are answered with 503, and
+// the buffer pools stop holding on to their buffers.
+type Monitor struct {
+	limit    uint64
+	resume   uint64
+	interval time.Duration
+	read     func() uint64
+	log      *zerolog.Logger
+
+	degraded atomic.Bool
+}
+
+func New(config Config, log *zerolog.Logger) *Monitor {
+	interval := config.CheckInterval
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+	return &Monitor{
+		limit:    config.SoftLimit,
+		resume:   uint64(float64(config.SoftLimit) * resumeRatio),
+		interval: interval,
+		read:     readMemoryUsed,
+		log:      log,
+	}
+}
+
+// Degraded returns whether the memory used exceeds the soft limit. A nil Monitor is never degraded.
+func (m *Monitor) Degraded() bool {
+	if m == nil {
+		return false
+	}
+	return m.degraded.Load()
+}
+
+// Shed returns whether load of kind must be shed because the connector is degraded, counting it if so.
+func (m *Monitor) Shed(kind string) bool {
+	if !m.Degraded() {
+		return false
+	}
+	shedLoad.WithLabelValues(kind).Inc()
+	return true
+}
+
+// Run checks the memory used periodically until ctx is done. Unless GOMEMLIMIT is set, the soft limit is also set as
+// the memory limit of the Go runtime, so that the garbage collector works harder as the memory used gets close to it.
+func (m *Monitor) Run(ctx context.Context) {
+	if os.Getenv("GOMEMLIMIT") == "" && m.limit > 0 {
+		debug.SetMemoryLimit(int64(m.limit))
+	}
+	softLimit.Set(float64(m.limit))
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+func (m *Monitor) check() {
+	used := m.read()
+	memoryUsed.Set(float64(used))
+	switch {
+	case !m.degraded.Load() && used >= m.limit:
+		m.degraded.Store(true)
+		degradedGauge.Set(1)
+		m.log.Warn().Uint64("used", used).Uint64("softLimit", m.limit).
+			Msg("The memory used exceeds the soft limit, shedding load until it drops")
+		cfio.SetPooling(false)
+		// Give back to the OS what the garbage collector can free, the pooled buffers included
+		debug.FreeOSMemory()
+	case m.degraded.Load() && used < m.resume:
+		m.degraded.Store(false)
+		degradedGauge.Set(0)
+		cfio.SetPooling(true)
+		m.log.Info().Uint64("used", used).Uint64("softLimit", m.limit).
+			Msg("The memory used dropped below the soft limit, load isn't shed anymore")
+	}
+}
+
+// readMemoryUsed returns the memory mapped by the Go runtime and not released to the OS, which is what the memory
+// limit of the runtime applies to.
+func readMemoryUsed() uint64 {
+	samples := []metrics.Sample{{Name: metricTotalMemory}, {Name: metricReleasedMemory}}
+	metrics.Read(samples)
+	var total, released uint64
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		total = samples[0].Value.Uint64()
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		released = samples[1].Value.Uint64()
+	}
+	if released > total {
+		return 0
+	}
+	return total - released
+}
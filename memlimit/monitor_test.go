@@ -0,0 +1,70 @@
+package memlimit
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfdflow "github.com/cloudflare/cloudflared/flow"
+	"github.com/cloudflare/cloudflared/management"
+)
+
+func TestMonitorDegraded(t *testing.T) {
+	log := zerolog.Nop()
+	monitor := New(Config{SoftLimit: 1000}, &log)
+	var used uint64
+	monitor.read = func() uint64 { return used }
+	t.Cleanup(func() {
+		used = 0
+		monitor.check()
+	})
+
+	used = 999
+	monitor.check()
+	assert.False(t, monitor.Degraded())
+
+	used = 1000
+	monitor.check()
+	assert.True(t, monitor.Degraded())
+
+	// The load is shed until the memory used drops below the resume threshold
+	used = 950
+	monitor.check()
+	assert.True(t, monitor.Degraded())
+	used = 899
+	monitor.check()
+	assert.False(t, monitor.Degraded())
+
+	var nilMonitor *Monitor
+	assert.False(t, nilMonitor.Degraded())
+	assert.False(t, nilMonitor.Shed(KindRequest))
+}
+
+func TestLimiterShedsUDPSessions(t *testing.T) {
+	log := zerolog.Nop()
+	monitor := New(Config{SoftLimit: 1000}, &log)
+	var used uint64 = 2000
+	monitor.read = func() uint64 { return used }
+	monitor.check()
+	t.Cleanup(func() {
+		used = 0
+		monitor.check()
+	})
+
+	limiter := NewLimiter(cfdflow.NewLimiter(0), monitor)
+	err := limiter.Acquire(management.UDP.String())
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, cfdflow.ErrTooManyActiveFlows))
+	assert.NoError(t, limiter.Acquire(management.TCP.String()))
+
+	used = 0
+	monitor.check()
+	assert.NoError(t, limiter.Acquire(management.UDP.String()))
+}
+
+func TestReadMemoryUsed(t *testing.T) {
+	assert.NotZero(t, readMemoryUsed())
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	_ "expvar" // serves /debug/vars
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// DebugEndpoints serves pprof, expvar, the request traces and the leak check report under /debug/ of the metrics
+// server. When a token or client certificates are required, only the requests authenticated with either reach them,
+// so that live profiles can be taken from production connectors. They can be enabled and disabled at runtime.
+type DebugEndpoints struct {
+	// token is the bearer token authenticating the requests, if any
+	token string
+	// clientCerts requires the requests to be authenticated by a client certificate verified by the TLS listener
+	clientCerts bool
+	enabled     atomic.Bool
+	handler     http.Handler
+}
+
+// NewDebugEndpoints returns the debug endpoints, requiring token as bearer token if set, or a verified client
+// certificate if clientCerts is true. Without either the endpoints aren't authenticated, as the metrics server is
+// usually only reachable from the host.
+func NewDebugEndpoints(token string, clientCerts bool, enabled bool) *DebugEndpoints {
+	d := &DebugEndpoints{
+		token:       token,
+		clientCerts: clientCerts,
+		// net/http/pprof, expvar, x/net/trace and leakcheck register their handlers to the default mux
+		handler: http.DefaultServeMux,
+	}
+	d.enabled.Store(enabled)
+	return d
+}
+
+// Enabled returns whether the endpoints are served.
+func (d *DebugEndpoints) Enabled() bool {
+	return d.enabled.Load()
+}
+
+// SetEnabled enables or disables the endpoints.
+func (d *DebugEndpoints) SetEnabled(enabled bool) {
+	d.enabled.Store(enabled)
+}
+
+func (d *DebugEndpoints) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.Guard(d.handler).ServeHTTP(w, r)
+}
+
+// Guard returns handler served like the debug endpoints: only while they're enabled, and to the authenticated
+// requests. It protects the other endpoints exposing profiles or the configuration, e.g. the diagnostics bundle.
+func (d *DebugEndpoints) Guard(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.Enabled() {
+			http.NotFound(w, r)
+			return
+		}
+		if !d.authenticated(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cloudflared"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (d *DebugEndpoints) authenticated(r *http.Request) bool {
+	if d.token == "" && !d.clientCerts {
+		return true
+	}
+	if d.clientCerts && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	if d.token == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(d.token)) == 1
+}
@@ -0,0 +1,73 @@
+package metrics_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudflare/cloudflared/metrics"
+)
+
+func serveDebug(debug *metrics.DebugEndpoints, configure func(*http.Request)) int {
+	req := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+	if configure != nil {
+		configure(req)
+	}
+	w := httptest.NewRecorder()
+	debug.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestDebugEndpointsToken(t *testing.T) {
+	debug := metrics.NewDebugEndpoints("s3cr3t", false, true)
+	assert.Equal(t, http.StatusUnauthorized, serveDebug(debug, nil))
+	assert.Equal(t, http.StatusUnauthorized, serveDebug(debug, func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer wrong")
+	}))
+	assert.Equal(t, http.StatusOK, serveDebug(debug, func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer s3cr3t")
+	}))
+
+	debug.SetEnabled(false)
+	assert.Equal(t, http.StatusNotFound, serveDebug(debug, func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer s3cr3t")
+	}))
+}
+
+func TestDebugEndpointsClientCertificate(t *testing.T) {
+	debug := metrics.NewDebugEndpoints("", true, true)
+	assert.Equal(t, http.StatusUnauthorized, serveDebug(debug, nil))
+	assert.Equal(t, http.StatusOK, serveDebug(debug, func(r *http.Request) {
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	}))
+}
+
+func TestDebugEndpointsWithoutAuthentication(t *testing.T) {
+	debug := metrics.NewDebugEndpoints("", false, true)
+	assert.Equal(t, http.StatusOK, serveDebug(debug, nil))
+}
+
+func TestDebugEndpointsGuard(t *testing.T) {
+	debug := metrics.NewDebugEndpoints("s3cr3t", false, true)
+	bundle := debug.Guard(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/diag/bundle", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		bundle.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, serve(""))
+	assert.Equal(t, http.StatusOK, serve("s3cr3t"))
+
+	debug.SetEnabled(false)
+	assert.Equal(t, http.StatusNotFound, serve("s3cr3t"))
+}
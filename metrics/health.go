@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cloudflare/cloudflared/tunnelstate"
+)
+
+// HealthCriteria configures when the connector is reported healthy by /healthz and ready by /readyz.
+type HealthCriteria struct {
+	// MinConnections is the number of connections to the edge the connector needs to be ready. Defaults to 1.
+	MinConnections uint
+	// MaxTimeSinceConnected is how long the connector can have less than MinConnections since a connection was
+	// last registered before /healthz fails, e.g. for Kubernetes to restart it. 0 never fails /healthz.
+	MaxTimeSinceConnected time.Duration
+	// RequireRemoteConfig makes /readyz wait for the configuration of remotely managed tunnels to be applied.
+	RequireRemoteConfig bool
+}
+
+// HealthServer serves /healthz and /readyz, for Kubernetes liveness and readiness probes.
+type HealthServer struct {
+	criteria HealthCriteria
+	tracker  *tunnelstate.ConnTracker
+	// configVersion returns the version of the configuration applied from the edge, or -1 if none was applied.
+	configVersion func() int32
+}
+
+func NewHealthServer(criteria HealthCriteria, tracker *tunnelstate.ConnTracker, configVersion func() int32) *HealthServer {
+	if criteria.MinConnections == 0 {
+		criteria.MinConnections = 1
+	}
+	return &HealthServer{
+		criteria:      criteria,
+		tracker:       tracker,
+		configVersion: configVersion,
+	}
+}
+
+type healthBody struct {
+	Status           int    `json:"status"`
+	ReadyConnections uint   `json:"readyConnections"`
+	Reason           string `json:"reason,omitempty"`
+}
+
+// Healthz fails once the connector has been without enough connections to the edge for longer than
+// MaxTimeSinceConnected.
+func (hs *HealthServer) Healthz(w http.ResponseWriter, _ *http.Request) {
+	connections := hs.tracker.CountActiveConns()
+	reason := ""
+	if connections < hs.criteria.MinConnections && hs.criteria.MaxTimeSinceConnected > 0 {
+		if since := time.Since(hs.tracker.LastConnected()); since > hs.criteria.MaxTimeSinceConnected {
+			reason = fmt.Sprintf("%d of %d connections to the edge, and none registered for %s", connections,
+				hs.criteria.MinConnections, since.Round(time.Second))
+		}
+	}
+	writeHealth(w, connections, reason)
+}
+
+// Readyz succeeds while the connector has enough connections to the edge to serve traffic, and its configuration
+// was applied if required.
+func (hs *HealthServer) Readyz(w http.ResponseWriter, _ *http.Request) {
+	connections := hs.tracker.CountActiveConns()
+	reason := ""
+	if connections < hs.criteria.MinConnections {
+		reason = fmt.Sprintf("%d of %d connections to the edge", connections, hs.criteria.MinConnections)
+	} else if hs.criteria.RequireRemoteConfig && hs.configVersion() < 0 {
+		reason = "the configuration wasn't received from the edge yet"
+	}
+	writeHealth(w, connections, reason)
+}
+
+func writeHealth(w http.ResponseWriter, connections uint, reason string) {
+	status := http.StatusOK
+	if reason != "" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(healthBody{
+		Status:           status,
+		ReadyConnections: connections,
+		Reason:           reason,
+	})
+}
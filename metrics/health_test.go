@@ -0,0 +1,86 @@
+package metrics_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/metrics"
+	"github.com/cloudflare/cloudflared/tunnelstate"
+)
+
+func probe(t *testing.T, handler http.HandlerFunc) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	var body struct {
+		Status int    `json:"status"`
+		Reason string `json:"reason"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, rec.Code, body.Status)
+	return rec.Code, body.Reason
+}
+
+func TestHealthz(t *testing.T) {
+	log := zerolog.Nop()
+	tracker := tunnelstate.NewConnTracker(&log)
+	hs := metrics.NewHealthServer(metrics.HealthCriteria{
+		MinConnections:        2,
+		MaxTimeSinceConnected: 50 * time.Millisecond,
+	}, tracker, func() int32 { return -1 })
+
+	// Connectors are healthy while they start
+	code, _ := probe(t, hs.Healthz)
+	assert.Equal(t, http.StatusOK, code)
+	time.Sleep(100 * time.Millisecond)
+	code, reason := probe(t, hs.Healthz)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, reason, "0 of 2 connections")
+
+	tracker.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected})
+	code, _ = probe(t, hs.Healthz)
+	assert.Equal(t, http.StatusOK, code)
+
+	// Losing connections fails once no connection was registered for too long
+	time.Sleep(100 * time.Millisecond)
+	code, _ = probe(t, hs.Healthz)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	tracker.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Connected})
+	time.Sleep(100 * time.Millisecond)
+	code, _ = probe(t, hs.Healthz)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestReadyz(t *testing.T) {
+	log := zerolog.Nop()
+	tracker := tunnelstate.NewConnTracker(&log)
+	configVersion := int32(-1)
+	hs := metrics.NewHealthServer(metrics.HealthCriteria{RequireRemoteConfig: true}, tracker, func() int32 {
+		return configVersion
+	})
+
+	code, reason := probe(t, hs.Readyz)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, reason, "0 of 1 connections")
+
+	tracker.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected})
+	code, reason = probe(t, hs.Readyz)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, reason, "configuration")
+
+	configVersion = 1
+	code, _ = probe(t, hs.Readyz)
+	assert.Equal(t, http.StatusOK, code)
+
+	tracker.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Disconnected})
+	code, _ = probe(t, hs.Readyz)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// OtherLabelValue replaces the values of a label that aren't in its allowlist.
+const OtherLabelValue = "other"
+
+type labelDrop struct {
+	// families is a glob of the names of the metric families the labels are dropped from, see path.Match
+	families string
+	labels   map[string]struct{}
+}
+
+// LabelPolicy lowers the cardinality of the served metrics. It drops labels, e.g. rule or conn_index, from the metric
+// families matching a glob, and collapses the values of a label outside of its allowlist into OtherLabelValue. The
+// series left with the same labels are aggregated.
+type LabelPolicy struct {
+	drops      []labelDrop
+	allowlists map[string]map[string]struct{}
+}
+
+// ParseLabelPolicy parses the labels dropped from metric families, as "<family glob>=<label>[,<label>...]", e.g.
+// "cloudflared_tunnel_origin_*=rule", and the allowlists of label values, as "<label>=<value>[,<value>...]", e.g.
+// "rule=0,1".
+func ParseLabelPolicy(drops, allowlists []string) (*LabelPolicy, error) {
+	policy := &LabelPolicy{allowlists: make(map[string]map[string]struct{})}
+	for _, drop := range drops {
+		families, labels, err := parseLabelList(drop)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dropped labels %q: %w", drop, err)
+		}
+		if _, err := path.Match(families, ""); err != nil {
+			return nil, fmt.Errorf("invalid dropped labels %q: %w", drop, err)
+		}
+		policy.drops = append(policy.drops, labelDrop{families: families, labels: labels})
+	}
+	for _, allowlist := range allowlists {
+		label, values, err := parseLabelList(allowlist)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label allowlist %q: %w", allowlist, err)
+		}
+		if policy.allowlists[label] == nil {
+			policy.allowlists[label] = make(map[string]struct{})
+		}
+		for value := range values {
+			policy.allowlists[label][value] = struct{}{}
+		}
+	}
+	return policy, nil
+}
+
+func parseLabelList(s string) (string, map[string]struct{}, error) {
+	key, list, ok := strings.Cut(s, "=")
+	if !ok || key == "" || list == "" {
+		return "", nil, fmt.Errorf("expected <name>=<value>[,<value>...]")
+	}
+	values := make(map[string]struct{})
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values[value] = struct{}{}
+		}
+	}
+	return key, values, nil
+}
+
+// Gatherer returns a Gatherer applying the policy to the metrics of gatherer.
+func (p *LabelPolicy) Gatherer(gatherer prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := gatherer.Gather()
+		for _, family := range families {
+			p.apply(family)
+		}
+		return families, err
+	})
+}
+
+func (p *LabelPolicy) dropped(family string) map[string]struct{} {
+	dropped := make(map[string]struct{})
+	for _, drop := range p.drops {
+		if matched, _ := path.Match(drop.families, family); matched {
+			for label := range drop.labels {
+				dropped[label] = struct{}{}
+			}
+		}
+	}
+	return dropped
+}
+
+func (p *LabelPolicy) apply(family *dto.MetricFamily) {
+	dropped := p.dropped(family.GetName())
+	if len(dropped) == 0 && len(p.allowlists) == 0 {
+		return
+	}
+	aggregated := make(map[string]*dto.Metric, len(family.Metric))
+	metrics := family.Metric[:0]
+	for _, metric := range family.Metric {
+		labels := metric.Label[:0]
+		for _, label := range metric.Label {
+			if _, ok := dropped[label.GetName()]; ok {
+				continue
+			}
+			if allowlist, ok := p.allowlists[label.GetName()]; ok {
+				if _, allowed := allowlist[label.GetValue()]; !allowed {
+					label.Value = proto.String(OtherLabelValue)
+				}
+			}
+			labels = append(labels, label)
+		}
+		metric.Label = labels
+
+		key := labelsKey(labels)
+		if existing, ok := aggregated[key]; ok {
+			mergeMetric(existing, metric)
+			continue
+		}
+		aggregated[key] = metric
+		metrics = append(metrics, metric)
+	}
+	family.Metric = metrics
+}
+
+func labelsKey(labels []*dto.LabelPair) string {
+	pairs := make([]string, len(labels))
+	for i, label := range labels {
+		pairs[i] = label.GetName() + "\xff" + label.GetValue()
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\xfe")
+}
+
+// mergeMetric adds the samples of from to into. Gauges are summed too, e.g. the active flows of all the connections.
+// The quantiles of summaries can't be merged and are left out.
+func mergeMetric(into, from *dto.Metric) {
+	switch {
+	case into.Counter != nil && from.Counter != nil:
+		into.Counter.Value = proto.Float64(into.Counter.GetValue() + from.Counter.GetValue())
+		into.Counter.Exemplar = nil
+	case into.Gauge != nil && from.Gauge != nil:
+		into.Gauge.Value = proto.Float64(into.Gauge.GetValue() + from.Gauge.GetValue())
+	case into.Untyped != nil && from.Untyped != nil:
+		into.Untyped.Value = proto.Float64(into.Untyped.GetValue() + from.Untyped.GetValue())
+	case into.Histogram != nil && from.Histogram != nil:
+		into.Histogram.SampleCount = proto.Uint64(into.Histogram.GetSampleCount() + from.Histogram.GetSampleCount())
+		into.Histogram.SampleSum = proto.Float64(into.Histogram.GetSampleSum() + from.Histogram.GetSampleSum())
+		// The histograms of a family have the same buckets
+		for i, bucket := range into.Histogram.Bucket {
+			if i < len(from.Histogram.Bucket) {
+				bucket.CumulativeCount = proto.Uint64(bucket.GetCumulativeCount() + from.Histogram.Bucket[i].GetCumulativeCount())
+				bucket.Exemplar = nil
+			}
+		}
+	case into.Summary != nil && from.Summary != nil:
+		into.Summary.SampleCount = proto.Uint64(into.Summary.GetSampleCount() + from.Summary.GetSampleCount())
+		into.Summary.SampleSum = proto.Float64(into.Summary.GetSampleSum() + from.Summary.GetSampleSum())
+		into.Summary.Quantile = nil
+	}
+	into.TimestampMs = nil
+}
@@ -0,0 +1,95 @@
+package metrics_test
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/metrics"
+)
+
+func gatherFamily(t *testing.T, gatherer prometheus.Gatherer, name string) *dto.MetricFamily {
+	families, err := gatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == name {
+			return family
+		}
+	}
+	t.Fatalf("metric family %s not gathered", name)
+	return nil
+}
+
+func labels(metric *dto.Metric) map[string]string {
+	result := make(map[string]string)
+	for _, label := range metric.Label {
+		result[label.GetName()] = label.GetValue()
+	}
+	return result
+}
+
+func TestLabelPolicy(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_origin_requests"}, []string{"rule", "status_code"})
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test_origin_latency", Buckets: []float64{1, 10}}, []string{"rule"})
+	flows := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_active_flows"}, []string{"conn_index"})
+	registry.MustRegister(requests, latency, flows)
+
+	requests.WithLabelValues("0", "200").Add(3)
+	requests.WithLabelValues("1", "200").Add(2)
+	requests.WithLabelValues("2", "200").Add(1)
+	requests.WithLabelValues("3", "502").Add(1)
+	latency.WithLabelValues("0").Observe(0.5)
+	latency.WithLabelValues("1").Observe(5)
+	latency.WithLabelValues("2").Observe(20)
+	flows.WithLabelValues("0").Set(4)
+	flows.WithLabelValues("1").Set(6)
+
+	policy, err := metrics.ParseLabelPolicy(
+		[]string{"test_origin_latency=rule", "test_active_*=conn_index"},
+		[]string{"rule=0, 1"},
+	)
+	require.NoError(t, err)
+	gatherer := policy.Gatherer(registry)
+
+	family := gatherFamily(t, gatherer, "test_origin_requests")
+	counts := make(map[[2]string]float64)
+	for _, metric := range family.Metric {
+		l := labels(metric)
+		counts[[2]string{l["rule"], l["status_code"]}] = metric.GetCounter().GetValue()
+	}
+	assert.Equal(t, map[[2]string]float64{
+		{"0", "200"}:                     3,
+		{"1", "200"}:                     2,
+		{metrics.OtherLabelValue, "200"}: 1,
+		{metrics.OtherLabelValue, "502"}: 1,
+	}, counts)
+
+	family = gatherFamily(t, gatherer, "test_origin_latency")
+	require.Len(t, family.Metric, 1)
+	histogram := family.Metric[0].GetHistogram()
+	assert.Empty(t, family.Metric[0].Label)
+	assert.Equal(t, uint64(3), histogram.GetSampleCount())
+	assert.Equal(t, 25.5, histogram.GetSampleSum())
+	assert.Equal(t, uint64(1), histogram.Bucket[0].GetCumulativeCount())
+	assert.Equal(t, uint64(2), histogram.Bucket[1].GetCumulativeCount())
+
+	family = gatherFamily(t, gatherer, "test_active_flows")
+	require.Len(t, family.Metric, 1)
+	assert.Equal(t, 10.0, family.Metric[0].GetGauge().GetValue())
+}
+
+func TestParseLabelPolicy(t *testing.T) {
+	for _, invalid := range [][2][]string{
+		{{"rule"}, nil},
+		{{"=rule"}, nil},
+		{{"test_[=rule"}, nil},
+		{nil, {"rule="}},
+	} {
+		_, err := metrics.ParseLabelPolicy(invalid[0], invalid[1])
+		assert.Error(t, err, invalid)
+	}
+}
@@ -2,6 +2,8 @@ package metrics
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
@@ -17,6 +19,7 @@ import (
 	"golang.org/x/net/trace"
 
 	"github.com/cloudflare/cloudflared/diagnostic"
+	"github.com/cloudflare/cloudflared/supervisor"
 )
 
 const (
@@ -54,15 +57,41 @@ func GetMetricsKnownAddresses(runtimeType string) []string {
 
 type Config struct {
 	ReadyServer         *ReadyServer
+	HealthServer        *HealthServer
 	DiagnosticHandler   *diagnostic.Handler
 	QuickTunnelHostname string
 	Orchestrator        orchestrator
+	// FeatureSnapshots, when set, serves the features the connections were established with at /features
+	FeatureSnapshots *supervisor.FeatureSnapshots
+	// Gatherer, when set, serves metrics at /metrics next to those of the default registry, e.g. the metrics
+	// labelled by hosted tunnel
+	Gatherer prometheus.Gatherer
+	// LabelPolicy, when set, lowers the cardinality of the metrics served at /metrics
+	LabelPolicy *LabelPolicy
+	// Debug, when set, guards the pprof, expvar and request trace endpoints under /debug/, the diagnostics bundle and
+	// the CLI configuration. They're served without authentication otherwise.
+	Debug *DebugEndpoints
+	// TLSConfig, when set, serves the metrics server over TLS, e.g. to authenticate clients with certificates
+	TLSConfig *tls.Config
 
 	ShutdownTimeout time.Duration
 }
 
 type orchestrator interface {
 	GetVersionedConfigJSON() ([]byte, error)
+	PurgeCache(host, pathPrefix string) (int, error)
+}
+
+// gatherer returns the gatherer of the metrics served at /metrics.
+func (config Config) gatherer() prometheus.Gatherer {
+	var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+	if config.Gatherer != nil {
+		gatherer = prometheus.Gatherers{prometheus.DefaultGatherer, config.Gatherer}
+	}
+	if config.LabelPolicy != nil {
+		gatherer = config.LabelPolicy.Gatherer(gatherer)
+	}
+	return gatherer
 }
 
 func newMetricsHandler(
@@ -70,14 +99,25 @@ func newMetricsHandler(
 	log *zerolog.Logger,
 ) *http.ServeMux {
 	router := http.NewServeMux()
-	router.Handle("/debug/", http.DefaultServeMux)
-	router.Handle("/metrics", promhttp.Handler())
+	if config.Debug != nil {
+		router.Handle("/debug/", config.Debug)
+	} else {
+		router.Handle("/debug/", http.DefaultServeMux)
+	}
+	router.Handle("/metrics", promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(config.gatherer(), promhttp.HandlerOpts{}),
+	))
 	router.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = fmt.Fprintf(w, "OK\n")
 	})
 	if config.ReadyServer != nil {
 		router.Handle("/ready", config.ReadyServer)
 	}
+	if config.HealthServer != nil {
+		router.HandleFunc("/healthz", config.HealthServer.Healthz)
+		router.HandleFunc("/readyz", config.HealthServer.Readyz)
+	}
 	router.HandleFunc("/quicktunnel", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = fmt.Fprintf(w, `{"hostname":"%s"}`, config.QuickTunnelHostname)
 	})
@@ -94,7 +134,35 @@ func newMetricsHandler(
 		})
 	}
 
-	config.DiagnosticHandler.InstallEndpoints(router)
+	if config.FeatureSnapshots != nil {
+		router.HandleFunc("/features", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(config.FeatureSnapshots.All())
+		})
+	}
+
+	var guard func(http.Handler) http.Handler
+	if config.Debug != nil {
+		guard = config.Debug.Guard
+	}
+	config.DiagnosticHandler.InstallEndpoints(router, guard)
+	// Purging the caches mutates the proxy, so it's only served like the debug endpoints
+	if config.Orchestrator != nil && guard != nil {
+		router.Handle("/cache/purge", guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			purged, err := config.Orchestrator.PurgeCache(r.URL.Query().Get("host"), r.URL.Query().Get("prefix"))
+			if err != nil {
+				w.WriteHeader(500)
+				_, _ = fmt.Fprintf(w, "ERR: %v", err)
+				log.Err(err).Msg("Failed to purge cache")
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"purged":%d}`, purged)
+		})))
+	}
 
 	return router
 }
@@ -142,7 +210,11 @@ func ServeMetrics(
 	log *zerolog.Logger,
 ) (err error) {
 	var wg sync.WaitGroup
-	// Metrics port is privileged, so no need for further access control
+	if config.TLSConfig != nil {
+		l = tls.NewListener(l, config.TLSConfig)
+	}
+	// Metrics port is privileged, so no need for further access control. The debug endpoints are authenticated by
+	// config.Debug if needed.
 	trace.AuthRequest = func(*http.Request) (bool, bool) { return true, true }
 	// TODO: parameterize ReadTimeout and WriteTimeout. The maximum time we can
 	// profile CPU usage depends on WriteTimeout
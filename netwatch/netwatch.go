@@ -0,0 +1,158 @@
+// Package netwatch detects the changes of the interface addresses and default routes of the host, e.g. after
+// switching Wi-Fi networks, so that the connections to the edge can be reestablished right away instead of after
+// their idle timeout.
+package netwatch
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// A network change usually comes with a burst of events, the state is read once they settle
+	debounceInterval = 500 * time.Millisecond
+	// pollInterval is how often the state is polled on the platforms without change notifications
+	pollInterval = 5 * time.Second
+)
+
+// The default routes are looked up by connecting UDP sockets to these addresses, which sends nothing.
+var (
+	ipv4RouteProbeAddr = netip.MustParseAddrPort("1.1.1.1:53")
+	ipv6RouteProbeAddr = netip.MustParseAddrPort("[2606:4700:4700::1111]:53")
+)
+
+// State is the state of the network of the host the connections depend on.
+type State struct {
+	// Addrs are the addresses of the interfaces of the host.
+	Addrs map[netip.Addr]struct{}
+	// IPv4Source and IPv6Source are the addresses the default routes send from, if there's a default route.
+	IPv4Source netip.Addr
+	IPv6Source netip.Addr
+}
+
+// CurrentState reads the state of the network of the host.
+func CurrentState() State {
+	state := State{Addrs: make(map[netip.Addr]struct{})}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if prefix, ok := addr.(*net.IPNet); ok {
+				if ip, ok := netip.AddrFromSlice(prefix.IP); ok {
+					state.Addrs[ip.Unmap()] = struct{}{}
+				}
+			}
+		}
+	}
+	state.IPv4Source = SourceAddr(ipv4RouteProbeAddr)
+	state.IPv6Source = SourceAddr(ipv6RouteProbeAddr)
+	return state
+}
+
+// SourceAddr returns the address the host sends the packets to dest from, or an invalid address if dest can't be
+// reached.
+func SourceAddr(dest netip.AddrPort) netip.Addr {
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(dest))
+	if err != nil {
+		return netip.Addr{}
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap()
+}
+
+// Equal returns whether the states are the same.
+func (s State) Equal(other State) bool {
+	if s.IPv4Source != other.IPv4Source || s.IPv6Source != other.IPv6Source || len(s.Addrs) != len(other.Addrs) {
+		return false
+	}
+	for addr := range s.Addrs {
+		if _, ok := other.Addrs[addr]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// HasAddr returns whether addr is an address of the host.
+func (s State) HasAddr(addr netip.Addr) bool {
+	_, ok := s.Addrs[addr.Unmap()]
+	return ok
+}
+
+// Watcher calls a function with the new state of the network of the host when it changes.
+type Watcher struct {
+	onChange func(State)
+	log      *zerolog.Logger
+	// events and currentState are overridden in tests
+	events       func(ctx context.Context) (<-chan struct{}, error)
+	currentState func() State
+}
+
+func New(onChange func(State), log *zerolog.Logger) *Watcher {
+	return &Watcher{
+		onChange:     onChange,
+		log:          log,
+		events:       events,
+		currentState: CurrentState,
+	}
+}
+
+// Run watches the network until ctx is done.
+func (w *Watcher) Run(ctx context.Context) error {
+	events, err := w.events(ctx)
+	if err != nil {
+		return err
+	}
+	last := w.currentState()
+	debounce := time.NewTimer(debounceInterval)
+	debounce.Stop()
+	defer debounce.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case _, ok := <-events:
+			if !ok {
+				return nil
+			}
+			debounce.Reset(debounceInterval)
+		case <-debounce.C:
+			state := w.currentState()
+			if state.Equal(last) {
+				continue
+			}
+			w.log.Info().
+				Str("ipv4Source", state.IPv4Source.String()).
+				Str("ipv6Source", state.IPv6Source.String()).
+				Int("addresses", len(state.Addrs)).
+				Msg("Network change detected")
+			last = state
+			w.onChange(state)
+		}
+	}
+}
+
+// pollEvents ticks to poll the state on the platforms without change notifications.
+func pollEvents(ctx context.Context) <-chan struct{} {
+	events := make(chan struct{})
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				select {
+				case events <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+package netwatch
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// netlinkGroups are the multicast groups of the link, address and route changes.
+const netlinkGroups = unix.RTMGRP_LINK |
+	unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR |
+	unix.RTMGRP_IPV4_ROUTE | unix.RTMGRP_IPV6_ROUTE
+
+// events subscribes to the netlink notifications of the link, address and route changes. The messages themselves
+// aren't parsed, they only trigger a read of the state.
+func events(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_ROUTE)
+	if err != nil {
+		// Fall back to polling, e.g. in sandboxes without netlink
+		return pollEvents(ctx), nil
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: netlinkGroups}); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("failed to subscribe to netlink route notifications: %w", err)
+	}
+	// The file registers the socket with the runtime poller, so that closing it unblocks the reads
+	socket := os.NewFile(uintptr(fd), "netlink")
+	go func() {
+		<-ctx.Done()
+		socket.Close()
+	}()
+
+	events := make(chan struct{})
+	go func() {
+		defer close(events)
+		buf := make([]byte, os.Getpagesize())
+		for {
+			if _, err := socket.Read(buf); err != nil {
+				// ENOBUFS means notifications were dropped, the state changed nonetheless
+				if err, ok := err.(*os.PathError); ok && err.Err == unix.ENOBUFS {
+					continue
+				}
+				return
+			}
+			select {
+			case events <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package netwatch
+
+import "context"
+
+// events polls the state of the network, since the routing sockets of the other platforms aren't portable.
+func events(ctx context.Context) (<-chan struct{}, error) {
+	return pollEvents(ctx), nil
+}
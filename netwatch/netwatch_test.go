@@ -0,0 +1,79 @@
+package netwatch
+
+import (
+	"context"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newState(ipv4Source string, addrs ...string) State {
+	state := State{Addrs: make(map[netip.Addr]struct{})}
+	for _, addr := range addrs {
+		state.Addrs[netip.MustParseAddr(addr)] = struct{}{}
+	}
+	if ipv4Source != "" {
+		state.IPv4Source = netip.MustParseAddr(ipv4Source)
+	}
+	return state
+}
+
+func TestStateEqual(t *testing.T) {
+	wifi := newState("192.168.1.10", "127.0.0.1", "192.168.1.10")
+	assert.True(t, wifi.Equal(newState("192.168.1.10", "192.168.1.10", "127.0.0.1")))
+	assert.False(t, wifi.Equal(newState("10.0.0.5", "127.0.0.1", "10.0.0.5")))
+	assert.False(t, wifi.Equal(newState("10.0.0.5", "127.0.0.1", "192.168.1.10")))
+	assert.False(t, wifi.Equal(newState("192.168.1.10", "127.0.0.1", "192.168.1.10", "10.0.0.5")))
+
+	assert.True(t, wifi.HasAddr(netip.MustParseAddr("::ffff:192.168.1.10")))
+	assert.False(t, wifi.HasAddr(netip.MustParseAddr("10.0.0.5")))
+}
+
+func TestWatcherDebouncesChanges(t *testing.T) {
+	events := make(chan struct{})
+	states := make(chan State, 1)
+	var current atomic.Value
+	current.Store(newState("192.168.1.10", "192.168.1.10"))
+	log := zerolog.Nop()
+	watcher := New(func(state State) { states <- state }, &log)
+	watcher.events = func(context.Context) (<-chan struct{}, error) { return events, nil }
+	watcher.currentState = func() State { return current.Load().(State) }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- watcher.Run(ctx) }()
+
+	// Events that don't change the state aren't reported
+	events <- struct{}{}
+	select {
+	case <-states:
+		t.Fatal("unexpected change")
+	case <-time.After(2 * debounceInterval):
+	}
+
+	// A burst of events is reported once
+	current.Store(newState("10.0.0.5", "10.0.0.5"))
+	for i := 0; i < 5; i++ {
+		events <- struct{}{}
+	}
+	select {
+	case state := <-states:
+		assert.Equal(t, netip.MustParseAddr("10.0.0.5"), state.IPv4Source)
+	case <-time.After(4 * debounceInterval):
+		t.Fatal("change not reported")
+	}
+	select {
+	case <-states:
+		t.Fatal("change reported twice")
+	case <-time.After(2 * debounceInterval):
+	}
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
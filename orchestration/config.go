@@ -3,8 +3,12 @@ package orchestration
 import (
 	"encoding/json"
 
+	"github.com/cloudflare/cloudflared/accesslog"
+	"github.com/cloudflare/cloudflared/accounting"
+	"github.com/cloudflare/cloudflared/cfio"
 	"github.com/cloudflare/cloudflared/config"
 	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/memlimit"
 )
 
 type newRemoteConfig struct {
@@ -26,6 +30,31 @@ type Config struct {
 	// Extra settings used to configure this instance but that are not eligible for remotely management
 	// ie. (--protocol, --loglevel, ...)
 	ConfigurationFlags map[string]string
+
+	// ValidateIngress, when set, is called with every new set of user provided ingress rules before they are
+	// applied. Rules failing validation are rejected.
+	ValidateIngress func(ingress.Ingress) error
+
+	// AccessLog, when set, gets an entry for every HTTP request proxied to an origin.
+	AccessLog *accesslog.Logger
+
+	// Accounting, when set, throttles the requests and flows once its usage quota is exceeded.
+	Accounting *accounting.Meter
+
+	// Memory, when set, sheds the UDP sessions and the requests of the low priority rules while the memory soft limit
+	// is exceeded.
+	Memory *memlimit.Monitor
+
+	// CopyWatermarks are the watermarks of the budgets of the copies of each ingress rule, and of the private network
+	// flows. The copies aren't limited when they're zero.
+	CopyWatermarks cfio.Watermarks
+
+	// ConfigHistorySize is how many of the configurations applied are kept to roll back to, 10 if 0.
+	ConfigHistorySize int
+
+	// OnApply, when set, is called when a new configuration starts being applied, and the function it returns once
+	// the configuration is applied or rejected.
+	OnApply func() (done func())
 }
 
 func (rc *newLocalConfig) MarshalJSON() ([]byte, error) {
@@ -65,3 +94,21 @@ func convertToUnvalidatedIngressRules(i ingress.Ingress) []config.UnvalidatedIng
 
 	return result
 }
+
+// ValidateConfig validates a configuration as pushed by the edge, reporting every problem found instead of stopping at
+// the first one like UpdateConfig does.
+func ValidateConfig(configJSON []byte) ingress.Findings {
+	var raw ingress.RemoteConfigJSON
+	if err := json.Unmarshal(configJSON, &raw); err != nil {
+		return ingress.Findings{{Severity: ingress.SeverityError, Rule: -1, Message: err.Error()}}
+	}
+	defaults := config.OriginRequestConfig{}
+	if raw.GlobalOriginRequest != nil {
+		defaults = *raw.GlobalOriginRequest
+	}
+	findings := ingress.ValidateRules(raw.IngressRules, defaults)
+	if err := ingress.ValidateQoSRules(raw.WarpRouting.QoS); err != nil {
+		findings = append(findings, ingress.Finding{Severity: ingress.SeverityError, Rule: -1, Field: "warp-routing.qos", Message: err.Error()})
+	}
+	return findings
+}
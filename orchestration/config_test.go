@@ -91,3 +91,24 @@ func TestNewLocalConfig_MarshalJSON(t *testing.T) {
 	})
 	require.Equal(t, remoteConfig.Ingress.Rules, expectedConfig.Ingress.Rules)
 }
+
+func TestValidateConfig(t *testing.T) {
+	findings := ValidateConfig([]byte(`{
+		"ingress": [
+			{"hostname": "a.example.com", "service": "http://localhost:8000", "originRequest": {"hedging": {"delay": -1}}},
+			{"hostname": "b.example.com", "service": "http://localhost:8001"}
+		]
+	}`))
+	require.True(t, findings.HasErrors())
+	require.Len(t, findings, 2)
+	require.Equal(t, 0, findings[0].Rule)
+	require.Equal(t, "originRequest.hedging", findings[0].Field)
+	require.Equal(t, 1, findings[1].Rule)
+	require.Equal(t, "hostname", findings[1].Field)
+
+	findings = ValidateConfig([]byte(`{"ingress": [`))
+	require.True(t, findings.HasErrors())
+
+	findings = ValidateConfig([]byte(`{"ingress": [{"service": "http_status:503"}]}`))
+	require.False(t, findings.HasErrors())
+}
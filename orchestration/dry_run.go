@@ -0,0 +1,125 @@
+package orchestration
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+// RuleChange is an ingress rule that a configuration adds, removes or modifies.
+type RuleChange struct {
+	Hostname string `json:"hostname"`
+	Path     string `json:"path,omitempty"`
+	// Service is the service of the rule in the candidate configuration, or of the removed rule.
+	Service string `json:"service"`
+	// PreviousService is the service of a modified rule in the current configuration.
+	PreviousService string `json:"previousService,omitempty"`
+}
+
+// DryRunReport is what applying a configuration would do.
+type DryRunReport struct {
+	// WouldApply is whether the configuration would be applied, i.e. none of the findings is an error.
+	WouldApply bool             `json:"wouldApply"`
+	Findings   ingress.Findings `json:"findings"`
+	Added      []RuleChange     `json:"added"`
+	Removed    []RuleChange     `json:"removed"`
+	Modified   []RuleChange     `json:"modified"`
+	// WarpRouting is the configuration the origin dialer would be built with, with the local overrides.
+	WarpRouting *config.WarpRoutingConfig `json:"warp-routing,omitempty"`
+	// UDPOrigins are the addresses of the UDP services the origin dialer would dial.
+	UDPOrigins []string `json:"udpOrigins,omitempty"`
+}
+
+// DryRun goes through the steps of UpdateConfig with a configuration as pushed by the edge, validating it,
+// compiling its ingress rules and resolving the settings of its origin dialer, and reports how the ingress rules
+// would change without applying it. The origins of the configuration aren't started.
+func (o *Orchestrator) DryRun(configJSON []byte) *DryRunReport {
+	report := &DryRunReport{Findings: ValidateConfig(configJSON)}
+	if report.Findings.HasErrors() {
+		return report
+	}
+
+	var newConf newRemoteConfig
+	if err := json.Unmarshal(configJSON, &newConf); err != nil {
+		report.Findings = append(report.Findings, configFinding("", err))
+		return report
+	}
+	warpRouting := newConf.WarpRouting
+	if err := o.overrideRemoteWarpRoutingWithLocalValues(&warpRouting); err != nil {
+		report.Findings = append(report.Findings, configFinding("warp-routing", err))
+		return report
+	}
+	if o.config.ValidateIngress != nil && !newConf.Ingress.IsEmpty() {
+		if err := o.config.ValidateIngress(newConf.Ingress); err != nil {
+			report.Findings = append(report.Findings, configFinding("ingress", err))
+			return report
+		}
+	}
+	report.WouldApply = true
+	rawWarpRouting := warpRouting.RawConfig()
+	report.WarpRouting = &rawWarpRouting
+	for addr := range newConf.Ingress.UDPOrigins() {
+		report.UDPOrigins = append(report.UDPOrigins, addr.String())
+	}
+	sort.Strings(report.UDPOrigins)
+
+	o.lock.RLock()
+	current := convertToUnvalidatedIngressRules(*o.config.Ingress)
+	o.lock.RUnlock()
+	report.Added, report.Removed, report.Modified = diffRules(current, convertToUnvalidatedIngressRules(newConf.Ingress))
+	return report
+}
+
+func configFinding(field string, err error) ingress.Finding {
+	return ingress.Finding{Severity: ingress.SeverityError, Rule: -1, Field: field, Message: err.Error()}
+}
+
+// diffRules compares ingress rules by hostname and path, or by address for the udp services which don't have any,
+// the rules matching the same requests being compared in order.
+func diffRules(current, candidate []config.UnvalidatedIngressRule) (added, removed, modified []RuleChange) {
+	added, removed, modified = []RuleChange{}, []RuleChange{}, []RuleChange{}
+	currentRules := make(map[string][]config.UnvalidatedIngressRule)
+	for _, rule := range current {
+		key := ruleKey(rule)
+		currentRules[key] = append(currentRules[key], rule)
+	}
+	for _, rule := range candidate {
+		key := ruleKey(rule)
+		previous := currentRules[key]
+		if len(previous) == 0 {
+			added = append(added, ruleChange(rule))
+			continue
+		}
+		currentRules[key] = previous[1:]
+		if !reflect.DeepEqual(previous[0], rule) {
+			change := ruleChange(rule)
+			change.PreviousService = previous[0].Service
+			modified = append(modified, change)
+		}
+	}
+	// Removed rules are reported in the order of the current configuration
+	for _, rule := range current {
+		key := ruleKey(rule)
+		if len(currentRules[key]) > 0 {
+			removed = append(removed, ruleChange(currentRules[key][0]))
+			currentRules[key] = currentRules[key][1:]
+		}
+	}
+	return added, removed, modified
+}
+
+func ruleKey(rule config.UnvalidatedIngressRule) string {
+	if strings.HasPrefix(rule.Service, "udp://") {
+		return rule.Service
+	}
+	return fmt.Sprintf("%s %s", rule.Hostname, rule.Path)
+}
+
+func ruleChange(rule config.UnvalidatedIngressRule) RuleChange {
+	return RuleChange{Hostname: rule.Hostname, Path: rule.Path, Service: rule.Service}
+}
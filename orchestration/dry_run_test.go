@@ -0,0 +1,82 @@
+package orchestration
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+func TestDryRun(t *testing.T) {
+	originDialer := ingress.NewOriginDialer(ingress.OriginConfig{
+		DefaultDialer:   testDefaultDialer,
+		TCPWriteTimeout: 1 * time.Second,
+	}, &testLogger)
+	initConfig := &Config{
+		Ingress:             &ingress.Ingress{},
+		OriginDialerService: originDialer,
+		ValidateIngress: func(ing ingress.Ingress) error {
+			if ing.Rules[0].Hostname == "unrouted.example.com" {
+				return fmt.Errorf("hostname has no DNS route")
+			}
+			return nil
+		},
+	}
+	orchestrator, err := NewOrchestrator(t.Context(), initConfig, testTags, []ingress.Rule{}, &testLogger)
+	require.NoError(t, err)
+	updateWithValidation(t, orchestrator, 1, []byte(`
+{
+	"ingress": [
+		{"hostname": "app.example.com", "service": "http://localhost:8000"},
+		{"hostname": "api.example.com", "path": "/v1", "service": "http://localhost:8001"},
+		{"hostname": "old.example.com", "service": "http://localhost:8002"},
+		{"service": "http_status:404"}
+	]
+}
+`))
+
+	report := orchestrator.DryRun([]byte(`
+{
+	"ingress": [
+		{"hostname": "app.example.com", "service": "http://localhost:8000"},
+		{"hostname": "api.example.com", "path": "/v1", "service": "http://localhost:9001"},
+		{"hostname": "new.example.com", "service": "http://localhost:8003"},
+		{"service": "udp://127.0.0.1:53"},
+		{"service": "http_status:404"}
+	],
+	"warp-routing": {"connectTimeout": 10}
+}
+`))
+	assert.True(t, report.WouldApply)
+	assert.Empty(t, report.Findings)
+	assert.Equal(t, []RuleChange{
+		{Hostname: "new.example.com", Service: "http://localhost:8003"},
+		{Service: "udp://127.0.0.1:53"},
+	}, report.Added)
+	assert.Equal(t, []RuleChange{{Hostname: "old.example.com", Service: "http://localhost:8002"}}, report.Removed)
+	assert.Equal(t, []RuleChange{{Hostname: "api.example.com", Path: "/v1", Service: "http://localhost:9001", PreviousService: "http://localhost:8001"}}, report.Modified)
+	assert.Equal(t, []string{"127.0.0.1:53"}, report.UDPOrigins)
+	require.NotNil(t, report.WarpRouting)
+	assert.Equal(t, 10*time.Second, report.WarpRouting.ConnectTimeout.Duration)
+
+	// Nothing was applied
+	assert.Equal(t, int32(1), orchestrator.currentVersion)
+	require.Len(t, orchestrator.config.Ingress.Rules, 4)
+	assert.Equal(t, "old.example.com", orchestrator.config.Ingress.Rules[2].Hostname)
+
+	// Rejected configurations report why
+	report = orchestrator.DryRun([]byte(`{"ingress": [{"hostname": "unrouted.example.com", "service": "http_status:200"}, {"service": "http_status:404"}]}`))
+	assert.False(t, report.WouldApply)
+	require.Len(t, report.Findings, 1)
+	assert.Equal(t, "ingress", report.Findings[0].Field)
+	assert.Contains(t, report.Findings[0].Message, "hostname has no DNS route")
+
+	report = orchestrator.DryRun([]byte(`{"ingress": [{"hostname": "app.example.com", "service": "ftp://localhost"}]}`))
+	assert.False(t, report.WouldApply)
+	assert.True(t, report.Findings.HasErrors())
+	assert.Empty(t, report.Added)
+}
@@ -0,0 +1,118 @@
+package orchestration
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+const defaultConfigHistorySize = 10
+
+// ErrConfigVersionNotFound is returned when rolling back to a version that isn't in the history.
+var ErrConfigVersionNotFound = errors.New("configuration version isn't in the history")
+
+// AppliedConfig is a configuration applied by the orchestrator.
+type AppliedConfig struct {
+	// Version is the version of the configuration, -1 for the configuration cloudflared started with.
+	Version   int32     `json:"version"`
+	AppliedAt time.Time `json:"appliedAt"`
+	// RolledBack is whether the configuration was applied again by a rollback.
+	RolledBack bool            `json:"rolledBack,omitempty"`
+	Config     json.RawMessage `json:"config"`
+}
+
+// configHistory holds the last configurations applied, oldest first.
+type configHistory struct {
+	size    int
+	entries []AppliedConfig
+}
+
+func newConfigHistory(size int) *configHistory {
+	if size <= 0 {
+		size = defaultConfigHistorySize
+	}
+	return &configHistory{size: size}
+}
+
+func (h *configHistory) record(entry AppliedConfig) {
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > h.size {
+		h.entries = h.entries[len(h.entries)-h.size:]
+	}
+}
+
+// find returns the last configuration applied with the version.
+func (h *configHistory) find(version int32) (AppliedConfig, bool) {
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		if h.entries[i].Version == version {
+			return h.entries[i], true
+		}
+	}
+	return AppliedConfig{}, false
+}
+
+// ConfigHistory returns the last configurations applied, newest first.
+func (o *Orchestrator) ConfigHistory() []AppliedConfig {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	history := make([]AppliedConfig, 0, len(o.history.entries))
+	for i := len(o.history.entries) - 1; i >= 0; i-- {
+		history = append(history, o.history.entries[i])
+	}
+	return history
+}
+
+// Rollback applies again the configuration of a version in the history. The current version isn't changed, so the
+// next configuration pushed by the edge replaces the rolled back one.
+func (o *Orchestrator) Rollback(version int32) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.applyingSince.Store(time.Now().UnixNano())
+	defer o.applyingSince.Store(0)
+
+	entry, ok := o.history.find(version)
+	if !ok {
+		return ErrConfigVersionNotFound
+	}
+	if o.config.OnApply != nil {
+		defer o.config.OnApply()()
+	}
+	var conf newRemoteConfig
+	if err := json.Unmarshal(entry.Config, &conf); err != nil {
+		return pkgerrors.Wrapf(err, "failed to deserialize configuration version %d", version)
+	}
+	if err := o.updateIngress(conf.Ingress, conf.WarpRouting); err != nil {
+		return pkgerrors.Wrapf(err, "failed to roll back to configuration version %d", version)
+	}
+	o.recordConfig(version, true)
+	configRollbacks.Inc()
+	o.log.Warn().
+		Int32("version", version).
+		Int32("current_version", o.currentVersion).
+		Msg("Rolled back to a previous configuration")
+	return nil
+}
+
+// recordConfig adds the configuration just applied to the history. The caller must hold the lock.
+func (o *Orchestrator) recordConfig(version int32, rolledBack bool) {
+	configJSON, err := json.Marshal(&newLocalConfig{
+		RemoteConfig: ingress.RemoteConfig{
+			Ingress:     *o.config.Ingress,
+			WarpRouting: o.config.WarpRouting,
+		},
+	})
+	if err != nil {
+		o.log.Err(err).Int32("version", version).Msg("Failed to record the applied configuration")
+		return
+	}
+	o.history.record(AppliedConfig{
+		Version:    version,
+		AppliedAt:  time.Now(),
+		RolledBack: rolledBack,
+		Config:     configJSON,
+	})
+}
@@ -0,0 +1,63 @@
+package orchestration
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+func TestConfigHistoryAndRollback(t *testing.T) {
+	originDialer := ingress.NewOriginDialer(ingress.OriginConfig{
+		DefaultDialer:   testDefaultDialer,
+		TCPWriteTimeout: 1 * time.Second,
+	}, &testLogger)
+	var applied, done int
+	initConfig := &Config{
+		Ingress:             &ingress.Ingress{},
+		OriginDialerService: originDialer,
+		ConfigHistorySize:   3,
+		OnApply: func() func() {
+			applied++
+			return func() { done++ }
+		},
+	}
+	orchestrator, err := NewOrchestrator(t.Context(), initConfig, testTags, []ingress.Rule{}, &testLogger)
+	require.NoError(t, err)
+
+	configWithService := func(service string) []byte {
+		return []byte(fmt.Sprintf(`{"ingress": [{"hostname": "app.example.com", "service": %q}, {"service": "http_status:404"}]}`, service))
+	}
+	updateWithValidation(t, orchestrator, 1, configWithService("http://localhost:8001"))
+	updateWithValidation(t, orchestrator, 2, configWithService("http://localhost:8002"))
+	updateWithValidation(t, orchestrator, 3, configWithService("http://localhost:8003"))
+
+	// The configuration cloudflared started with was pushed out of the history
+	history := orchestrator.ConfigHistory()
+	require.Len(t, history, 3)
+	for i, version := range []int32{3, 2, 1} {
+		assert.Equal(t, version, history[i].Version)
+		assert.False(t, history[i].RolledBack)
+	}
+	require.ErrorIs(t, orchestrator.Rollback(-1), ErrConfigVersionNotFound)
+	// Outdated versions aren't applied
+	orchestrator.UpdateConfig(2, configWithService("http://localhost:8002"))
+	assert.Equal(t, 3, applied)
+
+	require.NoError(t, orchestrator.Rollback(1))
+	assert.Equal(t, "http://localhost:8001", orchestrator.config.Ingress.Rules[0].Service.String())
+	assert.Equal(t, int32(3), orchestrator.ConfigVersion())
+	history = orchestrator.ConfigHistory()
+	assert.Equal(t, int32(1), history[0].Version)
+	assert.True(t, history[0].RolledBack)
+
+	// The next configuration pushed by the edge replaces the rolled back one
+	updateWithValidation(t, orchestrator, 4, configWithService("http://localhost:8004"))
+	assert.Equal(t, "http://localhost:8004", orchestrator.config.Ingress.Rules[0].Service.String())
+	assert.Equal(t, 5, applied)
+	assert.Equal(t, applied, done)
+}
@@ -18,8 +18,16 @@ var (
 			Help:      "Configuration Version",
 		},
 	)
+	configRollbacks = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "config_rollbacks",
+			Help:      "Number of rollbacks to a previous configuration",
+		},
+	)
 )
 
 func init() {
-	prometheus.MustRegister(configVersion)
+	prometheus.MustRegister(configVersion, configRollbacks)
 }
@@ -7,16 +7,20 @@ import (
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	pkgerrors "github.com/pkg/errors"
 	"github.com/rs/zerolog"
 
+	"github.com/cloudflare/cloudflared/accounting"
 	"github.com/cloudflare/cloudflared/cmd/cloudflared/flags"
 	"github.com/cloudflare/cloudflared/config"
 	"github.com/cloudflare/cloudflared/connection"
 	cfdflow "github.com/cloudflare/cloudflared/flow"
 	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/memlimit"
 	"github.com/cloudflare/cloudflared/proxy"
+	"github.com/cloudflare/cloudflared/recovery"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
 )
 
@@ -29,6 +33,8 @@ type Orchestrator struct {
 	currentVersion int32
 	// Used by UpdateConfig to make sure one update at a time
 	lock sync.RWMutex
+	// applyingSince is when the configuration being applied started to be, in Unix nanoseconds, or 0
+	applyingSince atomic.Int64
 	// Underlying value is proxy.Proxy, can be read without the lock, but still needs the lock to update
 	proxy atomic.Value
 	// Set of internal ingress rules defined at cloudflared startup (separate from user-defined ingress rules)
@@ -40,7 +46,13 @@ type Orchestrator struct {
 	flowLimiter cfdflow.Limiter
 	// Origin dialer service to manage egress socket dialing.
 	originDialerService *ingress.OriginDialerService
-	log                 *zerolog.Logger
+	// maintenance holds the maintenance toggles, shared by the successive proxies
+	maintenance *proxy.Maintenance
+	// caches holds the response caches of the ingress rules, reused by the successive proxies
+	caches *proxy.ResponseCaches
+	// history holds the last configurations applied, for rollbacks
+	history *configHistory
+	log     *zerolog.Logger
 
 	// orchestrator must not handle any more updates after shutdownC is closed
 	shutdownC <-chan struct{}
@@ -62,22 +74,39 @@ func NewOrchestrator(ctx context.Context,
 		internalRules:       internalRules,
 		config:              config,
 		tags:                tags,
-		flowLimiter:         cfdflow.NewLimiter(config.WarpRouting.MaxActiveFlows),
+		flowLimiter:         newFlowLimiter(config),
 		originDialerService: config.OriginDialerService,
+		maintenance:         proxy.NewMaintenance(),
+		caches:              proxy.NewResponseCaches(),
+		history:             newConfigHistory(config.ConfigHistorySize),
 		log:                 log,
 		shutdownC:           ctx.Done(),
 	}
 	if err := o.updateIngress(*config.Ingress, config.WarpRouting); err != nil {
 		return nil, err
 	}
+	o.recordConfig(o.currentVersion, false)
 	go o.waitToCloseLastProxy()
 	return o, nil
 }
 
 // UpdateConfig creates a new proxy with the new ingress rules
-func (o *Orchestrator) UpdateConfig(version int32, config []byte) *pogs.UpdateConfigurationResponse {
+func (o *Orchestrator) UpdateConfig(version int32, config []byte) (resp *pogs.UpdateConfigurationResponse) {
 	o.lock.Lock()
 	defer o.lock.Unlock()
+	o.applyingSince.Store(time.Now().UnixNano())
+	defer o.applyingSince.Store(0)
+	// A configuration the orchestrator panics on is rejected, the current one keeps being served
+	defer func() {
+		if r := recover(); r != nil {
+			err := recovery.Error(r, recovery.SubsystemOrchestrator, recovery.NoConnIndex)
+			o.log.Err(err).Int32("version", version).Msg("Failed to update to the new configuration")
+			resp = &pogs.UpdateConfigurationResponse{
+				LastAppliedVersion: o.currentVersion,
+				Err:                err,
+			}
+		}
+	}()
 
 	if o.currentVersion >= version {
 		o.log.Debug().
@@ -88,6 +117,9 @@ func (o *Orchestrator) UpdateConfig(version int32, config []byte) *pogs.UpdateCo
 			LastAppliedVersion: o.currentVersion,
 		}
 	}
+	if o.config.OnApply != nil {
+		defer o.config.OnApply()()
+	}
 	var newConf newRemoteConfig
 	if err := json.Unmarshal(config, &newConf); err != nil {
 		o.log.Err(err).
@@ -111,6 +143,7 @@ func (o *Orchestrator) UpdateConfig(version int32, config []byte) *pogs.UpdateCo
 		}
 	}
 	o.currentVersion = version
+	o.recordConfig(version, false)
 
 	o.log.Info().
 		Int32("version", version).
@@ -122,6 +155,15 @@ func (o *Orchestrator) UpdateConfig(version int32, config []byte) *pogs.UpdateCo
 	}
 }
 
+// ConfigApplyDuration returns how long the configuration being applied has been, or 0 if none is.
+func (o *Orchestrator) ConfigApplyDuration() time.Duration {
+	since := o.applyingSince.Load()
+	if since == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, since))
+}
+
 // overrideRemoteWarpRoutingWithLocalValues overrides the ingress.WarpRoutingConfig that comes from the remote with
 // the local values if there is any.
 func (o *Orchestrator) overrideRemoteWarpRoutingWithLocalValues(remoteWarpRouting *ingress.WarpRoutingConfig) error {
@@ -159,6 +201,12 @@ func (o *Orchestrator) updateIngress(ingressRules ingress.Ingress, warpRouting i
 		return pkgerrors.Wrap(err, "failed to merge local overrides into warp routing configuration")
 	}
 
+	if o.config.ValidateIngress != nil && !ingressRules.IsEmpty() {
+		if err := o.config.ValidateIngress(ingressRules); err != nil {
+			return pkgerrors.Wrap(err, "ingress rules failed validation")
+		}
+	}
+
 	// Assign the internal ingress rules to the parsed ingress
 	ingressRules.InternalRules = o.internalRules
 
@@ -185,9 +233,17 @@ func (o *Orchestrator) updateIngress(ingressRules ingress.Ingress, warpRouting i
 	// way into the datagram manager. Reconstructing the datagram manager is not something we currently provide during
 	// runtime in response to a configuration push except when starting a tunnel connection.
 	o.originDialerService.UpdateDefaultDialer(ingress.NewDialer(warpRouting))
+	o.originDialerService.UpdateIngressUDPServices(ingressRules.UDPOrigins())
+
+	// The rules toggled in maintenance are identified by their index, which may now be another rule's
+	if o.proxy.Load() != nil {
+		if cleared := o.maintenance.ClearRules(); cleared > 0 {
+			o.log.Warn().Msgf("Cleared the maintenance toggles of %d ingress rules, since the ingress rules changed", cleared)
+		}
+	}
 
 	// Create and replace the origin proxy with a new instance
-	proxy := proxy.NewOriginProxy(ingressRules, o.originDialerService, o.tags, o.flowLimiter, o.log)
+	proxy := proxy.NewOriginProxy(ingressRules, o.originDialerService, o.tags, o.flowLimiter, o.config.AccessLog, o.config.Accounting, o.config.Memory, o.maintenance, o.caches, o.config.CopyWatermarks, o.log)
 	o.proxy.Store(proxy)
 	o.config.Ingress = &ingressRules
 	o.config.WarpRouting = warpRouting
@@ -242,6 +298,13 @@ func (o *Orchestrator) GetVersionedConfigJSON() ([]byte, error) {
 	return json.Marshal(currentConfiguration)
 }
 
+// ConfigVersion returns the version of the last configuration applied from the edge, or -1 if none was applied.
+func (o *Orchestrator) ConfigVersion() int32 {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	return o.currentVersion
+}
+
 // GetOriginProxy returns an interface to proxy to origin. It satisfies connection.ConfigManager interface
 func (o *Orchestrator) GetOriginProxy() (connection.OriginProxy, error) {
 	val := o.proxy.Load()
@@ -259,6 +322,24 @@ func (o *Orchestrator) GetOriginProxy() (connection.OriginProxy, error) {
 	return proxy, nil
 }
 
+// PurgeCache removes the responses matching host and path prefix from the response cache of the current proxy.
+func (o *Orchestrator) PurgeCache(host, pathPrefix string) (int, error) {
+	val := o.proxy.Load()
+	if val == nil {
+		return 0, fmt.Errorf("origin proxy not configured")
+	}
+	originProxy, ok := val.(*proxy.Proxy)
+	if !ok {
+		return 0, fmt.Errorf("origin proxy has unexpected value %+v", val)
+	}
+	return originProxy.PurgeCache(host, pathPrefix), nil
+}
+
+// Maintenance returns the maintenance toggles of the ingress rules.
+func (o *Orchestrator) Maintenance() *proxy.Maintenance {
+	return o.maintenance
+}
+
 // GetFlowLimiter returns the flow limiter used across cloudflared, that can be hot reload when
 // the configuration changes.
 func (o *Orchestrator) GetFlowLimiter() cfdflow.Limiter {
@@ -266,6 +347,7 @@ func (o *Orchestrator) GetFlowLimiter() cfdflow.Limiter {
 }
 
 func (o *Orchestrator) waitToCloseLastProxy() {
+	defer recovery.Recover(o.log, recovery.SubsystemOrchestrator, recovery.NoConnIndex)
 	<-o.shutdownC
 	o.lock.Lock()
 	defer o.lock.Unlock()
@@ -275,3 +357,16 @@ func (o *Orchestrator) waitToCloseLastProxy() {
 		o.proxyShutdownC = nil
 	}
 }
+
+// newFlowLimiter returns the limiter of the flows, which also rejects them while the usage quota is exceeded, and
+// the UDP sessions while the memory soft limit is exceeded.
+func newFlowLimiter(config *Config) cfdflow.Limiter {
+	limiter := cfdflow.NewLimiter(config.WarpRouting.MaxActiveFlows)
+	if config.Memory != nil {
+		limiter = memlimit.NewLimiter(limiter, config.Memory)
+	}
+	if config.Accounting != nil {
+		return accounting.NewLimiter(limiter, config.Accounting)
+	}
+	return limiter
+}
@@ -245,6 +245,57 @@ func TestUpdateConfiguration_WithoutIngressRule(t *testing.T) {
 	require.Len(t, orchestrator.config.Ingress.Rules, 1)
 }
 
+func TestUpdateConfiguration_RejectedByValidation(t *testing.T) {
+	originDialer := ingress.NewOriginDialer(ingress.OriginConfig{
+		DefaultDialer:   testDefaultDialer,
+		TCPWriteTimeout: 1 * time.Second,
+	}, &testLogger)
+	initConfig := &Config{
+		Ingress:             &ingress.Ingress{},
+		OriginDialerService: originDialer,
+		ValidateIngress: func(ing ingress.Ingress) error {
+			if ing.Rules[0].Hostname == "unrouted.example.com" {
+				return fmt.Errorf("hostname has no DNS route")
+			}
+			return nil
+		},
+	}
+	orchestrator, err := NewOrchestrator(t.Context(), initConfig, testTags, []ingress.Rule{}, &testLogger)
+	require.NoError(t, err)
+
+	rejectedConfig := []byte(`
+{
+	"ingress": [
+		{
+			"hostname": "unrouted.example.com",
+			"service": "http_status:200"
+		},
+		{
+			"service": "http_status:404"
+		}
+	]
+}
+`)
+	resp := orchestrator.UpdateConfig(1, rejectedConfig)
+	require.Error(t, resp.Err)
+	require.Equal(t, int32(-1), resp.LastAppliedVersion)
+
+	acceptedConfig := []byte(`
+{
+	"ingress": [
+		{
+			"hostname": "app.example.com",
+			"service": "http_status:200"
+		},
+		{
+			"service": "http_status:404"
+		}
+	]
+}
+`)
+	updateWithValidation(t, orchestrator, 2, acceptedConfig)
+}
+
 // TestConcurrentUpdateAndRead makes sure orchestrator can receive updates and return origin proxy concurrently
 func TestConcurrentUpdateAndRead(t *testing.T) {
 	const (
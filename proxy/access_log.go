@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cloudflare/cloudflared/accesslog"
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/tracing"
+)
+
+// accessLogRecorder records the status and the size of the response sent to the eyeball, for the access log.
+type accessLogRecorder struct {
+	connection.ResponseWriter
+	start  time.Time
+	status int
+	bytes  int64
+}
+
+func newAccessLogRecorder(w connection.ResponseWriter) *accessLogRecorder {
+	return &accessLogRecorder{ResponseWriter: w, start: time.Now()}
+}
+
+func (r *accessLogRecorder) WriteRespHeaders(status int, header http.Header) error {
+	if r.status == 0 {
+		r.status = status
+	}
+	return r.ResponseWriter.WriteRespHeaders(status, header)
+}
+
+func (r *accessLogRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *accessLogRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *accessLogRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// logAccess writes the access log entry of a request. Requests that failed before a response was written are
+// logged with a 502 status, which is what the edge answers the eyeball.
+func (p *Proxy) logAccess(tr *tracing.TracedHTTPRequest, recorder *accessLogRecorder, ruleNum int, err error) {
+	req := tr.Request
+	status := recorder.status
+	if status == 0 && err != nil {
+		status = http.StatusBadGateway
+	}
+	p.accessLog.Log(accesslog.Entry{
+		Time:      recorder.start,
+		Method:    req.Method,
+		Host:      req.Host,
+		Path:      req.URL.Path,
+		Status:    status,
+		Duration:  time.Since(recorder.start),
+		Bytes:     recorder.bytes,
+		EyeballIP: req.Header.Get("Cf-Connecting-Ip"),
+		CfRay:     connection.FindCfRayHeader(req),
+		Rule:      ruleNum,
+		ConnIndex: tr.ConnIndex,
+	})
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// budget bounds the extra requests sent to an origin, such as retries and hedged requests, to a percentage of the
+// requests of a sliding window.
+type budget struct {
+	percent uint
+	// minPerWindow extra requests are always allowed in a window, so that low traffic rules aren't starved
+	minPerWindow uint
+	window       time.Duration
+
+	lock        sync.Mutex
+	windowStart time.Time
+	requests    uint
+	spent       uint
+}
+
+func newBudget(percent, minPerWindow uint, window time.Duration) *budget {
+	return &budget{
+		percent:      percent,
+		minPerWindow: minPerWindow,
+		window:       window,
+	}
+}
+
+func (b *budget) countRequest() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.resetWindowIfExpired()
+	b.requests++
+}
+
+// acquire takes an extra request from the budget, returning false if the budget is exhausted.
+func (b *budget) acquire() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.resetWindowIfExpired()
+	if b.spent >= b.minPerWindow && b.spent*100 >= b.requests*b.percent {
+		return false
+	}
+	b.spent++
+	return true
+}
+
+// caller must hold the lock
+func (b *budget) resetWindowIfExpired() {
+	now := time.Now()
+	if now.Sub(b.windowStart) > b.window {
+		b.windowStart = now
+		b.requests = 0
+		b.spent = 0
+	}
+}
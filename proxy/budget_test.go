@@ -0,0 +1,30 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudget(t *testing.T) {
+	b := newBudget(20, 3, time.Hour)
+	// The minimum is allowed without requests
+	for i := 0; i < 3; i++ {
+		assert.True(t, b.acquire())
+	}
+	assert.False(t, b.acquire())
+
+	for i := 0; i < 20; i++ {
+		b.countRequest()
+	}
+	assert.True(t, b.acquire())
+	assert.False(t, b.acquire())
+
+	// A new window starts from an empty budget
+	b.window = 0
+	b.windowStart = time.Now().Add(-time.Second)
+	b.countRequest()
+	assert.Equal(t, uint(1), b.requests)
+	assert.Equal(t, uint(0), b.spent)
+}
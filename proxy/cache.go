@@ -0,0 +1,531 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+const (
+	defaultCacheMaxSize      = 64 * 1024 * 1024
+	defaultCacheMaxEntrySize = 1024 * 1024
+
+	cacheResultHit         = "hit"
+	cacheResultMiss        = "miss"
+	cacheResultRevalidated = "revalidated"
+)
+
+// responseCache is a LRU cache of the origin responses of an ingress rule, used to serve repeated requests for
+// static assets without reaching the origin. It implements a conservative subset of RFC 9111 for shared caches:
+// only GET requests without credentials are served from cache, and only responses that explicitly allow it and
+// don't vary on request headers are stored.
+type responseCache struct {
+	maxSize      uint64
+	maxEntrySize uint64
+	dir          string
+	// rule is the metrics label of the rule, which changes when the rule moves to another index
+	rule atomic.Pointer[string]
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	size    uint64
+	// dropped is set once the rule no longer uses the cache, so that the responses still being read aren't stored
+	dropped bool
+
+	// nowFunc is overridden in tests
+	nowFunc func() time.Time
+}
+
+type cacheEntry struct {
+	key        string
+	host       string
+	path       string
+	statusCode int
+	header     http.Header
+	// body is nil when the cache is backed by disk
+	body     []byte
+	size     uint64
+	storedAt time.Time
+	expires  time.Time
+}
+
+func newResponseCache(cfg config.CacheConfig, ruleNum int, log *zerolog.Logger) *responseCache {
+	c := &responseCache{
+		maxSize:      cfg.MaxSize,
+		maxEntrySize: cfg.MaxEntrySize,
+		dir:          cfg.Dir,
+		entries:      make(map[string]*list.Element),
+		lru:          list.New(),
+		nowFunc:      time.Now,
+	}
+	if c.maxSize == 0 {
+		c.maxSize = defaultCacheMaxSize
+	}
+	if c.maxEntrySize == 0 {
+		c.maxEntrySize = defaultCacheMaxEntrySize
+	}
+	c.setRule(ruleNum)
+	if c.maxEntrySize > c.maxSize {
+		c.maxEntrySize = c.maxSize
+	}
+	if c.dir != "" {
+		if err := os.MkdirAll(c.dir, 0o700); err != nil {
+			log.Err(err).Str("dir", c.dir).Msgf("Cannot create the cache directory of ingress rule %d, caching in memory instead", ruleNum)
+			c.dir = ""
+		}
+	}
+	cacheSize.WithLabelValues(c.label()).Set(0)
+	return c
+}
+
+func (c *responseCache) label() string {
+	return *c.rule.Load()
+}
+
+// setRule sets the index of the rule using the cache, returning the previous metrics label.
+func (c *responseCache) setRule(ruleNum int) string {
+	label := strconv.Itoa(ruleNum)
+	if previous := c.rule.Swap(&label); previous != nil {
+		return *previous
+	}
+	return ""
+}
+
+// drop removes every entry, and their files, once the rule no longer uses the cache.
+func (c *responseCache) drop() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dropped = true
+	for _, elem := range c.entries {
+		c.removeElement(elem, true)
+	}
+	cacheSize.DeleteLabelValues(c.label())
+}
+
+// ResponseCaches holds the response caches of the ingress rules. They outlive the proxies created on configuration
+// updates: a rule keeps its cache as long as its hostname, path, service and cache configuration don't change, even if
+// it moves to another index. The caches of the rules that changed or were removed are dropped, along with their files.
+type ResponseCaches struct {
+	lock   sync.Mutex
+	caches map[string]*responseCache
+}
+
+func NewResponseCaches() *ResponseCaches {
+	return &ResponseCaches{caches: make(map[string]*responseCache)}
+}
+
+// forRules returns the caches of the rules enabling it, keyed by rule index, reusing those of the previous rules.
+func (rc *ResponseCaches) forRules(rules []ingress.Rule, log *zerolog.Logger) map[int]*responseCache {
+	caches := make(map[int]*responseCache)
+	if rc == nil {
+		for i, rule := range rules {
+			if rule.Config.Cache.Enabled {
+				caches[i] = newResponseCache(rule.Config.Cache, i, log)
+			}
+		}
+		return caches
+	}
+
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	kept := make(map[string]*responseCache)
+	occurrences := make(map[string]int)
+	for i, rule := range rules {
+		if !rule.Config.Cache.Enabled {
+			continue
+		}
+		// Identical rules are told apart by their order
+		key := responseCacheKey(rule)
+		occurrences[key]++
+		key += "\x00" + strconv.Itoa(occurrences[key])
+
+		cache, ok := rc.caches[key]
+		if !ok {
+			cache = newResponseCache(rule.Config.Cache, i, log)
+		} else if previous := cache.setRule(i); previous != cache.label() {
+			cacheSize.DeleteLabelValues(previous)
+			cache.lock.Lock()
+			cacheSize.WithLabelValues(cache.label()).Set(float64(cache.size))
+			cache.lock.Unlock()
+		}
+		kept[key] = cache
+		caches[i] = cache
+	}
+	for key, cache := range rc.caches {
+		if kept[key] != cache {
+			cache.drop()
+		}
+	}
+	rc.caches = kept
+	return caches
+}
+
+// responseCacheKey identifies the cached responses of a rule across configuration updates.
+func responseCacheKey(rule ingress.Rule) string {
+	var path string
+	if rule.Path != nil {
+		path = rule.Path.String()
+	}
+	return strings.Join([]string{
+		rule.Hostname,
+		path,
+		rule.Service.String(),
+		strconv.FormatUint(rule.Config.Cache.MaxSize, 10),
+		strconv.FormatUint(rule.Config.Cache.MaxEntrySize, 10),
+		rule.Config.Cache.Dir,
+	}, "\x00")
+}
+
+// roundTrip serves the request from cache when possible, otherwise sends it to the origin with next and stores the
+// response if it's cacheable.
+func (c *responseCache) roundTrip(req *http.Request, next func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if !isCacheableRequest(req) {
+		return next(req)
+	}
+	// The origin service rewrites the request URL, so everything needed from it has to be captured first
+	key := req.Host + req.URL.RequestURI()
+	host, path := req.Host, req.URL.Path
+
+	entry, body, ok := c.get(key)
+	if ok && c.nowFunc().Before(entry.expires) && !parseCacheControl(req.Header).has("no-cache") {
+		cacheLookups.WithLabelValues(c.label(), cacheResultHit).Inc()
+		return c.response(entry, body, req), nil
+	}
+
+	if ok && entry.hasValidators() && !hasConditionals(req) {
+		condReq := req.Clone(req.Context())
+		if etag := entry.header.Get("ETag"); etag != "" {
+			condReq.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.header.Get("Last-Modified"); lastModified != "" {
+			condReq.Header.Set("If-Modified-Since", lastModified)
+		}
+		resp, err := next(condReq)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusNotModified {
+			_ = resp.Body.Close()
+			cacheLookups.WithLabelValues(c.label(), cacheResultRevalidated).Inc()
+			return c.response(c.refresh(entry, resp), body, req), nil
+		}
+		cacheLookups.WithLabelValues(c.label(), cacheResultMiss).Inc()
+		return c.maybeStore(key, host, path, resp), nil
+	}
+
+	cacheLookups.WithLabelValues(c.label(), cacheResultMiss).Inc()
+	resp, err := next(req)
+	if err != nil {
+		return nil, err
+	}
+	return c.maybeStore(key, host, path, resp), nil
+}
+
+// get returns a copy of the entry for key along with its body.
+func (c *responseCache) get(key string) (cacheEntry, []byte, bool) {
+	c.lock.Lock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.lock.Unlock()
+		return cacheEntry{}, nil, false
+	}
+	c.lru.MoveToFront(elem)
+	entry := *elem.Value.(*cacheEntry)
+	c.lock.Unlock()
+
+	if c.dir == "" {
+		return entry, entry.body, true
+	}
+	body, err := os.ReadFile(c.filePath(key))
+	if err != nil || uint64(len(body)) != entry.size {
+		c.remove(key)
+		return cacheEntry{}, nil, false
+	}
+	return entry, body, true
+}
+
+// maybeStore wraps the body of a cacheable response so that it's stored once it has been entirely read.
+func (c *responseCache) maybeStore(key, host, path string, resp *http.Response) *http.Response {
+	now := c.nowFunc()
+	lifetime, ok := storableLifetime(resp, now)
+	if !ok || (resp.ContentLength > 0 && uint64(resp.ContentLength) > c.maxEntrySize) {
+		return resp
+	}
+	entry := &cacheEntry{
+		key:        key,
+		host:       host,
+		path:       path,
+		statusCode: resp.StatusCode,
+		header:     resp.Header.Clone(),
+		storedAt:   now,
+		expires:    now.Add(lifetime),
+	}
+	resp.Body = &cachingBody{
+		ReadCloser: resp.Body,
+		limit:      c.maxEntrySize,
+		onComplete: func(body []byte) { c.put(entry, body) },
+	}
+	return resp
+}
+
+func (c *responseCache) put(entry *cacheEntry, body []byte) {
+	entry.size = uint64(len(body))
+	if c.dir == "" {
+		entry.body = body
+	} else if err := c.writeFile(entry.key, body); err != nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.dropped {
+		if c.dir != "" {
+			_ = os.Remove(c.filePath(entry.key))
+		}
+		return
+	}
+	if elem, ok := c.entries[entry.key]; ok {
+		c.removeElement(elem, false)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += entry.size
+	for c.size > c.maxSize {
+		c.removeElement(c.lru.Back(), true)
+	}
+	cacheSize.WithLabelValues(c.label()).Set(float64(c.size))
+}
+
+// refresh updates the freshness of an entry the origin revalidated, returning the updated copy.
+func (c *responseCache) refresh(entry cacheEntry, notModified *http.Response) cacheEntry {
+	header := entry.header.Clone()
+	for _, name := range []string{"Cache-Control", "Date", "ETag", "Expires", "Last-Modified"} {
+		if values := notModified.Header.Values(name); len(values) > 0 {
+			header[name] = values
+		}
+	}
+	now := c.nowFunc()
+	lifetime := freshnessLifetime(header, now)
+	entry.header = header
+	entry.storedAt = now
+	entry.expires = now.Add(lifetime)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.entries[entry.key]; ok {
+		stored := elem.Value.(*cacheEntry)
+		stored.header = entry.header
+		stored.storedAt = entry.storedAt
+		stored.expires = entry.expires
+	}
+	return entry
+}
+
+// purge removes the entries matching host and path prefix, an empty host matching every host. It returns the
+// number of removed entries.
+func (c *responseCache) purge(host, pathPrefix string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	purged := 0
+	for _, elem := range c.entries {
+		entry := elem.Value.(*cacheEntry)
+		if (host == "" || strings.EqualFold(entry.host, host)) && strings.HasPrefix(entry.path, pathPrefix) {
+			c.removeElement(elem, true)
+			purged++
+		}
+	}
+	cacheSize.WithLabelValues(c.label()).Set(float64(c.size))
+	return purged
+}
+
+func (c *responseCache) remove(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem, true)
+		cacheSize.WithLabelValues(c.label()).Set(float64(c.size))
+	}
+}
+
+// caller must hold the lock
+func (c *responseCache) removeElement(elem *list.Element, deleteFile bool) {
+	entry := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	if deleteFile && c.dir != "" {
+		_ = os.Remove(c.filePath(entry.key))
+	}
+}
+
+func (c *responseCache) filePath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+func (c *responseCache) writeFile(key string, body []byte) error {
+	tmp, err := os.CreateTemp(c.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(body); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.filePath(key))
+}
+
+// response builds the response served to the eyeball from a cache entry.
+func (c *responseCache) response(entry cacheEntry, body []byte, req *http.Request) *http.Response {
+	header := entry.header.Clone()
+	header.Set("Age", strconv.Itoa(int(c.nowFunc().Sub(entry.storedAt).Seconds())))
+	statusCode := entry.statusCode
+	if etag := header.Get("ETag"); etag != "" && req.Header.Get("If-None-Match") == etag {
+		statusCode = http.StatusNotModified
+		body = nil
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return &http.Response{
+		Status:        strconv.Itoa(statusCode) + " " + http.StatusText(statusCode),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func (e *cacheEntry) hasValidators() bool {
+	return e.header.Get("ETag") != "" || e.header.Get("Last-Modified") != ""
+}
+
+func isCacheableRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet || req.Header.Get("Authorization") != "" {
+		return false
+	}
+	return !parseCacheControl(req.Header).has("no-store")
+}
+
+func hasConditionals(req *http.Request) bool {
+	return req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+}
+
+// storableLifetime returns for how long a response is fresh, and false if it must not be stored.
+func storableLifetime(resp *http.Response, now time.Time) (time.Duration, bool) {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+	default:
+		return 0, false
+	}
+	cc := parseCacheControl(resp.Header)
+	if cc.has("no-store") || cc.has("private") || resp.Header.Get("Set-Cookie") != "" || resp.Header.Get("Vary") != "" {
+		return 0, false
+	}
+	lifetime := freshnessLifetime(resp.Header, now)
+	if cc.has("no-cache") {
+		lifetime = 0
+	}
+	// Responses without explicit freshness can only be stored if they can be revalidated
+	hasValidators := resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+	if lifetime <= 0 && !hasValidators {
+		return 0, false
+	}
+	return lifetime, true
+}
+
+// freshnessLifetime computes the freshness lifetime of a response from its headers.
+func freshnessLifetime(header http.Header, now time.Time) time.Duration {
+	cc := parseCacheControl(header)
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if val, ok := cc[directive]; ok {
+			seconds, err := strconv.Atoi(val)
+			if err != nil || seconds < 0 {
+				return 0
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		date := now
+		if d, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = d
+		}
+		return expiresAt.Sub(date)
+	}
+	return 0
+}
+
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, val, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name == "" {
+				continue
+			}
+			cc[strings.ToLower(name)] = strings.Trim(val, `"`)
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// cachingBody records the body of a response as it's streamed to the eyeball, and hands it to onComplete once
+// it has been entirely read. Bodies larger than limit are not recorded.
+type cachingBody struct {
+	io.ReadCloser
+	buf        bytes.Buffer
+	limit      uint64
+	overflow   bool
+	completed  bool
+	onComplete func(body []byte)
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.overflow {
+		if uint64(b.buf.Len()+n) > b.limit {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.overflow && !b.completed {
+		b.completed = true
+		b.onComplete(b.buf.Bytes())
+	}
+	return n, err
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/tracing"
+)
+
+func TestProxyCache(t *testing.T) {
+	var originHits atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originHits.Add(1)
+		switch r.URL.Path {
+		case "/static.js":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		case "/etag.css":
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		}
+		_, _ = fmt.Fprintf(w, "body of %s", r.URL.Path)
+	}))
+	defer origin.Close()
+
+	for _, dir := range []string{"", t.TempDir()} {
+		t.Run(fmt.Sprintf("dir=%q", dir), func(t *testing.T) {
+			originHits.Store(0)
+			proxy := newTestProxy(t, config.OriginRequestConfig{
+				Cache: &config.CacheConfig{Enabled: true, Dir: dir},
+			}, origin.URL)
+
+			get := func(path string) *mockHTTPRespWriter {
+				req, err := http.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+				require.NoError(t, err)
+				responseWriter := newMockHTTPRespWriter()
+				require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, proxy.log), false))
+				require.Equal(t, http.StatusOK, responseWriter.Code)
+				require.Equal(t, "body of "+path, responseWriter.Body.String())
+				return responseWriter
+			}
+
+			// Fresh responses are served from cache
+			get("/static.js")
+			resp := get("/static.js")
+			assert.Equal(t, int32(1), originHits.Load())
+			assert.NotEmpty(t, resp.Header().Get("Age"))
+
+			// Responses with validators are revalidated, and the cached body is served on 304
+			get("/etag.css")
+			get("/etag.css")
+			assert.Equal(t, int32(3), originHits.Load())
+
+			// Private responses are not stored
+			get("/private")
+			get("/private")
+			assert.Equal(t, int32(5), originHits.Load())
+
+			// Purged responses are fetched from the origin again
+			assert.Equal(t, 1, proxy.PurgeCache("example.com", "/static"))
+			get("/static.js")
+			assert.Equal(t, int32(6), originHits.Load())
+		})
+	}
+}
+
+func TestResponseCacheExpiresAndEvicts(t *testing.T) {
+	var originHits atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originHits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=10")
+		_, _ = fmt.Fprint(w, "0123456789")
+	}))
+	defer origin.Close()
+
+	proxy := newTestProxy(t, config.OriginRequestConfig{
+		Cache: &config.CacheConfig{Enabled: true, MaxSize: 15},
+	}, origin.URL)
+	cache := proxy.caches[0]
+	require.NotNil(t, cache)
+	now := time.Now()
+	cache.nowFunc = func() time.Time { return now }
+
+	get := func(path string) {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		require.NoError(t, err)
+		responseWriter := newMockHTTPRespWriter()
+		require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, proxy.log), false))
+		require.Equal(t, "0123456789", responseWriter.Body.String())
+	}
+
+	get("/a")
+	get("/a")
+	require.Equal(t, int32(1), originHits.Load())
+
+	now = now.Add(11 * time.Second)
+	get("/a")
+	require.Equal(t, int32(2), originHits.Load())
+
+	// Storing /b exceeds the cache size, evicting /a
+	get("/b")
+	get("/a")
+	require.Equal(t, int32(4), originHits.Load())
+	require.Equal(t, uint64(10), cache.size)
+}
+
+func TestResponseCachesReusedAcrossUpdates(t *testing.T) {
+	dir := t.TempDir()
+	parse := func(rules ...config.UnvalidatedIngressRule) []ingress.Rule {
+		ing, err := ingress.ParseIngress(&config.Configuration{TunnelID: t.Name(), Ingress: rules})
+		require.NoError(t, err)
+		return ing.Rules
+	}
+	cached := config.OriginRequestConfig{Cache: &config.CacheConfig{Enabled: true, Dir: dir}}
+	static := config.UnvalidatedIngressRule{Hostname: "static.example.com", Service: "http://localhost:8080", OriginRequest: cached}
+	api := config.UnvalidatedIngressRule{Hostname: "api.example.com", Service: "http://localhost:8081", OriginRequest: cached}
+	catchAll := config.UnvalidatedIngressRule{Service: "http_status:404"}
+
+	log := zerolog.Nop()
+	caches := NewResponseCaches()
+	first := caches.forRules(parse(static, api, catchAll), &log)
+	require.Len(t, first, 2)
+	first[1].put(&cacheEntry{key: "api.example.com/", host: "api.example.com", path: "/"}, []byte("api"))
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	// The cache of a rule moving to another index is kept
+	second := caches.forRules(parse(api, catchAll), &log)
+	require.Len(t, second, 1)
+	assert.Same(t, first[1], second[0])
+	assert.Equal(t, "0", second[0].label())
+
+	// The cache of a changed rule is dropped along with its files
+	api.Service = "http://localhost:8082"
+	third := caches.forRules(parse(api, catchAll), &log)
+	assert.NotSame(t, second[0], third[0])
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+const (
+	defaultBreakerOpenDuration = 30 * time.Second
+	defaultBreakerErrorStatus  = http.StatusServiceUnavailable
+	defaultBreakerContentType  = "text/plain; charset=utf-8"
+)
+
+// errCircuitOpen is returned instead of sending a request to an origin whose circuit breaker is open.
+var errCircuitOpen = errors.New("origin circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+// circuitBreaker fast-fails requests to an origin after it failed FailureThreshold consecutive times. Once
+// OpenDuration elapsed, a single request is let through to probe the origin: its success closes the circuit,
+// its failure opens it again.
+type circuitBreaker struct {
+	config config.CircuitBreakerConfig
+	rule   string
+
+	lock             sync.Mutex
+	state            breakerState
+	consecutiveFails uint
+	openedAt         time.Time
+	probeInFlight    bool
+
+	// nowFunc is overridden in tests
+	nowFunc func() time.Time
+}
+
+func newCircuitBreaker(cfg config.CircuitBreakerConfig, ruleNum int) *circuitBreaker {
+	if cfg.OpenDuration.Duration <= 0 {
+		cfg.OpenDuration.Duration = defaultBreakerOpenDuration
+	}
+	if cfg.ErrorStatus == 0 {
+		cfg.ErrorStatus = defaultBreakerErrorStatus
+	}
+	if cfg.ErrorContentType == "" {
+		cfg.ErrorContentType = defaultBreakerContentType
+	}
+	cb := &circuitBreaker{
+		config:  cfg,
+		rule:    strconv.Itoa(ruleNum),
+		nowFunc: time.Now,
+	}
+	circuitBreakerState.WithLabelValues(cb.rule).Set(float64(breakerClosed))
+	return cb
+}
+
+// allow reports whether a request can be proxied to the origin.
+func (cb *circuitBreaker) allow() bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	switch cb.state {
+	case breakerOpen:
+		if cb.nowFunc().Sub(cb.openedAt) < cb.config.OpenDuration.Duration {
+			circuitBreakerRejections.WithLabelValues(cb.rule).Inc()
+			return false
+		}
+		cb.setState(breakerHalfOpen)
+		cb.probeInFlight = true
+		return true
+	case breakerHalfOpen:
+		if cb.probeInFlight {
+			circuitBreakerRejections.WithLabelValues(cb.rule).Inc()
+			return false
+		}
+		cb.probeInFlight = true
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of a request that was allowed through.
+func (cb *circuitBreaker) record(success bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	cb.probeInFlight = false
+	if success {
+		cb.consecutiveFails = 0
+		cb.setState(breakerClosed)
+		return
+	}
+	cb.consecutiveFails++
+	if cb.state == breakerHalfOpen || cb.consecutiveFails >= cb.config.FailureThreshold {
+		cb.openedAt = cb.nowFunc()
+		cb.setState(breakerOpen)
+	}
+}
+
+// abandon releases the probe slot of a request that ended without telling anything about the origin health,
+// e.g. because the eyeball went away.
+func (cb *circuitBreaker) abandon() {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	cb.probeInFlight = false
+}
+
+// caller must hold the lock
+func (cb *circuitBreaker) setState(state breakerState) {
+	cb.state = state
+	circuitBreakerState.WithLabelValues(cb.rule).Set(float64(state))
+}
+
+// writeErrorResponse writes the configured error page to the eyeball.
+func (cb *circuitBreaker) writeErrorResponse(w connection.ResponseWriter) error {
+	headers := http.Header{}
+	headers.Set("Content-Type", cb.config.ErrorContentType)
+	headers.Set("Content-Length", strconv.Itoa(len(cb.config.ErrorBody)))
+	if err := w.WriteRespHeaders(cb.config.ErrorStatus, headers); err != nil {
+		return err
+	}
+	if cb.config.ErrorBody != "" {
+		_, err := w.Write([]byte(cb.config.ErrorBody))
+		return err
+	}
+	return nil
+}
+
+// isOriginFailure reports whether the outcome of a round trip means the origin is unavailable.
+func isOriginFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudflare/cloudflared/connection"
+	cfdflow "github.com/cloudflare/cloudflared/flow"
+)
+
+// warpRoutingRoute is the route of the flow records of the private network flows, which don't match ingress rules.
+const warpRoutingRoute = "warp-routing"
+
+// flowCountingReadWriteAcker counts the traffic of a stream for its flow record. Reads from the eyeball are sent to
+// the origin, and writes to the eyeball come from the origin.
+type flowCountingReadWriteAcker struct {
+	connection.ReadWriteAcker
+	counters *cfdflow.Counters
+}
+
+func (c *flowCountingReadWriteAcker) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteAcker.Read(p)
+	if n > 0 {
+		c.counters.ToOrigin(n)
+	}
+	return n, err
+}
+
+func (c *flowCountingReadWriteAcker) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteAcker.Write(p)
+	if n > 0 {
+		c.counters.FromOrigin(n)
+	}
+	return n, err
+}
+
+// emitStreamRecord emits the flow record of a TCP stream once it's done.
+func emitStreamRecord(ctx context.Context, record cfdflow.Record, counters *cfdflow.Counters) {
+	record.Kind = cfdflow.KindTCP
+	record.Duration = time.Since(record.Start)
+	record.CloseReason = cfdflow.CloseCompleted
+	if ctx.Err() != nil {
+		record.CloseReason = cfdflow.CloseConnection
+	}
+	counters.Fill(&record)
+	// Streams don't have packets, the counts of reads and writes aren't meaningful
+	record.PacketsToOrigin, record.PacketsFromOrigin = 0, 0
+	cfdflow.Emit(record)
+}
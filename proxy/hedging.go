@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/recovery"
+)
+
+const (
+	defaultHedgeBudgetPercent = 10
+	hedgeBudgetWindow         = 10 * time.Second
+	// HedgeHeader is set on the hedged copies of the requests, for the origin to tell them apart.
+	HedgeHeader = "Cf-Cloudflared-Hedge"
+
+	hedgeResultSent      = "sent"
+	hedgeResultWon       = "won"
+	hedgeResultExhausted = "budget_exhausted"
+)
+
+// hedger sends a copy of the idempotent requests the origin is slow to respond to, serving whichever response
+// arrives first. Hedged requests are bounded by a budget so that a slow origin doesn't get twice the load.
+type hedger struct {
+	delay  time.Duration
+	budget *budget
+	rule   string
+}
+
+func newHedger(cfg config.HedgingConfig, ruleNum int) *hedger {
+	budgetPercent := cfg.BudgetPercent
+	if budgetPercent == 0 {
+		budgetPercent = defaultHedgeBudgetPercent
+	}
+	return &hedger{
+		delay:  cfg.Delay.Duration,
+		budget: newBudget(budgetPercent, 0, hedgeBudgetWindow),
+		rule:   strconv.Itoa(ruleNum),
+	}
+}
+
+// acquireHedge takes a hedged request from the budget, returning false if the budget is exhausted.
+func (h *hedger) acquireHedge() bool {
+	if !h.budget.acquire() {
+		hedgedRequests.WithLabelValues(h.rule, hedgeResultExhausted).Inc()
+		return false
+	}
+	hedgedRequests.WithLabelValues(h.rule, hedgeResultSent).Inc()
+	return true
+}
+
+// hedgedOrigin hedges the requests sent to an origin.
+type hedgedOrigin struct {
+	origin ingress.HTTPOriginProxy
+	hedger *hedger
+}
+
+func (o hedgedOrigin) RoundTrip(req *http.Request) (*http.Response, error) {
+	return o.hedger.roundTrip(o.origin, req)
+}
+
+type hedgeAttempt struct {
+	resp   *http.Response
+	err    error
+	hedged bool
+}
+
+// roundTrip sends the request to the origin, and a copy of it if the response headers don't arrive within the delay.
+// The first successful response is returned, the other request is canceled.
+func (h *hedger) roundTrip(originProxy ingress.HTTPOriginProxy, req *http.Request) (*http.Response, error) {
+	h.budget.countRequest()
+	if !isRetryable(req) {
+		return originProxy.RoundTrip(req)
+	}
+
+	attempts := make(chan hedgeAttempt, 2)
+	// Each attempt gets its own copy of the request, since origins rewrite its URL and headers
+	send := func(req *http.Request, hedged bool) context.CancelFunc {
+		ctx, cancel := context.WithCancel(req.Context())
+		attempt := req.Clone(ctx)
+		if hedged {
+			attempt.Header.Set(HedgeHeader, "1")
+		}
+		go func() {
+			var (
+				resp *http.Response
+				err  error
+			)
+			// The request waits for each attempt, so a panic must fail the attempt rather than lose it
+			defer func() {
+				if r := recover(); r != nil {
+					err = recovery.Error(r, recovery.SubsystemProxy, recovery.NoConnIndex)
+				}
+				attempts <- hedgeAttempt{resp: resp, err: err, hedged: hedged}
+			}()
+			resp, err = originProxy.RoundTrip(attempt)
+		}()
+		return cancel
+	}
+	cancels := map[bool]context.CancelFunc{false: send(req, false)}
+
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+	var (
+		pending = 1
+		failed  hedgeAttempt
+	)
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if h.acquireHedge() {
+				cancels[true] = send(req, true)
+				pending++
+			}
+		case attempt := <-attempts:
+			pending--
+			if attempt.err != nil {
+				failed = attempt
+				continue
+			}
+			if attempt.hedged {
+				hedgedRequests.WithLabelValues(h.rule, hedgeResultWon).Inc()
+			}
+			for hedged, cancel := range cancels {
+				if hedged != attempt.hedged {
+					cancel()
+				}
+			}
+			if pending > 0 {
+				go discardAttempts(attempts, pending)
+			}
+			attempt.resp.Body = &cancelOnCloseBody{ReadCloser: attempt.resp.Body, cancel: cancels[attempt.hedged]}
+			return attempt.resp, nil
+		}
+	}
+	for _, cancel := range cancels {
+		cancel()
+	}
+	return nil, failed.err
+}
+
+// discardAttempts closes the responses of the requests that lost the race.
+func discardAttempts(attempts <-chan hedgeAttempt, pending int) {
+	for ; pending > 0; pending-- {
+		if attempt := <-attempts; attempt.err == nil {
+			_ = attempt.resp.Body.Close()
+		}
+	}
+}
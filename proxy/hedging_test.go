@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/tracing"
+)
+
+func TestProxyHedging(t *testing.T) {
+	var requests, canceled atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get(HedgeHeader) == "" && r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+				canceled.Add(1)
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+		_, _ = w.Write([]byte(r.Header.Get(HedgeHeader)))
+	}))
+	defer origin.Close()
+
+	proxy := newTestProxy(t, config.OriginRequestConfig{
+		Hedging: &config.HedgingConfig{Delay: config.CustomDuration{Duration: 50 * time.Millisecond}, BudgetPercent: 100},
+	}, origin.URL)
+	require.Contains(t, proxy.hedgers, 0)
+
+	proxyRequest := func(method, path, body string) *mockHTTPRespWriter {
+		req, err := http.NewRequest(method, "http://example.com"+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if body == "" {
+			req.Body = http.NoBody
+		}
+		responseWriter := newMockHTTPRespWriter()
+		require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, proxy.log), false))
+		return responseWriter
+	}
+
+	// The hedged request responds first, and the slow one is canceled
+	start := time.Now()
+	responseWriter := proxyRequest(http.MethodGet, "/slow", "")
+	assert.Equal(t, http.StatusOK, responseWriter.Code)
+	assert.Equal(t, "1", responseWriter.Body.String())
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, int32(2), requests.Load())
+	assert.Eventually(t, func() bool { return canceled.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// Fast responses aren't hedged
+	responseWriter = proxyRequest(http.MethodGet, "/fast", "")
+	assert.Equal(t, "", responseWriter.Body.String())
+	assert.Equal(t, int32(3), requests.Load())
+
+	// Requests with a body can't be sent twice
+	responseWriter = proxyRequest(http.MethodPost, "/fast", "hello")
+	assert.Equal(t, http.StatusOK, responseWriter.Code)
+	assert.Equal(t, int32(4), requests.Load())
+}
+
+type panickingOrigin struct{}
+
+func (panickingOrigin) RoundTrip(*http.Request) (*http.Response, error) {
+	panic("boom")
+}
+
+func TestHedgingOriginPanic(t *testing.T) {
+	h := newHedger(config.HedgingConfig{Delay: config.CustomDuration{Duration: time.Hour}}, 0)
+	req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	_, err := h.roundTrip(panickingOrigin{}, req)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "boom")
+}
+
+func TestHedgeBudget(t *testing.T) {
+	h := newHedger(config.HedgingConfig{Delay: config.CustomDuration{Duration: time.Millisecond}}, 0)
+	assert.Equal(t, uint(defaultHedgeBudgetPercent), h.budget.percent)
+	for i := 0; i < 20; i++ {
+		h.budget.countRequest()
+	}
+	assert.True(t, h.acquireHedge())
+	assert.True(t, h.acquireHedge())
+	assert.False(t, h.acquireHedge())
+}
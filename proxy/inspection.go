@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflared/inspect"
+)
+
+// inspection collects the event of an inspected request while it's proxied. Methods are no-ops on a nil
+// inspection, which is what startInspection returns for requests that aren't inspected.
+type inspection struct {
+	pipeline       *inspect.Pipeline
+	event          *inspect.Event
+	start          time.Time
+	requestSample  *sampledBody
+	responseSample *sampledBody
+}
+
+func (p *Proxy) startInspection(req *http.Request, ruleNum int) *inspection {
+	pipeline, ok := p.inspections[ruleNum]
+	if !ok || !pipeline.Sampled() {
+		return nil
+	}
+	ins := &inspection{
+		pipeline: pipeline,
+		event: &inspect.Event{
+			Rule:          ruleNum,
+			Hostname:      req.Host,
+			Method:        req.Method,
+			Path:          req.URL.Path,
+			RequestHeader: req.Header.Clone(),
+		},
+		start: time.Now(),
+	}
+	if limit := pipeline.SampleBytes(); limit > 0 && req.Body != nil && req.Body != http.NoBody {
+		ins.requestSample = &sampledBody{ReadCloser: req.Body, limit: limit}
+		req.Body = ins.requestSample
+	}
+	return ins
+}
+
+func (ins *inspection) observeResponse(resp *http.Response) {
+	if ins == nil {
+		return
+	}
+	ins.event.StatusCode = resp.StatusCode
+	ins.event.ResponseHeader = resp.Header.Clone()
+	if limit := ins.pipeline.SampleBytes(); limit > 0 {
+		ins.responseSample = &sampledBody{ReadCloser: resp.Body, limit: limit}
+		resp.Body = ins.responseSample
+	}
+}
+
+// finish hands the event to the inspectors.
+func (ins *inspection) finish(err error) {
+	if ins == nil {
+		return
+	}
+	ins.event.Duration = time.Since(ins.start)
+	ins.event.RequestSample = ins.requestSample.sample()
+	ins.event.ResponseSample = ins.responseSample.sample()
+	if err != nil {
+		ins.event.Error = err.Error()
+	}
+	inspect.DefaultDispatcher().Submit(ins.pipeline, ins.event)
+}
+
+// sampledBody records the first limit bytes read from a body.
+type sampledBody struct {
+	io.ReadCloser
+	limit int
+
+	// The request body is read by the transport, possibly while the event is being finished
+	lock sync.Mutex
+	buf  []byte
+}
+
+func (b *sampledBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.lock.Lock()
+	if remaining := b.limit - len(b.buf); remaining > 0 {
+		b.buf = append(b.buf, p[:min(n, remaining)]...)
+	}
+	b.lock.Unlock()
+	return n, err
+}
+
+func (b *sampledBody) sample() []byte {
+	if b == nil {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]byte(nil), b.buf...)
+}
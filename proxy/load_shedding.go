@@ -0,0 +1,19 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+// shedRetryAfter is the Retry-After, in seconds, of the requests shed while the memory used exceeds the soft limit
+const shedRetryAfter = "5"
+
+// writeShedResponse rejects a request of a low priority rule while the connector sheds load.
+func writeShedResponse(w connection.ResponseWriter) error {
+	headers := http.Header{}
+	headers.Set("Content-Length", "0")
+	headers.Set("Cache-Control", "no-store")
+	headers.Set("Retry-After", shedRetryAfter)
+	return w.WriteRespHeaders(http.StatusServiceUnavailable, headers)
+}
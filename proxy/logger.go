@@ -14,7 +14,9 @@ import (
 const (
 	logFieldCFRay         = "cfRay"
 	logFieldLBProbe       = "lbProbe"
-	logFieldRule          = "ingressRule"
+	logFieldRule          = management.RuleKey
+	logFieldHost          = management.HostKey
+	logFieldStatus        = management.StatusKey
 	logFieldOriginService = "originService"
 	logFieldConnIndex     = "connIndex"
 	logFieldDestAddr      = "destAddr"
@@ -39,6 +41,7 @@ func newHTTPLogger(logger *zerolog.Logger, connIndex uint8, req *http.Request, r
 		ctx.Bool(logFieldLBProbe, lbProbe)
 	}
 	return ctx.
+		Str(logFieldHost, req.Host).
 		Str(logFieldOriginService, serviceName).
 		Interface(logFieldRule, rule).
 		Logger()
@@ -59,7 +62,6 @@ func newTCPLogger(logger *zerolog.Logger, req *connection.TCPRequest) zerolog.Lo
 // logHTTPRequest logs a Debug message with the corresponding HTTP request details from the eyeball.
 func logHTTPRequest(logger *zerolog.Logger, r *http.Request) {
 	logger.Debug().
-		Str("host", r.Host).
 		Str("path", r.URL.Path).
 		Interface("headers", r.Header).
 		Int64("content-length", r.ContentLength).
@@ -70,6 +72,7 @@ func logHTTPRequest(logger *zerolog.Logger, r *http.Request) {
 func logOriginHTTPResponse(logger *zerolog.Logger, resp *http.Response) {
 	responseByCode.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
 	logger.Debug().
+		Int(logFieldStatus, resp.StatusCode).
 		Int64("content-length", resp.ContentLength).
 		Msgf("%s", resp.Status)
 }
@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+const defaultMaintenanceContentType = "text/html; charset=utf-8"
+
+// Maintenance holds the maintenance toggles set through the management and control APIs. They outlive the proxies
+// created on configuration updates, except for the toggles of individual rules, which are cleared when the ingress
+// rules change since rules are identified by their index.
+//
+// A rule is in maintenance if it's toggled on, or if it isn't toggled off and either the global toggle or the
+// maintenance configuration of the rule is on.
+type Maintenance struct {
+	lock   sync.RWMutex
+	global bool
+	rules  map[int]bool
+}
+
+func NewMaintenance() *Maintenance {
+	return &Maintenance{rules: make(map[int]bool)}
+}
+
+// SetGlobal puts all the rules in maintenance, except those toggled off.
+func (m *Maintenance) SetGlobal(enabled bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.global = enabled
+}
+
+// SetRule toggles the maintenance of the rule at ruleNum, or clears its toggle when enabled is nil so that the global
+// toggle and the configuration apply again.
+func (m *Maintenance) SetRule(ruleNum int, enabled *bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if enabled == nil {
+		delete(m.rules, ruleNum)
+	} else {
+		m.rules[ruleNum] = *enabled
+	}
+}
+
+// ClearRules clears the toggles of the individual rules, when the ingress rules change, returning how many were set.
+func (m *Maintenance) ClearRules() int {
+	if m == nil {
+		return 0
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	cleared := len(m.rules)
+	clear(m.rules)
+	return cleared
+}
+
+// MaintenanceState is the state of the maintenance toggles, as served by the maintenance API.
+type MaintenanceState struct {
+	Global bool         `json:"global"`
+	Rules  map[int]bool `json:"rules"`
+}
+
+func (m *Maintenance) State() MaintenanceState {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	state := MaintenanceState{Global: m.global, Rules: make(map[int]bool, len(m.rules))}
+	for ruleNum, enabled := range m.rules {
+		state.Rules[ruleNum] = enabled
+	}
+	return state
+}
+
+// inMaintenance returns whether the user-defined rule at ruleNum, configured with cfg, is in maintenance.
+func (m *Maintenance) inMaintenance(ruleNum int, cfg config.MaintenanceConfig) bool {
+	// Internal rules, such as the management service, are never in maintenance
+	if ruleNum < 0 {
+		return false
+	}
+	if m == nil {
+		return cfg.Enabled
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if enabled, ok := m.rules[ruleNum]; ok {
+		return enabled
+	}
+	return m.global || cfg.Enabled
+}
+
+// writeMaintenanceResponse writes the maintenance response of the rule to the eyeball.
+func writeMaintenanceResponse(w connection.ResponseWriter, cfg config.MaintenanceConfig, ruleNum int) error {
+	maintenanceResponses.WithLabelValues(strconv.Itoa(ruleNum)).Inc()
+	status := cfg.Status
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	contentType := cfg.ContentType
+	if contentType == "" {
+		contentType = defaultMaintenanceContentType
+	}
+	headers := http.Header{}
+	headers.Set("Content-Type", contentType)
+	headers.Set("Content-Length", strconv.Itoa(len(cfg.Body)))
+	headers.Set("Cache-Control", "no-store")
+	if cfg.RetryAfter.Duration > 0 {
+		headers.Set("Retry-After", strconv.Itoa(int(math.Ceil(cfg.RetryAfter.Seconds()))))
+	}
+	if err := w.WriteRespHeaders(status, headers); err != nil {
+		return err
+	}
+	if cfg.Body != "" {
+		_, err := w.Write([]byte(cfg.Body))
+		return err
+	}
+	return nil
+}
+
+// maintenanceUpdate toggles the global maintenance, or the maintenance of a rule when Rule is set. Enabled is null to
+// clear the toggle of a rule.
+type maintenanceUpdate struct {
+	Rule    *int  `json:"rule"`
+	Enabled *bool `json:"enabled"`
+}
+
+// MaintenanceHandler serves the maintenance toggles of m, to read them with GET and change them with PUT, e.g.
+// {"enabled": true} to put every rule in maintenance or {"rule": 2, "enabled": false} to take the rule at index 2
+// out of it.
+func MaintenanceHandler(m *Maintenance, log *zerolog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var update maintenanceUpdate
+			if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+				writeMaintenanceError(w, err)
+				return
+			}
+			switch {
+			case update.Rule == nil && update.Enabled == nil:
+				writeMaintenanceError(w, errors.New("enabled is required to toggle the global maintenance"))
+				return
+			case update.Rule == nil:
+				m.SetGlobal(*update.Enabled)
+				log.Info().Bool("enabled", *update.Enabled).Msg("Global maintenance toggled")
+			case *update.Rule < 0:
+				writeMaintenanceError(w, errors.New("rule must be the index of an ingress rule"))
+				return
+			default:
+				m.SetRule(*update.Rule, update.Enabled)
+				log.Info().Int("ingressRule", *update.Rule).Interface("enabled", update.Enabled).Msg("Maintenance of the ingress rule toggled")
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(m.State())
+	})
+}
+
+func writeMaintenanceError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/tracing"
+)
+
+func TestProxyMaintenance(t *testing.T) {
+	var originHits atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originHits.Add(1)
+	}))
+	defer origin.Close()
+
+	proxy := newTestProxy(t, config.OriginRequestConfig{
+		Maintenance: &config.MaintenanceConfig{
+			Body:        `{"error": "down for maintenance"}`,
+			ContentType: "application/json",
+			RetryAfter:  config.CustomDuration{Duration: 90 * time.Second},
+		},
+	}, origin.URL)
+	proxy.maintenance = NewMaintenance()
+
+	proxyRequest := func() *mockHTTPRespWriter {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		require.NoError(t, err)
+		responseWriter := newMockHTTPRespWriter()
+		require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, proxy.log), false))
+		return responseWriter
+	}
+
+	assert.Equal(t, http.StatusOK, proxyRequest().Code)
+	require.Equal(t, int32(1), originHits.Load())
+
+	proxy.maintenance.SetGlobal(true)
+	responseWriter := proxyRequest()
+	assert.Equal(t, http.StatusServiceUnavailable, responseWriter.Code)
+	assert.Equal(t, `{"error": "down for maintenance"}`, responseWriter.Body.String())
+	assert.Equal(t, "application/json", responseWriter.Header().Get("Content-Type"))
+	assert.Equal(t, "90", responseWriter.Header().Get("Retry-After"))
+	require.Equal(t, int32(1), originHits.Load())
+
+	// The toggle of the rule takes precedence over the global one
+	disabled := false
+	proxy.maintenance.SetRule(0, &disabled)
+	assert.Equal(t, http.StatusOK, proxyRequest().Code)
+	require.Equal(t, int32(2), originHits.Load())
+	proxy.maintenance.SetRule(0, nil)
+	proxy.maintenance.SetGlobal(false)
+	assert.Equal(t, http.StatusOK, proxyRequest().Code)
+}
+
+func TestMaintenanceFromConfiguration(t *testing.T) {
+	maintenance := NewMaintenance()
+	cfg := config.MaintenanceConfig{Enabled: true}
+	assert.True(t, maintenance.inMaintenance(0, cfg))
+	assert.False(t, maintenance.inMaintenance(-1, cfg), "internal rules are never in maintenance")
+	maintenance.SetGlobal(true)
+	assert.False(t, maintenance.inMaintenance(-1, cfg), "internal rules are never in maintenance")
+
+	disabled := false
+	maintenance.SetRule(0, &disabled)
+	assert.False(t, maintenance.inMaintenance(0, cfg))
+	assert.Equal(t, 1, maintenance.ClearRules())
+	assert.True(t, maintenance.inMaintenance(0, cfg))
+
+	var noMaintenance *Maintenance
+	assert.True(t, noMaintenance.inMaintenance(0, cfg))
+	assert.False(t, noMaintenance.inMaintenance(0, config.MaintenanceConfig{}))
+}
+
+func TestMaintenanceHandler(t *testing.T) {
+	log := zerolog.Nop()
+	maintenance := NewMaintenance()
+	handler := MaintenanceHandler(maintenance, &log)
+	request := func(method, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, "/maintenance", strings.NewReader(body)))
+		return w
+	}
+
+	w := request(http.MethodPut, `{"enabled": true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"global": true, "rules": {}}`, w.Body.String())
+
+	w = request(http.MethodPut, `{"rule": 2, "enabled": false}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"global": true, "rules": {"2": false}}`, w.Body.String())
+
+	w = request(http.MethodPut, `{"rule": 2, "enabled": null}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"global": true, "rules": {}}`, w.Body.String())
+
+	w = request(http.MethodGet, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"global": true, "rules": {}}`, w.Body.String())
+
+	for _, invalid := range []string{`{}`, `{"rule": -1, "enabled": true}`, `{"enabled": "yes"}`} {
+		assert.Equal(t, http.StatusBadRequest, request(http.MethodPut, invalid).Code, invalid)
+	}
+	assert.Equal(t, http.StatusMethodNotAllowed, request(http.MethodPost, `{"enabled": true}`).Code)
+}
@@ -2,7 +2,9 @@ package proxy
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
 
+	"github.com/cloudflare/cloudflared/cfio"
 	"github.com/cloudflare/cloudflared/connection"
 )
 
@@ -76,6 +78,199 @@ var (
 			Help:      "Total count of failure to establish and acknowledge connections",
 		},
 	)
+	originRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "origin_retries",
+			Help:      "Total count of requests retried towards the origin, by ingress rule",
+		},
+		[]string{"rule"},
+	)
+	originRetriesBudgetExhausted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "origin_retries_budget_exhausted",
+			Help:      "Total count of retries not attempted because the retry budget of the ingress rule was exhausted",
+		},
+		[]string{"rule"},
+	)
+	hedgedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "origin_hedged_requests",
+			Help:      "Total count of hedged requests towards the origin, by ingress rule and result: sent, won when the hedged request responded first, or budget_exhausted",
+		},
+		[]string{"rule", "result"},
+	)
+	spnegoAuthentications = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "origin_spnego_authentications",
+			Help:      "Total count of requests authenticated to the origin with SPNEGO, by ingress rule and result: accepted, rejected by the origin, or token_error when no token could be obtained",
+		},
+		[]string{"rule", "result"},
+	)
+	circuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "circuit_breaker_state",
+			Help:      "State of the origin circuit breaker by ingress rule: 0 closed, 1 half-open, 2 open",
+		},
+		[]string{"rule"},
+	)
+	circuitBreakerRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "circuit_breaker_rejections",
+			Help:      "Total count of requests fast-failed because the circuit breaker of the ingress rule was open",
+		},
+		[]string{"rule"},
+	)
+	rateLimitedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "rate_limited_requests",
+			Help:      "Total count of requests throttled with a 429 by the rate limit of the ingress rule",
+		},
+		[]string{"rule"},
+	)
+	maintenanceResponses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "maintenance_responses",
+			Help:      "Total count of requests served the maintenance response of the ingress rule instead of being proxied",
+		},
+		[]string{"rule"},
+	)
+	mirroredRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "mirrored_requests",
+			Help:      "Total count of the requests of each ingress rule mirrored to its secondary origin, by result: sent, failed when the secondary origin can't be reached or responds with a 5xx, dropped when too many mirrored requests are in flight, or skipped when the body is too large",
+		},
+		[]string{"rule", "result"},
+	)
+	requestLimitRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "request_limit_rejections",
+			Help:      "Total count of requests rejected by the request limits of the ingress rule, by reason: body_size, header_size or body_timeout",
+		},
+		[]string{"rule", "reason"},
+	)
+	originCompressionBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "origin_compression_bytes",
+			Help:      "Bytes of the bodies compressed for or decompressed from the origin of each ingress rule, by direction and before or after compression",
+		},
+		[]string{"rule", "direction", "stage"},
+	)
+	originCompressionSeconds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "origin_compression_seconds",
+			Help:      "Time spent compressing request bodies for and decompressing response bodies from the origin of each ingress rule",
+		},
+		[]string{"rule", "direction"},
+	)
+	cacheLookups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "cache_lookups",
+			Help:      "Total count of response cache lookups by ingress rule and result (hit, miss, revalidated)",
+		},
+		[]string{"rule", "result"},
+	)
+	cacheSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "cache_size_bytes",
+			Help:      "Size of the response bodies held in the cache of each ingress rule",
+		},
+		[]string{"rule"},
+	)
+	websocketsClosed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "websockets_closed",
+			Help:      "Total count of websocket connections closed by cloudflared, by ingress rule and reason (frame_too_large, message_too_large, idle)",
+		},
+		[]string{"rule", "reason"},
+	)
+	// The origin latencies are in the tunnel subsystem, with the haConnections and the other metrics of the tunnel.
+	originConnectDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "origin_connect_duration_seconds",
+			Help:      "Time it takes to dial new connections to the HTTP origin of each ingress rule",
+			Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+		[]string{"rule"},
+	)
+	originTTFB = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "origin_ttfb_seconds",
+			Help:      "Time until the HTTP origin of each ingress rule answers with the headers of its response, dials and retries included",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		},
+		[]string{"rule"},
+	)
+	originRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "origin_request_duration_seconds",
+			Help:      "Time it takes to proxy HTTP requests to the origin of each ingress rule, until the response body is sent to the edge",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		},
+		[]string{"rule"},
+	)
+	originResponses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "origin_responses",
+			Help:      "Count of the responses of the HTTP origin of each ingress rule, by status code",
+		},
+		[]string{"rule", "status_code"},
+	)
+	copyBufferedBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "copy_buffered_bytes",
+			Help:      "Bytes read from the edge or the origins of each ingress rule, or of the private network flows, that the proxy didn't write to the other side yet, when backpressure is enabled",
+		},
+		[]string{"rule"},
+	)
+	copyBackpressurePauses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "copy_backpressure_pauses",
+			Help:      "Count of the times the proxy stopped reading from the edge and the origins of each ingress rule, or of the private network flows, because their buffered bytes reached the high watermark",
+		},
+		[]string{"rule"},
+	)
 )
 
 func init() {
@@ -88,6 +283,27 @@ func init() {
 		totalTCPSessions,
 		connectLatency,
 		connectStreamErrors,
+		originRetries,
+		originRetriesBudgetExhausted,
+		hedgedRequests,
+		spnegoAuthentications,
+		circuitBreakerState,
+		circuitBreakerRejections,
+		rateLimitedRequests,
+		maintenanceResponses,
+		mirroredRequests,
+		requestLimitRejections,
+		originCompressionBytes,
+		originCompressionSeconds,
+		cacheLookups,
+		cacheSize,
+		websocketsClosed,
+		originConnectDuration,
+		originTTFB,
+		originRequestDuration,
+		originResponses,
+		copyBufferedBytes,
+		copyBackpressurePauses,
 	)
 }
 
@@ -110,3 +326,14 @@ func decrementTCPConcurrentRequests() {
 	decrementConcurrentRequests()
 	activeTCPSessions.Dec()
 }
+
+// newCopyBackpressure returns the budget of the copies of an ingress rule, or of the private network flows, reporting
+// to the metrics labelled with rule. It returns nil if the copies can't be limited.
+func newCopyBackpressure(watermarks cfio.Watermarks, rule string, log *zerolog.Logger) *cfio.Backpressure {
+	b, err := cfio.NewBackpressure(watermarks, copyBufferedBytes.WithLabelValues(rule), copyBackpressurePauses.WithLabelValues(rule))
+	if err != nil {
+		log.Err(err).Msgf("Copies of %s won't be limited", rule)
+		return nil
+	}
+	return b
+}
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/recovery"
+)
+
+const (
+	defaultMirrorTimeout     = 10 * time.Second
+	defaultMirrorMaxBodySize = 64 * 1024
+	// maxInFlightMirrors bounds the mirrored requests in flight for each rule, so that a slow secondary origin can't
+	// pile up goroutines. Requests are dropped past this.
+	maxInFlightMirrors = 100
+	// MirrorHeader is set on the mirrored requests, for the secondary origin to tell them apart.
+	MirrorHeader = "Cf-Cloudflared-Mirror"
+
+	mirrorResultSent    = "sent"
+	mirrorResultFailed  = "failed"
+	mirrorResultDropped = "dropped"
+	mirrorResultSkipped = "skipped"
+)
+
+// mirror sends a copy of a share of the requests of an ingress rule to a secondary origin, in the background.
+// Responses are discarded.
+type mirror struct {
+	origin      *url.URL
+	percent     float64
+	timeout     time.Duration
+	maxBodySize int64
+	rule        string
+	client      *http.Client
+	inFlight    chan struct{}
+	log         *zerolog.Logger
+}
+
+func newMirror(cfg config.MirrorConfig, ruleNum int, log *zerolog.Logger) (*mirror, error) {
+	origin, err := url.Parse(cfg.Origin)
+	if err != nil {
+		return nil, err
+	}
+	timeout := cfg.Timeout.Duration
+	if timeout == 0 {
+		timeout = defaultMirrorTimeout
+	}
+	maxBodySize := cfg.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = defaultMirrorMaxBodySize
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = maxInFlightMirrors
+	return &mirror{
+		origin:      origin,
+		percent:     cfg.Percent,
+		timeout:     timeout,
+		maxBodySize: maxBodySize,
+		rule:        strconv.Itoa(ruleNum),
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+			// The redirects are the secondary origin's response
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		inFlight: make(chan struct{}, maxInFlightMirrors),
+		log:      log,
+	}, nil
+}
+
+func (m *mirror) sampled() bool {
+	return m.percent >= 100 || rand.Float64()*100 < m.percent
+}
+
+// mirrorRequest sends a copy of req to the secondary origin if it's sampled. The request body is copied as the
+// origin reads it, and the copy is only sent once the origin read all of it, so that mirroring never holds the request
+// up, e.g. for streamed bodies or protocols waiting for the response before sending more.
+func (m *mirror) mirrorRequest(req *http.Request) {
+	if !m.sampled() {
+		return
+	}
+	if req.ContentLength > m.maxBodySize {
+		mirroredRequests.WithLabelValues(m.rule, mirrorResultSkipped).Inc()
+		return
+	}
+	mirrored := req.Clone(context.Background())
+	mirrored.URL.Scheme = m.origin.Scheme
+	mirrored.URL.Host = m.origin.Host
+	mirrored.URL.Path = m.origin.Path + req.URL.Path
+	mirrored.RequestURI = ""
+	mirrored.Header.Set(MirrorHeader, "1")
+	if req.Body == nil || req.Body == http.NoBody {
+		m.sendInBackground(mirrored, nil)
+		return
+	}
+	req.Body = &mirroredBody{
+		ReadCloser: req.Body,
+		limit:      m.maxBodySize,
+		done: func(body []byte, complete bool) {
+			if !complete {
+				mirroredRequests.WithLabelValues(m.rule, mirrorResultSkipped).Inc()
+				return
+			}
+			m.sendInBackground(mirrored, body)
+		},
+	}
+}
+
+// sendInBackground sends the mirrored request with body, unless too many are in flight already.
+func (m *mirror) sendInBackground(mirrored *http.Request, body []byte) {
+	select {
+	case m.inFlight <- struct{}{}:
+	default:
+		mirroredRequests.WithLabelValues(m.rule, mirrorResultDropped).Inc()
+		return
+	}
+	mirrored.Body = http.NoBody
+	mirrored.ContentLength = int64(len(body))
+	mirrored.TransferEncoding = nil
+	if len(body) > 0 {
+		mirrored.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	go func() {
+		defer func() { <-m.inFlight }()
+		defer recovery.Recover(m.log, recovery.SubsystemProxy, recovery.NoConnIndex)
+		m.send(mirrored)
+	}()
+}
+
+func (m *mirror) send(req *http.Request) {
+	resp, err := m.client.Do(req)
+	if err != nil {
+		mirroredRequests.WithLabelValues(m.rule, mirrorResultFailed).Inc()
+		m.log.Debug().Err(err).Str("ingressRule", m.rule).Msg("Failed to mirror request")
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		mirroredRequests.WithLabelValues(m.rule, mirrorResultFailed).Inc()
+		return
+	}
+	mirroredRequests.WithLabelValues(m.rule, mirrorResultSent).Inc()
+}
+
+// mirroredBody copies the request body read by the origin, up to limit. done is called once, with complete set if the
+// whole body was read within the limit.
+type mirroredBody struct {
+	io.ReadCloser
+	limit    int64
+	copied   bytes.Buffer
+	overflow bool
+	done     func(body []byte, complete bool)
+	once     sync.Once
+}
+
+func (b *mirroredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.overflow {
+		if int64(b.copied.Len()+n) > b.limit {
+			b.overflow = true
+			b.copied = bytes.Buffer{}
+		} else {
+			b.copied.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		b.once.Do(func() { b.done(b.copied.Bytes(), !b.overflow) })
+	}
+	return n, err
+}
+
+func (b *mirroredBody) Close() error {
+	// The origin didn't read the whole body
+	b.once.Do(func() { b.done(nil, false) })
+	return b.ReadCloser.Close()
+}
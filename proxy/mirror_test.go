@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/tracing"
+)
+
+type mirroredRequest struct {
+	host string
+	path string
+	body string
+}
+
+func TestProxyMirror(t *testing.T) {
+	mirroredC := make(chan mirroredRequest, 10)
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "1", r.Header.Get(MirrorHeader))
+		mirroredC <- mirroredRequest{host: r.Host, path: r.URL.Path, body: string(body)}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer secondary.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer origin.Close()
+
+	proxy := newTestProxy(t, config.OriginRequestConfig{
+		Mirror: &config.MirrorConfig{Origin: secondary.URL + "/shadow", Percent: 100, MaxBodySize: 8},
+	}, origin.URL)
+	require.Contains(t, proxy.mirrors, 0)
+
+	proxyRequest := func(body string) *mockHTTPRespWriter {
+		req, err := http.NewRequest(http.MethodPost, "http://example.com/api", strings.NewReader(body))
+		require.NoError(t, err)
+		responseWriter := newMockHTTPRespWriter()
+		require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, proxy.log), false))
+		return responseWriter
+	}
+
+	// The response of the secondary origin doesn't affect the eyeball's
+	responseWriter := proxyRequest("hello")
+	assert.Equal(t, http.StatusOK, responseWriter.Code)
+	assert.Equal(t, "hello", responseWriter.Body.String())
+	select {
+	case mirrored := <-mirroredC:
+		assert.Equal(t, mirroredRequest{host: "example.com", path: "/shadow/api", body: "hello"}, mirrored)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request wasn't mirrored")
+	}
+
+	// Requests with bodies too large to buffer are still proxied whole, without mirror
+	responseWriter = proxyRequest("hello world")
+	assert.Equal(t, "hello world", responseWriter.Body.String())
+	select {
+	case mirrored := <-mirroredC:
+		t.Fatalf("the request was mirrored: %+v", mirrored)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMirrorStreamedBody(t *testing.T) {
+	mirroredC := make(chan string, 1)
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mirroredC <- string(body)
+	}))
+	defer secondary.Close()
+	log := zerolog.Nop()
+	m, err := newMirror(config.MirrorConfig{Origin: secondary.URL, Percent: 100}, 0, &log)
+	require.NoError(t, err)
+
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, "http://example.com/stream", pr)
+	require.NoError(t, err)
+	// Nothing was sent yet, mirroring must not wait for the body
+	m.mirrorRequest(req)
+
+	go func() {
+		_, _ = pw.Write([]byte("chunk"))
+		_ = pw.Close()
+	}()
+	received, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "chunk", string(received))
+	select {
+	case mirrored := <-mirroredC:
+		assert.Equal(t, "chunk", mirrored)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request wasn't mirrored")
+	}
+}
+
+func TestMirrorSampling(t *testing.T) {
+	m, err := newMirror(config.MirrorConfig{Origin: "http://localhost", Percent: 100}, 0, nil)
+	require.NoError(t, err)
+	assert.True(t, m.sampled())
+	assert.Equal(t, defaultMirrorTimeout, m.timeout)
+	assert.Equal(t, int64(defaultMirrorMaxBodySize), m.maxBodySize)
+
+	m.percent = 0.0001
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		if m.sampled() {
+			sampled++
+		}
+	}
+	assert.Less(t, sampled, 10)
+}
@@ -0,0 +1,261 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudflare/cloudflared/cfio"
+	"github.com/cloudflare/cloudflared/config"
+)
+
+const (
+	compressionDirectionRequest  = "request"
+	compressionDirectionResponse = "response"
+)
+
+// originCompressor negotiates gzip with the origin of an ingress rule. Responses are requested compressed, and
+// decompressed for the eyeballs that don't accept gzip. Request bodies are compressed once the origin advertises
+// support with an Accept-Encoding response header, as in RFC 7694. A request whose compressed body is rejected
+// with 415 is sent again uncompressed if its body can be replayed.
+type originCompressor struct {
+	rule    string
+	level   int
+	minSize int64
+	// acceptsRequests is set by the Accept-Encoding header of the responses of the origin
+	acceptsRequests atomic.Bool
+}
+
+func newOriginCompressor(cfg config.OriginCompressionConfig, ruleNum int) *originCompressor {
+	level := cfg.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return &originCompressor{
+		rule:    strconv.Itoa(ruleNum),
+		level:   level,
+		minSize: cfg.MinSize,
+	}
+}
+
+// roundTrip sends the request with next, compressing its body if the origin accepts it, and adding gzip to the
+// encodings the eyeball accepts if it isn't one of them.
+func (c *originCompressor) roundTrip(req *http.Request, next func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	original := req
+	eyeballAcceptsGzip := listsGzip(req.Header.Get("Accept-Encoding"))
+	req = req.Clone(req.Context())
+	if !eyeballAcceptsGzip {
+		req.Header.Set("Accept-Encoding", withGzip(req.Header.Get("Accept-Encoding")))
+	}
+	compressedRequest := c.compressesRequest(req)
+	if compressedRequest {
+		req.Body = c.compressBody(req.Body)
+		req.GetBody = nil
+		req.ContentLength = -1
+		req.Header.Del("Content-Length")
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := next(req)
+	if err != nil {
+		return nil, err
+	}
+	if listsGzip(resp.Header.Get("Accept-Encoding")) {
+		c.acceptsRequests.Store(true)
+	} else if compressedRequest && resp.StatusCode == http.StatusUnsupportedMediaType {
+		// The origin stopped accepting compressed requests. The eyeball didn't send a compressed body, so the
+		// rejection is only passed on if the body can't be sent again.
+		c.acceptsRequests.Store(false)
+		if original.GetBody != nil {
+			body, err := original.GetBody()
+			if err == nil {
+				_ = resp.Body.Close()
+				req = original.Clone(original.Context())
+				req.Body = body
+				if !eyeballAcceptsGzip {
+					req.Header.Set("Accept-Encoding", withGzip(req.Header.Get("Accept-Encoding")))
+				}
+				if resp, err = next(req); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if !eyeballAcceptsGzip && c.decompressesResponse(req, resp) {
+		resp.Body = c.decompressBody(resp.Body)
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+		resp.Header.Del("Content-Encoding")
+		resp.Uncompressed = true
+	}
+	return resp, nil
+}
+
+func (c *originCompressor) compressesRequest(req *http.Request) bool {
+	if !c.acceptsRequests.Load() || req.Body == nil || req.Body == http.NoBody || req.ContentLength == 0 {
+		return false
+	}
+	if req.Header.Get("Content-Encoding") != "" || len(req.TransferEncoding) > 0 {
+		return false
+	}
+	return req.ContentLength < 0 || req.ContentLength >= c.minSize
+}
+
+func (c *originCompressor) decompressesResponse(req *http.Request, resp *http.Response) bool {
+	if req.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return false
+	}
+	return strings.EqualFold(strings.TrimSpace(resp.Header.Get("Content-Encoding")), "gzip")
+}
+
+// compressBody gzips body in the background as the origin reads it.
+func (c *originCompressor) compressBody(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		compressed := &meteredWriter{w: pw}
+		// The level is validated with the ingress rules
+		gw, _ := gzip.NewWriterLevel(compressed, c.level)
+		var uncompressed int64
+		var spent time.Duration
+		buf := cfio.GetBuffer(32 * 1024)
+		defer cfio.PutBuffer(buf)
+		err := func() error {
+			for {
+				n, err := body.Read(*buf)
+				if n > 0 {
+					start, waited := time.Now(), compressed.waited
+					if _, err := gw.Write((*buf)[:n]); err != nil {
+						return err
+					}
+					spent += time.Since(start) - (compressed.waited - waited)
+					uncompressed += int64(n)
+				}
+				if err == io.EOF {
+					return gw.Close()
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}()
+		_ = pw.CloseWithError(err)
+		c.observe(compressionDirectionRequest, uncompressed, compressed.n, spent)
+	}()
+	return &compressedBody{PipeReader: pr, body: body}
+}
+
+func (c *originCompressor) decompressBody(body io.ReadCloser) io.ReadCloser {
+	return &decompressedBody{compressed: &meteredReader{r: body}, body: body, compressor: c}
+}
+
+func (c *originCompressor) observe(direction string, uncompressed, compressed int64, spent time.Duration) {
+	originCompressionBytes.WithLabelValues(c.rule, direction, "uncompressed").Add(float64(uncompressed))
+	originCompressionBytes.WithLabelValues(c.rule, direction, "compressed").Add(float64(compressed))
+	originCompressionSeconds.WithLabelValues(c.rule, direction).Add(spent.Seconds())
+}
+
+// compressedBody closes the body of the eyeball along with the pipe, which stops the compression.
+type compressedBody struct {
+	*io.PipeReader
+	body io.Closer
+}
+
+func (b *compressedBody) Close() error {
+	_ = b.PipeReader.Close()
+	return b.body.Close()
+}
+
+// decompressedBody gunzips the body of a response. The gzip header is only read on the first read, so that the
+// response headers can be sent without waiting for the body.
+type decompressedBody struct {
+	compressed   *meteredReader
+	body         io.Closer
+	reader       *gzip.Reader
+	compressor   *originCompressor
+	uncompressed int64
+	spent        time.Duration
+	closed       bool
+}
+
+func (b *decompressedBody) Read(p []byte) (int, error) {
+	start, waited := time.Now(), b.compressed.waited
+	defer func() {
+		b.spent += time.Since(start) - (b.compressed.waited - waited)
+	}()
+	if b.reader == nil {
+		reader, err := gzip.NewReader(b.compressed)
+		if err != nil {
+			return 0, err
+		}
+		b.reader = reader
+	}
+	n, err := b.reader.Read(p)
+	b.uncompressed += int64(n)
+	return n, err
+}
+
+func (b *decompressedBody) Close() error {
+	if !b.closed {
+		b.closed = true
+		b.compressor.observe(compressionDirectionResponse, b.uncompressed, b.compressed.n, b.spent)
+	}
+	return b.body.Close()
+}
+
+// meteredWriter counts the bytes written to w, and the time spent waiting for it.
+type meteredWriter struct {
+	w      io.Writer
+	n      int64
+	waited time.Duration
+}
+
+func (m *meteredWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := m.w.Write(p)
+	m.waited += time.Since(start)
+	m.n += int64(n)
+	return n, err
+}
+
+// meteredReader counts the bytes read from r, and the time spent waiting for it.
+type meteredReader struct {
+	r      io.Reader
+	n      int64
+	waited time.Duration
+}
+
+func (m *meteredReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := m.r.Read(p)
+	m.waited += time.Since(start)
+	m.n += int64(n)
+	return n, err
+}
+
+// withGzip adds gzip to the encodings of an Accept-Encoding header, replacing a gzip;q=0 refusal.
+func withGzip(acceptEncoding string) string {
+	var encodings []string
+	for _, encoding := range strings.Split(acceptEncoding, ",") {
+		encoding = strings.TrimSpace(encoding)
+		name, _, _ := strings.Cut(encoding, ";")
+		if encoding != "" && !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			encodings = append(encodings, encoding)
+		}
+	}
+	return strings.Join(append(encodings, "gzip"), ", ")
+}
+
+// listsGzip returns whether an Accept-Encoding header accepts gzip.
+func listsGzip(acceptEncoding string) bool {
+	for _, encoding := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/tracing"
+)
+
+func gzipBytes(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	_, err := gw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func TestProxyOriginCompression(t *testing.T) {
+	payload := strings.Repeat("compressible ", 200)
+	var requestEncodings, requestBodies []string
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gr, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			body = gr
+		}
+		received, err := io.ReadAll(body)
+		require.NoError(t, err)
+		requestEncodings = append(requestEncodings, r.Header.Get("Content-Encoding"))
+		requestBodies = append(requestBodies, string(received))
+
+		w.Header().Set("Accept-Encoding", "gzip")
+		if listsGzip(r.Header.Get("Accept-Encoding")) {
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(gzipBytes(t, payload))
+			return
+		}
+		_, _ = w.Write([]byte(payload))
+	}))
+	defer origin.Close()
+
+	proxy := newTestProxy(t, config.OriginRequestConfig{
+		OriginCompression: &config.OriginCompressionConfig{Encodings: []string{"gzip"}, MinSize: 16},
+	}, origin.URL)
+	proxyRequest := func(acceptEncoding, body string) *mockHTTPRespWriter {
+		req, err := http.NewRequest(http.MethodPost, "http://example.com", strings.NewReader(body))
+		require.NoError(t, err)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		responseWriter := newMockHTTPRespWriter()
+		require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, proxy.log), false))
+		return responseWriter
+	}
+
+	// The response is decompressed for eyeballs that don't accept gzip
+	resp := proxyRequest("", payload)
+	assert.Equal(t, payload, resp.Body.String())
+	assert.Empty(t, resp.Header().Get("Content-Encoding"))
+	// The first request is sent as is, since the origin didn't advertise support yet
+	assert.Equal(t, "", requestEncodings[0])
+
+	// The response is passed through for eyeballs that accept gzip
+	resp = proxyRequest("gzip, br", payload)
+	assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+	assert.True(t, bytes.Equal(gzipBytes(t, payload), resp.Body.Bytes()))
+	assert.Equal(t, "gzip", requestEncodings[1])
+	assert.Equal(t, payload, requestBodies[1])
+
+	// Small request bodies are sent as is
+	proxyRequest("", "small")
+	assert.Equal(t, "", requestEncodings[2])
+	assert.Equal(t, "small", requestBodies[2])
+}
+
+func TestOriginCompressionRejected(t *testing.T) {
+	payload := strings.Repeat("compressible ", 200)
+	compressor := newOriginCompressor(config.OriginCompressionConfig{Encodings: []string{"gzip"}}, 0)
+	compressor.acceptsRequests.Store(true)
+	var sent []string
+	next := func(req *http.Request) (*http.Response, error) {
+		sent = append(sent, req.Header.Get("Content-Encoding"))
+		_, err := io.Copy(io.Discard, req.Body)
+		require.NoError(t, err)
+		status := http.StatusOK
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			status = http.StatusUnsupportedMediaType
+		}
+		return &http.Response{StatusCode: status, Header: http.Header{}, Body: http.NoBody}, nil
+	}
+
+	// A replayable body is sent again uncompressed
+	req, err := http.NewRequest(http.MethodPost, "http://example.com", strings.NewReader(payload))
+	require.NoError(t, err)
+	resp, err := compressor.roundTrip(req, next)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"gzip", ""}, sent)
+	assert.False(t, compressor.acceptsRequests.Load())
+}
+
+func TestWithGzip(t *testing.T) {
+	assert.Equal(t, "gzip", withGzip(""))
+	assert.Equal(t, "br, zstd, gzip", withGzip("br, zstd"))
+	assert.Equal(t, "br;q=0.8, gzip", withGzip("br;q=0.8, gzip;q=0"))
+}
+
+func TestListsGzip(t *testing.T) {
+	assert.True(t, listsGzip("gzip"))
+	assert.True(t, listsGzip("br, GZIP;q=0.5"))
+	assert.False(t, listsGzip(""))
+	assert.False(t, listsGzip("br, gzip;q=0"))
+	assert.False(t, listsGzip("identity"))
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/cloudflare/cloudflared/tracing"
+)
+
+type originDial struct {
+	start time.Time
+	span  trace.Span
+}
+
+// withOriginTrace observes the time spent dialing the origin for a request, to tell it from the time the origin
+// spends processing the request. Connections reused from the pool aren't observed. When spans are exported, the dials
+// and TLS handshakes are recorded as spans too.
+func withOriginTrace(ctx context.Context, tr *tracing.TracedHTTPRequest, rule string) context.Context {
+	recordSpans := tracing.Exporting()
+	var lock sync.Mutex
+	// Dials are keyed by address, since happy eyeballs dials several addresses at once
+	dials := make(map[string]originDial)
+	var handshakeSpan trace.Span
+	clientTrace := &httptrace.ClientTrace{
+		ConnectStart: func(network, addr string) {
+			dial := originDial{start: time.Now()}
+			if recordSpans {
+				_, dial.span = tr.Tracer().Start(ctx, "origin_dial", trace.WithAttributes(
+					attribute.String("network", network),
+					attribute.String("origin-addr", addr),
+				))
+			}
+			lock.Lock()
+			dials[network+addr] = dial
+			lock.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			lock.Lock()
+			dial, ok := dials[network+addr]
+			delete(dials, network+addr)
+			lock.Unlock()
+			if !ok {
+				return
+			}
+			if err != nil {
+				tracing.EndWithErrorStatus(dial.span, err)
+				return
+			}
+			originConnectDuration.WithLabelValues(rule).Observe(time.Since(dial.start).Seconds())
+			tracing.End(dial.span)
+		},
+	}
+	if recordSpans {
+		clientTrace.TLSHandshakeStart = func() {
+			_, span := tr.Tracer().Start(ctx, "origin_tls_handshake")
+			lock.Lock()
+			handshakeSpan = span
+			lock.Unlock()
+		}
+		clientTrace.TLSHandshakeDone = func(_ tls.ConnectionState, err error) {
+			lock.Lock()
+			span := handshakeSpan
+			handshakeSpan = nil
+			lock.Unlock()
+			if err != nil {
+				tracing.EndWithErrorStatus(span, err)
+				return
+			}
+			tracing.End(span)
+		}
+	}
+	return httptrace.WithClientTrace(ctx, clientTrace)
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/netip"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -17,10 +18,15 @@ import (
 	cfdflow "github.com/cloudflare/cloudflared/flow"
 	"github.com/cloudflare/cloudflared/management"
 
+	"github.com/cloudflare/cloudflared/accesslog"
+	"github.com/cloudflare/cloudflared/accounting"
 	"github.com/cloudflare/cloudflared/carrier"
 	"github.com/cloudflare/cloudflared/cfio"
+	"github.com/cloudflare/cloudflared/config"
 	"github.com/cloudflare/cloudflared/connection"
 	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/inspect"
+	"github.com/cloudflare/cloudflared/memlimit"
 	"github.com/cloudflare/cloudflared/stream"
 	"github.com/cloudflare/cloudflared/tracing"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
@@ -38,7 +44,26 @@ type Proxy struct {
 	originDialer ingress.OriginTCPDialer
 	tags         []pogs.Tag
 	flowLimiter  cfdflow.Limiter
+	accessLog    *accesslog.Logger
+	meter        *accounting.Meter
+	memory       *memlimit.Monitor
+	maintenance  *Maintenance
 	log          *zerolog.Logger
+
+	// warpRoutingBackpressure is the budget of the copies of the private network flows, nil if they aren't limited.
+	warpRoutingBackpressure *cfio.Backpressure
+
+	// Per ingress rule state, keyed by rule index. Only rules configuring the feature have an entry.
+	retriers        map[int]*retrier
+	hedgers         map[int]*hedger
+	circuitBreakers map[int]*circuitBreaker
+	rateLimiters    map[int]*rateLimiter
+	compressors     map[int]*originCompressor
+	caches          map[int]*responseCache
+	inspections     map[int]*inspect.Pipeline
+	mirrors         map[int]*mirror
+	spnego          map[int]*spnegoAuthenticator
+	backpressures   map[int]*cfio.Backpressure
 }
 
 // NewOriginProxy returns a new instance of the Proxy struct.
@@ -47,14 +72,83 @@ func NewOriginProxy(
 	originDialer ingress.OriginDialer,
 	tags []pogs.Tag,
 	flowLimiter cfdflow.Limiter,
+	accessLog *accesslog.Logger,
+	meter *accounting.Meter,
+	memory *memlimit.Monitor,
+	maintenance *Maintenance,
+	caches *ResponseCaches,
+	copyWatermarks cfio.Watermarks,
 	log *zerolog.Logger,
 ) *Proxy {
 	proxy := &Proxy{
-		ingressRules: ingressRules,
-		originDialer: originDialer,
-		tags:         tags,
-		flowLimiter:  flowLimiter,
-		log:          log,
+		ingressRules:    ingressRules,
+		originDialer:    originDialer,
+		tags:            tags,
+		flowLimiter:     flowLimiter,
+		accessLog:       accessLog,
+		meter:           meter,
+		memory:          memory,
+		maintenance:     maintenance,
+		log:             log,
+		retriers:        make(map[int]*retrier),
+		hedgers:         make(map[int]*hedger),
+		circuitBreakers: make(map[int]*circuitBreaker),
+		rateLimiters:    make(map[int]*rateLimiter),
+		compressors:     make(map[int]*originCompressor),
+		caches:          caches.forRules(ingressRules.Rules, log),
+		inspections:     make(map[int]*inspect.Pipeline),
+		mirrors:         make(map[int]*mirror),
+		spnego:          make(map[int]*spnegoAuthenticator),
+		backpressures:   make(map[int]*cfio.Backpressure),
+	}
+	if copyWatermarks.Enabled() {
+		proxy.warpRoutingBackpressure = newCopyBackpressure(copyWatermarks, warpRoutingRoute, log)
+	}
+	for i, rule := range ingressRules.Rules {
+		if copyWatermarks.Enabled() {
+			if b := newCopyBackpressure(copyWatermarks, strconv.Itoa(i), log); b != nil {
+				proxy.backpressures[i] = b
+			}
+		}
+		if rule.Config.Retry.MaxRetries > 0 {
+			proxy.retriers[i] = newRetrier(rule.Config.Retry, i)
+		}
+		if rule.Config.Hedging.Delay.Duration > 0 {
+			proxy.hedgers[i] = newHedger(rule.Config.Hedging, i)
+		}
+		if rule.Config.CircuitBreaker.FailureThreshold > 0 {
+			proxy.circuitBreakers[i] = newCircuitBreaker(rule.Config.CircuitBreaker, i)
+		}
+		if rule.Config.RateLimit.RequestsPerSecond > 0 {
+			proxy.rateLimiters[i] = newRateLimiter(rule.Config.RateLimit, i)
+		}
+		if len(rule.Config.OriginCompression.Encodings) > 0 {
+			proxy.compressors[i] = newOriginCompressor(rule.Config.OriginCompression, i)
+		}
+		if inspection := rule.Config.Inspection; len(inspection.Inspectors) > 0 {
+			pipeline, err := inspect.NewPipeline(inspection.Inspectors, inspection.SampleRate, inspection.SampleBytes)
+			if err != nil {
+				log.Err(err).Msgf("Requests of ingress rule %d won't be inspected", i)
+			} else {
+				proxy.inspections[i] = pipeline
+			}
+		}
+		if rule.Config.SPNEGO.Enabled() {
+			authenticator, err := newSPNEGOAuthenticator(rule.Config.SPNEGO, rule.Service, i, log)
+			if err != nil {
+				log.Err(err).Msgf("Requests of ingress rule %d won't be authenticated with SPNEGO", i)
+			} else {
+				proxy.spnego[i] = authenticator
+			}
+		}
+		if rule.Config.Mirror.Origin != "" {
+			m, err := newMirror(rule.Config.Mirror, i, log)
+			if err != nil {
+				log.Err(err).Msgf("Requests of ingress rule %d won't be mirrored", i)
+			} else {
+				proxy.mirrors[i] = m
+			}
+		}
 	}
 
 	return proxy
@@ -81,18 +175,29 @@ func (p *Proxy) ProxyHTTP(
 	w connection.ResponseWriter,
 	tr *tracing.TracedHTTPRequest,
 	isWebsocket bool,
-) error {
+) (err error) {
 	incrementRequests()
 	defer decrementConcurrentRequests()
+	cfdflow.Active.Begin(cfdflow.KindHTTP)
+	defer cfdflow.Active.End(cfdflow.KindHTTP)
 
 	req := tr.Request
 	p.appendTagHeaders(req)
+	var ruleNum int
+	if p.accessLog != nil {
+		recorder := newAccessLogRecorder(w)
+		w = recorder
+		defer func() {
+			p.logAccess(tr, recorder, ruleNum, err)
+		}()
+	}
 
 	_, ruleSpan := tr.Tracer().Start(req.Context(), "ingress_match",
 		trace.WithAttributes(attribute.String("req-host", req.Host)))
 	rule, ruleNum := p.ingressRules.FindMatchingRule(req.Host, req.URL.Path)
 	ruleSpan.SetAttributes(attribute.Int("rule-num", ruleNum))
 	ruleSpan.End()
+	appendFingerprintHeaders(rule, req)
 	logger := newHTTPLogger(p.log, tr.ConnIndex, req, ruleNum, rule.Service.String())
 	logHTTPRequest(&logger, req)
 	if err, applied := p.applyIngressMiddleware(rule, req, w); err != nil {
@@ -102,15 +207,41 @@ func (p *Proxy) ProxyHTTP(
 		}
 		return err
 	}
+	if p.meter.Throttled() {
+		logger.Debug().Msg("Request throttled because the usage quota of the tunnel is exceeded")
+		return writeQuotaExceededResponse(w)
+	}
+	if p.maintenance.inMaintenance(ruleNum, rule.Config.Maintenance) {
+		logger.Debug().Msg("Ingress rule is in maintenance, serving the maintenance response")
+		return writeMaintenanceResponse(w, rule.Config.Maintenance, ruleNum)
+	}
+	if rule.Config.Priority == ingress.PriorityLow && p.memory.Shed(memlimit.KindRequest) {
+		logger.Debug().Msg("Request of a low priority ingress rule shed because the memory used exceeds the soft limit")
+		return writeShedResponse(w)
+	}
+	if limiter, ok := p.rateLimiters[ruleNum]; ok {
+		if allowed, retryAfter := limiter.allow(req); !allowed {
+			logger.Debug().Msg("Request throttled by the rate limit of the ingress rule")
+			return limiter.writeThrottledResponse(w, retryAfter)
+		}
+	}
 
 	switch originProxy := rule.Service.(type) {
 	case ingress.HTTPOriginProxy:
+		if status := checkRequestSize(req, rule.Config.RequestLimits, ruleNum); status != 0 && !isWebsocket {
+			logger.Debug().Int("status", status).Msg("Request exceeds the size limits of the ingress rule")
+			return writeRequestLimitResponse(w, status)
+		}
 		if err := p.proxyHTTPRequest(
 			w,
 			tr,
 			originProxy,
 			isWebsocket,
 			rule.Config.DisableChunkedEncoding,
+			rule.Config.Streaming,
+			rule.Config.WebSocket,
+			rule.Config.RequestLimits,
+			ruleNum,
 			&logger,
 		); err != nil {
 			logRequestError(&logger, err)
@@ -128,7 +259,16 @@ func (p *Proxy) ProxyHTTP(
 		}
 		rws := connection.NewHTTPResponseReadWriterAcker(w, flusher, req)
 		logger := logger.With().Str(logFieldDestAddr, dest).Logger()
-		if err := p.proxyStream(tr.ToTracedContext(), rws, dest, originProxy, &logger); err != nil {
+		tracedCtx := tr.ToTracedContext()
+		tracedCtx.Context = ingress.ContextWithStreamClientInfo(tracedCtx.Context, streamClientInfo(req))
+		tracedCtx.Context = cfio.ContextWithBackpressure(tracedCtx.Context, p.backpressures[ruleNum])
+		record := cfdflow.Record{
+			ID:        connection.FindCfRayHeader(req),
+			Dst:       dest,
+			Route:     strconv.Itoa(ruleNum),
+			ConnIndex: tr.ConnIndex,
+		}
+		if err := p.proxyStream(tracedCtx, rws, dest, originProxy, record, &logger); err != nil {
 			logRequestError(&logger, err)
 			return err
 		}
@@ -158,6 +298,8 @@ func (p *Proxy) ProxyTCP(
 		return errors.Wrap(err, "failed to start tcp flow due to rate limiting")
 	}
 	defer p.flowLimiter.Release()
+	cfdflow.Active.Begin(cfdflow.KindTCP)
+	defer cfdflow.Active.End(cfdflow.KindTCP)
 
 	serveCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -172,7 +314,13 @@ func (p *Proxy) ProxyTCP(
 		return err
 	}
 
-	if err := p.proxyTCPStream(tracedCtx, conn, dest, p.originDialer, &logger); err != nil {
+	record := cfdflow.Record{
+		ID:        req.FlowID,
+		Dst:       dest.String(),
+		Route:     warpRoutingRoute,
+		ConnIndex: req.ConnIndex,
+	}
+	if err := p.proxyTCPStream(tracedCtx, conn, dest, p.originDialer, record, &logger); err != nil {
 		logRequestError(&logger, err)
 		return err
 	}
@@ -189,6 +337,10 @@ func (p *Proxy) proxyHTTPRequest(
 	httpService ingress.HTTPOriginProxy,
 	isWebsocket bool,
 	disableChunkedEncoding bool,
+	streaming config.StreamingConfig,
+	websocket config.WebSocketConfig,
+	limits config.RequestLimitsConfig,
+	ruleNum int,
 	logger *zerolog.Logger,
 ) error {
 	roundTripReq := tr.Request
@@ -197,6 +349,7 @@ func (p *Proxy) proxyHTTPRequest(
 		roundTripReq.Header.Set("Connection", "Upgrade")
 		roundTripReq.Header.Set("Upgrade", "websocket")
 		roundTripReq.Header.Set("Sec-Websocket-Version", "13")
+		filterWebsocketExtensions(roundTripReq.Header, websocket)
 		roundTripReq.ContentLength = 0
 		roundTripReq.Body = nil
 	} else {
@@ -217,10 +370,40 @@ func (p *Proxy) proxyHTTPRequest(
 		roundTripReq.Header.Set("User-Agent", "")
 	}
 
-	_, ttfbSpan := tr.Tracer().Start(tr.Context(), "ttfb_origin")
-	resp, err := httpService.RoundTrip(roundTripReq)
+	var ins *inspection
+	var limitedReqBody *limitedBody
+	if !isWebsocket {
+		limitedReqBody = limitRequestBody(roundTripReq, limits, ruleNum)
+		if m, ok := p.mirrors[ruleNum]; ok {
+			m.mirrorRequest(roundTripReq)
+		}
+		ins = p.startInspection(roundTripReq, ruleNum)
+	}
+
+	rule := strconv.Itoa(ruleNum)
+	ttfbCtx, ttfbSpan := tr.Tracer().Start(tr.Context(), "ttfb_origin")
+	roundTripReq = roundTripReq.WithContext(withOriginTrace(ttfbCtx, tr, rule))
+	tracing.InjectTraceparent(ttfbCtx, roundTripReq.Header)
+	start := time.Now()
+	if !isWebsocket {
+		// The duration of websockets is how long they stay open, it isn't a latency
+		defer func() {
+			originRequestDuration.WithLabelValues(rule).Observe(time.Since(start).Seconds())
+		}()
+	}
+	resp, err := p.roundTrip(httpService, roundTripReq, ruleNum, isWebsocket)
+	originTTFB.WithLabelValues(rule).Observe(time.Since(start).Seconds())
 	if err != nil {
+		ins.finish(err)
 		tracing.EndWithErrorStatus(ttfbSpan, err)
+		if errors.Is(err, errCircuitOpen) {
+			logger.Debug().Msg("Origin circuit breaker is open, fast-failing request")
+			return p.circuitBreakers[ruleNum].writeErrorResponse(w)
+		}
+		if status := limitedReqBody.rejectedStatus(); status != 0 {
+			logger.Debug().Err(err).Int("status", status).Msg("Request body exceeds the limits of the ingress rule")
+			return writeRequestLimitResponse(w, status)
+		}
 		if err := roundTripReq.Context().Err(); err != nil {
 			return errors.Wrap(err, "Incoming request ended abruptly")
 		}
@@ -228,6 +411,8 @@ func (p *Proxy) proxyHTTPRequest(
 	}
 
 	tracing.EndWithStatusCode(ttfbSpan, resp.StatusCode)
+	originResponses.WithLabelValues(rule, strconv.Itoa(resp.StatusCode)).Inc()
+	ins.observeResponse(resp)
 	defer resp.Body.Close()
 
 	headers := make(http.Header, len(resp.Header))
@@ -241,10 +426,12 @@ func (p *Proxy) proxyHTTPRequest(
 
 	err = w.WriteRespHeaders(resp.StatusCode, headers)
 	if err != nil {
+		ins.finish(err)
 		return errors.Wrap(err, "Error writing response header")
 	}
 
 	if resp.StatusCode == http.StatusSwitchingProtocols {
+		ins.finish(nil)
 		rwc, ok := resp.Body.(io.ReadWriteCloser)
 		if !ok {
 			return errors.New("internal error: unsupported connection type")
@@ -255,30 +442,128 @@ func (p *Proxy) proxyHTTPRequest(
 			writer: w,
 			reader: tr.Request.Body,
 		}
+		var originConn io.ReadWriter = rwc
+		if guard := newWebsocketGuard(websocket, ruleNum, rwc); guard != nil {
+			defer guard.stop()
+			eyeballStream.reader = guard.reader(eyeballStream.reader)
+			originConn = &bidirectionalStream{
+				writer: rwc,
+				reader: guard.reader(rwc),
+			}
+		}
 
-		stream.Pipe(eyeballStream, rwc, logger)
+		stream.PipeWithBackpressure(eyeballStream, originConn, p.backpressures[ruleNum], logger)
 		return nil
 	}
 
-	if _, err = cfio.Copy(w, resp.Body); err != nil {
+	var body io.Writer = w
+	if flusher := newFlushWriter(w, streaming); flusher != nil {
+		defer flusher.stop()
+		body = flusher
+	}
+	if _, err = cfio.CopyWithBackpressure(body, resp.Body, p.backpressures[ruleNum]); err != nil {
+		ins.finish(err)
 		return err
 	}
 
 	// copy trailers
 	copyTrailers(w, resp)
+	ins.finish(nil)
 
 	logOriginHTTPResponse(logger, resp)
 	return nil
 }
 
+// roundTrip serves the request from the cache of the rule if possible, otherwise sends it to the origin.
+func (p *Proxy) roundTrip(
+	httpService ingress.HTTPOriginProxy,
+	req *http.Request,
+	ruleNum int,
+	isWebsocket bool,
+) (*http.Response, error) {
+	cache, ok := p.caches[ruleNum]
+	if !ok || isWebsocket {
+		return p.originRoundTrip(httpService, req, ruleNum, isWebsocket)
+	}
+	return cache.roundTrip(req, func(req *http.Request) (*http.Response, error) {
+		return p.originRoundTrip(httpService, req, ruleNum, false)
+	})
+}
+
+// originRoundTrip sends the request to the origin, negotiating the compression of the bodies if the rule configures
+// it.
+func (p *Proxy) originRoundTrip(
+	httpService ingress.HTTPOriginProxy,
+	req *http.Request,
+	ruleNum int,
+	isWebsocket bool,
+) (*http.Response, error) {
+	compressor, ok := p.compressors[ruleNum]
+	if !ok || isWebsocket {
+		return p.sendToOrigin(httpService, req, ruleNum, isWebsocket)
+	}
+	return compressor.roundTrip(req, func(req *http.Request) (*http.Response, error) {
+		return p.sendToOrigin(httpService, req, ruleNum, false)
+	})
+}
+
+// sendToOrigin sends the request to the origin, applying the SPNEGO authentication, hedging, retry policy and circuit
+// breaker of the rule if any. The breaker is only consulted here, so that the requests served from the cache never
+// take its probe slot.
+func (p *Proxy) sendToOrigin(
+	httpService ingress.HTTPOriginProxy,
+	req *http.Request,
+	ruleNum int,
+	isWebsocket bool,
+) (*http.Response, error) {
+	breaker, hasBreaker := p.circuitBreakers[ruleNum]
+	if hasBreaker && !breaker.allow() {
+		return nil, errCircuitOpen
+	}
+	// Each attempt gets its own token
+	if authenticator, ok := p.spnego[ruleNum]; ok {
+		httpService = spnegoOrigin{origin: httpService, authenticator: authenticator}
+	}
+	if hedger, ok := p.hedgers[ruleNum]; ok && !isWebsocket {
+		httpService = hedgedOrigin{origin: httpService, hedger: hedger}
+	}
+	var resp *http.Response
+	var err error
+	if retrier, ok := p.retriers[ruleNum]; ok && !isWebsocket {
+		resp, err = retrier.roundTrip(httpService, req)
+	} else {
+		resp, err = httpService.RoundTrip(req)
+	}
+
+	if hasBreaker {
+		if req.Context().Err() != nil {
+			breaker.abandon()
+		} else {
+			breaker.record(!isOriginFailure(resp, err))
+		}
+	}
+	return resp, err
+}
+
+// PurgeCache removes the cached responses matching host and path prefix from the caches of every ingress rule,
+// an empty host matching every host. It returns the number of removed responses.
+func (p *Proxy) PurgeCache(host, pathPrefix string) int {
+	purged := 0
+	for _, cache := range p.caches {
+		purged += cache.purge(host, pathPrefix)
+	}
+	return purged
+}
+
 // proxyStream proxies type TCP and other underlying types if the connection is defined as a stream oriented
-// ingress rule.
+// ingress rule. record is completed and emitted once the stream is done.
 // connectedLogger is used to log when the connection is acknowledged
 func (p *Proxy) proxyStream(
 	tr *tracing.TracedContext,
 	rwa connection.ReadWriteAcker,
 	dest string,
 	originDialer ingress.StreamBasedOriginProxy,
+	record cfdflow.Record,
 	logger *zerolog.Logger,
 ) error {
 	ctx := tr.Context
@@ -305,7 +590,10 @@ func (p *Proxy) proxyStream(
 	connectLatency.Observe(float64(time.Since(start).Milliseconds()))
 	logger.Debug().Msg("proxy stream acknowledged")
 
-	originConn.Stream(ctx, rwa, logger)
+	record.Start = start
+	counters := &cfdflow.Counters{}
+	originConn.Stream(ctx, &flowCountingReadWriteAcker{ReadWriteAcker: rwa, counters: counters}, logger)
+	emitStreamRecord(ctx, record, counters)
 	return nil
 }
 
@@ -318,6 +606,7 @@ func (p *Proxy) proxyTCPStream(
 	tunnelConn connection.ReadWriteAcker,
 	dest netip.AddrPort,
 	originDialer ingress.OriginTCPDialer,
+	record cfdflow.Record,
 	logger *zerolog.Logger,
 ) error {
 	ctx := tr.Context
@@ -344,7 +633,11 @@ func (p *Proxy) proxyTCPStream(
 	connectLatency.Observe(float64(time.Since(start).Milliseconds()))
 	logger.Debug().Msg("proxy stream acknowledged")
 
-	stream.Pipe(tunnelConn, originConn, logger)
+	record.Src = originConn.LocalAddr().String()
+	record.Start = start
+	counters := &cfdflow.Counters{}
+	stream.PipeWithBackpressure(&flowCountingReadWriteAcker{ReadWriteAcker: tunnelConn, counters: counters}, originConn, p.warpRoutingBackpressure, logger)
+	emitStreamRecord(ctx, record, counters)
 	return nil
 }
 
@@ -378,6 +671,21 @@ func (p *Proxy) appendTagHeaders(r *http.Request) {
 	}
 }
 
+// appendFingerprintHeaders forwards the client fingerprints provided by the edge to the origin, using the header names
+// configured for the rule. Configured headers are always stripped first so that eyeballs can't spoof them.
+func appendFingerprintHeaders(rule *ingress.Rule, r *http.Request) {
+	if len(rule.Config.FingerprintHeaders) == 0 {
+		return
+	}
+	fingerprints := connection.FingerprintsFromContext(r.Context())
+	for name, header := range rule.Config.FingerprintHeaders {
+		r.Header.Del(header)
+		if value, ok := fingerprints[strings.ToLower(name)]; ok {
+			r.Header.Set(header, value)
+		}
+	}
+}
+
 func copyTrailers(w connection.ResponseWriter, response *http.Response) {
 	for trailerHeader, trailerValues := range response.Trailer {
 		for _, trailerValue := range trailerValues {
@@ -386,6 +694,20 @@ func copyTrailers(w connection.ResponseWriter, response *http.Response) {
 	}
 }
 
+// streamClientInfo describes the eyeball of a stream from the headers added by the edge. The edge doesn't report
+// the port of the eyeball.
+func streamClientInfo(req *http.Request) ingress.StreamClientInfo {
+	var addr netip.AddrPort
+	if ip, err := netip.ParseAddr(req.Header.Get("Cf-Connecting-Ip")); err == nil {
+		addr = netip.AddrPortFrom(ip, 0)
+	}
+	return ingress.StreamClientInfo{
+		Addr:      addr,
+		Authority: req.Host,
+		UniqueID:  connection.FindCfRayHeader(req),
+	}
+}
+
 func getDestFromRule(rule *ingress.Rule, req *http.Request) (string, error) {
 	switch rule.Service.String() {
 	case ingress.ServiceBastion:
@@ -10,13 +10,17 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/gobwas/ws/wsutil"
 	gorillaWS "github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -28,11 +32,14 @@ import (
 
 	cfdflow "github.com/cloudflare/cloudflared/flow"
 
+	"github.com/cloudflare/cloudflared/accesslog"
+	"github.com/cloudflare/cloudflared/accounting"
 	"github.com/cloudflare/cloudflared/cfio"
 	"github.com/cloudflare/cloudflared/config"
 	"github.com/cloudflare/cloudflared/connection"
 	"github.com/cloudflare/cloudflared/hello"
 	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/inspect"
 	"github.com/cloudflare/cloudflared/tracing"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
 )
@@ -167,7 +174,7 @@ func TestProxySingleOrigin(t *testing.T) {
 		TCPWriteTimeout: 1 * time.Second,
 	}, &log)
 
-	proxy := NewOriginProxy(ingressRule, originDialer, testTags, cfdflow.NewLimiter(0), &log)
+	proxy := NewOriginProxy(ingressRule, originDialer, testTags, cfdflow.NewLimiter(0), nil, nil, nil, nil, nil, cfio.Watermarks{}, &log)
 	t.Run("testProxyHTTP", testProxyHTTP(proxy))
 	t.Run("testProxyWebsocket", testProxyWebsocket(proxy))
 	t.Run("testProxySSE", testProxySSE(proxy))
@@ -355,6 +362,296 @@ func TestProxyMultipleOrigins(t *testing.T) {
 	runIngressTestScenarios(t, unvalidatedIngress, tests)
 }
 
+func TestProxyFingerprintHeaders(t *testing.T) {
+	echoHeaders := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "ja3=%s;ja4=%s", r.Header.Get("X-JA3"), r.Header.Get("X-JA4"))
+	}))
+	defer echoHeaders.Close()
+
+	ingressRule, err := ingress.ParseIngress(&config.Configuration{
+		TunnelID: t.Name(),
+		Ingress: []config.UnvalidatedIngressRule{
+			{
+				Service: echoHeaders.URL,
+				OriginRequest: config.OriginRequestConfig{
+					FingerprintHeaders: map[string]string{"ja3": "X-JA3", "ja4": "X-JA4"},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	log := zerolog.Nop()
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	require.NoError(t, ingressRule.StartOrigins(&log, ctx.Done()))
+	proxy := NewOriginProxy(ingressRule, nil, nil, cfdflow.NewLimiter(0), nil, nil, nil, nil, nil, cfio.Watermarks{}, &log)
+
+	reqCtx := connection.ContextWithFingerprints(ctx, connection.Fingerprints{"ja3": "771,4865-4866"})
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, "http://example.com", nil)
+	require.NoError(t, err)
+	// Eyeballs must not be able to spoof fingerprints the edge didn't provide
+	req.Header.Set("X-JA4", "spoofed")
+
+	responseWriter := newMockHTTPRespWriter()
+	require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, &log), false))
+	assert.Equal(t, http.StatusOK, responseWriter.Code)
+	assert.Equal(t, "ja3=771,4865-4866;ja4=", responseWriter.Body.String())
+}
+
+func TestProxyRetriesIdempotentRequests(t *testing.T) {
+	var attempts atomic.Int32
+	flakyOrigin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = fmt.Fprint(w, "ok")
+	}))
+	defer flakyOrigin.Close()
+
+	proxy := newTestProxy(t, config.OriginRequestConfig{
+		Retry: &config.RetryConfig{MaxRetries: 2},
+	}, flakyOrigin.URL)
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	require.NoError(t, err)
+	responseWriter := newMockHTTPRespWriter()
+	require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, proxy.log), false))
+	assert.Equal(t, http.StatusOK, responseWriter.Code)
+	assert.Equal(t, "ok", responseWriter.Body.String())
+	assert.Equal(t, int32(3), attempts.Load())
+
+	// Non idempotent requests are never retried
+	attempts.Store(0)
+	req, err = http.NewRequest(http.MethodPost, "http://example.com", nil)
+	require.NoError(t, err)
+	responseWriter = newMockHTTPRespWriter()
+	require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, proxy.log), false))
+	assert.Equal(t, http.StatusBadGateway, responseWriter.Code)
+	assert.Equal(t, int32(1), attempts.Load())
+}
+
+func TestProxyCircuitBreaker(t *testing.T) {
+	var originHits atomic.Int32
+	failingOrigin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originHits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failingOrigin.Close()
+
+	proxy := newTestProxy(t, config.OriginRequestConfig{
+		CircuitBreaker: &config.CircuitBreakerConfig{
+			FailureThreshold: 2,
+			OpenDuration:     config.CustomDuration{Duration: time.Minute},
+			ErrorStatus:      http.StatusTooManyRequests,
+			ErrorBody:        "origin is down",
+		},
+	}, failingOrigin.URL)
+	breaker := proxy.circuitBreakers[0]
+	require.NotNil(t, breaker)
+	now := time.Now()
+	breaker.nowFunc = func() time.Time { return now }
+
+	proxyRequest := func() *mockHTTPRespWriter {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		require.NoError(t, err)
+		responseWriter := newMockHTTPRespWriter()
+		require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, proxy.log), false))
+		return responseWriter
+	}
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusServiceUnavailable, proxyRequest().Code)
+	}
+	require.Equal(t, int32(2), originHits.Load())
+
+	// The circuit is open, requests don't reach the origin anymore
+	responseWriter := proxyRequest()
+	assert.Equal(t, http.StatusTooManyRequests, responseWriter.Code)
+	assert.Equal(t, "origin is down", responseWriter.Body.String())
+	require.Equal(t, int32(2), originHits.Load())
+
+	// After the open duration a probe is let through, and its failure opens the circuit again
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusServiceUnavailable, proxyRequest().Code)
+	require.Equal(t, int32(3), originHits.Load())
+	assert.Equal(t, http.StatusTooManyRequests, proxyRequest().Code)
+	require.Equal(t, int32(3), originHits.Load())
+}
+
+func TestProxyCircuitBreakerWithCache(t *testing.T) {
+	var originHits atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originHits.Add(1)
+		if r.URL.Path == "/cached" {
+			w.Header().Set("Cache-Control", "max-age=3600")
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer origin.Close()
+
+	proxy := newTestProxy(t, config.OriginRequestConfig{
+		Cache: &config.CacheConfig{Enabled: true},
+		CircuitBreaker: &config.CircuitBreakerConfig{
+			FailureThreshold: 1,
+			OpenDuration:     config.CustomDuration{Duration: time.Minute},
+			ErrorStatus:      http.StatusTooManyRequests,
+		},
+	}, origin.URL)
+	breaker := proxy.circuitBreakers[0]
+	require.NotNil(t, breaker)
+	now := time.Now()
+	breaker.nowFunc = func() time.Time { return now }
+
+	proxyRequest := func(path string) int {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		require.NoError(t, err)
+		responseWriter := newMockHTTPRespWriter()
+		require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, proxy.log), false))
+		return responseWriter.Code
+	}
+
+	assert.Equal(t, http.StatusOK, proxyRequest("/cached"))
+	assert.Equal(t, http.StatusServiceUnavailable, proxyRequest("/down"))
+	require.Equal(t, int32(2), originHits.Load())
+
+	// The cached responses are still served while the circuit is open
+	assert.Equal(t, http.StatusOK, proxyRequest("/cached"))
+	assert.Equal(t, http.StatusTooManyRequests, proxyRequest("/down"))
+	require.Equal(t, int32(2), originHits.Load())
+
+	// Once half-open, a cache hit doesn't take the probe slot, so the next request still probes the origin
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusOK, proxyRequest("/cached"))
+	assert.Equal(t, http.StatusServiceUnavailable, proxyRequest("/down"))
+	require.Equal(t, int32(3), originHits.Load())
+	assert.Equal(t, http.StatusTooManyRequests, proxyRequest("/down"))
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusOK, proxyRequest("/cached"))
+	assert.Equal(t, http.StatusServiceUnavailable, proxyRequest("/down"))
+	require.Equal(t, int32(4), originHits.Load())
+}
+
+func TestProxyRateLimit(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer origin.Close()
+
+	proxy := newTestProxy(t, config.OriginRequestConfig{
+		RateLimit: &config.RateLimitConfig{RequestsPerSecond: 0.5, Burst: 2},
+	}, origin.URL)
+	limiter := proxy.rateLimiters[0]
+	require.NotNil(t, limiter)
+	now := time.Now()
+	limiter.nowFunc = func() time.Time { return now }
+
+	proxyRequest := func(eyeballIP string) *mockHTTPRespWriter {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		require.NoError(t, err)
+		req.Header.Set("Cf-Connecting-Ip", eyeballIP)
+		responseWriter := newMockHTTPRespWriter()
+		require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, proxy.log), false))
+		return responseWriter
+	}
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, proxyRequest("198.51.100.1").Code)
+	}
+	responseWriter := proxyRequest("198.51.100.1")
+	assert.Equal(t, http.StatusTooManyRequests, responseWriter.Code)
+	assert.Equal(t, "2", responseWriter.Header().Get("Retry-After"))
+
+	// Each eyeball has its own bucket
+	assert.Equal(t, http.StatusOK, proxyRequest("198.51.100.2").Code)
+
+	// Buckets are refilled at the configured rate
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, http.StatusOK, proxyRequest("198.51.100.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, proxyRequest("198.51.100.1").Code)
+}
+
+func TestRateLimiterByHeader(t *testing.T) {
+	limiter := newRateLimiter(config.RateLimitConfig{RequestsPerSecond: 1, Key: ingress.RateLimitKeyHeader, Header: "X-Api-Key"}, 0)
+	now := time.Now()
+	limiter.nowFunc = func() time.Time { return now }
+	request := func(apiKey string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+		req.Header.Set("X-Api-Key", apiKey)
+		return req
+	}
+
+	allowed, _ := limiter.allow(request("a"))
+	assert.True(t, allowed)
+	allowed, retryAfter := limiter.allow(request("a"))
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, retryAfter)
+	allowed, _ = limiter.allow(request("b"))
+	assert.True(t, allowed)
+
+	// Full buckets are forgotten
+	now = now.Add(rateLimitSweepInterval)
+	allowed, _ = limiter.allow(request("c"))
+	assert.True(t, allowed)
+	assert.Len(t, limiter.buckets, 1)
+}
+
+func TestProxyInspection(t *testing.T) {
+	events := make(chan *inspect.Event, 1)
+	inspect.Register(t.Name(), inspect.InspectorFunc(func(ctx context.Context, event *inspect.Event) {
+		events <- event
+	}))
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = fmt.Fprint(w, "card number 4111 1111 1111 1111")
+	}))
+	defer origin.Close()
+
+	proxy := newTestProxy(t, config.OriginRequestConfig{
+		Inspection: &config.InspectionConfig{Inspectors: []string{t.Name()}, SampleBytes: 11},
+	}, origin.URL)
+
+	req, err := http.NewRequest(http.MethodPost, "http://example.com/upload", strings.NewReader("secret=hunter2"))
+	require.NoError(t, err)
+	responseWriter := newMockHTTPRespWriter()
+	require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, proxy.log), false))
+	require.Equal(t, http.StatusOK, responseWriter.Code)
+
+	select {
+	case event := <-events:
+		assert.Equal(t, "example.com", event.Hostname)
+		assert.Equal(t, http.MethodPost, event.Method)
+		assert.Equal(t, "/upload", event.Path)
+		assert.Equal(t, http.StatusOK, event.StatusCode)
+		assert.Equal(t, "text/plain", event.ResponseHeader.Get("Content-Type"))
+		assert.Equal(t, "secret=hunt", string(event.RequestSample))
+		assert.Equal(t, "card number", string(event.ResponseSample))
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "request wasn't inspected")
+	}
+}
+
+func newTestProxy(t *testing.T, originRequest config.OriginRequestConfig, service string) *Proxy {
+	ingressRule, err := ingress.ParseIngress(&config.Configuration{
+		TunnelID: t.Name(),
+		Ingress: []config.UnvalidatedIngressRule{
+			{
+				Service:       service,
+				OriginRequest: originRequest,
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	log := zerolog.Nop()
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	require.NoError(t, ingressRule.StartOrigins(&log, ctx.Done()))
+	return NewOriginProxy(ingressRule, nil, nil, cfdflow.NewLimiter(0), nil, nil, nil, nil, nil, cfio.Watermarks{}, &log)
+}
+
 type MultipleIngressTest struct {
 	url            string
 	expectedStatus int
@@ -378,7 +675,7 @@ func runIngressTestScenarios(t *testing.T, unvalidatedIngress []config.Unvalidat
 		TCPWriteTimeout: 1 * time.Second,
 	}, &log)
 
-	proxy := NewOriginProxy(ingressRule, originDialer, testTags, cfdflow.NewLimiter(0), &log)
+	proxy := NewOriginProxy(ingressRule, originDialer, testTags, cfdflow.NewLimiter(0), nil, nil, nil, nil, nil, cfio.Watermarks{}, &log)
 
 	for _, test := range tests {
 		responseWriter := newMockHTTPRespWriter()
@@ -431,7 +728,7 @@ func TestProxyError(t *testing.T) {
 		TCPWriteTimeout: 1 * time.Second,
 	}, &log)
 
-	proxy := NewOriginProxy(ing, originDialer, testTags, cfdflow.NewLimiter(0), &log)
+	proxy := NewOriginProxy(ing, originDialer, testTags, cfdflow.NewLimiter(0), nil, nil, nil, nil, nil, cfio.Watermarks{}, &log)
 
 	responseWriter := newMockHTTPRespWriter()
 	req, err := http.NewRequest(http.MethodGet, "http://127.0.0.1", nil)
@@ -699,7 +996,7 @@ func TestConnections(t *testing.T) {
 			flowLimiter.EXPECT().Acquire("tcp").AnyTimes().Return(test.args.flowLimiterResponse)
 			flowLimiter.EXPECT().Release().AnyTimes()
 
-			proxy := NewOriginProxy(ingressRule, originDialer, testTags, flowLimiter, &log)
+			proxy := NewOriginProxy(ingressRule, originDialer, testTags, flowLimiter, nil, nil, nil, nil, nil, cfio.Watermarks{}, &log)
 
 			dest := ln.Addr().String()
 			req, err := http.NewRequest(
@@ -1014,3 +1311,143 @@ func runEchoWSService(t *testing.T, l net.Listener) {
 		}
 	}()
 }
+
+func TestProxyUsageQuota(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer origin.Close()
+
+	proxy := newTestProxy(t, config.OriginRequestConfig{}, origin.URL)
+	meter, err := accounting.New(accounting.Config{
+		Store: accounting.NewFileStore(filepath.Join(t.TempDir(), "usage.json")),
+		Quota: accounting.Quota{Requests: 1, Action: accounting.QuotaThrottle},
+	}, proxy.log)
+	require.NoError(t, err)
+	proxy.meter = meter
+	proxy.accessLog = accesslog.New(meter)
+
+	proxyRequest := func() *mockHTTPRespWriter {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		require.NoError(t, err)
+		responseWriter := newMockHTTPRespWriter()
+		require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, proxy.log), false))
+		return responseWriter
+	}
+	assert.Equal(t, http.StatusOK, proxyRequest().Code)
+	assert.Equal(t, http.StatusTooManyRequests, proxyRequest().Code)
+}
+
+func TestProxyAccessLog(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("created"))
+	}))
+	defer origin.Close()
+
+	proxy := newTestProxy(t, config.OriginRequestConfig{}, origin.URL)
+	ring := accesslog.NewRing(10)
+	proxy.accessLog = accesslog.New(ring)
+
+	req, err := http.NewRequest(http.MethodPost, "http://example.com/resource", nil)
+	require.NoError(t, err)
+	req.Header.Set("Cf-Connecting-Ip", "198.51.100.1")
+	req.Header.Set("Cf-Ray", "8a1b2c3d4e5f6a7b-LHR")
+	responseWriter := newMockHTTPRespWriter()
+	require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 3, proxy.log), false))
+	assert.Equal(t, http.StatusCreated, responseWriter.Code)
+
+	entries := ring.Entries()
+	require.Len(t, entries, 1)
+	entry := entries[0]
+	assert.Equal(t, http.MethodPost, entry.Method)
+	assert.Equal(t, "example.com", entry.Host)
+	assert.Equal(t, "/resource", entry.Path)
+	assert.Equal(t, http.StatusCreated, entry.Status)
+	assert.Equal(t, int64(len("created")), entry.Bytes)
+	assert.Equal(t, "198.51.100.1", entry.EyeballIP)
+	assert.Equal(t, "8a1b2c3d4e5f6a7b-LHR", entry.CfRay)
+	assert.Equal(t, 0, entry.Rule)
+	assert.Equal(t, uint8(3), entry.ConnIndex)
+}
+
+func TestProxyOriginMetrics(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer origin.Close()
+
+	proxy := newTestProxy(t, config.OriginRequestConfig{}, origin.URL)
+	histogramCount := func(histogram *prometheus.HistogramVec) uint64 {
+		var m dto.Metric
+		require.NoError(t, histogram.WithLabelValues("0").(prometheus.Metric).Write(&m))
+		return m.GetHistogram().GetSampleCount()
+	}
+	responses := func() float64 {
+		var m dto.Metric
+		require.NoError(t, originResponses.WithLabelValues("0", "202").Write(&m))
+		return m.GetCounter().GetValue()
+	}
+	connectsBefore, ttfbBefore, durationBefore, responsesBefore := histogramCount(originConnectDuration),
+		histogramCount(originTTFB), histogramCount(originRequestDuration), responses()
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		require.NoError(t, err)
+		responseWriter := newMockHTTPRespWriter()
+		require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, proxy.log), false))
+		assert.Equal(t, http.StatusAccepted, responseWriter.Code)
+	}
+
+	// The second request reuses the connection of the first one
+	assert.Equal(t, connectsBefore+1, histogramCount(originConnectDuration))
+	assert.Equal(t, ttfbBefore+2, histogramCount(originTTFB))
+	assert.Equal(t, durationBefore+2, histogramCount(originRequestDuration))
+	assert.Equal(t, responsesBefore+2, responses())
+}
+
+func TestProxyTCPFlowRecord(t *testing.T) {
+	log := zerolog.Nop()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, len("test"))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("echo-test"))
+	}()
+
+	var records []cfdflow.Record
+	cfdflow.SetRecorder(func(record cfdflow.Record) {
+		records = append(records, record)
+	})
+	defer cfdflow.SetRecorder(nil)
+
+	originDialer := ingress.NewOriginDialer(ingress.OriginConfig{DefaultDialer: testDefaultDialer}, &log)
+	proxy := NewOriginProxy(ingress.Ingress{}, originDialer, testTags, cfdflow.NewLimiter(0), nil, nil, nil, nil, nil, cfio.Watermarks{}, &log)
+
+	replayer := &replayer{rw: bytes.NewBuffer([]byte{})}
+	respWriter := newTCPRespWriter(replayer)
+	req, err := http.NewRequest(http.MethodGet, "tcp://"+ln.Addr().String(), newTCPRequestBody([]byte("test")))
+	require.NoError(t, err)
+	rwa := connection.NewHTTPResponseReadWriterAcker(respWriter, respWriter, req)
+	err = proxy.ProxyTCP(t.Context(), rwa, &connection.TCPRequest{Dest: ln.Addr().String(), FlowID: "flow-1", ConnIndex: 2})
+	require.NoError(t, err)
+
+	require.Len(t, records, 1)
+	record := records[0]
+	assert.Equal(t, cfdflow.KindTCP, record.Kind)
+	assert.Equal(t, "flow-1", record.ID)
+	assert.Equal(t, ln.Addr().String(), record.Dst)
+	assert.NotEmpty(t, record.Src)
+	assert.Equal(t, warpRoutingRoute, record.Route)
+	assert.Equal(t, uint8(2), record.ConnIndex)
+	assert.Equal(t, uint64(len("test")), record.BytesToOrigin)
+	assert.Equal(t, uint64(len("echo-test")), record.BytesFromOrigin)
+	assert.Equal(t, cfdflow.CloseCompleted, record.CloseReason)
+}
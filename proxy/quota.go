@@ -0,0 +1,15 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+// writeQuotaExceededResponse rejects a request while the usage quota of the tunnel is exceeded. The quota is reset
+// with the next accounting period, which is too far away for a Retry-After.
+func writeQuotaExceededResponse(w connection.ResponseWriter) error {
+	headers := http.Header{}
+	headers.Set("Content-Length", "0")
+	return w.WriteRespHeaders(http.StatusTooManyRequests, headers)
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+const (
+	// Buckets that are full again are forgotten every rateLimitSweepInterval.
+	rateLimitSweepInterval = time.Minute
+	// Past maxRateLimitBuckets keys, the requests of new keys share a single bucket until the next sweep.
+	maxRateLimitBuckets  = 100_000
+	rateLimitOverflowKey = "\x00overflow"
+)
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter throttles the requests of an ingress rule with a token bucket per key, the eyeball IP or the value of
+// a request header. Requests without key share a bucket.
+type rateLimiter struct {
+	rule   string
+	rate   float64
+	burst  float64
+	header string
+
+	lock      sync.Mutex
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+
+	// nowFunc is overridden in tests
+	nowFunc func() time.Time
+}
+
+func newRateLimiter(cfg config.RateLimitConfig, ruleNum int) *rateLimiter {
+	burst := float64(cfg.Burst)
+	if burst == 0 {
+		burst = math.Max(1, math.Ceil(cfg.RequestsPerSecond))
+	}
+	header := "Cf-Connecting-Ip"
+	if cfg.Key == ingress.RateLimitKeyHeader {
+		header = cfg.Header
+	}
+	return &rateLimiter{
+		rule:      strconv.Itoa(ruleNum),
+		rate:      cfg.RequestsPerSecond,
+		burst:     burst,
+		header:    header,
+		buckets:   make(map[string]*rateBucket),
+		lastSweep: time.Now(),
+		nowFunc:   time.Now,
+	}
+}
+
+// allow takes a token from the bucket of the request. When the bucket is empty, it returns how long until the next
+// token.
+func (rl *rateLimiter) allow(req *http.Request) (bool, time.Duration) {
+	key := req.Header.Get(rl.header)
+	now := rl.nowFunc()
+
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	if now.Sub(rl.lastSweep) >= rateLimitSweepInterval {
+		rl.sweep(now)
+	}
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		if len(rl.buckets) >= maxRateLimitBuckets {
+			key = rateLimitOverflowKey
+			bucket, ok = rl.buckets[key]
+		}
+		if !ok {
+			bucket = &rateBucket{tokens: rl.burst, last: now}
+			rl.buckets[key] = bucket
+		}
+	}
+	rl.refill(bucket, now)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	rateLimitedRequests.WithLabelValues(rl.rule).Inc()
+	return false, time.Duration((1 - bucket.tokens) / rl.rate * float64(time.Second))
+}
+
+// caller must hold the lock
+func (rl *rateLimiter) refill(bucket *rateBucket, now time.Time) {
+	if elapsed := now.Sub(bucket.last); elapsed > 0 {
+		bucket.tokens = math.Min(rl.burst, bucket.tokens+elapsed.Seconds()*rl.rate)
+		bucket.last = now
+	}
+}
+
+// sweep forgets the buckets that are full again, since they'd be created full anyway. Caller must hold the lock.
+func (rl *rateLimiter) sweep(now time.Time) {
+	for key, bucket := range rl.buckets {
+		rl.refill(bucket, now)
+		if bucket.tokens >= rl.burst {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.lastSweep = now
+}
+
+// writeThrottledResponse tells the eyeball to retry once its bucket has a token again.
+func (rl *rateLimiter) writeThrottledResponse(w connection.ResponseWriter, retryAfter time.Duration) error {
+	headers := http.Header{}
+	headers.Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(retryAfter.Seconds())))))
+	headers.Set("Content-Length", "0")
+	return w.WriteRespHeaders(http.StatusTooManyRequests, headers)
+}
@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+const (
+	requestLimitBodySize   = "body_size"
+	requestLimitHeaderSize = "header_size"
+	requestLimitBodyTime   = "body_timeout"
+)
+
+var (
+	errRequestBodyTooLarge = errors.New("request body is larger than the limit of the ingress rule")
+	errRequestBodyTimeout  = errors.New("request body wasn't received in time")
+)
+
+// requestHeaderSize approximates the size of the request line and headers as sent in HTTP/1.1.
+func requestHeaderSize(req *http.Request) int {
+	size := len(req.Method) + len(req.URL.RequestURI()) + len("Host: ") + len(req.Host)
+	for name, values := range req.Header {
+		for _, value := range values {
+			size += len(name) + len(value) + len(": \r\n")
+		}
+	}
+	return size
+}
+
+// checkRequestSize rejects the requests whose headers or announced body exceed the limits of the rule, returning the
+// status they are rejected with.
+func checkRequestSize(req *http.Request, limits config.RequestLimitsConfig, ruleNum int) int {
+	if limits.MaxHeaderSize > 0 && requestHeaderSize(req) > limits.MaxHeaderSize {
+		requestLimitRejections.WithLabelValues(strconv.Itoa(ruleNum), requestLimitHeaderSize).Inc()
+		return http.StatusRequestHeaderFieldsTooLarge
+	}
+	if limits.MaxBodySize > 0 && req.ContentLength > limits.MaxBodySize {
+		requestLimitRejections.WithLabelValues(strconv.Itoa(ruleNum), requestLimitBodySize).Inc()
+		return http.StatusRequestEntityTooLarge
+	}
+	return 0
+}
+
+// limitedBody enforces the size and read timeouts of a request body whose length isn't announced or is within the
+// limit. Once a limit is exceeded, the body of the eyeball is closed and reads fail, so that the origin stops waiting
+// for it.
+type limitedBody struct {
+	io.ReadCloser
+	maxSize     int64
+	idleTimeout time.Duration
+	rule        string
+
+	read      int64
+	lock      sync.Mutex
+	err       error
+	idleTimer *time.Timer
+	timer     *time.Timer
+}
+
+// limitRequestBody wraps the body of req to enforce the limits of the rule, or returns nil if there's nothing to
+// enforce.
+func limitRequestBody(req *http.Request, limits config.RequestLimitsConfig, ruleNum int) *limitedBody {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	if limits.MaxBodySize <= 0 && limits.ReadBodyTimeout.Duration <= 0 && limits.ReadBodyIdleTimeout.Duration <= 0 {
+		return nil
+	}
+	body := &limitedBody{
+		ReadCloser:  req.Body,
+		maxSize:     limits.MaxBodySize,
+		idleTimeout: limits.ReadBodyIdleTimeout.Duration,
+		rule:        strconv.Itoa(ruleNum),
+	}
+	if timeout := limits.ReadBodyTimeout.Duration; timeout > 0 {
+		body.timer = time.AfterFunc(timeout, func() { body.fail(errRequestBodyTimeout) })
+	}
+	if body.idleTimeout > 0 {
+		body.idleTimer = time.AfterFunc(body.idleTimeout, func() { body.fail(errRequestBodyTimeout) })
+	}
+	req.Body = body
+	return body
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if err := b.failure(); err != nil {
+		return 0, err
+	}
+	n, err := b.ReadCloser.Read(p)
+	if failure := b.failure(); failure != nil {
+		return 0, failure
+	}
+	b.read += int64(n)
+	if b.maxSize > 0 && b.read > b.maxSize {
+		b.fail(errRequestBodyTooLarge)
+		return 0, errRequestBodyTooLarge
+	}
+	if err != nil {
+		// The whole body was received
+		b.stopTimers()
+		return n, err
+	}
+	if b.idleTimer != nil {
+		b.idleTimer.Reset(b.idleTimeout)
+	}
+	return n, nil
+}
+
+func (b *limitedBody) Close() error {
+	b.stopTimers()
+	return b.ReadCloser.Close()
+}
+
+func (b *limitedBody) fail(err error) {
+	b.lock.Lock()
+	if b.err != nil {
+		b.lock.Unlock()
+		return
+	}
+	b.err = err
+	b.lock.Unlock()
+
+	b.stopTimers()
+	reason := requestLimitBodySize
+	if errors.Is(err, errRequestBodyTimeout) {
+		reason = requestLimitBodyTime
+	}
+	requestLimitRejections.WithLabelValues(b.rule, reason).Inc()
+	// Unblocks a pending read of the eyeball's body
+	_ = b.ReadCloser.Close()
+}
+
+func (b *limitedBody) failure() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.err
+}
+
+func (b *limitedBody) stopTimers() {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	if b.idleTimer != nil {
+		b.idleTimer.Stop()
+	}
+}
+
+// rejectedStatus returns the status the request is rejected with if its body exceeded a limit, otherwise 0.
+func (b *limitedBody) rejectedStatus() int {
+	if b == nil {
+		return 0
+	}
+	switch b.failure() {
+	case errRequestBodyTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case errRequestBodyTimeout:
+		return http.StatusRequestTimeout
+	default:
+		return 0
+	}
+}
+
+// writeRequestLimitResponse tells the eyeball its request was rejected, closing the connection since the rest of
+// the request body won't be read.
+func writeRequestLimitResponse(w connection.ResponseWriter, status int) error {
+	headers := http.Header{}
+	headers.Set("Content-Length", "0")
+	headers.Set("Connection", "close")
+	return w.WriteRespHeaders(status, headers)
+}
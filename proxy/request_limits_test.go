@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/tracing"
+)
+
+func TestProxyRequestLimits(t *testing.T) {
+	var originHits atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originHits.Add(1)
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	defer origin.Close()
+
+	proxy := newTestProxy(t, config.OriginRequestConfig{
+		RequestLimits: &config.RequestLimitsConfig{
+			MaxBodySize:         8,
+			MaxHeaderSize:       512,
+			ReadBodyIdleTimeout: config.CustomDuration{Duration: 100 * time.Millisecond},
+		},
+	}, origin.URL)
+
+	proxyRequest := func(body io.Reader, contentLength int64, header http.Header) *mockHTTPRespWriter {
+		req, err := http.NewRequest(http.MethodPost, "http://example.com", body)
+		require.NoError(t, err)
+		req.ContentLength = contentLength
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		responseWriter := newMockHTTPRespWriter()
+		require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, proxy.log), false))
+		return responseWriter
+	}
+
+	responseWriter := proxyRequest(strings.NewReader("hello"), 5, nil)
+	assert.Equal(t, http.StatusOK, responseWriter.Code)
+	assert.Equal(t, "hello", responseWriter.Body.String())
+	require.Equal(t, int32(1), originHits.Load())
+
+	// Requests announcing too large bodies or headers don't reach the origin
+	assert.Equal(t, http.StatusRequestEntityTooLarge, proxyRequest(strings.NewReader("hello world"), 11, nil).Code)
+	largeHeader := http.Header{"X-Large": []string{strings.Repeat("a", 512)}}
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, proxyRequest(strings.NewReader("hello"), 5, largeHeader).Code)
+	require.Equal(t, int32(1), originHits.Load())
+
+	// Bodies of unknown length are cut once they exceed the limit
+	assert.Equal(t, http.StatusRequestEntityTooLarge, proxyRequest(io.MultiReader(strings.NewReader("hello world")), -1, nil).Code)
+
+	// Eyeballs stalling while sending the body time out
+	stalled, stalledWriter := io.Pipe()
+	defer stalledWriter.Close()
+	go func() {
+		_, _ = stalledWriter.Write([]byte("hel"))
+	}()
+	assert.Equal(t, http.StatusRequestTimeout, proxyRequest(stalled, -1, nil).Code)
+}
+
+func TestRequestHeaderSize(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/path?query=1", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "*/*")
+	// GET /path?query=1, Host: example.com, Accept: */*
+	assert.Equal(t, 3+len("/path?query=1")+len("Host: example.com")+len("Accept: */*\r\n"), requestHeaderSize(req))
+}
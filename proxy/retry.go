@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+const (
+	defaultRetryBudgetPercent = 20
+	retryBudgetWindow         = 10 * time.Second
+	// Retries are always allowed for the first requests of a window, so that low traffic rules can still retry.
+	minRetriesPerWindow = 3
+)
+
+// retrier retries idempotent requests that failed to reach the origin, bounded by a retry budget so that
+// retries can't multiply the load on an origin that is already struggling.
+type retrier struct {
+	config config.RetryConfig
+	budget *budget
+	rule   string
+}
+
+func newRetrier(cfg config.RetryConfig, ruleNum int) *retrier {
+	if cfg.BudgetPercent == 0 {
+		cfg.BudgetPercent = defaultRetryBudgetPercent
+	}
+	return &retrier{
+		config: cfg,
+		budget: newBudget(cfg.BudgetPercent, minRetriesPerWindow, retryBudgetWindow),
+		rule:   strconv.Itoa(ruleNum),
+	}
+}
+
+// isRetryable reports whether a request can be safely sent to the origin more than once.
+func isRetryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	// Bodies are streamed from the edge, so they can't be replayed
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+// acquireRetry takes a retry from the budget, returning false if the budget is exhausted.
+func (r *retrier) acquireRetry() bool {
+	if !r.budget.acquire() {
+		originRetriesBudgetExhausted.WithLabelValues(r.rule).Inc()
+		return false
+	}
+	originRetries.WithLabelValues(r.rule).Inc()
+	return true
+}
+
+// roundTrip sends the request to the origin, retrying it while the origin is unavailable and the retry budget
+// allows it.
+func (r *retrier) roundTrip(originProxy ingress.HTTPOriginProxy, req *http.Request) (*http.Response, error) {
+	r.budget.countRequest()
+	retryable := isRetryable(req)
+	for attempt := uint(0); ; attempt++ {
+		resp, err := r.attempt(originProxy, req)
+		if !isOriginFailure(resp, err) || !retryable || attempt >= r.config.MaxRetries {
+			return resp, err
+		}
+		// The eyeball went away, no point in retrying
+		if req.Context().Err() != nil {
+			return resp, err
+		}
+		if !r.acquireRetry() {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			_ = resp.Body.Close()
+		}
+	}
+}
+
+// attempt performs a single round trip bounded by the per-try timeout. The timeout only applies until the response
+// headers are received, the body can be streamed for as long as needed.
+func (r *retrier) attempt(originProxy ingress.HTTPOriginProxy, req *http.Request) (*http.Response, error) {
+	timeout := r.config.PerTryTimeout.Duration
+	if timeout <= 0 {
+		return originProxy.RoundTrip(req)
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(timeout, cancel)
+	resp, err := originProxy.RoundTrip(req.WithContext(ctx))
+	if err != nil || !timer.Stop() {
+		cancel()
+		if err == nil {
+			_ = resp.Body.Close()
+			err = context.DeadlineExceeded
+		}
+		return nil, err
+	}
+	resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
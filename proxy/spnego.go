@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/spnego"
+)
+
+const (
+	defaultSPNEGORefreshInterval = time.Hour
+	spnegoRefreshTimeout         = 30 * time.Second
+	negotiateScheme              = "Negotiate"
+
+	spnegoResultAccepted   = "accepted"
+	spnegoResultRejected   = "rejected"
+	spnegoResultTokenError = "token_error"
+)
+
+// spnegoAuthenticator authenticates the requests of an ingress rule to an origin with Windows integrated
+// authentication, attaching a new SPNEGO token to each request. The ticket cache is renewed periodically, and when the
+// origin rejects a token, in which case the request is sent again if its body can be replayed.
+type spnegoAuthenticator struct {
+	provider        spnego.Provider
+	refresher       spnego.Refresher
+	spn             string
+	refreshInterval time.Duration
+	rule            string
+	log             *zerolog.Logger
+
+	lock        sync.Mutex
+	lastRefresh time.Time
+	refreshing  bool
+}
+
+func newSPNEGOAuthenticator(cfg config.SPNEGOConfig, service ingress.OriginService, ruleNum int, log *zerolog.Logger) (*spnegoAuthenticator, error) {
+	provider, refresher, err := spnego.NewProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	spn := cfg.SPN
+	if spn == "" {
+		originURL, err := url.Parse(service.String())
+		if err != nil || originURL.Hostname() == "" {
+			return nil, fmt.Errorf("the SPN of origin %s can't be guessed, it must be configured", service)
+		}
+		spn = "HTTP/" + originURL.Hostname()
+	}
+	refreshInterval := cfg.RefreshInterval.Duration
+	if refreshInterval == 0 {
+		refreshInterval = defaultSPNEGORefreshInterval
+	}
+	return &spnegoAuthenticator{
+		provider:        provider,
+		refresher:       refresher,
+		spn:             spn,
+		refreshInterval: refreshInterval,
+		rule:            strconv.Itoa(ruleNum),
+		log:             log,
+		lastRefresh:     time.Now(),
+	}, nil
+}
+
+// spnegoOrigin authenticates the requests sent to an origin.
+type spnegoOrigin struct {
+	origin        ingress.HTTPOriginProxy
+	authenticator *spnegoAuthenticator
+}
+
+func (o spnegoOrigin) RoundTrip(req *http.Request) (*http.Response, error) {
+	return o.authenticator.roundTrip(o.origin, req)
+}
+
+func (a *spnegoAuthenticator) roundTrip(origin ingress.HTTPOriginProxy, req *http.Request) (*http.Response, error) {
+	a.refreshIfDue()
+	resp, err := a.send(origin, req)
+	if err != nil || !isNegotiateChallenge(resp) || a.refresher == nil {
+		return resp, err
+	}
+	// Bodies are streamed from the edge, so they can't be replayed
+	if req.Body != nil && req.Body != http.NoBody {
+		return resp, nil
+	}
+	// The origin rejected the token, e.g. because the ticket expired: renew the ticket cache and try once more
+	_ = resp.Body.Close()
+	a.refresh(req.Context())
+	return a.send(origin, req)
+}
+
+func (a *spnegoAuthenticator) send(origin ingress.HTTPOriginProxy, req *http.Request) (*http.Response, error) {
+	token, err := a.provider.Token(req.Context(), a.spn)
+	if err != nil {
+		spnegoAuthentications.WithLabelValues(a.rule, spnegoResultTokenError).Inc()
+		return nil, err
+	}
+	authenticated := req.Clone(req.Context())
+	authenticated.Header.Set("Authorization", negotiateScheme+" "+base64.StdEncoding.EncodeToString(token))
+	resp, err := origin.RoundTrip(authenticated)
+	if err != nil {
+		return nil, err
+	}
+	if isNegotiateChallenge(resp) {
+		spnegoAuthentications.WithLabelValues(a.rule, spnegoResultRejected).Inc()
+	} else {
+		spnegoAuthentications.WithLabelValues(a.rule, spnegoResultAccepted).Inc()
+	}
+	return resp, nil
+}
+
+// refreshIfDue renews the ticket cache in the background once the refresh interval elapsed.
+func (a *spnegoAuthenticator) refreshIfDue() {
+	if a.refresher == nil {
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.refreshing || time.Since(a.lastRefresh) < a.refreshInterval {
+		return
+	}
+	a.refreshing = true
+	go a.refresh(context.Background())
+}
+
+func (a *spnegoAuthenticator) refresh(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, spnegoRefreshTimeout)
+	defer cancel()
+	if err := a.refresher.Refresh(ctx); err != nil {
+		a.log.Err(err).Str("spn", a.spn).Msg("Unable to refresh the SPNEGO credentials")
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.lastRefresh = time.Now()
+	a.refreshing = false
+}
+
+// isNegotiateChallenge returns whether the origin asks for the requests to be authenticated with SPNEGO.
+func isNegotiateChallenge(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	for _, challenge := range resp.Header.Values("WWW-Authenticate") {
+		if len(challenge) >= len(negotiateScheme) && strings.EqualFold(challenge[:len(negotiateScheme)], negotiateScheme) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/spnego"
+	"github.com/cloudflare/cloudflared/tracing"
+)
+
+// testKDC issues expired tokens until refreshed.
+type testKDC struct {
+	refreshes atomic.Int32
+	spns      chan string
+}
+
+func (k *testKDC) Token(_ context.Context, spn string) ([]byte, error) {
+	k.spns <- spn
+	if k.refreshes.Load() == 0 {
+		return []byte("expired"), nil
+	}
+	return []byte("fresh"), nil
+}
+
+func (k *testKDC) Refresh(context.Context) error {
+	k.refreshes.Add(1)
+	return nil
+}
+
+func TestProxySPNEGO(t *testing.T) {
+	kdc := &testKDC{spns: make(chan string, 10)}
+	spnego.Register("test-kdc", kdc)
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Negotiate ZnJlc2g=" {
+			w.Header().Set("WWW-Authenticate", "Negotiate")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("authenticated"))
+	}))
+	defer origin.Close()
+
+	proxy := newTestProxy(t, config.OriginRequestConfig{
+		SPNEGO: &config.SPNEGOConfig{Provider: "test-kdc"},
+	}, origin.URL)
+	require.Contains(t, proxy.spnego, 0)
+
+	proxyRequest := func(method, body string) *mockHTTPRespWriter {
+		req, err := http.NewRequest(method, "http://example.com", strings.NewReader(body))
+		require.NoError(t, err)
+		if body == "" {
+			req.Body = http.NoBody
+		}
+		responseWriter := newMockHTTPRespWriter()
+		require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, proxy.log), false))
+		return responseWriter
+	}
+
+	// The expired ticket is rejected, so it's refreshed and the request sent again
+	responseWriter := proxyRequest(http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, responseWriter.Code)
+	assert.Equal(t, "authenticated", responseWriter.Body.String())
+	assert.Equal(t, int32(1), kdc.refreshes.Load())
+	assert.Equal(t, "HTTP/127.0.0.1", <-kdc.spns)
+	assert.Len(t, kdc.spns, 1)
+	<-kdc.spns
+
+	responseWriter = proxyRequest(http.MethodPost, "hello")
+	assert.Equal(t, http.StatusOK, responseWriter.Code)
+	assert.Len(t, kdc.spns, 1)
+}
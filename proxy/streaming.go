@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+// flushWriter flushes the response body written to the edge according to the streaming configuration of a rule,
+// so that streaming responses like SSE or long polling aren't held in the buffers of the connection.
+type flushWriter struct {
+	w           connection.ResponseWriter
+	flusher     http.Flusher
+	always      bool
+	interval    time.Duration
+	maxBuffered uint64
+
+	lock    sync.Mutex
+	pending uint64
+	timer   *time.Timer
+	stopped bool
+}
+
+// newFlushWriter returns a flushWriter for cfg, or nil if the rule leaves flushing to the connection.
+func newFlushWriter(w connection.ResponseWriter, cfg config.StreamingConfig) *flushWriter {
+	always := cfg.Mode == ingress.StreamingModeStream
+	if !always && cfg.FlushInterval.Duration == 0 && cfg.MaxBufferedBytes == 0 {
+		return nil
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil
+	}
+	return &flushWriter{
+		w:           w,
+		flusher:     flusher,
+		always:      always,
+		interval:    cfg.FlushInterval.Duration,
+		maxBuffered: cfg.MaxBufferedBytes,
+	}
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	n, err := f.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	f.pending += uint64(n)
+	switch {
+	case f.always, f.maxBuffered > 0 && f.pending >= f.maxBuffered:
+		f.flush()
+	case f.interval > 0 && f.timer == nil:
+		f.timer = time.AfterFunc(f.interval, f.delayedFlush)
+	}
+	return n, nil
+}
+
+func (f *flushWriter) delayedFlush() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.timer = nil
+	if !f.stopped && f.pending > 0 {
+		f.flush()
+	}
+}
+
+// flush must be called with the lock held.
+func (f *flushWriter) flush() {
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+	f.pending = 0
+	f.flusher.Flush()
+}
+
+// stop flushes the remaining bytes, the response writer must not be used once the response is proxied.
+func (f *flushWriter) stop() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.pending > 0 {
+		f.flush()
+	}
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+	f.stopped = true
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+type flushCountingRespWriter struct {
+	*mockHTTPRespWriter
+	flushes atomic.Int32
+}
+
+func (w *flushCountingRespWriter) Flush() {
+	w.flushes.Add(1)
+}
+
+func TestFlushWriter(t *testing.T) {
+	// Without streaming settings, flushing is left to the connection
+	assert.Nil(t, newFlushWriter(&flushCountingRespWriter{mockHTTPRespWriter: newMockHTTPRespWriter()}, config.StreamingConfig{}))
+
+	t.Run("stream", func(t *testing.T) {
+		w := &flushCountingRespWriter{mockHTTPRespWriter: newMockHTTPRespWriter()}
+		f := newFlushWriter(w, config.StreamingConfig{Mode: ingress.StreamingModeStream})
+		require.NotNil(t, f)
+		for i := 0; i < 3; i++ {
+			_, err := f.Write([]byte("data: event\n\n"))
+			require.NoError(t, err)
+		}
+		f.stop()
+		assert.Equal(t, int32(3), w.flushes.Load())
+		assert.Equal(t, "data: event\n\ndata: event\n\ndata: event\n\n", w.Body.String())
+	})
+
+	t.Run("max buffered bytes", func(t *testing.T) {
+		w := &flushCountingRespWriter{mockHTTPRespWriter: newMockHTTPRespWriter()}
+		f := newFlushWriter(w, config.StreamingConfig{MaxBufferedBytes: 10})
+		for i := 0; i < 4; i++ {
+			_, err := f.Write([]byte("12345"))
+			require.NoError(t, err)
+		}
+		assert.Equal(t, int32(2), w.flushes.Load())
+		_, err := f.Write([]byte("1"))
+		require.NoError(t, err)
+		// The remaining bytes are flushed once the response is proxied
+		f.stop()
+		assert.Equal(t, int32(3), w.flushes.Load())
+	})
+
+	t.Run("flush interval", func(t *testing.T) {
+		w := &flushCountingRespWriter{mockHTTPRespWriter: newMockHTTPRespWriter()}
+		f := newFlushWriter(w, config.StreamingConfig{FlushInterval: config.CustomDuration{Duration: 10 * time.Millisecond}})
+		_, err := f.Write([]byte("long poll response"))
+		require.NoError(t, err)
+		assert.Equal(t, int32(0), w.flushes.Load())
+		require.Eventually(t, func() bool { return w.flushes.Load() == 1 }, time.Second, time.Millisecond)
+		f.stop()
+		assert.Equal(t, int32(1), w.flushes.Load())
+	})
+}
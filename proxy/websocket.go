@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+const (
+	websocketExtensionsHeader = "Sec-Websocket-Extensions"
+	permessageDeflate         = "permessage-deflate"
+
+	wsOpContinuation = 0x0
+	wsOpClose        = 0x8
+)
+
+var (
+	errWebsocketFrameTooLarge   = errors.New("websocket frame exceeds the maximum frame size")
+	errWebsocketMessageTooLarge = errors.New("websocket message exceeds the maximum message size")
+)
+
+// filterWebsocketExtensions keeps the permessage-deflate offers of the eyeball, so that compression is negotiated
+// end to end with the origin. Other extensions are dropped since they could give a different meaning to the
+// frames inspected by cloudflared.
+func filterWebsocketExtensions(header http.Header, cfg config.WebSocketConfig) {
+	offers := header.Values(websocketExtensionsHeader)
+	header.Del(websocketExtensionsHeader)
+	if cfg.DisableCompression {
+		return
+	}
+	for _, value := range offers {
+		for _, offer := range strings.Split(value, ",") {
+			offer = strings.TrimSpace(offer)
+			name, _, _ := strings.Cut(offer, ";")
+			if strings.EqualFold(strings.TrimSpace(name), permessageDeflate) {
+				header.Add(websocketExtensionsHeader, offer)
+			}
+		}
+	}
+}
+
+// websocketGuard enforces the size limits and idle timeout of the websocket connections of a rule.
+type websocketGuard struct {
+	cfg    config.WebSocketConfig
+	rule   string
+	closer io.Closer
+
+	lock  sync.Mutex
+	timer *time.Timer
+}
+
+// newWebsocketGuard returns a websocketGuard closing closer when the connection is idle, or nil if the rule sets
+// no limits.
+func newWebsocketGuard(cfg config.WebSocketConfig, ruleNum int, closer io.Closer) *websocketGuard {
+	if cfg.MaxFrameSize == 0 && cfg.MaxMessageSize == 0 && cfg.IdleTimeout.Duration == 0 {
+		return nil
+	}
+	g := &websocketGuard{
+		cfg:    cfg,
+		rule:   strconv.Itoa(ruleNum),
+		closer: closer,
+	}
+	if timeout := cfg.IdleTimeout.Duration; timeout > 0 {
+		g.timer = time.AfterFunc(timeout, g.closeIdle)
+	}
+	return g
+}
+
+func (g *websocketGuard) closeIdle() {
+	websocketsClosed.WithLabelValues(g.rule, "idle").Inc()
+	_ = g.closer.Close()
+}
+
+func (g *websocketGuard) activity() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.timer != nil {
+		g.timer.Reset(g.cfg.IdleTimeout.Duration)
+	}
+}
+
+func (g *websocketGuard) stop() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.timer != nil {
+		g.timer.Stop()
+		g.timer = nil
+	}
+}
+
+// reader returns a reader of the frames sent in one direction of the connection, failing once they exceed the
+// limits. Frames are relayed untouched, compressed messages are measured by their compressed size.
+func (g *websocketGuard) reader(r io.Reader) io.Reader {
+	return &websocketFrameReader{reader: r, guard: g}
+}
+
+// websocketFrameReader tracks the frame boundaries in the bytes read, as defined by RFC 6455, section 5.2.
+type websocketFrameReader struct {
+	reader io.Reader
+	guard  *websocketGuard
+
+	header      [14]byte
+	headerLen   int
+	payloadLeft uint64
+	messageSize uint64
+}
+
+func (r *websocketFrameReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if observeErr := r.observe(p[:n]); observeErr != nil {
+		reason := "frame_too_large"
+		if errors.Is(observeErr, errWebsocketMessageTooLarge) {
+			reason = "message_too_large"
+		}
+		websocketsClosed.WithLabelValues(r.guard.rule, reason).Inc()
+		// The offending frame is not relayed, the connection is closed instead
+		return 0, observeErr
+	}
+	return n, err
+}
+
+func (r *websocketFrameReader) observe(b []byte) error {
+	for len(b) > 0 {
+		if r.payloadLeft > 0 {
+			skip := min(r.payloadLeft, uint64(len(b)))
+			r.payloadLeft -= skip
+			b = b[skip:]
+			continue
+		}
+		headerSize := websocketHeaderSize(r.header[:r.headerLen])
+		copied := copy(r.header[r.headerLen:headerSize], b)
+		r.headerLen += copied
+		b = b[copied:]
+		if r.headerLen < 2 || r.headerLen < websocketHeaderSize(r.header[:r.headerLen]) {
+			continue
+		}
+		if err := r.frame(); err != nil {
+			return err
+		}
+		r.headerLen = 0
+	}
+	return nil
+}
+
+// websocketHeaderSize returns the size of the frame header starting with header, which is known once its first
+// two bytes are.
+func websocketHeaderSize(header []byte) int {
+	if len(header) < 2 {
+		return 2
+	}
+	size := 2
+	switch header[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if header[1]&0x80 != 0 {
+		// Masking key
+		size += 4
+	}
+	return size
+}
+
+func (r *websocketFrameReader) frame() error {
+	opcode := r.header[0] & 0x0f
+	payloadLen := uint64(r.header[1] & 0x7f)
+	switch payloadLen {
+	case 126:
+		payloadLen = uint64(binary.BigEndian.Uint16(r.header[2:4]))
+	case 127:
+		payloadLen = binary.BigEndian.Uint64(r.header[2:10])
+	}
+	r.payloadLeft = payloadLen
+	r.guard.activity()
+
+	cfg := r.guard.cfg
+	if cfg.MaxFrameSize > 0 && payloadLen > cfg.MaxFrameSize {
+		return errWebsocketFrameTooLarge
+	}
+	if opcode >= wsOpClose {
+		// Control frames can be interleaved with the frames of a message
+		return nil
+	}
+	if opcode != wsOpContinuation {
+		r.messageSize = 0
+	}
+	r.messageSize += payloadLen
+	if cfg.MaxMessageSize > 0 && r.messageSize > cfg.MaxMessageSize {
+		return errWebsocketMessageTooLarge
+	}
+	return nil
+}
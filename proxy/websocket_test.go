@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+// websocketFrame encodes a frame with a payload of size bytes, masked like the frames sent by clients.
+func websocketFrame(fin bool, opcode byte, size int) []byte {
+	var frame bytes.Buffer
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame.WriteByte(first)
+	switch {
+	case size < 126:
+		frame.WriteByte(0x80 | byte(size))
+	case size <= 0xffff:
+		frame.WriteByte(0x80 | 126)
+		_ = binary.Write(&frame, binary.BigEndian, uint16(size))
+	default:
+		frame.WriteByte(0x80 | 127)
+		_ = binary.Write(&frame, binary.BigEndian, uint64(size))
+	}
+	frame.Write([]byte{1, 2, 3, 4})
+	frame.Write(bytes.Repeat([]byte{0x7f}, size))
+	return frame.Bytes()
+}
+
+type closeCounter struct {
+	closes atomic.Int32
+}
+
+func (c *closeCounter) Close() error {
+	c.closes.Add(1)
+	return nil
+}
+
+func TestFilterWebsocketExtensions(t *testing.T) {
+	header := http.Header{}
+	header.Add(websocketExtensionsHeader, "permessage-deflate; client_max_window_bits, x-webkit-deflate-frame")
+	header.Add(websocketExtensionsHeader, "permessage-deflate")
+	filterWebsocketExtensions(header, config.WebSocketConfig{})
+	assert.Equal(t, []string{"permessage-deflate; client_max_window_bits", "permessage-deflate"}, header.Values(websocketExtensionsHeader))
+
+	filterWebsocketExtensions(header, config.WebSocketConfig{DisableCompression: true})
+	assert.Empty(t, header.Values(websocketExtensionsHeader))
+}
+
+func TestWebsocketGuardLimits(t *testing.T) {
+	assert.Nil(t, newWebsocketGuard(config.WebSocketConfig{}, 0, &closeCounter{}))
+
+	cfg := config.WebSocketConfig{MaxFrameSize: 70000, MaxMessageSize: 100000}
+	tests := []struct {
+		name        string
+		frames      [][]byte
+		expectedErr error
+	}{
+		{
+			name: "within limits",
+			frames: [][]byte{
+				websocketFrame(false, 0x2, 60000),
+				// Control frames can be sent in the middle of a message
+				websocketFrame(true, 0x9, 10),
+				websocketFrame(true, wsOpContinuation, 40000),
+				websocketFrame(true, 0x1, 70000),
+			},
+		},
+		{
+			name:        "frame too large",
+			frames:      [][]byte{websocketFrame(true, 0x2, 70001)},
+			expectedErr: errWebsocketFrameTooLarge,
+		},
+		{
+			name: "message too large",
+			frames: [][]byte{
+				websocketFrame(false, 0x2, 60000),
+				websocketFrame(true, wsOpContinuation, 40001),
+			},
+			expectedErr: errWebsocketMessageTooLarge,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stream := bytes.Join(test.frames, nil)
+			for _, reader := range []io.Reader{bytes.NewReader(stream), iotest.OneByteReader(bytes.NewReader(stream))} {
+				guard := newWebsocketGuard(cfg, 0, &closeCounter{})
+				n, err := io.Copy(io.Discard, guard.reader(reader))
+				guard.stop()
+				if test.expectedErr == nil {
+					require.NoError(t, err)
+					assert.Equal(t, int64(len(stream)), n)
+				} else {
+					require.ErrorIs(t, err, test.expectedErr)
+				}
+			}
+		})
+	}
+}
+
+func TestWebsocketGuardIdleTimeout(t *testing.T) {
+	closer := &closeCounter{}
+	guard := newWebsocketGuard(config.WebSocketConfig{IdleTimeout: config.CustomDuration{Duration: 200 * time.Millisecond}}, 0, closer)
+	defer guard.stop()
+
+	// Frames keep the connection open
+	reader := io.MultiReader(bytes.NewReader(websocketFrame(true, 0x1, 5)), bytes.NewReader(websocketFrame(true, 0x1, 5)))
+	frames := guard.reader(reader)
+	buf := make([]byte, 11)
+	for i := 0; i < 2; i++ {
+		time.Sleep(100 * time.Millisecond)
+		_, err := io.ReadFull(frames, buf)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(0), closer.closes.Load())
+
+	require.Eventually(t, func() bool { return closer.closes.Load() == 1 }, time.Second, 5*time.Millisecond)
+}
@@ -302,3 +302,20 @@ func generateTLSConfig() *tls.Config {
 		NextProtos:   []string{"argotunnel"},
 	}
 }
+
+func TestDatagramMuxerV2MaxPayloadSize(t *testing.T) {
+	logger := zerolog.Nop()
+	muxer := NewDatagramMuxerV2(nil, &logger, nil)
+	require.Equal(t, maxDatagramPayloadSize, muxer.mtu())
+
+	// Limits above the default are ignored
+	muxer.SetMaxPayloadSize(maxDatagramPayloadSize + 1)
+	require.Equal(t, maxDatagramPayloadSize, muxer.mtu())
+
+	muxer.SetMaxPayloadSize(1000)
+	require.Equal(t, 1000, muxer.mtu())
+	require.Error(t, muxer.SendToSession(&packet.Session{
+		ID:      testSessionID,
+		Payload: make([]byte, 1001),
+	}))
+}
@@ -47,6 +47,9 @@ func SuffixType(b []byte, datagramType DatagramV2Type) ([]byte, error) {
 
 // Maximum application payload to send to / receive from QUIC datagram frame
 func (dm *DatagramMuxerV2) mtu() int {
+	if dm.maxPayloadSize > 0 && dm.maxPayloadSize < maxDatagramPayloadSize {
+		return dm.maxPayloadSize
+	}
 	return maxDatagramPayloadSize
 }
 
@@ -55,6 +58,13 @@ type DatagramMuxerV2 struct {
 	logger           *zerolog.Logger
 	sessionDemuxChan chan<- *packet.Session
 	packetDemuxChan  chan Packet
+	maxPayloadSize   int
+}
+
+// SetMaxPayloadSize lowers the maximum application payload of the datagrams, when the path to the edge can't carry
+// datagrams of the default size. It must be called before the muxer is used.
+func (dm *DatagramMuxerV2) SetMaxPayloadSize(size int) {
+	dm.maxPayloadSize = size
 }
 
 func NewDatagramMuxerV2(
@@ -394,18 +394,29 @@ const maxICMPPayloadLen = maxDatagramPayloadLen
 //   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
 
 func (d *ICMPDatagram) MarshalBinary() (data []byte, err error) {
+	// Make room for the 1 byte ICMPType header
+	datagram := make([]byte, len(d.Payload)+datagramTypeLen)
+	if _, err := d.MarshalBinaryTo(datagram); err != nil {
+		return nil, err
+	}
+	return datagram, nil
+}
+
+// MarshalBinaryTo marshals the datagram into data, e.g. a pooled buffer, and returns its length.
+func (d *ICMPDatagram) MarshalBinaryTo(data []byte) (int, error) {
 	if len(d.Payload) > maxICMPPayloadLen {
-		return nil, wrapMarshalErr(ErrDatagramICMPPayloadTooLarge)
+		return 0, wrapMarshalErr(ErrDatagramICMPPayloadTooLarge)
 	}
 	// We shouldn't attempt to marshal an ICMP datagram with no ICMP payload provided
 	if len(d.Payload) == 0 {
-		return nil, wrapMarshalErr(ErrDatagramICMPPayloadMissing)
+		return 0, wrapMarshalErr(ErrDatagramICMPPayloadMissing)
 	}
-	// Make room for the 1 byte ICMPType header
-	datagram := make([]byte, len(d.Payload)+datagramTypeLen)
-	datagram[0] = byte(ICMPType)
-	copy(datagram[1:], d.Payload)
-	return datagram, nil
+	if len(data) < len(d.Payload)+datagramTypeLen {
+		return 0, wrapMarshalErr(ErrDatagramPayloadInvalidSize)
+	}
+	data[0] = byte(ICMPType)
+	copy(data[1:], d.Payload)
+	return len(d.Payload) + datagramTypeLen, nil
 }
 
 func (d *ICMPDatagram) UnmarshalBinary(data []byte) error {
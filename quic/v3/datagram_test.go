@@ -371,6 +371,20 @@ func TestICMPDatagram(t *testing.T) {
 		require.Equal(t, payload, unmarshaled.Payload)
 	})
 
+	t.Run("marshal to buffer", func(t *testing.T) {
+		payload := makePayload(128)
+		datagram := v3.ICMPDatagram{Payload: payload}
+		buf := make([]byte, 2048)
+		n, err := datagram.MarshalBinaryTo(buf)
+		require.NoError(t, err)
+		marshaled, err := datagram.MarshalBinary()
+		require.NoError(t, err)
+		require.Equal(t, marshaled, buf[:n])
+
+		_, err = datagram.MarshalBinaryTo(buf[:len(payload)])
+		require.ErrorIs(t, err, v3.ErrDatagramPayloadInvalidSize)
+	})
+
 	t.Run("payload size empty", func(t *testing.T) {
 		payload := []byte{}
 		datagram := v3.ICMPDatagram{Payload: payload}
@@ -0,0 +1,165 @@
+package v3
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	defaultDemuxQueueDepth = 1024
+)
+
+// DemuxConfig configures the processing of the datagrams received from a connection. The session payloads and ICMP
+// packets are queued to a fixed pool of workers, so that floods of datagrams are dropped instead of growing the
+// number of goroutines without bounds.
+type DemuxConfig struct {
+	// Workers is the number of goroutines processing the queued datagrams. 0 uses the number of CPUs.
+	Workers int
+	// QueueDepth is how many datagrams wait for a worker before the new ones are dropped. It's rounded up to a power
+	// of 2 of at least 2, 0 uses 1024.
+	QueueDepth int
+}
+
+func (c DemuxConfig) workers() int {
+	if c.Workers > 0 {
+		return c.Workers
+	}
+	return runtime.NumCPU()
+}
+
+func (c DemuxConfig) queueDepth() int {
+	if c.QueueDepth > 0 {
+		return c.QueueDepth
+	}
+	return defaultDemuxQueueDepth
+}
+
+// datagramRing is a bounded lock-free queue of datagrams for multiple producers and consumers, based on the
+// sequence numbers of its cells.
+type datagramRing struct {
+	mask       uint64
+	cells      []ringCell
+	enqueuePos atomic.Uint64
+	dequeuePos atomic.Uint64
+}
+
+type ringCell struct {
+	seq      atomic.Uint64
+	datagram []byte
+}
+
+func newDatagramRing(size int) *datagramRing {
+	// The sequence numbers can't tell apart full and empty cells with a single cell
+	capacity := 2
+	for capacity < size {
+		capacity <<= 1
+	}
+	r := &datagramRing{
+		mask:  uint64(capacity - 1),
+		cells: make([]ringCell, capacity),
+	}
+	for i := range r.cells {
+		r.cells[i].seq.Store(uint64(i))
+	}
+	return r
+}
+
+// push adds a datagram to the ring, returning false if it's full.
+func (r *datagramRing) push(datagram []byte) bool {
+	pos := r.enqueuePos.Load()
+	for {
+		cell := &r.cells[pos&r.mask]
+		seq := cell.seq.Load()
+		switch diff := int64(seq) - int64(pos); {
+		case diff == 0:
+			if r.enqueuePos.CompareAndSwap(pos, pos+1) {
+				cell.datagram = datagram
+				cell.seq.Store(pos + 1)
+				return true
+			}
+			pos = r.enqueuePos.Load()
+		case diff < 0:
+			return false
+		default:
+			pos = r.enqueuePos.Load()
+		}
+	}
+}
+
+// pop removes the oldest datagram of the ring, returning false if it's empty.
+func (r *datagramRing) pop() ([]byte, bool) {
+	pos := r.dequeuePos.Load()
+	for {
+		cell := &r.cells[pos&r.mask]
+		seq := cell.seq.Load()
+		switch diff := int64(seq) - int64(pos+1); {
+		case diff == 0:
+			if r.dequeuePos.CompareAndSwap(pos, pos+1) {
+				datagram := cell.datagram
+				cell.datagram = nil
+				cell.seq.Store(pos + r.mask + 1)
+				return datagram, true
+			}
+			pos = r.dequeuePos.Load()
+		case diff < 0:
+			return nil, false
+		default:
+			pos = r.dequeuePos.Load()
+		}
+	}
+}
+
+// demuxQueue hands the datagrams of a connection to a pool of workers.
+type demuxQueue struct {
+	ring *datagramRing
+	// ready holds a token for every datagram in the ring, for the idle workers to wait on
+	ready   chan struct{}
+	workers int
+	handle  func(datagram []byte)
+}
+
+func newDemuxQueue(config DemuxConfig, handle func(datagram []byte)) *demuxQueue {
+	ring := newDatagramRing(config.queueDepth())
+	return &demuxQueue{
+		ring:    ring,
+		ready:   make(chan struct{}, len(ring.cells)),
+		workers: config.workers(),
+		handle:  handle,
+	}
+}
+
+// enqueue queues a datagram for the workers, returning false if the queue is full and the datagram was dropped.
+func (q *demuxQueue) enqueue(datagram []byte) bool {
+	if !q.ring.push(datagram) {
+		return false
+	}
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// run processes the queued datagrams until ctx is done.
+func (q *demuxQueue) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-q.ready:
+				}
+				if datagram, ok := q.ring.pop(); ok {
+					q.handle(datagram)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,142 @@
+package v3
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	limitSessionPackets = "flow_packets"
+	limitSessionBytes   = "flow_bytes"
+	limitGlobalPackets  = "global_packets"
+	limitGlobalBytes    = "global_bytes"
+
+	rejectedFlowConnectionLimit = "connection_limit"
+)
+
+// SessionLimits configures the policing of the UDP sessions. Datagrams over a rate are dropped in either direction.
+// A zero value disables the limit.
+type SessionLimits struct {
+	// PacketsPerSecond and BytesPerSecond limit the datagrams of each session.
+	PacketsPerSecond uint64
+	BytesPerSecond   uint64
+	// GlobalPacketsPerSecond and GlobalBytesPerSecond limit the datagrams of all the sessions together.
+	GlobalPacketsPerSecond uint64
+	GlobalBytesPerSecond   uint64
+	// MaxSessionsPerConnection limits the sessions registered through the same edge connection. It isn't a limit per
+	// eyeball: registrations don't carry the address of the eyeball, and an edge connection carries the sessions of
+	// many eyeballs.
+	MaxSessionsPerConnection uint64
+	// MigrationGracePeriod is how long a session whose connection is gone waits to be migrated to another connection
+	// before being closed. Zero closes the sessions along with their connection.
+	MigrationGracePeriod time.Duration
+}
+
+func (l SessionLimits) policesSessions() bool {
+	return l.PacketsPerSecond > 0 || l.BytesPerSecond > 0 || l.GlobalPacketsPerSecond > 0 || l.GlobalBytesPerSecond > 0
+}
+
+// tokenBucket allows rate tokens per second, with bursts of up to one second worth of tokens or minBurst tokens,
+// whichever is larger. A nil tokenBucket allows everything.
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a bucket of rate tokens per second. minBurst is the largest single take, which would
+// otherwise never be allowed by a rate below it.
+func newTokenBucket(rate, minBurst uint64) *tokenBucket {
+	if rate == 0 {
+		return nil
+	}
+	burst := float64(max(rate, minBurst))
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) take(n uint64, now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// refund gives back the tokens taken for a datagram that was dropped by another bucket.
+func (b *tokenBucket) refund(n uint64) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tokens = min(b.burst, b.tokens+float64(n))
+}
+
+// globalPolicer holds the buckets shared by all the sessions of a session manager.
+type globalPolicer struct {
+	packets *tokenBucket
+	bytes   *tokenBucket
+}
+
+// sessionPolicer polices the datagrams of a session, in both directions.
+type sessionPolicer struct {
+	packets *tokenBucket
+	bytes   *tokenBucket
+	global  *globalPolicer
+}
+
+func newSessionPolicer(limits SessionLimits, global *globalPolicer) *sessionPolicer {
+	if !limits.policesSessions() {
+		return nil
+	}
+	return &sessionPolicer{
+		packets: newTokenBucket(limits.PacketsPerSecond, 1),
+		bytes:   newTokenBucket(limits.BytesPerSecond, maxDatagramPayloadLen),
+		global:  global,
+	}
+}
+
+// allow returns whether a datagram of size bytes can be proxied, or the limit it exceeds. Tokens are only taken
+// when the datagram is allowed by every limit.
+func (p *sessionPolicer) allow(size int) (bool, string) {
+	if p == nil {
+		return true, ""
+	}
+	now := time.Now()
+	n := uint64(size) // nolint: gosec
+	if !p.packets.take(1, now) {
+		return false, limitSessionPackets
+	}
+	if !p.bytes.take(n, now) {
+		p.packets.refund(1)
+		return false, limitSessionBytes
+	}
+	if !p.global.packets.take(1, now) {
+		p.packets.refund(1)
+		p.bytes.refund(n)
+		return false, limitGlobalPackets
+	}
+	if !p.global.bytes.take(n, now) {
+		p.packets.refund(1)
+		p.bytes.refund(n)
+		p.global.packets.refund(1)
+		return false, limitGlobalBytes
+	}
+	return true, ""
+}
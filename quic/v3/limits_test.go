@@ -0,0 +1,115 @@
+package v3_test
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfdflow "github.com/cloudflare/cloudflared/flow"
+	"github.com/cloudflare/cloudflared/ingress"
+	v3 "github.com/cloudflare/cloudflared/quic/v3"
+)
+
+type limitCountingMetrics struct {
+	noopMetrics
+	lock     sync.Mutex
+	policed  map[string]int
+	rejected map[string]int
+}
+
+func newLimitCountingMetrics() *limitCountingMetrics {
+	return &limitCountingMetrics{policed: map[string]int{}, rejected: map[string]int{}}
+}
+
+func (m *limitCountingMetrics) PolicedUDPDatagram(connIndex uint8, limit string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.policed[limit]++
+}
+
+func (m *limitCountingMetrics) RejectedFlow(connIndex uint8, reason string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.rejected[reason]++
+}
+
+func newLimitedSessionManager(t *testing.T, metrics v3.Metrics, limits v3.SessionLimits) (v3.SessionManager, netip.AddrPort, net.PacketConn) {
+	return newSessionManagerOfKind(t, v3.SessionManagerDefault, metrics, limits)
+}
+
+func newSessionManagerOfKind(t *testing.T, kind v3.SessionManagerKind, metrics v3.Metrics, limits v3.SessionLimits) (v3.SessionManager, netip.AddrPort, net.PacketConn) {
+	origin, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = origin.Close() })
+	log := zerolog.Nop()
+	originDialerService := ingress.NewOriginDialer(ingress.OriginConfig{DefaultDialer: testDefaultDialer}, &log)
+	manager := v3.NewSessionManagerOfKind(kind, metrics, &log, originDialerService, cfdflow.NewLimiter(0), limits)
+	return manager, netip.MustParseAddrPort(origin.LocalAddr().String()), origin
+}
+
+func TestSessionLimitsPolicePackets(t *testing.T) {
+	metrics := newLimitCountingMetrics()
+	manager, dest, origin := newLimitedSessionManager(t, metrics, v3.SessionLimits{PacketsPerSecond: 3, GlobalBytesPerSecond: 1000})
+
+	session, err := manager.RegisterSession(&v3.UDPSessionRegistrationDatagram{RequestID: testRequestID, Dest: dest}, &noopEyeball{})
+	require.NoError(t, err)
+	defer manager.UnregisterSession(testRequestID)
+
+	for i := 0; i < 5; i++ {
+		// Policed datagrams are dropped without failing the write
+		n, err := session.Write([]byte("ping"))
+		require.NoError(t, err)
+		assert.Equal(t, 4, n)
+	}
+	received := 0
+	buf := make([]byte, 16)
+	_ = origin.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	for {
+		if _, _, err := origin.ReadFrom(buf); err != nil {
+			break
+		}
+		received++
+	}
+	assert.Equal(t, 3, received)
+	assert.Equal(t, 2, metrics.policed["flow_packets"])
+
+	// The global limit applies to the datagrams allowed by the session limits. Its burst fits a max-size datagram,
+	// even though that is over the rate.
+	time.Sleep(time.Second)
+	_, err = session.Write(make([]byte, 1200))
+	require.NoError(t, err)
+	assert.Equal(t, 0, metrics.policed["global_bytes"])
+	_, err = session.Write(make([]byte, 1000))
+	require.NoError(t, err)
+	assert.Equal(t, 1, metrics.policed["global_bytes"])
+}
+
+func TestSessionLimitsMaxSessionsPerConnection(t *testing.T) {
+	metrics := newLimitCountingMetrics()
+	manager, dest, _ := newLimitedSessionManager(t, metrics, v3.SessionLimits{MaxSessionsPerConnection: 1})
+
+	first := mustRequestID([16]byte{0x01})
+	second := mustRequestID([16]byte{0x02})
+	_, err := manager.RegisterSession(&v3.UDPSessionRegistrationDatagram{RequestID: first, Dest: dest}, &noopEyeball{})
+	require.NoError(t, err)
+	_, err = manager.RegisterSession(&v3.UDPSessionRegistrationDatagram{RequestID: second, Dest: dest}, &noopEyeball{})
+	require.ErrorIs(t, err, v3.ErrSessionConnectionLimit)
+	require.ErrorIs(t, err, v3.ErrSessionRegistrationRateLimited)
+	assert.Equal(t, 1, metrics.rejected["connection_limit"])
+
+	// Sessions of other connections aren't limited
+	_, err = manager.RegisterSession(&v3.UDPSessionRegistrationDatagram{RequestID: second, Dest: dest}, &noopEyeball{connID: 1})
+	require.NoError(t, err)
+	manager.UnregisterSession(second)
+
+	manager.UnregisterSession(first)
+	_, err = manager.RegisterSession(&v3.UDPSessionRegistrationDatagram{RequestID: second, Dest: dest}, &noopEyeball{})
+	require.NoError(t, err)
+	manager.UnregisterSession(second)
+}
@@ -1,8 +1,11 @@
 package v3
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/rs/zerolog"
 
@@ -21,8 +24,22 @@ var (
 	ErrSessionAlreadyRegistered = errors.New("flow is already registered for this connection")
 	// ErrSessionRegistrationRateLimited is returned when a registration fails due to rate limiting on the number of active flows.
 	ErrSessionRegistrationRateLimited = errors.New("flow registration rate limited")
+	// ErrSessionConnectionLimit is returned when a registration fails because its connection already has the maximum
+	// number of flows.
+	ErrSessionConnectionLimit = fmt.Errorf("%w: too many flows for the connection", ErrSessionRegistrationRateLimited)
+
+	// activeSessions counts the sessions held by all the session managers
+	activeSessions atomic.Int64
 )
 
+// ActiveSessions returns the number of sessions held by all the session managers.
+func ActiveSessions() int64 {
+	return activeSessions.Load()
+}
+
+// SessionManager holds the UDP sessions of the connections using datagram v3. The muxers of all the connections share
+// it, so its methods are called concurrently. [NewSessionManager] holds the sessions behind a single lock and
+// [NewShardedSessionManager] spreads them over shards for hosts with a lot of concurrent sessions.
 type SessionManager interface {
 	// RegisterSession will register a new session if it does not already exist for the request ID.
 	// During new session creation, the session will also bind the UDP socket for the origin.
@@ -37,22 +54,127 @@ type SessionManager interface {
 	UnregisterSession(requestID RequestID)
 }
 
-type sessionManager struct {
-	sessions     map[RequestID]Session
-	mutex        sync.RWMutex
+// SessionMigrator is implemented by the session managers migrating the sessions of the connections that are gone to
+// the other connections. The muxers call it when their connection comes up and goes down.
+type SessionMigrator interface {
+	// ConnectionUp makes conn a target of the migrations, migrating to it the sessions waiting for a connection.
+	// ctx is the context of the connection, which ends when it goes down.
+	ConnectionUp(ctx context.Context, conn DatagramConn)
+	// ConnectionDown migrates the sessions of conn to another connection, if any.
+	ConnectionDown(conn DatagramConn)
+}
+
+// liveConn is a connection sessions can be migrated to.
+type liveConn struct {
+	conn DatagramConn
+	ctx  context.Context
+}
+
+// sessionFactory creates the sessions of the session managers, binding their origin sockets.
+type sessionFactory struct {
 	originDialer ingress.OriginUDPDialer
 	limiter      cfdflow.Limiter
+	limits       SessionLimits
+	policer      *globalPolicer
 	metrics      Metrics
 	log          *zerolog.Logger
 }
 
-func NewSessionManager(metrics Metrics, log *zerolog.Logger, originDialer ingress.OriginUDPDialer, limiter cfdflow.Limiter) SessionManager {
-	return &sessionManager{
-		sessions:     make(map[RequestID]Session),
+func newSessionFactory(
+	metrics Metrics,
+	log *zerolog.Logger,
+	originDialer ingress.OriginUDPDialer,
+	limiter cfdflow.Limiter,
+	limits SessionLimits,
+) sessionFactory {
+	return sessionFactory{
 		originDialer: originDialer,
 		limiter:      limiter,
-		metrics:      metrics,
-		log:          log,
+		limits:       limits,
+		policer: &globalPolicer{
+			packets: newTokenBucket(limits.GlobalPacketsPerSecond, 1),
+			bytes:   newTokenBucket(limits.GlobalBytesPerSecond, maxDatagramPayloadLen),
+		},
+		metrics: metrics,
+		log:     log,
+	}
+}
+
+// newSession acquires a flow from the limiter and binds the origin socket of the session.
+func (f *sessionFactory) newSession(request *UDPSessionRegistrationDatagram, conn DatagramConn) (*session, error) {
+	// Try to start a new session
+	if err := f.limiter.Acquire(management.UDP.String()); err != nil {
+		return nil, ErrSessionRegistrationRateLimited
+	}
+
+	// Attempt to bind the UDP socket for the new session
+	origin, err := f.originDialer.DialUDP(request.Dest)
+	if err != nil {
+		return nil, err
+	}
+	idleTimeout := request.IdleDurationHint
+	if timeouts, ok := f.originDialer.(ingress.OriginUDPIdleTimeouts); ok {
+		if timeout := timeouts.UDPIdleTimeout(request.Dest); timeout > 0 {
+			idleTimeout = timeout
+		}
+	}
+	session := newSession(
+		request.RequestID,
+		idleTimeout,
+		origin,
+		origin.RemoteAddr(),
+		origin.LocalAddr(),
+		conn,
+		f.metrics,
+		f.log)
+	if classifier, ok := f.originDialer.(ingress.OriginQoSClassifier); ok {
+		session.qosClass = classifier.QoSClass(request.Dest)
+	}
+	session.policer = newSessionPolicer(f.limits, f.policer)
+	session.migrationGrace = f.limits.MigrationGracePeriod
+	return session, nil
+}
+
+// migrate moves the sessions to target, replaying their registration so that the edge sends their datagrams over it.
+func (f *sessionFactory) migrate(sessions []Session, target liveConn) {
+	for _, session := range sessions {
+		session.Migrate(target.conn, target.ctx, f.log)
+		if err := target.conn.SendUDPSessionResponse(session.ID(), ResponseOk); err != nil {
+			f.log.Debug().Err(err).Str(logFlowID, session.ID().String()).Msg("unable to replay the flow registration after migrating it")
+			continue
+		}
+		f.log.Debug().Str(logFlowID, session.ID().String()).Uint8("connIndex", target.conn.ID()).Msg("flow migrated to another connection")
+	}
+}
+
+type sessionManager struct {
+	sessionFactory
+	sessions map[RequestID]Session
+	mutex    sync.RWMutex
+
+	// conns are the connections serving datagrams, by connection index
+	conns map[uint8]liveConn
+	// orphans are the sessions whose connection is gone, waiting for another connection to come up
+	orphans map[RequestID]Session
+}
+
+func NewSessionManager(metrics Metrics, log *zerolog.Logger, originDialer ingress.OriginUDPDialer, limiter cfdflow.Limiter) SessionManager {
+	return NewSessionManagerWithLimits(metrics, log, originDialer, limiter, SessionLimits{})
+}
+
+// NewSessionManagerWithLimits returns a SessionManager policing the datagrams of its sessions with limits.
+func NewSessionManagerWithLimits(
+	metrics Metrics,
+	log *zerolog.Logger,
+	originDialer ingress.OriginUDPDialer,
+	limiter cfdflow.Limiter,
+	limits SessionLimits,
+) SessionManager {
+	return &sessionManager{
+		sessionFactory: newSessionFactory(metrics, log, originDialer, limiter, limits),
+		sessions:       make(map[RequestID]Session),
+		conns:          make(map[uint8]liveConn),
+		orphans:        make(map[RequestID]Session),
 	}
 }
 
@@ -67,30 +189,33 @@ func (s *sessionManager) RegisterSession(request *UDPSessionRegistrationDatagram
 		return nil, ErrSessionBoundToOtherConn
 	}
 
-	// Try to start a new session
-	if err := s.limiter.Acquire(management.UDP.String()); err != nil {
-		return nil, ErrSessionRegistrationRateLimited
+	if s.limits.MaxSessionsPerConnection > 0 && s.connectionSessions(conn.ID()) >= s.limits.MaxSessionsPerConnection {
+		s.metrics.RejectedFlow(conn.ID(), rejectedFlowConnectionLimit)
+		return nil, ErrSessionConnectionLimit
 	}
 
-	// Attempt to bind the UDP socket for the new session
-	origin, err := s.originDialer.DialUDP(request.Dest)
+	session, err := s.newSession(request, conn)
 	if err != nil {
 		return nil, err
 	}
-	// Create and insert the new session in the map
-	session := NewSession(
-		request.RequestID,
-		request.IdleDurationHint,
-		origin,
-		origin.RemoteAddr(),
-		origin.LocalAddr(),
-		conn,
-		s.metrics,
-		s.log)
+	// Insert the new session in the map
 	s.sessions[request.RequestID] = session
+	activeSessions.Add(1)
+	cfdflow.Active.Begin(cfdflow.KindUDP)
 	return session, nil
 }
 
+// connectionSessions counts the sessions currently bound to the connection, following their migrations.
+func (s *sessionManager) connectionSessions(connID uint8) uint64 {
+	var count uint64
+	for _, session := range s.sessions {
+		if session.ConnectionID() == connID {
+			count++
+		}
+	}
+	return count
+}
+
 func (s *sessionManager) GetSession(requestID RequestID) (Session, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
@@ -109,7 +234,72 @@ func (s *sessionManager) UnregisterSession(requestID RequestID) {
 	if exists {
 		// We ignore any errors when attempting to close the session
 		_ = session.Close()
+		activeSessions.Add(-1)
+		cfdflow.Active.End(cfdflow.KindUDP)
 	}
 	delete(s.sessions, requestID)
+	delete(s.orphans, requestID)
 	s.limiter.Release()
 }
+
+func (s *sessionManager) ConnectionUp(ctx context.Context, conn DatagramConn) {
+	s.mutex.Lock()
+	s.conns[conn.ID()] = liveConn{conn: conn, ctx: ctx}
+	orphans := make([]Session, 0, len(s.orphans))
+	for requestID, session := range s.orphans {
+		orphans = append(orphans, session)
+		delete(s.orphans, requestID)
+	}
+	s.mutex.Unlock()
+
+	s.migrate(orphans, liveConn{conn: conn, ctx: ctx})
+}
+
+func (s *sessionManager) ConnectionDown(conn DatagramConn) {
+	s.mutex.Lock()
+	if live, ok := s.conns[conn.ID()]; ok && live.conn == conn {
+		delete(s.conns, conn.ID())
+	}
+	if s.limits.MigrationGracePeriod <= 0 {
+		s.mutex.Unlock()
+		return
+	}
+	var sessions []Session
+	for _, registered := range s.sessions {
+		if sess, ok := registered.(*session); ok && sess.boundTo(conn) {
+			sessions = append(sessions, registered)
+		}
+	}
+	target, ok := s.migrationTarget(len(sessions))
+	if !ok {
+		// The sessions wait for the next connection to come up, until their grace period ends
+		for _, session := range sessions {
+			s.orphans[session.ID()] = session
+		}
+	}
+	s.mutex.Unlock()
+
+	if ok {
+		s.migrate(sessions, target)
+	}
+}
+
+// migrationTarget returns the live connection with the fewest sessions that has room for n more.
+func (s *sessionManager) migrationTarget(n int) (liveConn, bool) {
+	var (
+		target   liveConn
+		found    bool
+		fewest   uint64
+		incoming = uint64(n)
+	)
+	for _, live := range s.conns {
+		count := s.connectionSessions(live.conn.ID())
+		if s.limits.MaxSessionsPerConnection > 0 && count+incoming > s.limits.MaxSessionsPerConnection {
+			continue
+		}
+		if !found || count < fewest {
+			target, found, fewest = live, true, count
+		}
+	}
+	return target, found
+}
@@ -0,0 +1,282 @@
+package v3
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/ingress"
+
+	cfdflow "github.com/cloudflare/cloudflared/flow"
+)
+
+// sessionShards is the number of shards of the sharded session manager. It's a power of two so that the shard of a
+// request ID is its low bits.
+const sessionShards = 64
+
+// SessionManagerKind is the implementation of the SessionManager.
+type SessionManagerKind string
+
+const (
+	// SessionManagerDefault holds the sessions behind a single lock.
+	SessionManagerDefault SessionManagerKind = "default"
+	// SessionManagerSharded spreads the sessions over shards with their own locks.
+	SessionManagerSharded SessionManagerKind = "sharded"
+)
+
+// ParseSessionManagerKind returns the SessionManagerKind named kind, the default one if empty.
+func ParseSessionManagerKind(kind string) (SessionManagerKind, error) {
+	switch SessionManagerKind(kind) {
+	case "", SessionManagerDefault:
+		return SessionManagerDefault, nil
+	case SessionManagerSharded:
+		return SessionManagerSharded, nil
+	}
+	return "", fmt.Errorf("unknown session manager %q, expected %s or %s", kind, SessionManagerDefault, SessionManagerSharded)
+}
+
+// NewSessionManagerOfKind returns the SessionManager implementation of kind, policing the datagrams of its sessions
+// with limits.
+func NewSessionManagerOfKind(
+	kind SessionManagerKind,
+	metrics Metrics,
+	log *zerolog.Logger,
+	originDialer ingress.OriginUDPDialer,
+	limiter cfdflow.Limiter,
+	limits SessionLimits,
+) SessionManager {
+	if kind == SessionManagerSharded {
+		return NewShardedSessionManager(metrics, log, originDialer, limiter, limits)
+	}
+	return NewSessionManagerWithLimits(metrics, log, originDialer, limiter, limits)
+}
+
+// sessionShard is a part of the sessions of the sharded session manager, behind its own lock.
+type sessionShard struct {
+	mutex    sync.RWMutex
+	sessions map[RequestID]Session
+	// connIDs are the connections the sessions are counted against, which follow their migrations
+	connIDs map[RequestID]uint8
+}
+
+// shardedSessionManager is a SessionManager for hosts with a lot of concurrent sessions. The datagrams of the
+// sessions look them up for each payload, so with a single lock the registrations and unregistrations stall the
+// lookups of all the connections. The sessions are spread over shards by request ID instead, each with its own lock.
+//
+// The connections the sessions are migrated between have their own lock, which is never taken while holding the lock
+// of a shard. The sessions of each connection are counted with atomic counters, so that registrations reserve their
+// slot without taking the locks of the other shards.
+type shardedSessionManager struct {
+	sessionFactory
+	shards [sessionShards]sessionShard
+	// connSessions counts the sessions of each connection, by connection index
+	connSessions [math.MaxUint8 + 1]atomic.Uint64
+
+	connsMutex sync.Mutex
+	// conns are the connections serving datagrams, by connection index
+	conns map[uint8]liveConn
+	// orphans are the sessions whose connection is gone, waiting for another connection to come up
+	orphans map[RequestID]Session
+}
+
+// NewShardedSessionManager returns a SessionManager spreading its sessions over shards, policing the datagrams of its
+// sessions with limits. It behaves like the one returned by [NewSessionManagerWithLimits].
+func NewShardedSessionManager(
+	metrics Metrics,
+	log *zerolog.Logger,
+	originDialer ingress.OriginUDPDialer,
+	limiter cfdflow.Limiter,
+	limits SessionLimits,
+) SessionManager {
+	s := &shardedSessionManager{
+		sessionFactory: newSessionFactory(metrics, log, originDialer, limiter, limits),
+		conns:          make(map[uint8]liveConn),
+		orphans:        make(map[RequestID]Session),
+	}
+	for i := range s.shards {
+		s.shards[i].sessions = make(map[RequestID]Session)
+		s.shards[i].connIDs = make(map[RequestID]uint8)
+	}
+	return s
+}
+
+func (s *shardedSessionManager) shard(requestID RequestID) *sessionShard {
+	return &s.shards[(requestID.hi^requestID.lo)%sessionShards]
+}
+
+func (s *shardedSessionManager) RegisterSession(request *UDPSessionRegistrationDatagram, conn DatagramConn) (Session, error) {
+	shard := s.shard(request.RequestID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if registered, exists := shard.sessions[request.RequestID]; exists {
+		if conn.ID() == registered.ConnectionID() {
+			return nil, ErrSessionAlreadyRegistered
+		}
+		// The caller migrates the session to conn
+		s.rebind(shard, request.RequestID, conn.ID())
+		return nil, ErrSessionBoundToOtherConn
+	}
+	// The slot is reserved before creating the session, so that concurrent registrations through the same connection
+	// can't exceed the limit
+	if !s.reserveSlot(conn.ID()) {
+		s.metrics.RejectedFlow(conn.ID(), rejectedFlowConnectionLimit)
+		return nil, ErrSessionConnectionLimit
+	}
+	session, err := s.newSession(request, conn)
+	if err != nil {
+		s.releaseSlot(conn.ID())
+		return nil, err
+	}
+	shard.sessions[request.RequestID] = session
+	shard.connIDs[request.RequestID] = conn.ID()
+	activeSessions.Add(1)
+	cfdflow.Active.Begin(cfdflow.KindUDP)
+	return session, nil
+}
+
+// reserveSlot counts one more session against the connection, unless it already has the most sessions allowed.
+func (s *shardedSessionManager) reserveSlot(connID uint8) bool {
+	counter := &s.connSessions[connID]
+	for {
+		count := counter.Load()
+		if s.limits.MaxSessionsPerConnection > 0 && count >= s.limits.MaxSessionsPerConnection {
+			return false
+		}
+		if counter.CompareAndSwap(count, count+1) {
+			return true
+		}
+	}
+}
+
+func (s *shardedSessionManager) releaseSlot(connID uint8) {
+	s.connSessions[connID].Add(^uint64(0))
+}
+
+// rebind counts the session against the connection it's migrated to. The caller must hold the lock of its shard.
+func (s *shardedSessionManager) rebind(shard *sessionShard, requestID RequestID, connID uint8) {
+	previous, ok := shard.connIDs[requestID]
+	if !ok || previous == connID {
+		return
+	}
+	s.releaseSlot(previous)
+	s.connSessions[connID].Add(1)
+	shard.connIDs[requestID] = connID
+}
+
+func (s *shardedSessionManager) GetSession(requestID RequestID) (Session, error) {
+	shard := s.shard(requestID)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+	if session, exists := shard.sessions[requestID]; exists {
+		return session, nil
+	}
+	return nil, ErrSessionNotFound
+}
+
+func (s *shardedSessionManager) UnregisterSession(requestID RequestID) {
+	shard := s.shard(requestID)
+	shard.mutex.Lock()
+	// Get the session and make sure to close it if it isn't already closed
+	session, exists := shard.sessions[requestID]
+	if exists {
+		// We ignore any errors when attempting to close the session
+		_ = session.Close()
+		activeSessions.Add(-1)
+		cfdflow.Active.End(cfdflow.KindUDP)
+		s.releaseSlot(shard.connIDs[requestID])
+	}
+	delete(shard.sessions, requestID)
+	delete(shard.connIDs, requestID)
+	shard.mutex.Unlock()
+
+	s.connsMutex.Lock()
+	delete(s.orphans, requestID)
+	s.connsMutex.Unlock()
+	s.limiter.Release()
+}
+
+func (s *shardedSessionManager) ConnectionUp(ctx context.Context, conn DatagramConn) {
+	s.connsMutex.Lock()
+	s.conns[conn.ID()] = liveConn{conn: conn, ctx: ctx}
+	orphans := make([]Session, 0, len(s.orphans))
+	for requestID, session := range s.orphans {
+		orphans = append(orphans, session)
+		delete(s.orphans, requestID)
+	}
+	s.connsMutex.Unlock()
+
+	s.migrateSessions(orphans, liveConn{conn: conn, ctx: ctx})
+}
+
+func (s *shardedSessionManager) ConnectionDown(conn DatagramConn) {
+	// The lock is held until the sessions are orphaned, so that the ones unregistered meanwhile aren't left behind
+	s.connsMutex.Lock()
+	if live, ok := s.conns[conn.ID()]; ok && live.conn == conn {
+		delete(s.conns, conn.ID())
+	}
+	if s.limits.MigrationGracePeriod <= 0 {
+		s.connsMutex.Unlock()
+		return
+	}
+	var sessions []Session
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mutex.RLock()
+		for _, registered := range shard.sessions {
+			if sess, ok := registered.(*session); ok && sess.boundTo(conn) {
+				sessions = append(sessions, registered)
+			}
+		}
+		shard.mutex.RUnlock()
+	}
+	target, ok := s.migrationTarget(len(sessions))
+	if !ok {
+		// The sessions wait for the next connection to come up, until their grace period ends
+		for _, session := range sessions {
+			s.orphans[session.ID()] = session
+		}
+	}
+	s.connsMutex.Unlock()
+
+	if ok {
+		s.migrateSessions(sessions, target)
+	}
+}
+
+// migrateSessions moves the sessions to target, counting them against it.
+func (s *shardedSessionManager) migrateSessions(sessions []Session, target liveConn) {
+	s.migrate(sessions, target)
+	for _, session := range sessions {
+		shard := s.shard(session.ID())
+		shard.mutex.Lock()
+		if shard.sessions[session.ID()] == session {
+			s.rebind(shard, session.ID(), target.conn.ID())
+		}
+		shard.mutex.Unlock()
+	}
+}
+
+// migrationTarget returns the live connection with the fewest sessions that has room for n more. The caller must
+// hold connsMutex.
+func (s *shardedSessionManager) migrationTarget(n int) (liveConn, bool) {
+	var (
+		target   liveConn
+		found    bool
+		fewest   uint64
+		incoming = uint64(n)
+	)
+	for _, live := range s.conns {
+		count := s.connSessions[live.conn.ID()].Load()
+		if s.limits.MaxSessionsPerConnection > 0 && count+incoming > s.limits.MaxSessionsPerConnection {
+			continue
+		}
+		if !found || count < fewest {
+			target, found, fewest = live, true, count
+		}
+	}
+	return target, found
+}
@@ -0,0 +1,121 @@
+package v3_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v3 "github.com/cloudflare/cloudflared/quic/v3"
+)
+
+func TestParseSessionManagerKind(t *testing.T) {
+	for name, expected := range map[string]v3.SessionManagerKind{
+		"":        v3.SessionManagerDefault,
+		"default": v3.SessionManagerDefault,
+		"sharded": v3.SessionManagerSharded,
+	} {
+		kind, err := v3.ParseSessionManagerKind(name)
+		require.NoError(t, err)
+		assert.Equal(t, expected, kind)
+	}
+	_, err := v3.ParseSessionManagerKind("striped")
+	require.Error(t, err)
+}
+
+func TestShardedSessionManagerConcurrentSessions(t *testing.T) {
+	manager, dest, _ := newSessionManagerOfKind(t, v3.SessionManagerSharded, &noopMetrics{}, v3.SessionLimits{})
+	const sessions = 256
+
+	var wg sync.WaitGroup
+	for i := 0; i < sessions; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			requestID := mustRequestID([16]byte{byte(i), byte(i >> 8), 0x01})
+			conn := &noopEyeball{connID: uint8(i % 4)}
+			session, err := manager.RegisterSession(&v3.UDPSessionRegistrationDatagram{RequestID: requestID, Dest: dest}, conn)
+			if !assert.NoError(t, err) {
+				return
+			}
+			got, err := manager.GetSession(requestID)
+			assert.NoError(t, err)
+			assert.Equal(t, session, got)
+
+			_, err = manager.RegisterSession(&v3.UDPSessionRegistrationDatagram{RequestID: requestID, Dest: dest}, conn)
+			assert.ErrorIs(t, err, v3.ErrSessionAlreadyRegistered)
+			_, err = manager.RegisterSession(&v3.UDPSessionRegistrationDatagram{RequestID: requestID, Dest: dest}, &noopEyeball{connID: 4})
+			assert.ErrorIs(t, err, v3.ErrSessionBoundToOtherConn)
+
+			manager.UnregisterSession(requestID)
+			_, err = manager.GetSession(requestID)
+			assert.True(t, errors.Is(err, v3.ErrSessionNotFound))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestShardedSessionManagerMaxSessionsPerConnection(t *testing.T) {
+	metrics := newLimitCountingMetrics()
+	manager, dest, _ := newSessionManagerOfKind(t, v3.SessionManagerSharded, metrics, v3.SessionLimits{MaxSessionsPerConnection: 2})
+
+	// The sessions of a connection are counted across the shards
+	first := mustRequestID([16]byte{0x01})
+	second := mustRequestID([16]byte{0x02})
+	third := mustRequestID([16]byte{0x03})
+	for _, requestID := range []v3.RequestID{first, second} {
+		_, err := manager.RegisterSession(&v3.UDPSessionRegistrationDatagram{RequestID: requestID, Dest: dest}, &noopEyeball{})
+		require.NoError(t, err)
+		defer manager.UnregisterSession(requestID)
+	}
+	_, err := manager.RegisterSession(&v3.UDPSessionRegistrationDatagram{RequestID: third, Dest: dest}, &noopEyeball{})
+	require.ErrorIs(t, err, v3.ErrSessionConnectionLimit)
+	assert.Equal(t, 1, metrics.rejected["connection_limit"])
+
+	// Registering a session again is reported as such, even at the limit
+	_, err = manager.RegisterSession(&v3.UDPSessionRegistrationDatagram{RequestID: first, Dest: dest}, &noopEyeball{})
+	require.ErrorIs(t, err, v3.ErrSessionAlreadyRegistered)
+
+	_, err = manager.RegisterSession(&v3.UDPSessionRegistrationDatagram{RequestID: third, Dest: dest}, &noopEyeball{connID: 1})
+	require.NoError(t, err)
+	manager.UnregisterSession(third)
+}
+
+func TestShardedSessionManagerConcurrentRegistrationsAtLimit(t *testing.T) {
+	const limit = 8
+	manager, dest, _ := newSessionManagerOfKind(t, v3.SessionManagerSharded, newLimitCountingMetrics(), v3.SessionLimits{MaxSessionsPerConnection: limit})
+
+	var (
+		wg         sync.WaitGroup
+		lock       sync.Mutex
+		registered []v3.RequestID
+	)
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			requestID := mustRequestID([16]byte{byte(i), 0x02})
+			_, err := manager.RegisterSession(&v3.UDPSessionRegistrationDatagram{RequestID: requestID, Dest: dest}, &noopEyeball{})
+			if err != nil {
+				assert.ErrorIs(t, err, v3.ErrSessionConnectionLimit)
+				return
+			}
+			lock.Lock()
+			registered = append(registered, requestID)
+			lock.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, registered, limit)
+
+	// Unregistering frees the slots
+	for _, requestID := range registered {
+		manager.UnregisterSession(requestID)
+	}
+	requestID := mustRequestID([16]byte{0xff, 0x02})
+	_, err := manager.RegisterSession(&v3.UDPSessionRegistrationDatagram{RequestID: requestID, Dest: dest}, &noopEyeball{})
+	require.NoError(t, err)
+	manager.UnregisterSession(requestID)
+}
@@ -2,6 +2,7 @@ package v3
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 
@@ -13,6 +14,27 @@ const (
 	subsystem = "udp"
 
 	commandMetricLabel = "command"
+	qosClassLabel      = "qos_class"
+	limitLabel         = "limit"
+	reasonLabel        = "reason"
+	directionLabel     = "direction"
+	resultLabel        = "result"
+
+	// Directions of the proxied datagrams
+	directionToOrigin   = "to_origin"
+	directionFromOrigin = "from_origin"
+
+	// Reasons the datagrams of the flows are dropped
+	dropNoFlow          = "no_flow"
+	dropPayloadTooLarge = "payload_too_large"
+	dropRateLimited     = "rate_limited"
+
+	// Results of the registrations of the flows
+	registrationOk          = "ok"
+	registrationRetry       = "retry"
+	registrationMigrated    = "migrated"
+	registrationRateLimited = "rate_limited"
+	registrationFailed      = "failed"
 )
 
 type Metrics interface {
@@ -22,6 +44,18 @@ type Metrics interface {
 	RetryFlowResponse(connIndex uint8)
 	MigrateFlow(connIndex uint8)
 	UnsupportedRemoteCommand(connIndex uint8, command string)
+	DroppedUDPDatagram(connIndex uint8, qosClass string)
+	PolicedUDPDatagram(connIndex uint8, limit string)
+	RejectedFlow(connIndex uint8, reason string)
+	DroppedDemuxDatagram(connIndex uint8)
+	// ProxiedDatagram counts a datagram of size bytes proxied to or from the origin of a flow.
+	ProxiedDatagram(connIndex uint8, direction string, size int)
+	// DroppedFlowDatagram counts a datagram of a flow dropped for reason: no_flow, payload_too_large or rate_limited.
+	DroppedFlowDatagram(connIndex uint8, reason string)
+	// ClosedFlow counts a flow closed for reason, one of the close reasons of the flow records.
+	ClosedFlow(connIndex uint8, reason string)
+	// RegisteredFlow observes the time to respond to the registration of a flow, by result.
+	RegisteredFlow(connIndex uint8, result string, duration time.Duration)
 }
 
 type metrics struct {
@@ -31,6 +65,15 @@ type metrics struct {
 	retryFlowResponses        *prometheus.CounterVec
 	migratedFlows             *prometheus.CounterVec
 	unsupportedRemoteCommands *prometheus.CounterVec
+	droppedUDPDatagrams       *prometheus.CounterVec
+	policedUDPDatagrams       *prometheus.CounterVec
+	rejectedFlows             *prometheus.CounterVec
+	droppedDemuxDatagrams     *prometheus.CounterVec
+	proxiedDatagrams          *prometheus.CounterVec
+	proxiedBytes              *prometheus.CounterVec
+	droppedFlowDatagrams      *prometheus.CounterVec
+	closedFlows               *prometheus.CounterVec
+	registrationDuration      *prometheus.HistogramVec
 }
 
 func (m *metrics) IncrementFlows(connIndex uint8) {
@@ -58,6 +101,39 @@ func (m *metrics) UnsupportedRemoteCommand(connIndex uint8, command string) {
 	m.unsupportedRemoteCommands.WithLabelValues(fmt.Sprintf("%d", connIndex), command).Inc()
 }
 
+func (m *metrics) DroppedUDPDatagram(connIndex uint8, qosClass string) {
+	m.droppedUDPDatagrams.WithLabelValues(fmt.Sprintf("%d", connIndex), qosClass).Inc()
+}
+
+func (m *metrics) PolicedUDPDatagram(connIndex uint8, limit string) {
+	m.policedUDPDatagrams.WithLabelValues(fmt.Sprintf("%d", connIndex), limit).Inc()
+}
+
+func (m *metrics) RejectedFlow(connIndex uint8, reason string) {
+	m.rejectedFlows.WithLabelValues(fmt.Sprintf("%d", connIndex), reason).Inc()
+}
+
+func (m *metrics) DroppedDemuxDatagram(connIndex uint8) {
+	m.droppedDemuxDatagrams.WithLabelValues(fmt.Sprintf("%d", connIndex)).Inc()
+}
+
+func (m *metrics) ProxiedDatagram(connIndex uint8, direction string, size int) {
+	m.proxiedDatagrams.WithLabelValues(fmt.Sprintf("%d", connIndex), direction).Inc()
+	m.proxiedBytes.WithLabelValues(fmt.Sprintf("%d", connIndex), direction).Add(float64(size))
+}
+
+func (m *metrics) DroppedFlowDatagram(connIndex uint8, reason string) {
+	m.droppedFlowDatagrams.WithLabelValues(fmt.Sprintf("%d", connIndex), reason).Inc()
+}
+
+func (m *metrics) ClosedFlow(connIndex uint8, reason string) {
+	m.closedFlows.WithLabelValues(fmt.Sprintf("%d", connIndex), reason).Inc()
+}
+
+func (m *metrics) RegisteredFlow(connIndex uint8, result string, duration time.Duration) {
+	m.registrationDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
 func NewMetrics(registerer prometheus.Registerer) Metrics {
 	m := &metrics{
 		activeUDPFlows: prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -96,6 +172,61 @@ func NewMetrics(registerer prometheus.Registerer) Metrics {
 			Name:      "unsupported_remote_command_total",
 			Help:      "Total count of unsupported remote RPC commands for the ",
 		}, []string{quic.ConnectionIndexMetricLabel, commandMetricLabel}),
+		droppedUDPDatagrams: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "dropped_datagrams_total",
+			Help:      "Total count of UDP datagrams from origins dropped because the connection to the edge couldn't keep up, per QoS class",
+		}, []string{quic.ConnectionIndexMetricLabel, qosClassLabel}),
+		policedUDPDatagrams: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "policed_datagrams_total",
+			Help:      "Total count of UDP datagrams dropped because they exceeded a rate limit of the flows, per limit",
+		}, []string{quic.ConnectionIndexMetricLabel, limitLabel}),
+		rejectedFlows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "rejected_flows_total",
+			Help:      "Total count of UDP flows rejected because they exceeded a limit of the flows, per reason",
+		}, []string{quic.ConnectionIndexMetricLabel, reasonLabel}),
+		droppedDemuxDatagrams: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "demux_dropped_datagrams_total",
+			Help:      "Total count of datagrams from the edge dropped because the queue of the demux workers was full",
+		}, []string{quic.ConnectionIndexMetricLabel}),
+		proxiedDatagrams: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "proxied_datagrams_total",
+			Help:      "Total count of UDP datagrams proxied to or from the origins, per direction",
+		}, []string{quic.ConnectionIndexMetricLabel, directionLabel}),
+		proxiedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "proxied_bytes_total",
+			Help:      "Total count of bytes of the UDP datagrams proxied to or from the origins, per direction",
+		}, []string{quic.ConnectionIndexMetricLabel, directionLabel}),
+		droppedFlowDatagrams: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "flow_dropped_datagrams_total",
+			Help:      "Total count of UDP datagrams of the flows dropped, per reason: no_flow, payload_too_large or rate_limited",
+		}, []string{quic.ConnectionIndexMetricLabel, reasonLabel}),
+		closedFlows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "closed_flows_total",
+			Help:      "Total count of UDP flows closed, per reason: idle, closed_by_origin, connection_closed, terminated or error",
+		}, []string{quic.ConnectionIndexMetricLabel, reasonLabel}),
+		registrationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "flow_registration_duration_seconds",
+			Help:      "Time to respond to the registrations of the UDP flows, binding their origin socket, per result",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}, []string{resultLabel}),
 	}
 	registerer.MustRegister(
 		m.activeUDPFlows,
@@ -104,6 +235,15 @@ func NewMetrics(registerer prometheus.Registerer) Metrics {
 		m.retryFlowResponses,
 		m.migratedFlows,
 		m.unsupportedRemoteCommands,
+		m.droppedUDPDatagrams,
+		m.policedUDPDatagrams,
+		m.rejectedFlows,
+		m.droppedDemuxDatagrams,
+		m.proxiedDatagrams,
+		m.proxiedBytes,
+		m.droppedFlowDatagrams,
+		m.closedFlows,
+		m.registrationDuration,
 	)
 	return m
 }
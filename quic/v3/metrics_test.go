@@ -1,10 +1,66 @@
 package v3_test
 
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v3 "github.com/cloudflare/cloudflared/quic/v3"
+)
+
 type noopMetrics struct{}
 
-func (noopMetrics) IncrementFlows(connIndex uint8)                           {}
-func (noopMetrics) DecrementFlows(connIndex uint8)                           {}
-func (noopMetrics) PayloadTooLarge(connIndex uint8)                          {}
-func (noopMetrics) RetryFlowResponse(connIndex uint8)                        {}
-func (noopMetrics) MigrateFlow(connIndex uint8)                              {}
-func (noopMetrics) UnsupportedRemoteCommand(connIndex uint8, command string) {}
+func (noopMetrics) IncrementFlows(connIndex uint8)                                        {}
+func (noopMetrics) DecrementFlows(connIndex uint8)                                        {}
+func (noopMetrics) PayloadTooLarge(connIndex uint8)                                       {}
+func (noopMetrics) RetryFlowResponse(connIndex uint8)                                     {}
+func (noopMetrics) MigrateFlow(connIndex uint8)                                           {}
+func (noopMetrics) UnsupportedRemoteCommand(connIndex uint8, command string)              {}
+func (noopMetrics) DroppedUDPDatagram(connIndex uint8, qosClass string)                   {}
+func (noopMetrics) PolicedUDPDatagram(connIndex uint8, limit string)                      {}
+func (noopMetrics) RejectedFlow(connIndex uint8, reason string)                           {}
+func (noopMetrics) DroppedDemuxDatagram(connIndex uint8)                                  {}
+func (noopMetrics) ProxiedDatagram(connIndex uint8, direction string, size int)           {}
+func (noopMetrics) DroppedFlowDatagram(connIndex uint8, reason string)                    {}
+func (noopMetrics) ClosedFlow(connIndex uint8, reason string)                             {}
+func (noopMetrics) RegisteredFlow(connIndex uint8, result string, duration time.Duration) {}
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := v3.NewMetrics(registry)
+	metrics.ProxiedDatagram(0, "to_origin", 100)
+	metrics.ProxiedDatagram(0, "to_origin", 50)
+	metrics.ProxiedDatagram(0, "from_origin", 10)
+	metrics.DroppedFlowDatagram(1, "no_flow")
+	metrics.ClosedFlow(1, "idle")
+	metrics.RegisteredFlow(0, "ok", 2*time.Millisecond)
+	metrics.RegisteredFlow(0, "rate_limited", time.Millisecond)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			key := family.GetName()
+			for _, label := range metric.GetLabel() {
+				key += "," + label.GetName() + "=" + label.GetValue()
+			}
+			switch {
+			case metric.GetCounter() != nil:
+				values[key] = metric.GetCounter().GetValue()
+			case metric.GetHistogram() != nil:
+				values[key] = float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	assert.Equal(t, 2.0, values["cloudflared_udp_proxied_datagrams_total,conn_index=0,direction=to_origin"])
+	assert.Equal(t, 150.0, values["cloudflared_udp_proxied_bytes_total,conn_index=0,direction=to_origin"])
+	assert.Equal(t, 10.0, values["cloudflared_udp_proxied_bytes_total,conn_index=0,direction=from_origin"])
+	assert.Equal(t, 1.0, values["cloudflared_udp_flow_dropped_datagrams_total,conn_index=1,reason=no_flow"])
+	assert.Equal(t, 1.0, values["cloudflared_udp_closed_flows_total,conn_index=1,reason=idle"])
+	assert.Equal(t, 1.0, values["cloudflared_udp_flow_registration_duration_seconds,result=ok"])
+	assert.Equal(t, 1.0, values["cloudflared_udp_flow_registration_duration_seconds,result=rate_limited"])
+}
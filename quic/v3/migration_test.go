@@ -0,0 +1,126 @@
+package v3_test
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	cfdflow "github.com/cloudflare/cloudflared/flow"
+	"github.com/cloudflare/cloudflared/ingress"
+	v3 "github.com/cloudflare/cloudflared/quic/v3"
+)
+
+func newCancelableMockQuicConn() (*mockQuicConn, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &mockQuicConn{
+		ctx:  ctx,
+		send: make(chan []byte, 1),
+		recv: make(chan []byte, 1),
+	}, cancel
+}
+
+func readDatagram(t *testing.T, conn *mockQuicConn) []byte {
+	select {
+	case datagram := <-conn.recv:
+		return datagram
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a datagram")
+		return nil
+	}
+}
+
+func TestSessionMigratesToAnotherConnection(t *testing.T) {
+	for _, kind := range []v3.SessionManagerKind{v3.SessionManagerDefault, v3.SessionManagerSharded} {
+		t.Run(string(kind), func(t *testing.T) {
+			testSessionMigratesToAnotherConnection(t, kind)
+		})
+	}
+}
+
+func testSessionMigratesToAnotherConnection(t *testing.T, kind v3.SessionManagerKind) {
+	log := zerolog.Nop()
+	manager, dest, origin := newSessionManagerOfKind(t, kind, &noopMetrics{}, v3.SessionLimits{MigrationGracePeriod: 5 * time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	quic1, closeConn1 := newCancelableMockQuicConn()
+	conn1 := v3.NewDatagramConn(quic1, manager, &noopICMPRouter{}, 0, &noopMetrics{}, &log)
+	quic2, closeConn2 := newCancelableMockQuicConn()
+	defer closeConn2()
+	conn2 := v3.NewDatagramConn(quic2, manager, &noopICMPRouter{}, 1, &noopMetrics{}, &log)
+
+	done1 := make(chan error, 1)
+	go func() { done1 <- conn1.Serve(ctx) }()
+	go func() { _ = conn2.Serve(ctx) }()
+
+	registration := v3.UDPSessionRegistrationDatagram{RequestID: testRequestID, Dest: dest, IdleDurationHint: 5 * time.Second}
+	payload, err := registration.MarshalBinary()
+	require.NoError(t, err)
+	quic1.send <- payload
+	var resp v3.UDPSessionRegistrationResponseDatagram
+	require.NoError(t, resp.UnmarshalBinary(readDatagram(t, quic1)))
+	require.Equal(t, v3.ResponseOk, resp.ResponseType)
+
+	// The connection the session was registered on goes away
+	closeConn1()
+	<-done1
+
+	// The registration is replayed on the remaining connection
+	require.NoError(t, resp.UnmarshalBinary(readDatagram(t, quic2)))
+	require.Equal(t, testRequestID, resp.RequestID)
+	require.Equal(t, v3.ResponseOk, resp.ResponseType)
+
+	session, err := manager.GetSession(testRequestID)
+	require.NoError(t, err)
+	defer manager.UnregisterSession(testRequestID)
+
+	// Payloads from the eyeball on the new connection reach the origin, and the origin replies are sent to it
+	quic2.send <- newSessionPayloadDatagram(testRequestID, []byte{0xef, 0xef})
+	buf := make([]byte, 16)
+	_ = origin.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, eyeball, err := origin.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0xef, 0xef}, buf[:n])
+	_, err = origin.WriteTo([]byte{0xab}, eyeball)
+	require.NoError(t, err)
+	require.Equal(t, newSessionPayloadDatagram(testRequestID, []byte{0xab}), readDatagram(t, quic2))
+	require.Equal(t, uint8(1), session.ConnectionID())
+}
+
+func TestSessionClosedWithoutMigrationGracePeriod(t *testing.T) {
+	log := zerolog.Nop()
+	origin, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer origin.Close()
+	originDialerService := ingress.NewOriginDialer(ingress.OriginConfig{DefaultDialer: testDefaultDialer}, &log)
+	manager := v3.NewSessionManager(&noopMetrics{}, &log, originDialerService, cfdflow.NewLimiter(0))
+
+	quic1, closeConn1 := newCancelableMockQuicConn()
+	conn1 := v3.NewDatagramConn(quic1, manager, &noopICMPRouter{}, 0, &noopMetrics{}, &log)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done1 := make(chan error, 1)
+	go func() { done1 <- conn1.Serve(ctx) }()
+
+	registration := v3.UDPSessionRegistrationDatagram{
+		RequestID:        testRequestID,
+		Dest:             netip.MustParseAddrPort(origin.LocalAddr().String()),
+		IdleDurationHint: 5 * time.Second,
+	}
+	payload, err := registration.MarshalBinary()
+	require.NoError(t, err)
+	quic1.send <- payload
+	readDatagram(t, quic1)
+
+	closeConn1()
+	<-done1
+	require.Eventually(t, func() bool {
+		_, err := manager.GetSession(testRequestID)
+		return err != nil
+	}, 2*time.Second, 10*time.Millisecond)
+}
@@ -4,13 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
 
+	"github.com/cloudflare/cloudflared/cfio"
+	cfdflow "github.com/cloudflare/cloudflared/flow"
 	"github.com/cloudflare/cloudflared/ingress"
 	"github.com/cloudflare/cloudflared/packet"
+	"github.com/cloudflare/cloudflared/recovery"
 )
 
 const (
@@ -64,22 +69,62 @@ type datagramConn struct {
 	sessionManager SessionManager
 	icmpRouter     ingress.ICMPRouter
 	metrics        Metrics
+	maxPayloadLen  int
+	demuxConfig    DemuxConfig
 	logger         *zerolog.Logger
 	datagrams      chan []byte
 	readErrors     chan error
 
+	// The scheduler is only started once a flow with a QoS class sends a datagram
+	schedulerOnce sync.Once
+	scheduler     *datagramScheduler
+
 	icmpEncoderPool sync.Pool // a pool of *packet.Encoder
 	icmpDecoderPool sync.Pool
 }
 
 func NewDatagramConn(conn QuicConnection, sessionManager SessionManager, icmpRouter ingress.ICMPRouter, index uint8, metrics Metrics, logger *zerolog.Logger) DatagramConn {
+	return NewDatagramConnWithMaxPayload(conn, sessionManager, icmpRouter, index, metrics, maxDatagramPayloadLen, logger)
+}
+
+// NewDatagramConnWithMaxPayload returns a DatagramConn dropping the UDP payloads larger than maxPayloadLen, for paths to
+// the edge that can't carry datagrams of maxDatagramPayloadLen. Larger limits are lowered to maxDatagramPayloadLen.
+func NewDatagramConnWithMaxPayload(
+	conn QuicConnection,
+	sessionManager SessionManager,
+	icmpRouter ingress.ICMPRouter,
+	index uint8,
+	metrics Metrics,
+	maxPayloadLen int,
+	logger *zerolog.Logger,
+) DatagramConn {
+	return NewDatagramConnWithConfig(conn, sessionManager, icmpRouter, index, metrics, maxPayloadLen, DemuxConfig{}, logger)
+}
+
+// NewDatagramConnWithConfig returns a DatagramConn like NewDatagramConnWithMaxPayload, processing the received
+// datagrams as configured by demuxConfig.
+func NewDatagramConnWithConfig(
+	conn QuicConnection,
+	sessionManager SessionManager,
+	icmpRouter ingress.ICMPRouter,
+	index uint8,
+	metrics Metrics,
+	maxPayloadLen int,
+	demuxConfig DemuxConfig,
+	logger *zerolog.Logger,
+) DatagramConn {
 	log := logger.With().Uint8("datagramVersion", 3).Logger()
+	if maxPayloadLen <= 0 || maxPayloadLen > maxDatagramPayloadLen {
+		maxPayloadLen = maxDatagramPayloadLen
+	}
 	return &datagramConn{
 		conn:           conn,
 		index:          index,
 		sessionManager: sessionManager,
 		icmpRouter:     icmpRouter,
 		metrics:        metrics,
+		maxPayloadLen:  maxPayloadLen,
+		demuxConfig:    demuxConfig,
 		logger:         &log,
 		datagrams:      make(chan []byte, demuxChanCapacity),
 		readErrors:     make(chan error, 2),
@@ -100,10 +145,46 @@ func (c *datagramConn) ID() uint8 {
 	return c.index
 }
 
+// payloadLimiter is implemented by the connections limiting the size of the UDP payloads they carry.
+type payloadLimiter interface {
+	maxUDPPayloadLen() int
+}
+
+func (c *datagramConn) maxUDPPayloadLen() int {
+	return c.maxPayloadLen
+}
+
+// maxPayloadLen returns the largest UDP payload a connection carries.
+func maxPayloadLen(conn DatagramConn) int {
+	if limiter, ok := conn.(payloadLimiter); ok {
+		return limiter.maxUDPPayloadLen()
+	}
+	return maxDatagramPayloadLen
+}
+
 func (c *datagramConn) SendUDPSessionDatagram(datagram []byte) error {
 	return c.conn.SendDatagram(datagram)
 }
 
+// SendPrioritizedUDPSessionDatagram queues the datagram to be sent according to its QoS class. The datagram may be
+// dropped if the connection can't keep up, in which case lower classes are dropped first.
+func (c *datagramConn) SendPrioritizedUDPSessionDatagram(datagram []byte, class ingress.QoSClass) error {
+	c.schedulerOnce.Do(func() {
+		c.scheduler = newDatagramScheduler(
+			c.conn.SendDatagram,
+			func(class ingress.QoSClass) {
+				c.metrics.DroppedUDPDatagram(c.index, class.String())
+			},
+			func(err error) {
+				c.logger.Debug().Err(err).Msg("failed to send prioritized flow datagram")
+			},
+		)
+		go c.scheduler.run(c.conn.Context())
+	})
+	c.scheduler.enqueue(datagram, class)
+	return nil
+}
+
 func (c *datagramConn) SendUDPSessionResponse(id RequestID, resp SessionRegistrationResp) error {
 	datagram := UDPSessionRegistrationResponseDatagram{
 		RequestID:    id,
@@ -132,11 +213,14 @@ func (c *datagramConn) SendICMPPacket(icmp *packet.ICMP) error {
 	icmpDatagram := ICMPDatagram{
 		Payload: payload.Data,
 	}
-	datagram, err := icmpDatagram.MarshalBinary()
+	// The datagram is copied by the connection, so its buffer can be reused once sent
+	datagram := cfio.GetBuffer(len(payload.Data) + datagramTypeLen)
+	defer cfio.PutBuffer(datagram)
+	n, err := icmpDatagram.MarshalBinaryTo(*datagram)
 	if err != nil {
 		return err
 	}
-	return c.conn.SendDatagram(datagram)
+	return c.conn.SendDatagram((*datagram)[:n])
 }
 
 func (c *datagramConn) SendICMPTTLExceed(icmp *packet.ICMP, rawPacket packet.RawPacket) error {
@@ -145,6 +229,7 @@ func (c *datagramConn) SendICMPTTLExceed(icmp *packet.ICMP, rawPacket packet.Raw
 
 // pollDatagrams will read datagrams from the underlying connection until the provided context is done.
 func (c *datagramConn) pollDatagrams(ctx context.Context) {
+	defer recovery.Recover(c.logger, recovery.SubsystemDatagram, int(c.index))
 	for ctx.Err() == nil {
 		datagram, err := c.conn.ReceiveDatagram(ctx)
 		// If the read returns an error, we want to return the failure to the channel.
@@ -167,7 +252,16 @@ func (c *datagramConn) Serve(ctx context.Context) error {
 	// underlying connection is also closing, but that is handled outside of the context of the datagram muxer.
 	readCtx, cancel := context.WithCancel(connCtx)
 	defer cancel()
+	// The sessions of the connection are migrated to another connection once it stops serving
+	if migrator, ok := c.sessionManager.(SessionMigrator); ok {
+		migrator.ConnectionUp(connCtx, c)
+		defer migrator.ConnectionDown(c)
+	}
 	go c.pollDatagrams(readCtx)
+	queue := newDemuxQueue(c.demuxConfig, func(datagram []byte) {
+		c.demux(connCtx, datagram)
+	})
+	go queue.run(readCtx)
 	for {
 		// We make sure to monitor the context of cloudflared and the underlying connection to return if any errors occur.
 		var datagram []byte
@@ -186,53 +280,64 @@ func (c *datagramConn) Serve(ctx context.Context) error {
 			datagram = d
 		}
 
-		// Each incoming datagram will be processed in a new go routine to handle the demuxing and action associated.
-		go func() {
-			typ, err := ParseDatagramType(datagram)
-			if err != nil {
-				c.logger.Err(err).Msgf("unable to parse datagram type: %d", typ)
-				return
-			}
-			switch typ {
-			case UDPSessionRegistrationType:
-				reg := &UDPSessionRegistrationDatagram{}
-				err := reg.UnmarshalBinary(datagram)
-				if err != nil {
-					c.logger.Err(err).Msgf("unable to unmarshal session registration datagram")
-					return
-				}
-				logger := c.logger.With().Str(logFlowID, reg.RequestID.String()).Logger()
-				// We bind the new session to the quic connection context instead of cloudflared context to allow for the
-				// quic connection to close and close only the sessions bound to it. Closing of cloudflared will also
-				// initiate the close of the quic connection, so we don't have to worry about the application context
-				// in the scope of a session.
-				c.handleSessionRegistrationDatagram(connCtx, reg, &logger)
-			case UDPSessionPayloadType:
-				payload := &UDPSessionPayloadDatagram{}
-				err := payload.UnmarshalBinary(datagram)
-				if err != nil {
-					c.logger.Err(err).Msgf("unable to unmarshal session payload datagram")
-					return
-				}
-				logger := c.logger.With().Str(logFlowID, payload.RequestID.String()).Logger()
-				c.handleSessionPayloadDatagram(payload, &logger)
-			case ICMPType:
-				packet := &ICMPDatagram{}
-				err := packet.UnmarshalBinary(datagram)
-				if err != nil {
-					c.logger.Err(err).Msgf("unable to unmarshal icmp datagram")
-					return
-				}
-				c.handleICMPPacket(packet)
-			case UDPSessionRegistrationResponseType:
-				// cloudflared should never expect to receive UDP session responses as it will not initiate new
-				// sessions towards the edge.
-				c.logger.Error().Msgf("unexpected datagram type received: %d", UDPSessionRegistrationResponseType)
-				return
-			default:
-				c.logger.Error().Msgf("unknown datagram type received: %d", typ)
-			}
-		}()
+		// Registrations are processed in a new go routine since it serves the session for its whole lifetime. The
+		// other datagrams are queued to the demux workers, and dropped when they can't keep up.
+		if typ, err := ParseDatagramType(datagram); err == nil && typ == UDPSessionRegistrationType {
+			go c.demux(connCtx, datagram)
+			continue
+		}
+		if !queue.enqueue(datagram) {
+			c.metrics.DroppedDemuxDatagram(c.index)
+		}
+	}
+}
+
+// demux handles a datagram according to its type.
+func (c *datagramConn) demux(connCtx context.Context, datagram []byte) {
+	defer recovery.Recover(c.logger, recovery.SubsystemDatagram, int(c.index))
+	typ, err := ParseDatagramType(datagram)
+	if err != nil {
+		c.logger.Err(err).Msgf("unable to parse datagram type: %d", typ)
+		return
+	}
+	switch typ {
+	case UDPSessionRegistrationType:
+		reg := &UDPSessionRegistrationDatagram{}
+		err := reg.UnmarshalBinary(datagram)
+		if err != nil {
+			c.logger.Err(err).Msgf("unable to unmarshal session registration datagram")
+			return
+		}
+		logger := c.logger.With().Str(logFlowID, reg.RequestID.String()).Logger()
+		// We bind the new session to the quic connection context instead of cloudflared context to allow for the
+		// quic connection to close and close only the sessions bound to it. Closing of cloudflared will also
+		// initiate the close of the quic connection, so we don't have to worry about the application context
+		// in the scope of a session.
+		c.handleSessionRegistrationDatagram(connCtx, reg, &logger)
+	case UDPSessionPayloadType:
+		payload := &UDPSessionPayloadDatagram{}
+		err := payload.UnmarshalBinary(datagram)
+		if err != nil {
+			c.logger.Err(err).Msgf("unable to unmarshal session payload datagram")
+			return
+		}
+		logger := c.logger.With().Str(logFlowID, payload.RequestID.String()).Logger()
+		c.handleSessionPayloadDatagram(payload, &logger)
+	case ICMPType:
+		packet := &ICMPDatagram{}
+		err := packet.UnmarshalBinary(datagram)
+		if err != nil {
+			c.logger.Err(err).Msgf("unable to unmarshal icmp datagram")
+			return
+		}
+		c.handleICMPPacket(packet)
+	case UDPSessionRegistrationResponseType:
+		// cloudflared should never expect to receive UDP session responses as it will not initiate new
+		// sessions towards the edge.
+		c.logger.Error().Msgf("unexpected datagram type received: %d", UDPSessionRegistrationResponseType)
+		return
+	default:
+		c.logger.Error().Msgf("unknown datagram type received: %d", typ)
 	}
 }
 
@@ -242,6 +347,7 @@ func (c *datagramConn) handleSessionRegistrationDatagram(ctx context.Context, da
 		Str(logFlowID, datagram.RequestID.String()).
 		Str(logDstKey, datagram.Dest.String()).
 		Logger()
+	received := time.Now()
 	session, err := c.sessionManager.RegisterSession(datagram, c)
 	switch err {
 	case nil:
@@ -249,18 +355,22 @@ func (c *datagramConn) handleSessionRegistrationDatagram(ctx context.Context, da
 	case ErrSessionAlreadyRegistered:
 		// Session is already registered and likely the response got lost
 		c.handleSessionAlreadyRegistered(datagram.RequestID, &log)
+		c.metrics.RegisteredFlow(c.index, registrationRetry, time.Since(received))
 		return
 	case ErrSessionBoundToOtherConn:
 		// Session is already registered but to a different connection
 		c.handleSessionMigration(datagram.RequestID, &log)
+		c.metrics.RegisteredFlow(c.index, registrationMigrated, time.Since(received))
 		return
-	case ErrSessionRegistrationRateLimited:
+	case ErrSessionRegistrationRateLimited, ErrSessionConnectionLimit:
 		// There are too many concurrent sessions so we return an error to force a retry later
 		c.handleSessionRegistrationRateLimited(datagram, &log)
+		c.metrics.RegisteredFlow(c.index, registrationRateLimited, time.Since(received))
 		return
 	default:
 		log.Err(err).Msg("flow registration failure")
 		c.handleSessionRegistrationFailure(datagram.RequestID, &log)
+		c.metrics.RegisteredFlow(c.index, registrationFailed, time.Since(received))
 		return
 	}
 	log = log.With().Str(logSrcKey, session.LocalAddr().String()).Logger()
@@ -272,14 +382,18 @@ func (c *datagramConn) handleSessionRegistrationDatagram(ctx context.Context, da
 	// Respond that we are able to process the new session
 	err = c.SendUDPSessionResponse(datagram.RequestID, ResponseOk)
 	if err != nil {
+		c.metrics.RegisteredFlow(c.index, registrationFailed, time.Since(received))
 		log.Err(err).Msgf("flow registration failure: unable to send session registration response")
 		return
 	}
+	c.metrics.RegisteredFlow(c.index, registrationOk, time.Since(received))
 
 	// We bind the context of the session to the [quic.Connection] that initiated the session.
 	// [Session.Serve] is blocking and will continue this go routine till the end of the session lifetime.
 	start := time.Now()
 	err = session.Serve(ctx)
+	emitFlowRecord(session, datagram.Dest.String(), start, err)
+	c.metrics.ClosedFlow(c.index, flowCloseReason(err))
 	elapsedMS := time.Since(start).Milliseconds()
 	log = log.With().Int64(logDurationKey, elapsedMS).Logger()
 	if err == nil {
@@ -362,6 +476,7 @@ func (c *datagramConn) handleSessionRegistrationRateLimited(datagram *UDPSession
 func (c *datagramConn) handleSessionPayloadDatagram(datagram *UDPSessionPayloadDatagram, logger *zerolog.Logger) {
 	s, err := c.sessionManager.GetSession(datagram.RequestID)
 	if err != nil {
+		c.metrics.DroppedFlowDatagram(c.index, dropNoFlow)
 		logger.Err(err).Msgf("unable to find flow")
 		return
 	}
@@ -419,3 +534,41 @@ func (c *datagramConn) handleICMPPacket(datagram *ICMPDatagram) {
 		return
 	}
 }
+
+// flowCounter is implemented by the sessions that count their traffic for the flow records.
+type flowCounter interface {
+	Counters() *cfdflow.Counters
+}
+
+func emitFlowRecord(session Session, dst string, start time.Time, err error) {
+	record := cfdflow.Record{
+		Kind:        cfdflow.KindUDP,
+		ID:          session.ID().String(),
+		Version:     "v3",
+		Src:         session.LocalAddr().String(),
+		Dst:         dst,
+		Start:       start,
+		Duration:    time.Since(start),
+		CloseReason: flowCloseReason(err),
+		ConnIndex:   session.ConnectionID(),
+	}
+	if counter, ok := session.(flowCounter); ok {
+		counter.Counters().Fill(&record)
+	}
+	cfdflow.Emit(record)
+}
+
+func flowCloseReason(err error) string {
+	switch {
+	case errors.Is(err, SessionIdleErr{}):
+		return cfdflow.CloseIdle
+	case errors.Is(err, SessionCloseErr):
+		return cfdflow.CloseTerminated
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return cfdflow.CloseConnection
+	case err == nil, errors.Is(err, net.ErrClosed), errors.Is(err, io.EOF):
+		return cfdflow.CloseByOrigin
+	default:
+		return cfdflow.CloseError
+	}
+}
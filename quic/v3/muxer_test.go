@@ -10,6 +10,7 @@ import (
 	"slices"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -915,3 +916,128 @@ func (m *mockSession) Write(payload []byte) (n int, err error) {
 func (m *mockSession) Close() error {
 	return nil
 }
+
+type demuxDropCountingMetrics struct {
+	noopMetrics
+	dropped atomic.Int64
+}
+
+func (m *demuxDropCountingMetrics) DroppedDemuxDatagram(connIndex uint8) {
+	m.dropped.Add(1)
+}
+
+func TestDatagramConnServe_DemuxQueueFull(t *testing.T) {
+	log := zerolog.Nop()
+	quic := newMockQuicConn()
+	session := newMockSession()
+	sessionManager := mockSessionManager{session: &session}
+	metrics := &demuxDropCountingMetrics{}
+	conn := v3.NewDatagramConnWithConfig(quic, &sessionManager, &noopICMPRouter{}, 0, metrics, 1280, v3.DemuxConfig{Workers: 1, QueueDepth: 2}, &log)
+
+	ctx, cancel := context.WithCancelCause(t.Context())
+	defer cancel(errors.New("other error"))
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.Serve(ctx)
+	}()
+
+	// The session doesn't read the payloads, so once one is buffered by the session the only worker blocks and the
+	// queue fills up
+	quic.send <- newSessionPayloadDatagram(testRequestID, []byte{0})
+	require.Eventually(t, func() bool {
+		return len(session.recv) == 1
+	}, time.Second, 10*time.Millisecond)
+	const sent = 6
+	for i := 1; i < sent; i++ {
+		quic.send <- newSessionPayloadDatagram(testRequestID, []byte{byte(i)})
+	}
+	// At most one payload is written by the worker and two are queued
+	require.Eventually(t, func() bool {
+		return metrics.dropped.Load() >= sent-4
+	}, time.Second, 10*time.Millisecond)
+
+	// The worker resumes once the session reads the payloads, and every payload was either processed or dropped
+	received := 0
+	for received+int(metrics.dropped.Load()) < sent {
+		select {
+		case <-session.recv:
+			received++
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d payloads to be processed or dropped, got %d processed and %d dropped", sent, received, metrics.dropped.Load())
+		}
+	}
+
+	assertContextClosed(t, ctx, done, cancel)
+}
+
+type flowCountingMetrics struct {
+	noopMetrics
+	lock          sync.Mutex
+	registrations map[string]int
+	closed        map[string]int
+	dropped       map[string]int
+}
+
+func newFlowCountingMetrics() *flowCountingMetrics {
+	return &flowCountingMetrics{
+		registrations: make(map[string]int),
+		closed:        make(map[string]int),
+		dropped:       make(map[string]int),
+	}
+}
+
+func (m *flowCountingMetrics) RegisteredFlow(connIndex uint8, result string, duration time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.registrations[result]++
+}
+
+func (m *flowCountingMetrics) ClosedFlow(connIndex uint8, reason string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.closed[reason]++
+}
+
+func (m *flowCountingMetrics) DroppedFlowDatagram(connIndex uint8, reason string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.dropped[reason]++
+}
+
+func (m *flowCountingMetrics) count(counts map[string]int, key string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return counts[key]
+}
+
+func TestDatagramConnServe_FlowMetrics(t *testing.T) {
+	log := zerolog.Nop()
+	quic := newMockQuicConn()
+	session := newMockSession()
+	// The session is served and closed right away, so the payloads don't find it anymore
+	sessionManager := mockSessionManager{session: &session, expectedGetErr: v3.ErrSessionNotFound}
+	metrics := newFlowCountingMetrics()
+	conn := v3.NewDatagramConn(quic, &sessionManager, &noopICMPRouter{}, 0, metrics, &log)
+
+	ctx, cancel := context.WithCancelCause(t.Context())
+	defer cancel(errors.New("other error"))
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.Serve(ctx)
+	}()
+
+	quic.send <- newRegisterSessionDatagram(testRequestID)
+	var resp v3.UDPSessionRegistrationResponseDatagram
+	require.NoError(t, resp.UnmarshalBinary(<-quic.recv))
+	require.Equal(t, v3.ResponseOk, resp.ResponseType)
+	require.Eventually(t, func() bool {
+		return metrics.count(metrics.registrations, "ok") == 1 && metrics.count(metrics.closed, cfdflow.CloseTerminated) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	quic.send <- newSessionPayloadDatagram(testRequestID, []byte{0xef})
+	require.Eventually(t, func() bool {
+		return metrics.count(metrics.dropped, "no_flow") == 1
+	}, time.Second, 10*time.Millisecond)
+
+	assertContextClosed(t, ctx, done, cancel)
+}
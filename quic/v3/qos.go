@@ -0,0 +1,135 @@
+package v3
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cloudflare/cloudflared/cfio"
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+// qosQueueCapacity is the number of datagrams that can wait to be sent to the edge across all QoS classes of a
+// connection.
+const qosQueueCapacity = 256
+
+// prioritizedUDPWriter sends UDP session datagrams according to their QoS class.
+type prioritizedUDPWriter interface {
+	SendPrioritizedUDPSessionDatagram(datagram []byte, class ingress.QoSClass) error
+}
+
+// datagramScheduler queues the UDP session datagrams of a connection per QoS class and sends the highest class
+// first. When the queue is full, a datagram of a lower class is dropped to make room, otherwise the incoming
+// datagram is dropped.
+type datagramScheduler struct {
+	send      func([]byte) error
+	onDrop    func(class ingress.QoSClass)
+	onSendErr func(error)
+
+	lock   sync.Mutex
+	queues [len(qosQueueClasses)][]*[]byte
+	queued int
+	// ready has a single slot to wake up the sender without blocking the producers
+	ready chan struct{}
+}
+
+// qosQueueClasses maps queue indexes to classes, from the lowest to the highest priority.
+var qosQueueClasses = [...]ingress.QoSClass{ingress.QoSBulk, ingress.QoSStandard, ingress.QoSRealtime}
+
+func newDatagramScheduler(send func([]byte) error, onDrop func(ingress.QoSClass), onSendErr func(error)) *datagramScheduler {
+	return &datagramScheduler{
+		send:      send,
+		onDrop:    onDrop,
+		onSendErr: onSendErr,
+		ready:     make(chan struct{}, 1),
+	}
+}
+
+func queueIndex(class ingress.QoSClass) int {
+	for i, c := range qosQueueClasses {
+		if c == class {
+			return i
+		}
+	}
+	// Unclassified datagrams are queued with the standard ones
+	return 1
+}
+
+// enqueue queues a copy of datagram, returning false if it was dropped.
+func (s *datagramScheduler) enqueue(datagram []byte, class ingress.QoSClass) bool {
+	index := queueIndex(class)
+	dropped := -1
+	s.lock.Lock()
+	if s.queued >= qosQueueCapacity {
+		for i := 0; i < index; i++ {
+			if len(s.queues[i]) > 0 {
+				dropped = i
+				break
+			}
+		}
+		if dropped < 0 {
+			s.lock.Unlock()
+			s.onDrop(qosQueueClasses[index])
+			return false
+		}
+		// Drop the newest datagram of the lower class, the oldest ones are closer to being sent
+		last := len(s.queues[dropped]) - 1
+		cfio.PutBuffer(s.queues[dropped][last])
+		s.queues[dropped][last] = nil
+		s.queues[dropped] = s.queues[dropped][:last]
+		s.queued--
+	}
+	// The datagram buffer is reused by the session, so we need our own copy
+	buf := cfio.GetBuffer(len(datagram))
+	copy(*buf, datagram)
+	s.queues[index] = append(s.queues[index], buf)
+	s.queued++
+	s.lock.Unlock()
+
+	if dropped >= 0 {
+		s.onDrop(qosQueueClasses[dropped])
+	}
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// dequeue returns the oldest datagram of the highest non-empty class, to give back to the buffer pool once sent.
+func (s *datagramScheduler) dequeue() (*[]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := len(s.queues) - 1; i >= 0; i-- {
+		if len(s.queues[i]) > 0 {
+			datagram := s.queues[i][0]
+			s.queues[i][0] = nil
+			s.queues[i] = s.queues[i][1:]
+			s.queued--
+			return datagram, true
+		}
+	}
+	return nil, false
+}
+
+// run sends the queued datagrams until ctx is done.
+func (s *datagramScheduler) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.ready:
+		}
+		for ctx.Err() == nil {
+			datagram, ok := s.dequeue()
+			if !ok {
+				break
+			}
+			// The datagram is copied by the connection, so its buffer can be reused once sent
+			err := s.send(*datagram)
+			cfio.PutBuffer(datagram)
+			if err != nil {
+				s.onSendErr(err)
+			}
+		}
+	}
+}
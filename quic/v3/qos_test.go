@@ -0,0 +1,96 @@
+package v3_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/ingress"
+	v3 "github.com/cloudflare/cloudflared/quic/v3"
+)
+
+type prioritizedWriter interface {
+	SendPrioritizedUDPSessionDatagram(datagram []byte, class ingress.QoSClass) error
+}
+
+// blockingQuicConn blocks every SendDatagram until it is released.
+type blockingQuicConn struct {
+	mockQuicConn
+	entered chan []byte
+	release chan struct{}
+}
+
+func (m *blockingQuicConn) SendDatagram(payload []byte) error {
+	m.entered <- payload
+	<-m.release
+	return nil
+}
+
+type dropCountingMetrics struct {
+	noopMetrics
+	lock    sync.Mutex
+	dropped map[string]int
+}
+
+func (m *dropCountingMetrics) DroppedUDPDatagram(connIndex uint8, qosClass string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.dropped[qosClass]++
+}
+
+func (m *dropCountingMetrics) droppedCount(qosClass string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.dropped[qosClass]
+}
+
+func TestDatagramConn_SendPrioritizedUDPSessionDatagram(t *testing.T) {
+	log := zerolog.Nop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	quic := &blockingQuicConn{
+		mockQuicConn: mockQuicConn{ctx: ctx},
+		entered:      make(chan []byte),
+		release:      make(chan struct{}),
+	}
+	metrics := &dropCountingMetrics{dropped: map[string]int{}}
+	conn, ok := v3.NewDatagramConn(quic, nil, nil, 0, metrics, &log).(prioritizedWriter)
+	require.True(t, ok)
+
+	// Block the sender on a first datagram, so that the following ones are queued
+	require.NoError(t, conn.SendPrioritizedUDPSessionDatagram([]byte("first"), ingress.QoSBulk))
+	require.Equal(t, []byte("first"), <-quic.entered)
+
+	// Fill the queue with bulk datagrams
+	const capacity = 256
+	for i := 0; i < capacity; i++ {
+		require.NoError(t, conn.SendPrioritizedUDPSessionDatagram([]byte(fmt.Sprintf("bulk-%d", i)), ingress.QoSBulk))
+	}
+	// A full queue drops incoming datagrams of the lowest class
+	require.NoError(t, conn.SendPrioritizedUDPSessionDatagram([]byte("dropped"), ingress.QoSBulk))
+	require.Equal(t, 1, metrics.droppedCount("bulk"))
+
+	// Higher classes make room by dropping bulk datagrams
+	require.NoError(t, conn.SendPrioritizedUDPSessionDatagram([]byte("standard"), ingress.QoSStandard))
+	require.NoError(t, conn.SendPrioritizedUDPSessionDatagram([]byte("realtime"), ingress.QoSRealtime))
+	require.Equal(t, 3, metrics.droppedCount("bulk"))
+	require.Equal(t, 0, metrics.droppedCount("standard"))
+
+	// Higher classes are sent first, then bulk datagrams in order
+	quic.release <- struct{}{}
+	expected := []string{"realtime", "standard", "bulk-0", "bulk-1"}
+	for _, e := range expected {
+		select {
+		case datagram := <-quic.entered:
+			require.Equal(t, e, string(datagram))
+			quic.release <- struct{}{}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for datagram %s", e)
+		}
+	}
+}
@@ -11,6 +11,11 @@ import (
 	"time"
 
 	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/cfio"
+	cfdflow "github.com/cloudflare/cloudflared/flow"
+	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/recovery"
 )
 
 const (
@@ -72,7 +77,21 @@ type session struct {
 	closeChan    chan error
 	contextChan  chan context.Context
 	metrics      Metrics
-	log          *zerolog.Logger
+	// log is replaced when the session is migrated, while the session is served
+	log atomic.Pointer[zerolog.Logger]
+	// qosClass is set by the session manager before the session is served
+	qosClass ingress.QoSClass
+	// policer is set by the session manager before the session is served, nil when the datagrams aren't policed
+	policer *sessionPolicer
+	// migrationGrace is set by the session manager before the session is served. It's how long the session waits to
+	// be migrated to another connection once its connection is gone; with zero it's closed along with its connection.
+	migrationGrace time.Duration
+	// connCtx is the context of the connection carrying the session
+	connCtx atomic.Pointer[context.Context]
+	// done is closed once the session stops serving, so that a migration doesn't wait for it
+	done chan struct{}
+	// counters counts the traffic of the session for its flow record
+	counters cfdflow.Counters
 
 	// A special close function that we wrap with sync.Once to make sure it is only called once
 	closeFn func() error
@@ -88,6 +107,19 @@ func NewSession(
 	metrics Metrics,
 	log *zerolog.Logger,
 ) Session {
+	return newSession(id, closeAfterIdle, origin, originAddr, localAddr, eyeball, metrics, log)
+}
+
+func newSession(
+	id RequestID,
+	closeAfterIdle time.Duration,
+	origin io.ReadWriteCloser,
+	originAddr net.Addr,
+	localAddr net.Addr,
+	eyeball DatagramConn,
+	metrics Metrics,
+	log *zerolog.Logger,
+) *session {
 	logger := log.With().Str(logFlowID, id.String()).Logger()
 	// closeChan has two slots to allow for both writers (the closeFn and the Serve routine) to both be able to
 	// write to the channel without blocking since there is only ever one value read from the closeChan by the
@@ -106,8 +138,8 @@ func NewSession(
 		closeChan:    closeChan,
 		// contextChan is an unbounded channel to help enforce one active migration of a session at a time.
 		contextChan: make(chan context.Context),
+		done:        make(chan struct{}),
 		metrics:     metrics,
-		log:         &logger,
 		closeFn: sync.OnceValue(func() error {
 			// We don't want to block on sending to the close channel if it is already full
 			select {
@@ -118,9 +150,15 @@ func NewSession(
 		}),
 	}
 	session.eyeball.Store(&eyeball)
+	session.log.Store(&logger)
 	return session
 }
 
+// Counters returns the traffic counts of the session.
+func (s *session) Counters() *cfdflow.Counters {
+	return &s.counters
+}
+
 func (s *session) ID() RequestID {
 	return s.id
 }
@@ -138,14 +176,29 @@ func (s *session) ConnectionID() uint8 {
 	return eyeball.ID()
 }
 
+// boundTo returns whether the session is carried by conn.
+func (s *session) boundTo(conn DatagramConn) bool {
+	return *(s.eyeball.Load()) == conn
+}
+
+// connectionLost returns whether the connection carrying the session is gone.
+func (s *session) connectionLost() bool {
+	ctx := s.connCtx.Load()
+	return ctx != nil && (*ctx).Err() != nil
+}
+
 func (s *session) Migrate(eyeball DatagramConn, ctx context.Context, logger *zerolog.Logger) {
 	current := *(s.eyeball.Load())
-	// Only migrate if the connection ids are different.
-	if current.ID() != eyeball.ID() {
+	// Only migrate if the connections are different. A connection that reconnected keeps its index.
+	if current != eyeball {
 		s.eyeball.Store(&eyeball)
-		s.contextChan <- ctx
+		s.connCtx.Store(&ctx)
+		select {
+		case s.contextChan <- ctx:
+		case <-s.done:
+		}
 		log := logger.With().Str(logFlowID, s.id.String()).Logger()
-		s.log = &log
+		s.log.Store(&log)
 	}
 	// The session is already running so we want to restart the idle timeout since no proxied packets have come down yet.
 	s.markActive()
@@ -154,10 +207,14 @@ func (s *session) Migrate(eyeball DatagramConn, ctx context.Context, logger *zer
 }
 
 func (s *session) Serve(ctx context.Context) error {
+	s.connCtx.Store(&ctx)
 	go func() {
+		defer recovery.Recover(s.log.Load(), recovery.SubsystemDatagram, int(s.ConnectionID()))
 		// QUIC implementation copies data to another buffer before returning https://github.com/quic-go/quic-go/blob/v0.24.0/session.go#L1967-L1975
 		// This makes it safe to share readBuffer between iterations
-		readBuffer := [maxOriginUDPPacketSize + DatagramPayloadHeaderLen]byte{}
+		pooledBuffer := cfio.GetBuffer(maxOriginUDPPacketSize + DatagramPayloadHeaderLen)
+		defer cfio.PutBuffer(pooledBuffer)
+		readBuffer := *pooledBuffer
 		// To perform a zero copy write when passing the datagram to the connection, we prepare the buffer with
 		// the required datagram header information. We can reuse this buffer for this session since the header is the
 		// same for the each read.
@@ -168,31 +225,48 @@ func (s *session) Serve(ctx context.Context) error {
 			if err != nil {
 				if errors.Is(err, io.EOF) ||
 					errors.Is(err, io.ErrUnexpectedEOF) {
-					s.log.Debug().Msgf("flow (origin) connection closed: %v", err)
+					s.log.Load().Debug().Msgf("flow (origin) connection closed: %v", err)
 				}
 				s.closeChan <- err
 				return
 			}
 			if n < 0 {
-				s.log.Warn().Int(logPacketSizeKey, n).Msg("flow (origin) packet read was negative and was dropped")
+				s.log.Load().Warn().Int(logPacketSizeKey, n).Msg("flow (origin) packet read was negative and was dropped")
 				continue
 			}
-			if n > maxDatagramPayloadLen {
+			// We need to synchronize on the eyeball in-case that the connection was migrated. This should be rarely a point
+			// of lock contention, as a migration can only happen during startup of a session before traffic flow.
+			eyeball := *(s.eyeball.Load())
+			if n > maxPayloadLen(eyeball) {
 				connectionIndex := s.ConnectionID()
 				s.metrics.PayloadTooLarge(connectionIndex)
-				s.log.Error().Int(logPacketSizeKey, n).Msg("flow (origin) packet read was too large and was dropped")
+				s.metrics.DroppedFlowDatagram(connectionIndex, dropPayloadTooLarge)
+				s.log.Load().Error().Int(logPacketSizeKey, n).Msg("flow (origin) packet read was too large and was dropped")
+				continue
+			}
+			if allowed, limit := s.policer.allow(n); !allowed {
+				s.metrics.PolicedUDPDatagram(eyeball.ID(), limit)
+				s.metrics.DroppedFlowDatagram(eyeball.ID(), dropRateLimited)
 				continue
 			}
-			// We need to synchronize on the eyeball in-case that the connection was migrated. This should be rarely a point
-			// of lock contention, as a migration can only happen during startup of a session before traffic flow.
-			eyeball := *(s.eyeball.Load())
 			// Sending a packet to the session does block on the [quic.Connection], however, this is okay because it
 			// will cause back-pressure to the kernel buffer if the writes are not fast enough to the edge.
-			err = eyeball.SendUDPSessionDatagram(readBuffer[:DatagramPayloadHeaderLen+n])
+			// Flows with a QoS class are queued instead, so that lower classes are dropped first under pressure.
+			if writer, ok := eyeball.(prioritizedUDPWriter); ok && s.qosClass != ingress.QoSUnclassified {
+				err = writer.SendPrioritizedUDPSessionDatagram(readBuffer[:DatagramPayloadHeaderLen+n], s.qosClass)
+			} else {
+				err = eyeball.SendUDPSessionDatagram(readBuffer[:DatagramPayloadHeaderLen+n])
+			}
 			if err != nil {
+				// The datagrams are dropped while the session waits to be migrated off a connection that's gone
+				if s.migrationGrace > 0 && s.connectionLost() {
+					continue
+				}
 				s.closeChan <- err
 				return
 			}
+			s.counters.FromOrigin(n)
+			s.metrics.ProxiedDatagram(eyeball.ID(), directionFromOrigin, n)
 			// Mark the session as active since we proxied a valid packet from the origin.
 			s.markActive()
 		}
@@ -201,16 +275,24 @@ func (s *session) Serve(ctx context.Context) error {
 }
 
 func (s *session) Write(payload []byte) (n int, err error) {
+	if allowed, limit := s.policer.allow(len(payload)); !allowed {
+		// Policed datagrams are dropped like a congested network would, without failing the session
+		s.metrics.PolicedUDPDatagram(s.ConnectionID(), limit)
+		s.metrics.DroppedFlowDatagram(s.ConnectionID(), dropRateLimited)
+		return len(payload), nil
+	}
 	n, err = s.origin.Write(payload)
 	if err != nil {
-		s.log.Err(err).Msg("failed to write payload to flow (remote)")
+		s.log.Load().Err(err).Msg("failed to write payload to flow (remote)")
 		return n, err
 	}
 	// Write must return a non-nil error if it returns n < len(p). https://pkg.go.dev/io#Writer
 	if n < len(payload) {
-		s.log.Err(io.ErrShortWrite).Msg("failed to write the full payload to flow (remote)")
+		s.log.Load().Err(io.ErrShortWrite).Msg("failed to write the full payload to flow (remote)")
 		return n, io.ErrShortWrite
 	}
+	s.counters.ToOrigin(n)
+	s.metrics.ProxiedDatagram(s.ConnectionID(), directionToOrigin, n)
 	// Mark the session as active since we proxied a packet to the origin.
 	s.markActive()
 	return n, err
@@ -240,8 +322,10 @@ func (s *session) Close() error {
 
 func (s *session) waitForCloseCondition(ctx context.Context, closeAfterIdle time.Duration) error {
 	connCtx := ctx
+	connDone := connCtx.Done()
 	// Closing the session at the end cancels read so Serve() can return
 	defer s.Close()
+	defer close(s.done)
 	if closeAfterIdle == 0 {
 		// Provided that the default caller doesn't specify one
 		closeAfterIdle = defaultCloseIdleAfter
@@ -250,15 +334,26 @@ func (s *session) waitForCloseCondition(ctx context.Context, closeAfterIdle time
 	checkIdleTimer := time.NewTimer(closeAfterIdle)
 	defer checkIdleTimer.Stop()
 
+	// migrationDeadline is set once the connection is gone, while the session waits to be migrated
+	var migrationDeadline <-chan time.Time
+
 	for {
 		select {
-		case <-connCtx.Done():
+		case <-connDone:
+			if s.migrationGrace <= 0 {
+				return connCtx.Err()
+			}
+			connDone = nil
+			migrationDeadline = time.After(s.migrationGrace)
+		case <-migrationDeadline:
 			return connCtx.Err()
 		case newContext := <-s.contextChan:
 			// During migration of a session, we need to make sure that the context of the new connection is used instead
 			// of the old connection context. This will ensure that when the old connection goes away, this session will
 			// still be active on the existing connection.
 			connCtx = newContext
+			connDone = connCtx.Done()
+			migrationDeadline = nil
 			continue
 		case reason := <-s.closeChan:
 			return reason
@@ -13,6 +13,7 @@ import (
 	"github.com/fortytw2/leaktest"
 	"github.com/rs/zerolog"
 
+	cfdflow "github.com/cloudflare/cloudflared/flow"
 	v3 "github.com/cloudflare/cloudflared/quic/v3"
 )
 
@@ -58,6 +59,11 @@ func testSessionWrite(t *testing.T, payload []byte) {
 	if !slices.Equal(payload, read[:len(payload)]) {
 		t.Fatal("payload provided from origin and read value are not the same")
 	}
+	var record cfdflow.Record
+	session.(interface{ Counters() *cfdflow.Counters }).Counters().Fill(&record)
+	if record.BytesToOrigin != uint64(len(payload)) || record.PacketsToOrigin != 1 {
+		t.Fatalf("unexpected traffic counts of the flow: %+v", record)
+	}
 }
 
 func TestSessionWrite_Max(t *testing.T) {
@@ -164,6 +170,36 @@ func TestSessionServe_OriginTooLarge(t *testing.T) {
 	}
 }
 
+func TestSessionServe_ConnectionMaxPayload(t *testing.T) {
+	log := zerolog.Nop()
+	quic := newMockQuicConn()
+	conn := v3.NewDatagramConnWithMaxPayload(quic, nil, nil, 0, &noopMetrics{}, 1000, &log)
+	origin, server := net.Pipe()
+	defer origin.Close()
+	defer server.Close()
+	session := v3.NewSession(testRequestID, 2*time.Second, origin, testOriginAddr, testLocalAddr, conn, &noopMetrics{}, &log)
+	defer session.Close()
+	go func() {
+		_ = session.Serve(t.Context())
+	}()
+
+	// The payloads the path to the edge can't carry are dropped
+	if _, err := server.Write(makePayload(1001)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Write(makePayload(1000)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-quic.recv:
+		if len(data) != v3.DatagramPayloadHeaderLen+1000 {
+			t.Fatalf("expected the payload within the limit, got a datagram of %d bytes", len(data))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the payload within the limit to be sent")
+	}
+}
+
 func TestSessionServe_Migrate(t *testing.T) {
 	defer leaktest.Check(t)()
 	log := zerolog.Nop()
@@ -0,0 +1,19 @@
+package recovery
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var panics = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "cloudflared",
+		Subsystem: "recovery",
+		Name:      "panics_total",
+		Help:      "Count of the panics recovered from, by subsystem and connection",
+	},
+	[]string{"subsystem", "conn_index"},
+)
+
+func init() {
+	prometheus.MustRegister(panics)
+}
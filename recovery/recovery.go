@@ -0,0 +1,75 @@
+// Package recovery recovers from the panics of the long-running goroutines of cloudflared, so that a bug in the
+// handling of one request, datagram or configuration doesn't crash the whole process. Each panic is counted, and
+// reported to Sentry at the configured sample rate, tagged with the subsystem and the connection it happened in.
+package recovery
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// NoConnIndex is the connection index of the goroutines that don't belong to a connection
+	NoConnIndex = -1
+
+	// Subsystems the panics are recovered in
+	SubsystemSupervisor   = "supervisor"
+	SubsystemDatagram     = "datagram"
+	SubsystemProxy        = "proxy"
+	SubsystemOrchestrator = "orchestrator"
+)
+
+// sentrySampleRate holds the bits of the share of the panics reported to Sentry.
+var sentrySampleRate atomic.Uint64
+
+// SetSentrySampleRate sets the share, between 0 and 1, of the panics reported to Sentry. None are by default.
+func SetSentrySampleRate(rate float64) {
+	sentrySampleRate.Store(math.Float64bits(math.Max(0, math.Min(rate, 1))))
+}
+
+// Recover recovers from a panic of the goroutine deferring it, logging and reporting it. It must be deferred
+// directly, as in defer recovery.Recover(log, recovery.SubsystemDatagram, connIndex).
+func Recover(log *zerolog.Logger, subsystem string, connIndex int) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err := Error(r, subsystem, connIndex)
+	event := log.Error().Err(err).Str("subsystem", subsystem)
+	if connIndex != NoConnIndex {
+		event = event.Int("connIndex", connIndex)
+	}
+	event.Msg("Recovered from a panic")
+}
+
+// Error reports the value r recovered from a panic, returning it as an error carrying the stack of the panic.
+func Error(r any, subsystem string, connIndex int) error {
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("panic: %v", r)
+	}
+	err = errors.Wrapf(err, "stack trace: %s", string(debug.Stack()))
+
+	connLabel := "none"
+	if connIndex != NoConnIndex {
+		connLabel = strconv.Itoa(connIndex)
+	}
+	panics.WithLabelValues(subsystem, connLabel).Inc()
+	if rate := math.Float64frombits(sentrySampleRate.Load()); rate > 0 && rand.Float64() < rate {
+		hub := sentry.CurrentHub().Clone()
+		hub.ConfigureScope(func(scope *sentry.Scope) {
+			scope.SetTag("subsystem", subsystem)
+			scope.SetTag("connIndex", connLabel)
+		})
+		hub.CaptureException(err)
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package recovery
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func panicCount(t *testing.T, subsystem, connIndex string) float64 {
+	var m dto.Metric
+	require.NoError(t, panics.WithLabelValues(subsystem, connIndex).Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+func TestRecover(t *testing.T) {
+	var logs bytes.Buffer
+	log := zerolog.New(&logs)
+	before := panicCount(t, SubsystemDatagram, "2")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer Recover(&log, SubsystemDatagram, 2)
+		panic("boom")
+	}()
+	<-done
+
+	assert.Equal(t, before+1, panicCount(t, SubsystemDatagram, "2"))
+	assert.Contains(t, logs.String(), `"subsystem":"datagram"`)
+	assert.Contains(t, logs.String(), `"connIndex":2`)
+	assert.Contains(t, logs.String(), "panic: boom")
+}
+
+func TestRecoverWithoutPanic(t *testing.T) {
+	var logs bytes.Buffer
+	log := zerolog.New(&logs)
+	func() {
+		defer Recover(&log, SubsystemProxy, NoConnIndex)
+	}()
+	assert.Empty(t, logs.String())
+}
+
+func TestError(t *testing.T) {
+	before := panicCount(t, SubsystemOrchestrator, "none")
+	cause := errors.New("nil map")
+	var err error
+	func() {
+		defer func() {
+			err = Error(recover(), SubsystemOrchestrator, NoConnIndex)
+		}()
+		panic(cause)
+	}()
+	require.ErrorIs(t, err, cause)
+	assert.Contains(t, err.Error(), "stack trace:")
+	assert.Contains(t, err.Error(), "recovery.TestError")
+	assert.Equal(t, before+1, panicCount(t, SubsystemOrchestrator, "none"))
+}
+
+func TestSetSentrySampleRate(t *testing.T) {
+	t.Cleanup(func() { SetSentrySampleRate(0) })
+	SetSentrySampleRate(2)
+	assert.Equal(t, uint64(0x3ff0000000000000), sentrySampleRate.Load())
+	SetSentrySampleRate(-1)
+	assert.Equal(t, uint64(0), sentrySampleRate.Load())
+}
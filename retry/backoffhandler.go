@@ -2,14 +2,86 @@ package retry
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"math/rand"
 	"time"
 )
 
 const (
-	DefaultBaseTime time.Duration = time.Second
+	DefaultBaseTime   time.Duration = time.Second
+	DefaultMultiplier               = 2
 )
 
+// Jitter is how the backoff randomizes the time to wait before retrying, so that clients failing together don't
+// retry together.
+type Jitter int
+
+const (
+	// FullJitter waits a random time up to the exponential backoff.
+	FullJitter Jitter = iota
+	// EqualJitter waits half of the exponential backoff, plus a random time up to the other half.
+	EqualJitter
+	// NoJitter waits the exponential backoff.
+	NoJitter
+	// DecorrelatedJitter waits a random time between the base time and three times the previous wait, which spreads
+	// the retries of clients that started failing at the same time better than the exponential backoff.
+	DecorrelatedJitter
+)
+
+var jitterNames = map[Jitter]string{
+	FullJitter:         "full",
+	EqualJitter:        "equal",
+	NoJitter:           "none",
+	DecorrelatedJitter: "decorrelated",
+}
+
+func (j Jitter) String() string {
+	if name, ok := jitterNames[j]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown jitter %d", int(j))
+}
+
+// ParseJitter parses the name of a jitter: full, equal, none or decorrelated.
+func ParseJitter(name string) (Jitter, error) {
+	for jitter, jitterName := range jitterNames {
+		if name == jitterName {
+			return jitter, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown jitter %q, expected full, equal, none or decorrelated", name)
+}
+
+// Strategy configures how long a BackoffHandler waits between retries. The zero value waits a random time up to
+// DefaultBaseTime, doubling with each retry.
+type Strategy struct {
+	// BaseTime is the backoff of the first retry.
+	BaseTime time.Duration
+	// MaxTime caps the time to wait. Zero caps it to the backoff of the last retry.
+	MaxTime time.Duration
+	// Multiplier multiplies the backoff with each retry. Zero is DefaultMultiplier.
+	Multiplier float64
+	Jitter     Jitter
+}
+
+// Validate checks that the strategy makes the backoff grow.
+func (s Strategy) Validate() error {
+	if s.BaseTime < 0 || s.MaxTime < 0 {
+		return fmt.Errorf("the backoff times must be positive")
+	}
+	if s.Multiplier != 0 && s.Multiplier < 1 {
+		return fmt.Errorf("the backoff multiplier must be at least 1")
+	}
+	if s.MaxTime != 0 && s.MaxTime < s.BaseTime {
+		return fmt.Errorf("the max backoff %s is lower than the base backoff %s", s.MaxTime, s.BaseTime)
+	}
+	if _, ok := jitterNames[s.Jitter]; !ok {
+		return fmt.Errorf("%s", s.Jitter)
+	}
+	return nil
+}
+
 // Redeclare time functions so they can be overridden in tests.
 type Clock struct {
 	Now   func() time.Time
@@ -30,8 +102,14 @@ type BackoffHandler struct {
 	retryForever bool
 	// BaseTime sets the initial backoff period.
 	baseTime time.Duration
+	// maxTime, multiplier and jitter are set by the Strategy
+	maxTime    time.Duration
+	multiplier float64
+	jitter     Jitter
 
-	retries       uint
+	retries uint
+	// previousWait is the last time waited by the decorrelated jitter
+	previousWait  time.Duration
 	resetDeadline time.Time
 
 	Clock Clock
@@ -46,6 +124,15 @@ func NewBackoff(maxRetries uint, baseTime time.Duration, retryForever bool) Back
 	}
 }
 
+// NewBackoffWithStrategy makes a BackoffHandler waiting between retries according to strategy.
+func NewBackoffWithStrategy(maxRetries uint, strategy Strategy, retryForever bool) BackoffHandler {
+	b := NewBackoff(maxRetries, strategy.BaseTime, retryForever)
+	b.maxTime = strategy.MaxTime
+	b.multiplier = strategy.Multiplier
+	b.jitter = strategy.Jitter
+	return b
+}
+
 func (b BackoffHandler) GetMaxBackoffDuration(ctx context.Context) (time.Duration, bool) {
 	// Follows the same logic as Backoff, but without mutating the receiver.
 	// This select has to happen first to reflect the actual behaviour of the Backoff function.
@@ -61,8 +148,10 @@ func (b BackoffHandler) GetMaxBackoffDuration(ctx context.Context) (time.Duratio
 	if b.retries >= b.maxRetries && !b.retryForever {
 		return time.Duration(0), false
 	}
-	maxTimeToWait := b.GetBaseTime() * 1 << (b.retries + 1)
-	return maxTimeToWait, true
+	if b.jitter == DecorrelatedJitter {
+		return b.decorrelatedMaxWait(), true
+	}
+	return b.exponentialWait(b.retries + 1), true
 }
 
 // BackoffTimer returns a channel that sends the current time when the exponential backoff timeout expires.
@@ -70,6 +159,7 @@ func (b BackoffHandler) GetMaxBackoffDuration(ctx context.Context) (time.Duratio
 func (b *BackoffHandler) BackoffTimer() <-chan time.Time {
 	if !b.resetDeadline.IsZero() && b.Clock.Now().After(b.resetDeadline) {
 		b.retries = 0
+		b.previousWait = 0
 		b.resetDeadline = time.Time{}
 	}
 	if b.retries >= b.maxRetries {
@@ -79,9 +169,69 @@ func (b *BackoffHandler) BackoffTimer() <-chan time.Time {
 	} else {
 		b.retries++
 	}
-	maxTimeToWait := b.GetBaseTime() * (1 << b.retries)
-	timeToWait := time.Duration(rand.Int63n(maxTimeToWait.Nanoseconds())) // #nosec G404
-	return b.Clock.After(timeToWait)
+	return b.Clock.After(b.nextWait())
+}
+
+// nextWait picks the time to wait before the current retry according to the jitter.
+func (b *BackoffHandler) nextWait() time.Duration {
+	switch b.jitter {
+	case EqualJitter:
+		maxTimeToWait := b.exponentialWait(b.retries)
+		return maxTimeToWait/2 + randomDuration(maxTimeToWait-maxTimeToWait/2)
+	case NoJitter:
+		return b.exponentialWait(b.retries)
+	case DecorrelatedJitter:
+		base := b.GetBaseTime()
+		b.previousWait = base + randomDuration(b.decorrelatedMaxWait()-base)
+		return b.previousWait
+	default:
+		return randomDuration(b.exponentialWait(b.retries))
+	}
+}
+
+// exponentialWait is the base time multiplied for each retry, capped to the max time.
+func (b *BackoffHandler) exponentialWait(retries uint) time.Duration {
+	wait := time.Duration(float64(b.GetBaseTime()) * math.Pow(b.getMultiplier(), float64(retries)))
+	if maxTime := b.getMaxTime(); maxTime > 0 && (wait > maxTime || wait <= 0) {
+		return maxTime
+	}
+	return wait
+}
+
+// decorrelatedMaxWait is three times the previous wait of the decorrelated jitter, capped to the max time.
+func (b *BackoffHandler) decorrelatedMaxWait() time.Duration {
+	base := b.GetBaseTime()
+	maxTimeToWait := 3 * b.previousWait
+	if maxTimeToWait < base {
+		maxTimeToWait = base
+	}
+	if maxTime := b.getMaxTime(); maxTimeToWait > maxTime {
+		return maxTime
+	}
+	return maxTimeToWait
+}
+
+func (b *BackoffHandler) getMultiplier() float64 {
+	if b.multiplier == 0 {
+		return DefaultMultiplier
+	}
+	return b.multiplier
+}
+
+// getMaxTime returns the max time to wait, which is by default the backoff of the last retry for the decorrelated
+// jitter, and uncapped otherwise.
+func (b *BackoffHandler) getMaxTime() time.Duration {
+	if b.maxTime == 0 && b.jitter == DecorrelatedJitter {
+		return time.Duration(float64(b.GetBaseTime()) * math.Pow(b.getMultiplier(), float64(b.maxRetries)))
+	}
+	return b.maxTime
+}
+
+func randomDuration(maxDuration time.Duration) time.Duration {
+	if maxDuration <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(maxDuration.Nanoseconds())) // #nosec G404
 }
 
 // Backoff is used to wait according to exponential backoff. Returns false if the
@@ -128,4 +278,5 @@ func (b *BackoffHandler) ReachedMaxRetries() bool {
 func (b *BackoffHandler) ResetNow() {
 	b.resetDeadline = b.Clock.Now()
 	b.retries = 0
+	b.previousWait = 0
 }
@@ -140,3 +140,99 @@ func TestBackoffRetryForever(t *testing.T) {
 		t.Fatalf("backoff returned %v instead of 8 seconds on fifth retry", duration)
 	}
 }
+
+// recordWaits makes the backoff return immediately, recording the times it waits.
+func recordWaits(backoff *BackoffHandler) *[]time.Duration {
+	var waits []time.Duration
+	backoff.Clock = Clock{time.Now, func(d time.Duration) <-chan time.Time {
+		waits = append(waits, d)
+		return immediateTimeAfter(d)
+	}}
+	return &waits
+}
+
+func TestBackoffStrategyJitter(t *testing.T) {
+	ctx := context.Background()
+	strategy := Strategy{BaseTime: time.Second, MaxTime: 10 * time.Second, Multiplier: 3}
+
+	strategy.Jitter = NoJitter
+	backoff := NewBackoffWithStrategy(4, strategy, false)
+	waits := recordWaits(&backoff)
+	for backoff.Backoff(ctx) {
+	}
+	expected := []time.Duration{3 * time.Second, 9 * time.Second, 10 * time.Second, 10 * time.Second}
+	if len(*waits) != len(expected) {
+		t.Fatalf("backoff waited %v instead of %v", *waits, expected)
+	}
+	for i, wait := range *waits {
+		if wait != expected[i] {
+			t.Fatalf("backoff waited %v instead of %v", *waits, expected)
+		}
+	}
+
+	strategy.Jitter = EqualJitter
+	backoff = NewBackoffWithStrategy(4, strategy, false)
+	waits = recordWaits(&backoff)
+	for backoff.Backoff(ctx) {
+	}
+	for i, wait := range *waits {
+		if wait < expected[i]/2 || wait > expected[i] {
+			t.Fatalf("backoff waited %s on retry %d, not between %s and %s", wait, i+1, expected[i]/2, expected[i])
+		}
+	}
+}
+
+func TestBackoffDecorrelatedJitter(t *testing.T) {
+	ctx := context.Background()
+	backoff := NewBackoffWithStrategy(5, Strategy{BaseTime: time.Second, Jitter: DecorrelatedJitter}, true)
+	waits := recordWaits(&backoff)
+	previous := time.Second
+	for i := 0; i < 20; i++ {
+		maxWait, ok := backoff.GetMaxBackoffDuration(ctx)
+		if !ok {
+			t.Fatalf("backoff refused despite RetryForever")
+		}
+		if !backoff.Backoff(ctx) {
+			t.Fatalf("backoff refused on retry %d despite RetryForever", i+1)
+		}
+		wait := (*waits)[i]
+		// The max time defaults to the backoff of the last retry
+		if wait < time.Second || wait > 3*previous || wait > 32*time.Second || wait > maxWait {
+			t.Fatalf("backoff waited %s after %s on retry %d", wait, previous, i+1)
+		}
+		previous = wait
+	}
+
+	// The decorrelation restarts from the base time
+	backoff.ResetNow()
+	if maxWait, _ := backoff.GetMaxBackoffDuration(ctx); maxWait != time.Second {
+		t.Fatalf("backoff returned %s instead of 1 second after reset", maxWait)
+	}
+}
+
+func TestParseJitter(t *testing.T) {
+	for _, jitter := range []Jitter{FullJitter, EqualJitter, NoJitter, DecorrelatedJitter} {
+		parsed, err := ParseJitter(jitter.String())
+		if err != nil || parsed != jitter {
+			t.Fatalf("parsed %s as %s, %v", jitter, parsed, err)
+		}
+	}
+	if _, err := ParseJitter("random"); err == nil {
+		t.Fatalf("parsed an unknown jitter")
+	}
+}
+
+func TestStrategyValidate(t *testing.T) {
+	valid := []Strategy{{}, {BaseTime: time.Second, MaxTime: time.Minute, Multiplier: 1.5, Jitter: DecorrelatedJitter}}
+	for _, strategy := range valid {
+		if err := strategy.Validate(); err != nil {
+			t.Fatalf("%+v is invalid: %v", strategy, err)
+		}
+	}
+	invalid := []Strategy{{BaseTime: -time.Second}, {Multiplier: 0.5}, {BaseTime: time.Minute, MaxTime: time.Second}, {Jitter: Jitter(10)}}
+	for _, strategy := range invalid {
+		if err := strategy.Validate(); err == nil {
+			t.Fatalf("%+v is valid", strategy)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+//go:build linux
+
+package sockmark
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const supported = true
+
+func set(rawConn syscall.RawConn, o Options) error {
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		if o.Mark != 0 {
+			// Requires CAP_NET_ADMIN
+			if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, int(o.Mark)); err != nil {
+				sockErr = fmt.Errorf("unable to set the firewall mark %#x of the socket: %w", o.Mark, err)
+				return
+			}
+		}
+		if o.Interface != "" {
+			if err := unix.BindToDevice(int(fd), o.Interface); err != nil {
+				sockErr = fmt.Errorf("unable to bind the socket to interface %s: %w", o.Interface, err)
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package sockmark
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestSet(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	err = Set(conn, Options{Mark: 0x2a, Interface: "lo"})
+	if err != nil {
+		t.Skipf("Setting the socket options needs CAP_NET_ADMIN: %v", err)
+	}
+	rawConn, err := conn.SyscallConn()
+	require.NoError(t, err)
+	var mark int
+	var device string
+	require.NoError(t, rawConn.Control(func(fd uintptr) {
+		mark, err = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK)
+		require.NoError(t, err)
+		device, err = unix.GetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE)
+		require.NoError(t, err)
+	}))
+	assert.Equal(t, 0x2a, mark)
+	assert.Equal(t, "lo", device)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package sockmark
+
+import "syscall"
+
+const supported = false
+
+func set(_ syscall.RawConn, o Options) error {
+	if o.IsZero() {
+		return nil
+	}
+	return errUnsupported
+}
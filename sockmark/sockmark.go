@@ -0,0 +1,64 @@
+// Package sockmark steers the connections to the edge with Linux policy routing, by setting the firewall mark of
+// their sockets (SO_MARK), which ip rules can match, and the interface they are bound to (SO_BINDTODEVICE). This is
+// how the tunnel's traffic is kept out of, or sent through, a VPN or the WARP client running on the same host.
+package sockmark
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var errUnsupported = errors.New("socket marks and interface binding are only supported on Linux")
+
+// Options are the options set on the sockets. The zero value leaves them as is.
+type Options struct {
+	// Mark is the firewall mark of the packets, unset when 0
+	Mark uint32
+	// Interface is the name of the interface the sockets are bound to, unbound when empty
+	Interface string
+}
+
+// IsZero returns whether the options leave the sockets as is.
+func (o Options) IsZero() bool {
+	return o == Options{}
+}
+
+// Validate returns an error if the options can't be set on this platform.
+func (o Options) Validate() error {
+	if !o.IsZero() && !supported {
+		return errUnsupported
+	}
+	return nil
+}
+
+// ParseMark parses a firewall mark, in decimal or in hexadecimal prefixed with 0x as ip rule prints them.
+func ParseMark(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	mark, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, errors.New("invalid firewall mark " + strconv.Quote(s) + ", expected a 32 bits number")
+	}
+	return uint32(mark), nil
+}
+
+// Set sets the options on a socket.
+func Set(conn syscall.Conn, o Options) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return set(rawConn, o)
+}
+
+// Control returns a Control function for net.Dialer that sets the options on the sockets it dials, before they
+// connect.
+func Control(o Options) func(network, address string, rawConn syscall.RawConn) error {
+	return func(_, _ string, rawConn syscall.RawConn) error {
+		return set(rawConn, o)
+	}
+}
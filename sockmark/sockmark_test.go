@@ -0,0 +1,20 @@
+package sockmark
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMark(t *testing.T) {
+	for input, expected := range map[string]uint32{"": 0, "42": 42, "0x2a": 42, " 0xffffffff ": 0xffffffff} {
+		mark, err := ParseMark(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, mark, input)
+	}
+	for _, input := range []string{"-1", "0x100000000", "mark"} {
+		_, err := ParseMark(input)
+		assert.Error(t, err, input)
+	}
+}
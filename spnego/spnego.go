@@ -0,0 +1,137 @@
+// Package spnego obtains the SPNEGO tokens authenticating cloudflared to origins with Windows integrated
+// authentication, such as IIS or Exchange.
+package spnego
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os/exec"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+// Provider obtains SPNEGO tokens, e.g. with a Kerberos library and a keytab.
+type Provider interface {
+	// Token returns a new SPNEGO token for the service principal name.
+	Token(ctx context.Context, spn string) ([]byte, error)
+}
+
+// Refresher is implemented by the providers whose credentials, e.g. a Kerberos ticket cache, need to be renewed.
+type Refresher interface {
+	Refresh(ctx context.Context) error
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]Provider)
+)
+
+// Register makes a provider available to ingress rules under name. It panics if name is already registered.
+func Register(name string, provider Provider) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if provider == nil {
+		panic("spnego: Register provider is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("spnego: Register called twice for provider " + name)
+	}
+	registry[name] = provider
+}
+
+// Lookup returns the provider registered under name.
+func Lookup(name string) (Provider, error) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	provider, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown SPNEGO provider %q, registered providers are %v", name, registeredNames())
+	}
+	return provider, nil
+}
+
+// caller must hold the lock
+func registeredNames() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProvider returns the provider of the tokens of an ingress rule, and what renews its credentials if anything.
+func NewProvider(cfg config.SPNEGOConfig) (Provider, Refresher, error) {
+	var provider Provider = &CommandProvider{Command: cfg.TokenCommand}
+	if cfg.Provider != "" {
+		registered, err := Lookup(cfg.Provider)
+		if err != nil {
+			return nil, nil, err
+		}
+		provider = registered
+	}
+	if len(cfg.RefreshCommand) > 0 {
+		return provider, &CommandRefresher{Command: cfg.RefreshCommand}, nil
+	}
+	if refresher, ok := provider.(Refresher); ok {
+		return provider, refresher, nil
+	}
+	return provider, nil, nil
+}
+
+// CommandProvider obtains the tokens by running a command, with the SPN as last argument, that prints a base64
+// encoded token.
+type CommandProvider struct {
+	Command []string
+}
+
+func (p *CommandProvider) Token(ctx context.Context, spn string) ([]byte, error) {
+	if len(p.Command) == 0 {
+		return nil, errors.New("no SPNEGO token command")
+	}
+	args := append(append([]string{}, p.Command[1:]...), spn)
+	output, err := runCommand(ctx, p.Command[0], args)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to obtain a SPNEGO token")
+	}
+	token, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(output)))
+	if err != nil {
+		return nil, errors.Wrap(err, "the SPNEGO token command didn't print a base64 encoded token")
+	}
+	if len(token) == 0 {
+		return nil, errors.New("the SPNEGO token command printed an empty token")
+	}
+	return token, nil
+}
+
+// CommandRefresher renews the credentials by running a command, e.g. kinit -R.
+type CommandRefresher struct {
+	Command []string
+}
+
+func (r *CommandRefresher) Refresh(ctx context.Context) error {
+	if _, err := runCommand(ctx, r.Command[0], r.Command[1:]); err != nil {
+		return errors.Wrap(err, "unable to refresh the Kerberos ticket cache")
+	}
+	return nil
+}
+
+func runCommand(ctx context.Context, name string, args []string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...) // nolint: gosec
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return output, nil
+}
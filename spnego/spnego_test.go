@@ -0,0 +1,62 @@
+package spnego
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestCommandProvider(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands are run by sh")
+	}
+	refreshed := filepath.Join(t.TempDir(), "refreshed")
+	provider, refresher, err := NewProvider(config.SPNEGOConfig{
+		TokenCommand:   []string{"sh", "-c", `test "$0" = HTTP/iis.example.com && echo dG9rZW4=`},
+		RefreshCommand: []string{"touch", refreshed},
+	})
+	require.NoError(t, err)
+
+	token, err := provider.Token(context.Background(), "HTTP/iis.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "token", string(token))
+	_, err = provider.Token(context.Background(), "HTTP/exchange.example.com")
+	require.Error(t, err)
+
+	require.NoError(t, refresher.Refresh(context.Background()))
+	assert.FileExists(t, refreshed)
+
+	invalid := &CommandProvider{Command: []string{"sh", "-c", "echo not base64!"}}
+	_, err = invalid.Token(context.Background(), "HTTP/iis.example.com")
+	require.ErrorContains(t, err, "base64")
+
+	failing := &CommandRefresher{Command: []string{"sh", "-c", "echo ticket expired >&2; exit 1"}}
+	require.ErrorContains(t, failing.Refresh(context.Background()), "ticket expired")
+}
+
+type staticProvider []byte
+
+func (p staticProvider) Token(context.Context, string) ([]byte, error) {
+	return p, nil
+}
+
+func TestRegister(t *testing.T) {
+	Register("static", staticProvider("token"))
+	assert.Panics(t, func() { Register("static", staticProvider("token")) })
+
+	provider, refresher, err := NewProvider(config.SPNEGOConfig{Provider: "static"})
+	require.NoError(t, err)
+	assert.Nil(t, refresher)
+	token, err := provider.Token(context.Background(), "HTTP/iis.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "token", string(token))
+
+	_, _, err = NewProvider(config.SPNEGOConfig{Provider: "unregistered"})
+	require.ErrorContains(t, err, `unknown SPNEGO provider "unregistered"`)
+}
@@ -45,6 +45,11 @@ func (n *nopCloseWriterAdapter) CloseWrite() error {
 	return nil
 }
 
+// Unwrap lets cfio.Copy splice between the sockets of the adapted streams.
+func (n *nopCloseWriterAdapter) Unwrap() io.ReadWriter {
+	return n.ReadWriter
+}
+
 type bidirectionalStreamStatus struct {
 	doneChan chan struct{}
 	anyDone  uint32
@@ -87,7 +92,12 @@ func (s *bidirectionalStreamStatus) isAnyDone() bool {
 
 // Pipe copies copy data to & from provided io.ReadWriters.
 func Pipe(tunnelConn, originConn io.ReadWriter, log *zerolog.Logger) {
-	PipeBidirectional(NopCloseWriterAdapter(tunnelConn), NopCloseWriterAdapter(originConn), 0, log)
+	PipeWithBackpressure(tunnelConn, originConn, nil, log)
+}
+
+// PipeWithBackpressure is Pipe, with both directions sharing the budget b of their group, if it's not nil.
+func PipeWithBackpressure(tunnelConn, originConn io.ReadWriter, b *cfio.Backpressure, log *zerolog.Logger) {
+	_ = pipeBidirectional(NopCloseWriterAdapter(tunnelConn), NopCloseWriterAdapter(originConn), 0, b, log)
 }
 
 // PipeBidirectional copies data two BidirectionStreams. It is a special case of Pipe where it receives a concept that allows for Read and Write side to be closed independently.
@@ -96,10 +106,14 @@ func Pipe(tunnelConn, originConn io.ReadWriter, log *zerolog.Logger) {
 // Finally, depending on once EOF is ready from one of the provided streams, the other direction of streaming data will have a configured time period to also finish, otherwise,
 // the method will return immediately  with a timeout error. It is however, the responsability of the caller to close the associated streams in both ends in order to free all the resources/go-routines.
 func PipeBidirectional(downstream, upstream Stream, maxWaitForSecondStream time.Duration, log *zerolog.Logger) error {
+	return pipeBidirectional(downstream, upstream, maxWaitForSecondStream, nil, log)
+}
+
+func pipeBidirectional(downstream, upstream Stream, maxWaitForSecondStream time.Duration, b *cfio.Backpressure, log *zerolog.Logger) error {
 	status := newBiStreamStatus()
 
-	go unidirectionalStream(downstream, upstream, "upstream->downstream", status, log)
-	go unidirectionalStream(upstream, downstream, "downstream->upstream", status, log)
+	go unidirectionalStream(downstream, upstream, "upstream->downstream", status, b, log)
+	go unidirectionalStream(upstream, downstream, "downstream->upstream", status, b, log)
 
 	if err := status.wait(maxWaitForSecondStream); err != nil {
 		return errors.Wrap(err, "unable to wait for both streams while proxying")
@@ -108,7 +122,7 @@ func PipeBidirectional(downstream, upstream Stream, maxWaitForSecondStream time.
 	return nil
 }
 
-func unidirectionalStream(dst WriterCloser, src Reader, dir string, status *bidirectionalStreamStatus, log *zerolog.Logger) {
+func unidirectionalStream(dst WriterCloser, src Reader, dir string, status *bidirectionalStreamStatus, b *cfio.Backpressure, log *zerolog.Logger) {
 	defer func() {
 		// The bidirectional streaming spawns 2 goroutines to stream each direction.
 		// If any ends, the callstack returns, meaning the Tunnel request/stream (depending on http2 vs quic) will
@@ -131,7 +145,7 @@ func unidirectionalStream(dst WriterCloser, src Reader, dir string, status *bidi
 
 	defer dst.CloseWrite()
 
-	_, err := copyData(dst, src, dir)
+	_, err := copyData(dst, src, dir, b)
 	if err != nil {
 		log.Debug().Msgf("%s copy: %v", dir, err)
 	}
@@ -141,7 +155,7 @@ func unidirectionalStream(dst WriterCloser, src Reader, dir string, status *bidi
 // when set to true, enables logging of content copied to/from origin and tunnel
 const debugCopy = false
 
-func copyData(dst io.Writer, src io.Reader, dir string) (written int64, err error) {
+func copyData(dst io.Writer, src io.Reader, dir string, b *cfio.Backpressure) (written int64, err error) {
 	if debugCopy {
 		// copyBuffer is based on stdio Copy implementation but shows copied data
 		copyBuffer := func(dst io.Writer, src io.Reader, dir string) (written int64, err error) {
@@ -181,6 +195,6 @@ func copyData(dst io.Writer, src io.Reader, dir string) (written int64, err erro
 		}
 		return copyBuffer(dst, src, dir)
 	} else {
-		return cfio.Copy(dst, src)
+		return cfio.CopyWithBackpressure(dst, src, b)
 	}
 }
@@ -0,0 +1,99 @@
+package supervisor
+
+import (
+	"sync"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/features"
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+// ConnectionFeatures are the features a connection to the edge was established with.
+type ConnectionFeatures struct {
+	Protocol string `json:"protocol"`
+	// DatagramVersion is v2 or v3, for QUIC connections only since http2 doesn't carry datagrams
+	DatagramVersion string `json:"datagramVersion,omitempty"`
+	// PostQuantum is the post-quantum mode, prefer or strict
+	PostQuantum string `json:"postQuantum"`
+	// PostQuantumDowngraded is whether the connection was only established after leaving out the post-quantum key
+	// agreements
+	PostQuantumDowngraded bool `json:"postQuantumDowngraded,omitempty"`
+	// Features are the features announced to the edge when registering
+	Features []string `json:"features"`
+	// ICMP is the backend proxying the ICMP requests carried by the datagrams, for QUIC connections only
+	ICMP string `json:"icmp,omitempty"`
+}
+
+func newConnectionFeatures(protocol connection.Protocol, snapshot features.FeatureSnapshot, pqMode features.PostQuantumMode, downgraded bool) ConnectionFeatures {
+	connFeatures := ConnectionFeatures{
+		Protocol:              protocol.String(),
+		PostQuantum:           "prefer",
+		PostQuantumDowngraded: downgraded,
+		Features:              snapshot.FeaturesList,
+	}
+	if pqMode == features.PostQuantumStrict {
+		connFeatures.PostQuantum = "strict"
+	}
+	if protocol == connection.QUIC {
+		connFeatures.DatagramVersion = "v2"
+		if snapshot.DatagramVersion == features.DatagramV3 {
+			connFeatures.DatagramVersion = "v3"
+		}
+	}
+	return connFeatures
+}
+
+// icmpFeature returns the ICMP capability of the router, disabled if the ICMP proxy couldn't be created.
+func icmpFeature(router ingress.ICMPRouterServer) string {
+	if router == nil {
+		return ingress.ICMPCapability{}.String()
+	}
+	return router.Capability().String()
+}
+
+// FeatureSnapshots holds the features of the connections to the edge, updated every time they (re)connect, to debug
+// e.g. datagram v2 versus v3 behavior without trace logs.
+type FeatureSnapshots struct {
+	lock  sync.RWMutex
+	conns map[uint8]ConnectionFeatures
+	// generations tells apart the successive connections of an index, since a drained connection ends after its
+	// replacement started
+	generations map[uint8]uint64
+}
+
+func NewFeatureSnapshots() *FeatureSnapshots {
+	return &FeatureSnapshots{
+		conns:       make(map[uint8]ConnectionFeatures),
+		generations: make(map[uint8]uint64),
+	}
+}
+
+// set records the features of a connection until the returned function is called, once it ends.
+func (f *FeatureSnapshots) set(connIndex uint8, connFeatures ConnectionFeatures) func() {
+	if f == nil {
+		return func() {}
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.conns[connIndex] = connFeatures
+	f.generations[connIndex]++
+	generation := f.generations[connIndex]
+	return func() {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		if f.generations[connIndex] == generation {
+			delete(f.conns, connIndex)
+		}
+	}
+}
+
+// All returns the features of the connections by connection index.
+func (f *FeatureSnapshots) All() map[uint8]ConnectionFeatures {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	conns := make(map[uint8]ConnectionFeatures, len(f.conns))
+	for connIndex, connFeatures := range f.conns {
+		conns[connIndex] = connFeatures
+	}
+	return conns
+}
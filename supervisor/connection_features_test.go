@@ -0,0 +1,62 @@
+package supervisor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/features"
+)
+
+func TestFeatureSnapshots(t *testing.T) {
+	snapshot := features.FeatureSnapshot{
+		PostQuantum:     features.PostQuantumPrefer,
+		DatagramVersion: features.DatagramV3,
+		FeaturesList:    []string{features.FeatureAllowRemoteConfig, features.FeatureDatagramV3_1},
+	}
+	snapshots := NewFeatureSnapshots()
+
+	done0 := snapshots.set(0, newConnectionFeatures(connection.QUIC, snapshot, features.PostQuantumPrefer, true))
+	done1 := snapshots.set(1, newConnectionFeatures(connection.HTTP2, snapshot, features.PostQuantumPrefer, false))
+	assert.Equal(t, map[uint8]ConnectionFeatures{
+		0: {
+			Protocol:              "quic",
+			DatagramVersion:       "v3",
+			PostQuantum:           "prefer",
+			PostQuantumDowngraded: true,
+			Features:              snapshot.FeaturesList,
+		},
+		1: {
+			Protocol:    "http2",
+			PostQuantum: "prefer",
+			Features:    snapshot.FeaturesList,
+		},
+	}, snapshots.All())
+
+	// Reconnecting replaces the features of the connection
+	done1()
+	snapshot.DatagramVersion = features.DatagramV2
+	defer snapshots.set(1, newConnectionFeatures(connection.QUIC, snapshot, features.PostQuantumStrict, false))()
+	done0()
+	assert.Equal(t, map[uint8]ConnectionFeatures{
+		1: {
+			Protocol:        "quic",
+			DatagramVersion: "v2",
+			PostQuantum:     "strict",
+			Features:        snapshot.FeaturesList,
+		},
+	}, snapshots.All())
+
+	// A drained connection ending after its replacement started leaves the replacement's features
+	drained := snapshots.set(2, newConnectionFeatures(connection.HTTP2, snapshot, features.PostQuantumPrefer, false))
+	defer snapshots.set(2, newConnectionFeatures(connection.QUIC, snapshot, features.PostQuantumPrefer, false))()
+	drained()
+	assert.Equal(t, "quic", snapshots.All()[2].Protocol)
+
+	assert.Equal(t, "disabled", icmpFeature(nil))
+
+	// Connections without snapshots are fine
+	var none *FeatureSnapshots
+	none.set(0, ConnectionFeatures{})()
+}
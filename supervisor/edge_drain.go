@@ -0,0 +1,41 @@
+package supervisor
+
+import "time"
+
+const (
+	// drainReconnectWindow and maxDrainReconnects bound the connections replaced right away because the edge drained
+	// them. Beyond that, an edge that keeps draining a connection is backed off like one that keeps failing.
+	drainReconnectWindow = time.Minute
+	maxDrainReconnects   = 3
+)
+
+// drainReconnects counts the recent immediate reconnects of drained connections. It's only used by the supervisor
+// loop, so it isn't safe for concurrent use.
+type drainReconnects struct {
+	now    func() time.Time
+	recent map[int][]time.Time
+}
+
+func newDrainReconnects() *drainReconnects {
+	return &drainReconnects{
+		now:    time.Now,
+		recent: make(map[int][]time.Time),
+	}
+}
+
+// allow reports whether the drained connection may be replaced right away, and counts it if so.
+func (d *drainReconnects) allow(connIndex int) bool {
+	now := d.now()
+	recent := d.recent[connIndex][:0]
+	for _, at := range d.recent[connIndex] {
+		if now.Sub(at) < drainReconnectWindow {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) >= maxDrainReconnects {
+		d.recent[connIndex] = recent
+		return false
+	}
+	d.recent[connIndex] = append(recent, now)
+	return true
+}
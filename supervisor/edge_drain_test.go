@@ -0,0 +1,24 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainReconnects(t *testing.T) {
+	now := time.Now()
+	drains := newDrainReconnects()
+	drains.now = func() time.Time { return now }
+
+	for i := 0; i < maxDrainReconnects; i++ {
+		assert.True(t, drains.allow(0))
+	}
+	assert.False(t, drains.allow(0))
+	// The other connections have their own budget
+	assert.True(t, drains.allow(1))
+
+	now = now.Add(drainReconnectWindow)
+	assert.True(t, drains.allow(0))
+}
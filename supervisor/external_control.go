@@ -1,12 +1,46 @@
 package supervisor
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"time"
+
+	"github.com/cloudflare/cloudflared/errcodes"
 )
 
 type ReconnectSignal struct {
 	// wait this many seconds before re-establish the connection
 	Delay time.Duration
+	// Target is the index of the connection to reconnect. Signals without target reconnect one of the connections.
+	Target *uint8
+	// All reconnects all the connections at once, after Delay, instead of one of them
+	All bool
+	// Reason is logged when the connections reconnect
+	Reason string
+}
+
+// SendReconnect sends a reconnect signal to the connections reading reconnectCh, e.g. from the control API. It
+// returns ctx.Err() if ctx is done before a connection reads the signal.
+func SendReconnect(ctx context.Context, reconnectCh chan<- ReconnectSignal, signal ReconnectSignal) error {
+	select {
+	case reconnectCh <- signal:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// String describes the target of the signal.
+func (r ReconnectSignal) String() string {
+	switch {
+	case r.All:
+		return "all connections"
+	case r.Target != nil:
+		return fmt.Sprintf("connection %d", *r.Target)
+	default:
+		return "one connection"
+	}
 }
 
 // Error allows us to use ReconnectSignal as a special error to force connection abort
@@ -14,8 +48,68 @@ func (r ReconnectSignal) Error() string {
 	return "reconnect signal"
 }
 
+func (r ReconnectSignal) ErrorCode() errcodes.Code {
+	return errcodes.ReconnectRequested
+}
+
 func (r ReconnectSignal) DelayBeforeReconnect() {
 	if r.Delay > 0 {
 		time.Sleep(r.Delay)
 	}
 }
+
+// reconnectRouter delivers the reconnect signals targeting a connection to it, whichever connection read them from
+// the reconnect channel.
+type reconnectRouter struct {
+	lock  sync.Mutex
+	conns map[uint8]chan ReconnectSignal
+}
+
+func newReconnectRouter() *reconnectRouter {
+	return &reconnectRouter{conns: make(map[uint8]chan ReconnectSignal)}
+}
+
+// register returns the channel of the signals targeting a connection while it's served.
+func (r *reconnectRouter) register(connIndex uint8) <-chan ReconnectSignal {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	signals := make(chan ReconnectSignal, 1)
+	r.conns[connIndex] = signals
+	return signals
+}
+
+func (r *reconnectRouter) unregister(connIndex uint8, signals <-chan ReconnectSignal) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.conns[connIndex] == signals {
+		delete(r.conns, connIndex)
+	}
+}
+
+// deliver sends a signal to its target. Signals targeting connections that aren't served, or that already have a
+// pending signal, are dropped.
+func (r *reconnectRouter) deliver(signal ReconnectSignal) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if signals, ok := r.conns[*signal.Target]; ok {
+		r.send(signals, signal)
+	}
+}
+
+// broadcast sends a signal to the served connections but except, which read it from the reconnect channel.
+func (r *reconnectRouter) broadcast(signal ReconnectSignal, except uint8) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for connIndex, signals := range r.conns {
+		if connIndex != except {
+			r.send(signals, signal)
+		}
+	}
+}
+
+func (r *reconnectRouter) send(signals chan ReconnectSignal, signal ReconnectSignal) {
+	select {
+	case signals <- signal:
+	default:
+	}
+}
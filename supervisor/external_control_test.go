@@ -0,0 +1,64 @@
+package supervisor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconnectRouter(t *testing.T) {
+	router := newReconnectRouter()
+	conn0 := router.register(0)
+	conn1 := router.register(1)
+
+	target := uint8(1)
+	router.deliver(ReconnectSignal{Target: &target})
+	// The connection already has a pending signal
+	router.deliver(ReconnectSignal{Target: &target})
+	assert.Len(t, conn0, 0)
+	assert.Len(t, conn1, 1)
+	signal := <-conn1
+	assert.Equal(t, uint8(1), *signal.Target)
+
+	// Signals targeting connections that aren't served are dropped
+	router.unregister(1, conn1)
+	router.deliver(ReconnectSignal{Target: &target})
+	assert.Len(t, conn1, 0)
+
+	// A connection served again doesn't lose its new channel when the previous one is unregistered
+	newConn0 := router.register(0)
+	router.unregister(0, conn0)
+	target = 0
+	router.deliver(ReconnectSignal{Target: &target})
+	assert.Len(t, newConn0, 1)
+}
+
+func TestReconnectRouterBroadcast(t *testing.T) {
+	router := newReconnectRouter()
+	conn0 := router.register(0)
+	conn1 := router.register(1)
+	conn2 := router.register(2)
+
+	// The connection that read the signal from the reconnect channel doesn't get it again
+	router.broadcast(ReconnectSignal{All: true, Reason: "test"}, 1)
+	assert.Len(t, conn0, 1)
+	assert.Len(t, conn1, 0)
+	assert.Len(t, conn2, 1)
+	signal := <-conn2
+	assert.True(t, signal.All)
+	assert.Equal(t, "test", signal.Reason)
+}
+
+func TestSendReconnect(t *testing.T) {
+	reconnectCh := make(chan ReconnectSignal, 1)
+	target := uint8(3)
+	require.NoError(t, SendReconnect(context.Background(), reconnectCh, ReconnectSignal{Target: &target}))
+	assert.Equal(t, "connection 3", (<-reconnectCh).String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	reconnectCh <- ReconnectSignal{}
+	assert.ErrorIs(t, SendReconnect(ctx, reconnectCh, ReconnectSignal{All: true}), context.Canceled)
+}
@@ -0,0 +1,311 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/orchestration"
+	v3 "github.com/cloudflare/cloudflared/quic/v3"
+	"github.com/cloudflare/cloudflared/signal"
+	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
+)
+
+const (
+	// LogFieldHostedTunnel is the name of the hosted tunnel a log event is about
+	LogFieldHostedTunnel = "tunnel"
+
+	HostedTunnelConnecting = "connecting"
+	HostedTunnelConnected  = "connected"
+	HostedTunnelStopping   = "stopping"
+	HostedTunnelFailed     = "failed"
+)
+
+var (
+	ErrHostedTunnelExists   = errors.New("a tunnel with this name is already hosted")
+	ErrHostedTunnelNotFound = errors.New("no tunnel with this name is hosted")
+)
+
+// HostedTunnelBuilder builds the configuration and the orchestrator of a hosted tunnel, which live until ctx is done.
+type HostedTunnelBuilder func(ctx context.Context) (*TunnelConfig, *orchestration.Orchestrator, error)
+
+// HostedTunnelStatus is the state of a hosted tunnel.
+type HostedTunnelStatus struct {
+	Name        string    `json:"name"`
+	TunnelID    uuid.UUID `json:"tunnelId"`
+	ConnectorID uuid.UUID `json:"connectorId"`
+	State       string    `json:"state"`
+	Error       string    `json:"error,omitempty"`
+}
+
+type hostedTunnel struct {
+	status      HostedTunnelStatus
+	gracePeriod time.Duration
+	connected   *signal.Signal
+	removeC     chan struct{}
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// TunnelHost runs named tunnels next to the main tunnel of the process, each with its own credentials, ingress rules,
+// connector ID and UDP flow metrics, so that many small tunnels don't need a process each. Tunnels are added and
+// removed at runtime.
+type TunnelHost struct {
+	ctx       context.Context
+	shutdownC <-chan struct{}
+	log       *zerolog.Logger
+	// registry holds the metrics labelled by hosted tunnel, kept apart from the unlabelled metrics of the main tunnel
+	registry *prometheus.Registry
+
+	lock            sync.Mutex
+	tunnels         map[string]*hostedTunnel
+	datagramMetrics map[string]v3.Metrics
+	wg              sync.WaitGroup
+}
+
+// NewTunnelHost returns a TunnelHost whose tunnels shut down gracefully once shutdownC is closed and stop once ctx is
+// done.
+func NewTunnelHost(ctx context.Context, shutdownC <-chan struct{}, log *zerolog.Logger) *TunnelHost {
+	return &TunnelHost{
+		ctx:             ctx,
+		shutdownC:       shutdownC,
+		log:             log,
+		registry:        prometheus.NewRegistry(),
+		tunnels:         make(map[string]*hostedTunnel),
+		datagramMetrics: make(map[string]v3.Metrics),
+	}
+}
+
+// Gatherer returns the metrics of the hosted tunnels, labelled by tunnel.
+func (h *TunnelHost) Gatherer() prometheus.Gatherer {
+	return h.registry
+}
+
+// HostedConfig derives the configuration of a hosted tunnel from base, the configuration of the main tunnel. The
+// hosted tunnel shares the settings of the command line, e.g. the protocol, the HA connections and the edge TLS
+// configurations, but connects with its own credentials and connector ID. It doesn't proxy ICMP, whose raw sockets
+// are owned by the main tunnel.
+func (h *TunnelHost) HostedConfig(base *TunnelConfig, name string, namedTunnel *connection.TunnelProperties) (*TunnelConfig, error) {
+	clientConfig := *base.ClientConfig
+	connectorID, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate a connector UUID: %w", err)
+	}
+	clientConfig.ConnectorID = connectorID
+
+	log := base.Log.With().Str(LogFieldHostedTunnel, name).Logger()
+	logTransport := base.LogTransport.With().Str(LogFieldHostedTunnel, name).Logger()
+
+	tags := make([]pogs.Tag, 0, len(base.Tags))
+	for _, tag := range base.Tags {
+		if tag.Name == "ID" {
+			tag.Value = connectorID.String()
+		}
+		tags = append(tags, tag)
+	}
+
+	config := *base
+	config.ClientConfig = &clientConfig
+	config.Tags = tags
+	config.Log = &log
+	config.LogTransport = &logTransport
+	config.Observer = connection.NewObserver(&log, &logTransport)
+	config.NamedTunnel = namedTunnel
+	config.TunnelCredentials = nil
+	config.CloseConnOnce = nil
+	config.ICMPRouterServer = nil
+	config.StateFile = nil
+	config.ProtocolOverrides = nil
+	config.FeatureSnapshots = NewFeatureSnapshots()
+	config.DatagramMetrics = h.datagramMetricsOf(name)
+	if endpoint := namedTunnel.Credentials.Endpoint; endpoint != "" {
+		config.Region = endpoint
+	}
+	return &config, nil
+}
+
+// datagramMetricsOf returns the UDP flow metrics of the hosted tunnel name. They're registered once per name, since
+// a tunnel removed and added again reports to the same series.
+func (h *TunnelHost) datagramMetricsOf(name string) v3.Metrics {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if metrics, ok := h.datagramMetrics[name]; ok {
+		return metrics
+	}
+	metrics := v3.NewMetrics(prometheus.WrapRegistererWith(prometheus.Labels{LogFieldHostedTunnel: name}, h.registry))
+	h.datagramMetrics[name] = metrics
+	return metrics
+}
+
+// Add builds the tunnel name and starts connecting it to the edge.
+func (h *TunnelHost) Add(name string, build HostedTunnelBuilder) error {
+	if name == "" {
+		return errors.New("a hosted tunnel needs a name")
+	}
+	if h.hosts(name) {
+		return ErrHostedTunnelExists
+	}
+
+	ctx, cancel := context.WithCancel(h.ctx)
+	config, orchestrator, err := build(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	// Another tunnel with the same name may have been added while this one was built
+	if _, ok := h.tunnels[name]; ok {
+		cancel()
+		return ErrHostedTunnelExists
+	}
+
+	tunnel := &hostedTunnel{
+		status: HostedTunnelStatus{
+			Name:        name,
+			TunnelID:    config.NamedTunnel.Credentials.TunnelID,
+			ConnectorID: config.ClientConfig.ConnectorID,
+			State:       HostedTunnelConnecting,
+		},
+		gracePeriod: config.GracePeriod,
+		connected:   signal.New(make(chan struct{})),
+		removeC:     make(chan struct{}),
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	h.tunnels[name] = tunnel
+	hostedTunnelsConnected.WithLabelValues(name).Set(0)
+	config.Log.Info().Str("tunnelID", tunnel.status.TunnelID.String()).Msg("Starting hosted tunnel")
+
+	h.wg.Add(2)
+	go func() {
+		defer h.wg.Done()
+		select {
+		case <-tunnel.connected.Wait():
+			h.setState(tunnel, HostedTunnelConnected, nil)
+			hostedTunnelsConnected.WithLabelValues(name).Set(1)
+		case <-tunnel.done:
+		}
+	}()
+	go func() {
+		defer h.wg.Done()
+		h.run(ctx, name, tunnel, config, orchestrator)
+	}()
+	return nil
+}
+
+func (h *TunnelHost) hosts(name string) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	_, ok := h.tunnels[name]
+	return ok
+}
+
+func (h *TunnelHost) run(ctx context.Context, name string, tunnel *hostedTunnel, config *TunnelConfig, orchestrator *orchestration.Orchestrator) {
+	shutdownC := make(chan struct{})
+	go func() {
+		select {
+		case <-h.shutdownC:
+		case <-tunnel.removeC:
+		case <-tunnel.done:
+			return
+		}
+		close(shutdownC)
+	}()
+
+	reconnectCh := make(chan ReconnectSignal, config.HAConnections)
+	err := StartTunnelDaemon(ctx, config, orchestrator, tunnel.connected, reconnectCh, shutdownC)
+	close(tunnel.done)
+	tunnel.cancel()
+	hostedTunnelsConnected.WithLabelValues(name).Set(0)
+
+	select {
+	case <-tunnel.removeC:
+		h.lock.Lock()
+		delete(h.tunnels, name)
+		h.lock.Unlock()
+		hostedTunnelsConnected.DeleteLabelValues(name)
+		config.Log.Info().Msg("Hosted tunnel removed")
+		return
+	default:
+	}
+	if err == nil && ctx.Err() == nil {
+		err = errors.New("the tunnel stopped")
+	}
+	if ctx.Err() == nil {
+		config.Log.Err(err).Msg("Hosted tunnel failed")
+	}
+	h.setState(tunnel, HostedTunnelFailed, err)
+}
+
+// Remove gracefully shuts down the tunnel name, in the background. Its connections are unregistered right away and
+// the tunnel is stopped after its grace period, or once its connections are all closed.
+func (h *TunnelHost) Remove(name string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	tunnel, ok := h.tunnels[name]
+	if !ok {
+		return ErrHostedTunnelNotFound
+	}
+	select {
+	case <-tunnel.removeC:
+		// Already being removed
+		return nil
+	case <-tunnel.done:
+		// A failed tunnel is only kept to report its error
+		delete(h.tunnels, name)
+		hostedTunnelsConnected.DeleteLabelValues(name)
+		return nil
+	default:
+	}
+	close(tunnel.removeC)
+	tunnel.status.State = HostedTunnelStopping
+	go func() {
+		select {
+		case <-tunnel.done:
+		case <-time.After(tunnel.gracePeriod):
+			tunnel.cancel()
+		}
+	}()
+	return nil
+}
+
+// Tunnels returns the state of the hosted tunnels, sorted by name.
+func (h *TunnelHost) Tunnels() []HostedTunnelStatus {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	tunnels := make([]HostedTunnelStatus, 0, len(h.tunnels))
+	for _, tunnel := range h.tunnels {
+		tunnels = append(tunnels, tunnel.status)
+	}
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].Name < tunnels[j].Name
+	})
+	return tunnels
+}
+
+// Wait waits for the hosted tunnels to stop.
+func (h *TunnelHost) Wait() {
+	h.wg.Wait()
+}
+
+func (h *TunnelHost) setState(tunnel *hostedTunnel, state string, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if tunnel.status.State == HostedTunnelStopping {
+		return
+	}
+	tunnel.status.State = state
+	if err != nil {
+		tunnel.status.Error = err.Error()
+	}
+}
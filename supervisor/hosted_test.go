@@ -0,0 +1,111 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/client"
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/orchestration"
+	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
+)
+
+func newTestBaseConfig(t *testing.T) *TunnelConfig {
+	log := zerolog.Nop()
+	clientConfig, err := client.NewConfig("test", "linux_amd64", nil)
+	require.NoError(t, err)
+	return &TunnelConfig{
+		ClientConfig:  clientConfig,
+		HAConnections: 4,
+		Region:        "us",
+		Tags: []pogs.Tag{
+			{Name: "env", Value: "prod"},
+			{Name: "ID", Value: clientConfig.ConnectorID.String()},
+		},
+		Log:               &log,
+		LogTransport:      &log,
+		ProtocolOverrides: NewProtocolOverrides(),
+		NamedTunnel:       &connection.TunnelProperties{Credentials: connection.Credentials{TunnelID: uuid.New()}},
+	}
+}
+
+func TestHostedConfig(t *testing.T) {
+	log := zerolog.Nop()
+	host := NewTunnelHost(context.Background(), nil, &log)
+	base := newTestBaseConfig(t)
+
+	namedTunnel := &connection.TunnelProperties{Credentials: connection.Credentials{TunnelID: uuid.New(), Endpoint: "fed"}}
+	config, err := host.HostedConfig(base, "blog", namedTunnel)
+	require.NoError(t, err)
+	assert.Equal(t, namedTunnel, config.NamedTunnel)
+	assert.NotEqual(t, base.ClientConfig.ConnectorID, config.ClientConfig.ConnectorID)
+	assert.Equal(t, []pogs.Tag{
+		{Name: "env", Value: "prod"},
+		{Name: "ID", Value: config.ClientConfig.ConnectorID.String()},
+	}, config.Tags)
+	assert.Equal(t, base.HAConnections, config.HAConnections)
+	assert.Equal(t, "fed", config.Region)
+	assert.Nil(t, config.ProtocolOverrides)
+	assert.NotNil(t, config.Observer)
+	assert.NotNil(t, config.DatagramMetrics)
+
+	// The base configuration is left untouched
+	assert.Equal(t, base.ClientConfig.ConnectorID.String(), base.Tags[1].Value)
+	assert.Equal(t, "us", base.Region)
+
+	// Hosting a tunnel with the same name again reuses its metrics instead of registering them twice
+	again, err := host.HostedConfig(base, "blog", namedTunnel)
+	require.NoError(t, err)
+	assert.Equal(t, config.DatagramMetrics, again.DatagramMetrics)
+}
+
+func TestTunnelHost(t *testing.T) {
+	log := zerolog.Nop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	host := NewTunnelHost(ctx, nil, &log)
+	base := newTestBaseConfig(t)
+
+	buildErr := errors.New("invalid ingress rules")
+	err := host.Add("blog", func(context.Context) (*TunnelConfig, *orchestration.Orchestrator, error) {
+		return nil, nil, buildErr
+	})
+	assert.ErrorIs(t, err, buildErr)
+	assert.Empty(t, host.Tunnels())
+	assert.Error(t, host.Add("", nil))
+
+	// The edge addresses can't be resolved, so the tunnel fails right away
+	namedTunnel := &connection.TunnelProperties{Credentials: connection.Credentials{TunnelID: uuid.New()}}
+	require.NoError(t, host.Add("blog", func(context.Context) (*TunnelConfig, *orchestration.Orchestrator, error) {
+		config, err := host.HostedConfig(base, "blog", namedTunnel)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.EdgeAddrs = []string{"invalid edge address"}
+		return config, nil, nil
+	}))
+	require.Eventually(t, func() bool {
+		tunnels := host.Tunnels()
+		return len(tunnels) == 1 && tunnels[0].State == HostedTunnelFailed
+	}, time.Second, 10*time.Millisecond)
+	status := host.Tunnels()[0]
+	assert.Equal(t, "blog", status.Name)
+	assert.Equal(t, namedTunnel.Credentials.TunnelID, status.TunnelID)
+	assert.NotEmpty(t, status.Error)
+
+	// A failed tunnel keeps its name until removed
+	assert.ErrorIs(t, host.Add("blog", nil), ErrHostedTunnelExists)
+	require.NoError(t, host.Remove("blog"))
+	assert.Empty(t, host.Tunnels())
+	assert.ErrorIs(t, host.Remove("blog"), ErrHostedTunnelNotFound)
+
+	cancel()
+	host.Wait()
+}
@@ -1,6 +1,8 @@
 package supervisor
 
 import (
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/cloudflare/cloudflared/connection"
@@ -18,10 +20,164 @@ var (
 			Help:      "Number of active ha connections",
 		},
 	)
+	connectionErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "connection_errors",
+			Help:      "Number of errors that ended a connection to the edge, by error code",
+		},
+		[]string{"code"},
+	)
+	postQuantumDowngrades = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "post_quantum_downgrades",
+			Help:      "Number of connections to the edge established only after leaving out the post-quantum key agreements, by protocol",
+		},
+		[]string{"protocol"},
+	)
+	edgePathMTU = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "edge_path_mtu",
+			Help:      "MTU of the path to the edge discovered when the QUIC connection was established, by connection index",
+		},
+		[]string{"conn_index"},
+	)
+	watchdogRestarts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "watchdog_restarts",
+			Help:      "Number of times the watchdog restarted all the connections to the edge, by reason",
+		},
+		[]string{"reason"},
+	)
+	edgePrecheckReachable = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "edge_precheck_reachable_addrs",
+			Help:      "Number of edge addresses found reachable by the last edge precheck, by protocol and region",
+		},
+		[]string{"protocol", "region"},
+	)
+	hostedTunnelsConnected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "hosted_tunnel_connected",
+			Help:      "Whether a tunnel hosted next to the main tunnel of the process is connected to the edge, by tunnel name",
+		},
+		[]string{"tunnel"},
+	)
+	regionFailoverActive = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "region_failover_active",
+			Help:      "Whether the connections fell back to the global region because the configured region is unreachable",
+		},
+	)
+	regionFailovers = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "region_failovers",
+			Help:      "Number of times the connections fell back to the global region",
+		},
+	)
+	connectAttempts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "connect_attempts_total",
+			Help:      "Number of attempts to connect to the edge, by protocol, result and cause of the failures",
+		},
+		[]string{"protocol", "result", "cause"},
+	)
+	timeToRegister = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:                   connection.MetricsNamespace,
+			Subsystem:                   connection.TunnelSubsystem,
+			Name:                        "time_to_register_seconds",
+			Help:                        "Time from the start of the dial to the registration of the connections to the edge, by protocol",
+			Buckets:                     prometheus.ExponentialBuckets(0.05, 2, 12),
+			NativeHistogramBucketFactor: 1.1,
+		},
+		[]string{"protocol"},
+	)
+	registeredConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "registered_connections",
+			Help:      "Number of connections registered with the edge, by protocol and edge location",
+		},
+		[]string{"protocol", "edge_location"},
+	)
+	protocolRecoveries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "protocol_recoveries",
+			Help:      "Number of times a connection that fell back to another protocol was moved back to the preferred one",
+		},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(
 		haConnections,
+		connectionErrors,
+		postQuantumDowngrades,
+		edgePathMTU,
+		watchdogRestarts,
+		edgePrecheckReachable,
+		hostedTunnelsConnected,
+		regionFailoverActive,
+		regionFailovers,
+		protocolRecoveries,
+		connectAttempts,
+		timeToRegister,
+		registeredConnections,
 	)
 }
+
+// registeredConnsGauge keeps the registered_connections gauge up to date with the connection events.
+type registeredConnsGauge struct {
+	lock sync.Mutex
+	// labels are the label values of the registered connections
+	labels map[uint8][2]string
+}
+
+func newRegisteredConnsGauge() *registeredConnsGauge {
+	return &registeredConnsGauge{labels: make(map[uint8][2]string)}
+}
+
+func (g *registeredConnsGauge) OnTunnelEvent(event connection.Event) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	switch event.EventType {
+	case connection.Connected:
+		g.forget(event.Index)
+		labels := [2]string{event.Protocol.String(), event.Location}
+		g.labels[event.Index] = labels
+		registeredConnections.WithLabelValues(labels[0], labels[1]).Inc()
+	case connection.Disconnected, connection.Reconnecting, connection.Unregistering:
+		g.forget(event.Index)
+	}
+}
+
+// forget stops counting the connection. The caller must hold the lock.
+func (g *registeredConnsGauge) forget(connIndex uint8) {
+	labels, ok := g.labels[connIndex]
+	if !ok {
+		return
+	}
+	delete(g.labels, connIndex)
+	registeredConnections.WithLabelValues(labels[0], labels[1]).Dec()
+}
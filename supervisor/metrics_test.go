@@ -0,0 +1,39 @@
+package supervisor
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+func registeredConnsCount(t *testing.T, protocol connection.Protocol, location string) float64 {
+	var m dto.Metric
+	require.NoError(t, registeredConnections.WithLabelValues(protocol.String(), location).Write(&m))
+	return m.GetGauge().GetValue()
+}
+
+func TestRegisteredConnsGauge(t *testing.T) {
+	gauge := newRegisteredConnsGauge()
+
+	gauge.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected, Protocol: connection.QUIC, Location: "test-lis"})
+	gauge.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Connected, Protocol: connection.QUIC, Location: "test-lis"})
+	gauge.OnTunnelEvent(connection.Event{Index: 2, EventType: connection.Connected, Protocol: connection.HTTP2, Location: "test-mad"})
+	assert.Equal(t, 2.0, registeredConnsCount(t, connection.QUIC, "test-lis"))
+	assert.Equal(t, 1.0, registeredConnsCount(t, connection.HTTP2, "test-mad"))
+
+	// A connection that registers again is counted where it registered last
+	gauge.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Connected, Protocol: connection.HTTP2, Location: "test-mad"})
+	assert.Equal(t, 1.0, registeredConnsCount(t, connection.QUIC, "test-lis"))
+	assert.Equal(t, 2.0, registeredConnsCount(t, connection.HTTP2, "test-mad"))
+
+	gauge.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Disconnected})
+	gauge.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Reconnecting})
+	gauge.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Disconnected})
+	gauge.OnTunnelEvent(connection.Event{Index: 2, EventType: connection.Unregistering})
+	assert.Equal(t, 0.0, registeredConnsCount(t, connection.QUIC, "test-lis"))
+	assert.Equal(t, 0.0, registeredConnsCount(t, connection.HTTP2, "test-mad"))
+}
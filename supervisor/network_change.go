@@ -0,0 +1,86 @@
+package supervisor
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/netwatch"
+)
+
+type connPath struct {
+	local netip.Addr
+	edge  netip.AddrPort
+}
+
+// connLocalAddrs tracks the local address of the connections while they're served, to find those broken by a network
+// change.
+type connLocalAddrs struct {
+	lock  sync.Mutex
+	paths map[uint8]connPath
+	// sourceAddr is overridden in tests
+	sourceAddr func(dest netip.AddrPort) netip.Addr
+}
+
+func newConnLocalAddrs() *connLocalAddrs {
+	return &connLocalAddrs{
+		paths:      make(map[uint8]connPath),
+		sourceAddr: netwatch.SourceAddr,
+	}
+}
+
+// set records the local address of a connection to edge, returning the function forgetting it. The source address
+// the host picks for edge is recorded for the connections from an unspecified address.
+func (c *connLocalAddrs) set(connIndex uint8, local net.Addr, edge netip.AddrPort) func() {
+	if c == nil {
+		return func() {}
+	}
+	var path connPath
+	switch local := local.(type) {
+	case *net.UDPAddr:
+		path = connPath{local: local.AddrPort().Addr().Unmap(), edge: edge}
+	case *net.TCPAddr:
+		path = connPath{local: local.AddrPort().Addr().Unmap(), edge: edge}
+	default:
+		return func() {}
+	}
+	if path.local.IsUnspecified() {
+		path.local = c.sourceAddr(edge)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.paths[connIndex] = path
+	return func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if c.paths[connIndex] == path {
+			delete(c.paths, connIndex)
+		}
+	}
+}
+
+// unusable returns the connections whose local address is gone in state or, unless they're bound to it, isn't the
+// one the host sends to their edge address from anymore.
+func (c *connLocalAddrs) unusable(state netwatch.State, bound bool) []uint8 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var conns []uint8
+	for connIndex, path := range c.paths {
+		if !state.HasAddr(path.local) || (!bound && c.sourceAddr(path.edge) != path.local) {
+			conns = append(conns, connIndex)
+		}
+	}
+	return conns
+}
+
+// reconnectOnNetworkChange reconnects the connections broken by a change of the network of the host right away,
+// instead of waiting for them to time out.
+func (s *Supervisor) reconnectOnNetworkChange(state netwatch.State) {
+	for _, connIndex := range s.localAddrs.unusable(state, s.config.EdgeBindAddr != nil) {
+		s.log.Logger().Info().
+			Uint8(connection.LogFieldConnIndex, connIndex).
+			Msg("Reconnecting since the network changed")
+		s.reconnects.deliver(ReconnectSignal{Target: &connIndex, Reason: "network changed"})
+	}
+}
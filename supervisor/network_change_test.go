@@ -0,0 +1,43 @@
+package supervisor
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudflare/cloudflared/netwatch"
+)
+
+func TestConnLocalAddrsUnusable(t *testing.T) {
+	edge := netip.MustParseAddrPort("198.41.200.13:7844")
+	source := netip.MustParseAddr("192.168.1.10")
+	localAddrs := newConnLocalAddrs()
+	localAddrs.sourceAddr = func(netip.AddrPort) netip.Addr { return source }
+
+	// QUIC connections are dialed from an unspecified address, the source of their route is recorded
+	forget0 := localAddrs.set(0, &net.UDPAddr{IP: net.IPv6zero, Port: 50000}, edge)
+	localAddrs.set(1, &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 50001}, edge)
+	localAddrs.set(2, &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 50002}, edge)
+
+	wifi := netwatch.State{Addrs: map[netip.Addr]struct{}{
+		netip.MustParseAddr("192.168.1.10"): {},
+		netip.MustParseAddr("10.0.0.5"):     {},
+	}}
+	assert.ElementsMatch(t, []uint8{2}, localAddrs.unusable(wifi, false))
+	// Bound connections only break when their address goes away
+	assert.Empty(t, localAddrs.unusable(wifi, true))
+
+	// Switching networks breaks the connections from the previous address
+	source = netip.MustParseAddr("10.0.0.5")
+	ethernet := netwatch.State{Addrs: map[netip.Addr]struct{}{source: {}}}
+	assert.ElementsMatch(t, []uint8{0, 1}, localAddrs.unusable(ethernet, false))
+	assert.ElementsMatch(t, []uint8{0, 1}, localAddrs.unusable(ethernet, true))
+
+	forget0()
+	assert.ElementsMatch(t, []uint8{1}, localAddrs.unusable(ethernet, false))
+
+	var unset *connLocalAddrs
+	unset.set(0, &net.UDPAddr{}, edge)()
+}
@@ -3,7 +3,9 @@ package supervisor
 import (
 	"crypto/tls"
 	"fmt"
+	"sync"
 
+	"github.com/cloudflare/cloudflared/errcodes"
 	"github.com/cloudflare/cloudflared/features"
 )
 
@@ -58,3 +60,52 @@ func curvePreference(pqMode features.PostQuantumMode, fipsEnabled bool, currentC
 		return nil, fmt.Errorf("Unexpected post quantum mode")
 	}
 }
+
+func isPostQuantumCurve(curve tls.CurveID) bool {
+	return curve == X25519MLKEM768PQKex || curve == P256Kyber768Draft00PQKex || curve == X25519Kyber768Draft00PQKex
+}
+
+// classicalCurves removes the post-quantum key agreements from curves.
+func classicalCurves(curves []tls.CurveID, fipsEnabled bool) []tls.CurveID {
+	var result []tls.CurveID
+	for _, curve := range curves {
+		if !isPostQuantumCurve(curve) {
+			result = append(result, curve)
+		}
+	}
+	if len(result) > 0 {
+		return result
+	}
+	if fipsEnabled {
+		return []tls.CurveID{tls.CurveP256}
+	}
+	return []tls.CurveID{tls.X25519, tls.CurveP256}
+}
+
+// pqDowngrades holds the connections whose next handshake leaves out the post-quantum key agreements, because the
+// last one preferring them failed. Middleboxes are known to drop the bigger ClientHello of post-quantum handshakes.
+type pqDowngrades struct {
+	lock    sync.Mutex
+	pending map[uint8]bool
+}
+
+func (d *pqDowngrades) downgraded(connIndex uint8) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.pending[connIndex]
+}
+
+func (d *pqDowngrades) set(connIndex uint8, downgrade bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.pending == nil {
+		d.pending = make(map[uint8]bool)
+	}
+	d.pending[connIndex] = downgrade
+}
+
+// isHandshakeFailure returns whether a QUIC dial failed in a way a downgraded handshake could avoid.
+func isHandshakeFailure(err error) bool {
+	code := errcodes.Of(err)
+	return code == errcodes.EdgeDialTimeout || code == errcodes.EdgeTLSHandshake
+}
@@ -2,14 +2,17 @@ package supervisor
 
 import (
 	"crypto/tls"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"slices"
 	"testing"
 
+	"github.com/quic-go/quic-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/cloudflare/cloudflared/connection"
 	"github.com/cloudflare/cloudflared/features"
 	"github.com/cloudflare/cloudflared/fips"
 )
@@ -117,3 +120,36 @@ func TestSupportedCurvesNegotiation(t *testing.T) {
 		assert.Equal(t, curves, advertisedCurves)
 	}
 }
+
+func TestClassicalCurves(t *testing.T) {
+	assert.Equal(t, []tls.CurveID{tls.X25519}, classicalCurves([]tls.CurveID{X25519MLKEM768PQKex, tls.X25519}, false))
+	assert.Equal(t, []tls.CurveID{tls.X25519, tls.CurveP256}, classicalCurves(nonFipsPostQuantumStrictPKex, false))
+	assert.Equal(t, []tls.CurveID{tls.CurveP256}, classicalCurves(fipsPostQuantumPreferPKex, true))
+}
+
+func TestPostQuantumModeByProtocol(t *testing.T) {
+	config := &TunnelConfig{
+		PostQuantumModes: map[string]features.PostQuantumMode{"quic": features.PostQuantumStrict},
+	}
+	prefer := features.FeatureSnapshot{PostQuantum: features.PostQuantumPrefer}
+	assert.Equal(t, features.PostQuantumStrict, config.postQuantumMode(connection.QUIC, prefer))
+	assert.Equal(t, features.PostQuantumPrefer, config.postQuantumMode(connection.HTTP2, prefer))
+
+	// Strict for every protocol can't be relaxed by protocol
+	config.PostQuantumModes = map[string]features.PostQuantumMode{"quic": features.PostQuantumPrefer}
+	strict := features.FeatureSnapshot{PostQuantum: features.PostQuantumStrict}
+	assert.Equal(t, features.PostQuantumStrict, config.postQuantumMode(connection.QUIC, strict))
+}
+
+func TestPQDowngrades(t *testing.T) {
+	var downgrades pqDowngrades
+	assert.False(t, downgrades.downgraded(0))
+	downgrades.set(0, true)
+	assert.True(t, downgrades.downgraded(0))
+	assert.False(t, downgrades.downgraded(1))
+	downgrades.set(0, false)
+	assert.False(t, downgrades.downgraded(0))
+
+	assert.True(t, isHandshakeFailure(&connection.EdgeQuicDialError{Cause: &quic.IdleTimeoutError{}}))
+	assert.False(t, isHandshakeFailure(errors.New("registration failed")))
+}
@@ -0,0 +1,256 @@
+package supervisor
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery"
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+	"github.com/cloudflare/cloudflared/sockmark"
+)
+
+const (
+	precheckTimeout = 5 * time.Second
+	// precheckAddrsPerRegion is how many addresses of each region are tested
+	precheckAddrsPerRegion = 2
+)
+
+// PrecheckResult is the reachability of an edge address over a protocol.
+type PrecheckResult struct {
+	Region    int    `json:"region"`
+	Address   string `json:"address"`
+	Protocol  string `json:"protocol"`
+	Reachable bool   `json:"reachable"`
+	Latency   string `json:"latency,omitempty"`
+	// MTU of the path to the address, for QUIC when it can be discovered on the platform
+	MTU   int    `json:"mtu,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// PrecheckReport holds the results of testing the reachability of the edge.
+type PrecheckReport struct {
+	Results []PrecheckResult `json:"results"`
+}
+
+// Reachable returns how many of the tested edge addresses are reachable over protocol.
+func (r *PrecheckReport) Reachable(protocol connection.Protocol) int {
+	reachable := 0
+	for _, result := range r.Results {
+		if result.Protocol == protocol.String() && result.Reachable {
+			reachable++
+		}
+	}
+	return reachable
+}
+
+func (r *PrecheckReport) tested(protocol connection.Protocol) int {
+	tested := 0
+	for _, result := range r.Results {
+		if result.Protocol == protocol.String() {
+			tested++
+		}
+	}
+	return tested
+}
+
+// Log logs the results, explaining whether the network blocks the connections to the edge.
+func (r *PrecheckReport) Log(log *zerolog.Logger) {
+	for _, result := range r.Results {
+		event := log.Debug().
+			Int("region", result.Region).
+			Str("address", result.Address).
+			Str("protocol", result.Protocol).
+			Bool("reachable", result.Reachable)
+		if result.MTU != 0 {
+			event = event.Int("mtu", result.MTU)
+		}
+		if result.Error != "" {
+			event = event.Str("error", result.Error)
+		}
+		event.Msg("Edge precheck result")
+	}
+
+	quicReachable, http2Reachable := r.Reachable(connection.QUIC), r.Reachable(connection.HTTP2)
+	log.Info().Msgf("Edge precheck: %d/%d edge addresses reachable with QUIC over UDP/7844, %d/%d with TLS over TCP/7844",
+		quicReachable, r.tested(connection.QUIC), http2Reachable, r.tested(connection.HTTP2))
+	switch {
+	case quicReachable == 0 && http2Reachable == 0:
+		log.Error().Msg("Edge precheck: the edge is unreachable over both UDP and TCP port 7844. The network likely blocks egress to the edge; allow it in your firewall before troubleshooting the tunnel configuration")
+	case quicReachable == 0:
+		log.Warn().Msg("Edge precheck: the edge is unreachable over UDP port 7844. The network likely blocks UDP egress; connections will have to use http2")
+	case http2Reachable == 0:
+		log.Warn().Msg("Edge precheck: the edge is unreachable over TCP port 7844. The network likely blocks TCP egress to the edge; connections will only be able to use quic")
+	default:
+		log.Info().Msg("Edge precheck: the edge is reachable, connection failures are not caused by the network blocking the edge")
+	}
+}
+
+func (r *PrecheckReport) updateMetrics() {
+	reachable := make(map[[2]string]int)
+	for _, result := range r.Results {
+		key := [2]string{result.Protocol, strconv.Itoa(result.Region)}
+		if _, ok := reachable[key]; !ok {
+			reachable[key] = 0
+		}
+		if result.Reachable {
+			reachable[key]++
+		}
+	}
+	for key, count := range reachable {
+		edgePrecheckReachable.WithLabelValues(key[0], key[1]).Set(float64(count))
+	}
+}
+
+// Precheck tests QUIC handshakes over UDP/7844 and TLS handshakes over TCP/7844 against a few addresses of each
+// edge region, to tell apart the networks blocking the edge from configuration errors. The results are reported in the
+// edge_precheck_reachable_addrs gauge.
+func Precheck(ctx context.Context, config *TunnelConfig) (*PrecheckReport, error) {
+	regions, err := precheckRegions(config)
+	if err != nil {
+		return nil, err
+	}
+	report := newEdgePrechecker(config).run(ctx, regions)
+	report.updateMetrics()
+	return report, nil
+}
+
+func precheckRegions(config *TunnelConfig) ([][]*allregions.EdgeAddr, error) {
+	if len(config.EdgeAddrs) > 0 { // static edge addresses
+		addrs := allregions.ResolveAddrs(config.EdgeAddrs, config.Log)
+		if len(addrs) == 0 {
+			return nil, errors.New("failed to resolve any edge address")
+		}
+		return [][]*allregions.EdgeAddr{addrs}, nil
+	}
+	return allregions.ResolveRegionAddrs(config.Log, config.Region, config.EdgeIPVersion)
+}
+
+type edgePrechecker struct {
+	quicHandshake func(ctx context.Context, addr *allregions.EdgeAddr) error
+	tlsHandshake  func(ctx context.Context, addr *allregions.EdgeAddr) error
+	pathMTU       func(addr *allregions.EdgeAddr) (int, error)
+}
+
+func newEdgePrechecker(config *TunnelConfig) *edgePrechecker {
+	return &edgePrechecker{
+		quicHandshake: func(ctx context.Context, addr *allregions.EdgeAddr) error {
+			return precheckQUIC(ctx, config.EdgeTLSConfigs[connection.QUIC], addr, config.EdgeBindAddr, config.EdgeRouting)
+		},
+		tlsHandshake: func(ctx context.Context, addr *allregions.EdgeAddr) error {
+			var control func(string, string, syscall.RawConn) error
+			if !config.EdgeRouting.IsZero() {
+				control = sockmark.Control(config.EdgeRouting)
+			}
+			conn, err := edgediscovery.DialEdge(ctx, precheckTimeout, config.EdgeTLSConfigs[connection.HTTP2], addr.TCP, config.EdgeBindAddr, control)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		},
+		pathMTU: func(addr *allregions.EdgeAddr) (int, error) {
+			pathMTU, err := connection.ProbePathMTU(addr.UDP.AddrPort(), config.EdgeBindAddr, config.EdgeRouting)
+			return pathMTU.MTU, err
+		},
+	}
+}
+
+func (p *edgePrechecker) run(ctx context.Context, regions [][]*allregions.EdgeAddr) *PrecheckReport {
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		results []PrecheckResult
+	)
+	record := func(result PrecheckResult) {
+		lock.Lock()
+		defer lock.Unlock()
+		results = append(results, result)
+	}
+	for region, addrs := range regions {
+		if len(addrs) > precheckAddrsPerRegion {
+			addrs = addrs[:precheckAddrsPerRegion]
+		}
+		for _, addr := range addrs {
+			wg.Add(2)
+			go func(region int, addr *allregions.EdgeAddr) {
+				defer wg.Done()
+				result := p.check(ctx, region, addr.UDP.String(), connection.QUIC, func(ctx context.Context) error {
+					return p.quicHandshake(ctx, addr)
+				})
+				if mtu, err := p.pathMTU(addr); err == nil {
+					result.MTU = mtu
+				}
+				record(result)
+			}(region+1, addr)
+			go func(region int, addr *allregions.EdgeAddr) {
+				defer wg.Done()
+				record(p.check(ctx, region, addr.TCP.String(), connection.HTTP2, func(ctx context.Context) error {
+					return p.tlsHandshake(ctx, addr)
+				}))
+			}(region+1, addr)
+		}
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Region != results[j].Region {
+			return results[i].Region < results[j].Region
+		}
+		if results[i].Address != results[j].Address {
+			return results[i].Address < results[j].Address
+		}
+		return results[i].Protocol < results[j].Protocol
+	})
+	return &PrecheckReport{Results: results}
+}
+
+func (p *edgePrechecker) check(ctx context.Context, region int, address string, protocol connection.Protocol, handshake func(context.Context) error) PrecheckResult {
+	ctx, cancel := context.WithTimeout(ctx, precheckTimeout)
+	defer cancel()
+
+	result := PrecheckResult{Region: region, Address: address, Protocol: protocol.String()}
+	start := time.Now()
+	if err := handshake(ctx); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Reachable = true
+	result.Latency = time.Since(start).String()
+	return result
+}
+
+func precheckQUIC(ctx context.Context, tlsConfig *tls.Config, addr *allregions.EdgeAddr, bindAddr net.IP, routing sockmark.Options) error {
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: bindAddr})
+	if err != nil {
+		return err
+	}
+	defer udpConn.Close()
+	if !routing.IsZero() {
+		if err := sockmark.Set(udpConn, routing); err != nil {
+			return err
+		}
+	}
+
+	// Same initial packet sizes as the connections, which fit the 1280 bytes MTU of WARP
+	var initialPacketSize uint16 = 1252
+	if addr.UDP.IP.To4() != nil {
+		initialPacketSize = 1232
+	}
+	conn, err := quic.Dial(ctx, udpConn, addr.UDP, tlsConfig.Clone(), &quic.Config{
+		HandshakeIdleTimeout: precheckTimeout,
+		InitialPacketSize:    initialPacketSize,
+	})
+	if err != nil {
+		return err
+	}
+	return conn.CloseWithError(0, "")
+}
@@ -0,0 +1,63 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+)
+
+func precheckAddr(ip string) *allregions.EdgeAddr {
+	return &allregions.EdgeAddr{
+		TCP:       &net.TCPAddr{IP: net.ParseIP(ip), Port: 7844},
+		UDP:       &net.UDPAddr{IP: net.ParseIP(ip), Port: 7844},
+		IPVersion: allregions.V4,
+	}
+}
+
+func TestPrecheckRun(t *testing.T) {
+	blocked := errors.New("i/o timeout")
+	prechecker := &edgePrechecker{
+		// UDP is blocked to the second region
+		quicHandshake: func(_ context.Context, addr *allregions.EdgeAddr) error {
+			if addr.UDP.IP.Equal(net.ParseIP("198.41.200.1")) {
+				return blocked
+			}
+			return nil
+		},
+		tlsHandshake: func(context.Context, *allregions.EdgeAddr) error { return nil },
+		pathMTU:      func(*allregions.EdgeAddr) (int, error) { return 1500, nil },
+	}
+	regions := [][]*allregions.EdgeAddr{
+		{precheckAddr("198.41.192.1"), precheckAddr("198.41.192.2"), precheckAddr("198.41.192.3")},
+		{precheckAddr("198.41.200.1")},
+	}
+
+	report := prechecker.run(context.Background(), regions)
+
+	// Only precheckAddrsPerRegion addresses of each region are tested, over both protocols
+	require.Len(t, report.Results, 6)
+	assert.Equal(t, 2, report.Reachable(connection.QUIC))
+	assert.Equal(t, 3, report.Reachable(connection.HTTP2))
+	assert.Equal(t, PrecheckResult{
+		Region:   2,
+		Address:  "198.41.200.1:7844",
+		Protocol: "quic",
+		MTU:      1500,
+		Error:    "i/o timeout",
+	}, report.Results[5])
+	for _, result := range report.Results {
+		if result.Protocol == "http2" {
+			assert.Zero(t, result.MTU)
+		}
+		if result.Reachable {
+			assert.NotEmpty(t, result.Latency)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package supervisor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+// ProtocolOverrides forces the protocol of some connections regardless of the protocol selector, e.g. to compare
+// http2 and quic side by side while debugging. Overridden connections don't fall back to another protocol.
+type ProtocolOverrides struct {
+	lock      sync.RWMutex
+	protocols map[uint8]connection.Protocol
+}
+
+func NewProtocolOverrides() *ProtocolOverrides {
+	return &ProtocolOverrides{protocols: make(map[uint8]connection.Protocol)}
+}
+
+// ParseProtocolOverrides parses overrides in the form <connection index>=<protocol>, e.g. 3=http2.
+func ParseProtocolOverrides(overrides []string) (*ProtocolOverrides, error) {
+	o := NewProtocolOverrides()
+	for _, override := range overrides {
+		index, name, ok := strings.Cut(override, "=")
+		if !ok {
+			return nil, fmt.Errorf("protocol override %q must be in the form <connection index>=<protocol>", override)
+		}
+		connIndex, err := strconv.ParseUint(index, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("protocol override %q has an invalid connection index: %w", override, err)
+		}
+		protocol, err := connection.ParseProtocol(name)
+		if err != nil {
+			return nil, fmt.Errorf("protocol override %q: %w", override, err)
+		}
+		o.Set(uint8(connIndex), protocol)
+	}
+	return o, nil
+}
+
+// Get returns the protocol forced on a connection, if any.
+func (o *ProtocolOverrides) Get(connIndex uint8) (connection.Protocol, bool) {
+	if o == nil {
+		return 0, false
+	}
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	protocol, ok := o.protocols[connIndex]
+	return protocol, ok
+}
+
+// Set forces the protocol of a connection. It's used the next time the connection connects.
+func (o *ProtocolOverrides) Set(connIndex uint8, protocol connection.Protocol) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.protocols[connIndex] = protocol
+}
+
+// Clear removes the override of a connection, returning whether there was one.
+func (o *ProtocolOverrides) Clear(connIndex uint8) bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	_, ok := o.protocols[connIndex]
+	delete(o.protocols, connIndex)
+	return ok
+}
+
+// All returns the name of the protocols forced by connection index.
+func (o *ProtocolOverrides) All() map[uint8]string {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	protocols := make(map[uint8]string, len(o.protocols))
+	for connIndex, protocol := range o.protocols {
+		protocols[connIndex] = protocol.String()
+	}
+	return protocols
+}
@@ -0,0 +1,32 @@
+package supervisor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+func TestParseProtocolOverrides(t *testing.T) {
+	overrides, err := ParseProtocolOverrides([]string{"3=http2", "0=quic"})
+	require.NoError(t, err)
+	protocol, ok := overrides.Get(3)
+	require.True(t, ok)
+	assert.Equal(t, connection.HTTP2, protocol)
+	assert.Equal(t, map[uint8]string{0: "quic", 3: "http2"}, overrides.All())
+	_, ok = overrides.Get(1)
+	assert.False(t, ok)
+	assert.True(t, overrides.Clear(3))
+	assert.False(t, overrides.Clear(3))
+
+	var unset *ProtocolOverrides
+	_, ok = unset.Get(0)
+	assert.False(t, ok)
+
+	for _, invalid := range []string{"3", "256=quic", "x=quic", "1=h2mux"} {
+		_, err := ParseProtocolOverrides([]string{invalid})
+		assert.Error(t, err, invalid)
+	}
+}
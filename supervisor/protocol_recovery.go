@@ -0,0 +1,180 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+const protocolRecoveryDefaultSuccesses = 3
+
+// ProtocolRecoveryConfig configures the recovery of the connections that fell back to another protocol, e.g. to
+// http2 while UDP was blocked, once the preferred protocol is reachable again.
+type ProtocolRecoveryConfig struct {
+	// Interval is the time between the probes of the preferred protocol while connections are on the fallback one.
+	// 0 disables the recovery.
+	Interval time.Duration
+	// Successes is how many probes in a row must succeed before a connection is moved back, 3 if 0.
+	Successes int
+}
+
+func (c ProtocolRecoveryConfig) Validate() error {
+	if c.Interval < 0 || c.Successes < 0 {
+		return fmt.Errorf("the protocol recovery interval and successes can't be negative")
+	}
+	return nil
+}
+
+func (c ProtocolRecoveryConfig) successes() int {
+	if c.Successes == 0 {
+		return protocolRecoveryDefaultSuccesses
+	}
+	return c.Successes
+}
+
+// protocolRecovery probes the preferred protocol while connections are connected with the fallback one, and moves
+// them back to it one at a time once it's been reachable for a few probes in a row. Without it, the connections stay
+// on the fallback protocol until cloudflared restarts, even after a transient UDP block cleared.
+type protocolRecovery struct {
+	successes int
+	preferred func() connection.Protocol
+	// probe is overridden in tests
+	probe func(ctx context.Context, protocol connection.Protocol) bool
+
+	lock sync.Mutex
+	// protocols are the protocols of the connected connections
+	protocols map[uint8]connection.Protocol
+	// consecutive is how many probes in a row succeeded
+	consecutive int
+	// migrating is the connection moved back to the preferred protocol, until it connects
+	migrating *uint8
+	// migrate are the connections to move back to the preferred protocol when they reconnect
+	migrate map[uint8]struct{}
+}
+
+func newProtocolRecovery(config *TunnelConfig) *protocolRecovery {
+	return &protocolRecovery{
+		successes: config.ProtocolRecovery.successes(),
+		preferred: config.ProtocolSelector.Current,
+		probe: func(ctx context.Context, protocol connection.Protocol) bool {
+			regions, err := precheckRegions(config)
+			if err != nil {
+				return false
+			}
+			report := newEdgePrechecker(config).run(ctx, regions)
+			return report.Reachable(protocol) > 0
+		},
+		protocols: make(map[uint8]connection.Protocol),
+		migrate:   make(map[uint8]struct{}),
+	}
+}
+
+func (r *protocolRecovery) OnTunnelEvent(event connection.Event) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	switch event.EventType {
+	case connection.Connected:
+		r.protocols[event.Index] = event.Protocol
+		if r.migrating != nil && *r.migrating == event.Index {
+			r.migrating = nil
+			if event.Protocol != r.preferred() {
+				r.consecutive = 0
+			}
+		}
+	case connection.ProtocolFallback:
+		// The connection moved back couldn't connect with the preferred protocol
+		if r.migrating != nil && *r.migrating == event.Index && event.Protocol != r.preferred() {
+			r.migrating = nil
+			r.consecutive = 0
+		}
+	case connection.Disconnected, connection.Reconnecting, connection.Unregistering:
+		delete(r.protocols, event.Index)
+	}
+}
+
+// onFallback returns the connected connections that aren't using the preferred protocol, in order.
+func (r *protocolRecovery) onFallback(preferred connection.Protocol) []uint8 {
+	var conns []uint8
+	for connIndex, protocol := range r.protocols {
+		if protocol != preferred {
+			conns = append(conns, connIndex)
+		}
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i] < conns[j] })
+	return conns
+}
+
+// next probes the preferred protocol, returning the connection to move back to it if it's time to.
+func (r *protocolRecovery) next(ctx context.Context) (uint8, bool) {
+	preferred := r.preferred()
+	r.lock.Lock()
+	if len(r.onFallback(preferred)) == 0 {
+		r.consecutive = 0
+		r.lock.Unlock()
+		return 0, false
+	}
+	if r.migrating != nil {
+		// One connection at a time
+		r.lock.Unlock()
+		return 0, false
+	}
+	r.lock.Unlock()
+
+	reachable := r.probe(ctx, preferred)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !reachable {
+		r.consecutive = 0
+		return 0, false
+	}
+	r.consecutive++
+	conns := r.onFallback(preferred)
+	if r.consecutive < r.successes || len(conns) == 0 || r.migrating != nil {
+		return 0, false
+	}
+	connIndex := conns[0]
+	r.migrating = &connIndex
+	r.migrate[connIndex] = struct{}{}
+	return connIndex, true
+}
+
+// shouldRecover returns whether the connection must reconnect with the preferred protocol, only once.
+func (r *protocolRecovery) shouldRecover(connIndex uint8) bool {
+	if r == nil {
+		return false
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.migrate[connIndex]; !ok {
+		return false
+	}
+	delete(r.migrate, connIndex)
+	return true
+}
+
+// runProtocolRecovery moves the connections back to the preferred protocol until ctx is done.
+func (s *Supervisor) runProtocolRecovery(ctx context.Context) {
+	ticker := time.NewTicker(s.config.ProtocolRecovery.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			connIndex, ok := s.protocolRecovery.next(ctx)
+			if !ok {
+				continue
+			}
+			protocolRecoveries.Inc()
+			s.log.Logger().Info().
+				Uint8(connection.LogFieldConnIndex, connIndex).
+				Msgf("%s is reachable again, moving the connection back to it", s.protocolRecovery.preferred())
+			s.reconnects.deliver(ReconnectSignal{Target: &connIndex, Reason: "preferred protocol reachable again"})
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package supervisor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+func TestProtocolRecovery(t *testing.T) {
+	reachable := false
+	probes := 0
+	recovery := &protocolRecovery{
+		successes: 2,
+		preferred: func() connection.Protocol { return connection.QUIC },
+		probe: func(_ context.Context, protocol connection.Protocol) bool {
+			assert.Equal(t, connection.QUIC, protocol)
+			probes++
+			return reachable
+		},
+		protocols: make(map[uint8]connection.Protocol),
+		migrate:   make(map[uint8]struct{}),
+	}
+	ctx := context.Background()
+
+	// Nothing to probe while the connections use the preferred protocol
+	recovery.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected, Protocol: connection.QUIC})
+	_, ok := recovery.next(ctx)
+	assert.False(t, ok)
+	assert.Equal(t, 0, probes)
+
+	recovery.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Connected, Protocol: connection.HTTP2})
+	recovery.OnTunnelEvent(connection.Event{Index: 2, EventType: connection.Connected, Protocol: connection.HTTP2})
+	_, ok = recovery.next(ctx)
+	assert.False(t, ok)
+
+	// The probes must succeed in a row
+	reachable = true
+	_, ok = recovery.next(ctx)
+	assert.False(t, ok)
+	reachable = false
+	_, ok = recovery.next(ctx)
+	assert.False(t, ok)
+	reachable = true
+	_, ok = recovery.next(ctx)
+	assert.False(t, ok)
+	connIndex, ok := recovery.next(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, uint8(1), connIndex)
+	assert.False(t, recovery.shouldRecover(2))
+
+	// One connection at a time
+	probes = 0
+	_, ok = recovery.next(ctx)
+	assert.False(t, ok)
+	assert.Equal(t, 0, probes)
+
+	assert.True(t, recovery.shouldRecover(1))
+	assert.False(t, recovery.shouldRecover(1))
+	recovery.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Reconnecting})
+	recovery.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.ProtocolFallback, Protocol: connection.QUIC})
+	recovery.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Connected, Protocol: connection.QUIC})
+
+	// The next connection is moved back once the previous one connected
+	connIndex, ok = recovery.next(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, uint8(2), connIndex)
+
+	// Falling back again starts the probes over
+	assert.True(t, recovery.shouldRecover(2))
+	recovery.OnTunnelEvent(connection.Event{Index: 2, EventType: connection.Reconnecting})
+	recovery.OnTunnelEvent(connection.Event{Index: 2, EventType: connection.ProtocolFallback, Protocol: connection.HTTP2})
+	recovery.OnTunnelEvent(connection.Event{Index: 2, EventType: connection.Connected, Protocol: connection.HTTP2})
+	_, ok = recovery.next(ctx)
+	assert.False(t, ok)
+	connIndex, ok = recovery.next(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, uint8(2), connIndex)
+
+	var nilRecovery *protocolRecovery
+	assert.False(t, nilRecovery.shouldRecover(0))
+}
@@ -0,0 +1,135 @@
+package supervisor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery"
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+)
+
+const regionFailoverCheckInterval = 10 * time.Second
+
+// regionFailover lets the connections fall back to the global region when none of them could connect to the
+// configured region for the window, and brings them back once the configured region is reachable again.
+type regionFailover struct {
+	window time.Duration
+	// resolveGlobal and probeRegion are overridden in tests
+	resolveGlobal func() (*edgediscovery.Edge, error)
+	probeRegion   func(ctx context.Context) bool
+
+	lock      sync.Mutex
+	connected map[uint8]struct{}
+	// lastHealthy is the last time a connection was connected to the configured region
+	lastHealthy time.Time
+	// global is the edge of the global region while failed over
+	global *edgediscovery.Edge
+}
+
+func newRegionFailover(config *TunnelConfig) *regionFailover {
+	return &regionFailover{
+		window: config.RegionFailoverWindow,
+		resolveGlobal: func() (*edgediscovery.Edge, error) {
+			return edgediscovery.ResolveEdge(config.Log, "", config.EdgeIPVersion)
+		},
+		probeRegion: func(ctx context.Context) bool {
+			regions, err := allregions.ResolveRegionAddrs(config.Log, config.Region, config.EdgeIPVersion)
+			if err != nil {
+				return false
+			}
+			report := newEdgePrechecker(config).run(ctx, regions)
+			return report.Reachable(config.ProtocolSelector.Current()) > 0
+		},
+		connected:   make(map[uint8]struct{}),
+		lastHealthy: time.Now(),
+	}
+}
+
+func (f *regionFailover) OnTunnelEvent(event connection.Event) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch event.EventType {
+	case connection.Connected:
+		f.connected[event.Index] = struct{}{}
+	case connection.Disconnected, connection.Reconnecting, connection.Unregistering:
+		delete(f.connected, event.Index)
+	}
+}
+
+// edge returns the edge of the global region while failed over, nil otherwise.
+func (f *regionFailover) edge() *edgediscovery.Edge {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.global
+}
+
+// shouldFailOver returns whether the connections haven't connected to the configured region for the window.
+func (f *regionFailover) shouldFailOver(now time.Time) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.global != nil {
+		return false
+	}
+	if len(f.connected) > 0 {
+		f.lastHealthy = now
+		return false
+	}
+	return now.Sub(f.lastHealthy) >= f.window
+}
+
+func (f *regionFailover) failOver(global *edgediscovery.Edge) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.global = global
+}
+
+func (f *regionFailover) recover(now time.Time) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.global = nil
+	f.lastHealthy = now
+}
+
+// check fails over to the global region when the configured one is unreachable, or moves the connections back to the
+// configured region once it's reachable again.
+func (f *regionFailover) check(ctx context.Context, now time.Time, s *Supervisor) {
+	log := s.log.Logger()
+	if f.edge() == nil {
+		if !f.shouldFailOver(now) {
+			return
+		}
+		global, err := f.resolveGlobal()
+		if err != nil {
+			log.Err(err).Msg("Unable to resolve the global region to fail over to")
+			return
+		}
+		f.failOver(global)
+		regionFailoverActive.Set(1)
+		regionFailovers.Inc()
+		log.Warn().Str("region", s.config.Region).Msgf("No connection to region %s for %s, falling back to the global region", s.config.Region, f.window)
+		return
+	}
+	if !f.probeRegion(ctx) {
+		return
+	}
+	f.recover(now)
+	regionFailoverActive.Set(0)
+	log.Info().Str("region", s.config.Region).Msgf("Region %s is reachable again, moving the connections back to it", s.config.Region)
+	_ = SendReconnect(ctx, s.reconnectCh, ReconnectSignal{All: true, Reason: "preferred region recovered"})
+}
+
+// runRegionFailover checks the connections to the configured region until ctx is done.
+func (s *Supervisor) runRegionFailover(ctx context.Context) {
+	ticker := time.NewTicker(regionFailoverCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.regionFailover.check(ctx, now, s)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package supervisor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery"
+)
+
+func TestRegionFailover(t *testing.T) {
+	log := zerolog.Nop()
+	global, err := edgediscovery.StaticEdge(&log, []string{"127.0.0.1:7844"})
+	require.NoError(t, err)
+
+	start := time.Now()
+	reachable := false
+	failover := &regionFailover{
+		window: time.Minute,
+		resolveGlobal: func() (*edgediscovery.Edge, error) {
+			return global, nil
+		},
+		probeRegion: func(context.Context) bool {
+			return reachable
+		},
+		connected:   make(map[uint8]struct{}),
+		lastHealthy: start,
+	}
+	reconnectCh := make(chan ReconnectSignal, 1)
+	s := &Supervisor{
+		config:      &TunnelConfig{Region: "us"},
+		log:         NewConnAwareLogger(&log, nil, connection.NewObserver(&log, &log)),
+		reconnectCh: reconnectCh,
+	}
+
+	// A connected connection keeps the connections in the region
+	failover.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected})
+	failover.check(context.Background(), start.Add(2*time.Minute), s)
+	assert.Nil(t, failover.edge())
+
+	// The window starts over from the last time a connection was connected
+	failover.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Disconnected})
+	failover.check(context.Background(), start.Add(2*time.Minute+30*time.Second), s)
+	assert.Nil(t, failover.edge())
+	failover.check(context.Background(), start.Add(3*time.Minute), s)
+	assert.Equal(t, global, failover.edge())
+
+	// Stays on the global region while the configured one is unreachable
+	failover.check(context.Background(), start.Add(4*time.Minute), s)
+	assert.Equal(t, global, failover.edge())
+	assert.Len(t, reconnectCh, 0)
+
+	reachable = true
+	failover.check(context.Background(), start.Add(5*time.Minute), s)
+	assert.Nil(t, failover.edge())
+	signal := <-reconnectCh
+	assert.True(t, signal.All)
+	assert.Equal(t, "preferred region recovered", signal.Reason)
+}
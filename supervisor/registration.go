@@ -0,0 +1,180 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cloudflare/cloudflared/retry"
+	"github.com/cloudflare/cloudflared/signal"
+)
+
+// RegistrationConfig controls how the HA connections are brought up when cloudflared starts.
+type RegistrationConfig struct {
+	// Parallelism is the number of connections dialed at once. Below 2, the first connection registers before the
+	// others are started one at a time.
+	Parallelism int
+	// ReadyConnections is the number of connections that must register before cloudflared reports it's ready,
+	// 1 if unset. It's only used when connections are dialed in parallel.
+	ReadyConnections int
+	// ReadyDeadline fails the startup if no connection registers before it elapses. Unset waits indefinitely.
+	ReadyDeadline time.Duration
+}
+
+func (c RegistrationConfig) Validate() error {
+	if c.Parallelism < 0 {
+		return fmt.Errorf("parallelism %d must not be negative", c.Parallelism)
+	}
+	if c.ReadyConnections < 0 {
+		return fmt.Errorf("ready connections %d must not be negative", c.ReadyConnections)
+	}
+	if c.ReadyDeadline < 0 {
+		return fmt.Errorf("ready deadline %s must not be negative", c.ReadyDeadline)
+	}
+	return nil
+}
+
+func (c RegistrationConfig) parallel() bool {
+	return c.Parallelism > 1
+}
+
+// readyConnections returns the number of connections to register before reporting ready, out of haConnections.
+func (c RegistrationConfig) readyConnections(haConnections int) int {
+	if c.ReadyConnections <= 0 {
+		return 1
+	}
+	if c.ReadyConnections > haConnections {
+		return haConnections
+	}
+	return c.ReadyConnections
+}
+
+// ErrNoConnectionRegistered is returned when no connection registered before the ready deadline.
+type ErrNoConnectionRegistered struct {
+	Deadline time.Duration
+}
+
+func (e ErrNoConnectionRegistered) Error() string {
+	return fmt.Sprintf("no connection registered within the ready deadline of %s", e.Deadline)
+}
+
+// initializeParallel dials the HA connections with up to config.Registration.Parallelism of them at once. It notifies
+// connectedSignal once the ready connections registered, and returns once every connection was started. It returns
+// the connections that failed meanwhile, for Run to retry them, and the number of connections still active. It fails
+// if all connections failed before any registered.
+func (s *Supervisor) initializeParallel(ctx context.Context, connectedSignal *signal.Signal) ([]int, int, error) {
+	s.limitHAConnections()
+	haConnections := s.config.HAConnections
+	parallelism := s.config.Registration.Parallelism
+	ready := s.config.Registration.readyConnections(haConnections)
+	var deadlineC <-chan time.Time
+	if deadline := s.config.Registration.ReadyDeadline; deadline > 0 {
+		timer := time.NewTimer(deadline)
+		defer timer.Stop()
+		deadlineC = timer.C
+	}
+	protocol, inFallback := s.initialProtocol()
+	// The fallbacks are set before dialing, since the connections read them concurrently
+	for i := 0; i < haConnections; i++ {
+		s.tunnelsProtocolFallback[i] = &protocolFallback{
+			retry.NewBackoffWithStrategy(s.config.Retries, s.config.RetryStrategy, true),
+			protocol,
+			inFallback,
+		}
+	}
+
+	// registeredC receives the index of the connections as they register
+	registeredC := make(chan int, haConnections)
+	doneC := make(chan struct{})
+	defer close(doneC)
+
+	var (
+		failed             []int
+		lastErr            error
+		next               int
+		dialing            = map[int]bool{}
+		registered         int
+		notifiedReady      bool
+		tunnelsOutstanding int
+	)
+	notifyReady := func() {
+		if !notifiedReady {
+			notifiedReady = true
+			connectedSignal.Notify()
+		}
+	}
+	for {
+		for next < haConnections && len(dialing) < parallelism {
+			index := next
+			connSignal := s.newConnectedTunnelSignal(index)
+			go func() {
+				select {
+				case <-connSignal.Wait():
+					registeredC <- index
+				case <-doneC:
+				}
+			}()
+			go s.startInitialTunnel(ctx, index, connSignal)
+			next++
+			dialing[index] = true
+			tunnelsOutstanding++
+		}
+		// Once every connection registered or failed, no more connection can become ready
+		if next == haConnections && (notifiedReady || len(dialing) == 0) {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			for ; tunnelsOutstanding > 0; tunnelsOutstanding-- {
+				<-s.tunnelErrors
+			}
+			return nil, 0, ctx.Err()
+		case <-s.gracefulShutdownC:
+			return nil, 0, errEarlyShutdown
+		case index := <-registeredC:
+			delete(dialing, index)
+			registered++
+			if registered == ready {
+				s.log.Logger().Info().Msgf("%d of %d connections registered", registered, haConnections)
+				notifyReady()
+			}
+		case tunnelError := <-s.tunnelErrors:
+			tunnelsOutstanding--
+			delete(dialing, tunnelError.index)
+			if tunnelError.err == nil {
+				continue
+			}
+			lastErr = tunnelError.err
+			failed = append(failed, tunnelError.index)
+			s.waitForNextTunnel(tunnelError.index)
+		case <-deadlineC:
+			deadlineC = nil
+			if registered == 0 {
+				return nil, 0, ErrNoConnectionRegistered{Deadline: s.config.Registration.ReadyDeadline}
+			}
+			if !notifiedReady {
+				s.log.Logger().Warn().Msgf("Only %d of the %d connections required to be ready registered within %s",
+					registered, ready, s.config.Registration.ReadyDeadline)
+				notifyReady()
+			}
+		}
+	}
+	if registered == 0 {
+		if lastErr == nil {
+			return nil, 0, errors.New("all connections exited before registering")
+		}
+		return nil, 0, lastErr
+	}
+	if !notifiedReady {
+		// The ready connections can't all register since some failed
+		s.log.Logger().Warn().Msgf("%d of %d connections registered", registered, haConnections)
+		notifyReady()
+	}
+	if len(failed) > 0 {
+		s.log.Logger().Warn().Msgf("%d of %d connections failed to register, retrying them", len(failed), haConnections)
+	}
+	return failed, tunnelsOutstanding, nil
+}
@@ -0,0 +1,150 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery"
+	"github.com/cloudflare/cloudflared/signal"
+)
+
+// mockTunnelServer registers the connections after a delay by connection index, or fails them, and serves them
+// until the context is done.
+type mockTunnelServer struct {
+	delays map[uint8]time.Duration
+	errs   map[uint8]error
+
+	lock       sync.Mutex
+	dialing    int
+	maxDialing int
+}
+
+func (m *mockTunnelServer) Serve(ctx context.Context, connIndex uint8, _ *protocolFallback, connectedSignal *signal.Signal) error {
+	m.lock.Lock()
+	m.dialing++
+	if m.dialing > m.maxDialing {
+		m.maxDialing = m.dialing
+	}
+	m.lock.Unlock()
+	select {
+	case <-time.After(m.delays[connIndex]):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	m.lock.Lock()
+	m.dialing--
+	m.lock.Unlock()
+	if err := m.errs[connIndex]; err != nil {
+		return err
+	}
+	connectedSignal.Notify()
+	<-ctx.Done()
+	return nil
+}
+
+func newRegistrationSupervisor(t *testing.T, server TunnelServer, registration RegistrationConfig) *Supervisor {
+	log := zerolog.Nop()
+	var addrs []string
+	for i := 1; i <= 4; i++ {
+		addrs = append(addrs, fmt.Sprintf("127.0.0.%d:7844", i))
+	}
+	edgeIPs, err := edgediscovery.StaticEdge(&log, addrs)
+	require.NoError(t, err)
+	fetcher := dynamicMockFetcher{
+		protocolPercents: edgediscovery.ProtocolPercents{edgediscovery.ProtocolPercent{Protocol: "quic", Percentage: 100}},
+	}
+	selector, err := connection.NewProtocolSelector("quic", "", false, false, fetcher.fetch(), time.Minute, &log)
+	require.NoError(t, err)
+	return &Supervisor{
+		config: &TunnelConfig{
+			HAConnections:    4,
+			Registration:     registration,
+			ProtocolSelector: selector,
+		},
+		edgeIPs:                 edgeIPs,
+		edgeTunnelServer:        server,
+		tunnelErrors:            make(chan tunnelError),
+		tunnelsConnecting:       map[int]chan struct{}{},
+		tunnelsProtocolFallback: map[int]*protocolFallback{},
+		log:                     NewConnAwareLogger(&log, nil, connection.NewObserver(&log, &log)),
+	}
+}
+
+func TestInitializeParallel(t *testing.T) {
+	server := &mockTunnelServer{
+		delays: map[uint8]time.Duration{0: 50 * time.Millisecond, 1: 10 * time.Millisecond, 2: 20 * time.Millisecond, 3: 30 * time.Millisecond},
+	}
+	s := newRegistrationSupervisor(t, server, RegistrationConfig{Parallelism: 2, ReadyConnections: 2})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	connectedSignal := signal.New(make(chan struct{}))
+	failed, active, err := s.initializeParallel(ctx, connectedSignal)
+	require.NoError(t, err)
+	assert.Empty(t, failed)
+	assert.Equal(t, 4, active)
+	select {
+	case <-connectedSignal.Wait():
+	default:
+		t.Fatal("expected cloudflared to be ready")
+	}
+	assert.Equal(t, 2, server.maxDialing)
+}
+
+func TestInitializeParallelFailures(t *testing.T) {
+	connErr := errors.New("connection failed")
+	server := &mockTunnelServer{
+		errs: map[uint8]error{1: connErr, 3: connErr},
+	}
+	s := newRegistrationSupervisor(t, server, RegistrationConfig{Parallelism: 4, ReadyConnections: 3})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Cloudflared is ready with the connections that registered, and the failed ones are retried
+	connectedSignal := signal.New(make(chan struct{}))
+	failed, active, err := s.initializeParallel(ctx, connectedSignal)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{1, 3}, failed)
+	assert.Equal(t, 2, active)
+	select {
+	case <-connectedSignal.Wait():
+	default:
+		t.Fatal("expected cloudflared to be ready")
+	}
+
+	// Startup fails if all connections fail
+	server.errs = map[uint8]error{0: connErr, 1: connErr, 2: connErr, 3: connErr}
+	s = newRegistrationSupervisor(t, server, RegistrationConfig{Parallelism: 4})
+	_, _, err = s.initializeParallel(ctx, signal.New(make(chan struct{})))
+	assert.ErrorIs(t, err, connErr)
+}
+
+func TestInitializeParallelDeadline(t *testing.T) {
+	server := &mockTunnelServer{
+		delays: map[uint8]time.Duration{0: time.Hour, 1: time.Hour, 2: time.Hour, 3: time.Hour},
+	}
+	s := newRegistrationSupervisor(t, server, RegistrationConfig{Parallelism: 4, ReadyDeadline: 20 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, _, err := s.initializeParallel(ctx, signal.New(make(chan struct{})))
+	assert.Equal(t, ErrNoConnectionRegistered{Deadline: 20 * time.Millisecond}, err)
+}
+
+func TestRegistrationConfigReadyConnections(t *testing.T) {
+	assert.Equal(t, 1, RegistrationConfig{}.readyConnections(4))
+	assert.Equal(t, 3, RegistrationConfig{ReadyConnections: 3}.readyConnections(4))
+	assert.Equal(t, 4, RegistrationConfig{ReadyConnections: 8}.readyConnections(4))
+	assert.Error(t, RegistrationConfig{Parallelism: -1}.Validate())
+	assert.Error(t, RegistrationConfig{ReadyDeadline: -time.Second}.Validate())
+	assert.NoError(t, RegistrationConfig{Parallelism: 4, ReadyConnections: 2, ReadyDeadline: time.Minute}.Validate())
+}
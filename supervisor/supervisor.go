@@ -13,6 +13,7 @@ import (
 
 	"github.com/cloudflare/cloudflared/connection"
 	"github.com/cloudflare/cloudflared/edgediscovery"
+	"github.com/cloudflare/cloudflared/netwatch"
 	"github.com/cloudflare/cloudflared/orchestration"
 	v3 "github.com/cloudflare/cloudflared/quic/v3"
 	"github.com/cloudflare/cloudflared/retry"
@@ -46,6 +47,10 @@ type Supervisor struct {
 	logTransport *zerolog.Logger
 
 	reconnectCh       chan ReconnectSignal
+	reconnects        *reconnectRouter
+	localAddrs        *connLocalAddrs
+	regionFailover    *regionFailover
+	protocolRecovery  *protocolRecovery
 	gracefulShutdownC <-chan struct{}
 }
 
@@ -59,26 +64,48 @@ type tunnelError struct {
 func NewSupervisor(config *TunnelConfig, orchestrator *orchestration.Orchestrator, reconnectCh chan ReconnectSignal, gracefulShutdownC <-chan struct{}) (*Supervisor, error) {
 	isStaticEdge := len(config.EdgeAddrs) > 0
 
-	var err error
-	var edgeIPs *edgediscovery.Edge
-	if isStaticEdge { // static edge addresses
-		edgeIPs, err = edgediscovery.StaticEdge(config.Log, config.EdgeAddrs)
-	} else {
-		edgeIPs, err = edgediscovery.ResolveEdge(config.Log, config.Region, config.EdgeIPVersion)
-	}
+	edgeIPs, err := resolveEdge(config)
 	if err != nil {
 		return nil, err
 	}
 
+	if config.StateFile != nil && !isStaticEdge {
+		// Reconnect to the edge addresses the connections used before restarting
+		for i := 0; i < config.HAConnections; i++ {
+			// nolint: gosec
+			if ip := config.StateFile.EdgeAddress(uint8(i)); ip != nil {
+				edgeIPs.PreferAddr(i, ip)
+			}
+		}
+	}
+
 	tracker := tunnelstate.NewConnTracker(config.Log)
 	log := NewConnAwareLogger(config.Log, tracker, config.Observer)
 
 	edgeAddrHandler := NewIPAddrFallback(config.MaxEdgeAddrRetries)
 	edgeBindAddr := config.EdgeBindAddr
 
-	datagramMetrics := v3.NewMetrics(prometheus.DefaultRegisterer)
+	datagramMetrics := config.DatagramMetrics
+	if datagramMetrics == nil {
+		datagramMetrics = v3.NewMetrics(prometheus.DefaultRegisterer)
+	}
+	reconnects := newReconnectRouter()
+	localAddrs := newConnLocalAddrs()
+	var failover *regionFailover
+	if config.RegionFailoverWindow > 0 && config.Region != "" && !isStaticEdge {
+		failover = newRegionFailover(config)
+		config.Observer.RegisterSink(failover)
+	}
+	config.Observer.RegisterSink(newRegisteredConnsGauge())
+	var recovery *protocolRecovery
+	if config.ProtocolRecovery.Interval > 0 {
+		if _, hasFallback := config.ProtocolSelector.Fallback(); hasFallback {
+			recovery = newProtocolRecovery(config)
+			config.Observer.RegisterSink(recovery)
+		}
+	}
 
-	sessionManager := v3.NewSessionManager(datagramMetrics, config.Log, config.OriginDialerService, orchestrator.GetFlowLimiter())
+	sessionManager := v3.NewSessionManagerOfKind(config.UDPSessionManager, datagramMetrics, config.Log, config.OriginDialerService, orchestrator.GetFlowLimiter(), config.UDPSessionLimits)
 
 	edgeTunnelServer := EdgeTunnelServer{
 		config:            config,
@@ -86,10 +113,14 @@ func NewSupervisor(config *TunnelConfig, orchestrator *orchestration.Orchestrato
 		sessionManager:    sessionManager,
 		datagramMetrics:   datagramMetrics,
 		edgeAddrs:         edgeIPs,
+		regionFailover:    failover,
+		protocolRecovery:  recovery,
 		edgeAddrHandler:   edgeAddrHandler,
 		edgeBindAddr:      edgeBindAddr,
 		tracker:           tracker,
 		reconnectCh:       reconnectCh,
+		reconnects:        reconnects,
+		localAddrs:        localAddrs,
 		gracefulShutdownC: gracefulShutdownC,
 		connAwareLogger:   log,
 	}
@@ -105,10 +136,21 @@ func NewSupervisor(config *TunnelConfig, orchestrator *orchestration.Orchestrato
 		log:                     log,
 		logTransport:            config.LogTransport,
 		reconnectCh:             reconnectCh,
+		reconnects:              reconnects,
+		localAddrs:              localAddrs,
+		regionFailover:          failover,
+		protocolRecovery:        recovery,
 		gracefulShutdownC:       gracefulShutdownC,
 	}, nil
 }
 
+func resolveEdge(config *TunnelConfig) (*edgediscovery.Edge, error) {
+	if len(config.EdgeAddrs) > 0 { // static edge addresses
+		return edgediscovery.StaticEdge(config.Log, config.EdgeAddrs)
+	}
+	return edgediscovery.ResolveEdge(config.Log, config.Region, config.EdgeIPVersion)
+}
+
 func (s *Supervisor) Run(
 	ctx context.Context,
 	connectedSignal *signal.Signal,
@@ -128,18 +170,56 @@ func (s *Supervisor) Run(
 	// Setup DNS Resolver refresh
 	go s.config.OriginDNSService.StartRefreshLoop(ctx)
 
-	if err := s.initialize(ctx, connectedSignal); err != nil {
+	if s.config.NetworkChangeReconnect {
+		go func() {
+			watcher := netwatch.New(s.reconnectOnNetworkChange, s.log.Logger())
+			if err := watcher.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				s.log.Logger().Err(err).Msg("Unable to watch the network changes")
+			}
+		}()
+	}
+
+	if s.regionFailover != nil {
+		go s.runRegionFailover(ctx)
+	}
+
+	if s.protocolRecovery != nil {
+		go s.runProtocolRecovery(ctx)
+	}
+
+	if s.config.Watchdog.enabled() {
+		return s.runWithWatchdog(ctx, connectedSignal)
+	}
+	return s.runConnections(ctx, connectedSignal)
+}
+
+// runConnections establishes the connections to the edge and keeps them connected until ctx is done.
+func (s *Supervisor) runConnections(ctx context.Context, connectedSignal *signal.Signal) error {
+	var (
+		tunnelsWaiting []int
+		tunnelsActive  int
+		err            error
+	)
+	if s.config.Registration.parallel() {
+		tunnelsWaiting, tunnelsActive, err = s.initializeParallel(ctx, connectedSignal)
+	} else {
+		err = s.initialize(ctx, connectedSignal)
+		tunnelsActive = s.config.HAConnections
+	}
+	if err != nil {
 		if err == errEarlyShutdown {
 			return nil
 		}
 		return err
 	}
-	var tunnelsWaiting []int
-	tunnelsActive := s.config.HAConnections
 
 	backoff := retry.NewBackoff(s.config.Retries, tunnelRetryDuration, true)
 	var backoffTimer <-chan time.Time
+	if len(tunnelsWaiting) > 0 {
+		backoffTimer = backoff.BackoffTimer()
+	}
 
+	drains := newDrainReconnects()
 	shuttingDown := false
 	for {
 		select {
@@ -155,6 +235,16 @@ func (s *Supervisor) Run(
 		case tunnelError := <-s.tunnelErrors:
 			tunnelsActive--
 			if tunnelError.err != nil && !shuttingDown {
+				if errors.Is(tunnelError.err, connection.ErrEdgeDraining) {
+					if drains.allow(tunnelError.index) {
+						// The draining connection finishes its requests while its replacement connects
+						go s.startTunnel(ctx, tunnelError.index, s.newConnectedTunnelSignal(tunnelError.index))
+						tunnelsActive++
+						continue
+					}
+					s.log.Logger().Warn().Int(connection.LogFieldConnIndex, tunnelError.index).
+						Msgf("Edge drained the connection more than %d times in %s, backing off", maxDrainReconnects, drainReconnectWindow)
+				}
 				switch tunnelError.err.(type) {
 				case ReconnectSignal:
 					// For tunnels that closed with reconnect signal, we reconnect immediately
@@ -205,18 +295,15 @@ func (s *Supervisor) initialize(
 	ctx context.Context,
 	connectedSignal *signal.Signal,
 ) error {
-	availableAddrs := s.edgeIPs.AvailableAddrs()
-	if s.config.HAConnections > availableAddrs {
-		s.log.Logger().Info().Msgf("You requested %d HA connections but I can give you at most %d.", s.config.HAConnections, availableAddrs)
-		s.config.HAConnections = availableAddrs
-	}
+	s.limitHAConnections()
+	protocol, inFallback := s.initialProtocol()
 	s.tunnelsProtocolFallback[0] = &protocolFallback{
-		retry.NewBackoff(s.config.Retries, retry.DefaultBaseTime, true),
-		s.config.ProtocolSelector.Current(),
-		false,
+		retry.NewBackoffWithStrategy(s.config.Retries, s.config.RetryStrategy, true),
+		protocol,
+		inFallback,
 	}
 
-	go s.startFirstTunnel(ctx, connectedSignal)
+	go s.startInitialTunnel(ctx, 0, connectedSignal)
 
 	// Wait for response from first tunnel before proceeding to attempt other HA edge tunnels
 	select {
@@ -233,7 +320,7 @@ func (s *Supervisor) initialize(
 	// At least one successful connection, so start the rest
 	for i := 1; i < s.config.HAConnections; i++ {
 		s.tunnelsProtocolFallback[i] = &protocolFallback{
-			retry.NewBackoff(s.config.Retries, retry.DefaultBaseTime, true),
+			retry.NewBackoffWithStrategy(s.config.Retries, s.config.RetryStrategy, true),
 			// Set the protocol we know the first tunnel connected with.
 			s.tunnelsProtocolFallback[0].protocol,
 			false,
@@ -244,22 +331,50 @@ func (s *Supervisor) initialize(
 	return nil
 }
 
-// startTunnel starts the first tunnel connection. The resulting error will be sent on
+// limitHAConnections lowers config.HAConnections to the number of available edge addresses.
+func (s *Supervisor) limitHAConnections() {
+	availableAddrs := s.edgeIPs.AvailableAddrs()
+	if s.config.HAConnections > availableAddrs {
+		s.log.Logger().Info().Msgf("You requested %d HA connections but I can give you at most %d.", s.config.HAConnections, availableAddrs)
+		s.config.HAConnections = availableAddrs
+	}
+}
+
+// initialProtocol returns the protocol of the selector, unless the connections fell back to another protocol before
+// restarting, in which case they start with it rather than falling back again.
+func (s *Supervisor) initialProtocol() (connection.Protocol, bool) {
+	current := s.config.ProtocolSelector.Current()
+	if s.config.StateFile == nil {
+		return current, false
+	}
+	persisted, ok := s.config.StateFile.Protocol()
+	if !ok || persisted == current {
+		return current, false
+	}
+	if fallback, hasFallback := s.config.ProtocolSelector.Fallback(); !hasFallback || fallback != persisted {
+		return current, false
+	}
+	s.log.Logger().Info().Msgf("Starting with protocol %s, which the connections fell back to before restarting", persisted)
+	return persisted, true
+}
+
+// startInitialTunnel starts a tunnel connection when cloudflared starts. The resulting error will be sent on
 // s.tunnelErrors. It will send a signal via connectedSignal if registration succeed
-func (s *Supervisor) startFirstTunnel(
+func (s *Supervisor) startInitialTunnel(
 	ctx context.Context,
+	index int,
 	connectedSignal *signal.Signal,
 ) {
 	var err error
-	const firstConnIndex = 0
 	isStaticEdge := len(s.config.EdgeAddrs) > 0
 	defer func() {
-		s.tunnelErrors <- tunnelError{index: firstConnIndex, err: err}
+		s.tunnelErrors <- tunnelError{index: index, err: err}
 	}()
 
-	// If the first tunnel disconnects, keep restarting it.
+	// If the tunnel disconnects, keep restarting it.
 	for {
-		err = s.edgeTunnelServer.Serve(ctx, firstConnIndex, s.tunnelsProtocolFallback[firstConnIndex], connectedSignal)
+		// nolint: gosec
+		err = s.edgeTunnelServer.Serve(ctx, uint8(index), s.tunnelsProtocolFallback[index], connectedSignal)
 		if ctx.Err() != nil {
 			return
 		}
@@ -267,7 +382,7 @@ func (s *Supervisor) startFirstTunnel(
 			return
 		}
 		// Make sure we don't continue if there is no more fallback allowed
-		if _, retry := s.tunnelsProtocolFallback[firstConnIndex].GetMaxBackoffDuration(ctx); !retry {
+		if _, retry := s.tunnelsProtocolFallback[index].GetMaxBackoffDuration(ctx); !retry {
 			return
 		}
 		// Try again for Unauthorized errors because we hope them to be
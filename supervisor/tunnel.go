@@ -6,9 +6,10 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
-	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/getsentry/sentry-go"
@@ -19,8 +20,10 @@ import (
 
 	"github.com/cloudflare/cloudflared/client"
 	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/dscp"
 	"github.com/cloudflare/cloudflared/edgediscovery"
 	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+	"github.com/cloudflare/cloudflared/errcodes"
 	"github.com/cloudflare/cloudflared/features"
 	"github.com/cloudflare/cloudflared/fips"
 	"github.com/cloudflare/cloudflared/ingress"
@@ -29,14 +32,20 @@ import (
 	"github.com/cloudflare/cloudflared/orchestration"
 	quicpogs "github.com/cloudflare/cloudflared/quic"
 	v3 "github.com/cloudflare/cloudflared/quic/v3"
+	"github.com/cloudflare/cloudflared/recovery"
 	"github.com/cloudflare/cloudflared/retry"
 	"github.com/cloudflare/cloudflared/signal"
+	"github.com/cloudflare/cloudflared/sockmark"
+	"github.com/cloudflare/cloudflared/tlsconfig"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
 	"github.com/cloudflare/cloudflared/tunnelstate"
 )
 
 const (
 	dialTimeout = 15 * time.Second
+	// duplicateConnectionCooldown is how long the other connections avoid an edge address that still holds a
+	// connection of this tunnel, e.g. after an unclean restart, until the edge drops it.
+	duplicateConnectionCooldown = time.Minute
 )
 
 type TunnelConfig struct {
@@ -48,6 +57,7 @@ type TunnelConfig struct {
 	EdgeIPVersion      allregions.ConfigIPVersion
 	EdgeBindAddr       net.IP
 	HAConnections      int
+	Registration       RegistrationConfig
 	IsAutoupdated      bool
 	LBPool             string
 	Tags               []pogs.Tag
@@ -56,6 +66,7 @@ type TunnelConfig struct {
 	Observer           *connection.Observer
 	ReportedVersion    string
 	Retries            uint
+	RetryStrategy      retry.Strategy
 	MaxEdgeAddrRetries uint8
 	RunFromTerminal    bool
 
@@ -70,10 +81,111 @@ type TunnelConfig struct {
 
 	RPCTimeout         time.Duration
 	WriteStreamTimeout time.Duration
-
-	DisableQUICPathMTUDiscovery         bool
+	// Heartbeat configures the heartbeats sent to the edge on the control stream of each connection
+	Heartbeat connection.HeartbeatConfig
+	// ProtocolRecovery moves the connections back to the preferred protocol once it's reachable again
+	ProtocolRecovery ProtocolRecoveryConfig
+
+	DisableQUICPathMTUDiscovery bool
+	// Watchdog restarts all the connections when they're flapping, and exits when cloudflared is otherwise wedged
+	Watchdog WatchdogConfig
+	// RegionFailoverWindow is how long none of the connections can connect to Region before they fall back to the
+	// global region, until Region is reachable again. 0 disables the fallback.
+	RegionFailoverWindow time.Duration
+	// NetworkChangeReconnect reconnects the connections broken by a change of the interfaces or routes of the host
+	// right away
+	NetworkChangeReconnect              bool
+	DisableQUICUDPOffload               bool
 	QUICConnectionLevelFlowControlLimit uint64
 	QUICStreamLevelFlowControlLimit     uint64
+
+	UDPSessionLimits v3.SessionLimits
+	// UDPDemux configures the workers processing the datagrams received from the connections using datagram v3
+	UDPDemux v3.DemuxConfig
+	// UDPSessionManager is the implementation holding the UDP flows of the connections using datagram v3
+	UDPSessionManager v3.SessionManagerKind
+	// DatagramMetrics records the UDP flows of the connections using datagram v3. Defaults to metrics registered to
+	// the default registry.
+	DatagramMetrics v3.Metrics
+
+	// EdgeDSCP marks the packets of the connections to the edge
+	EdgeDSCP dscp.Config
+
+	// EdgeRouting sets the firewall mark and the interface of the sockets of the connections to the edge, so that
+	// policy routing steers them
+	EdgeRouting sockmark.Options
+
+	// EdgeTrust verifies the edge with an alternate root CA bundle and SPKI pins, applied to EdgeTLSConfigs
+	EdgeTrust *tlsconfig.EdgeTrust
+
+	// TunnelCredentials returns the latest credentials of the named tunnel when they're rotated, replacing the ones
+	// of NamedTunnel when connections register
+	TunnelCredentials func() connection.Credentials
+
+	// StateFile persists the registration state of the connections across restarts, if set
+	StateFile *tunnelstate.StateFile
+
+	// ProtocolOverrides forces the protocol of some connections, if set
+	ProtocolOverrides *ProtocolOverrides
+	// FeatureSnapshots records the features of the connections, if set
+	FeatureSnapshots *FeatureSnapshots
+
+	// PostQuantumModes overrides the post-quantum mode of the features by transport protocol, unless it's strict
+	PostQuantumModes map[string]features.PostQuantumMode
+}
+
+// postQuantumMode returns the post-quantum mode of the connections of a transport protocol.
+func (c *TunnelConfig) postQuantumMode(protocol connection.Protocol, snapshot features.FeatureSnapshot) features.PostQuantumMode {
+	if mode, ok := c.PostQuantumModes[protocol.String()]; ok && snapshot.PostQuantum != features.PostQuantumStrict {
+		return mode
+	}
+	return snapshot.PostQuantum
+}
+
+// namedTunnel returns the properties of the named tunnel, with the current credentials when they're rotated.
+func (c *TunnelConfig) namedTunnel() *connection.TunnelProperties {
+	if c.TunnelCredentials == nil || c.NamedTunnel == nil {
+		return c.NamedTunnel
+	}
+	namedTunnel := *c.NamedTunnel
+	namedTunnel.Credentials = c.TunnelCredentials()
+	return &namedTunnel
+}
+
+// quicSocketOptions returns the options of the UDP socket of a QUIC connection.
+func (c *TunnelConfig) quicSocketOptions(connIndex uint8) connection.UDPSocketOptions {
+	value, mark := c.EdgeDSCP.For(connection.QUIC.String(), connIndex)
+	return connection.UDPSocketOptions{
+		DisableOffload: c.DisableQUICUDPOffload,
+		MarkDSCP:       mark,
+		DSCP:           value,
+		Routing:        c.EdgeRouting,
+	}
+}
+
+// edgeDialControl returns the Control function of the dialer of a TCP connection, nil if the socket is left as is.
+func (c *TunnelConfig) edgeDialControl(protocol connection.Protocol, connIndex uint8) func(string, string, syscall.RawConn) error {
+	var controls []func(string, string, syscall.RawConn) error
+	if value, mark := c.EdgeDSCP.For(protocol.String(), connIndex); mark {
+		controls = append(controls, dscp.Control(value))
+	}
+	if !c.EdgeRouting.IsZero() {
+		controls = append(controls, sockmark.Control(c.EdgeRouting))
+	}
+	switch len(controls) {
+	case 0:
+		return nil
+	case 1:
+		return controls[0]
+	}
+	return func(network, address string, rawConn syscall.RawConn) error {
+		for _, control := range controls {
+			if err := control(network, address, rawConn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 }
 
 func (c *TunnelConfig) connectionOptions(originLocalAddr string, previousAttempts uint8) *client.ConnectionOptionsSnapshot {
@@ -173,10 +285,15 @@ type EdgeTunnelServer struct {
 	datagramMetrics   v3.Metrics
 	edgeAddrHandler   EdgeAddrHandler
 	edgeAddrs         *edgediscovery.Edge
+	regionFailover    *regionFailover
+	protocolRecovery  *protocolRecovery
 	edgeBindAddr      net.IP
 	reconnectCh       chan ReconnectSignal
+	reconnects        *reconnectRouter
+	localAddrs        *connLocalAddrs
 	gracefulShutdownC <-chan struct{}
 	tracker           *tunnelstate.ConnTracker
+	pqDowngrades      pqDowngrades
 
 	connAwareLogger *ConnAwareLogger
 }
@@ -199,7 +316,13 @@ func (e *EdgeTunnelServer) Serve(ctx context.Context, connIndex uint8, protocolF
 	defer connectedFuse.Fuse(false)
 
 	// Fetch IP address to associated connection index
-	addr, err := e.edgeAddrs.GetAddr(int(connIndex))
+	edgeAddrs := e.edgeAddrs
+	if e.regionFailover != nil {
+		if global := e.regionFailover.edge(); global != nil {
+			edgeAddrs = global
+		}
+	}
+	addr, err := edgeAddrs.GetAddr(int(connIndex))
 	switch err.(type) {
 	case nil: // no error
 	case edgediscovery.ErrNoAddressesLeft:
@@ -219,22 +342,48 @@ func (e *EdgeTunnelServer) Serve(ctx context.Context, connIndex uint8, protocolF
 	// to another protocol when a particular metal doesn't support new protocol
 	// Each connection can also have it's own IP version because individual connections might fallback
 	// to another IP version.
-	err, shouldFallbackProtocol := e.serveTunnel(
-		ctx,
-		connLog,
-		addr,
-		connIndex,
-		connectedFuse,
-		protocolFallback,
-		protocolFallback.protocol,
-	)
+	if e.protocolRecovery.shouldRecover(connIndex) {
+		protocolFallback.reset()
+		protocolFallback.protocol = e.config.ProtocolSelector.Current()
+		connLog.Logger().Info().Msgf("Switching back to protocol %s", protocolFallback.protocol)
+		e.config.Observer.SendProtocolFallback(connIndex, protocolFallback.protocol)
+	}
+	protocol := protocolFallback.protocol
+	overriddenProtocol, overridden := e.config.ProtocolOverrides.Get(connIndex)
+	if overridden {
+		protocol = overriddenProtocol
+		connLog.Logger().Warn().Msgf("Connecting with protocol %s, overridden for debugging", protocol)
+	}
+	// The edge addresses may be of both families, e.g. with --edge, and only those of the family of the bind address
+	// can be reached
+	var shouldFallbackProtocol bool
+	if err = edgediscovery.CheckBindAddrFamily(addr.UDP.IP, e.edgeBindAddr); err == nil {
+		err, shouldFallbackProtocol = e.serveTunnel(
+			ctx,
+			connLog,
+			addr,
+			connIndex,
+			connectedFuse,
+			protocolFallback,
+			protocol,
+		)
+	}
+
+	// The edge asked to drain the connection, its replacement is established right away
+	if errors.Is(err, connection.ErrEdgeDraining) {
+		return err
+	}
 
 	// Check if the connection error was from an IP issue with the host or
 	// establishing a connection to the edge and if so, rotate the IP address.
 	shouldRotateEdgeIP, cErr := e.edgeAddrHandler.ShouldGetNewAddress(connIndex, err)
+	if errors.As(err, &connection.DupConnRegisterTunnelError{}) {
+		// The edge would reject the other connections dialing it too
+		edgeAddrs.CoolDown(addr.UDP.IP, duplicateConnectionCooldown)
+	}
 	if shouldRotateEdgeIP {
 		// rotate IP, but forcing internal state to assign a new IP to connection index.
-		if _, err := e.edgeAddrs.GetDifferentAddr(int(connIndex), true); err != nil {
+		if _, err := edgeAddrs.GetDifferentAddr(int(connIndex), true); err != nil {
 			return err
 		}
 
@@ -253,6 +402,9 @@ func (e *EdgeTunnelServer) Serve(ctx context.Context, connIndex uint8, protocolF
 	}
 	e.config.Observer.SendReconnect(connIndex)
 	connLog.Logger().Info().Msgf("Retrying connection in up to %s", duration)
+	// A connection that keeps reconnecting falls back even if it connected, since its errors alone never trigger
+	// the fallback
+	flapping := e.config.ProtocolSelector.RecordReconnect(connIndex)
 
 	select {
 	case <-ctx.Done():
@@ -261,24 +413,30 @@ func (e *EdgeTunnelServer) Serve(ctx context.Context, connIndex uint8, protocolF
 		return nil
 	case <-protocolFallback.BackoffTimer():
 		// should we fallback protocol? If not, just return. Otherwise, set new protocol for next method call.
-		if !shouldFallbackProtocol {
+		// Connections with an overridden protocol stick to it.
+		if overridden || (!shouldFallbackProtocol && !flapping) {
 			return err
 		}
 
 		// If a single connection has connected with the current protocol, we know we know we don't have to fallback
 		// to a different protocol.
-		if e.tracker.HasConnectedWith(e.config.ProtocolSelector.Current()) {
+		if !flapping && e.tracker.HasConnectedWith(e.config.ProtocolSelector.Current()) {
 			return err
 		}
 
+		previousProtocol := protocolFallback.protocol
 		if !selectNextProtocol(
 			connLog.Logger(),
 			protocolFallback,
 			e.config.ProtocolSelector,
 			err,
+			flapping,
 		) {
 			return err
 		}
+		if protocolFallback.protocol != previousProtocol {
+			e.config.Observer.SendProtocolFallback(connIndex, protocolFallback.protocol)
+		}
 	}
 
 	return err
@@ -304,17 +462,22 @@ func (pf *protocolFallback) fallback(fallback connection.Protocol) {
 }
 
 // selectNextProtocol picks connection protocol for the next retry iteration,
-// returns true if it was able to pick the protocol, false if we are out of options and should stop retrying
+// returns true if it was able to pick the protocol, false if we are out of options and should stop retrying.
+// A flapping connection falls back as if it reached the max retries.
 func selectNextProtocol(
 	connLog *zerolog.Logger,
 	protocolBackoff *protocolFallback,
 	selector connection.ProtocolSelector,
 	cause error,
+	flapping bool,
 ) bool {
 	isQuicBroken := isQuicBroken(cause)
 	_, hasFallback := selector.Fallback()
 
-	if protocolBackoff.ReachedMaxRetries() || (hasFallback && isQuicBroken) {
+	if protocolBackoff.ReachedMaxRetries() || (hasFallback && (isQuicBroken || flapping)) {
+		if flapping {
+			connLog.Warn().Msgf("Connection is reconnecting too often with %s", protocolBackoff.protocol)
+		}
 		if isQuicBroken {
 			connLog.Warn().Msg("If this log occurs persistently, and cloudflared is unable to connect to " +
 				"Cloudflare Network with `quic` protocol, then most likely your machine/network is getting its egress " +
@@ -358,6 +521,38 @@ func isQuicBroken(cause error) bool {
 	return false
 }
 
+const (
+	// Results of the connect attempts
+	connectSuccess = "success"
+	connectFailure = "failure"
+)
+
+// connectCause classifies the error that kept an attempt from connecting with protocol, e.g. the edge dial timing
+// out with QUIC, which means that UDP is likely blocked.
+func connectCause(protocol connection.Protocol, err error) string {
+	if err == nil {
+		return "shutdown"
+	}
+	code := errcodes.Of(err)
+	if protocol == connection.QUIC && (isQuicBroken(err) || code == errcodes.EdgeDialTimeout) {
+		return "udp_blocked"
+	}
+	switch code {
+	case errcodes.EdgeDialTimeout:
+		return "dial_timeout"
+	case errcodes.EdgeDial, errcodes.EdgeQUICDial:
+		return "dial"
+	case errcodes.EdgeTLSHandshake, errcodes.PQHandshake:
+		return "handshake"
+	case errcodes.DupRegistration, errcodes.RegistrationRejected, errcodes.RegistrationRetryable:
+		return "registration"
+	case errcodes.Canceled, errcodes.ReconnectRequested, errcodes.EdgeDraining:
+		return "canceled"
+	default:
+		return "other"
+	}
+}
+
 // ServeTunnel runs a single tunnel connection, returns nil on graceful shutdown,
 // on error returns a flag indicating if error can be retried
 func (e *EdgeTunnelServer) serveTunnel(
@@ -372,17 +567,18 @@ func (e *EdgeTunnelServer) serveTunnel(
 	// Treat panics as recoverable errors
 	defer func() {
 		if r := recover(); r != nil {
-			var ok bool
-			err, ok = r.(error)
-			if !ok {
-				err = fmt.Errorf("ServeTunnel: %v", r)
-			}
-			err = errors.Wrapf(err, "stack trace: %s", string(debug.Stack()))
+			err = recovery.Error(r, recovery.SubsystemSupervisor, int(connIndex))
 			recoverable = true
 		}
 	}()
 
-	defer e.config.Observer.SendDisconnect(connIndex)
+	var code errcodes.Code
+	defer func() {
+		// A drained connection is only disconnected once it has finished its in-flight requests
+		connection.AfterDrained(err, func() {
+			e.config.Observer.SendDisconnect(connIndex, code)
+		})
+	}()
 	err, recoverable = e.serveConnection(
 		ctx,
 		connLog,
@@ -392,25 +588,35 @@ func (e *EdgeTunnelServer) serveTunnel(
 		backoff,
 		protocol,
 	)
+	if err != nil {
+		code = errcodes.Of(err)
+		connectionErrors.WithLabelValues(string(code)).Inc()
+	}
 
+	if errors.Is(err, connection.ErrEdgeDraining) {
+		return err, true
+	}
 	if err != nil {
 		switch err := err.(type) {
 		case connection.DupConnRegisterTunnelError:
-			connLog.ConnAwareLogger().Err(err).Msg("Unable to establish connection.")
+			connLog.ConnAwareLogger().Err(err).Str(errcodes.LogField, string(code)).Msg("Unable to establish connection.")
 			// don't retry this connection anymore, let supervisor pick a new address
 			return err, false
 		case connection.ServerRegisterTunnelError:
-			connLog.ConnAwareLogger().Err(err).Msg("Register tunnel error from server side")
+			connLog.ConnAwareLogger().Err(err).Str(errcodes.LogField, string(code)).Msg("Register tunnel error from server side")
 			// Don't send registration error return from server to Sentry. They are
 			// logged on server side
 			return err.Cause, !err.Permanent
 		case *connection.EdgeQuicDialError:
 			return err, false
 		case ReconnectSignal:
-			connLog.Logger().Info().
+			event := connLog.Logger().Info().
 				IPAddr(connection.LogFieldIPAddress, addr.UDP.IP).
-				Uint8(connection.LogFieldConnIndex, connIndex).
-				Msgf("Restarting connection due to reconnect signal in %s", err.Delay)
+				Uint8(connection.LogFieldConnIndex, connIndex)
+			if err.Reason != "" {
+				event = event.Str("reason", err.Reason)
+			}
+			event.Msgf("Restarting connection due to reconnect signal in %s", err.Delay)
 			err.DelayBeforeReconnect()
 			return err, true
 		default:
@@ -418,7 +624,7 @@ func (e *EdgeTunnelServer) serveTunnel(
 				connLog.Logger().Debug().Err(err).Msgf("Serve tunnel error")
 				return err, false
 			}
-			connLog.ConnAwareLogger().Err(err).Msgf("Serve tunnel error")
+			connLog.ConnAwareLogger().Err(err).Str(errcodes.LogField, string(code)).Msgf("Serve tunnel error")
 			_, permanent := err.(unrecoverableError)
 			return err, !permanent
 		}
@@ -436,13 +642,21 @@ func (e *EdgeTunnelServer) serveConnection(
 	protocol connection.Protocol,
 ) (err error, recoverable bool) {
 	connectedFuse := &connectedFuse{
-		fuse:    fuse,
-		backoff: backoff,
+		fuse:     fuse,
+		backoff:  backoff,
+		protocol: protocol,
+		start:    time.Now(),
 	}
+	defer func() {
+		// The attempts that connected are counted when they do
+		if !fuse.Value() {
+			connectAttempts.WithLabelValues(protocol.String(), connectFailure, connectCause(protocol, err)).Inc()
+		}
+	}()
 	controlStream := connection.NewControlStream(
 		e.config.Observer,
 		connectedFuse,
-		e.config.NamedTunnel,
+		e.config.namedTunnel(),
 		connIndex,
 		addr.UDP.IP,
 		nil,
@@ -450,6 +664,7 @@ func (e *EdgeTunnelServer) serveConnection(
 		e.gracefulShutdownC,
 		e.config.GracePeriod,
 		protocol,
+		e.config.Heartbeat,
 	)
 
 	switch protocol {
@@ -466,12 +681,17 @@ func (e *EdgeTunnelServer) serveConnection(
 			connIndex)
 
 	case connection.HTTP2:
-		edgeConn, err := edgediscovery.DialEdge(ctx, dialTimeout, e.config.EdgeTLSConfigs[protocol], addr.TCP, e.edgeBindAddr)
-		if err != nil {
-			connLog.ConnAwareLogger().Err(err).Msg("Unable to establish connection with Cloudflare edge")
-			return err, true
+		edgeConn, dialErr := edgediscovery.DialEdge(ctx, dialTimeout, e.config.EdgeTLSConfigs[protocol], addr.TCP, e.edgeBindAddr, e.config.edgeDialControl(protocol, connIndex))
+		if dialErr != nil {
+			connLog.ConnAwareLogger().Err(dialErr).Str(errcodes.LogField, string(errcodes.Of(dialErr))).Msg("Unable to establish connection with Cloudflare edge")
+			return dialErr, true
 		}
 
+		clearLocalAddr := e.localAddrs.set(connIndex, edgeConn.LocalAddr(), addr.TCP.AddrPort())
+		defer func() {
+			connection.AfterDrained(err, clearLocalAddr)
+		}()
+
 		// nolint: gosec
 		connOptions := e.config.connectionOptions(edgeConn.LocalAddr().String(), uint8(backoff.Retries()))
 		// nolint: zerologlint
@@ -508,11 +728,15 @@ func (e *EdgeTunnelServer) serveHTTP2(
 	connOptions *client.ConnectionOptionsSnapshot,
 	controlStreamHandler connection.ControlStreamHandler,
 	connIndex uint8,
-) error {
-	pqMode := connOptions.FeatureSnapshot.PostQuantum
+) (err error) {
+	pqMode := e.config.postQuantumMode(connection.HTTP2, connOptions.FeatureSnapshot)
 	if pqMode == features.PostQuantumStrict {
 		return unrecoverableError{errors.New("HTTP/2 transport does not support post-quantum")}
 	}
+	clearFeatures := e.config.FeatureSnapshots.set(connIndex, newConnectionFeatures(connection.HTTP2, connOptions.FeatureSnapshot, pqMode, false))
+	defer func() {
+		connection.AfterDrained(err, clearFeatures)
+	}()
 
 	connLog.Logger().Debug().Msgf("Connecting via http2")
 	h2conn := connection.NewHTTP2Connection(
@@ -531,9 +755,9 @@ func (e *EdgeTunnelServer) serveHTTP2(
 	})
 
 	errGroup.Go(func() error {
-		err := listenReconnect(serveCtx, e.reconnectCh, e.gracefulShutdownC)
+		err := e.listenReconnect(serveCtx, connIndex)
 		if err != nil {
-			// forcefully break the connection (this is only used for testing)
+			// forcefully break the connection (used by tests and the control API)
 			// errgroup will return context canceled for the h2conn.Serve
 			connLog.Logger().Debug().Msg("Forcefully breaking http2 connection")
 		}
@@ -553,22 +777,42 @@ func (e *EdgeTunnelServer) serveQUIC(
 ) (err error, recoverable bool) {
 	tlsConfig := e.config.EdgeTLSConfigs[connection.QUIC]
 
-	pqMode := connOptions.FeatureSnapshot.PostQuantum
+	pqMode := e.config.postQuantumMode(connection.QUIC, connOptions.FeatureSnapshot)
 	curvePref, err := curvePreference(pqMode, fips.IsFipsEnabled(), tlsConfig.CurvePreferences)
 	if err != nil {
 		return err, true
 	}
+	downgraded := pqMode == features.PostQuantumPrefer && e.pqDowngrades.downgraded(connIndex)
+	if downgraded {
+		// The TLS config is shared by the connections, which keep preferring post-quantum
+		tlsConfig = tlsConfig.Clone()
+		curvePref = classicalCurves(curvePref, fips.IsFipsEnabled())
+		connLogger.Logger().Info().Msg("Retrying the handshake without post-quantum key agreement, since the last one failed")
+	}
 
 	connLogger.Logger().Info().Msgf("Tunnel connection curve preferences: %v", curvePref)
 
 	tlsConfig.CurvePreferences = curvePref
 
 	// quic-go 0.44 increases the initial packet size to 1280 by default. That breaks anyone running tunnel through WARP
-	// because WARP MTU is 1280.
+	// because WARP MTU is 1280. The path MTU is probed to size the packets to it, falling back to sizes fitting WARP.
 	var initialPacketSize uint16 = 1252
 	if edgeAddr.Addr().Is4() {
 		initialPacketSize = 1232
 	}
+	var maxDatagramPayload int
+	if !e.config.DisableQUICPathMTUDiscovery {
+		pathMTU, err := connection.ProbePathMTU(edgeAddr, e.edgeBindAddr, e.config.EdgeRouting)
+		if err == nil {
+			initialPacketSize = pathMTU.PacketSize
+			maxDatagramPayload = pathMTU.MaxDatagramPayload
+			edgePathMTU.WithLabelValues(strconv.Itoa(int(connIndex))).Set(float64(pathMTU.MTU))
+			connLogger.Logger().Debug().Msgf("Path MTU to the edge is %d, sending QUIC packets of %d bytes and UDP payloads of up to %d bytes",
+				pathMTU.MTU, pathMTU.PacketSize, pathMTU.MaxDatagramPayload)
+		} else if !errors.Is(err, connection.ErrPathMTUUnsupported) {
+			connLogger.Logger().Debug().Err(err).Msg("Unable to probe the path MTU to the edge")
+		}
+	}
 
 	quicConfig := &quic.Config{
 		HandshakeIdleTimeout:       quicpogs.HandshakeIdleTimeout,
@@ -592,14 +836,32 @@ func (e *EdgeTunnelServer) serveQUIC(
 		edgeAddr,
 		e.edgeBindAddr,
 		connIndex,
+		e.config.quicSocketOptions(connIndex),
 		connLogger.Logger(),
 	)
 	if err != nil {
-		connLogger.ConnAwareLogger().Err(err).Msgf("Failed to dial a quic connection")
+		var dialErr *connection.EdgeQuicDialError
+		if errors.As(err, &dialErr) {
+			dialErr.PostQuantum = pqMode == features.PostQuantumStrict
+		}
+		connLogger.ConnAwareLogger().Err(err).Str(errcodes.LogField, string(errcodes.Of(err))).Msgf("Failed to dial a quic connection")
 
-		e.reportErrorToSentry(err, connOptions.FeatureSnapshot.PostQuantum)
+		// Downgrades are tried once: if the downgraded handshake fails too, post-quantum wasn't the issue
+		e.pqDowngrades.set(connIndex, pqMode == features.PostQuantumPrefer && !downgraded && isHandshakeFailure(err))
+		e.reportErrorToSentry(err, pqMode)
 		return err, true
 	}
+	defer e.localAddrs.set(connIndex, conn.LocalAddr(), edgeAddr)()
+	connFeatures := newConnectionFeatures(connection.QUIC, connOptions.FeatureSnapshot, pqMode, downgraded)
+	connFeatures.ICMP = icmpFeature(e.config.ICMPRouterServer)
+	defer e.config.FeatureSnapshots.set(connIndex, connFeatures)()
+	if downgraded {
+		e.pqDowngrades.set(connIndex, false)
+		postQuantumDowngrades.WithLabelValues(connection.QUIC.String()).Inc()
+		connLogger.Logger().Warn().
+			Int(management.EventTypeKey, int(management.Cloudflared)).
+			Msg("Tunnel connection established only after downgrading to classical key agreement: the handshake preferring post-quantum failed")
+	}
 
 	var datagramSessionManager connection.DatagramSessionHandler
 	if connOptions.FeatureSnapshot.DatagramVersion == features.DatagramV3 {
@@ -610,6 +872,8 @@ func (e *EdgeTunnelServer) serveQUIC(
 			e.config.ICMPRouterServer,
 			connIndex,
 			e.datagramMetrics,
+			maxDatagramPayload,
+			e.config.UDPDemux,
 			connLogger.Logger(),
 		)
 	} else {
@@ -622,6 +886,7 @@ func (e *EdgeTunnelServer) serveQUIC(
 			e.config.RPCTimeout,
 			e.config.WriteStreamTimeout,
 			e.orchestrator.GetFlowLimiter(),
+			maxDatagramPayload,
 			connLogger.Logger(),
 		)
 	}
@@ -656,9 +921,9 @@ func (e *EdgeTunnelServer) serveQUIC(
 	})
 
 	errGroup.Go(func() error {
-		err := listenReconnect(serveCtx, e.reconnectCh, e.gracefulShutdownC)
+		err := e.listenReconnect(serveCtx, connIndex)
 		if err != nil {
-			// forcefully break the connection (this is only used for testing)
+			// forcefully break the connection (used by tests and the control API)
 			// errgroup will return context canceled for the tunnelConn.Serve
 			connLogger.Logger().Debug().Msg("Forcefully breaking tunnel connection")
 		}
@@ -688,25 +953,45 @@ func (e *EdgeTunnelServer) reportErrorToSentry(err error, pqMode features.PostQu
 	}
 }
 
-func listenReconnect(ctx context.Context, reconnectCh <-chan ReconnectSignal, gracefulShutdownCh <-chan struct{}) error {
-	select {
-	case reconnect := <-reconnectCh:
-		return reconnect
-	case <-gracefulShutdownCh:
-		return nil
-	case <-ctx.Done():
-		return nil
+// listenReconnect returns the first reconnect signal for a connection. Signals targeting other connections are
+// delivered to them.
+func (e *EdgeTunnelServer) listenReconnect(ctx context.Context, connIndex uint8) error {
+	targeted := e.reconnects.register(connIndex)
+	defer e.reconnects.unregister(connIndex, targeted)
+	for {
+		select {
+		case reconnect := <-e.reconnectCh:
+			if reconnect.All {
+				e.reconnects.broadcast(reconnect, connIndex)
+				return reconnect
+			}
+			if reconnect.Target == nil || *reconnect.Target == connIndex {
+				return reconnect
+			}
+			e.reconnects.deliver(reconnect)
+		case reconnect := <-targeted:
+			return reconnect
+		case <-e.gracefulShutdownC:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
 	}
 }
 
 type connectedFuse struct {
-	fuse    *booleanFuse
-	backoff *protocolFallback
+	fuse     *booleanFuse
+	backoff  *protocolFallback
+	protocol connection.Protocol
+	// start is when the connection started dialing the edge
+	start time.Time
 }
 
 func (cf *connectedFuse) Connected() {
 	cf.fuse.Fuse(true)
 	cf.backoff.reset()
+	connectAttempts.WithLabelValues(cf.protocol.String(), connectSuccess, "").Inc()
+	timeToRegister.WithLabelValues(cf.protocol.String()).Observe(time.Since(cf.start).Seconds())
 }
 
 func (cf *connectedFuse) IsConnected() bool {
@@ -1,9 +1,14 @@
 package supervisor
 
 import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/quic-go/quic-go"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
@@ -11,6 +16,7 @@ import (
 	"github.com/cloudflare/cloudflared/connection"
 	"github.com/cloudflare/cloudflared/edgediscovery"
 	"github.com/cloudflare/cloudflared/retry"
+	"github.com/cloudflare/cloudflared/tunnelstate"
 )
 
 type dynamicMockFetcher struct {
@@ -62,14 +68,14 @@ func TestWaitForBackoffFallback(t *testing.T) {
 	// Retry #0 and #1. At retry #2, we switch protocol, so the fallback loop has one more retry than this
 	for i := 0; i < int(maxRetries-1); i++ {
 		protoFallback.BackoffTimer() // simulate retry
-		ok := selectNextProtocol(&log, protoFallback, protocolSelector, nil)
+		ok := selectNextProtocol(&log, protoFallback, protocolSelector, nil, false)
 		assert.True(t, ok)
 		assert.Equal(t, initProtocol, protoFallback.protocol)
 	}
 
 	// Retry fallback protocol
 	protoFallback.BackoffTimer() // simulate retry
-	ok := selectNextProtocol(&log, protoFallback, protocolSelector, nil)
+	ok := selectNextProtocol(&log, protoFallback, protocolSelector, nil, false)
 	assert.True(t, ok)
 	fallback, ok := protocolSelector.Fallback()
 	assert.True(t, ok)
@@ -84,18 +90,18 @@ func TestWaitForBackoffFallback(t *testing.T) {
 		protoFallback.BackoffTimer()
 	}
 	// No protocol to fallback, return error
-	ok = selectNextProtocol(&log, protoFallback, protocolSelector, nil)
+	ok = selectNextProtocol(&log, protoFallback, protocolSelector, nil, false)
 	assert.False(t, ok)
 
 	protoFallback.reset()
 	protoFallback.BackoffTimer() // simulate retry
-	ok = selectNextProtocol(&log, protoFallback, protocolSelector, nil)
+	ok = selectNextProtocol(&log, protoFallback, protocolSelector, nil, false)
 	assert.True(t, ok)
 	assert.Equal(t, initProtocol, protoFallback.protocol)
 
 	protoFallback.reset()
 	protoFallback.BackoffTimer() // simulate retry
-	ok = selectNextProtocol(&log, protoFallback, protocolSelector, &quic.IdleTimeoutError{})
+	ok = selectNextProtocol(&log, protoFallback, protocolSelector, &quic.IdleTimeoutError{}, false)
 	// Check that we get a true after the first try itself when this flag is true. This allows us to immediately
 	// switch protocols when there is a fallback.
 	assert.True(t, ok)
@@ -115,12 +121,100 @@ func TestWaitForBackoffFallback(t *testing.T) {
 	protoFallback = &protocolFallback{backoff, protocolSelector.Current(), false}
 	for i := 0; i < int(maxRetries-1); i++ {
 		protoFallback.BackoffTimer() // simulate retry
-		ok := selectNextProtocol(&log, protoFallback, protocolSelector, &quic.IdleTimeoutError{})
+		ok := selectNextProtocol(&log, protoFallback, protocolSelector, &quic.IdleTimeoutError{}, false)
 		assert.True(t, ok)
 		assert.Equal(t, connection.QUIC, protoFallback.protocol)
 	}
 	// And finally it fails as it should, with no fallback.
 	protoFallback.BackoffTimer()
-	ok = selectNextProtocol(&log, protoFallback, protocolSelector, &quic.IdleTimeoutError{})
+	ok = selectNextProtocol(&log, protoFallback, protocolSelector, &quic.IdleTimeoutError{}, false)
 	assert.False(t, ok)
 }
+
+func TestFlappingConnectionFallback(t *testing.T) {
+	backoff := retry.NewBackoff(3, 40*time.Millisecond, false)
+	backoff.Clock.After = immediateTimeAfter
+	log := zerolog.Nop()
+	mockFetcher := dynamicMockFetcher{
+		protocolPercents: edgediscovery.ProtocolPercents{edgediscovery.ProtocolPercent{Protocol: "quic", Percentage: 100}},
+	}
+	protocolSelector, err := connection.NewProtocolSelector("auto", "", false, false, mockFetcher.fetch(), 10*time.Second, &log)
+	assert.NoError(t, err)
+	protoFallback := &protocolFallback{backoff, protocolSelector.Current(), false}
+	assert.Equal(t, connection.QUIC, protoFallback.protocol)
+
+	// A connection that keeps reconnecting without ever exhausting its backoff eventually falls back
+	for i := 0; i < 4; i++ {
+		assert.False(t, protocolSelector.RecordReconnect(1))
+		// Other connections are tracked separately
+		assert.False(t, protocolSelector.RecordReconnect(2))
+		protoFallback.reset()
+		protoFallback.BackoffTimer()
+		assert.True(t, selectNextProtocol(&log, protoFallback, protocolSelector, nil, false))
+		assert.Equal(t, connection.QUIC, protoFallback.protocol)
+	}
+	flapping := protocolSelector.RecordReconnect(1)
+	assert.True(t, flapping)
+	protoFallback.reset()
+	protoFallback.BackoffTimer()
+	assert.True(t, selectNextProtocol(&log, protoFallback, protocolSelector, nil, flapping))
+	assert.Equal(t, connection.HTTP2, protoFallback.protocol)
+}
+
+func TestInitialProtocolFromStateFile(t *testing.T) {
+	log := zerolog.Nop()
+	mockFetcher := dynamicMockFetcher{
+		protocolPercents: edgediscovery.ProtocolPercents{edgediscovery.ProtocolPercent{Protocol: "quic", Percentage: 100}},
+	}
+	autoSelector, err := connection.NewProtocolSelector("auto", "", false, false, mockFetcher.fetch(), 10*time.Second, &log)
+	assert.NoError(t, err)
+	quicSelector, err := connection.NewProtocolSelector("quic", "", false, false, mockFetcher.fetch(), 10*time.Second, &log)
+	assert.NoError(t, err)
+	stateFile, err := tunnelstate.NewStateFile(filepath.Join(t.TempDir(), "state.json"), uuid.New(), tunnelstate.StateFileMaxAge, &log)
+	assert.NoError(t, err)
+
+	supervisor := func(selector connection.ProtocolSelector, stateFile *tunnelstate.StateFile) *Supervisor {
+		return &Supervisor{
+			config: &TunnelConfig{ProtocolSelector: selector, StateFile: stateFile},
+			log:    NewConnAwareLogger(&log, nil, connection.NewObserver(&log, &log)),
+		}
+	}
+
+	protocol, inFallback := supervisor(autoSelector, stateFile).initialProtocol()
+	assert.Equal(t, connection.QUIC, protocol)
+	assert.False(t, inFallback)
+
+	// The connections fell back to http2 before restarting
+	stateFile.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected, Protocol: connection.HTTP2})
+	protocol, inFallback = supervisor(autoSelector, stateFile).initialProtocol()
+	assert.Equal(t, connection.HTTP2, protocol)
+	assert.True(t, inFallback)
+	protocol, inFallback = supervisor(autoSelector, nil).initialProtocol()
+	assert.Equal(t, connection.QUIC, protocol)
+	assert.False(t, inFallback)
+
+	// A protocol without fallback isn't overridden
+	protocol, inFallback = supervisor(quicSelector, stateFile).initialProtocol()
+	assert.Equal(t, connection.QUIC, protocol)
+	assert.False(t, inFallback)
+}
+
+func TestConnectCause(t *testing.T) {
+	tests := []struct {
+		protocol connection.Protocol
+		err      error
+		cause    string
+	}{
+		{connection.QUIC, &connection.EdgeQuicDialError{Cause: context.DeadlineExceeded}, "udp_blocked"},
+		{connection.QUIC, &quic.IdleTimeoutError{}, "udp_blocked"},
+		{connection.QUIC, &connection.EdgeQuicDialError{Cause: errors.New("connection refused")}, "dial"},
+		{connection.HTTP2, edgediscovery.CheckBindAddrFamily(net.ParseIP("198.41.200.1"), net.ParseIP("::1")), "dial"},
+		{connection.HTTP2, connection.DupConnRegisterTunnelError{}, "registration"},
+		{connection.HTTP2, context.Canceled, "canceled"},
+		{connection.HTTP2, errors.New("boom"), "other"},
+		{connection.HTTP2, nil, "shutdown"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.cause, connectCause(test.protocol, test.err), "%s: %v", test.protocol, test.err)
+	}
+}
@@ -0,0 +1,285 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+
+	"github.com/cloudflare/cloudflared/connection"
+	v3 "github.com/cloudflare/cloudflared/quic/v3"
+	"github.com/cloudflare/cloudflared/signal"
+)
+
+const (
+	watchdogCheckInterval = 10 * time.Second
+	// A connection staying connected this long isn't flapping
+	watchdogStableConnection = time.Minute
+	// The goroutines must exceed the limit on this many checks in a row to be considered leaking
+	watchdogLeakChecks = 3
+	// The restarts are budgeted over this window
+	watchdogRestartWindow = time.Hour
+)
+
+// Reasons of the watchdog restarts.
+const (
+	watchdogFlapping        = "flapping"
+	watchdogConfigStuck     = "config_apply_stuck"
+	watchdogGoroutineLeak   = "goroutine_leak"
+	watchdogDefaultRestarts = 3
+)
+
+// ErrWatchdogRestartBudget is returned when the connections are still wedged after the watchdog restarted them as many
+// times as allowed, so that cloudflared exits and its process manager restarts it.
+var ErrWatchdogRestartBudget = errors.New("watchdog restarted the connections too many times, exiting")
+
+// ErrWatchdogWedged is returned when cloudflared is wedged in a way restarting the connections doesn't fix, e.g. a
+// configuration update holding the orchestrator or goroutines leaking outside of the connections, so that cloudflared
+// exits and its process manager restarts it.
+var ErrWatchdogWedged = errors.New("watchdog found cloudflared wedged, exiting")
+
+// WatchdogConfig configures the watchdog restarting all the connections to the edge when they're flapping, and exiting
+// when cloudflared is otherwise wedged. The zero value of a threshold disables its check.
+type WatchdogConfig struct {
+	// FlappingPeriod is how long the connections can keep reconnecting without any of them staying connected.
+	FlappingPeriod time.Duration
+	// ConfigApplyTimeout is how long applying a configuration pushed by the edge can take.
+	ConfigApplyTimeout time.Duration
+	// MaxGoroutines is how many goroutines cloudflared can run before they're considered leaking.
+	MaxGoroutines int
+	// MaxRestarts is how many restarts are allowed per hour, 3 if 0.
+	MaxRestarts int
+}
+
+func (c WatchdogConfig) enabled() bool {
+	return c.FlappingPeriod > 0 || c.ConfigApplyTimeout > 0 || c.MaxGoroutines > 0
+}
+
+func (c WatchdogConfig) Validate() error {
+	if c.FlappingPeriod < 0 || c.ConfigApplyTimeout < 0 || c.MaxGoroutines < 0 || c.MaxRestarts < 0 {
+		return fmt.Errorf("the watchdog thresholds can't be negative")
+	}
+	if c.FlappingPeriod > 0 && c.FlappingPeriod < watchdogStableConnection {
+		return fmt.Errorf("the watchdog flapping period must be at least %s", watchdogStableConnection)
+	}
+	return nil
+}
+
+// restartFixes reports whether restarting the connections gets cloudflared out of the wedged state of reason. A stuck
+// configuration update keeps holding the orchestrator, and the goroutines may leak outside of the connections, so only
+// flapping connections are restarted.
+func restartFixes(reason string) bool {
+	return reason == watchdogFlapping
+}
+
+func (c WatchdogConfig) maxRestarts() int {
+	if c.MaxRestarts == 0 {
+		return watchdogDefaultRestarts
+	}
+	return c.MaxRestarts
+}
+
+// watchdog detects the pathological states cloudflared doesn't recover from on its own.
+type watchdog struct {
+	config WatchdogConfig
+	// configApplyDuration and numGoroutines are overridden in tests
+	configApplyDuration func() time.Duration
+	numGoroutines       func() int
+
+	lock sync.Mutex
+	// connectedSince is when the connections connected, by connection index
+	connectedSince map[uint8]time.Time
+	lastConnected  time.Time
+	lastStable     time.Time
+	leakChecks     int
+}
+
+func newWatchdog(config WatchdogConfig, configApplyDuration func() time.Duration) *watchdog {
+	w := &watchdog{
+		config:              config,
+		configApplyDuration: configApplyDuration,
+		numGoroutines:       runtime.NumGoroutine,
+	}
+	w.reset(time.Now())
+	return w
+}
+
+// reset forgets the state of the connections, when they're restarted.
+func (w *watchdog) reset(now time.Time) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.connectedSince = make(map[uint8]time.Time)
+	w.lastConnected = time.Time{}
+	w.lastStable = now
+	w.leakChecks = 0
+}
+
+func (w *watchdog) OnTunnelEvent(event connection.Event) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	switch event.EventType {
+	case connection.Connected:
+		now := time.Now()
+		w.connectedSince[event.Index] = now
+		w.lastConnected = now
+	case connection.Disconnected, connection.Reconnecting, connection.Unregistering:
+		delete(w.connectedSince, event.Index)
+	}
+}
+
+// check returns the reason to restart the connections, if they're wedged.
+func (w *watchdog) check(now time.Time) string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.config.FlappingPeriod > 0 {
+		for _, since := range w.connectedSince {
+			if now.Sub(since) >= watchdogStableConnection {
+				w.lastStable = now
+				break
+			}
+		}
+		// Connections that don't connect at all are an outage a restart doesn't fix
+		if now.Sub(w.lastStable) >= w.config.FlappingPeriod && w.lastConnected.After(w.lastStable) {
+			return watchdogFlapping
+		}
+	}
+	if w.config.ConfigApplyTimeout > 0 && w.configApplyDuration() >= w.config.ConfigApplyTimeout {
+		return watchdogConfigStuck
+	}
+	if w.config.MaxGoroutines > 0 {
+		if w.numGoroutines() > w.config.MaxGoroutines {
+			w.leakChecks++
+		} else {
+			w.leakChecks = 0
+		}
+		if w.leakChecks >= watchdogLeakChecks {
+			return watchdogGoroutineLeak
+		}
+	}
+	return ""
+}
+
+// watch returns the reason to restart the connections once they're wedged, or an empty reason once ctx is done.
+func (w *watchdog) watch(ctx context.Context) string {
+	ticker := time.NewTicker(watchdogCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ""
+		case now := <-ticker.C:
+			if reason := w.check(now); reason != "" {
+				return reason
+			}
+		}
+	}
+}
+
+// restartBudget limits the restarts over a sliding window.
+type restartBudget struct {
+	max      int
+	restarts []time.Time
+}
+
+func (b *restartBudget) allow(now time.Time) bool {
+	recent := b.restarts[:0]
+	for _, restart := range b.restarts {
+		if now.Sub(restart) < watchdogRestartWindow {
+			recent = append(recent, restart)
+		}
+	}
+	b.restarts = recent
+	if len(b.restarts) >= b.max {
+		return false
+	}
+	b.restarts = append(b.restarts, now)
+	return true
+}
+
+// runWithWatchdog runs the connections, tearing them all down and establishing them again from scratch when the
+// watchdog finds them flapping. It returns ErrWatchdogWedged, without waiting for the connections, when the watchdog
+// finds cloudflared wedged in a way restarting them doesn't fix.
+func (s *Supervisor) runWithWatchdog(ctx context.Context, connectedSignal *signal.Signal) error {
+	w := newWatchdog(s.config.Watchdog, s.orchestrator.ConfigApplyDuration)
+	s.config.Observer.RegisterSink(w)
+	budget := &restartBudget{max: s.config.Watchdog.maxRestarts()}
+	for {
+		runCtx, cancel := context.WithCancel(ctx)
+		reasonC := make(chan string, 1)
+		go func() {
+			if reason := w.watch(runCtx); reason != "" {
+				reasonC <- reason
+			}
+		}()
+		errC := make(chan error, 1)
+		go func() {
+			errC <- s.runConnections(runCtx, connectedSignal)
+		}()
+
+		var reason string
+		select {
+		case err := <-errC:
+			cancel()
+			return err
+		case reason = <-reasonC:
+		}
+
+		log := s.log.Logger()
+		if !restartFixes(reason) {
+			cancel()
+			sentry.AddBreadcrumb(&sentry.Breadcrumb{
+				Category: "watchdog",
+				Message:  "Exiting: " + reason,
+				Level:    sentry.LevelError,
+			})
+			log.Error().Str("reason", reason).Msg("Watchdog found cloudflared wedged in a way restarting the connections doesn't fix, exiting")
+			return fmt.Errorf("%w: %s", ErrWatchdogWedged, reason)
+		}
+
+		cancel()
+		<-errC
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.gracefulShutdownC:
+			return nil
+		default:
+		}
+
+		if !budget.allow(time.Now()) {
+			log.Error().Str("reason", reason).Msgf("Connections are wedged after %d watchdog restarts in the last %s", budget.max, watchdogRestartWindow)
+			return ErrWatchdogRestartBudget
+		}
+		watchdogRestarts.WithLabelValues(reason).Inc()
+		sentry.AddBreadcrumb(&sentry.Breadcrumb{
+			Category: "watchdog",
+			Message:  "Restarted all the connections to the edge: " + reason,
+			Level:    sentry.LevelWarning,
+		})
+		log.Warn().Str("reason", reason).Msg("Watchdog restarting all the connections to the edge")
+		s.reset()
+		w.reset(time.Now())
+	}
+}
+
+// reset rebuilds the state of the connections once they've all been torn down.
+func (s *Supervisor) reset() {
+	if edgeIPs, err := resolveEdge(s.config); err == nil {
+		s.edgeIPs = edgeIPs
+	} else {
+		s.log.Logger().Warn().Err(err).Msg("Unable to resolve the edge again, reusing the known addresses")
+	}
+	s.tunnelsConnecting = map[int]chan struct{}{}
+	s.tunnelsProtocolFallback = map[int]*protocolFallback{}
+	s.nextConnectedIndex = 0
+	s.nextConnectedSignal = nil
+	if server, ok := s.edgeTunnelServer.(*EdgeTunnelServer); ok {
+		server.edgeAddrs = s.edgeIPs
+		server.edgeAddrHandler = NewIPAddrFallback(s.config.MaxEdgeAddrRetries)
+		server.sessionManager = v3.NewSessionManagerOfKind(s.config.UDPSessionManager, server.datagramMetrics, s.config.Log, s.config.OriginDialerService, s.orchestrator.GetFlowLimiter(), s.config.UDPSessionLimits)
+	}
+}
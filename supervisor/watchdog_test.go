@@ -0,0 +1,87 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+func TestWatchdogFlapping(t *testing.T) {
+	w := newWatchdog(WatchdogConfig{FlappingPeriod: 5 * time.Minute}, nil)
+	start := time.Now()
+
+	// Connections that never connect are an outage, not flapping
+	assert.Empty(t, w.check(start.Add(10*time.Minute)))
+
+	w.reset(start)
+	w.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected})
+	w.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Disconnected})
+	w.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Connected})
+	w.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Reconnecting})
+	assert.Empty(t, w.check(start.Add(4*time.Minute)))
+	assert.Equal(t, watchdogFlapping, w.check(start.Add(5*time.Minute)))
+
+	// A connection staying connected means cloudflared isn't wedged
+	w.reset(start)
+	w.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected})
+	w.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Connected})
+	w.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Disconnected})
+	assert.Empty(t, w.check(start.Add(5*time.Minute)))
+	assert.Empty(t, w.check(start.Add(10*time.Minute)))
+}
+
+func TestWatchdogConfigApplyStuck(t *testing.T) {
+	applying := time.Duration(0)
+	w := newWatchdog(WatchdogConfig{ConfigApplyTimeout: time.Minute}, func() time.Duration { return applying })
+	assert.Empty(t, w.check(time.Now()))
+	applying = 30 * time.Second
+	assert.Empty(t, w.check(time.Now()))
+	applying = time.Minute
+	assert.Equal(t, watchdogConfigStuck, w.check(time.Now()))
+}
+
+func TestWatchdogGoroutineLeak(t *testing.T) {
+	goroutines := 2000
+	w := newWatchdog(WatchdogConfig{MaxGoroutines: 1000}, nil)
+	w.numGoroutines = func() int { return goroutines }
+
+	assert.Empty(t, w.check(time.Now()))
+	// A burst of goroutines isn't a leak
+	goroutines = 500
+	assert.Empty(t, w.check(time.Now()))
+	goroutines = 2000
+	for i := 1; i < watchdogLeakChecks; i++ {
+		assert.Empty(t, w.check(time.Now()))
+	}
+	assert.Equal(t, watchdogGoroutineLeak, w.check(time.Now()))
+}
+
+func TestRestartBudget(t *testing.T) {
+	budget := &restartBudget{max: 2}
+	start := time.Now()
+	assert.True(t, budget.allow(start))
+	assert.True(t, budget.allow(start.Add(10*time.Minute)))
+	assert.False(t, budget.allow(start.Add(20*time.Minute)))
+	// The restarts older than the window don't count
+	assert.True(t, budget.allow(start.Add(61*time.Minute)))
+	assert.False(t, budget.allow(start.Add(62*time.Minute)))
+}
+
+func TestWatchdogConfig(t *testing.T) {
+	assert.False(t, WatchdogConfig{MaxRestarts: 5}.enabled())
+	assert.True(t, WatchdogConfig{MaxGoroutines: 5000}.enabled())
+	assert.Equal(t, watchdogDefaultRestarts, WatchdogConfig{}.maxRestarts())
+	assert.Error(t, WatchdogConfig{FlappingPeriod: 30 * time.Second}.Validate())
+	assert.Error(t, WatchdogConfig{MaxRestarts: -1}.Validate())
+	assert.NoError(t, WatchdogConfig{FlappingPeriod: 10 * time.Minute, ConfigApplyTimeout: 5 * time.Minute}.Validate())
+}
+
+func TestWatchdogRestartFixes(t *testing.T) {
+	assert.True(t, restartFixes(watchdogFlapping))
+	// Restarting the connections neither releases a stuck configuration update nor the goroutines leaking elsewhere
+	assert.False(t, restartFixes(watchdogConfigStuck))
+	assert.False(t, restartFixes(watchdogGoroutineLeak))
+}
@@ -0,0 +1,192 @@
+package tlsconfig
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/watcher"
+)
+
+const (
+	EdgeCABundleFlag = "edge-ca-bundle"
+	EdgeSPKIPinsFlag = "edge-spki-pins"
+)
+
+// EdgeTrust verifies the certificates of the edge against an alternate root CA bundle, and optionally requires the
+// verified chains to contain one of a set of pinned public keys. Both are read from files, and reloaded when the
+// files change, keeping the previous ones if the new ones are invalid.
+type EdgeTrust struct {
+	bundlePath string
+	pinsPath   string
+	log        *zerolog.Logger
+
+	lock  sync.RWMutex
+	roots *x509.CertPool
+	pins  map[[sha256.Size]byte]struct{}
+}
+
+// NewEdgeTrust loads the root CA bundle at bundlePath and the SPKI pins at pinsPath. Either may be empty, in which
+// case the roots of the TLS config or no pins are used.
+func NewEdgeTrust(bundlePath, pinsPath string, log *zerolog.Logger) (*EdgeTrust, error) {
+	t := &EdgeTrust{
+		bundlePath: bundlePath,
+		pinsPath:   pinsPath,
+		log:        log,
+	}
+	if err := t.Load(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Load reads the root CA bundle and the SPKI pins from their files.
+func (t *EdgeTrust) Load() error {
+	var roots *x509.CertPool
+	if t.bundlePath != "" {
+		pem, err := os.ReadFile(t.bundlePath)
+		if err != nil {
+			return errors.Wrapf(err, "unable to read the file %s for --%s", t.bundlePath, EdgeCABundleFlag)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s for --%s contains no PEM certificate", t.bundlePath, EdgeCABundleFlag)
+		}
+	}
+	var pins map[[sha256.Size]byte]struct{}
+	if t.pinsPath != "" {
+		content, err := os.ReadFile(t.pinsPath)
+		if err != nil {
+			return errors.Wrapf(err, "unable to read the file %s for --%s", t.pinsPath, EdgeSPKIPinsFlag)
+		}
+		pins, err = ParseSPKIPins(content)
+		if err != nil {
+			return errors.Wrapf(err, "invalid --%s %s", EdgeSPKIPinsFlag, t.pinsPath)
+		}
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.roots = roots
+	t.pins = pins
+	return nil
+}
+
+// ParseSPKIPins parses one base64 encoded SHA-256 hash of a SubjectPublicKeyInfo per line, as in the pin-sha256
+// directives of RFC 7469. Blank lines and lines starting with # are ignored.
+func ParseSPKIPins(content []byte) (map[[sha256.Size]byte]struct{}, error) {
+	pins := make(map[[sha256.Size]byte]struct{})
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "sha256/"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "pin %q is not base64 encoded", line)
+		}
+		if len(hash) != sha256.Size {
+			return nil, fmt.Errorf("pin %q is not a SHA-256 hash", line)
+		}
+		pins[[sha256.Size]byte(hash)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(pins) == 0 {
+		return nil, errors.New("no pin")
+	}
+	return pins, nil
+}
+
+// Apply makes tlsConfig verify the edge with the trust. The standard verification is replaced when there is a root
+// CA bundle, so that it can be reloaded without recreating the config.
+func (t *EdgeTrust) Apply(tlsConfig *tls.Config) {
+	if t.bundlePath != "" {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	tlsConfig.VerifyConnection = t.verifyConnection
+}
+
+func (t *EdgeTrust) verifyConnection(cs tls.ConnectionState) error {
+	t.lock.RLock()
+	roots, pins := t.roots, t.pins
+	t.lock.RUnlock()
+
+	chains := cs.VerifiedChains
+	if roots != nil {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("the edge presented no certificate")
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		var err error
+		chains, err = cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+			DNSName:       cs.ServerName,
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "the edge certificate isn't signed by the CAs of --%s", EdgeCABundleFlag)
+		}
+	}
+	if pins == nil {
+		return nil
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			if _, ok := pins[sha256.Sum256(cert.RawSubjectPublicKeyInfo)]; ok {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("the edge certificate chain contains none of the public keys of --%s", EdgeSPKIPinsFlag)
+}
+
+// Watch reloads the trust when its files are written, until ctx is done.
+func (t *EdgeTrust) Watch(ctx context.Context) {
+	f, err := watcher.NewFile()
+	if err != nil {
+		t.log.Err(err).Msg("Cannot watch the edge trust files, they won't be reloaded")
+		return
+	}
+	for _, path := range []string{t.bundlePath, t.pinsPath} {
+		if path == "" {
+			continue
+		}
+		if err := f.Add(path); err != nil {
+			t.log.Err(err).Msgf("Cannot watch %s, it won't be reloaded", path)
+		}
+	}
+	go f.Start(t)
+	<-ctx.Done()
+	f.Shutdown()
+}
+
+// WatcherItemDidChange implements watcher.Notification
+func (t *EdgeTrust) WatcherItemDidChange(path string) {
+	if err := t.Load(); err != nil {
+		t.log.Err(err).Msgf("Keeping the previous edge trust since %s is invalid", path)
+		return
+	}
+	t.log.Info().Msgf("Reloaded the edge trust from %s", path)
+}
+
+// WatcherDidError implements watcher.Notification
+func (t *EdgeTrust) WatcherDidError(err error) {
+	t.log.Err(err).Msg("Error watching the edge trust files")
+}
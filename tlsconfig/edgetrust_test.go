@@ -0,0 +1,176 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testEdgeServerName = "edge.test"
+
+type testEdgeCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+	pin  string
+}
+
+func newTestEdgeCA(t *testing.T) *testEdgeCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test edge CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pin := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return &testEdgeCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pin:  base64.StdEncoding.EncodeToString(pin[:]),
+	}
+}
+
+func (ca *testEdgeCA) serverCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		DNSNames:     []string{testEdgeServerName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func handshake(t *testing.T, clientConfig *tls.Config, serverCert tls.Certificate) error {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.(*tls.Conn).Handshake()
+	}()
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func writeFile(t *testing.T, path string, content []byte) {
+	require.NoError(t, os.WriteFile(path, content, 0o600))
+}
+
+func TestEdgeTrust(t *testing.T) {
+	log := zerolog.Nop()
+	ca, otherCA := newTestEdgeCA(t), newTestEdgeCA(t)
+	serverCert := ca.serverCert(t)
+	dir := t.TempDir()
+	bundlePath, pinsPath := filepath.Join(dir, "bundle.pem"), filepath.Join(dir, "pins")
+	writeFile(t, bundlePath, ca.pem)
+	writeFile(t, pinsPath, []byte("# edge CA\n"+ca.pin+"\n"))
+
+	trust, err := NewEdgeTrust(bundlePath, pinsPath, &log)
+	require.NoError(t, err)
+	clientConfig := &tls.Config{ServerName: testEdgeServerName, RootCAs: x509.NewCertPool()}
+	trust.Apply(clientConfig)
+	assert.NoError(t, handshake(t, clientConfig, serverCert))
+
+	// The pins are reloaded
+	writeFile(t, pinsPath, []byte("sha256/"+otherCA.pin))
+	require.NoError(t, trust.Load())
+	assert.ErrorContains(t, handshake(t, clientConfig, serverCert), "none of the public keys")
+
+	// An invalid bundle keeps the previous trust
+	writeFile(t, pinsPath, []byte(ca.pin))
+	writeFile(t, bundlePath, []byte("not a certificate"))
+	assert.Error(t, trust.Load())
+	assert.ErrorContains(t, handshake(t, clientConfig, serverCert), "none of the public keys")
+
+	// The bundle is reloaded
+	writeFile(t, bundlePath, otherCA.pem)
+	require.NoError(t, trust.Load())
+	assert.ErrorContains(t, handshake(t, clientConfig, serverCert), "isn't signed by the CAs")
+}
+
+func TestEdgeTrustPinsOnly(t *testing.T) {
+	log := zerolog.Nop()
+	ca, otherCA := newTestEdgeCA(t), newTestEdgeCA(t)
+	serverCert := ca.serverCert(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	pinsPath := filepath.Join(t.TempDir(), "pins")
+
+	writeFile(t, pinsPath, []byte(ca.pin))
+	trust, err := NewEdgeTrust("", pinsPath, &log)
+	require.NoError(t, err)
+	clientConfig := &tls.Config{ServerName: testEdgeServerName, RootCAs: roots}
+	trust.Apply(clientConfig)
+	assert.False(t, clientConfig.InsecureSkipVerify)
+	assert.NoError(t, handshake(t, clientConfig, serverCert))
+
+	writeFile(t, pinsPath, []byte(otherCA.pin))
+	require.NoError(t, trust.Load())
+	assert.Error(t, handshake(t, clientConfig, serverCert))
+}
+
+func TestParseSPKIPins(t *testing.T) {
+	hash := sha256.Sum256([]byte("key"))
+	pin := base64.StdEncoding.EncodeToString(hash[:])
+
+	pins, err := ParseSPKIPins([]byte("\n# comment\nsha256/" + pin + "\n " + pin + " \n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[[sha256.Size]byte]struct{}{hash: {}}, pins)
+
+	for _, invalid := range []string{"", "# comment only", "not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		_, err := ParseSPKIPins([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestNewEdgeTrustInvalid(t *testing.T) {
+	log := zerolog.Nop()
+	dir := t.TempDir()
+	_, err := NewEdgeTrust(filepath.Join(dir, "missing.pem"), "", &log)
+	assert.Error(t, err)
+
+	emptyPath := filepath.Join(dir, "empty.pem")
+	writeFile(t, emptyPath, nil)
+	_, err = NewEdgeTrust(emptyPath, "", &log)
+	assert.Error(t, err)
+	_, err = NewEdgeTrust("", emptyPath, &log)
+	assert.Error(t, err)
+}
@@ -0,0 +1,151 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/propagation"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const defaultExportTimeout = 10 * time.Second
+
+// ExportConfig configures the export of the spans of cloudflared to an OTLP collector, in addition to the spans
+// returned to the edge for the requests it traces.
+type ExportConfig struct {
+	// Endpoint is the URL spans are posted to with OTLP over HTTP, e.g. http://localhost:4318/v1/traces.
+	Endpoint string
+	// Headers are added to the export requests, e.g. for authentication.
+	Headers map[string]string
+	// SampleRatio is the ratio of the requests without a sampled parent span that are traced.
+	SampleRatio float64
+	Timeout     time.Duration
+}
+
+var (
+	// exportProcessor and exportSampler are set once the export is started. Spans of every traced request go
+	// through the same batch processor.
+	exportProcessor tracesdk.SpanProcessor
+	exportSampler   tracesdk.Sampler
+
+	// w3cPropagator propagates the traceparent of the requests, to the origins as well.
+	w3cPropagator = propagation.TraceContext{}
+)
+
+// StartExport exports the spans of the requests to the OTLP collector at cfg.Endpoint, until the returned function is
+// called to flush the remaining spans. It must be called before any request is proxied.
+func StartExport(ctx context.Context, cfg ExportConfig, log *zerolog.Logger) (func(context.Context) error, error) {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultExportTimeout
+	}
+	exporter, err := otlptrace.New(ctx, &otlpHTTPClient{
+		endpoint: cfg.Endpoint,
+		headers:  cfg.Headers,
+		client:   &http.Client{Timeout: timeout},
+	})
+	if err != nil {
+		return nil, err
+	}
+	processor := tracesdk.NewBatchSpanProcessor(exporter)
+	exportProcessor = sharedSpanProcessor{processor}
+	exportSampler = tracesdk.ParentBased(tracesdk.TraceIDRatioBased(cfg.SampleRatio))
+	log.Info().Str("endpoint", cfg.Endpoint).Float64("sampleRatio", cfg.SampleRatio).Msg("Exporting traces to OTLP collector")
+	return processor.Shutdown, nil
+}
+
+// Exporting returns whether the spans are exported to an OTLP collector.
+func Exporting() bool {
+	return exportProcessor != nil
+}
+
+// InjectTraceparent sets the traceparent header of an origin request to the span of ctx, so that the origin can
+// continue the trace. Nothing is done unless spans are exported.
+func InjectTraceparent(ctx context.Context, header http.Header) {
+	if !Exporting() || !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+	w3cPropagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// StartRequestSpan starts the span of a request proxied by cloudflared, the parent of the spans of the proxy and the
+// origin. It's only recorded for the requests whose spans are exported but not returned to the edge, since the edge
+// gets the spans of the requests it traces before this span ends.
+func (tr *TracedHTTPRequest) StartRequestSpan(transport trace.SpanStartOption) trace.Span {
+	if _, exportOnly := tr.exporter.(*NoopOtlpClient); !Exporting() || !exportOnly {
+		return NewNoopSpan()
+	}
+	ctx, span := tr.Tracer().Start(tr.Context(), "cloudflared_request", transport, trace.WithSpanKind(trace.SpanKindServer))
+	tr.Request = tr.Request.WithContext(ctx)
+	return span
+}
+
+// newExportTracer returns a tracer for the requests that aren't traced by the edge. Their spans are only exported.
+func newExportTracer(log *zerolog.Logger) *cfdTracer {
+	tp := tracesdk.NewTracerProvider(
+		tracesdk.WithSampler(exportSampler),
+		tracesdk.WithSpanProcessor(exportProcessor),
+		tracesdk.WithResource(newResource()),
+	)
+	return &cfdTracer{tp, &NoopOtlpClient{}, log}
+}
+
+// sharedSpanProcessor is added to the tracer provider of every request, which mustn't shut down the batch processor
+// they share.
+type sharedSpanProcessor struct {
+	tracesdk.SpanProcessor
+}
+
+func (sharedSpanProcessor) Shutdown(context.Context) error   { return nil }
+func (sharedSpanProcessor) ForceFlush(context.Context) error { return nil }
+
+// otlpHTTPClient uploads spans with the binary protobuf encoding of OTLP over HTTP.
+type otlpHTTPClient struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+}
+
+func (c *otlpHTTPClient) Start(context.Context) error {
+	return nil
+}
+
+func (c *otlpHTTPClient) Stop(context.Context) error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+func (c *otlpHTTPClient) UploadTraces(ctx context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	body, err := proto.Marshal(&coltracepb.ExportTraceServiceRequest{ResourceSpans: protoSpans})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("OTLP collector answered with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// startTestExport exports spans to a test collector, returning the function that flushes them and returns the spans
+// it received.
+func startTestExport(t *testing.T, sampleRatio float64) func() []*tracepb.Span {
+	var lock sync.Mutex
+	var spans []*tracepb.Span
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		var request coltracepb.ExportTraceServiceRequest
+		require.NoError(t, proto.Unmarshal(body, &request))
+		lock.Lock()
+		defer lock.Unlock()
+		for _, resourceSpans := range request.ResourceSpans {
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				spans = append(spans, scopeSpans.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(collector.Close)
+
+	log := zerolog.Nop()
+	stop, err := StartExport(context.Background(), ExportConfig{
+		Endpoint:    collector.URL,
+		Headers:     map[string]string{"Authorization": "secret"},
+		SampleRatio: sampleRatio,
+	}, &log)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		exportProcessor = nil
+		exportSampler = nil
+	})
+	return func() []*tracepb.Span {
+		require.NoError(t, stop(context.Background()))
+		lock.Lock()
+		defer lock.Unlock()
+		return spans
+	}
+}
+
+func TestExportContinuesTraceparent(t *testing.T) {
+	flush := startTestExport(t, 0)
+	log := zerolog.Nop()
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Set("traceparent", testTraceparent)
+	tr := NewTracedHTTPRequest(req, 0, &log)
+	requestSpan := tr.StartRequestSpan(QuicTransportAttribute)
+	originCtx, originSpan := tr.Tracer().Start(tr.Context(), "ttfb_origin")
+	originHeaders := http.Header{}
+	InjectTraceparent(originCtx, originHeaders)
+	End(originSpan)
+	End(requestSpan)
+
+	// The origin continues the trace from the span of cloudflared
+	assert.Contains(t, originHeaders.Get("traceparent"), "4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.NotEqual(t, testTraceparent, originHeaders.Get("traceparent"))
+
+	spans := flush()
+	require.Len(t, spans, 2)
+	names := make(map[string]*tracepb.Span)
+	for _, span := range spans {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(span.TraceId))
+		names[span.Name] = span
+	}
+	require.Contains(t, names, "cloudflared_request")
+	require.Contains(t, names, "ttfb_origin")
+	assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(names["cloudflared_request"].ParentSpanId))
+	assert.Equal(t, names["cloudflared_request"].SpanId, names["ttfb_origin"].ParentSpanId)
+}
+
+func TestExportSampling(t *testing.T) {
+	flush := startTestExport(t, 0)
+	log := zerolog.Nop()
+
+	// Requests without a sampled parent are sampled by ratio
+	tr := NewTracedHTTPRequest(httptest.NewRequest(http.MethodGet, "http://localhost", nil), 0, &log)
+	End(tr.StartRequestSpan(Http2TransportAttribute))
+	assert.Empty(t, flush())
+}
+
+func TestExportTracedByEdge(t *testing.T) {
+	flush := startTestExport(t, 0)
+	log := zerolog.Nop()
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost", nil)
+	req.Header.Add(TracerContextName, "14cb070dde8e51fc5ae8514e69ba42ca:b38f1bf5eae406f3:0:1")
+	tr := NewTracedHTTPRequest(req, 0, &log)
+	// The edge gets the spans of the requests it traces before the request span would end
+	assert.False(t, tr.StartRequestSpan(QuicTransportAttribute).IsRecording())
+	_, span := tr.Tracer().Start(tr.Context(), "ttfb_origin")
+	End(span)
+
+	assert.NotEmpty(t, tr.GetSpans())
+	spans := flush()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "ttfb_origin", spans[0].Name)
+}
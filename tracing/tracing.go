@@ -80,6 +80,10 @@ type TracedHTTPRequest struct {
 func NewTracedHTTPRequest(req *http.Request, connIndex uint8, log *zerolog.Logger) *TracedHTTPRequest {
 	ctx, exists := extractTrace(req)
 	if !exists {
+		if Exporting() {
+			ctx := w3cPropagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+			return &TracedHTTPRequest{req.WithContext(ctx), newExportTracer(log), connIndex}
+		}
 		return &TracedHTTPRequest{req, &cfdTracer{trace.NewNoopTracerProvider(), &NoopOtlpClient{}, log}, connIndex}
 	}
 	return &TracedHTTPRequest{req.WithContext(ctx), newCfdTracer(ctx, log), connIndex}
@@ -116,24 +120,32 @@ func newCfdTracer(ctx context.Context, log *zerolog.Logger) *cfdTracer {
 	if err != nil {
 		return &cfdTracer{trace.NewNoopTracerProvider(), &NoopOtlpClient{}, log}
 	}
-	tp := tracesdk.NewTracerProvider(
+	options := []tracesdk.TracerProviderOption{
 		// We want to dump to in-memory exporter immediately
 		tracesdk.WithSyncer(exp),
 		// Record information about this application in a Resource.
-		tracesdk.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			serviceAttribute,
-			otelVersionAttribute,
-			hostnameAttribute,
-			cloudflaredVersionAttribute,
-			HostOSAttribute,
-			HostArchAttribute,
-		)),
-	)
+		tracesdk.WithResource(newResource()),
+	}
+	if Exporting() {
+		options = append(options, tracesdk.WithSpanProcessor(exportProcessor))
+	}
+	tp := tracesdk.NewTracerProvider(options...)
 
 	return &cfdTracer{tp, mc, log}
 }
 
+func newResource() *resource.Resource {
+	return resource.NewWithAttributes(
+		semconv.SchemaURL,
+		serviceAttribute,
+		otelVersionAttribute,
+		hostnameAttribute,
+		cloudflaredVersionAttribute,
+		HostOSAttribute,
+		HostArchAttribute,
+	)
+}
+
 func (cft *cfdTracer) Tracer() trace.Tracer {
 	return cft.TracerProvider.Tracer(tracerInstrumentName)
 }
@@ -227,6 +227,21 @@ func (c RegistrationServer_PogsClient) UnregisterConnection(ctx context.Context)
 	return nil
 }
 
+// Ping makes a round trip to the edge with a getServerInfo call, which has no side effect. An edge that doesn't
+// implement the call still answers it, with an exception, so only the errors of the round trip itself are returned.
+func (c RegistrationServer_PogsClient) Ping(ctx context.Context) error {
+	client := proto.TunnelServer{Client: c.Client}
+	promise := client.GetServerInfo(ctx, func(p proto.TunnelServer_getServerInfo_Params) error {
+		return nil
+	})
+	_, err := promise.Struct()
+	var exception rpc.Exception
+	if err != nil && !errors.As(err, &exception) {
+		return wrapRPCError(err)
+	}
+	return nil
+}
+
 type ClientInfo struct {
 	ClientID []byte `capnp:"clientId"` // must be a slice for capnp compatibility
 	Features []string
@@ -27,6 +27,12 @@ type RegistrationClient interface {
 	Close()
 }
 
+// Heartbeater is implemented by the registration clients that can check the edge still answers on the stream.
+type Heartbeater interface {
+	// Heartbeat makes a round trip to the edge, returning an error if it didn't complete.
+	Heartbeat(ctx context.Context) error
+}
+
 type registrationClient struct {
 	client         pogs.RegistrationServer_PogsClient
 	transport      rpc.Transport
@@ -79,6 +85,10 @@ func (r *registrationClient) SendLocalConfiguration(ctx context.Context, config
 	return err
 }
 
+func (r *registrationClient) Heartbeat(ctx context.Context) error {
+	return r.client.Ping(ctx)
+}
+
 func (r *registrationClient) GracefulShutdown(ctx context.Context, gracePeriod time.Duration) error {
 	ctx, cancel := context.WithTimeout(ctx, gracePeriod)
 	defer cancel()
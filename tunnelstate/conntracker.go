@@ -3,6 +3,7 @@ package tunnelstate
 import (
 	"net"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 
@@ -13,7 +14,9 @@ type ConnTracker struct {
 	mutex sync.RWMutex
 	// int is the connection Index
 	connectionInfo map[uint8]ConnectionInfo
-	log            *zerolog.Logger
+	// lastConnected is when a connection was last registered, or when the tracker was created
+	lastConnected time.Time
+	log           *zerolog.Logger
 }
 
 type ConnectionInfo struct {
@@ -33,6 +36,7 @@ func NewConnTracker(
 ) *ConnTracker {
 	return &ConnTracker{
 		connectionInfo: make(map[uint8]ConnectionInfo, 0),
+		lastConnected:  time.Now(),
 		log:            log,
 	}
 }
@@ -47,6 +51,7 @@ func (ct *ConnTracker) OnTunnelEvent(c connection.Event) {
 			EdgeAddress: c.EdgeAddress,
 		}
 		ct.connectionInfo[c.Index] = ci
+		ct.lastConnected = time.Now()
 		ct.mutex.Unlock()
 	case connection.Disconnected, connection.Reconnecting, connection.RegisteringTunnel, connection.Unregistering:
 		ct.mutex.Lock()
@@ -54,6 +59,8 @@ func (ct *ConnTracker) OnTunnelEvent(c connection.Event) {
 		ci.IsConnected = false
 		ct.connectionInfo[c.Index] = ci
 		ct.mutex.Unlock()
+	case connection.ProtocolFallback:
+		// The connection is already tracked as disconnected while it reconnects
 	default:
 		ct.log.Error().Msgf("Unknown connection event case %v", c)
 	}
@@ -71,6 +78,14 @@ func (ct *ConnTracker) CountActiveConns() uint {
 	return active
 }
 
+// LastConnected returns when a connection to the edge was last registered, or when the tracker was created if none
+// was registered yet.
+func (ct *ConnTracker) LastConnected() time.Time {
+	ct.mutex.RLock()
+	defer ct.mutex.RUnlock()
+	return ct.lastConnected
+}
+
 // HasConnectedWith checks if we've ever had a successful connection to the edge
 // with said protocol.
 func (ct *ConnTracker) HasConnectedWith(protocol connection.Protocol) bool {
@@ -0,0 +1,228 @@
+package tunnelstate
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+const (
+	// StateFileMaxAge is how long the state of a stopped cloudflared is used when it restarts
+	StateFileMaxAge = 24 * time.Hour
+	// maxSessionTickets bounds the TLS sessions kept, one per edge server name
+	maxSessionTickets = 16
+)
+
+// StateFile persists the registration state of the connections to the edge across restarts: the edge address and
+// protocol they registered with, and the TLS sessions of the edge. A restarting cloudflared reconnects to the same
+// edge addresses with the protocol that worked, resuming the TLS sessions.
+type StateFile struct {
+	path string
+	log  *zerolog.Logger
+
+	lock  sync.Mutex
+	state persistedState
+}
+
+type persistedState struct {
+	TunnelID       uuid.UUID                         `json:"tunnelID"`
+	SavedAt        time.Time                         `json:"savedAt"`
+	Connections    map[string]persistedConnection    `json:"connections"`
+	SessionTickets map[string]persistedSessionTicket `json:"sessionTickets,omitempty"`
+}
+
+type persistedConnection struct {
+	Protocol    string    `json:"protocol"`
+	EdgeAddress net.IP    `json:"edgeAddress"`
+	Location    string    `json:"location,omitempty"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+type persistedSessionTicket struct {
+	Ticket []byte `json:"ticket"`
+	State  []byte `json:"state"`
+}
+
+// NewStateFile loads the state persisted at path for the tunnel, ignoring it if it's for another tunnel or older
+// than maxAge. It fails if the state can't be persisted at path.
+func NewStateFile(path string, tunnelID uuid.UUID, maxAge time.Duration, log *zerolog.Logger) (*StateFile, error) {
+	f := &StateFile{
+		path:  path,
+		log:   log,
+		state: newPersistedState(tunnelID),
+	}
+	content, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, errors.Wrapf(err, "cannot read the state file %s", path)
+	default:
+		var state persistedState
+		if err := json.Unmarshal(content, &state); err != nil {
+			log.Warn().Err(err).Msgf("Ignoring the invalid state file %s", path)
+		} else if state.TunnelID != tunnelID {
+			log.Info().Msgf("Ignoring the state file %s of tunnel %s", path, state.TunnelID)
+		} else if time.Since(state.SavedAt) > maxAge {
+			log.Info().Msgf("Ignoring the state file %s saved at %s", path, state.SavedAt)
+		} else {
+			if state.Connections == nil {
+				state.Connections = make(map[string]persistedConnection)
+			}
+			if state.SessionTickets == nil {
+				state.SessionTickets = make(map[string]persistedSessionTicket)
+			}
+			f.state = state
+			log.Info().Msgf("Loaded the state of %d connections from %s", len(state.Connections), path)
+		}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.save(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func newPersistedState(tunnelID uuid.UUID) persistedState {
+	return persistedState{
+		TunnelID:       tunnelID,
+		Connections:    make(map[string]persistedConnection),
+		SessionTickets: make(map[string]persistedSessionTicket),
+	}
+}
+
+// OnTunnelEvent implements connection.EventSink, persisting the edge address and protocol of the connections when
+// they register.
+func (f *StateFile) OnTunnelEvent(event connection.Event) {
+	if event.EventType != connection.Connected {
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.state.Connections[strconv.Itoa(int(event.Index))] = persistedConnection{
+		Protocol:    event.Protocol.String(),
+		EdgeAddress: event.EdgeAddress,
+		Location:    event.Location,
+		ConnectedAt: time.Now(),
+	}
+	if err := f.save(); err != nil {
+		f.log.Err(err).Msg("Cannot persist the state of the connections")
+	}
+}
+
+// EdgeAddress returns the IP of the edge the connection last registered with, or nil.
+func (f *StateFile) EdgeAddress(connIndex uint8) net.IP {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.state.Connections[strconv.Itoa(int(connIndex))].EdgeAddress
+}
+
+// Protocol returns the protocol a connection last registered with.
+func (f *StateFile) Protocol() (connection.Protocol, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var last persistedConnection
+	for _, conn := range f.state.Connections {
+		if conn.ConnectedAt.After(last.ConnectedAt) {
+			last = conn
+		}
+	}
+	for _, protocol := range connection.ProtocolList {
+		if protocol.String() == last.Protocol {
+			return protocol, true
+		}
+	}
+	return 0, false
+}
+
+// SessionCache returns a TLS session cache persisting the sessions in the state file.
+func (f *StateFile) SessionCache() tls.ClientSessionCache {
+	return (*persistentSessionCache)(f)
+}
+
+// save writes the state to a temporary file renamed over the state file, so that the state file is never partially
+// written. The sessions are secret, so only the user can read it.
+func (f *StateFile) save() error {
+	f.state.SavedAt = time.Now()
+	content, err := json.Marshal(f.state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "cannot write the state file")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "cannot write the state file")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "cannot write the state file")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), f.path), "cannot write the state file")
+}
+
+// persistentSessionCache is a tls.ClientSessionCache backed by the state file.
+type persistentSessionCache StateFile
+
+func (c *persistentSessionCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	f := (*StateFile)(c)
+	f.lock.Lock()
+	ticket, ok := f.state.SessionTickets[sessionKey]
+	f.lock.Unlock()
+	if !ok {
+		return nil, false
+	}
+	state, err := tls.ParseSessionState(ticket.State)
+	if err != nil {
+		return nil, false
+	}
+	session, err := tls.NewResumptionState(ticket.Ticket, state)
+	if err != nil {
+		return nil, false
+	}
+	return session, true
+}
+
+func (c *persistentSessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	f := (*StateFile)(c)
+	var ticket persistedSessionTicket
+	if cs != nil {
+		resumptionTicket, state, err := cs.ResumptionState()
+		if err != nil || state == nil {
+			return
+		}
+		stateBytes, err := state.Bytes()
+		if err != nil {
+			return
+		}
+		ticket = persistedSessionTicket{Ticket: resumptionTicket, State: stateBytes}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if cs == nil {
+		delete(f.state.SessionTickets, sessionKey)
+	} else {
+		if _, ok := f.state.SessionTickets[sessionKey]; !ok && len(f.state.SessionTickets) >= maxSessionTickets {
+			return
+		}
+		f.state.SessionTickets[sessionKey] = ticket
+	}
+	if err := f.save(); err != nil {
+		f.log.Err(err).Msg("Cannot persist the TLS sessions of the edge")
+	}
+}
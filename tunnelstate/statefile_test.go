@@ -0,0 +1,141 @@
+package tunnelstate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+func TestStateFile(t *testing.T) {
+	log := zerolog.Nop()
+	path := filepath.Join(t.TempDir(), "state.json")
+	tunnelID := uuid.New()
+
+	stateFile, err := NewStateFile(path, tunnelID, StateFileMaxAge, &log)
+	require.NoError(t, err)
+	_, ok := stateFile.Protocol()
+	assert.False(t, ok)
+	assert.Nil(t, stateFile.EdgeAddress(0))
+
+	stateFile.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected, Protocol: connection.QUIC, EdgeAddress: net.ParseIP("198.41.200.1")})
+	stateFile.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Connected, Protocol: connection.HTTP2, EdgeAddress: net.ParseIP("198.41.192.7")})
+	stateFile.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Disconnected})
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// The state is loaded when restarting
+	restarted, err := NewStateFile(path, tunnelID, StateFileMaxAge, &log)
+	require.NoError(t, err)
+	assert.Equal(t, net.ParseIP("198.41.200.1"), restarted.EdgeAddress(0))
+	assert.Equal(t, net.ParseIP("198.41.192.7"), restarted.EdgeAddress(1))
+	protocol, ok := restarted.Protocol()
+	assert.True(t, ok)
+	assert.Equal(t, connection.HTTP2, protocol)
+
+	// The state is ignored for another tunnel, or once too old
+	other, err := NewStateFile(path, uuid.New(), StateFileMaxAge, &log)
+	require.NoError(t, err)
+	assert.Nil(t, other.EdgeAddress(0))
+	_, err = NewStateFile(path, tunnelID, StateFileMaxAge, &log)
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+	expired, err := NewStateFile(path, tunnelID, time.Nanosecond, &log)
+	require.NoError(t, err)
+	assert.Nil(t, expired.EdgeAddress(0))
+}
+
+func TestStateFileInvalid(t *testing.T) {
+	log := zerolog.Nop()
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "state.json")
+	require.NoError(t, os.WriteFile(path, []byte("{invalid"), 0o600))
+	stateFile, err := NewStateFile(path, uuid.New(), StateFileMaxAge, &log)
+	require.NoError(t, err)
+	assert.Nil(t, stateFile.EdgeAddress(0))
+
+	_, err = NewStateFile(filepath.Join(dir, "missing", "state.json"), uuid.New(), StateFileMaxAge, &log)
+	assert.Error(t, err)
+}
+
+func TestStateFileSessionCache(t *testing.T) {
+	log := zerolog.Nop()
+	path := filepath.Join(t.TempDir(), "state.json")
+	tunnelID := uuid.New()
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{testCertificate(t)},
+	}
+
+	stateFile, err := NewStateFile(path, tunnelID, StateFileMaxAge, &log)
+	require.NoError(t, err)
+	clientConfig := &tls.Config{InsecureSkipVerify: true, ClientSessionCache: stateFile.SessionCache()}
+	assert.False(t, tlsHandshake(t, clientConfig, serverConfig).DidResume)
+	// TLS 1.3 tickets are read after the handshake
+	require.Eventually(t, func() bool {
+		_, ok := stateFile.SessionCache().Get("127.0.0.1")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	restarted, err := NewStateFile(path, tunnelID, StateFileMaxAge, &log)
+	require.NoError(t, err)
+	clientConfig = &tls.Config{InsecureSkipVerify: true, ClientSessionCache: restarted.SessionCache()}
+	assert.True(t, tlsHandshake(t, clientConfig, serverConfig).DidResume)
+
+	restarted.SessionCache().Put("127.0.0.1", nil)
+	_, ok := restarted.SessionCache().Get("127.0.0.1")
+	assert.False(t, ok)
+}
+
+func tlsHandshake(t *testing.T, clientConfig, serverConfig *tls.Config) tls.ConnectionState {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.(*tls.Conn).Handshake()
+		// Wait for the client to read the session ticket
+		_, _ = conn.Read(make([]byte, 1))
+	}()
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+	require.NoError(t, err)
+	defer conn.Close()
+	// Reading processes the session ticket sent after the handshake
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _ = conn.Read(make([]byte, 1))
+	return conn.ConnectionState()
+}
+
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
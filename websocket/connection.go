@@ -1,7 +1,6 @@
 package websocket
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -31,29 +30,32 @@ type PingPeriodContext string
 // This is still used by access carrier
 type GorillaConn struct {
 	*websocket.Conn
-	log     *zerolog.Logger
-	readBuf bytes.Buffer
+	log *zerolog.Logger
+	// message is the reader of the message being read, read straight into the buffers of the callers
+	message io.Reader
 }
 
 // Read will read messages from the websocket connection
 func (c *GorillaConn) Read(p []byte) (int, error) {
-	// Intermediate buffer may contain unread bytes from the last read, start there before blocking on a new frame
-	if c.readBuf.Len() > 0 {
-		return c.readBuf.Read(p)
-	}
-
-	_, message, err := c.Conn.ReadMessage()
-	if err != nil {
-		return 0, err
+	for {
+		// The message being read may still have unread bytes, start there before blocking on a new frame
+		if c.message == nil {
+			_, message, err := c.Conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			c.message = message
+		}
+		n, err := c.message.Read(p)
+		if err == io.EOF {
+			c.message = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
 	}
-
-	copied := copy(p, message)
-
-	// Write unread bytes to readBuf; if everything was read this is a no-op
-	// Write returns a nil error always and grows the buffer; everything is always written or panic
-	c.readBuf.Write(message[copied:])
-
-	return copied, nil
 }
 
 // Write will write messages to the websocket connection